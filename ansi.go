@@ -0,0 +1,185 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+)
+
+/*ANSIStripper parser states*/
+const (
+	ansiData = iota
+	ansiEsc
+	ansiEscInter
+	ansiCSI
+	ansiString
+	ansiStringEsc
+)
+
+/*maxANSISeq bounds how much of a sequence is retained for the replacement func*/
+const maxANSISeq = 256
+
+/*
+ANSIStripper is a Transform that removes ANSI / VT100 escape sequences (colors,
+cursor movement, window titles and the like) from incoming data, so that the
+output of menu-driven device consoles can be matched by an Arbiter.  It
+recognizes CSI sequences (ESC [ ... final), string sequences (OSC, DCS, etc.,
+ending in BEL or ESC \), and other 2 or 3 byte escapes.  Outgoing data is
+untouched.
+
+If replace is not nil, each sequence removed is replaced with whatever replace
+returns for it (see ANSIMarker).  Sequences longer than 256 bytes are
+truncated before being handed to replace.
+*/
+type ANSIStripper struct {
+	replace func(seq []byte) []byte
+	state   int
+	seq     []byte
+}
+
+/*NewANSIStripper returns an ANSIStripper, optionally replacing sequences with replace*/
+func NewANSIStripper(replace func(seq []byte) []byte) *ANSIStripper {
+	return &ANSIStripper{replace: replace}
+}
+
+/*NewANSIStrippedIO returns a Transformer stripping ANSI sequences read from idoio*/
+func NewANSIStrippedIO(idoio IDoIO, replace func(seq []byte) []byte) *Transformer {
+	return NewTransformer(idoio, NewANSIStripper(replace))
+}
+
+/*ANSIMarker returns a replacement func (see ANSIStripper) that replaces every sequence with marker*/
+func ANSIMarker(marker string) func([]byte) []byte {
+	return func([]byte) []byte { return []byte(marker) }
+}
+
+/*
+WithANSIStripping strips ANSI sequences, replacing each with marker, and is
+described as "ansi" or "ansi(marker=...)"
+*/
+func WithANSIStripping(marker string) Layer {
+	params := map[string]string{}
+	var replace func([]byte) []byte
+	if marker != "" {
+		params["marker"], replace = marker, ANSIMarker(marker)
+	}
+	return NewLayer("ansi", RankTransform, params, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewANSIStrippedIO(inner, replace), nil
+	})
+}
+
+func parseANSILayer(params map[string]string) (Layer, error) {
+	return WithANSIStripping(params["marker"]), nil
+}
+
+/*Encode conforms to Transform, passing b through*/
+func (as *ANSIStripper) Encode(b []byte) ([]byte, error) { return b, nil }
+
+/*Decode conforms to Transform, removing escape sequences from b*/
+func (as *ANSIStripper) Decode(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if as.state != ansiData && len(as.seq) < maxANSISeq {
+			as.seq = append(as.seq, c)
+		}
+		switch as.state {
+		case ansiData:
+			if c == 0x1b {
+				as.state, as.seq = ansiEsc, []byte{c}
+				continue
+			}
+			out = append(out, c)
+		case ansiEsc:
+			switch {
+			case c == '[':
+				as.state = ansiCSI
+			case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+				as.state = ansiString
+			case c >= 0x20 && c <= 0x2f:
+				as.state = ansiEscInter
+			case c >= 0x30 && c <= 0x7e:
+				out = as.end(out)
+			default:
+				out = as.abort(out, c)
+			}
+		case ansiEscInter:
+			switch {
+			case c >= 0x20 && c <= 0x2f:
+			case c >= 0x30 && c <= 0x7e:
+				out = as.end(out)
+			default:
+				out = as.abort(out, c)
+			}
+		case ansiCSI:
+			switch {
+			case c >= 0x20 && c <= 0x3f:
+			case c >= 0x40 && c <= 0x7e:
+				out = as.end(out)
+			default:
+				out = as.abort(out, c)
+			}
+		case ansiString:
+			switch c {
+			case 0x07:
+				out = as.end(out)
+			case 0x1b:
+				as.state = ansiStringEsc
+			}
+		case ansiStringEsc:
+			as.state = ansiString
+			if c == '\\' {
+				out = as.end(out)
+			}
+		}
+	}
+	return out, nil
+}
+
+/*end finishes the current sequence, appending its replacement to out*/
+func (as *ANSIStripper) end(out []byte) []byte {
+	if as.replace != nil {
+		out = append(out, as.replace(as.seq)...)
+	}
+	as.state, as.seq = ansiData, nil
+	return out
+}
+
+/*
+abort abandons a malformed sequence interrupted by c, which is treated as data
+(or as the start of a new sequence)
+*/
+func (as *ANSIStripper) abort(out []byte, c byte) []byte {
+	if n := len(as.seq); n > 0 && as.seq[n-1] == c {
+		as.seq = as.seq[:n-1]
+	}
+	out = as.end(out)
+	if c == 0x1b {
+		as.state, as.seq = ansiEsc, []byte{c}
+		return out
+	}
+	return append(out, c)
+}
+
+/*Reset abandons any partial sequence*/
+func (as *ANSIStripper) Reset() { as.state, as.seq = ansiData, nil }
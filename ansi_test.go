@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestANSIStripper(t *testing.T) {
+	screen := "\x1b[2J\x1b[1;1HMain Menu\r\n\x1b[1;31mAlarm\x1b[0m\x1b]0;title\x07 \x1b(B1) Status\x1b]2;t\x1b\\>\x1b[12\n"
+	for _, tc := range []struct {
+		replace  func([]byte) []byte
+		expected string
+	}{
+		{nil, "Main Menu\r\nAlarm 1) Status>\n"},
+		{ANSIMarker("~"), "~~Main Menu\r\n~Alarm~~ ~1) Status~>~\n"},
+		{func(seq []byte) []byte { return []byte(fmt.Sprintf("<%d>", len(seq))) }, "<4><6>Main Menu\r\n<7>Alarm<4><10> <3>1) Status<7>><4>\n"},
+	} {
+		//feed byte by byte to exercise the state carried between reads
+		as := NewANSIStripper(tc.replace)
+		got := []byte{}
+		for i := 0; i < len(screen); i++ {
+			out, _ := as.Decode([]byte{screen[i]})
+			got = append(got, out...)
+		}
+		if string(got) != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, got)
+		}
+	}
+
+	inner := &bufIO{}
+	tr := NewANSIStrippedIO(inner, nil)
+	inner.rx.WriteString("\x1b[7mOK\x1b[")
+	b := make([]byte, 16)
+	if n, err := tr.Read(b); err != nil || string(b[:n]) != "OK" {
+		t.Errorf("Unexpected read %q %v", b[:n], err)
+	}
+	tr.Write([]byte("\x1b[A"))
+	if inner.tx.String() != "\x1b[A" {
+		t.Error("Expected writes to pass through", inner.tx.String())
+	}
+	if s, err := ParseStack("tcp://localhost:1 | ansi(marker=~)"); err != nil || s.String() != "tcp://localhost:1 | ansi(marker=~)" {
+		t.Error("Expected the ansi layer to round trip", s, err)
+	}
+}
@@ -29,8 +29,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
-	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -92,6 +92,35 @@ type Arbiter interface {
 	Control(cmd Command, args ...interface{}) Response
 }
 
+/*
+ContextArbiter is implemented by Arbiters whose exchanges can each be given up
+on by the caller (a user abort, a shutdown, ...) without cancelling the
+context the Arbiter itself was built with.  Once ctx is done, whether while
+waiting for the Arbiter or for the response, the Response's Error is
+temporary, and a timeout if ctx's deadline passed.  Discover it with a type
+assertion.
+*/
+type ContextArbiter interface {
+	Arbiter
+	SimpleContext(ctx context.Context, cmd, ok, failure []byte, duration time.Duration) Response
+	ControlContext(ctx context.Context, cmd Command, args ...interface{}) Response
+}
+
+/*
+Expecter is implemented by Arbiters that can judge responses with a CheckFunc
+given by the caller (see Arb.Expect).  Discover it with a type assertion.
+*/
+type Expecter interface {
+	Expect(out []byte, timeout time.Duration, check CheckFunc) Response
+	ExpectContext(ctx context.Context, out []byte, timeout time.Duration, check CheckFunc) Response
+}
+
+/*cancelled is the error for an exchange given up on because the caller's ctx is done*/
+func cancelled(ctx context.Context) error {
+	timeout := ctx.Err() == context.DeadlineExceeded
+	return newErr(true, timeout, errors.Wrap(ctx.Err(), "Command cancelled by the caller"))
+}
+
 /*
 NewArbiter returns an opened Arbiter from the passed dial string, ctx, and timeout.
 dial will need to match a known dial format, timeout will be used during the connection
@@ -115,6 +144,14 @@ func Arbitrate(ctx context.Context, idoio IDoIO) (Arbiter, context.CancelFunc) {
 	return &Arb{ctx: arbctx, idotoo: idoio, cancel: cancelfunc}, cancelfunc
 }
 
+var (
+	_ ContextArbiter = &Arb{}
+	_ QueueReporter  = &Arb{}
+	_ Expecter       = &Arb{}
+	_ DialogRunner   = &Arb{}
+	_ Batcher        = &Arb{}
+)
+
 /*
 Arb is a wrapper over a IDoIO, but it locks the IDoIO under a mutex to
 serialize access.
@@ -122,8 +159,124 @@ serialize access.
 type Arb struct {
 	ctx    context.Context
 	cancel context.CancelFunc
-	mux    sync.Mutex //only one reader and writer: me
+	mux    laneMutex //only one reader and writer: me
 	idotoo IDoIO
+	log    Logger    //nil means LoggerFrom(ctx)
+	tapRx  io.Writer //nil means no tap
+	tapTx  io.Writer
+
+	unsolicited func(b []byte) //nil means discard stale input
+}
+
+/*
+SetLogger overrides the Logger carried by the context this Arb was created
+with (see WithLogger).
+*/
+func (a *Arb) SetLogger(l Logger) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.log = l
+}
+
+/*
+SetTap mirrors everything the Arb receives to rx, and everything it sends to
+tx, including data read and discarded when clearing stale input before an
+exchange.  Either may be nil to not tap that direction.  Errors writing to
+either are ignored; the writers should not block.
+*/
+func (a *Arb) SetTap(rx, tx io.Writer) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.tapRx, a.tapTx = rx, tx
+}
+
+/*
+SetUnsolicited has h called with whatever the Arb reads that no exchange
+consumes, such as alarms or status broadcasts the device sends between
+commands, rather than it being discarded.  That is whatever arrived since the
+last exchange, handed to h when the next one clears stale input (see Simple
+and Control), so h is called with the Arb held and must not use it.  b is h's
+to keep.  A nil h discards unsolicited data again.
+*/
+func (a *Arb) SetUnsolicited(h func(b []byte)) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.unsolicited = h
+}
+
+/*tapReader mirrors what is read from an IDoIO to a tap*/
+type tapReader struct {
+	r   io.Reader
+	tap io.Writer
+}
+
+func (t tapReader) Read(b []byte) (int, error) {
+	n, err := t.r.Read(b)
+	if n > 0 {
+		t.tap.Write(b[:n])
+	}
+	return n, err
+}
+
+/*ReadContext conforms to ContextReader, whether or not the IDoIO tapped does*/
+func (t tapReader) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, t.r, b)
+	if n > 0 {
+		t.tap.Write(b[:n])
+	}
+	return n, err
+}
+
+/*reader returns the IDoIO to read from, tapped if required. The caller must hold a.mux.*/
+func (a *Arb) reader() io.Reader {
+	if a.tapRx == nil {
+		return a.idotoo
+	}
+	return tapReader{r: a.idotoo, tap: a.tapRx}
+}
+
+/*write writes b, tapping what was written if required. The caller must hold a.mux.*/
+func (a *Arb) write(b []byte) (int, error) {
+	n, err := a.idotoo.Write(b)
+	if n > 0 && a.tapTx != nil {
+		a.tapTx.Write(b[:n])
+	}
+	return n, err
+}
+
+/*
+writeAll writes all of b as WriteAll does, giving up after timeout or once
+either ctx or the Arb's own context is done, tapping
+what was written if required. The caller must hold a.mux.
+*/
+func (a *Arb) writeAll(ctx context.Context, b []byte, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer context.AfterFunc(a.ctx, cancel)()
+	n, err := WriteAll(ctx, a.idotoo, b)
+	if n > 0 && a.tapTx != nil {
+		a.tapTx.Write(b[:n])
+	}
+	return n, err
+}
+
+func (a *Arb) dialString() string { return dialOf(a.idotoo) }
+
+func (a *Arb) logger() Logger {
+	if a.log != nil {
+		return a.log
+	}
+	return LoggerFrom(a.ctx)
+}
+
+/*logExchange logs the outcome of a Simple or Control exchange named name*/
+func (a *Arb) logExchange(name string, rsp Response) {
+	l := a.logger()
+	l.Debug("exchange complete", "event", EventCommand, "dial", dialOf(a.idotoo), "command", name,
+		"duration", rsp.Duration, "error", rsp.Error)
+	if rsp.Error != nil && rsp.Error != ErrErrorResponse && !IsTemporary(rsp.Error) {
+		l.Warn("exchange failed", "event", EventError, "dial", dialOf(a.idotoo), "command", name, "error", rsp.Error)
+	}
 }
 
 /*
@@ -151,7 +304,7 @@ linked / bonded
 */
 func (a *Arb) Close() error {
 	a.cancel()
-	a.mux.Lock()
+	a.mux.LockUrgent()
 	defer a.mux.Unlock()
 	return a.idotoo.Close()
 }
@@ -163,7 +316,7 @@ access is locked within a mutex, and the read and write channels are linked / bo
 func (a *Arb) Read(b []byte) (int, error) {
 	a.mux.Lock()
 	defer a.mux.Unlock()
-	return a.idotoo.Read(b)
+	return a.reader().Read(b)
 }
 
 /*
@@ -173,18 +326,80 @@ access is locked within a mutex, and the read and write channels are linked / bo
 func (a *Arb) Write(b []byte) (int, error) {
 	a.mux.Lock()
 	defer a.mux.Unlock()
-	return a.idotoo.Write(b)
+	return a.write(b)
+}
+
+/*
+Status conforms to StatusReporter, returning the Status of the underlying
+IDoIO, or StateUnknown if it does not keep track.  A closed Arbiter is
+StateClosed regardless.
+*/
+func (a *Arb) Status() ConnStatus {
+	var cs ConnStatus
+	if sr, ok := a.idotoo.(StatusReporter); ok {
+		cs = sr.Status()
+	} else {
+		cs.State = StateUnknown
+	}
+	if a.ctx.Err() != nil && cs.State != StateClosed {
+		cs.State, cs.OpenedAt = StateClosed, time.Time{}
+	}
+	return cs
+}
+
+/*IsOpen conforms to StatusReporter*/
+func (a *Arb) IsOpen() bool {
+	return a.Status().State == StateOpen
+}
+
+/*Queue conforms to QueueReporter*/
+func (a *Arb) Queue() QueueStatus {
+	return a.mux.status()
+}
+
+/*
+Redial conforms to Redialer, arbitrating a Redial of the underlying IDoIO,
+which must itself be a Redialer.  Any taps, and any handler of unsolicited
+data, are kept.
+*/
+func (a *Arb) Redial(ctx context.Context) (IDoIO, error) {
+	r, ok := a.idotoo.(Redialer)
+	if !ok {
+		return nil, newErr(false, false, fmt.Errorf("%v can not be redialed", a.idotoo))
+	}
+	idoio, err := r.Redial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	arb, _ := Arbitrate(ctx, idoio)
+	b := arb.(*Arb)
+	b.log, b.tapRx, b.tapTx, b.unsolicited = a.log, a.tapRx, a.tapTx, a.unsolicited
+	return b, nil
 }
 
-/*clearReadBuffer attempts to clear the internal read buffer*/
+/*
+clearReadBuffer attempts to clear the internal read buffer, with FlushInput
+if the IDoIO is a Flusher and nothing needs to see what is discarded.  What
+is read is handed to any handler set by SetUnsolicited.
+*/
 func (a *Arb) clearReadBuffer() {
+	if f, ok := a.idotoo.(Flusher); ok && a.tapRx == nil && a.unsolicited == nil && f.FlushInput() == nil {
+		return
+	}
 	//clear off any internal buffer
-	rdr := bufio.NewReader(a.idotoo)
+	var stale []byte
+	rdr := bufio.NewReader(a.reader())
 	for {
-		_, e := rdr.ReadByte()
+		c, e := rdr.ReadByte()
 		if e != nil {
 			break
 		}
+		if a.unsolicited != nil {
+			stale = append(stale, c)
+		}
+	}
+	if len(stale) > 0 {
+		a.unsolicited(stale)
 	}
 }
 
@@ -208,37 +423,75 @@ Access is serialized, and takes over control of the arbiter.  EG:
 	a, _ := agnoio.NewArbiter(...)
 	a.Simple(nil, nil, nil, 1 * time.Hour) //Blocks other a.* calls for an hour, sans connection faults
 */
-func (a *Arb) Simple(cmd, success, failure []byte, duration time.Duration) (rsp Response) {
-	a.mux.Lock()
+func (a *Arb) Simple(cmd, success, failure []byte, duration time.Duration) Response {
+	return a.SimpleContext(context.Background(), cmd, success, failure, duration)
+}
+
+/*SimpleContext is Simple, given up on once ctx is done (see ContextArbiter)*/
+func (a *Arb) SimpleContext(ctx context.Context, cmd, success, failure []byte, duration time.Duration) Response {
+	failed, succeeded := &tailContains{seq: failure}, &tailContains{seq: success}
+	cf := func(raw []byte) ExitCriteria {
+		if failed.Contains(raw) {
+			return Failure
+		}
+		if succeeded.Contains(raw) {
+			return Success
+		}
+		return Insufficient
+	}
+	limit := limitFor(Command{})
+	limit.trimmed = func(n int) { failed.discard(n); succeeded.discard(n) }
+	return a.expect(ctx, cmd, duration, cf, limit)
+}
+
+/*
+Expect conforms to Expecter.  It is Simple, but with the response judged by
+check, for protocols bytes.Contains and regexps cannot, such as those with
+checksums or length prefixes.  check is given everything received so far
+each time more arrives (less the oldest bytes, under a response limit with
+OverflowTrim, see SetDefaultResponseLimit).  If check is nil, Expect only
+times out.
+*/
+func (a *Arb) Expect(out []byte, timeout time.Duration, check CheckFunc) Response {
+	return a.ExpectContext(context.Background(), out, timeout, check)
+}
+
+/*ExpectContext is Expect, given up on once ctx is done (see ContextArbiter)*/
+func (a *Arb) ExpectContext(ctx context.Context, out []byte, timeout time.Duration, check CheckFunc) Response {
+	if check == nil {
+		check = func([]byte) ExitCriteria { return Insufficient }
+	}
+	return a.expect(ctx, out, timeout, check, limitFor(Command{}))
+}
+
+/*expect sends out and reads the response until check passes judgement on it, for Simple and Expect*/
+func (a *Arb) expect(ctx context.Context, out []byte, timeout time.Duration, check CheckFunc, limit responseLimit) (rsp Response) {
+	if a.mux.lockContext(ctx, PriorityRoutine) != nil {
+		return Response{Error: cancelled(ctx)}
+	}
 	defer a.mux.Unlock()
 
 	a.clearReadBuffer()
 	start := time.Now()
-	defer func() { rsp.Duration = time.Since(start) }()
+	defer func() {
+		rsp.Duration = time.Since(start)
+		a.logExchange(fmt.Sprintf("%q", out), rsp)
+	}()
 
-	//send off the bytes, barfing on any sort of write error
-	if n, werr := a.idotoo.Write(cmd); werr != nil || len(cmd) != n {
+	//send off the bytes, barfing on any write error that retrying won't fix
+	if _, werr := a.writeAll(ctx, out, timeout); werr != nil {
 		return Response{Error: werr}
 	}
+	sent := time.Now()
 
 	//creating data channel for communicating with reader
 	dataChan := make(chan status, 0)
 
-	cf := func(raw []byte) ExitCriteria {
-		if failure != nil && bytes.Contains(raw, failure) {
-			return Failure
-		}
-		if success != nil && bytes.Contains(raw, success) {
-			return Success
-		}
-		return Insufficient
-	}
-
 	// part of the contract of readUntil is that we must read from the passed channel.
 	// It will write the necessary data if the ctx collapses.
-	go a.readUntil(dataChan, duration, cf)
+	go a.readUntil(ctx, dataChan, timeout, check, limit)
 	d := <-dataChan
-	return Response{Error: d.err, Bytes: d.raw}
+	return Response{Error: d.err, Bytes: d.raw, Sent: sent, FirstByte: d.first, Matched: d.matched, Attempts: 1}
 }
 
 /*
@@ -254,20 +507,59 @@ timeout. If .Response is nil (not set), then the output is not compared for a
 positive response, and Command will only fail or timeout.  If both .Error and
 .Response are nil, this command will only time out. The response.Error will be
 the package ErrErrorResponse if the Error condition is matched
+
+Commands that fail may be re-issued, as set by Command.Retries, without giving
+up the Arbiter in between.
+
+Commands take the Arbiter in order of their Priority (Urgent ones ahead of
+any other callers waiting for it), taking turns with other callers of the same
+priority as described by laneMutex.
 */
-func (a *Arb) Control(cmd Command, args ...interface{}) (rsp Response) {
+func (a *Arb) Control(cmd Command, args ...interface{}) Response {
+	return a.ControlContext(context.Background(), cmd, args...)
+}
+
+/*ControlContext is Control, given up on once ctx is done (see ContextArbiter)*/
+func (a *Arb) ControlContext(ctx context.Context, cmd Command, args ...interface{}) Response {
 	//Any sort of formatting error gets kicked back immediately
 	rawBytes, err := cmd.Bytes(args...)
 	if err != nil {
 		return Response{Error: err}
 	}
 
-	a.mux.Lock()
+	if a.mux.lockContext(ctx, cmd.priority()) != nil {
+		return Response{Error: cancelled(ctx)}
+	}
 	defer a.mux.Unlock()
+	return a.controlRetrying(ctx, cmd, rawBytes)
+}
+
+/*
+controlRetrying is an exchange of rawBytes formed from cmd, re-issued as
+cmd.Retries says. The caller must hold a.mux.
+*/
+func (a *Arb) controlRetrying(ctx context.Context, cmd Command, rawBytes []byte) (rsp Response) {
+	for attempt := 1; ; attempt++ {
+		rsp = a.control(ctx, cmd, rawBytes)
+		rsp.Attempts = attempt
+		if attempt > cmd.Retries || !cmd.retries(rsp.Error) || ctx.Err() != nil {
+			return rsp
+		}
+		a.logger().Debug("retrying command", "event", EventRetry, "dial", dialOf(a.idotoo), "command", cmd.Name,
+			"attempt", attempt+1, "error", rsp.Error)
+		if !a.pause(ctx, cmd.backoff(attempt)) {
+			return rsp
+		}
+	}
+}
+
+/*control is a single exchange of rawBytes formed from cmd. The caller must hold a.mux.*/
+func (a *Arb) control(ctx context.Context, cmd Command, rawBytes []byte) (rsp Response) {
+	defer func() { a.logExchange(cmd.Name, rsp) }()
 
 	a.clearReadBuffer()
-	//send off the bytes, barfing on any sort of write error
-	if n, werr := a.idotoo.Write(rawBytes); werr != nil || len(rawBytes) != n {
+	//send off the bytes, barfing on any write error that retrying won't fix
+	if _, werr := a.writeAll(ctx, rawBytes, cmd.Timeout); werr != nil {
 		return Response{Error: werr}
 	}
 
@@ -277,70 +569,96 @@ func (a *Arb) Control(cmd Command, args ...interface{}) (rsp Response) {
 	//creating data channel for communicating with reader
 	dataChan := make(chan status, 0)
 
+	//only what may hold a new match is scanned, see tailMatcher
+	failed, succeeded := newTailMatcher(cmd.Error, cmd.Lookback), newTailMatcher(cmd.Response, cmd.Lookback)
 	cf := func(raw []byte) ExitCriteria {
-		if cmd.Error != nil && cmd.Error.Match(raw) { //check for error response
+		if failed.Match(raw) { //check for error response
 			return Failure
 		}
-		if cmd.Response != nil && cmd.Response.Match(raw) { //check for normal acceptable response
+		if succeeded.Match(raw) { //check for normal acceptable response
 			return Success
 		}
 		return Insufficient
 	}
+	limit := limitFor(cmd)
+	limit.trimmed = func(n int) { failed.discard(n); succeeded.discard(n) }
 
 	// part of the contract of readUntil is that we must read from the passed channel.
 	// It will write the necessary data if the ctx collapses.
-	go a.readUntil(dataChan, cmd.Timeout, cf)
+	go a.readUntil(ctx, dataChan, cmd.Timeout, cf, limit)
 	d := <-dataChan
-	return Response{Error: d.err, Bytes: d.raw}
+	return Response{Error: d.err, Bytes: d.raw, Sent: start, FirstByte: d.first, Matched: d.matched}
 }
 
 /*status is used to pass messages from readUntil back to callers.*/
 type status struct {
-	raw []byte
-	err error
+	raw     []byte
+	err     error
+	first   time.Time //when the first byte arrived
+	matched time.Time //when checkFunc returned Success or Failure
+}
+
+/*
+readPause is the least time between reads that return nothing, so transports
+that are not ContextReaders, and whose reads time out at once, do not spin
+*/
+const readPause = time.Millisecond
+
+/*
+readBatch is what readUntil's reader hands back: whatever arrived before a
+pause in the data, and any permanent error that ended the reading
+*/
+type readBatch struct {
+	raw   []byte
+	first time.Time //when the first byte of raw arrived
+	err   error
 }
 
 /*
-readUntil repeatedly reads data off the embedded io device until either a
-duration of timeout elapses, or checkFunc returns either Success or Failure.
-Caller should utilize a go-routine to issue this and should always read from
-the passed channel exactly one time, otherwise this will deadlock. This closes
-the channel on exit.
+readUntil waits for data off the embedded io device until either a duration of
+timeout elapses, ctx is done, or checkFunc returns either Success or Failure.  The reading
+itself is done by readBatches, and checkFunc is called whenever the data
+pauses, or when limit's worth has arrived without one.  Once more than
+limit's worth has been collected and checked, the exchange either fails with
+ErrResponseTooLarge or the oldest bytes are dropped, as limit's policy says.
+Caller should utilize a go-routine to issue this and should always
+read from the passed channel exactly one time, otherwise this will deadlock.
+This closes the channel on exit, once nothing is reading the device.
 */
-func (a *Arb) readUntil(dataChan chan<- status, timeout time.Duration, checkFunc CheckFunc) {
+func (a *Arb) readUntil(ctx context.Context, dataChan chan<- status, timeout time.Duration, checkFunc CheckFunc, limit responseLimit) {
 	timeoutctx, cancel := context.WithTimeout(a.ctx, timeout)
 	defer close(dataChan)
 	defer cancel()
-	rcvd, buf := bytes.NewBuffer(nil), bufio.NewReader(a.idotoo)
+	readctx, stopReading := context.WithCancel(timeoutctx)
+	batches, done := make(chan readBatch), make(chan struct{})
+	go a.readBatches(readctx, batches, max(limit.max, 0), done)
+	finish := func(s status) {
+		stopReading()
+		<-done
+		dataChan <- s
+	}
+	rcvd := bytes.NewBuffer(nil)
+	var first time.Time
 
 	for {
 		select {
 		case <-a.ctx.Done(): //context chain has collapsed
-			dataChan <- status{raw: rcvd.Bytes(), err: newErr(false, false, errors.Wrap(a.ctx.Err(), "Arbiter's context chain has collapsed"))}
+			finish(status{raw: rcvd.Bytes(), first: first, err: newErr(false, false, errors.Wrap(a.ctx.Err(), "Arbiter's context chain has collapsed"))})
+			return
+		case <-ctx.Done(): //the caller gave up
+			finish(status{raw: rcvd.Bytes(), first: first, err: cancelled(ctx)})
 			return
 		case <-timeoutctx.Done(): //timeout
-			dataChan <- status{raw: rcvd.Bytes(), err: newErr(true, true, errors.Wrap(timeoutctx.Err(), "Command timed out before receiving the proper response"))}
+			finish(status{raw: rcvd.Bytes(), first: first, err: newErr(true, true, errors.Wrap(timeoutctx.Err(), "Command timed out before receiving the proper response"))})
 			return
-		default:
-		}
-
-		reading := true
-		for reading {
-			b, e := buf.ReadByte()
-			switch e {
-			case nil:
-				rcvd.WriteByte(b)
-			default:
-				if ne, ok := e.(net.Error); ok {
-					if ne.Timeout() {
-						reading = false
-						continue
-					}
-					if !ne.Temporary() {
-						dataChan <- status{raw: rcvd.Bytes(), err: newErr(false, true, errors.New("Error Reading from buffer"))}
-						return
-					}
-				}
+		case batch := <-batches:
+			if rcvd.Len() == 0 {
+				first = batch.first
+			}
+			rcvd.Write(batch.raw)
+			if batch.err != nil {
+				finish(status{raw: rcvd.Bytes(), first: first, err: batch.err})
+				return
 			}
 		}
 
@@ -348,11 +666,82 @@ func (a *Arb) readUntil(dataChan chan<- status, timeout time.Duration, checkFunc
 		switch checkFunc(raw) {
 		case Insufficient: //need more data
 		case Failure: //return failure
-			dataChan <- status{err: ErrErrorResponse, raw: raw}
+			finish(status{err: ErrErrorResponse, raw: raw, first: first, matched: time.Now()})
 			return
 		case Success:
-			dataChan <- status{err: nil, raw: raw}
+			finish(status{err: nil, raw: raw, first: first, matched: time.Now()})
+			return
+		}
+		if limit.max <= 0 || len(raw) <= limit.max {
+			continue
+		}
+		if limit.policy != OverflowTrim {
+			finish(status{err: ErrResponseTooLarge, raw: raw[:limit.max], first: first})
+			return
+		}
+		n := len(raw) - limit.max
+		rcvd.Next(n)
+		if limit.trimmed != nil {
+			limit.trimmed(n)
+		}
+	}
+}
+
+/*
+readBatches reads the embedded io device for readUntil, sending what arrives
+on batches each time a read times out, or once flushAt bytes (if positive)
+have arrived, until ctx is done or a permanent error occurs.  Any error that
+is not a net.Error is taken to be permanent.  While nothing has arrived, the
+device is left waiting until ctx is done (see readContext).  Every read
+returning nothing is paced by readPause.  done is closed on exit.
+*/
+func (a *Arb) readBatches(ctx context.Context, batches chan<- readBatch, flushAt int, done chan<- struct{}) {
+	defer close(done)
+	r, b := a.reader(), make([]byte, 4096)
+	var batch readBatch
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		default:
+		}
+		start := time.Now()
+		var n int
+		var e error
+		if len(batch.raw) == 0 {
+			n, e = readContext(ctx, r, b)
+		} else {
+			n, e = r.Read(b)
+		}
+		if n > 0 {
+			if len(batch.raw) == 0 {
+				batch.first = time.Now()
+			}
+			batch.raw = append(batch.raw, b[:n]...)
+		}
+		full, paused := flushAt > 0 && len(batch.raw) >= flushAt, false
+		if e != nil {
+			ne, ok := e.(net.Error)
+			switch {
+			case !ok || !ne.Timeout() && !ne.Temporary(): //io.EOF and the like end the exchange too
+				batch.err = newErr(false, true, errors.New("Error Reading from buffer"))
+			case ne.Timeout():
+				paused = true
+			}
+		}
+		if full || batch.err != nil || paused && len(batch.raw) > 0 {
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			if batch.err != nil {
+				return
+			}
+			batch = readBatch{}
+		}
+		if n == 0 {
+			time.Sleep(readPause - time.Since(start))
 		}
 	}
 }
@@ -27,11 +27,15 @@ SOFTWARE.
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"regexp"
+	"strings"
 	"time"
 
+	"sync/atomic"
 	"testing"
 )
 
@@ -225,8 +229,15 @@ func TestArb_Control(t *testing.T) {
 		t.Log("Got Bytes", string(resp.Bytes))
 		t.Error("Expected response to arb a command to respond with nil")
 		t.FailNow()
+	} else if resp.Sent.IsZero() || resp.FirstByte.Before(resp.Sent) || resp.Matched.Before(resp.FirstByte) {
+		t.Error("Expected ordered timestamps", resp.Sent, resp.FirstByte, resp.Matched)
+	} else if resp.Latency() < 0 || resp.Transfer() < 0 || resp.Latency()+resp.Transfer() > resp.Duration {
+		t.Error("Expected latency and transfer within the duration", resp.Latency(), resp.Transfer(), resp.Duration)
 	}
 
+	if resp := a.Control(arbCmdTimeout); !resp.Matched.IsZero() || resp.FirstByte.IsZero() {
+		t.Error("Expected a timed out response to have a first byte but no match", resp.FirstByte, resp.Matched)
+	}
 	if resp := a.Control(arbCmdTimeout); resp.Error == nil || !bytes.Equal(resp.Bytes, []byte("Rxd>3")) {
 		t.Log("Got err", resp.Error)
 		t.Log("Got Bytes", string(resp.Bytes))
@@ -288,7 +299,7 @@ func TestArb_Contexts(t *testing.T) {
 	st := make(chan status, 0)
 	nctx, ncancel := context.WithCancel(context.Background())
 	arb.ctx = nctx
-	go arb.readUntil(st, 1*time.Hour, func([]byte) ExitCriteria { return Insufficient })
+	go arb.readUntil(context.Background(), st, 1*time.Hour, func([]byte) ExitCriteria { return Insufficient }, responseLimit{})
 	<-time.After(1 * time.Millisecond)
 	ncancel()
 	g := <-st
@@ -298,3 +309,483 @@ func TestArb_Contexts(t *testing.T) {
 	defer arb.Close()
 
 }
+
+func TestArb_SetTap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	a, e := NewArbiter(ctx, 100*time.Millisecond, dial)
+	if e != nil {
+		t.Fatal("Unable to dial", e)
+	}
+	defer a.Close()
+	rx, tx := &bytes.Buffer{}, &bytes.Buffer{}
+	a.(*Arb).SetTap(rx, tx)
+
+	a.Write([]byte("dead cat"))
+	<-time.After(20 * time.Millisecond)
+	if resp := a.Control(arbCmdOk); resp.Error != nil {
+		t.Fatal("Expected the command to succeed", resp.Error)
+	}
+	if tx.String() != "dead catABC" {
+		t.Errorf("Unexpected tx tap %q", tx.String())
+	}
+	if rx.String() != "Rxd>8Rxd>3" {
+		t.Errorf("Expected the discarded response in the rx tap, got %q", rx.String())
+	}
+
+	a.(*Arb).SetTap(nil, nil)
+	a.Control(arbCmdOk)
+	if tx.Len() != 11 || rx.Len() != 10 {
+		t.Error("Expected nothing tapped once removed", tx.String(), rx.String())
+	}
+}
+
+func TestLaneMutex(t *testing.T) {
+	var l laneMutex
+	l.Lock()
+	order := make(chan string, 4)
+	queued := func(n int) {
+		for {
+			if l.status().Depth == n {
+				return
+			}
+			<-time.After(time.Millisecond)
+		}
+	}
+	for i, name := range []string{"r1", "r2", "u1", "u2"} {
+		go func(name string) {
+			if name[0] == 'u' {
+				l.LockUrgent()
+			} else {
+				l.Lock()
+			}
+			order <- name
+			l.Unlock()
+		}(name)
+		queued(i + 1)
+	}
+	l.Unlock()
+	got := ""
+	for i := 0; i < 4; i++ {
+		got += <-order
+	}
+	if got != "u1u2r1r2" {
+		t.Error("Expected urgent waiters first, each lane in order, got", got)
+	}
+	l.Lock()
+	l.Unlock()
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected unlocking an unlocked laneMutex to panic")
+		}
+	}()
+	l.Unlock()
+}
+
+func TestArb_Urgent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	a, e := NewArbiter(ctx, 100*time.Millisecond, dial)
+	if e != nil {
+		t.Fatal("Unable to dial", e)
+	}
+	defer a.Close()
+	arb := a.(*Arb)
+
+	slow := arbCmdTimeout
+	slow.Timeout = 200 * time.Millisecond
+	go arb.Control(slow)
+	<-time.After(20 * time.Millisecond)
+
+	done := make(chan string, 4)
+	for i := 0; i < 3; i++ {
+		go func() { arb.Control(arbCmdOk); done <- "routine" }()
+	}
+	<-time.After(20 * time.Millisecond)
+	stop := arbCmdOk
+	stop.Urgent = true
+	go func() { arb.Control(stop); done <- "urgent" }()
+
+	if first := <-done; first != "urgent" {
+		t.Error("Expected the urgent command to preempt the routine ones, got", first)
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
+
+/*countingFlusher counts the FlushInput calls made to a NetClient*/
+type countingFlusher struct {
+	*NetClient
+	flushes int
+}
+
+func (cf *countingFlusher) FlushInput() error {
+	cf.flushes++
+	return cf.NetClient.FlushInput()
+}
+
+func TestArb_Flusher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	nc, err := NewNetClient(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	cf := &countingFlusher{NetClient: nc}
+	a, stop := Arbitrate(ctx, cf)
+	defer stop()
+
+	a.Write([]byte("dead cat")) //leaves a stale Rxd>8 to be flushed
+	<-time.After(20 * time.Millisecond)
+	if resp := a.Control(arbCmdOk); resp.Error != nil || string(resp.Bytes) != "Rxd>3" {
+		t.Fatalf("Expected the command to succeed, got %q %v", resp.Bytes, resp.Error)
+	}
+	if cf.flushes != 1 {
+		t.Errorf("Expected the stale input to be flushed, flushed %d times", cf.flushes)
+	}
+
+	//a tap must see what is discarded, so no flushing
+	rx := &bytes.Buffer{}
+	a.(*Arb).SetTap(rx, nil)
+	a.Write([]byte("dead cat"))
+	<-time.After(20 * time.Millisecond)
+	a.Control(arbCmdOk)
+	if cf.flushes != 1 || rx.String() != "Rxd>8Rxd>3" {
+		t.Errorf("Expected the tap to see the discarded input, flushed %d times, tapped %q", cf.flushes, rx.String())
+	}
+}
+
+func TestArb_ShortWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	nc, err := NewNetClient(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	a, stop := Arbitrate(ctx, &stingyIO{IDoIO: nc})
+	defer stop()
+	if resp := a.Control(arbCmdOk); resp.Error != nil {
+		t.Errorf("Expected short writes to be completed, got %q %v", resp.Bytes, resp.Error)
+	}
+}
+
+/*pollCounter is a bufIO counting its reads*/
+type pollCounter struct {
+	bufIO
+	reads int64
+}
+
+func (p *pollCounter) Read(b []byte) (int, error) {
+	atomic.AddInt64(&p.reads, 1)
+	return p.bufIO.Read(b)
+}
+
+func TestArb_ReadPacing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &pollCounter{}
+	a, stop := Arbitrate(ctx, p)
+	defer stop()
+	if resp := a.Simple([]byte("x"), []byte("never"), nil, 100*time.Millisecond); !IsTimeout(resp.Error) {
+		t.Error("Expected a timeout", resp.Error)
+	}
+	//reads that time out at once are paced, rather than spinning
+	if reads := atomic.LoadInt64(&p.reads); reads > 150 {
+		t.Errorf("Expected no more than a read a millisecond, got %d", reads)
+	}
+
+	//a ContextReader is left waiting for as long as the exchange may take
+	end, dev := NewMemPair(ctx)
+	w := &waitCounter{MemClient: end}
+	a, stop = Arbitrate(ctx, w)
+	defer stop()
+	if resp := a.Simple([]byte("x"), []byte("never"), nil, 100*time.Millisecond); !IsTimeout(resp.Error) {
+		t.Error("Expected a timeout", resp.Error)
+	}
+	if reads := atomic.LoadInt64(&w.reads); reads > 2 {
+		t.Errorf("Expected next to no reads while waiting, got %d", reads)
+	}
+
+	//and is woken by the response
+	atomic.StoreInt64(&w.reads, 0)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		dev.Write([]byte("done\n"))
+	}()
+	if resp := a.Simple([]byte("x"), []byte("done\n"), nil, time.Second); resp.Error != nil {
+		t.Error("Expected the response", resp.Error)
+	}
+	if reads := atomic.LoadInt64(&w.reads); reads > 5 {
+		t.Errorf("Expected next to no reads while waiting, got %d", reads)
+	}
+
+	//wrappers pass the wait on
+	atomic.StoreInt64(&w.reads, 0)
+	a, stop = Arbitrate(ctx, NewRingTap(w, 16))
+	defer stop()
+	if resp := a.Simple([]byte("x"), []byte("never"), nil, 100*time.Millisecond); !IsTimeout(resp.Error) {
+		t.Error("Expected a timeout", resp.Error)
+	}
+	if reads := atomic.LoadInt64(&w.reads); reads > 2 {
+		t.Errorf("Expected next to no reads through a wrapper, got %d", reads)
+	}
+}
+
+/*stubbornIO is a bufIO whose reads all return nothing but err, counting them*/
+type stubbornIO struct {
+	bufIO
+	err   error
+	reads int64
+}
+
+func (s *stubbornIO) Read(b []byte) (int, error) {
+	atomic.AddInt64(&s.reads, 1)
+	return 0, s.err
+}
+
+func TestArb_ReadErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//reads returning neither data nor an error are paced too
+	s := &stubbornIO{}
+	a, stop := Arbitrate(ctx, s)
+	defer stop()
+	if resp := a.Simple([]byte("x"), []byte("never"), nil, 100*time.Millisecond); !IsTimeout(resp.Error) {
+		t.Error("Expected a timeout", resp.Error)
+	}
+	//clearing stale input gives up after 100 such reads
+	if reads := atomic.LoadInt64(&s.reads); reads > 250 {
+		t.Errorf("Expected no more than a read a millisecond, got %d", reads)
+	}
+
+	//errors that are not net.Errors end the exchange at once
+	a, stop = Arbitrate(ctx, &stubbornIO{err: io.EOF})
+	defer stop()
+	start := time.Now()
+	if resp := a.Simple([]byte("x"), []byte("never"), nil, 2*time.Second); resp.Error == nil {
+		t.Error("Expected the read error")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Error("Expected the exchange to end on the read error, took", took)
+	}
+}
+
+/*waitCounter is a MemClient counting its reads*/
+type waitCounter struct {
+	*MemClient
+	reads int64
+}
+
+func (w *waitCounter) Read(b []byte) (int, error) {
+	atomic.AddInt64(&w.reads, 1)
+	return w.MemClient.Read(b)
+}
+
+func (w *waitCounter) ReadContext(ctx context.Context, b []byte) (int, error) {
+	atomic.AddInt64(&w.reads, 1)
+	return w.MemClient.ReadContext(ctx, b)
+}
+
+/*babbleIO is an IDoIO that never stops talking: reads always fill with x, ending with "DONE" after n bytes*/
+type babbleIO struct {
+	bufIO
+	n, sent int
+}
+
+func (b *babbleIO) Read(p []byte) (int, error) {
+	for i := range p {
+		b.sent++
+		p[i] = 'x'
+		if b.sent > b.n-4 && b.sent <= b.n {
+			p[i] = "DONE"[b.sent-b.n+3]
+		}
+	}
+	return len(p), nil
+}
+
+/*babbleIO has nothing to flush, and would never stop being drained by a read*/
+func (b *babbleIO) FlushInput() error  { return nil }
+func (b *babbleIO) FlushOutput() error { return nil }
+
+func TestArb_ResponseLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := Command{Name: "babble", Timeout: 5 * time.Second, Response: regexp.MustCompile(`DONE`), MaxResponseBytes: 1000}
+
+	a, stop := Arbitrate(ctx, &babbleIO{n: 100000})
+	defer stop()
+	if resp := a.Control(cmd); resp.Error != ErrResponseTooLarge || len(resp.Bytes) != 1000 {
+		t.Errorf("Expected the response to fail at its limit, got %d bytes: %v", len(resp.Bytes), resp.Error)
+	}
+
+	cmd.Overflow = OverflowTrim
+	a, stop = Arbitrate(ctx, &babbleIO{n: 100000})
+	defer stop()
+	resp := a.Control(cmd)
+	if resp.Error != nil || len(resp.Bytes) > 1000+4096 || !bytes.Contains(resp.Bytes, []byte("DONE")) {
+		t.Errorf("Expected the tail of the response to match, got %d bytes: %v", len(resp.Bytes), resp.Error)
+	}
+
+	//Simple follows the package default
+	SetDefaultResponseLimit(1000, OverflowFail)
+	defer SetDefaultResponseLimit(0, OverflowFail)
+	a, stop = Arbitrate(ctx, &babbleIO{n: 100000})
+	defer stop()
+	if resp := a.Simple(nil, []byte("DONE"), nil, 5*time.Second); resp.Error != ErrResponseTooLarge {
+		t.Error("Expected the default limit to apply", resp.Error)
+	}
+
+	var cc CommandConfig
+	if err := json.Unmarshal([]byte(`{"name":"x","max_response_bytes":10,"overflow":"trim"}`), &cc); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := cc.Command(); c.MaxResponseBytes != 10 || c.Overflow != OverflowTrim {
+		t.Error("Expected the limit to be configurable", c.MaxResponseBytes, c.Overflow)
+	}
+	if err := json.Unmarshal([]byte(`{"overflow":"spill"}`), &cc); err == nil {
+		t.Error("Expected an unknown policy to be refused")
+	}
+}
+
+func TestArb_ControlContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, stop := Arbitrate(ctx, &bufIO{})
+	defer stop()
+	ca := a.(ContextArbiter)
+	cmd := Command{Name: "silent", Timeout: time.Hour, Response: regexp.MustCompile(`OK`)}
+
+	//given up on while waiting for the response
+	cctx, ccancel := context.WithCancel(ctx)
+	time.AfterFunc(20*time.Millisecond, ccancel)
+	if resp := ca.ControlContext(cctx, cmd); !IsTemporary(resp.Error) || IsTimeout(resp.Error) || resp.Duration > time.Second {
+		t.Error("Expected a prompt, temporary error on cancellation", resp.Error, resp.Duration)
+	}
+	dctx, dcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer dcancel()
+	if resp := ca.SimpleContext(dctx, nil, []byte("OK"), nil, time.Hour); !IsTimeout(resp.Error) {
+		t.Error("Expected the caller's deadline to time the command out", resp.Error)
+	}
+
+	//given up on while waiting for the Arbiter, leaving it usable
+	go a.Simple(nil, []byte("OK"), nil, 100*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	qctx, qcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer qcancel()
+	start := time.Now()
+	if resp := ca.ControlContext(qctx, cmd); !IsTimeout(resp.Error) || time.Since(start) > 80*time.Millisecond {
+		t.Error("Expected to give up waiting for the Arbiter", resp.Error, time.Since(start))
+	}
+	cmd.Timeout = 10 * time.Millisecond
+	if resp := a.Control(cmd); !IsTimeout(resp.Error) {
+		t.Error("Expected the Arbiter to still be usable", resp.Error)
+	}
+}
+
+func TestLaneMutex_LockContext(t *testing.T) {
+	var l laneMutex
+	l.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan string, 2)
+	go func() {
+		if l.lockContext(ctx, PriorityUrgent) == nil {
+			got <- "cancelled"
+			l.Unlock()
+		}
+	}()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.Lock()
+		got <- "routine"
+		l.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	l.Unlock()
+	if first := <-got; first != "routine" {
+		t.Error("Expected a cancelled waiter to give up its place, got", first)
+	}
+	l.Lock() //only once the routine caller is done
+	if qs := l.status(); qs.Depth != 0 || len(l.lanes) != 0 {
+		t.Error("Expected no waiters left", qs)
+	}
+	l.Unlock()
+}
+
+func TestArb_SetUnsolicited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dev := &answeringIO{}
+	a, stop := Arbitrate(ctx, dev)
+	defer stop()
+	var got []string
+	a.(*Arb).SetUnsolicited(func(b []byte) { got = append(got, string(b)) })
+	cmd := Command{Name: "ping", Prototype: "ping\n", Timeout: 20 * time.Millisecond, Response: regexp.MustCompile(`OK`)}
+
+	dev.rx.WriteString("ALARM 1\n")
+	if rsp := a.Control(cmd); rsp.Error != nil || string(rsp.Bytes) != "OK\n" {
+		t.Errorf("Expected the exchange to be unaffected, got %q %v", rsp.Bytes, rsp.Error)
+	}
+	a.Control(cmd) //nothing unsolicited in between
+	dev.rx.WriteString("ALARM 2\nSTATUS\n")
+	a.Simple([]byte("ping\n"), []byte("OK"), nil, 20*time.Millisecond)
+	if len(got) != 2 || got[0] != "ALARM 1\n" || got[1] != "ALARM 2\nSTATUS\n" {
+		t.Errorf("Expected the unsolicited data, got %q", got)
+	}
+
+	a.(*Arb).SetUnsolicited(nil)
+	dev.rx.WriteString("ALARM 3\n")
+	a.Control(cmd)
+	if len(got) != 2 {
+		t.Errorf("Expected unsolicited data to be discarded once the handler is removed, got %q", got)
+	}
+}
+
+func TestArb_Expect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//a length prefixed frame, ending with a checksum: the sum of its bytes
+	check := func(raw []byte) ExitCriteria {
+		if len(raw) == 0 || len(raw) < int(raw[0])+2 {
+			return Insufficient
+		}
+		sum := byte(0)
+		for _, c := range raw[1 : raw[0]+1] {
+			sum += c
+		}
+		if sum != raw[raw[0]+1] {
+			return Failure
+		}
+		return Success
+	}
+	dev := &answeringIO{replies: []string{"\x02ab\xc3", "\x02ab\x00", "\x02a"}}
+	a, stop := Arbitrate(ctx, dev)
+	defer stop()
+	e := a.(Expecter)
+	if rsp := e.Expect([]byte("get\n"), 20*time.Millisecond, check); rsp.Error != nil || string(rsp.Bytes) != "\x02ab\xc3" {
+		t.Errorf("Expected a valid frame, got %q %v", rsp.Bytes, rsp.Error)
+	}
+	if rsp := e.Expect([]byte("get\n"), 20*time.Millisecond, check); rsp.Error != ErrErrorResponse {
+		t.Error("Expected a bad checksum to fail", rsp.Error)
+	}
+	if rsp := e.Expect([]byte("get\n"), 20*time.Millisecond, check); rsp.Error == nil || !IsTimeout(rsp.Error) {
+		t.Error("Expected a short frame to time out", rsp.Error)
+	}
+	if rsp := e.Expect([]byte("get\n"), 20*time.Millisecond, nil); rsp.Error == nil || !IsTimeout(rsp.Error) || string(rsp.Bytes) != "OK\n" {
+		t.Errorf("Expected a nil check to time out, got %q %v", rsp.Bytes, rsp.Error)
+	}
+	if sent := dev.tx.String(); sent != strings.Repeat("get\n", 4) {
+		t.Errorf("Unexpected commands sent %q", sent)
+	}
+}
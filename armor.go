@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+/*ArmorEncoding is a binary-to-text encoding used by an Armor*/
+type ArmorEncoding string
+
+const (
+	//ArmorHex encodes each byte as two hexadecimal digits
+	ArmorHex ArmorEncoding = "hex"
+
+	//ArmorBase64 uses standard, padded, base64 (RFC 4648)
+	ArmorBase64 ArmorEncoding = "base64"
+)
+
+/*
+Armor is a Transform that ASCII-armors binary data for paths that mangle it,
+such as 7-bit modems, text-only gateways, or tunnels that should stay
+log-friendly.  Each Write is encoded as a single line terminated by "\n", and
+inbound lines (terminated by "\n", with any "\r" ignored) are decoded.  Lines
+that do not decode are dropped and logged, rather than failing the read.
+*/
+type Armor struct {
+	enc  ArmorEncoding
+	line []byte //partial inbound line
+}
+
+/*NewArmor returns an Armor using enc, or an error if enc is not known*/
+func NewArmor(enc ArmorEncoding) (*Armor, error) {
+	switch enc {
+	case ArmorHex, ArmorBase64:
+		return &Armor{enc: enc}, nil
+	}
+	return nil, newErr(false, false, fmt.Errorf("unknown armor encoding %q", enc))
+}
+
+/*NewArmoredIO returns a Transformer armoring idoio with enc*/
+func NewArmoredIO(idoio IDoIO, enc ArmorEncoding) (*Transformer, error) {
+	a, err := NewArmor(enc)
+	if err != nil {
+		return nil, err
+	}
+	return NewTransformer(idoio, a), nil
+}
+
+/*WithArmor armors the stack with enc, described as "armor(encoding=hex)"*/
+func WithArmor(enc ArmorEncoding) Layer {
+	return NewLayer("armor", RankTransform, map[string]string{"encoding": string(enc)}, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewArmoredIO(inner, enc)
+	})
+}
+
+func parseArmorLayer(params map[string]string) (Layer, error) {
+	enc := ArmorEncoding(params["encoding"])
+	if _, err := NewArmor(enc); err != nil {
+		return Layer{}, err
+	}
+	return WithArmor(enc), nil
+}
+
+/*Encode conforms to Transform*/
+func (a *Armor) Encode(b []byte) ([]byte, error) {
+	var line []byte
+	if a.enc == ArmorHex {
+		line = make([]byte, hex.EncodedLen(len(b)))
+		hex.Encode(line, b)
+	} else {
+		line = make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+		base64.StdEncoding.Encode(line, b)
+	}
+	return append(line, '\n'), nil
+}
+
+/*Decode conforms to Transform*/
+func (a *Armor) Decode(b []byte) ([]byte, error) {
+	a.line = append(a.line, b...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(a.line, '\n')
+		if i < 0 {
+			return out, nil
+		}
+		line := bytes.TrimRight(bytes.ReplaceAll(a.line[:i], []byte("\r"), nil), " \t")
+		a.line = a.line[i+1:]
+		dec, err := a.decodeLine(line)
+		if err != nil {
+			DefaultLogger().Warn("dropping undecodable armored line", "event", EventError, "encoding", string(a.enc), "line", fmt.Sprintf("%q", line), "error", err)
+			continue
+		}
+		out = append(out, dec...)
+	}
+}
+
+func (a *Armor) decodeLine(line []byte) ([]byte, error) {
+	if a.enc == ArmorHex {
+		dec := make([]byte, hex.DecodedLen(len(line)))
+		_, err := hex.Decode(dec, line)
+		return dec, err
+	}
+	dec := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(dec, line)
+	return dec[:n], err
+}
+
+/*Reset discards any partial inbound line*/
+func (a *Armor) Reset() { a.line = nil }
@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"testing"
+)
+
+func TestArmor(t *testing.T) {
+	if _, err := NewArmor("rot13"); err == nil {
+		t.Error("Expected an unknown encoding to be rejected")
+	}
+	for _, tc := range []struct {
+		enc     ArmorEncoding
+		encoded string
+		inbound string
+	}{
+		{ArmorHex, "00ff0a\n", "48690A\r\nzz\n2021\n"},
+		{ArmorBase64, "AP8K\n", "SGkK\r\n!!\nICE=\n"},
+	} {
+		inner := &bufIO{}
+		a, _ := NewArmoredIO(inner, tc.enc)
+		if n, err := a.Write([]byte{0x00, 0xff, '\n'}); n != 3 || err != nil || inner.tx.String() != tc.encoded {
+			t.Errorf("%s: expected %q, got %q", tc.enc, tc.encoded, inner.tx.String())
+		}
+
+		//the bad line is dropped, the partial one waits for its newline
+		inner.rx.WriteString(tc.inbound[:len(tc.inbound)-3])
+		b := make([]byte, 16)
+		if n, err := a.Read(b); err != nil || string(b[:n]) != "Hi\n" {
+			t.Errorf("%s: expected the first line decoded, got %q %v", tc.enc, b[:n], err)
+		}
+		inner.rx.WriteString(tc.inbound[len(tc.inbound)-3:])
+		if n, err := a.Read(b); err != nil || string(b[:n]) != " !" {
+			t.Errorf("%s: expected the completed line decoded, got %q %v", tc.enc, b[:n], err)
+		}
+	}
+
+	s, err := ParseStack("tcp://localhost:1 | armor(encoding=base64)")
+	if err != nil || s.String() != "tcp://localhost:1 | armor(encoding=base64)" {
+		t.Error("Expected the armor layer to parse", s, err)
+	}
+	if _, err := ParseStack("tcp://localhost:1 | armor(encoding=uu)"); err == nil {
+		t.Error("Expected an unknown encoding to fail to parse")
+	}
+}
@@ -0,0 +1,101 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+)
+
+/*BatchResult is the outcome of sending several Commands as one exchange (see Batcher)*/
+type BatchResult struct {
+	Responses []Response //one per Command sent, in order
+	Error     error      //the first error encountered, if any
+	Failed    int        //how many of the Commands sent failed
+}
+
+/*
+Batcher is implemented by Arbiters that can send several Commands back to
+back as one exchange, for configuration sequences that must not be
+interleaved with other traffic.  Discover it with a type assertion.
+*/
+type Batcher interface {
+	ControlAll(cmds []Command, stopOnError bool) BatchResult
+	ControlAllContext(ctx context.Context, cmds []Command, stopOnError bool) BatchResult
+}
+
+/*
+ControlAll conforms to Batcher.  Each of cmds is sent as by Control, retries
+included, without giving up the Arb in between, and waiting for it with the
+priority of the most urgent of them.  If stopOnError is set, the first
+failure ends the batch; otherwise every Command is sent regardless.  Commands that can not be formed
+(see Command.Bytes) fail the batch before any are sent.
+*/
+func (a *Arb) ControlAll(cmds []Command, stopOnError bool) BatchResult {
+	return a.ControlAllContext(context.Background(), cmds, stopOnError)
+}
+
+/*ControlAllContext is ControlAll, given up on once ctx is done (see ContextArbiter)*/
+func (a *Arb) ControlAllContext(ctx context.Context, cmds []Command, stopOnError bool) (res BatchResult) {
+	if len(cmds) == 0 {
+		return
+	}
+	raw := make([][]byte, len(cmds))
+	for i, cmd := range cmds {
+		var err error
+		if raw[i], err = cmd.Bytes(); err != nil {
+			return BatchResult{Error: err}
+		}
+	}
+
+	if a.mux.lockContext(ctx, batchPriority(cmds)) != nil {
+		return BatchResult{Error: cancelled(ctx)}
+	}
+	defer a.mux.Unlock()
+	for i, cmd := range cmds {
+		rsp := a.controlRetrying(ctx, cmd, raw[i])
+		res.Responses = append(res.Responses, rsp)
+		if rsp.Error == nil {
+			continue
+		}
+		if res.Failed++; res.Error == nil {
+			res.Error = rsp.Error
+		}
+		if stopOnError || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+/*batchPriority returns the Priority of the most urgent of cmds*/
+func batchPriority(cmds []Command) Priority {
+	p := PriorityRoutine
+	for i, cmd := range cmds {
+		if i == 0 || cmd.priority() > p {
+			p = cmd.priority()
+		}
+	}
+	return p
+}
@@ -0,0 +1,87 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestArb_ControlAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := func(name string) Command {
+		return Command{Name: name, Prototype: name + "\n", Timeout: 30 * time.Millisecond,
+			Response: regexp.MustCompile(`OK`), Error: regexp.MustCompile(`ERR`)}
+	}
+	cmds := []Command{cmd("a"), cmd("b"), cmd("c")}
+
+	for _, tc := range []struct {
+		stop      bool
+		responses int
+	}{{true, 2}, {false, 3}} {
+		dev := &answeringIO{replies: []string{"OK\n", "ERR\n"}}
+		a, stop := Arbitrate(ctx, dev)
+		res := a.(Batcher).ControlAll(cmds, tc.stop)
+		stop()
+		if res.Error != ErrErrorResponse || res.Failed != 1 || len(res.Responses) != tc.responses {
+			t.Errorf("stopOnError %v: expected %d responses, one failed, got %d, %d failed: %v", tc.stop, tc.responses, len(res.Responses), res.Failed, res.Error)
+		}
+	}
+
+	//nothing else is sent in the middle of a batch
+	dev := &answeringIO{replies: []string{""}}
+	a, stop := Arbitrate(ctx, dev)
+	defer stop()
+	done := make(chan BatchResult)
+	go func() { done <- a.(Batcher).ControlAll(cmds, false) }()
+	time.Sleep(10 * time.Millisecond)
+	x := cmd("x")
+	x.Urgent = true
+	if rsp := a.Control(x); rsp.Error != nil {
+		t.Error("Expected the command after the batch to succeed", rsp.Error)
+	}
+	if res := <-done; res.Failed != 1 || !IsTimeout(res.Error) || len(res.Responses) != 3 {
+		t.Error("Expected the first command of the batch to time out", res.Failed, res.Error)
+	}
+	if sent := dev.tx.String(); sent != "a\nb\nc\nx\n" {
+		t.Errorf("Expected the batch to be sent back to back, got %q", sent)
+	}
+
+	//a command that can not be formed fails the batch before anything is sent
+	bad := cmd("d")
+	bad.Prototype = "%d\n"
+	if res := a.(Batcher).ControlAll(append(cmds, bad), false); res.Error != ErrBytesArgs || len(res.Responses) != 0 {
+		t.Error("Expected a bad command to fail the whole batch", res.Error)
+	}
+	if res := a.(Batcher).ControlAll(nil, true); res.Error != nil || len(res.Responses) != 0 {
+		t.Error("Expected an empty batch to do nothing", res.Error)
+	}
+	if sent := dev.tx.String(); sent != "a\nb\nc\nx\n" {
+		t.Errorf("Expected nothing more sent, got %q", sent)
+	}
+}
@@ -0,0 +1,466 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &BLEClient{}
+
+/*The characteristics of the Nordic UART Service, which BLEClients use by default*/
+const (
+	NUSRX = "6e400002-b5a3-f393-e0a9-e50e24dcca9e" //written by the central
+	NUSTX = "6e400003-b5a3-f393-e0a9-e50e24dcca9e" //notified by the peripheral
+)
+
+/*ATT opcodes, from the Bluetooth Core Specification Vol 3 Part F*/
+const (
+	attErrorRsp       = 0x01
+	attMTUReq         = 0x02
+	attMTURsp         = 0x03
+	attFindInfoReq    = 0x04
+	attFindInfoRsp    = 0x05
+	attReadByTypeReq  = 0x08
+	attReadByTypeRsp  = 0x09
+	attWriteReq       = 0x12
+	attWriteRsp       = 0x13
+	attWriteCmd       = 0x52
+	attNotification   = 0x1b
+	attIndication     = 0x1d
+	attConfirmation   = 0x1e
+	attNotFound       = 0x0a //error code for Attribute Not Found
+	attReqUnsupported = 0x06 //error code for Request Not Supported
+	attDefaultMTU     = 23
+	attMaxMTU         = 517
+)
+
+/*attError is an Error Response from the peripheral*/
+type attError struct {
+	op     byte
+	handle uint16
+	code   byte
+}
+
+func (e attError) Error() string {
+	return fmt.Sprintf("ATT error 0x%02x for opcode 0x%02x on handle 0x%04x", e.code, e.op, e.handle)
+}
+
+/*bleChar is a characteristic declaration*/
+type bleChar struct {
+	decl, value uint16
+	uuid        [16]byte
+}
+
+/*
+BLEClient provides an implementer of the IDoIO interface for a Bluetooth LE
+peripheral exposing a serial stream as a pair of characteristics, such as the
+Nordic UART Service, under the URI regime:
+
+	ble://
+
+Writes are sent to the rx characteristic without response, split to fit the
+negotiated MTU, and Reads return notifications (or indications) of the tx
+characteristic.  It speaks ATT directly over an L2CAP socket, so is only
+supported on linux, and the peripheral must not require pairing.
+*/
+type BLEClient struct {
+	ctx                context.Context
+	cancel             context.CancelFunc
+	timeout            time.Duration
+	rwtimeout          time.Duration
+	dial               string
+	addr               [6]byte
+	random             bool
+	rx, tx             [16]byte //as sent on the wire
+	open               func(addr [6]byte, random bool, timeout time.Duration) (deadlineConn, error)
+	conn               deadlineConn
+	mtu                int
+	rxHandle, txHandle uint16
+	buf                []byte
+	pending            []byte //notified but not yet Read
+	log                Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewBLEClient connects to a peripheral.  Dial should be in the form of
+
+	ble://<address>[?rx=<uuid>&tx=<uuid>&type=random|public]
+
+eg "ble://C4:7E:2A:10:33:9F".  rx and tx are the characteristics written and
+notified, which default to NUSRX and NUSTX, and may be given as 16 bit or
+full UUIDs.  type is that of the address, which defaults to random as most
+peripherals use.  Timeout limits connecting and discovering the
+characteristics, and defaults to the 30s of the ATT transaction timeout.
+*/
+func NewBLEClient(ctx context.Context, timeout time.Duration, dial string) (*BLEClient, error) {
+	d, err := dialFor(dial, "ble")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ParseMAC(d.Device)
+	if err != nil || len(addr) != 6 {
+		return nil, newErr(false, false, fmt.Errorf("invalid address in %q", dial))
+	}
+	bctx, cancel := context.WithCancel(ctx)
+	bc := &BLEClient{ctx: bctx, cancel: cancel, timeout: timeout, rwtimeout: 1 * time.Millisecond, dial: dial, random: true, open: dialBLE}
+	if bc.timeout <= 0 {
+		bc.timeout = 30 * time.Second
+	}
+	copy(bc.addr[:], addr)
+	if err := bc.parse(d.Params.Encode()); err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	return bc, openUnlessLazy(ctx, bc)
+}
+
+func (bc *BLEClient) parse(query string) error {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	rx, tx := NUSRX, NUSTX
+	for k, v := range q {
+		switch k {
+		case "rx":
+			rx = v[0]
+		case "tx":
+			tx = v[0]
+		case "type":
+			if v[0] != "random" && v[0] != "public" {
+				return fmt.Errorf("address type must be random or public, not %q", v[0])
+			}
+			bc.random = v[0] == "random"
+		default:
+			return fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	if bc.rx, err = bleUUID(rx); err == nil {
+		bc.tx, err = bleUUID(tx)
+	}
+	return err
+}
+
+/*bleUUID parses a 16 bit or full UUID into its (little endian) wire order*/
+func bleUUID(s string) (u [16]byte, err error) {
+	h := strings.ReplaceAll(s, "-", "")
+	if len(h) == 4 {
+		h = "0000" + h + "00001000800000805f9b34fb"
+	}
+	if len(h) != 32 {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	if _, err = hex.Decode(u[:], []byte(h)); err != nil {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	for i, j := 0, 15; i < j; i, j = i+1, j-1 {
+		u[i], u[j] = u[j], u[i]
+	}
+	return u, nil
+}
+
+/*bleWireUUID expands a 2 or 16 byte UUID, as found in an ATT PDU, to 16 bytes*/
+func bleWireUUID(b []byte) (u [16]byte) {
+	if len(b) == 2 {
+		u, _ = bleUUID(fmt.Sprintf("%04x", binary.LittleEndian.Uint16(b)))
+		return u
+	}
+	copy(u[:], b)
+	return u
+}
+
+/*
+SetLogger overrides the Logger carried by the context this BLEClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (bc *BLEClient) SetLogger(l Logger) {
+	bc.log = l
+}
+
+func (bc *BLEClient) dialString() string { return bc.dial }
+
+func (bc *BLEClient) logger() Logger {
+	if bc.log != nil {
+		return bc.log
+	}
+	return LoggerFrom(bc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (bc *BLEClient) String() string {
+	return fmt.Sprintf("BLE peripheral %X", bc.addr)
+}
+
+/*Open forcibly disconnects (ignoring errors), then connects and discovers the characteristics again*/
+func (bc *BLEClient) Open() (err error) {
+	select {
+	case <-bc.ctx.Done():
+		return newErr(false, false, bc.ctx.Err())
+	default:
+	}
+	if bc.conn != nil {
+		bc.conn.Close()
+		bc.conn = nil
+	}
+	bc.pending = nil
+	if bc.buf == nil {
+		bc.buf = make([]byte, attMaxMTU)
+	}
+	conn, err := bc.open(bc.addr, bc.random, bc.timeout)
+	if err == nil {
+		if err = bc.discover(conn); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		bc.logger().Warn("unable to open connection", "event", EventError, "dial", bc.dial, "error", err)
+		return newErr(false, os.IsTimeout(err), errors.Wrapf(err, "unable to connect to %v", bc))
+	}
+	bc.conn = conn
+	bc.logger().Debug("connection opened", "event", EventConnect, "dial", bc.dial)
+	return nil
+}
+
+/*discover negotiates the MTU, finds the rx and tx characteristics, and enables notification of tx*/
+func (bc *BLEClient) discover(conn deadlineConn) error {
+	conn.SetReadDeadline(time.Now().Add(bc.timeout))
+	conn.SetWriteDeadline(time.Now().Add(bc.timeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	bc.mtu = attDefaultMTU
+	rsp, err := bc.request(conn, []byte{attMTUReq, attMaxMTU & 0xff, attMaxMTU >> 8})
+	switch err.(type) {
+	case nil:
+		if len(rsp) >= 3 {
+			if mtu := int(binary.LittleEndian.Uint16(rsp[1:])); mtu > bc.mtu {
+				bc.mtu = min(mtu, attMaxMTU)
+			}
+		}
+	case attError: //the default it is
+	default:
+		return err
+	}
+
+	var chars []bleChar
+	for start := uint16(1); start != 0; {
+		req := []byte{attReadByTypeReq, 0, 0, 0xff, 0xff, 0x03, 0x28} //characteristic declarations
+		binary.LittleEndian.PutUint16(req[1:], start)
+		if rsp, err = bc.request(conn, req); err != nil {
+			if e, ok := err.(attError); ok && e.code == attNotFound {
+				break
+			}
+			return err
+		}
+		if len(rsp) < 2 || (rsp[1] != 7 && rsp[1] != 21) || len(rsp) < 2+int(rsp[1]) {
+			return fmt.Errorf("malformed characteristic discovery response")
+		}
+		for l, b := int(rsp[1]), rsp[2:]; len(b) >= l; b = b[l:] {
+			c := bleChar{decl: binary.LittleEndian.Uint16(b), value: binary.LittleEndian.Uint16(b[3:]), uuid: bleWireUUID(b[5:l])}
+			chars = append(chars, c)
+			start = c.decl + 1 //0 after the last handle, ending discovery
+		}
+	}
+	tx := -1
+	for i, c := range chars {
+		switch c.uuid {
+		case bc.rx:
+			bc.rxHandle = c.value
+		case bc.tx:
+			bc.txHandle, tx = c.value, i
+		}
+	}
+	if bc.rxHandle == 0 || tx < 0 {
+		return fmt.Errorf("peripheral lacks the rx or tx characteristic")
+	}
+
+	end := uint16(0xffff)
+	if tx+1 < len(chars) {
+		end = chars[tx+1].decl - 1
+	}
+	cccd := uint16(0)
+	for start := bc.txHandle + 1; cccd == 0 && start != 0 && start <= end; {
+		req := []byte{attFindInfoReq, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint16(req[1:], start)
+		binary.LittleEndian.PutUint16(req[3:], end)
+		if rsp, err = bc.request(conn, req); err != nil {
+			if e, ok := err.(attError); ok && e.code == attNotFound {
+				break
+			}
+			return err
+		}
+		l := 4 //handle and 16 bit UUID
+		if len(rsp) < 2 || rsp[1] == 2 {
+			l = 18 //handle and 128 bit UUID
+		}
+		if len(rsp) < 2+l {
+			return fmt.Errorf("malformed descriptor discovery response")
+		}
+		for b := rsp[2:]; len(b) >= l; b = b[l:] {
+			h := binary.LittleEndian.Uint16(b)
+			if l == 4 && binary.LittleEndian.Uint16(b[2:]) == 0x2902 { //Client Characteristic Configuration
+				cccd = h
+			}
+			start = h + 1
+		}
+	}
+	if cccd == 0 {
+		return fmt.Errorf("tx characteristic cannot notify")
+	}
+	req := []byte{attWriteReq, 0, 0, 0x01, 0x00} //enable notifications
+	binary.LittleEndian.PutUint16(req[1:], cccd)
+	_, err = bc.request(conn, req)
+	return err
+}
+
+/*request sends req and waits for its response, returning the Error Response as an attError*/
+func (bc *BLEClient) request(conn deadlineConn, req []byte) ([]byte, error) {
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	for {
+		n, err := conn.Read(bc.buf)
+		if err != nil {
+			return nil, err
+		}
+		rsp := bc.buf[:n]
+		switch {
+		case n == 0:
+		case rsp[0] == req[0]+1:
+			return rsp, nil
+		case rsp[0] == attErrorRsp && n >= 5 && rsp[1] == req[0]:
+			return nil, attError{op: rsp[1], handle: binary.LittleEndian.Uint16(rsp[2:]), code: rsp[4]}
+		default:
+			bc.unsolicited(conn, rsp)
+		}
+	}
+}
+
+/*unsolicited handles a PDU the peripheral sent of its own accord, keeping any data from tx*/
+func (bc *BLEClient) unsolicited(conn deadlineConn, pdu []byte) {
+	switch pdu[0] {
+	case attIndication:
+		conn.Write([]byte{attConfirmation})
+		fallthrough
+	case attNotification:
+		if len(pdu) >= 3 && bc.txHandle != 0 && binary.LittleEndian.Uint16(pdu[1:]) == bc.txHandle {
+			bc.pending = append(bc.pending, pdu[3:]...)
+		}
+	case attMTUReq:
+		conn.Write([]byte{attMTURsp, byte(bc.mtu), byte(bc.mtu >> 8)})
+	}
+}
+
+/*Read conforms to io.Reader, returning data notified by the tx characteristic*/
+func (bc *BLEClient) Read(b []byte) (int, error) {
+	select {
+	case <-bc.ctx.Done():
+		defer bc.Close()
+		return 0, newErr(false, false, bc.ctx.Err())
+	default:
+	}
+	if bc.conn == nil {
+		return 0, readErr
+	}
+	if len(bc.pending) == 0 {
+		if bc.rwtimeout > 0 {
+			bc.conn.SetReadDeadline(time.Now().Add(bc.rwtimeout))
+		}
+		n, err := bc.conn.Read(bc.buf)
+		if err != nil {
+			return 0, bc.connErr("read", err)
+		}
+		if n > 0 {
+			bc.unsolicited(bc.conn, bc.buf[:n])
+		}
+		if len(bc.pending) == 0 {
+			return 0, newErr(true, true, fmt.Errorf("read: nothing notified"))
+		}
+	}
+	n := copy(b, bc.pending)
+	bc.pending = bc.pending[n:]
+	return n, nil
+}
+
+/*Write conforms to io.Writer, writing b to the rx characteristic in as many commands as the MTU requires*/
+func (bc *BLEClient) Write(b []byte) (int, error) {
+	select {
+	case <-bc.ctx.Done():
+		defer bc.Close()
+		return 0, newErr(false, false, bc.ctx.Err())
+	default:
+	}
+	if bc.conn == nil {
+		return 0, writeErr
+	}
+	if bc.rwtimeout > 0 {
+		bc.conn.SetWriteDeadline(time.Now().Add(bc.rwtimeout))
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), bc.mtu-3)]
+		pdu := append([]byte{attWriteCmd, byte(bc.rxHandle), byte(bc.rxHandle >> 8)}, chunk...)
+		if _, err := bc.conn.Write(pdu); err != nil {
+			return written, bc.connErr("write", err)
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+/*Close conforms to io.Closer, disconnecting from the peripheral*/
+func (bc *BLEClient) Close() error {
+	bc.cancel()
+	if bc.conn == nil {
+		return nil
+	}
+	bc.logger().Debug("connection closed", "event", EventDisconnect, "dial", bc.dial)
+	err := bc.conn.Close()
+	bc.conn = nil
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
+
+/*connErr makes err conform to net.Error, logging it unless it is a deadline passing*/
+func (bc *BLEClient) connErr(op string, err error) error {
+	if os.IsTimeout(err) {
+		return newErr(true, true, err)
+	}
+	bc.logger().Debug(op+" failed", "event", EventError, "dial", bc.dial, "error", err)
+	return newErr(false, false, err)
+}
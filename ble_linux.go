@@ -0,0 +1,87 @@
+//go:build linux && !386
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+/*From bluetooth/bluetooth.h and bluetooth/l2cap.h*/
+const (
+	afBluetooth    = 31
+	btprotoL2CAP   = 0
+	l2capCIDATT    = 4
+	bdaddrLEPublic = 1
+	bdaddrLERandom = 2
+)
+
+/*sockaddrL2 is struct sockaddr_l2, assuming a little endian host*/
+type sockaddrL2 struct {
+	family     uint16
+	psm        uint16
+	bdaddr     [6]byte //least significant byte first
+	cid        uint16
+	bdaddrType uint8
+	_          uint8
+}
+
+/*dialBLE opens an L2CAP channel for ATT to the peripheral at addr, returned as a pollable file so deadlines work*/
+func dialBLE(addr [6]byte, random bool, timeout time.Duration) (deadlineConn, error) {
+	fd, err := syscall.Socket(afBluetooth, syscall.SOCK_SEQPACKET|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, btprotoL2CAP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	local := sockaddrL2{family: afBluetooth, cid: l2capCIDATT, bdaddrType: bdaddrLEPublic}
+	if _, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&local)), unsafe.Sizeof(local)); errno != 0 {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", errno)
+	}
+	remote := sockaddrL2{family: afBluetooth, cid: l2capCIDATT, bdaddrType: bdaddrLEPublic}
+	if random {
+		remote.bdaddrType = bdaddrLERandom
+	}
+	for i := range addr {
+		remote.bdaddr[i] = addr[len(addr)-1-i]
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd), uintptr(unsafe.Pointer(&remote)), unsafe.Sizeof(remote))
+	if errno != 0 && errno != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("connect", errno)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("ble:%X", addr))
+	if errno == syscall.EINPROGRESS {
+		if err = awaitConnect(f, timeout); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,161 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+fakeGATT serves a Nordic UART Service on con, at rx 0x11 and tx 0x13 with its
+CCCD at 0x14, followed by the Device Name characteristic.  Once notifications
+are enabled it answers each write to rx with "Rxd>%d".  Its PDUs are queued,
+as a radio would, so the client need not be reading to write.
+*/
+func fakeGATT(t *testing.T, con net.Conn, wrote chan<- []byte) {
+	out := make(chan []byte, 64)
+	defer close(out)
+	go func() {
+		defer con.Close()
+		for pdu := range out {
+			con.Write(pdu)
+		}
+	}()
+	rx, _ := bleUUID(NUSRX)
+	tx, _ := bleUUID(NUSTX)
+	notify := false
+	buf := make([]byte, 1024)
+	for {
+		n, err := con.Read(buf)
+		if err != nil || n == 0 {
+			return
+		}
+		pdu := buf[:n]
+		switch pdu[0] {
+		case attMTUReq:
+			out <- []byte{attMTURsp, 100, 0}
+		case attReadByTypeReq:
+			switch start := binary.LittleEndian.Uint16(pdu[1:]); {
+			case start <= 0x12:
+				rsp := []byte{attReadByTypeRsp, 21}
+				rsp = append(append(rsp, 0x10, 0, 0x04, 0x11, 0), rx[:]...)
+				rsp = append(append(rsp, 0x12, 0, 0x10, 0x13, 0), tx[:]...)
+				out <- rsp
+			case start <= 0x20:
+				out <- []byte{attReadByTypeRsp, 7, 0x20, 0, 0x02, 0x21, 0, 0x00, 0x2a}
+			default:
+				out <- []byte{attErrorRsp, attReadByTypeReq, pdu[1], pdu[2], attNotFound}
+			}
+		case attFindInfoReq:
+			if start, end := binary.LittleEndian.Uint16(pdu[1:]), binary.LittleEndian.Uint16(pdu[3:]); start != 0x14 || end != 0x1f {
+				t.Errorf("Expected descriptors of tx to be sought, not 0x%04x-0x%04x", start, end)
+			}
+			out <- []byte{attFindInfoRsp, 1, 0x14, 0, 0x02, 0x29}
+		case attWriteReq:
+			if binary.LittleEndian.Uint16(pdu[1:]) == 0x14 && bytes.Equal(pdu[3:], []byte{1, 0}) {
+				notify = true
+			}
+			out <- []byte{attWriteRsp}
+		case attWriteCmd:
+			if binary.LittleEndian.Uint16(pdu[1:]) != 0x11 {
+				t.Error("Expected writes to rx")
+			}
+			if wrote != nil {
+				wrote <- append([]byte{}, pdu[3:]...)
+			}
+			if notify {
+				out <- append([]byte{attNotification, 0x13, 0}, fmt.Sprintf("Rxd>%d", n-3)...)
+			}
+		}
+	}
+}
+
+func TestBLEClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"ble://C4:7E:2A:10:33", "ble://C4:7E:2A:10:33:9F?type=static", "ble://C4:7E:2A:10:33:9F?rx=xyz", "ble://C4:7E:2A:10:33:9F?bogus=1"} {
+		if _, err := NewBLEClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	if _, err := NewIDoIO(ctx, 100*time.Millisecond, "ble://C4:7E:2A:10:33:9F"); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error without the peripheral", err)
+	}
+	if u, _ := bleUUID("2a00"); u != bleWireUUID([]byte{0x00, 0x2a}) {
+		t.Error("Expected 16 bit UUIDs to expand with the base UUID", u)
+	}
+
+	wrote := make(chan []byte, 16)
+	bc := &BLEClient{ctx: ctx, cancel: func() {}, timeout: time.Second, rwtimeout: time.Millisecond, random: true}
+	bc.parse("")
+	bc.open = func(addr [6]byte, random bool, timeout time.Duration) (deadlineConn, error) {
+		near, far := net.Pipe()
+		go fakeGATT(t, far, wrote)
+		return near, nil
+	}
+	if err := bc.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	_ = bc.String()
+	if bc.mtu != 100 || bc.rxHandle != 0x11 || bc.txHandle != 0x13 {
+		t.Error("Expected the MTU and characteristics to be discovered", bc.mtu, bc.rxHandle, bc.txHandle)
+	}
+
+	a, stop := Arbitrate(ctx, bc)
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected a response", rsp.Error)
+	}
+	stop()
+	<-wrote
+
+	if n, err := bc.Write(bytes.Repeat([]byte{'x'}, 250)); n != 250 || err != nil {
+		t.Error("Unable to write", n, err)
+	}
+	for _, want := range []int{97, 97, 56} {
+		if got := len(<-wrote); got != want {
+			t.Errorf("Expected a %d byte write, got %d", want, got)
+		}
+	}
+	small := make([]byte, 3)
+	var got []byte
+	for i := 0; i < 1000 && len(got) < len("Rxd>97Rxd>97Rxd>56"); i++ {
+		n, _ := bc.Read(small)
+		got = append(got, small[:n]...)
+	}
+	if string(got) != "Rxd>97Rxd>97Rxd>56" {
+		t.Errorf("Expected notifications to be read in pieces, got %q", got)
+	}
+
+	bc.Close()
+	if _, err := bc.Read(small); err == nil {
+		t.Error("Expected an error reading after close")
+	}
+}
@@ -0,0 +1,395 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+The capture container is laid out as follows, with all integers big endian:
+
+	header:  "AGNOCAP\x00", version uint16, label length uint16, label
+	record:  direction uint8 (1 rx, 2 tx), unix nanoseconds int64, length uint32, bytes
+	...
+	index:   0x00, entry count uint32, then per entry:
+	         unix nanoseconds int64, file offset int64, record number uint64
+	trailer: index offset int64, "AGNOIDX\x00"
+
+The index and trailer are written by CaptureWriter.Close.  A capture without
+them (e.g. one cut short by a crash) can still be read sequentially, but Seek
+must then scan from the start.
+*/
+const (
+	captureVersion    = 1
+	captureIndexEvery = 256 //records between index entries
+	captureRecordHdr  = 13
+)
+
+var (
+	captureMagic = []byte("AGNOCAP\x00")
+	captureTrail = []byte("AGNOIDX\x00")
+)
+
+/*CaptureIndexEntry locates a record in a capture*/
+type CaptureIndexEntry struct {
+	Time   time.Time
+	Offset int64  //file offset of the record
+	Record uint64 //number of the record, counting from 0
+}
+
+/*
+CaptureWriter writes Records to the capture container shared by every agnoio
+tool that records traffic, so that captures are interoperable and seekable.
+*/
+type CaptureWriter struct {
+	w      *bufio.Writer
+	under  io.Writer
+	offset int64
+	count  uint64
+	index  []CaptureIndexEntry
+	err    error
+}
+
+/*
+NewCaptureWriter writes a capture header to w, labelled with label (e.g. the
+dial string of the transport captured), and returns a CaptureWriter appending
+records to it
+*/
+func NewCaptureWriter(w io.Writer, label string) (*CaptureWriter, error) {
+	if len(label) > 0xffff {
+		label = label[:0xffff]
+	}
+	cw := &CaptureWriter{w: bufio.NewWriter(w), under: w}
+	hdr := append(cloneBytes(captureMagic), 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(hdr[8:], captureVersion)
+	binary.BigEndian.PutUint16(hdr[10:], uint16(len(label)))
+	if err := cw.write(append(hdr, label...)); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+/*CreateCapture creates (or truncates) the capture file at path*/
+func CreateCapture(path, label string) (*CaptureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, newErr(false, false, err)
+	}
+	cw, err := NewCaptureWriter(f, label)
+	if err != nil {
+		f.Close()
+	}
+	return cw, err
+}
+
+func (cw *CaptureWriter) write(b []byte) error {
+	if cw.err != nil {
+		return cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.offset += int64(n)
+	if err != nil {
+		cw.err = newErr(false, false, errors.Wrap(err, "unable to write capture"))
+	}
+	return cw.err
+}
+
+/*WriteRecord appends rec to the capture*/
+func (cw *CaptureWriter) WriteRecord(rec Record) error {
+	if rec.Direction != Rx && rec.Direction != Tx {
+		return newErr(false, false, fmt.Errorf("can not capture a record with direction %v", rec.Direction))
+	}
+	if uint64(len(rec.Bytes)) > 0xffffffff {
+		return newErr(false, false, fmt.Errorf("record of %d bytes is too large to capture", len(rec.Bytes)))
+	}
+	if cw.count%captureIndexEvery == 0 {
+		cw.index = append(cw.index, CaptureIndexEntry{Time: rec.Time, Offset: cw.offset, Record: cw.count})
+	}
+	var hdr [captureRecordHdr]byte
+	hdr[0] = byte(rec.Direction)
+	binary.BigEndian.PutUint64(hdr[1:], uint64(rec.Time.UnixNano()))
+	binary.BigEndian.PutUint32(hdr[9:], uint32(len(rec.Bytes)))
+	if err := cw.write(hdr[:]); err != nil {
+		return err
+	}
+	cw.count++
+	return cw.write(rec.Bytes)
+}
+
+/*Count returns the number of records written*/
+func (cw *CaptureWriter) Count() uint64 { return cw.count }
+
+/*Flush writes any buffered records through to the underlying writer*/
+func (cw *CaptureWriter) Flush() error {
+	if cw.err == nil {
+		if err := cw.w.Flush(); err != nil {
+			cw.err = newErr(false, false, errors.Wrap(err, "unable to write capture"))
+		}
+	}
+	return cw.err
+}
+
+/*
+Close writes the index and trailer, flushes, and closes the underlying writer
+if it is an io.Closer.  No records may be written afterwards.
+*/
+func (cw *CaptureWriter) Close() error {
+	start := cw.offset
+	idx := make([]byte, 5, 5+24*len(cw.index)+16)
+	binary.BigEndian.PutUint32(idx[1:], uint32(len(cw.index)))
+	for _, e := range cw.index {
+		var ent [24]byte
+		binary.BigEndian.PutUint64(ent[0:], uint64(e.Time.UnixNano()))
+		binary.BigEndian.PutUint64(ent[8:], uint64(e.Offset))
+		binary.BigEndian.PutUint64(ent[16:], e.Record)
+		idx = append(idx, ent[:]...)
+	}
+	var trail [8]byte
+	binary.BigEndian.PutUint64(trail[:], uint64(start))
+	idx = append(append(idx, trail[:]...), captureTrail...)
+	err := cw.write(idx)
+	if ferr := cw.Flush(); err == nil {
+		err = ferr
+	}
+	if c, ok := cw.under.(io.Closer); ok {
+		if cerr := c.Close(); err == nil && cerr != nil {
+			err = newErr(false, false, cerr)
+		}
+	}
+	if cw.err == nil {
+		cw.err = newErr(false, false, errors.New("capture is closed"))
+	}
+	return err
+}
+
+/*
+CaptureReader reads Records from a capture written by a CaptureWriter.  If the
+underlying reader is an io.ReadSeeker, the capture's index is loaded and Seek
+may be used.
+*/
+type CaptureReader struct {
+	r      *bufio.Reader
+	under  io.Reader
+	label  string
+	first  int64 //offset of the first record
+	offset int64 //offset of the next record
+	count  uint64
+	index  []CaptureIndexEntry
+	done   bool
+}
+
+/*NewCaptureReader reads the capture header from r, and its index if r can seek*/
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	cr := &CaptureReader{r: bufio.NewReader(r), under: r}
+	var hdr [12]byte
+	if _, err := io.ReadFull(cr.r, hdr[:]); err != nil || !bytes.Equal(hdr[:8], captureMagic) {
+		return nil, newErr(false, false, fmt.Errorf("not a capture"))
+	}
+	if v := binary.BigEndian.Uint16(hdr[8:]); v != captureVersion {
+		return nil, newErr(false, false, fmt.Errorf("unsupported capture version %d", v))
+	}
+	label := make([]byte, binary.BigEndian.Uint16(hdr[10:]))
+	if _, err := io.ReadFull(cr.r, label); err != nil {
+		return nil, newErr(false, false, errors.Wrap(err, "truncated capture header"))
+	}
+	cr.label = string(label)
+	cr.first = int64(len(hdr) + len(label))
+	cr.offset = cr.first
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if err := cr.loadIndex(rs); err != nil {
+			return nil, err
+		}
+	}
+	return cr, nil
+}
+
+/*OpenCapture opens the capture file at path. Close the returned CaptureReader when done.*/
+func OpenCapture(path string) (*CaptureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, newErr(false, false, err)
+	}
+	cr, err := NewCaptureReader(f)
+	if err != nil {
+		f.Close()
+	}
+	return cr, err
+}
+
+/*loadIndex reads the index, if the capture has one, and returns to the first record*/
+func (cr *CaptureReader) loadIndex(rs io.ReadSeeker) error {
+	defer cr.seekTo(cr.first, 0)
+	end, err := rs.Seek(-16, io.SeekEnd)
+	if err != nil {
+		return nil //too short to have an index
+	}
+	var trail [16]byte
+	if _, err := io.ReadFull(rs, trail[:]); err != nil || !bytes.Equal(trail[8:], captureTrail) {
+		return nil
+	}
+	start := int64(binary.BigEndian.Uint64(trail[:8]))
+	if start < cr.first || start > end {
+		return newErr(false, false, fmt.Errorf("corrupt capture index offset %d", start))
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return newErr(false, false, err)
+	}
+	idx := make([]byte, end-start)
+	if _, err := io.ReadFull(rs, idx); err != nil || len(idx) < 5 || idx[0] != 0 {
+		return newErr(false, false, fmt.Errorf("corrupt capture index"))
+	}
+	n := int(binary.BigEndian.Uint32(idx[1:]))
+	if len(idx) != 5+24*n {
+		return newErr(false, false, fmt.Errorf("corrupt capture index"))
+	}
+	for i := 0; i < n; i++ {
+		ent := idx[5+24*i:]
+		cr.index = append(cr.index, CaptureIndexEntry{
+			Time:   time.Unix(0, int64(binary.BigEndian.Uint64(ent[0:]))),
+			Offset: int64(binary.BigEndian.Uint64(ent[8:])),
+			Record: binary.BigEndian.Uint64(ent[16:]),
+		})
+	}
+	return nil
+}
+
+/*Label returns the label the capture was written with*/
+func (cr *CaptureReader) Label() string { return cr.label }
+
+/*Index returns the capture's index, which is empty if it has none or can not seek*/
+func (cr *CaptureReader) Index() []CaptureIndexEntry {
+	return append([]CaptureIndexEntry{}, cr.index...)
+}
+
+/*
+Next returns the next Record, or io.EOF at the end of the capture.  A capture
+cut short mid-record returns io.ErrUnexpectedEOF.
+*/
+func (cr *CaptureReader) Next() (Record, error) {
+	if cr.done {
+		return Record{}, io.EOF
+	}
+	var hdr [captureRecordHdr]byte
+	if _, err := io.ReadFull(cr.r, hdr[:1]); err == io.EOF {
+		cr.done = true
+		return Record{}, io.EOF
+	} else if err != nil {
+		return Record{}, newErr(false, false, err)
+	}
+	if hdr[0] == 0 { //the index follows the last record
+		cr.done = true
+		return Record{}, io.EOF
+	}
+	if _, err := io.ReadFull(cr.r, hdr[1:]); err != nil {
+		return Record{}, newErr(false, false, io.ErrUnexpectedEOF)
+	}
+	rec := Record{
+		Direction: Direction(hdr[0]),
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(hdr[1:]))),
+		Bytes:     make([]byte, binary.BigEndian.Uint32(hdr[9:])),
+	}
+	if rec.Direction != Rx && rec.Direction != Tx {
+		return Record{}, newErr(false, false, fmt.Errorf("corrupt capture record at offset %d", cr.offset))
+	}
+	if _, err := io.ReadFull(cr.r, rec.Bytes); err != nil {
+		return Record{}, newErr(false, false, io.ErrUnexpectedEOF)
+	}
+	cr.offset += int64(captureRecordHdr + len(rec.Bytes))
+	cr.count++
+	return rec, nil
+}
+
+/*ReadAll returns the remaining Records*/
+func (cr *CaptureReader) ReadAll() ([]Record, error) {
+	var recs []Record
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+/*
+Seek positions the reader so that Next returns the first record at or after t,
+using the index to skip most of the capture.  It requires the underlying
+reader to be an io.ReadSeeker.  Records are assumed to be in time order.
+*/
+func (cr *CaptureReader) Seek(t time.Time) error {
+	offset, count := cr.first, uint64(0)
+	if i := sort.Search(len(cr.index), func(i int) bool { return !cr.index[i].Time.Before(t) }); i > 0 {
+		offset, count = cr.index[i-1].Offset, cr.index[i-1].Record
+	}
+	if err := cr.seekTo(offset, count); err != nil {
+		return err
+	}
+	for {
+		pos, n := cr.offset, cr.count
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !rec.Time.Before(t) {
+			return cr.seekTo(pos, n)
+		}
+	}
+}
+
+/*seekTo moves the underlying reader to offset, which is the start of record number count*/
+func (cr *CaptureReader) seekTo(offset int64, count uint64) error {
+	rs, ok := cr.under.(io.ReadSeeker)
+	if !ok {
+		return newErr(false, false, fmt.Errorf("capture can not seek"))
+	}
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return newErr(false, false, err)
+	}
+	cr.r.Reset(rs)
+	cr.offset, cr.count, cr.done = offset, count, false
+	return nil
+}
+
+/*Close closes the underlying reader if it is an io.Closer*/
+func (cr *CaptureReader) Close() error {
+	if c, ok := cr.under.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func captureRecords(n int) []Record {
+	t0 := time.Unix(1500000000, 0)
+	recs := make([]Record, n)
+	for i := range recs {
+		dir := Rx
+		if i%3 == 0 {
+			dir = Tx
+		}
+		recs[i] = Record{Time: t0.Add(time.Duration(i) * time.Millisecond), Direction: dir, Bytes: []byte{byte(i), byte(i >> 8)}}
+	}
+	return recs
+}
+
+func sameRecord(a, b Record) bool {
+	return a.Time.Equal(b.Time) && a.Direction == b.Direction && bytes.Equal(a.Bytes, b.Bytes)
+}
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cap")
+	recs := captureRecords(1000)
+	cw, err := CreateCapture(path, "tcp://localhost:1234")
+	if err != nil {
+		t.Fatal("Unable to create capture", err)
+	}
+	for _, r := range recs {
+		if err := cw.WriteRecord(r); err != nil {
+			t.Fatal("Unable to write record", err)
+		}
+	}
+	if err := cw.WriteRecord(Record{Direction: 7}); err == nil {
+		t.Error("Expected an invalid direction to be refused")
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal("Unable to close capture", err)
+	}
+
+	cr, err := OpenCapture(path)
+	if err != nil {
+		t.Fatal("Unable to open capture", err)
+	}
+	defer cr.Close()
+	if cr.Label() != "tcp://localhost:1234" || len(cr.Index()) != 4 {
+		t.Error("Unexpected label or index", cr.Label(), cr.Index())
+	}
+	got, err := cr.ReadAll()
+	if err != nil || len(got) != len(recs) {
+		t.Fatal("Expected every record back", len(got), err)
+	}
+	for i := range recs {
+		if !sameRecord(got[i], recs[i]) {
+			t.Error("Record mismatch at", i, got[i], recs[i])
+			break
+		}
+	}
+
+	for _, i := range []int{0, 1, 255, 256, 700, 999} {
+		if err := cr.Seek(recs[i].Time); err != nil {
+			t.Error("Unable to seek", err)
+		}
+		if r, err := cr.Next(); err != nil || !sameRecord(r, recs[i]) {
+			t.Error("Seek landed on the wrong record", i, r, err)
+		}
+	}
+	cr.Seek(recs[999].Time.Add(time.Second))
+	if _, err := cr.Next(); err != io.EOF {
+		t.Error("Expected EOF seeking past the end", err)
+	}
+}
+
+func TestCapture_Truncated(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recs := captureRecords(10)
+	cw, _ := NewCaptureWriter(buf, "")
+	for _, r := range recs {
+		cw.WriteRecord(r)
+	}
+	cw.Flush() //no index, as if the writer crashed
+	raw := buf.Bytes()[:buf.Len()-1]
+
+	cr, err := NewCaptureReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unable to read capture", err)
+	}
+	if len(cr.Index()) != 0 {
+		t.Error("Expected no index")
+	}
+	got, err := cr.ReadAll()
+	if len(got) != 9 || err == nil {
+		t.Error("Expected 9 records and an error for the truncated one", len(got), err)
+	}
+	if err := cr.Seek(recs[5].Time); err != nil {
+		t.Error("Expected to seek without an index", err)
+	}
+	if r, err := cr.Next(); err != nil || !sameRecord(r, recs[5]) {
+		t.Error("Seek landed on the wrong record", r, err)
+	}
+
+	if _, err := NewCaptureReader(bytes.NewReader([]byte("not a capture"))); err == nil {
+		t.Error("Expected garbage to be refused")
+	}
+	if cr, err := NewCaptureReader(bytes.NewBuffer(raw)); err != nil {
+		t.Error("Expected a plain reader to work", err)
+	} else if err := cr.Seek(time.Now()); err == nil {
+		t.Error("Expected Seek to fail on a plain reader")
+	}
+}
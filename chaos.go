@@ -0,0 +1,254 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &Chaos{}
+
+func init() {
+	known["chaos"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewChaosClient(ctx, dur, dial)
+	}
+}
+
+/*ChaosConfig describes the faults a Chaos injects.  Probabilities are in [0, 1].*/
+type ChaosConfig struct {
+	Delay   time.Duration //added to every Read and Write
+	Jitter  time.Duration //at most this much more is added at random
+	Short   float64       //probability a Read or Write is cut short
+	Corrupt float64       //probability each byte read or written has a bit flipped
+	Drop    float64       //probability a Read or Write finds the connection dropped
+	Seed    int64         //of the faults, so runs may be repeated; 0 picks one at random
+}
+
+/*
+Chaos wraps an IDoIO, injecting faults into its traffic, so that timeout and
+reconnect handling may be exercised without flaky hardware.  Short writes
+return temporary errors, as io.Writer requires an error when not everything
+was written.  Once dropped, every Read and Write fails permanently until Open
+is called, which reopens the IDoIO wrapped.
+*/
+type Chaos struct {
+	idotoo  IDoIO
+	cfg     ChaosConfig
+	dial    string     //empty unless built from one
+	mux     sync.Mutex //guards rnd and dropped
+	rnd     *rand.Rand
+	dropped bool
+}
+
+/*NewChaos returns a Chaos over idoio injecting the faults of cfg*/
+func NewChaos(idoio IDoIO, cfg ChaosConfig) *Chaos {
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return &Chaos{idotoo: idoio, cfg: cfg, rnd: rand.New(rand.NewSource(cfg.Seed))}
+}
+
+/*
+NewChaosClient opens a Chaos over another dial string.  Dial should be in the
+form of
+
+	chaos://(<dial>)?[delay=<duration>][&jitter=<duration>][&short=<p>][&corrupt=<p>][&drop=<p>][&seed=<n>]
+
+eg "chaos://(tcp://localhost:4000)?jitter=50ms&short=0.1&drop=0.01".
+*/
+func NewChaosClient(ctx context.Context, timeout time.Duration, dial string) (*Chaos, error) {
+	d, err := dialFor(dial, "chaos")
+	dials, query := d.Dials, d.Params.Encode()
+	if err == nil && len(dials) != 1 {
+		err = fmt.Errorf("exactly one dial string may be wrapped")
+	}
+	if err == nil && factoryFor(dials[0]) == nil {
+		err = fmt.Errorf("no known way to open %q", dials[0])
+	}
+	var cfg ChaosConfig
+	if err == nil {
+		cfg, err = parseChaos(query)
+	}
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+	idoio, err := NewIDoIO(ctx, timeout, dials[0])
+	c := NewChaos(idoio, cfg)
+	c.dial = dial
+	return c, err
+}
+
+func parseChaos(query string) (cfg ChaosConfig, err error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return cfg, err
+	}
+	for k, v := range q {
+		switch k {
+		case "delay":
+			cfg.Delay, err = time.ParseDuration(v[0])
+		case "jitter":
+			cfg.Jitter, err = time.ParseDuration(v[0])
+		case "short":
+			cfg.Short, err = parseProbability(v[0])
+		case "corrupt":
+			cfg.Corrupt, err = parseProbability(v[0])
+		case "drop":
+			cfg.Drop, err = parseProbability(v[0])
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(v[0], 10, 64)
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability must be in [0, 1], not %q", s)
+	}
+	return p, nil
+}
+
+func (c *Chaos) dialString() string {
+	if c.dial != "" {
+		return c.dial
+	}
+	return dialOf(c.idotoo)
+}
+
+/*String conforms to fmt.Stringer*/
+func (c *Chaos) String() string {
+	return fmt.Sprintf("Chaos over %v", c.idotoo)
+}
+
+/*Config returns the faults injected, including the seed picked if none was given*/
+func (c *Chaos) Config() ChaosConfig { return c.cfg }
+
+/*Open conforms to IDoIO, reopening the IDoIO wrapped, and ending any drop*/
+func (c *Chaos) Open() error {
+	c.mux.Lock()
+	c.dropped = false
+	c.mux.Unlock()
+	return c.idotoo.Open()
+}
+
+/*Close conforms to io.Closer, closing the IDoIO wrapped*/
+func (c *Chaos) Close() error { return c.idotoo.Close() }
+
+/*Read conforms to io.Reader, injecting faults*/
+func (c *Chaos) Read(b []byte) (int, error) {
+	if !c.before() {
+		return 0, readErr
+	}
+	if c.chance(c.cfg.Short) && len(b) > 1 {
+		b = b[:1+c.intn(len(b)-1)]
+	}
+	n, err := c.idotoo.Read(b)
+	c.corrupt(b[:n])
+	return n, err
+}
+
+/*Write conforms to io.Writer, injecting faults*/
+func (c *Chaos) Write(b []byte) (int, error) {
+	if !c.before() {
+		return 0, writeErr
+	}
+	short := c.chance(c.cfg.Short) && len(b) > 1
+	wb := append([]byte{}, b...)
+	if short {
+		wb = wb[:1+c.intn(len(b)-1)]
+	}
+	c.corrupt(wb)
+	n, err := c.idotoo.Write(wb)
+	if err == nil && short {
+		err = newErr(true, false, fmt.Errorf("write: cut short"))
+	}
+	return n, err
+}
+
+/*before delays, and decides if the connection drops, returning false if it is dropped*/
+func (c *Chaos) before() bool {
+	d := c.cfg.Delay
+	if c.cfg.Jitter > 0 {
+		d += time.Duration(c.int63n(int64(c.cfg.Jitter) + 1))
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.dropped && c.cfg.Drop > 0 && c.rnd.Float64() < c.cfg.Drop {
+		c.dropped = true
+	}
+	return !c.dropped
+}
+
+func (c *Chaos) corrupt(b []byte) {
+	if c.cfg.Corrupt <= 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i := range b {
+		if c.rnd.Float64() < c.cfg.Corrupt {
+			b[i] ^= 1 << c.rnd.Intn(8)
+		}
+	}
+}
+
+func (c *Chaos) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.rnd.Float64() < p
+}
+
+func (c *Chaos) intn(n int) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.rnd.Intn(n)
+}
+
+func (c *Chaos) int63n(n int64) int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.rnd.Int63n(n)
+}
@@ -0,0 +1,127 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"math/bits"
+	"testing"
+	"time"
+)
+
+/*readAll reads from idoio until n bytes have arrived, or a second passes*/
+func readAll(idoio IDoIO, n int) []byte {
+	var got []byte
+	buf := make([]byte, 64)
+	for end := time.Now().Add(time.Second); len(got) < n && time.Now().Before(end); {
+		k, _ := idoio.Read(buf)
+		got = append(got, buf[:k]...)
+	}
+	return got
+}
+
+func TestChaos(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	near, far := NewMemPair(ctx)
+
+	msg := []byte("the quick brown fox")
+	corrupt := NewChaos(near, ChaosConfig{Corrupt: 1, Seed: 42})
+	_ = corrupt.String()
+	if corrupt.Config().Seed != 42 {
+		t.Error("Expected the seed given")
+	}
+	if n, err := corrupt.Write(msg); n != len(msg) || err != nil {
+		t.Error("Unable to write", n, err)
+	}
+	got := readAll(far, len(msg))
+	for i := range got {
+		if bits.OnesCount8(got[i]^msg[i]) != 1 {
+			t.Errorf("Expected a single bit of byte %d flipped, got %q", i, got)
+			break
+		}
+	}
+	again := NewChaos(near, ChaosConfig{Corrupt: 1, Seed: 42})
+	again.Write(msg)
+	if repeat := readAll(far, len(msg)); !bytes.Equal(repeat, got) {
+		t.Errorf("Expected the same seed to corrupt the same way, got %q and %q", got, repeat)
+	}
+	if NewChaos(near, ChaosConfig{}).Config().Seed == 0 {
+		t.Error("Expected a seed to be picked")
+	}
+
+	short := NewChaos(near, ChaosConfig{Short: 1, Delay: 20 * time.Millisecond})
+	start := time.Now()
+	n, err := short.Write(msg)
+	if n >= len(msg) || err == nil || !IsTemporary(err) {
+		t.Error("Expected a short write with a temporary error", n, err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("Expected the write delayed")
+	}
+	readAll(far, n)
+	far.Write(msg)
+	n = 0
+	for i := 0; i < 1000 && n == 0; i++ {
+		n, _ = short.Read(make([]byte, len(msg)))
+	}
+	if n == 0 || n >= len(msg) {
+		t.Error("Expected a short read, got", n)
+	}
+	readAll(near, len(msg)-n)
+
+	drop := NewChaos(near, ChaosConfig{Drop: 1})
+	if _, err := drop.Write(msg); err == nil || IsTemporary(err) {
+		t.Error("Expected a dropped connection", err)
+	}
+	drop.cfg.Drop = 0
+	if _, err := drop.Read(make([]byte, 4)); err == nil || IsTemporary(err) {
+		t.Error("Expected the connection to stay dropped until opened", err)
+	}
+	drop.Open()
+	if _, err := drop.Write(msg); err != nil {
+		t.Error("Expected writes to work once opened", err)
+	}
+}
+
+func TestChaosClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"chaos://(null://)?drop=2", "chaos://(null://)?delay=soon", "chaos://(null://)?x=1", "chaos://(bogus://x)", "chaos://(null://)?seed=x"} {
+		if _, err := NewChaosClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, "chaos://(null://)?jitter=5ms&short=0.5&corrupt=0.01&drop=0&seed=7")
+	if err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	c := idoio.(*Chaos)
+	defer c.Close()
+	if cfg := c.Config(); cfg.Jitter != 5*time.Millisecond || cfg.Short != 0.5 || cfg.Corrupt != 0.01 || cfg.Seed != 7 {
+		t.Error("Expected the faults parsed", cfg)
+	}
+}
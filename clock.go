@@ -0,0 +1,167 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+TimeParser extracts the device's clock reading from the (successful) Response
+to a time-query Command
+*/
+type TimeParser func(Response) (time.Time, error)
+
+/*ClockSample is a single round trip of a time-query Command*/
+type ClockSample struct {
+	Sent     time.Time     //host time the command was issued
+	Received time.Time     //host time the response was matched
+	Device   time.Time     //device time parsed from the response
+	RTT      time.Duration //Received - Sent
+	Offset   time.Duration //Device - the midpoint of Sent and Received; positive means the device is ahead
+}
+
+/*
+ClockStats summarizes a series of ClockSamples.  Offset is taken from the
+sample with the smallest RTT, as it has the least uncertainty (+/- RTT/2).
+Drift is the least-squares slope of offset against host time, i.e. how many
+seconds the device clock gains per second of host time, and is zero with fewer
+than two samples.
+*/
+type ClockStats struct {
+	Samples      []ClockSample
+	Failures     int //number of exchanges that failed or could not be parsed
+	MinRTT       time.Duration
+	MaxRTT       time.Duration
+	MeanRTT      time.Duration
+	StdDevRTT    time.Duration
+	Offset       time.Duration
+	MeanOffset   time.Duration
+	StdDevOffset time.Duration
+	Drift        float64
+}
+
+/*String conforms to fmt.Stringer*/
+func (cs ClockStats) String() string {
+	return fmt.Sprintf("%d samples (%d failed) rtt min/mean/max/sd %v/%v/%v/%v offset %v (mean %v sd %v) drift %.3gs/s",
+		len(cs.Samples), cs.Failures, cs.MinRTT, cs.MeanRTT, cs.MaxRTT, cs.StdDevRTT, cs.Offset, cs.MeanOffset, cs.StdDevOffset, cs.Drift)
+}
+
+/*
+MeasureClock issues cmd over arb n times, waiting interval between each, and
+uses parse to read the device's clock from each response. The round trip time
+of each exchange, and the device's clock offset and drift relative to the host
+are estimated from the results.  Samples that fail are counted but otherwise
+ignored; an error is only returned if ctx is done before any sample succeeds,
+or if every sample fails.
+*/
+func MeasureClock(ctx context.Context, arb Arbiter, cmd Command, parse TimeParser, n int, interval time.Duration) (ClockStats, error) {
+	stats := ClockStats{}
+	var last error
+loop:
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-time.After(interval):
+			}
+		}
+		sent := time.Now()
+		rsp := arb.Control(cmd)
+		rcvd := time.Now()
+		if rsp.Error != nil {
+			stats.Failures, last = stats.Failures+1, rsp.Error
+			continue
+		}
+		dev, err := parse(rsp)
+		if err != nil {
+			stats.Failures, last = stats.Failures+1, err
+			continue
+		}
+		rtt := rcvd.Sub(sent)
+		stats.Samples = append(stats.Samples, ClockSample{
+			Sent:     sent,
+			Received: rcvd,
+			Device:   dev,
+			RTT:      rtt,
+			Offset:   dev.Sub(sent.Add(rtt / 2)),
+		})
+	}
+	if len(stats.Samples) == 0 {
+		if last == nil {
+			last = ctx.Err()
+		}
+		if last == nil {
+			last = fmt.Errorf("%d samples requested", n)
+		}
+		return stats, newErr(false, false, errors.Wrap(last, "no clock samples succeeded"))
+	}
+	stats.summarize()
+	return stats, nil
+}
+
+/*summarize computes the statistics over the Samples*/
+func (cs *ClockStats) summarize() {
+	n := float64(len(cs.Samples))
+	var rttSum, offSum float64
+	best := cs.Samples[0]
+	cs.MinRTT, cs.MaxRTT = best.RTT, best.RTT
+	for _, s := range cs.Samples {
+		rttSum += float64(s.RTT)
+		offSum += float64(s.Offset)
+		if s.RTT < cs.MinRTT {
+			cs.MinRTT, best = s.RTT, s
+		}
+		if s.RTT > cs.MaxRTT {
+			cs.MaxRTT = s.RTT
+		}
+	}
+	rttMean, offMean := rttSum/n, offSum/n
+	cs.MeanRTT, cs.MeanOffset, cs.Offset = time.Duration(rttMean), time.Duration(offMean), best.Offset
+
+	var rttVar, offVar, sxx, sxy float64
+	t0 := cs.Samples[0].Sent
+	var xMean float64
+	for _, s := range cs.Samples {
+		xMean += s.Sent.Sub(t0).Seconds() / n
+	}
+	for _, s := range cs.Samples {
+		rttVar += math.Pow(float64(s.RTT)-rttMean, 2) / n
+		offVar += math.Pow(float64(s.Offset)-offMean, 2) / n
+		dx := s.Sent.Sub(t0).Seconds() - xMean
+		sxx += dx * dx
+		sxy += dx * (s.Offset.Seconds() - offMean/float64(time.Second))
+	}
+	cs.StdDevRTT, cs.StdDevOffset = time.Duration(math.Sqrt(rttVar)), time.Duration(math.Sqrt(offVar))
+	if sxx > 0 {
+		cs.Drift = sxy / sxx
+	}
+}
@@ -0,0 +1,95 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*clockHandler answers every request with its clock, running 2 seconds fast*/
+func clockHandler(t *testing.T, con net.Conn) {
+	t.Helper()
+	defer con.Close()
+	buf := make([]byte, 1024)
+	for {
+		if _, err := con.Read(buf); err != nil {
+			return
+		}
+		fmt.Fprintf(con, "T=%d\n", time.Now().Add(2*time.Second).UnixNano())
+	}
+}
+
+var clockCmd = Command{
+	Name:      "time",
+	Timeout:   200 * time.Millisecond,
+	Prototype: "TIME?\n",
+	Response:  regexp.MustCompile(`T=\d+\n`),
+}
+
+func parseClock(rsp Response) (time.Time, error) {
+	m := regexp.MustCompile(`T=(\d+)`).FindSubmatch(rsp.Bytes)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("no time in %q", rsp.Bytes)
+	}
+	ns, err := strconv.ParseInt(string(m[1]), 10, 64)
+	return time.Unix(0, ns), err
+}
+
+func TestMeasureClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, clockHandler)
+	a, err := NewArbiter(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Error("Unable to dial", err)
+		t.FailNow()
+	}
+	defer a.Close()
+
+	stats, err := MeasureClock(ctx, a, clockCmd, parseClock, 5, time.Millisecond)
+	if err != nil || len(stats.Samples) != 5 || stats.Failures != 0 {
+		t.Error("Expected 5 good samples", stats, err)
+		t.FailNow()
+	}
+	_ = stats.String()
+	if off := stats.Offset - 2*time.Second; off < -50*time.Millisecond || off > 50*time.Millisecond {
+		t.Error("Expected an offset of about 2s, got", stats.Offset)
+	}
+	if stats.MinRTT <= 0 || stats.MinRTT > stats.MeanRTT || stats.MeanRTT > stats.MaxRTT {
+		t.Error("Inconsistent RTT statistics", stats)
+	}
+
+	bad := func(Response) (time.Time, error) { return time.Time{}, fmt.Errorf("nope") }
+	if stats, err := MeasureClock(ctx, a, clockCmd, bad, 2, time.Millisecond); err == nil || stats.Failures != 2 {
+		t.Error("Expected failure when nothing parses", stats, err)
+	}
+}
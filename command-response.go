@@ -69,8 +69,49 @@ type Command struct {
 	//Error is a regexp that should match bad/negative/failure responses
 	Error *regexp.Regexp
 
+	/*Lookback, if positive, limits how much of what has already been received
+	  Response and Error are run over again as more arrives, for large responses
+	  that would otherwise be rescanned in full each time: a match must then
+	  start within Lookback bytes of the new data.  Regexps with a bounded match
+	  length that are not anchored to what precedes them (^, \b, ...) need no
+	  Lookback, as they are only ever run over what might hold a new match*/
+	Lookback int
+
+	/*MaxResponseBytes, if positive, bounds what is held of the response while
+	  waiting on Response or Error, for devices that may babble without ever
+	  matching either.  Overflow says what happens once it is exceeded.  Zero
+	  uses the package default (see SetDefaultResponseLimit), and negative
+	  means no limit*/
+	MaxResponseBytes int
+	Overflow         OverflowPolicy
+
 	//Description is a human-readable string of a brief explanation of the commands purpose
 	Description string
+
+	/*Urgent commands (abort, safe shutdown, emergency stop, ...) are sent at
+	  the next exchange boundary, ahead of any routine commands already waiting
+	  for the Arbiter.  Urgent is short for a Priority of PriorityUrgent*/
+	Urgent bool
+
+	/*Priority orders the command among others waiting for the Arbiter, the
+	  highest first (see WithCaller for how callers share a priority)*/
+	Priority Priority
+
+	/*Retries is how many more times the command is re-issued after failing as
+	  RetryOn says, for idempotent commands over flaky links.  The first retry
+	  waits RetryBackoff, which doubles with each one after.  The Response is
+	  that of the last attempt*/
+	Retries      int
+	RetryBackoff time.Duration
+	RetryOn      RetryCondition
+}
+
+/*priority returns the Priority cmd waits for an Arbiter with*/
+func (c Command) priority() Priority {
+	if c.Urgent && c.Priority < PriorityUrgent {
+		return PriorityUrgent
+	}
+	return c.Priority
 }
 
 /*sanitize turns de-renders ASCII control seq to to readable equivalents*/
@@ -222,9 +263,37 @@ Error is one of:
 Duration is the duration the command took before it succeeded (or failed).
 */
 type Response struct {
-	Bytes    []byte        //Raw bytes read or received.  In Control funcs, this is the raw value that matched the 'match' clause
-	Error    error         //any non-nil errors
-	Duration time.Duration //how long did the request take
+	Bytes     []byte        //Raw bytes read or received.  In Control funcs, this is the raw value that matched the 'match' clause
+	Error     error         //any non-nil errors
+	Duration  time.Duration //how long did the request take
+	Sent      time.Time     //when the command was written out, zero if it could not be
+	FirstByte time.Time     //when the first byte of the response arrived, zero if none did
+	Matched   time.Time     //when the response matched the success or failure criteria, zero if it did not
+	Attempts  int           //how many times the command was sent, more than once if retried (see Command.Retries)
+}
+
+/*
+Latency returns the time between the command being sent and the first byte of
+the response arriving, which is largely the device's processing delay.  It is
+zero if either is unknown.
+*/
+func (r Response) Latency() time.Duration {
+	if r.Sent.IsZero() || r.FirstByte.IsZero() {
+		return 0
+	}
+	return r.FirstByte.Sub(r.Sent)
+}
+
+/*
+Transfer returns the time between the first byte of the response arriving
+and the response matching, which is largely the time taken to transfer it.  It
+is zero if either is unknown.
+*/
+func (r Response) Transfer() time.Duration {
+	if r.FirstByte.IsZero() || r.Matched.IsZero() {
+		return 0
+	}
+	return r.Matched.Sub(r.FirstByte)
 }
 
 // String implements the Stringer interface
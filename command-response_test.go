@@ -195,6 +195,14 @@ func TestResponse_String(t *testing.T) {
 	if resp.String() != `Response> Rx Bytes: "a"	Errors: <nil>	Duration: 1s` {
 		t.Fatalf("Response String() func not working")
 	}
+	if resp.Latency() != 0 || resp.Transfer() != 0 {
+		t.Error("Expected no latency or transfer time without timestamps")
+	}
+	resp.Sent = time.Now()
+	resp.FirstByte, resp.Matched = resp.Sent.Add(time.Second), resp.Sent.Add(3*time.Second)
+	if resp.Latency() != time.Second || resp.Transfer() != 2*time.Second {
+		t.Error("Unexpected latency or transfer time", resp.Latency(), resp.Transfer())
+	}
 }
 
 func TestCommands_Contains(t *testing.T) {
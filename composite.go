@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+splitComposite splits the dial string of a scheme wrapping others, of the form
+
+	<scheme>://(<dial>[,<dial>...])[?<query>]
+
+into the dial strings wrapped and the query.  Wrapped dial strings may
+themselves be composite, as commas within parentheses are not split upon.
+*/
+func splitComposite(scheme, dial string) (dials []string, query string, err error) {
+	rest, ok := strings.CutPrefix(dial, scheme+"://(")
+	if !ok {
+		return nil, "", fmt.Errorf("dial string not in correct form")
+	}
+	depth, start := 1, 0
+	for i, r := range rest {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 1:
+			depth--
+		case r == ',' && depth == 1, r == ')':
+			d := strings.TrimSpace(rest[start:i])
+			if d == "" {
+				return nil, "", fmt.Errorf("empty dial string in %q", dial)
+			}
+			dials, start = append(dials, d), i+1
+			if r == ')' {
+				tail := rest[start:]
+				if tail != "" && tail[0] != '?' {
+					return nil, "", fmt.Errorf("dial string not in correct form")
+				}
+				return dials, strings.TrimPrefix(tail, "?"), nil
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("unbalanced parentheses in %q", dial)
+}
@@ -0,0 +1,52 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitComposite(t *testing.T) {
+	for _, tc := range []struct {
+		dial  string
+		dials []string
+		query string
+	}{
+		{"x://(tcp://a:1)", []string{"tcp://a:1"}, ""},
+		{"x://(tcp://a:1, tcp://b:2?proxy=socks5://p:1080)?log=/tmp/raw.bin", []string{"tcp://a:1", "tcp://b:2?proxy=socks5://p:1080"}, "log=/tmp/raw.bin"},
+		{"x://(y://(tcp://a:1,tcp://b:2)?z=1,serial:///dev/ttyS0:9600)", []string{"y://(tcp://a:1,tcp://b:2)?z=1", "serial:///dev/ttyS0:9600"}, ""},
+	} {
+		dials, query, err := splitComposite("x", tc.dial)
+		if err != nil || !reflect.DeepEqual(dials, tc.dials) || query != tc.query {
+			t.Errorf("Expected %q %q from %q, got %q %q %v", tc.dials, tc.query, tc.dial, dials, query, err)
+		}
+	}
+	for _, dial := range []string{"x://tcp://a:1", "y://(tcp://a:1)", "x://(tcp://a:1", "x://(tcp://a:1,)", "x://()", "x://(tcp://a:1)z"} {
+		if _, _, err := splitComposite("x", dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+}
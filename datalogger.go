@@ -0,0 +1,346 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*DefaultDataLoggerTemplate is used when DataLoggerConfig.Template is empty*/
+const DefaultDataLoggerTemplate = `agnoio-{{.Time.Format "20060102T150405Z"}}-{{.Seq}}.raw`
+
+/*DataLoggerConfig controls where and how a DataLogger archives data*/
+type DataLoggerConfig struct {
+	/*Template is a text/template that is executed to form the path of each new
+	  file. The following fields are available:
+	      .Time  the time.Time (UTC) the file was started
+	      .Seq   the number of files started by this DataLogger, starting at 0
+	      .Name  the String() of the IDoIO being logged
+	  e.g. "/data/gps/{{.Time.Format "2006/01/02"}}/gps-{{.Time.Format "150405"}}.raw"
+	  Any missing directories are created.  Should it form the same name for
+	  the next file, or one whose compressed archive exists, a sequence number
+	  is added before the extension (gps.raw, gps.1.raw, gps.2.raw, ...) rather
+	  than reusing it.*/
+	Template string
+
+	//MaxSize is the size, in bytes, at which a file is closed and a new one started. Zero means no limit.
+	MaxSize int64
+
+	/*MaxAge rotates files on multiples of MaxAge since the zero time, e.g. on
+	  the hour when MaxAge is time.Hour.  Zero means files are never rotated on time.*/
+	MaxAge time.Duration
+
+	//Gzip compresses each file, appending ".gz" to its name, once it has been rotated or closed
+	Gzip bool
+
+	//ReopenDelay is the delay between attempts to reopen the IDoIO after a non-temporary error. Defaults to 1s.
+	ReopenDelay time.Duration
+}
+
+/*
+DataLogger continuously reads from an IDoIO and archives everything received
+into size and/or time rotated files.  Non-temporary read errors cause the IDoIO
+to be reopened (every ReopenDelay, until it succeeds), so the DataLogger
+survives reconnects for as long as it is running.
+
+The DataLogger is the only reader of the IDoIO while it runs, but the IDoIO
+remains owned by the caller, and is not closed when the DataLogger is.
+*/
+type DataLogger struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	idotoo IDoIO
+	cfg    DataLoggerConfig
+	tmpl   *template.Template
+	done   chan struct{}
+	zipper sync.WaitGroup //outstanding compressions
+
+	mux    sync.Mutex //guards everything below
+	file   *os.File
+	opened time.Time
+	size   int64
+	seq    int
+	last   string //the name the template formed for the current file
+	err    error
+}
+
+/*
+NewDataLogger starts logging idoio according to cfg, until ctx is cancelled or
+Close is called.  An error is only returned if cfg is unusable.
+*/
+func NewDataLogger(ctx context.Context, idoio IDoIO, cfg DataLoggerConfig) (*DataLogger, error) {
+	if cfg.Template == "" {
+		cfg.Template = DefaultDataLoggerTemplate
+	}
+	if cfg.ReopenDelay <= 0 {
+		cfg.ReopenDelay = 1 * time.Second
+	}
+	tmpl, err := template.New("datalogger").Parse(cfg.Template)
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrap(err, "invalid file name template"))
+	}
+	dctx, cancel := context.WithCancel(ctx)
+	d := &DataLogger{
+		ctx:    dctx,
+		cancel: cancel,
+		idotoo: idoio,
+		cfg:    cfg,
+		tmpl:   tmpl,
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d, nil
+}
+
+/*String conforms to fmt.Stringer*/
+func (d *DataLogger) String() string {
+	return "DataLogger over " + d.idotoo.String()
+}
+
+/*File returns the path of the file currently being written, if any*/
+func (d *DataLogger) File() string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.file == nil {
+		return ""
+	}
+	return d.file.Name()
+}
+
+/*Err returns the most recent error writing to the archive, if any*/
+func (d *DataLogger) Err() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.err
+}
+
+/*Rotate closes the current file, if any. The next data received starts a new one.*/
+func (d *DataLogger) Rotate() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.rotate()
+}
+
+/*
+Close stops the DataLogger, closes the current file and waits for any
+compression to complete.  The underlying IDoIO is left as is.
+*/
+func (d *DataLogger) Close() error {
+	d.cancel()
+	<-d.done
+	d.mux.Lock()
+	err := d.rotate()
+	d.mux.Unlock()
+	d.zipper.Wait()
+	return err
+}
+
+/*run is the read loop, which exits when d.ctx is done*/
+func (d *DataLogger) run() {
+	defer close(d.done)
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		default:
+		}
+		n, err := d.idotoo.Read(buf)
+		if n > 0 {
+			d.write(buf[:n])
+		}
+		if err == nil || IsTemporary(err) {
+			continue
+		}
+		d.reopen(err)
+	}
+}
+
+/*reopen repeatedly reopens the IDoIO until it succeeds or d.ctx is done*/
+func (d *DataLogger) reopen(cause error) {
+	l := LoggerFrom(d.ctx)
+	l.Warn("data logger lost its connection", "event", EventError, "dial", dialOf(d.idotoo), "error", cause)
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(d.cfg.ReopenDelay):
+		}
+		l.Info("data logger reopening connection", "event", EventRetry, "dial", dialOf(d.idotoo))
+		if err := d.idotoo.Open(); err == nil {
+			return
+		}
+	}
+}
+
+/*
+write archives b, rotating files as required. Chunks that would take a file
+past MaxSize are split across files.
+*/
+func (d *DataLogger) write(b []byte) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	now := time.Now().UTC()
+	for len(b) > 0 {
+		if d.file != nil && d.due(now) {
+			d.rotate()
+		}
+		if d.file == nil {
+			if d.err = d.create(now); d.err != nil {
+				return
+			}
+		}
+		chunk := b
+		if room := d.cfg.MaxSize - d.size; d.cfg.MaxSize > 0 && int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := d.file.Write(chunk)
+		d.size += int64(n)
+		if err != nil {
+			d.err = errors.Wrapf(err, "unable to write to %q", d.file.Name())
+			return
+		}
+		b = b[n:]
+	}
+}
+
+/*due returns true if the current file should be rotated before writing to it*/
+func (d *DataLogger) due(now time.Time) bool {
+	if d.cfg.MaxSize > 0 && d.size >= d.cfg.MaxSize {
+		return true
+	}
+	if d.cfg.MaxAge > 0 && !now.Truncate(d.cfg.MaxAge).Equal(d.opened.Truncate(d.cfg.MaxAge)) {
+		return true
+	}
+	return false
+}
+
+/*create starts a new file. The caller must hold d.mux.*/
+func (d *DataLogger) create(now time.Time) error {
+	name := &bytes.Buffer{}
+	if err := d.tmpl.Execute(name, struct {
+		Time time.Time
+		Seq  int
+		Name string
+	}{now, d.seq, d.idotoo.String()}); err != nil {
+		return errors.Wrap(err, "unable to form file name")
+	}
+	path := d.unused(name.String())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "unable to create directory")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to create file")
+	}
+	d.file, d.opened, d.size = f, now, 0
+	d.last = name.String()
+	d.seq++
+	return nil
+}
+
+/*
+unused returns path, unless the template formed it for the current file too,
+or compressing it would overwrite an archive.  Then it returns the first of
+path with a sequence number added that names no file, compressed or not, on
+disk. The caller must hold d.mux.
+*/
+func (d *DataLogger) unused(path string) string {
+	if path != d.last && !(d.cfg.Gzip && exists(path+".gz")) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	for i := 1; ; i++ {
+		next := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), i, ext)
+		if !exists(next) && !exists(next+".gz") {
+			return next
+		}
+	}
+}
+
+/*exists reports whether there is a file at path*/
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+/*rotate closes the current file, compressing it if configured. The caller must hold d.mux.*/
+func (d *DataLogger) rotate() error {
+	if d.file == nil {
+		return nil
+	}
+	name := d.file.Name()
+	err := d.file.Close()
+	d.file = nil
+	if d.cfg.Gzip && err == nil {
+		d.zipper.Add(1)
+		go func() {
+			defer d.zipper.Done()
+			if err := gzipFile(name); err != nil {
+				LoggerFrom(d.ctx).Warn("unable to compress archive", "event", EventError, "file", name, "error", err)
+			}
+		}()
+	}
+	return err
+}
+
+/*gzipFile compresses name to name.gz, removing the original on success*/
+func gzipFile(name string) (err error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Remove(name)
+		}
+	}()
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(name)
+	if _, err = io.Copy(zw, in); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,184 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*burstHandler sends a short burst of lines and hangs up*/
+func burstHandler(t *testing.T, con net.Conn) {
+	t.Helper()
+	defer con.Close()
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(con, "line %02d\n", i)
+	}
+}
+
+func TestNewDataLogger(t *testing.T) {
+	if _, err := NewDataLogger(context.Background(), InvalidIO("nope"), DataLoggerConfig{Template: "{{.Broken"}); err == nil {
+		t.Error("A broken template should be an error")
+	}
+}
+
+func TestDataLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, burstHandler)
+
+	idotoo, err := NewIDoIO(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Error("Unable to dial", err)
+		t.FailNow()
+	}
+	defer idotoo.Close()
+
+	dir := t.TempDir()
+	dl, err := NewDataLogger(ctx, idotoo, DataLoggerConfig{
+		Template:    filepath.Join(dir, "sub", "raw-{{.Seq}}.bin"),
+		MaxSize:     32,
+		Gzip:        true,
+		ReopenDelay: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Error("Unable to start the data logger", err)
+		t.FailNow()
+	}
+	_ = dl.String()
+
+	//each burst is 40 bytes, and the server hangs up after each one
+	<-time.After(200 * time.Millisecond)
+	if err := dl.Close(); err != nil {
+		t.Error("Close should not error", err)
+	}
+	if dl.Err() != nil {
+		t.Error("Unexpected archive error", dl.Err())
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "sub", "*.gz"))
+	if len(files) < 3 {
+		t.Errorf("Expected several rotated files, got %v", files)
+	}
+	total := 0
+	for _, name := range files {
+		f, _ := os.Open(name)
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Error("Unable to decompress", name, err)
+			continue
+		}
+		b, _ := io.ReadAll(zr)
+		if len(b) > 32 {
+			t.Errorf("%s is %d bytes, larger than MaxSize", name, len(b))
+		}
+		total += len(b)
+		f.Close()
+	}
+	if total < 80 {
+		t.Errorf("Expected the logger to survive reconnects, only archived %d bytes", total)
+	}
+}
+
+func TestDataLogger_FixedName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	end, dev := NewMemPair(ctx)
+	defer end.Close()
+
+	dir := t.TempDir()
+	dl, err := NewDataLogger(ctx, end, DataLoggerConfig{
+		Template: filepath.Join(dir, "fixed.raw"),
+		MaxSize:  32,
+		Gzip:     true,
+	})
+	if err != nil {
+		t.Fatal("Unable to start the data logger", err)
+	}
+	want := bytes.Repeat([]byte("0123456789"), 10)
+	dev.Write(want)
+	<-time.After(50 * time.Millisecond)
+	if err := dl.Close(); err != nil {
+		t.Error("Close should not error", err)
+	}
+
+	//every rotation needs a name of its own, or data is lost to compression
+	files, _ := filepath.Glob(filepath.Join(dir, "*.gz"))
+	if len(files) != 4 {
+		t.Errorf("Expected 4 compressed files, got %v", files)
+	}
+	got := []byte{}
+	for _, name := range []string{"fixed.raw.gz", "fixed.1.raw.gz", "fixed.2.raw.gz", "fixed.3.raw.gz"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Error("Missing", name, err)
+			continue
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Error("Unable to decompress", name, err)
+			f.Close()
+			continue
+		}
+		b, _ := io.ReadAll(zr)
+		got = append(got, b...)
+		f.Close()
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected everything archived in order, got %q", got)
+	}
+}
+
+func TestDataLogger_Unused(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.raw")
+	d := &DataLogger{cfg: DataLoggerConfig{Gzip: true}}
+	if got := d.unused(path); got != path {
+		t.Error("Expected a new name to be used as is", got)
+	}
+	os.WriteFile(path, nil, 0644)
+	if got := d.unused(path); got != path {
+		t.Error("Expected a file left by another run to be appended to", got)
+	}
+	d.last = path
+	os.WriteFile(filepath.Join(dir, "a.1.raw.gz"), nil, 0644)
+	if got := d.unused(path); got != filepath.Join(dir, "a.2.raw") {
+		t.Error("Expected the name of the current file to be numbered past those on disk", got)
+	}
+	d.last = ""
+	os.WriteFile(path+".gz", nil, 0644)
+	if got := d.unused(path); got != filepath.Join(dir, "a.2.raw") {
+		t.Error("Expected an archive not to be overwritten", got)
+	}
+}
@@ -0,0 +1,348 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+/*
+Dial is a dial string taken apart by ParseDial.  Only the fields that make
+sense for the scheme are filled in.
+*/
+type Dial struct {
+	Scheme string     //lower case, eg "tcp" or "serial"
+	User   string     //the user of ssh://
+	Host   string     //host:port (or just host) of network transports
+	Device string     //device, path or name of local transports, and whatever follows the scheme of unknown ones
+	Baud   int        //bits per second of serial transports
+	Path   string     //what follows the host or device: the /service/method of grpc://, /command of ssh://, number of modem://, address of i2c://
+	Dials  []string   //the dial strings wrapped by composite transports, eg failover://
+	Params url.Values //the query, never nil
+}
+
+/*dialKind tells how the part of a dial string between the scheme and the query is laid out*/
+func dialKind(scheme string) string {
+	switch scheme {
+	case "failover", "tee", "throttle", "chaos", "record", "reconnect":
+		return "composite"
+	case "serial", "rs232", "sbd", "modem":
+		return "baud"
+	case "rfc2217":
+		return "hostbaud"
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "tcp-listen", "tcp4-listen", "tcp6-listen",
+		"udp-listen", "udp4-listen", "udp6-listen", "mcast", "dtls", "zmq", "mqtt", "vsock", "grpc", "ssh":
+		return "host"
+	}
+	return "device"
+}
+
+/*
+ParseDial takes dial apart into its scheme, address, options and so on,
+following the layout of the scheme as documented with the package.  It does
+not check that the scheme is known, or that the values make sense to the
+transport (see NewIDoIO for that); dial strings of unknown schemes are given
+as a Device and Params.  The built in transports read their dial strings
+with it too, so a dial string it refuses is not one of theirs.
+*/
+func ParseDial(dial string) (Dial, error) {
+	m := schemeRe.FindStringSubmatch(dial)
+	if m == nil {
+		return Dial{}, newErr(false, false, fmt.Errorf("no scheme in %q", dial))
+	}
+	d := Dial{Scheme: strings.ToLower(m[1])}
+	rest, query := dial[len(m[0]):], ""
+	kind := dialKind(d.Scheme)
+	if kind == "composite" {
+		var err error
+		if d.Dials, query, err = splitComposite(m[1], dial); err != nil {
+			return Dial{}, newErr(false, false, err)
+		}
+		rest = ""
+	} else if i := strings.IndexByte(rest, '?'); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+	var err error
+	if d.Params, err = url.ParseQuery(query); err != nil {
+		return Dial{}, newErr(false, false, fmt.Errorf("invalid query in %q: %v", dial, err))
+	}
+
+	switch kind {
+	case "baud", "hostbaud":
+		i := strings.LastIndexByte(rest, ':')
+		if i < 0 {
+			return Dial{}, newErr(false, false, fmt.Errorf("no baud rate in %q", dial))
+		}
+		baud := rest[i+1:]
+		if d.Scheme == "modem" {
+			baud, d.Path, _ = strings.Cut(baud, "/")
+		}
+		if d.Baud, err = strconv.Atoi(baud); err != nil || d.Baud <= 0 {
+			return Dial{}, newErr(false, false, fmt.Errorf("invalid baud rate in %q", dial))
+		}
+		if kind == "hostbaud" {
+			d.Host = rest[:i]
+		} else {
+			d.Device = rest[:i]
+		}
+	case "host":
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			rest, d.Path = rest[:i], rest[i:]
+		}
+		if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+			d.User, rest = rest[:i], rest[i+1:]
+		}
+		d.Host = rest
+		switch {
+		case d.Path != "" && d.Scheme != "grpc" && d.Scheme != "ssh":
+			return Dial{}, newErr(false, false, fmt.Errorf("unexpected path in %q", dial))
+		case d.User != "" && d.Scheme != "ssh":
+			return Dial{}, newErr(false, false, fmt.Errorf("unexpected user in %q", dial))
+		case d.Scheme != "grpc" && d.Scheme != "ssh": //the port is optional for these
+			if _, _, err = net.SplitHostPort(d.Host); err != nil {
+				return Dial{}, newErr(false, false, fmt.Errorf("no host:port in %q", dial))
+			}
+		}
+	case "device":
+		d.Device = rest
+		if d.Scheme == "i2c" {
+			if i := strings.LastIndexByte(rest, ':'); i >= 0 {
+				d.Device, d.Path = rest[:i], rest[i+1:]
+			}
+		}
+	}
+	return d, nil
+}
+
+/*
+dialFor is ParseDial for the constructors of transports, also checking that
+the scheme of dial is one of schemes.
+*/
+func dialFor(dial string, schemes ...string) (Dial, error) {
+	d, err := ParseDial(dial)
+	if err != nil {
+		return Dial{}, newErr(false, false, errors.Wrap(err, "dial string not in correct form"))
+	}
+	for _, s := range schemes {
+		if d.Scheme == s {
+			return d, nil
+		}
+	}
+	return Dial{}, newErr(false, false, fmt.Errorf("dial string not in correct form"))
+}
+
+/*noParams fails if d has a query, for transports taking no parameters*/
+func noParams(d Dial) error {
+	for k := range d.Params {
+		return fmt.Errorf("unknown parameter %q", k)
+	}
+	return nil
+}
+
+/*isUSBID reports whether s is a USB vendor or product ID, of four hex digits*/
+func isUSBID(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 16)
+	return len(s) == 4 && err == nil
+}
+
+/*String reassembles the dial string, with the query sorted by key*/
+func (d Dial) String() string {
+	var b strings.Builder
+	b.WriteString(d.Scheme + "://")
+	switch kind := dialKind(d.Scheme); kind {
+	case "composite":
+		b.WriteString("(" + strings.Join(d.Dials, ",") + ")")
+	case "baud", "hostbaud":
+		b.WriteString(d.Host + d.Device + ":" + strconv.Itoa(d.Baud))
+		if d.Path != "" {
+			b.WriteString("/" + d.Path)
+		}
+	case "host":
+		if d.User != "" {
+			b.WriteString(d.User + "@")
+		}
+		b.WriteString(d.Host + d.Path)
+	default:
+		b.WriteString(d.Device)
+		if d.Path != "" {
+			b.WriteString(":" + d.Path)
+		}
+	}
+	if len(d.Params) > 0 {
+		b.WriteString("?" + d.Params.Encode())
+	}
+	return b.String()
+}
+
+/*standardBauds are the baud rates ValidateDial accepts*/
+var standardBauds = map[int]bool{
+	50: true, 75: true, 110: true, 134: true, 150: true, 200: true, 300: true, 600: true,
+	1200: true, 1800: true, 2400: true, 4800: true, 9600: true, 19200: true, 38400: true,
+	57600: true, 115200: true, 230400: true, 250000: true, 460800: true, 500000: true,
+	576000: true, 921600: true, 1000000: true, 1152000: true, 1500000: true, 2000000: true,
+	2500000: true, 3000000: true, 3500000: true, 4000000: true,
+}
+
+/*queryCheckers check the query of the schemes whose parameters can be parsed without building a transport*/
+var queryCheckers = map[string]func(scheme, query string) error{
+	"tcp":  func(s, q string) error { _, err := parseNetOptions(s, q); return err },
+	"tcp4": func(s, q string) error { _, err := parseNetOptions(s, q); return err },
+	"tcp6": func(s, q string) error { _, err := parseNetOptions(s, q); return err },
+	"udp":  func(s, q string) error { _, err := parseNetOptions(s, q); return err },
+	"udp4": func(s, q string) error { _, err := parseNetOptions(s, q); return err },
+	"udp6": func(s, q string) error { _, err := parseNetOptions(s, q); return err },
+	"serial": func(_, q string) error {
+		return (&SerialClient{mode: &serial.Mode{}}).parse(q)
+	},
+	"rs232": func(_, q string) error {
+		return (&SerialClient{mode: &serial.Mode{}}).parse(q)
+	},
+	"throttle":  func(_, q string) error { _, _, err := parseThrottle(q); return err },
+	"chaos":     func(_, q string) error { _, err := parseChaos(q); return err },
+	"reconnect": func(_, q string) error { _, err := parseReconnect(q); return err },
+	"spi":       func(_, q string) error { _, err := parseSPIConfig(q); return err },
+}
+
+/*
+ValidateDial checks dial without opening anything, for configuration linting:
+the scheme (and any layers, see ParseStack) must be known, the dial string in
+the form the transport expects, host:port addresses well formed, baud rates
+standard and, where they can be checked in isolation, the parameters valid.
+The dial strings within composite ones are checked in turn.  Whether devices
+exist is left to ValidateDialDevice.
+*/
+func ValidateDial(dial string) error {
+	_, err := validateDial(dial)
+	return err
+}
+
+/*
+ValidateDialDevice is ValidateDial, also checking that the device of a serial
+or other local transport is present.
+*/
+func ValidateDialDevice(dial string) error {
+	d, err := validateDial(dial)
+	if err != nil {
+		return err
+	}
+	for _, inner := range d.Dials {
+		if err := ValidateDialDevice(inner); err != nil {
+			return err
+		}
+	}
+	switch d.Scheme {
+	case "serial", "rs232":
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sc, err := NewSerialClient(WithLazyOpen(ctx), 0, d.String())
+		if err == nil && !sc.present() {
+			err = fmt.Errorf("device not present")
+		}
+		if err != nil {
+			return newErr(false, false, errors.Wrapf(err, "%q", dial))
+		}
+	case "sbd", "modem", "dmx", "i2c", "spi", "file", "replay", "unixgram":
+		if _, err := os.Stat(d.Device); err != nil {
+			return newErr(false, false, errors.Wrapf(err, "%q", dial))
+		}
+	}
+	return nil
+}
+
+/*validateDial is ValidateDial, returning dial taken apart (without any layers)*/
+func validateDial(dial string) (Dial, error) {
+	if isStackDesc(dial) {
+		s, err := ParseStack(dial)
+		if err != nil {
+			return Dial{}, err
+		}
+		dial = s.Dial
+	}
+	if factoryFor(dial) == nil {
+		return Dial{}, newErr(false, false, fmt.Errorf("no known way to open %q", dial))
+	}
+	d, err := ParseDial(dial)
+	if err != nil {
+		return Dial{}, err
+	}
+	switch kind := dialKind(d.Scheme); kind {
+	case "composite":
+		for _, inner := range d.Dials {
+			if err = ValidateDial(inner); err != nil {
+				break
+			}
+		}
+	case "baud", "hostbaud":
+		if !standardBauds[d.Baud] {
+			err = fmt.Errorf("non-standard baud rate %d", d.Baud)
+		}
+		if err == nil && kind == "hostbaud" {
+			err = validHostPort(d.Scheme, d.Host)
+		}
+	case "host":
+		err = validHostPort(d.Scheme, d.Host)
+	}
+	if check := queryCheckers[d.Scheme]; err == nil && check != nil {
+		err = check(d.Scheme, d.Params.Encode())
+	}
+	if err != nil {
+		return Dial{}, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+	return d, nil
+}
+
+/*validHostPort checks the host:port of a network dial string*/
+func validHostPort(scheme, hostport string) error {
+	if scheme == "ssh" && !strings.Contains(strings.TrimPrefix(hostport, "["), ":") {
+		hostport += ":22" //the port is optional
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return err
+	}
+	if host == "" && !strings.HasSuffix(scheme, "-listen") {
+		return fmt.Errorf("no host in %q", hostport)
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		if n < 0 || n > 65535 {
+			return fmt.Errorf("port %d out of range", n)
+		}
+		return nil
+	}
+	if scheme == "vsock" {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	_, err = net.LookupPort("tcp", port)
+	return err
+}
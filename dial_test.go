@@ -0,0 +1,153 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDial(t *testing.T) {
+	for _, tc := range []struct {
+		dial string
+		want Dial
+	}{
+		{"tcp://localhost:8080", Dial{Scheme: "tcp", Host: "localhost:8080"}},
+		{"udp4://10.0.0.1:5000?broadcast=true", Dial{Scheme: "udp4", Host: "10.0.0.1:5000", Params: map[string][]string{"broadcast": {"true"}}}},
+		{"TCP-listen://[::1]:0", Dial{Scheme: "tcp-listen", Host: "[::1]:0"}},
+		{"serial:///dev/ttyUSB0:9600?parity=even", Dial{Scheme: "serial", Device: "/dev/ttyUSB0", Baud: 9600, Params: map[string][]string{"parity": {"even"}}}},
+		{"serial://vid:pid=0403:6001:A1:115200", Dial{Scheme: "serial", Device: "vid:pid=0403:6001:A1", Baud: 115200}},
+		{"rs232://COM3:4800", Dial{Scheme: "rs232", Device: "COM3", Baud: 4800}},
+		{"rfc2217://ts1:4001:19200", Dial{Scheme: "rfc2217", Host: "ts1:4001", Baud: 19200}},
+		{"modem:///dev/ttyS0:2400/555-1234", Dial{Scheme: "modem", Device: "/dev/ttyS0", Baud: 2400, Path: "555-1234"}},
+		{"ssh://radar@dish:2222/cat /dev/ttyS1?key=id", Dial{Scheme: "ssh", User: "radar", Host: "dish:2222", Path: "/cat /dev/ttyS1", Params: map[string][]string{"key": {"id"}}}},
+		{"grpc://gw:443/acq.Link/Stream", Dial{Scheme: "grpc", Host: "gw:443", Path: "/acq.Link/Stream"}},
+		{"i2c:///dev/i2c-1:0x48", Dial{Scheme: "i2c", Device: "/dev/i2c-1", Path: "0x48"}},
+		{"failover://(tcp://a:1,tee://(tcp://b:2)?log=x)", Dial{Scheme: "failover", Dials: []string{"tcp://a:1", "tee://(tcp://b:2)?log=x"}}},
+		{"throttle://(null://)?rx=10", Dial{Scheme: "throttle", Dials: []string{"null://"}, Params: map[string][]string{"rx": {"10"}}}},
+		{"acme://widget/7?x=1", Dial{Scheme: "acme", Device: "widget/7", Params: map[string][]string{"x": {"1"}}}},
+	} {
+		got, err := ParseDial(tc.dial)
+		if err != nil {
+			t.Errorf("Unable to parse %q: %v", tc.dial, err)
+			continue
+		}
+		if tc.want.Params == nil {
+			tc.want.Params = map[string][]string{}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parsing %q, expected %+v, got %+v", tc.dial, tc.want, got)
+		}
+		again, err := ParseDial(got.String())
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("Expected %q to survive being reassembled as %q", tc.dial, got.String())
+		}
+	}
+	for _, bad := range []string{"localhost:80", "serial:///dev/ttyS0", "serial:///dev/ttyS0:fast", "failover://(tcp://a:1", "tcp://a:1?%zz", "tcp://localhost", "tcp://a:1/x", "udp://me@a:1"} {
+		if _, err := ParseDial(bad); err == nil {
+			t.Errorf("Expected %q to fail", bad)
+		}
+	}
+}
+
+func TestDialFor(t *testing.T) {
+	ctx := WithLazyOpen(context.Background())
+	for _, bad := range []string{
+		"tcp://localhost", "tcp://a:1/x", "mem://a?x=1", "sbd:///dev/null:9600?x=1", "i2c:///dev/null:0x48?x=1",
+		"i2c://dev:0x48", "vsock://host:1?x=1", "vsock://guest:1", "dmx:///dev/null?x=1", "udp-listen://:0?x=1",
+		"hid://zzzz:0001", "ble://00:11:22", "grpc://gw:443/only", "modem:///dev/null:2400/abc",
+		"serial://vid:pid=04:6001:9600", "serial://a:b:9600", "ssh://host:port", "spi://dev",
+	} {
+		if idoio, err := NewIDoIO(ctx, time.Millisecond, bad); err == nil {
+			idoio.Close()
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+	if _, err := dialFor("tcp://a:1", "udp"); err == nil {
+		t.Error("Expected a dial string of another scheme to be refused")
+	}
+	if d, err := dialFor("TCP://a:1", "tcp"); err != nil || d.Host != "a:1" {
+		t.Error("Expected schemes to be case insensitive", d, err)
+	}
+}
+
+func TestValidateDial(t *testing.T) {
+	for _, good := range []string{
+		"tcp://localhost:4001?nodelay=false",
+		"tcp://gps:http",
+		"tcp-listen://:4001",
+		"udp://10.0.0.1:5000?broadcast=true",
+		"ssh://me@host/cmd",
+		"serial:///dev/ttyUSB0:115200?parity=even",
+		"rfc2217://ts:4001:9600",
+		"failover://(tcp://a:1,tcp://b:2)",
+		"throttle://(tcp://a:1)?tx=100",
+		"tcp://localhost:4001 | log",
+		"null://",
+	} {
+		if err := ValidateDial(good); err != nil {
+			t.Errorf("Expected %q to be valid: %v", good, err)
+		}
+	}
+	for _, bad := range []string{
+		"",
+		"nope://x",
+		"tcp://localhost",
+		"tcp://:4001",
+		"tcp://localhost:99999",
+		"tcp://localhost:4001?nodelay=maybe",
+		"tcp://localhost:4001?bogus=1",
+		"serial:///dev/ttyUSB0:12345",
+		"serial:///dev/ttyUSB0:9600?parity=sideways",
+		"rfc2217://ts:9600",
+		"failover://(tcp://a:1,tcp://b)",
+		"throttle://(tcp://a:1)",
+		"tcp://localhost:4001 | nope",
+	} {
+		if err := ValidateDial(bad); err == nil {
+			t.Errorf("Expected %q to be invalid", bad)
+		}
+	}
+
+	dir := t.TempDir()
+	present := filepath.Join(dir, "log")
+	os.WriteFile(present, nil, 0o644)
+	if err := ValidateDialDevice("file://" + present); err != nil {
+		t.Error("Expected a present file to be valid", err)
+	}
+	for _, absent := range []string{
+		"file://" + filepath.Join(dir, "absent"),
+		"serial://" + filepath.Join(dir, "ttyNone") + ":9600",
+		"failover://(tcp://a:1,sbd://" + filepath.Join(dir, "ttyNone") + ":19200)",
+	} {
+		if err := ValidateDialDevice(absent); err == nil {
+			t.Errorf("Expected %q to be missing its device", absent)
+		}
+	}
+}
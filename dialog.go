@@ -0,0 +1,276 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+/*
+Dialog is a script of send/expect Steps, such as a modem's init strings, a
+login prompt, or a device's bring-up sequence, run as one exchange on an
+Arbiter (see DialogRunner) so nothing else is sent in between.  Steps run in
+order, unless a branch sends the dialog elsewhere.  EG:
+
+	agnoio.Dialog{Name: "login", Timeout: 5 * time.Second, Steps: []agnoio.DialogStep{
+		{Name: "user", Send: "\r", Expect: []agnoio.DialogBranch{{Match: regexp.MustCompile(`login:`)}}},
+		{Name: "pass", Send: "${user}\r", Expect: []agnoio.DialogBranch{
+			{Match: regexp.MustCompile(`Password:`)},
+			{Match: regexp.MustCompile(`\$ `), Done: true}, //no password needed
+		}},
+		{Name: "shell", Send: "${password}\r", Expect: []agnoio.DialogBranch{
+			{Match: regexp.MustCompile(`Login incorrect`), Fail: true},
+			{Match: regexp.MustCompile(`(?P<host>\w+)\$ `)},
+		}},
+	}, Vars: map[string]string{"user": "ops", "password": "secret"}}
+*/
+type Dialog struct {
+	Name  string
+	Steps []DialogStep
+
+	//Timeout is used by Steps that do not give their own
+	Timeout time.Duration
+
+	//Vars are the variables Steps may use before any are captured
+	Vars map[string]string
+
+	//Limit is the most Steps run, counting repeats, before giving up. Defaults to 100.
+	Limit int
+}
+
+/*
+DialogStep sends Send, if it is not empty, then waits up to Timeout for the
+first of Expect to match what has been received, or moves straight on if
+there is nothing to expect.  Anything received after a match is kept for the
+next step to expect.  ${name} in Send is replaced by the variable name,
+captured by a named group, eg (?P<name>\d+), in an earlier match or given by
+Dialog.Vars.
+*/
+type DialogStep struct {
+	Name    string //used by DialogBranch.Goto, and in logs
+	Send    string
+	Expect  []DialogBranch
+	Timeout time.Duration
+}
+
+/*
+DialogBranch is one of the responses a DialogStep expects.  Once Match
+matches, the dialog fails with ErrErrorResponse if Fail is set, finishes if
+Done is set, and otherwise carries on from the step named by Goto, or the next
+step if Goto is empty.
+*/
+type DialogBranch struct {
+	Match *regexp.Regexp
+	Goto  string
+	Done  bool
+	Fail  bool
+}
+
+/*DialogResult is the outcome of running a Dialog*/
+type DialogResult struct {
+	Vars      map[string]string //Dialog.Vars, along with everything captured
+	Responses []Response        //of each step run, in order
+	Step      string            //the name of the last step run
+	Error     error
+}
+
+/*
+DialogRunner is implemented by Arbiters that can run a Dialog as a single
+exchange.  Once ctx is done the dialog is given up on, as described by
+ContextArbiter.  Discover it with a type assertion.
+*/
+type DialogRunner interface {
+	RunDialog(ctx context.Context, d Dialog) DialogResult
+}
+
+/*dialogVarRe matches the variables that may be used in DialogStep.Send*/
+var dialogVarRe = regexp.MustCompile(`\$\{(\w+)\}`)
+
+/*validate checks that the branches of d are sound before any of it is run*/
+func (d Dialog) validate() error {
+	names := map[string]bool{}
+	for _, s := range d.Steps {
+		if s.Name == "" {
+			continue
+		}
+		if names[s.Name] {
+			return newErr(false, false, fmt.Errorf("dialog %q has more than one step %q", d.Name, s.Name))
+		}
+		names[s.Name] = true
+	}
+	for _, s := range d.Steps {
+		for _, b := range s.Expect {
+			switch {
+			case b.Match == nil:
+				return newErr(false, false, fmt.Errorf("dialog %q step %q expects nothing in a branch", d.Name, s.Name))
+			case b.Goto != "" && !names[b.Goto]:
+				return newErr(false, false, fmt.Errorf("dialog %q step %q branches to unknown step %q", d.Name, s.Name, b.Goto))
+			}
+		}
+	}
+	return nil
+}
+
+/*index returns the index of the step named name*/
+func (d Dialog) index(name string) int {
+	for i, s := range d.Steps {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/*expand replaces the variables in send with their values in vars*/
+func expand(send string, vars map[string]string) (string, error) {
+	var err error
+	out := dialogVarRe.ReplaceAllStringFunc(send, func(v string) string {
+		name := dialogVarRe.FindStringSubmatch(v)[1]
+		val, ok := vars[name]
+		if !ok && err == nil {
+			err = newErr(false, false, fmt.Errorf("no variable %q to send", name))
+		}
+		return val
+	})
+	return out, err
+}
+
+/*
+RunDialog conforms to DialogRunner, holding the Arb from the first step of d to
+the last.  Stale input is cleared before the first step only.
+*/
+func (a *Arb) RunDialog(ctx context.Context, d Dialog) (res DialogResult) {
+	res.Vars = map[string]string{}
+	for k, v := range d.Vars {
+		res.Vars[k] = v
+	}
+	if res.Error = d.validate(); res.Error != nil {
+		return
+	}
+	if a.mux.lockContext(ctx, PriorityRoutine) != nil {
+		res.Error = cancelled(ctx)
+		return
+	}
+	defer a.mux.Unlock()
+	a.clearReadBuffer()
+
+	limit := d.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	var carry []byte
+	for next, run := 0, 0; next < len(d.Steps); run++ {
+		if run == limit {
+			res.Error = newErr(false, false, fmt.Errorf("dialog %q ran %d steps without finishing", d.Name, run))
+			return
+		}
+		s := d.Steps[next]
+		res.Step = s.Name
+		timeout := s.Timeout
+		if timeout == 0 {
+			timeout = d.Timeout
+		}
+		rsp, b := a.dialogStep(ctx, fmt.Sprintf("%s: %s", d.Name, s.Name), s, timeout, res.Vars, &carry)
+		res.Responses = append(res.Responses, rsp)
+		switch {
+		case rsp.Error != nil:
+			res.Error = rsp.Error
+			return
+		case b == nil:
+			next++
+		case b.Fail:
+			res.Error = ErrErrorResponse
+			return
+		case b.Done:
+			return
+		case b.Goto != "":
+			next = d.index(b.Goto)
+		default:
+			next++
+		}
+	}
+	return
+}
+
+/*
+dialogStep runs s, returning the branch that matched, if any.  carry holds what
+was received but not yet matched, before and after.  The caller must hold
+a.mux.
+*/
+func (a *Arb) dialogStep(ctx context.Context, name string, s DialogStep, timeout time.Duration, vars map[string]string, carry *[]byte) (rsp Response, matched *DialogBranch) {
+	start := time.Now()
+	defer func() {
+		rsp.Duration = time.Since(start)
+		a.logExchange(name, rsp)
+	}()
+
+	out, err := expand(s.Send, vars)
+	if err != nil {
+		return Response{Error: err}, nil
+	}
+	if _, werr := a.writeAll(ctx, []byte(out), timeout); werr != nil {
+		return Response{Error: werr}, nil
+	}
+	rsp = Response{Sent: time.Now(), Attempts: 1}
+	if len(s.Expect) == 0 {
+		return rsp, nil
+	}
+
+	var buf []byte
+	var loc []int
+	cf := func(raw []byte) ExitCriteria {
+		buf = append((*carry)[:len(*carry):len(*carry)], raw...)
+		for i := range s.Expect {
+			if loc = s.Expect[i].Match.FindSubmatchIndex(buf); loc != nil {
+				matched = &s.Expect[i]
+				return Success
+			}
+		}
+		return Insufficient
+	}
+	if cf(nil) == Insufficient {
+		dataChan := make(chan status, 0)
+		go a.readUntil(ctx, dataChan, timeout, cf, limitFor(Command{}))
+		d := <-dataChan
+		rsp.Error, rsp.FirstByte, rsp.Matched = d.err, d.first, d.matched
+		if d.err != nil {
+			rsp.Bytes, *carry = append(*carry, d.raw...), nil
+			return rsp, nil
+		}
+	} else {
+		rsp.Matched = time.Now()
+	}
+
+	rsp.Bytes, *carry = buf[:loc[1]], buf[loc[1]:]
+	for i, v := range matched.Match.SubexpNames() {
+		if v != "" && loc[2*i] >= 0 {
+			vars[v] = string(buf[loc[2*i]:loc[2*i+1]])
+		}
+	}
+	return rsp, matched
+}
@@ -0,0 +1,129 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestArb_RunDialog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	login := Dialog{Name: "login", Timeout: 20 * time.Millisecond, Steps: []DialogStep{
+		{Name: "user", Send: "\r", Expect: []DialogBranch{{Match: regexp.MustCompile(`login: `)}}},
+		{Name: "pass", Send: "${user}\r", Expect: []DialogBranch{
+			{Match: regexp.MustCompile(`Password: `)},
+			{Match: regexp.MustCompile(`(?P<host>\w+)\$ `), Done: true},
+		}},
+		{Name: "shell", Send: "${password}\r", Expect: []DialogBranch{
+			{Match: regexp.MustCompile(`Login incorrect`), Fail: true},
+			{Match: regexp.MustCompile(`(?P<host>\w+)\$ `)},
+		}},
+	}, Vars: map[string]string{"user": "ops", "password": "secret"}}
+
+	for _, tc := range []struct {
+		replies []string
+		steps   int
+		host    string
+		err     error
+	}{
+		{[]string{"login: ", "Password: ", "Welcome\nsensor1$ "}, 3, "sensor1", nil},
+		{[]string{"login: ", "sensor2$ "}, 2, "sensor2", nil},
+		{[]string{"login: ", "Password: ", "Login incorrect\n"}, 3, "", ErrErrorResponse},
+	} {
+		dev := &answeringIO{replies: tc.replies}
+		a, stop := Arbitrate(ctx, dev)
+		res := a.(DialogRunner).RunDialog(ctx, login)
+		stop()
+		if res.Error != tc.err || len(res.Responses) != tc.steps || res.Vars["host"] != tc.host {
+			t.Errorf("%q: expected %d steps capturing %q, got %d capturing %q: %v", tc.replies, tc.steps, tc.host, len(res.Responses), res.Vars["host"], res.Error)
+		}
+	}
+	if login.Vars["host"] != "" {
+		t.Error("Expected the dialog's own variables to be left alone")
+	}
+}
+
+func TestArb_RunDialog_Branches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dial := Dialog{Name: "dial", Timeout: 20 * time.Millisecond, Limit: 5, Steps: []DialogStep{
+		{Name: "banner", Send: "AT\r", Expect: []DialogBranch{{Match: regexp.MustCompile(`READY\r\n`)}}},
+		{Name: "dial", Send: "ATD${number}\r", Expect: []DialogBranch{
+			{Match: regexp.MustCompile(`BUSY`), Goto: "dial"},
+			{Match: regexp.MustCompile(`CONNECT (?P<baud>\d+)`)},
+		}},
+	}, Vars: map[string]string{"number": "5551234"}}
+
+	//the banner and the first answer arrive together: the answer is kept for the dial step
+	dev := &answeringIO{replies: []string{"READY\r\nBUSY\r\n", "", "CONNECT 9600\r\n"}}
+	a, stop := Arbitrate(ctx, dev)
+	defer stop()
+	r := a.(DialogRunner)
+	res := r.RunDialog(ctx, Dialog{Name: "noop"})
+	if res.Error != nil || len(res.Responses) != 0 {
+		t.Error("Expected an empty dialog to do nothing", res.Error)
+	}
+	dev.rx.WriteString("stale")
+	res = r.RunDialog(ctx, dial)
+	if res.Error != nil || res.Vars["baud"] != "9600" || len(res.Responses) != 3 || res.Step != "dial" {
+		t.Errorf("Expected to redial until connected, got %d steps: %v %v", len(res.Responses), res.Vars, res.Error)
+	}
+	if len(res.Responses) > 1 && (string(res.Responses[0].Bytes) != "READY\r\n" || string(res.Responses[1].Bytes) != "BUSY") {
+		t.Errorf("Expected stale input cleared and the banner split from the answer, got %q %q", res.Responses[0].Bytes, res.Responses[1].Bytes)
+	}
+	if sent := dev.tx.String(); sent != "AT\rATD5551234\rATD5551234\r" {
+		t.Errorf("Unexpected dialing %q", sent)
+	}
+
+	//forever busy
+	a, stop = Arbitrate(ctx, &answeringIO{replies: []string{"READY\r\n", "BUSY", "BUSY", "BUSY", "BUSY", "BUSY"}})
+	defer stop()
+	if res := a.(DialogRunner).RunDialog(ctx, dial); res.Error == nil || len(res.Responses) != 5 {
+		t.Error("Expected the dialog to give up at its limit", len(res.Responses), res.Error)
+	}
+	//no answer
+	a, stop = Arbitrate(ctx, &bufIO{})
+	defer stop()
+	if res := a.(DialogRunner).RunDialog(ctx, dial); res.Error == nil || !IsTimeout(res.Error) || res.Step != "banner" {
+		t.Error("Expected the dialog to time out", res.Step, res.Error)
+	}
+
+	for _, bad := range []Dialog{
+		{Steps: []DialogStep{{Name: "a"}, {Name: "a"}}},
+		{Steps: []DialogStep{{Name: "a", Expect: []DialogBranch{{Match: regexp.MustCompile(`x`), Goto: "b"}}}}},
+		{Steps: []DialogStep{{Name: "a", Expect: []DialogBranch{{}}}}},
+	} {
+		if res := a.(DialogRunner).RunDialog(ctx, bad); res.Error == nil || len(res.Responses) != 0 {
+			t.Errorf("Expected %+v to be refused", bad.Steps)
+		}
+	}
+	if res := a.(DialogRunner).RunDialog(ctx, Dialog{Steps: []DialogStep{{Send: "${nope}"}}}); res.Error == nil {
+		t.Error("Expected an unknown variable to fail the dialog")
+	}
+}
@@ -0,0 +1,280 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+var (
+	_ IDoIO       = &DMXClient{}
+	_ io.WriterAt = &DMXClient{}
+)
+
+/*DMXSlots is the number of channels in a full DMX512 universe*/
+const DMXSlots = 512
+
+/*DMXConfig controls the DMX512 refresh loop*/
+type DMXConfig struct {
+	//Slots is the number of channels sent in each frame, 24 to 512. Defaults to 512.
+	Slots int
+
+	//Interval is the time between the start of frames. Defaults to 25ms (40Hz).
+	Interval time.Duration
+
+	//BreakTime is the length of the break preceding each frame. Defaults to 200µs (the minimum is 88µs).
+	BreakTime time.Duration
+
+	//StartCode precedes the slots in each frame. Zero, the default, is for dimmer data.
+	StartCode byte
+}
+
+/*dmxPort is the part of a serial.Port a DMXClient needs*/
+type dmxPort interface {
+	io.WriteCloser
+	Break(time.Duration) error
+}
+
+/*
+DMXClient drives a DMX512 universe from a serial port (typically an RS485
+adapter), continuously refreshing every slot as the protocol requires: a
+break, the mark after break, the start code, then the slots.  Writes update
+the slots sent by the following frames, Write(b) setting channels 1 through
+len(b), and WriteAt(b, off) channels off+1 onwards.
+
+DMX512 is output only; Read waits for the timeout and returns a timeout error.
+*/
+type DMXClient struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	cfg     DMXConfig
+	dev     string
+	dial    string
+	open    func() (dmxPort, error)
+	log     Logger //nil means LoggerFrom(ctx)
+
+	mux   sync.Mutex //guards everything below
+	slots []byte
+	port  dmxPort
+	stop  context.CancelFunc //stops the refresh loop
+	done  chan struct{}
+	err   error //why the refresh loop stopped
+}
+
+/*
+NewDMXClient opens a DMX512 universe on a serial device (250000 baud, 8N2).
+Dial should be in the form of "dmx://<device>".  Timeout is how long Read
+waits.
+*/
+func NewDMXClient(ctx context.Context, timeout time.Duration, dial string) (*DMXClient, error) {
+	dl, err := dialFor(dial, "dmx")
+	if err == nil && dl.Device == "" {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(dl); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	dev := dl.Device
+	d := newDMXClient(ctx, timeout, DMXConfig{}, func() (dmxPort, error) {
+		return serial.Open(dev, &serial.Mode{BaudRate: 250000, DataBits: 8, Parity: serial.NoParity, StopBits: serial.TwoStopBits})
+	})
+	d.dev, d.dial = dev, dial
+	return d, openUnlessLazy(ctx, d)
+}
+
+func newDMXClient(ctx context.Context, timeout time.Duration, cfg DMXConfig, open func() (dmxPort, error)) *DMXClient {
+	if cfg.Slots <= 0 || cfg.Slots > DMXSlots {
+		cfg.Slots = DMXSlots
+	}
+	if cfg.Slots < 24 {
+		cfg.Slots = 24 //the minimum frame length
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 25 * time.Millisecond
+	}
+	if cfg.BreakTime <= 0 {
+		cfg.BreakTime = 200 * time.Microsecond
+	}
+	nctx, cancel := context.WithCancel(ctx)
+	return &DMXClient{ctx: nctx, cancel: cancel, timeout: timeout, cfg: cfg, open: open, slots: make([]byte, cfg.Slots)}
+}
+
+/*
+SetLogger overrides the Logger carried by the context this DMXClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (d *DMXClient) SetLogger(l Logger) {
+	d.log = l
+}
+
+func (d *DMXClient) dialString() string { return d.dial }
+
+func (d *DMXClient) logger() Logger {
+	if d.log != nil {
+		return d.log
+	}
+	return LoggerFrom(d.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (d *DMXClient) String() string {
+	return fmt.Sprintf("DMX512 universe of %d slots on %v", d.cfg.Slots, d.dev)
+}
+
+/*Open (re)opens the serial device and starts the refresh loop*/
+func (d *DMXClient) Open() error {
+	select {
+	case <-d.ctx.Done():
+		return newErr(false, false, d.ctx.Err())
+	default:
+	}
+	d.Close()
+	port, err := d.open()
+	if err != nil {
+		d.logger().Warn("unable to open DMX device", "event", EventError, "dial", d.dial, "error", err)
+		return newErr(false, false, errors.Wrapf(err, "unable to open DMX device %q", d.dev))
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	lctx, stop := context.WithCancel(d.ctx)
+	d.port, d.stop, d.done, d.err = port, stop, make(chan struct{}), nil
+	go d.refresh(lctx, port, d.done)
+	d.logger().Debug("DMX device opened", "event", EventConnect, "dial", d.dial)
+	return nil
+}
+
+/*Close conforms to io.Closer, stopping the refresh loop and closing the device*/
+func (d *DMXClient) Close() error {
+	d.mux.Lock()
+	port, stop, done := d.port, d.stop, d.done
+	d.port, d.stop = nil, nil
+	d.mux.Unlock()
+	if port == nil {
+		return nil
+	}
+	stop()
+	<-done
+	d.logger().Debug("DMX device closed", "event", EventDisconnect, "dial", d.dial)
+	if err := port.Close(); err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
+
+/*refresh sends a frame every Interval until ctx is done or the device fails*/
+func (d *DMXClient) refresh(ctx context.Context, port dmxPort, done chan struct{}) {
+	defer close(done)
+	tick := time.NewTicker(d.cfg.Interval)
+	defer tick.Stop()
+	frame := make([]byte, 1+d.cfg.Slots)
+	frame[0] = d.cfg.StartCode
+	for {
+		d.mux.Lock()
+		copy(frame[1:], d.slots)
+		d.mux.Unlock()
+		err := port.Break(d.cfg.BreakTime)
+		if err == nil {
+			_, err = port.Write(frame)
+		}
+		if err != nil {
+			d.logger().Warn("DMX refresh failed", "event", EventError, "dial", d.dial, "error", err)
+			d.mux.Lock()
+			d.err = err
+			d.mux.Unlock()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+/*
+Write conforms to io.Writer, setting channels 1 through len(b).  An error is
+returned if the refresh loop is not running.
+*/
+func (d *DMXClient) Write(b []byte) (int, error) { return d.WriteAt(b, 0) }
+
+/*WriteAt conforms to io.WriterAt, setting channels off+1 through off+len(b)*/
+func (d *DMXClient) WriteAt(b []byte, off int64) (int, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if off < 0 || off+int64(len(b)) > int64(len(d.slots)) {
+		return 0, newErr(false, false, fmt.Errorf("channels %d-%d are outside the universe of %d", off+1, off+int64(len(b)), len(d.slots)))
+	}
+	if d.err != nil {
+		return 0, newErr(false, false, errors.Wrap(d.err, "DMX refresh failed"))
+	}
+	if d.port == nil {
+		return 0, writeErr
+	}
+	return copy(d.slots[off:], b), nil
+}
+
+/*Set sets channel (counting from 1) and those following it to values*/
+func (d *DMXClient) Set(channel int, values ...byte) error {
+	_, err := d.WriteAt(values, int64(channel-1))
+	return err
+}
+
+/*Slots returns a copy of the current channel values; channel n is at index n-1*/
+func (d *DMXClient) Slots() []byte {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return cloneBytes(d.slots)
+}
+
+/*Blackout sets every channel to zero*/
+func (d *DMXClient) Blackout() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for i := range d.slots {
+		d.slots[i] = 0
+	}
+}
+
+/*Read conforms to io.Reader. DMX512 is output only, so it only ever times out.*/
+func (d *DMXClient) Read(b []byte) (int, error) {
+	select {
+	case <-d.ctx.Done():
+		return 0, newErr(false, false, d.ctx.Err())
+	case <-time.After(d.timeout):
+		return 0, newErr(true, true, errors.New("DMX512 is output only"))
+	}
+}
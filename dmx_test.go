@@ -0,0 +1,128 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*fakeDMXPort records the frames written after each break*/
+type fakeDMXPort struct {
+	sync.Mutex
+	frames [][]byte
+	broke  bool
+	fail   bool
+}
+
+func (p *fakeDMXPort) Break(time.Duration) error {
+	p.Lock()
+	defer p.Unlock()
+	p.broke = true
+	return nil
+}
+
+func (p *fakeDMXPort) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.fail {
+		return 0, errors.New("unplugged")
+	}
+	if !p.broke {
+		return 0, errors.New("frame without a break")
+	}
+	p.broke = false
+	p.frames = append(p.frames, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (p *fakeDMXPort) Close() error { return nil }
+
+func (p *fakeDMXPort) last() []byte {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.frames) == 0 {
+		return nil
+	}
+	return p.frames[len(p.frames)-1]
+}
+
+func TestDMXClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port := &fakeDMXPort{}
+	d := newDMXClient(ctx, 10*time.Millisecond, DMXConfig{Slots: 32, Interval: time.Millisecond}, func() (dmxPort, error) { return port, nil })
+	if err := d.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	defer d.Close()
+
+	d.Write([]byte{255, 128})
+	if err := d.Set(10, 7, 8); err != nil {
+		t.Error("Unable to set channels", err)
+	}
+	if err := d.Set(32, 1, 2); err == nil {
+		t.Error("Expected channels beyond the universe to be refused")
+	}
+	want := make([]byte, 33)
+	want[1], want[2], want[10], want[11] = 255, 128, 7, 8
+	time.Sleep(10 * time.Millisecond)
+	if got := port.last(); !bytes.Equal(got, want) {
+		t.Errorf("Unexpected frame % x", got)
+	}
+	if !bytes.Equal(d.Slots(), want[1:]) {
+		t.Error("Unexpected slots", d.Slots())
+	}
+
+	d.Blackout()
+	time.Sleep(10 * time.Millisecond)
+	if got := port.last(); !bytes.Equal(got, make([]byte, 33)) {
+		t.Errorf("Expected a blackout, got % x", got)
+	}
+	if _, err := d.Read(make([]byte, 1)); !IsTimeout(err) {
+		t.Error("Expected reads to time out", err)
+	}
+
+	port.Lock()
+	port.fail = true
+	port.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := d.Write([]byte{1}); err == nil || IsTemporary(err) {
+		t.Error("Expected a failed refresh to fail writes", err)
+	}
+	port.Lock()
+	port.fail = false
+	port.Unlock()
+	if err := d.Open(); err != nil {
+		t.Error("Unable to reopen", err)
+	}
+	if _, err := d.Write([]byte{1}); err != nil {
+		t.Error("Expected writes to succeed once reopened", err)
+	}
+}
@@ -31,6 +31,11 @@ move the center frequency of a piece of test equipment, or send some sort of
 power on/off sequence to a PDU in a data center.  IDoIOs usually need some sort
 of parser where Arbiters need to be instructed what to do.
 
+Exchanges that take more than one command, such as a modem's init strings or
+a login prompt, can be scripted as a Dialog and run on an Arbiter as one
+exchange (see DialogRunner), and sequences of Commands can be sent back to back
+with ControlAll (see Batcher).
+
 # Dial Strings and Implementations
 
 Although you can write your own IDoIO (and I welcome patches!), this package
@@ -42,14 +47,66 @@ transparent enough that someone with a crude understanding would know what to
 make of the parameters.  The following schemas are provided by this package,
 and can be generically returned via the NewIDoIO() function:
 
-	tcp://<host:port> - Outgoing Sockets of type tcp (either v4 or v6)
+	tcp://<host:port>[?proxy=<url>&keepalive=<duration>&keepcount=<n>&nodelay=<bool>&laddr=<ip:port>&rcvbuf=<bytes>&sndbuf=<bytes>&proxyproto=<1|2>] - Outgoing Sockets of type tcp (either v4 or v6), optionally via a socks5:// or http:// proxy
 	tcp4://<host:port> - Outgoing Sockets of type tcp v4
 	tcp6://<host:port> - Outgoing Sockets of type tcp v6
-	udp://<host:port> - Outgoing Sockets of type udp (either v4 or v6)
+	tcp-listen://<host:port>[?proxyproto=<bool>] - Incoming Sockets of type tcp, accepting a single client (also tcp4-listen and tcp6-listen)
+	udp://<host:port>[?broadcast=<bool>&laddr=<ip:port>&rcvbuf=<bytes>&sndbuf=<bytes>] - Outgoing Sockets of type udp (either v4 or v6), optionally permitted to send to broadcast addresses
 	udp4://<host:port> - Outgoing Sockets of type udp v4
 	udp6://<host:port> - Outgoing Sockets of type udp v6
-	serial://<device>:<baud> - Serial connection
-	rs232://<device>:<baud> - Serial connection
+	udp-listen://<host:port> - Incoming Sockets of type udp, exchanging datagrams with the first peer heard from (also udp4-listen and udp6-listen)
+	mcast://<group:port>[?iface=<name>&ttl=<hops>&loop=<bool>] - Multicast udp, joining the group on the interface named
+	unixgram://<path>[?local=<path>] - Unix domain datagram socket, one message per Read and Write
+	vsock://<cid:port> - Virtio socket between a VM and its hypervisor, where cid may be "host" (linux only)
+	ble://<address>[?rx=<uuid>&tx=<uuid>&type=random|public] - Bluetooth LE peripheral, by default its Nordic UART Service (linux only)
+	hid://<vid:pid>[?serial=<serial>&report=<id>] - USB HID device, exchanging input and output reports (linux only)
+	grpc://<host:port>/<service>/<method>[?token=<bearer>&insecure=<bool>] - Bidirectional streaming gRPC method exchanging bytes Chunks, over TLS
+	dtls://<host:port> - Datagram TLS over udp, once SetDTLSHandshake is called
+	zmq://<host:port>[?type=<pair|req|dealer>&identity=<id>] - ZeroMQ socket speaking ZMTP 3.0 over tcp
+	mqtt://<host:port>?sub=<topic>&pub=<topic> - Reads messages from one MQTT topic, and publishes writes on another
+	ssh://[<user>@]<host>[:<port>][/<command>][?key=<path>&jump=<host>] - Stdin and stdout of a remote command run via ssh
+	serial://<device>:<baud>[?databits=<n>&parity=<p>&stopbits=<n>&flow=<f>&rs485=<rts|kernel>&hotplug=<interval>] - Serial connection, 8N1 without flow control by default
+	serial://vid:pid=<vid>:<pid>[:<serial>]:<baud>[?...] - Serial connection on whichever port belongs to a USB device
+	serial://COM<n>:<baud>[?...] - Serial connection on windows, for any COM port number
+	rs232://<device>:<baud>[?...] - Serial connection
+	rfc2217://<host:port>:<baud>[?databits=<n>&parity=<p>&stopbits=<n>&flow=<f>] - Remote serial port via a terminal server speaking RFC 2217
+	failover://(<dial>,<dial>[,<dial>...]) - The first of several redundant paths that opens, rotating through them on each reopen
+	tee://(<dial>)?log=<path>[&sink=<dial>] - Another dial string, with its traffic copied to files and other dial strings
+	throttle://(<dial>)?[rx=<bytes/s>][&tx=<bytes/s>][&burst=<bytes>] - Another dial string, with its traffic paced
+	chaos://(<dial>)?[delay=<duration>][&jitter=<duration>][&short=<p>][&corrupt=<p>][&drop=<p>][&seed=<n>] - Another dial string, with faults injected
+	record://(<dial>)?file=<path> - Another dial string, with its traffic written to a capture
+	reconnect://(<dial>)?[min=<duration>][&max=<duration>][&jitter=<duration>][&attempts=<n>] - Another dial string, reopened with backoff whenever it fails
+	replay://<path>[?timing=<bool>&speed=<factor>&lockstep=<bool>] - Plays back what was read in a capture
+	modem://<device>:<baud>/<number> - Hayes modem on a serial port, dialing number
+	sbd://<device>:<baud> - Iridium 9602/9603 short burst data modem on a serial port
+	dmx://<device> - DMX512 universe driven from a serial (RS485) device
+	i2c://<device>:<address> - Slave on a linux I2C bus, eg i2c:///dev/i2c-1:0x48
+	spi://<device>[?speed=<hz>&mode=<0-3>&bits=<n>] - Device on a linux SPI bus, eg spi:///dev/spidev0.0?speed=500000
+	file://<path>[?write=<path>&follow=<bool>] - Play back a file, appending writes to it (or another file)
+	mem://<name> - One end of an in-memory connection, the other end being the next mem:// of that name (see NewMemPair)
+	null://[<name>] - Discards writes, and reads nothing but timeouts
+
+Other schemes may be added with Register, either by packages linked into an
+application or by Go plugins loaded with LoadPlugin (or found via the
+AGNOIO_PLUGIN_PATH environment variable), so that site-specific transports
+can be used by applications that only know dial strings.  Schemes lists
+everything understood, and Supports checks a dial string without opening it.
+Dial strings that need more than a scheme to tell them apart can be claimed
+with a regular expression via RegisterScheme.
+
+Constructors open what they build before returning it.  NewIDoIODeferred,
+or a context marked with WithLazyOpen, leaves the first Open to the caller
+instead, for supervisors and retry loops.
+
+ParseDial takes a dial string apart into its scheme, host or device, baud
+rate and query parameters, for programs that need to inspect or rewrite one.
+ValidateDial (and ValidateDialDevice) check one without opening anything, for
+linting configuration before it is deployed.
+
+Layers may follow a dial string, separated by "|", e.g.
+"tcp://gps:4001 | log | frame(split=lines)", and are wrapped around the
+transport as by ParseStack and Build.  Any Wrapper (see Chain) can be named in
+this way once it is added with RegisterWrapper.
 
 # Context Usage
 
@@ -59,6 +116,22 @@ function will be called, and any further IO using the structure will end up in
 context errors.  This is helpful as it forces connection hangup and known exit
 behaviour.
 
+# Logging
+
+Transports and Arbiters emit structured log messages via the Logger interface,
+which *slog.Logger satisfies. Every message carries an "event" attribute (see
+the Event* constants), and where it makes sense a
+"dial" attribute with the dial string and a "command" attribute with the
+Command.Name involved. The Logger is chosen, in order of preference, from a
+SetLogger call on the instance, the context passed to its constructor (see
+WithLogger), or the package default (see SetDefaultLogger), which in turn
+defaults to slog.Default().  Which of a transport's opens, closes, reads,
+writes and timeouts are logged at Debug level is chosen with WithTrace.
+
+Programs that would rather react to a link coming and going than log it can
+give Hooks the same way, with WithHooks or SetHooks; NetClient, SerialClient,
+UnixgramClient and MulticastClient call them.
+
 # Error Handling
 
 All errors returned from this package either implicitly or explicitly conform to
@@ -122,4 +195,10 @@ var (
 	// - IsTimeout(ErrErrorResponse) == false
 	// This error is intended to be used to compare against when checking errors
 	ErrErrorResponse = newErr(false, false, errors.New("Command received error response"))
+
+	// ErrResponseTooLarge is returned when a response outgrows its limit (see
+	// Command.MaxResponseBytes) under OverflowFail, before matching either the
+	// success or failure criteria.  Like ErrErrorResponse it is neither
+	// temporary nor a timeout.
+	ErrResponseTooLarge = newErr(false, false, errors.New("Response exceeded its size limit"))
 )
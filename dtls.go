@@ -0,0 +1,296 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	_ IDoIO = &DTLSClient{}
+
+	dtlsMux              sync.RWMutex
+	defaultDTLSHandshake DTLSHandshake
+)
+
+/*
+DTLSHandshake secures conn, a connected UDP socket, with datagram TLS using
+cfg, returning the secured connection.  It must give up once ctx is done.
+
+The standard library has no DTLS implementation, so one has to be provided,
+typically by wrapping a third party package such as github.com/pion/dtls:
+
+	agnoio.SetDTLSHandshake(func(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+		return dtls.ClientWithContext(ctx, conn, &dtls.Config{Certificates: cfg.Certificates, RootCAs: cfg.RootCAs, ServerName: cfg.ServerName})
+	})
+*/
+type DTLSHandshake func(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error)
+
+/*
+SetDTLSHandshake sets the package-wide DTLSHandshake used by DTLSClients not
+configured with one of their own, including all those created from dtls://
+dial strings.  As there is no DTLS without one, NewIDoIO (and Supports,
+Schemes etc) only know the dtls scheme while a handshake is set.
+*/
+func SetDTLSHandshake(h DTLSHandshake) {
+	dtlsMux.Lock()
+	defaultDTLSHandshake = h
+	dtlsMux.Unlock()
+	registry.Lock()
+	defer registry.Unlock()
+	if h == nil {
+		delete(registry.schemes, "dtls")
+		return
+	}
+	registry.schemes["dtls"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewDTLSClient(ctx, dur, dial)
+	}
+}
+
+/*DTLSConfig controls how a DTLSClient secures its connection*/
+type DTLSConfig struct {
+	//TLS holds the certificates, roots, server name etc. Nil means an empty config.
+	TLS *tls.Config
+
+	//Handshake secures the connection. Nil means the one set with SetDTLSHandshake.
+	Handshake DTLSHandshake
+
+	//HandshakeTimeout bounds each handshake. Zero means the client's timeout, or 10s if that is zero too.
+	HandshakeTimeout time.Duration
+}
+
+/*
+NewDTLSClient opens a datagram TLS connection to a remote host using the
+package-wide DTLSHandshake (see SetDTLSHandshake). dial should be in the form
+of: 'dtls://<host>:<port>'.  timeout is used as for a NetClient.
+*/
+func NewDTLSClient(ctx context.Context, timeout time.Duration, dial string) (*DTLSClient, error) {
+	return NewDTLSClientConfig(ctx, timeout, dial, DTLSConfig{})
+}
+
+/*NewDTLSClientConfig is NewDTLSClient with an explicit configuration*/
+func NewDTLSClientConfig(ctx context.Context, timeout time.Duration, dial string, cfg DTLSConfig) (*DTLSClient, error) {
+	d, err := dialFor(dial, "dtls")
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, fmt.Errorf("invalid parameters in %q: %v", dial, err))
+	}
+	if cfg.TLS == nil {
+		cfg.TLS = &tls.Config{}
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = timeout
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = 10 * time.Second
+	}
+	dctx, cancel := context.WithCancel(ctx)
+	dc := &DTLSClient{
+		dial:      dial,
+		address:   d.Host,
+		cfg:       cfg,
+		timeout:   timeout,
+		rwtimeout: 1 * time.Millisecond,
+		ctx:       dctx,
+		cancel:    cancel,
+	}
+	return dc, openUnlessLazy(ctx, dc)
+}
+
+/*
+DTLSClient provides an implementer of the IDoIO interface over UDP secured
+with datagram TLS, under the URI regime:
+
+	dtls://
+
+Every Open performs a fresh handshake over a new socket, so reopening recovers
+from lost or expired sessions.  All returned errors conform to net.Error: read
+and write deadlines and handshake timeouts are temporary timeouts, while
+handshake failures are neither temporary nor timeouts.
+*/
+type DTLSClient struct {
+	dial      string
+	address   string
+	cfg       DTLSConfig
+	cancel    context.CancelFunc
+	ctx       context.Context
+	rwtimeout time.Duration
+	timeout   time.Duration
+	conn      net.Conn
+	log       Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this DTLSClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (dc *DTLSClient) SetLogger(l Logger) {
+	dc.log = l
+}
+
+func (dc *DTLSClient) dialString() string { return dc.dial }
+
+func (dc *DTLSClient) logger() Logger {
+	if dc.log != nil {
+		return dc.log
+	}
+	return LoggerFrom(dc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (dc *DTLSClient) String() string {
+	return fmt.Sprintf("dtls connection to %v", dc.address)
+}
+
+/*
+Open forcibly closes (ignoring errors) any existing connection, then dials and
+handshakes again
+*/
+func (dc *DTLSClient) Open() error {
+	select {
+	case <-dc.ctx.Done():
+		return newErr(false, false, dc.ctx.Err())
+	default:
+	}
+	if dc.conn != nil {
+		dc.conn.Close()
+		dc.conn = nil
+	}
+	handshake := dc.cfg.Handshake
+	if handshake == nil {
+		dtlsMux.RLock()
+		handshake = defaultDTLSHandshake
+		dtlsMux.RUnlock()
+	}
+	if handshake == nil {
+		return newErr(false, false, fmt.Errorf("no DTLS handshake available, see SetDTLSHandshake"))
+	}
+	dialer := net.Dialer{Timeout: dc.timeout}
+	raw, err := dialer.DialContext(dc.ctx, "udp", dc.address)
+	if err != nil {
+		dc.logger().Warn("unable to open connection", "event", EventError, "dial", dc.dial, "error", err)
+		return dtlsErr(err)
+	}
+	hctx, cancel := context.WithTimeout(dc.ctx, dc.cfg.HandshakeTimeout)
+	defer cancel()
+	if dc.conn, err = handshake(hctx, raw, dc.cfg.TLS); err != nil {
+		dc.conn = nil
+		raw.Close()
+		dc.logger().Warn("handshake failed", "event", EventError, "dial", dc.dial, "error", err)
+		return dtlsErr(err)
+	}
+	dc.logger().Debug("connection opened", "event", EventConnect, "dial", dc.dial)
+	return nil
+}
+
+/*
+Read conforms to io.Reader, but immediately returns upon ctx destruction after
+closing the underlying transport
+*/
+func (dc *DTLSClient) Read(b []byte) (int, error) {
+	select {
+	case <-dc.ctx.Done():
+		defer dc.Close()
+		return 0, newErr(false, false, dc.ctx.Err())
+	default:
+	}
+	if dc.conn == nil {
+		return 0, readErr
+	}
+	if dc.rwtimeout > 0 {
+		dc.conn.SetReadDeadline(time.Now().Add(dc.rwtimeout))
+	}
+	n, err := dc.conn.Read(b)
+	dc.logErr("read", err)
+	return n, dtlsErr(err)
+}
+
+/*
+Write conforms to io.Writer, but immediately returns upon ctx destruction after
+closing the underlying transport
+*/
+func (dc *DTLSClient) Write(b []byte) (int, error) {
+	select {
+	case <-dc.ctx.Done():
+		defer dc.Close()
+		return 0, newErr(false, false, dc.ctx.Err())
+	default:
+	}
+	if dc.conn == nil {
+		return 0, writeErr
+	}
+	if dc.rwtimeout > 0 {
+		dc.conn.SetWriteDeadline(time.Now().Add(dc.rwtimeout))
+	}
+	n, err := dc.conn.Write(b)
+	dc.logErr("write", err)
+	return n, dtlsErr(err)
+}
+
+/*Close conforms to io.Closer*/
+func (dc *DTLSClient) Close() error {
+	dc.cancel()
+	defer func() { dc.conn = nil }()
+	if dc.conn != nil {
+		dc.logger().Debug("connection closed", "event", EventDisconnect, "dial", dc.dial)
+		return dc.conn.Close()
+	}
+	return nil
+}
+
+/*logErr logs errors from op that are not deadlines expiring*/
+func (dc *DTLSClient) logErr(op string, err error) {
+	if err != nil && !IsTimeout(dtlsErr(err)) {
+		dc.logger().Debug(op+" failed", "event", EventError, "dial", dc.dial, "error", err)
+	}
+}
+
+/*
+dtlsErr makes err, which may come from a third party DTLS implementation,
+conform to net.Error.  Deadlines expiring are temporary timeouts, and anything
+else not already classified is neither temporary nor a timeout.
+*/
+func dtlsErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return newErr(true, true, err)
+	}
+	if _, ok := err.(net.Error); ok {
+		return err
+	}
+	return newErr(false, false, err)
+}
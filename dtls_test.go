@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*newUDPEchoSvr echoes every datagram received on addr*/
+func newUDPEchoSvr(ctx context.Context, t *testing.T, addr string) {
+	t.Helper()
+	svr, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal("Unable to start server", err)
+	}
+	go func() {
+		<-ctx.Done()
+		svr.Close()
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := svr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			svr.WriteTo(buf[:n], from)
+		}
+	}()
+}
+
+/*fakeDTLS stands in for a secured connection, failing reads of "ALERT" as a DTLS alert would*/
+type fakeDTLS struct{ net.Conn }
+
+func (f fakeDTLS) Read(b []byte) (int, error) {
+	n, err := f.Conn.Read(b)
+	if string(b[:n]) == "ALERT" {
+		return 0, errors.New("alert: bad record mac")
+	}
+	return n, err
+}
+
+func TestDTLSClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, _ := freePortCfg()
+	newUDPEchoSvr(ctx, t, srvdial)
+	dial := "dtls://" + srvdial
+
+	SetDTLSHandshake(nil)
+	if _, err := NewIDoIO(ctx, time.Second, dial); err == nil {
+		t.Error("Expected an error without a handshake")
+	}
+	if Supports(dial) {
+		t.Error("dtls should not be supported without a handshake")
+	}
+
+	var handshakes int32
+	hello := func(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+		if _, err := conn.Write([]byte("HELLO " + cfg.ServerName)); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 64)
+		if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "HELLO "+cfg.ServerName {
+			return nil, errors.New("bad hello")
+		}
+		atomic.AddInt32(&handshakes, 1)
+		return fakeDTLS{conn}, nil
+	}
+	SetDTLSHandshake(hello)
+	defer SetDTLSHandshake(nil)
+	if !Supports(dial) {
+		t.Error("dtls should be supported once a handshake is set")
+	}
+
+	idoio, err := NewIDoIO(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer idoio.Close()
+	_ = idoio.String()
+	if err := idoio.Open(); err != nil || atomic.LoadInt32(&handshakes) != 2 {
+		t.Error("Expected Open to handshake again", handshakes, err)
+	}
+
+	idoio.Write([]byte("data"))
+	<-time.After(20 * time.Millisecond)
+	buf := make([]byte, 64)
+	if n, err := idoio.Read(buf); err != nil || string(buf[:n]) != "data" {
+		t.Error("Expected the echo", string(buf[:n]), err)
+	}
+	if _, err := idoio.Read(buf); err == nil || !IsTimeout(err) || !IsTemporary(err) {
+		t.Error("Expected a temporary timeout with nothing to read", err)
+	}
+	idoio.Write([]byte("ALERT"))
+	<-time.After(20 * time.Millisecond)
+	if _, err := idoio.Read(buf); err == nil || IsTimeout(err) || IsTemporary(err) {
+		t.Error("Expected a permanent error from an alert", err)
+	}
+
+	stall := func(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	cfg := DTLSConfig{TLS: &tls.Config{ServerName: "sensor"}, Handshake: stall, HandshakeTimeout: 20 * time.Millisecond}
+	if _, err := NewDTLSClientConfig(ctx, time.Second, dial, cfg); err == nil || !IsTimeout(err) {
+		t.Error("Expected a handshake timeout", err)
+	}
+	cfg.Handshake = hello
+	dc, err := NewDTLSClientConfig(ctx, time.Second, dial, cfg)
+	if err != nil {
+		t.Fatal("Unable to dial with a config", err)
+	}
+	dc.Close()
+	if _, err := dc.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail once closed")
+	}
+}
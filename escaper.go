@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Escaper is a Transform implementing byte stuffing: each byte found in its
+table is sent as the escape byte followed by the byte the table maps it to,
+and the reverse is applied to incoming data.  The escape byte must itself be
+in the table.  For example, DLE stuffing doubles every DLE (0x10):
+
+	NewEscaper(0x10, map[byte]byte{0x10: 0x10})
+
+An escape followed by a byte not in the table is passed through as that byte.
+*/
+type Escaper struct {
+	escape  byte
+	table   map[byte]byte //raw -> escaped
+	untable map[byte]byte //escaped -> raw
+	preset  string
+	pending bool //the last byte decoded was an unpaired escape
+}
+
+/*NewEscaper returns an Escaper using escape and table, or an error if the table is ambiguous*/
+func NewEscaper(escape byte, table map[byte]byte) (*Escaper, error) {
+	if _, ok := table[escape]; !ok {
+		return nil, newErr(false, false, fmt.Errorf("the escape byte %#02x must be in the table", escape))
+	}
+	e := &Escaper{escape: escape, table: map[byte]byte{}, untable: map[byte]byte{}}
+	for raw, esc := range table {
+		if _, dup := e.untable[esc]; dup {
+			return nil, newErr(false, false, fmt.Errorf("%#02x is the escaped form of more than one byte", esc))
+		}
+		e.table[raw], e.untable[esc] = esc, raw
+	}
+	return e, nil
+}
+
+/*
+escaperPresets are the escaping schemes of common protocols: DLE stuffing, SLIP
+(RFC 1055), and HDLC/PPP (RFC 1662) flag and escape octets.
+*/
+var escaperPresets = map[string]struct {
+	escape byte
+	table  map[byte]byte
+}{
+	"dle":  {0x10, map[byte]byte{0x10: 0x10}},
+	"slip": {0xdb, map[byte]byte{0xc0: 0xdc, 0xdb: 0xdd}},
+	"hdlc": {0x7d, map[byte]byte{0x7e: 0x5e, 0x7d: 0x5d}},
+}
+
+/*NewEscaperPreset returns an Escaper for one of "dle", "slip" or "hdlc"*/
+func NewEscaperPreset(name string) (*Escaper, error) {
+	p, ok := escaperPresets[name]
+	if !ok {
+		return nil, newErr(false, false, fmt.Errorf("unknown escaping preset %q", name))
+	}
+	e, err := NewEscaper(p.escape, p.table)
+	if err == nil {
+		e.preset = name
+	}
+	return e, err
+}
+
+/*Encode conforms to Transform*/
+func (e *Escaper) Encode(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if esc, ok := e.table[c]; ok {
+			out = append(out, e.escape, esc)
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+/*Decode conforms to Transform. An escape at the end of b is held until the next call.*/
+func (e *Escaper) Decode(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch {
+		case e.pending:
+			e.pending = false
+			if raw, ok := e.untable[c]; ok {
+				c = raw
+			}
+			out = append(out, c)
+		case c == e.escape:
+			e.pending = true
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+/*Reset forgets any unpaired escape*/
+func (e *Escaper) Reset() { e.pending = false }
+
+/*params describes the Escaper for a Layer*/
+func (e *Escaper) params() map[string]string {
+	if e.preset != "" {
+		return map[string]string{"preset": e.preset}
+	}
+	pairs := make([]string, 0, len(e.table))
+	for raw, esc := range e.table {
+		pairs = append(pairs, fmt.Sprintf("%02x:%02x", raw, esc))
+	}
+	sort.Strings(pairs)
+	return map[string]string{"char": fmt.Sprintf("%02x", e.escape), "table": strings.Join(pairs, "/")}
+}
+
+/*
+WithEscaping applies e in a Transformer, beneath any framing.  It is
+described as "escape(preset=dle)" for presets, and otherwise as e.g.
+"escape(char=7d,table=7d:5d/7e:5e)", with bytes in hex.
+*/
+func WithEscaping(e *Escaper) Layer {
+	return NewLayer("escape", RankTransform, e.params(), func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		ec := *e //each build gets its own decoding state
+		ec.pending = false
+		return NewTransformer(inner, &ec), nil
+	})
+}
+
+func parseEscapeLayer(params map[string]string) (Layer, error) {
+	if preset, ok := params["preset"]; ok {
+		e, err := NewEscaperPreset(preset)
+		if err != nil {
+			return Layer{}, err
+		}
+		return WithEscaping(e), nil
+	}
+	hexByte := func(s string) (byte, error) {
+		v, err := strconv.ParseUint(s, 16, 8)
+		return byte(v), err
+	}
+	escape, err := hexByte(params["char"])
+	if err != nil {
+		return Layer{}, fmt.Errorf("bad escape char %q", params["char"])
+	}
+	table := map[byte]byte{}
+	for _, pair := range strings.Split(params["table"], "/") {
+		raw, esc, ok := strings.Cut(pair, ":")
+		r, rerr := hexByte(raw)
+		e, eerr := hexByte(esc)
+		if !ok || rerr != nil || eerr != nil {
+			return Layer{}, fmt.Errorf("bad table entry %q", pair)
+		}
+		table[r] = e
+	}
+	e, err := NewEscaper(escape, table)
+	if err != nil {
+		return Layer{}, err
+	}
+	return WithEscaping(e), nil
+}
@@ -0,0 +1,89 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscaper(t *testing.T) {
+	if _, err := NewEscaper(0x10, map[byte]byte{0x02: 0x42}); err == nil {
+		t.Error("Expected a table without the escape byte to be rejected")
+	}
+	if _, err := NewEscaper(0x10, map[byte]byte{0x10: 0x10, 0x02: 0x10}); err == nil {
+		t.Error("Expected an ambiguous table to be rejected")
+	}
+	if _, err := NewEscaperPreset("cobs"); err == nil {
+		t.Error("Expected an unknown preset to be rejected")
+	}
+
+	for _, tc := range []struct {
+		preset  string
+		raw     []byte
+		escaped []byte
+	}{
+		{"dle", []byte{0x01, 0x10, 0x02}, []byte{0x01, 0x10, 0x10, 0x02}},
+		{"slip", []byte{0xc0, 0x01, 0xdb}, []byte{0xdb, 0xdc, 0x01, 0xdb, 0xdd}},
+		{"hdlc", []byte{0x7e, 0x7d, 0x20}, []byte{0x7d, 0x5e, 0x7d, 0x5d, 0x20}},
+	} {
+		e, _ := NewEscaperPreset(tc.preset)
+		if enc, _ := e.Encode(tc.raw); !bytes.Equal(enc, tc.escaped) {
+			t.Errorf("%s: expected % x, got % x", tc.preset, tc.escaped, enc)
+		}
+		//split the escaped form at every point to exercise the held escape
+		for i := range tc.escaped {
+			a, _ := e.Decode(tc.escaped[:i])
+			b, _ := e.Decode(tc.escaped[i:])
+			if dec := append(a, b...); !bytes.Equal(dec, tc.raw) {
+				t.Errorf("%s split at %d: expected % x, got % x", tc.preset, i, tc.raw, dec)
+			}
+		}
+	}
+
+	inner := &bufIO{}
+	e, _ := NewEscaper(0x7d, map[byte]byte{0x7d: 0x5d, 0x7e: 0x5e})
+	stuffed := NewTransformer(inner, e)
+	stuffed.Write([]byte{0x7e})
+	inner.rx.Write([]byte{0x7d, 0x41, 0x7d})
+	b := make([]byte, 8)
+	if n, _ := stuffed.Read(b); !bytes.Equal(inner.tx.Bytes(), []byte{0x7d, 0x5e}) || !bytes.Equal(b[:n], []byte{0x41}) {
+		t.Errorf("Unexpected traffic: tx % x rx % x", inner.tx.Bytes(), b[:n])
+	}
+
+	for _, desc := range []string{
+		"tcp://localhost:1 | escape(preset=slip)",
+		"tcp://localhost:1 | escape(char=7d,table=7d:5d/7e:5e)",
+	} {
+		if s, err := ParseStack(desc); err != nil || s.String() != desc {
+			t.Error("Expected the escape layer to round trip", desc, s, err)
+		}
+	}
+	for _, bad := range []string{"escape(preset=x)", "escape(char=zz,table=10:10)", "escape(char=10,table=10)", "escape(char=10,table=11:11)"} {
+		if _, err := ParseStack("tcp://localhost:1 | " + bad); err == nil {
+			t.Error("Expected a bad escape layer to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ ContextArbiter = &FailoverArb{}
+	_ QueueReporter  = &FailoverArb{}
+	_ Expecter       = &FailoverArb{}
+	_ DialogRunner   = &FailoverArb{}
+	_ Batcher        = &FailoverArb{}
+)
+
+/*
+FailoverArb is an Arbiter spanning several redundant paths to the same device,
+such as a dual-homed instrument reachable over Ethernet with a serial backup.
+Only one path is active at a time. When an exchange on the active path fails
+with a fatal error (see IsTemporary), the path is closed, the next dial string
+is opened, the init sequence is re-run against the device, and the exchange is
+retried on the new path.
+
+Callers blocked waiting for their turn at the Arbiter are not disturbed by a
+failover: they simply proceed, in order, on whichever path is active once the
+failover completes.
+*/
+type FailoverArb struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	dials   []string
+	init    []Command
+	mux     laneMutex //serializes access, and guards current & arb
+	current int       //index into dials of the active (or next to try) path
+	arb     Arbiter   //nil when no path is active
+	arbstop context.CancelFunc
+	log     Logger      //nil means LoggerFrom(ctx)
+	up      atomic.Bool //mirrors arb != nil, readable without f.mux
+}
+
+/*
+NewFailoverArbiter returns an opened FailoverArb over dials, which are tried in
+the order given.  timeout is used when opening each path, and init is a sequence
+of commands (without arguments) that is sent, in order, each time a path is
+opened.  Every init command must succeed for a path to be considered usable.
+
+An error is returned only if none of the dials could be opened and initialized;
+the returned FailoverArb is still usable and will try again on the next
+operation.
+*/
+func NewFailoverArbiter(ctx context.Context, timeout time.Duration, init []Command, dials ...string) (*FailoverArb, error) {
+	if len(dials) == 0 {
+		return nil, newErr(false, false, fmt.Errorf("at least one dial string is required"))
+	}
+	fctx, cancel := context.WithCancel(ctx)
+	f := &FailoverArb{
+		ctx:     fctx,
+		cancel:  cancel,
+		timeout: timeout,
+		dials:   append([]string{}, dials...),
+		init:    append([]Command{}, init...),
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f, f.connect()
+}
+
+/*
+String conforms to IDoIO.  It returns something like
+
+	Failover Arbiter over tcp connection to localhost:4000 (path 1 of 2)
+*/
+func (f *FailoverArb) String() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.arb == nil {
+		return fmt.Sprintf("Failover Arbiter over %d paths (none active)", len(f.dials))
+	}
+	return fmt.Sprintf("Failover %v (path %d of %d)", f.arb, f.current+1, len(f.dials))
+}
+
+/*
+SetLogger overrides the Logger carried by the context this FailoverArb was
+created with (see WithLogger).  Arbiters created for each path log to the same
+Logger.
+*/
+func (f *FailoverArb) SetLogger(l Logger) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.log = l
+	if a, ok := f.arb.(*Arb); ok {
+		a.SetLogger(l)
+	}
+}
+
+func (f *FailoverArb) logger() Logger {
+	if f.log != nil {
+		return f.log
+	}
+	return LoggerFrom(f.ctx)
+}
+
+/*Active returns the dial string of the currently active path*/
+func (f *FailoverArb) Active() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.dials[f.current]
+}
+
+/*
+Connected returns true if a path is currently active.  Unlike the other
+methods, it does not wait for an exchange in progress to finish.
+*/
+func (f *FailoverArb) Connected() bool { return f.up.Load() }
+
+/*Queue conforms to QueueReporter, reporting callers waiting on any path*/
+func (f *FailoverArb) Queue() QueueStatus { return f.mux.status() }
+
+/*
+Open conforms to IDoIO.  It closes the active path (ignoring errors) and
+reconnects starting with the first (primary) dial string, which allows callers
+to fail back once the primary path has been repaired.
+*/
+func (f *FailoverArb) Open() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.drop()
+	f.current = 0
+	return f.connect()
+}
+
+/*
+Close conforms to io.Closer.  The FailoverArb's context is cancelled, so it
+may not be reopened afterwards.
+*/
+func (f *FailoverArb) Close() error {
+	f.cancel()
+	f.mux.LockUrgent()
+	defer f.mux.Unlock()
+	if f.arb == nil {
+		return nil
+	}
+	err := f.arb.Close()
+	f.arb, f.arbstop = nil, nil
+	f.up.Store(false)
+	return err
+}
+
+/*
+Read conforms to io.Reader.  A fatal read error triggers a failover, and the
+read is then attempted on the new path.
+*/
+func (f *FailoverArb) Read(b []byte) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	err = f.each(func(a Arbiter) error {
+		n, err = a.Read(b)
+		return err
+	})
+	return
+}
+
+/*
+Write conforms to io.Writer.  A fatal write error triggers a failover, and the
+write is then attempted on the new path.
+*/
+func (f *FailoverArb) Write(b []byte) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	err = f.each(func(a Arbiter) error {
+		n, err = a.Write(b)
+		return err
+	})
+	return
+}
+
+/*Simple conforms to Arbiter, failing over as described by FailoverArb*/
+func (f *FailoverArb) Simple(cmd, success, failure []byte, duration time.Duration) Response {
+	return f.SimpleContext(context.Background(), cmd, success, failure, duration)
+}
+
+/*SimpleContext is Simple, given up on once ctx is done (see ContextArbiter)*/
+func (f *FailoverArb) SimpleContext(ctx context.Context, cmd, success, failure []byte, duration time.Duration) (rsp Response) {
+	if f.mux.lockContext(ctx, PriorityRoutine) != nil {
+		return Response{Error: cancelled(ctx)}
+	}
+	defer f.mux.Unlock()
+	if err := f.each(func(a Arbiter) error {
+		if ca, ok := a.(ContextArbiter); ok {
+			rsp = ca.SimpleContext(ctx, cmd, success, failure, duration)
+		} else {
+			rsp = a.Simple(cmd, success, failure, duration)
+		}
+		return rsp.Error
+	}); rsp.Error == nil {
+		rsp.Error = err
+	}
+	return
+}
+
+/*Expect conforms to Expecter, failing over as described by FailoverArb*/
+func (f *FailoverArb) Expect(out []byte, timeout time.Duration, check CheckFunc) Response {
+	return f.ExpectContext(context.Background(), out, timeout, check)
+}
+
+/*
+ExpectContext is Expect, given up on once ctx is done (see ContextArbiter).
+The paths must be Expecters, as those NewArbiter returns are.
+*/
+func (f *FailoverArb) ExpectContext(ctx context.Context, out []byte, timeout time.Duration, check CheckFunc) (rsp Response) {
+	if f.mux.lockContext(ctx, PriorityRoutine) != nil {
+		return Response{Error: cancelled(ctx)}
+	}
+	defer f.mux.Unlock()
+	if err := f.each(func(a Arbiter) error {
+		if e, ok := a.(Expecter); ok {
+			rsp = e.ExpectContext(ctx, out, timeout, check)
+		} else {
+			rsp = Response{Error: newErr(false, false, fmt.Errorf("%v can not Expect", a))}
+		}
+		return rsp.Error
+	}); rsp.Error == nil {
+		rsp.Error = err
+	}
+	return
+}
+
+/*
+RunDialog conforms to DialogRunner, failing over as described by FailoverArb.
+A dialog cut short by a broken path is run again, from the start, on the
+next.
+*/
+func (f *FailoverArb) RunDialog(ctx context.Context, d Dialog) (res DialogResult) {
+	if f.mux.lockContext(ctx, PriorityRoutine) != nil {
+		return DialogResult{Error: cancelled(ctx)}
+	}
+	defer f.mux.Unlock()
+	if err := f.each(func(a Arbiter) error {
+		if r, ok := a.(DialogRunner); ok {
+			res = r.RunDialog(ctx, d)
+		} else {
+			res = DialogResult{Error: newErr(false, false, fmt.Errorf("%v can not run dialogs", a))}
+		}
+		return res.Error
+	}); res.Error == nil {
+		res.Error = err
+	}
+	return
+}
+
+/*ControlAll conforms to Batcher, failing over as described by FailoverArb*/
+func (f *FailoverArb) ControlAll(cmds []Command, stopOnError bool) BatchResult {
+	return f.ControlAllContext(context.Background(), cmds, stopOnError)
+}
+
+/*
+ControlAllContext is ControlAll, given up on once ctx is done (see
+ContextArbiter).  A batch cut short by a broken path is sent again, from the
+start, on the next.
+*/
+func (f *FailoverArb) ControlAllContext(ctx context.Context, cmds []Command, stopOnError bool) (res BatchResult) {
+	if f.mux.lockContext(ctx, batchPriority(cmds)) != nil {
+		return BatchResult{Error: cancelled(ctx)}
+	}
+	defer f.mux.Unlock()
+	if err := f.each(func(a Arbiter) error {
+		if b, ok := a.(Batcher); ok {
+			res = b.ControlAllContext(ctx, cmds, stopOnError)
+		} else {
+			res = BatchResult{Error: newErr(false, false, fmt.Errorf("%v can not send batches", a))}
+		}
+		return res.Error
+	}); res.Error == nil {
+		res.Error = err
+	}
+	return
+}
+
+/*
+Control conforms to Arbiter, failing over as described by FailoverArb.  Urgent
+commands jump the queue of waiting callers, as for an Arb.
+*/
+func (f *FailoverArb) Control(cmd Command, args ...interface{}) Response {
+	return f.ControlContext(context.Background(), cmd, args...)
+}
+
+/*ControlContext is Control, given up on once ctx is done (see ContextArbiter)*/
+func (f *FailoverArb) ControlContext(ctx context.Context, cmd Command, args ...interface{}) (rsp Response) {
+	if f.mux.lockContext(ctx, cmd.priority()) != nil {
+		return Response{Error: cancelled(ctx)}
+	}
+	defer f.mux.Unlock()
+	if err := f.each(func(a Arbiter) error {
+		if ca, ok := a.(ContextArbiter); ok {
+			rsp = ca.ControlContext(ctx, cmd, args...)
+		} else {
+			rsp = a.Control(cmd, args...)
+		}
+		return rsp.Error
+	}); rsp.Error == nil {
+		rsp.Error = err
+	}
+	return
+}
+
+/*
+each runs op against the active path, failing over and retrying on a fatal
+error until op succeeds, op fails with a non-fatal error, or every path has
+been tried once.  The caller must hold f.mux.
+*/
+func (f *FailoverArb) each(op func(Arbiter) error) error {
+	var err error
+	for tries := 0; tries < len(f.dials); tries++ {
+		if f.arb == nil {
+			if err = f.connect(); err != nil {
+				return err
+			}
+		}
+		if err = op(f.arb); !f.fatal(err) {
+			return err
+		}
+		from := f.dials[f.current]
+		f.drop()
+		f.current = (f.current + 1) % len(f.dials)
+		f.logger().Warn("failing over", "event", EventRetry, "dial", from, "next", f.dials[f.current], "error", err)
+	}
+	return err
+}
+
+/*
+fatal returns true if err indicates that the active path is broken and
+should be abandoned.  Error responses from the device, responses too large
+to hold, and timeouts are never fatal, nor is anything once the FailoverArb's
+own context has collapsed.
+*/
+func (f *FailoverArb) fatal(err error) bool {
+	if err == nil || err == ErrErrorResponse || err == ErrResponseTooLarge || f.ctx.Err() != nil {
+		return false
+	}
+	return !IsTemporary(err)
+}
+
+/*drop closes and forgets the active path. The caller must hold f.mux.*/
+func (f *FailoverArb) drop() {
+	if f.arb != nil {
+		f.arb.Close()
+		f.arbstop()
+	}
+	f.arb, f.arbstop = nil, nil
+	f.up.Store(false)
+}
+
+/*
+connect tries each dial, starting with f.current, until one opens and
+completes the init sequence. The caller must hold f.mux.
+*/
+func (f *FailoverArb) connect() error {
+	var last error
+	for tries := 0; tries < len(f.dials); tries++ {
+		select {
+		case <-f.ctx.Done():
+			return newErr(false, false, f.ctx.Err())
+		default:
+		}
+		dial := f.dials[f.current]
+		if last = f.dial(dial); last == nil {
+			f.logger().Info("failover path active", "event", EventConnect, "dial", dial)
+			return nil
+		}
+		f.logger().Warn("failover path unusable", "event", EventError, "dial", dial, "error", last)
+		f.current = (f.current + 1) % len(f.dials)
+	}
+	return newErr(false, false, errors.Wrapf(last, "all %d failover paths failed", len(f.dials)))
+}
+
+/*dial opens a single path and runs the init sequence over it*/
+func (f *FailoverArb) dial(dial string) error {
+	idotoo, err := NewIDoIO(eager(f.ctx), f.timeout, dial)
+	if err != nil {
+		if idotoo != nil {
+			idotoo.Close()
+		}
+		return errors.Wrapf(err, "unable to open %q", dial)
+	}
+	arb, stop := Arbitrate(f.ctx, idotoo)
+	if f.log != nil {
+		arb.(*Arb).SetLogger(f.log)
+	}
+	for _, cmd := range f.init {
+		if rsp := arb.Control(cmd); rsp.Error != nil {
+			arb.Close()
+			stop()
+			return errors.Wrapf(rsp.Error, "init command %q failed on %q", cmd.Name, dial)
+		}
+	}
+	f.arb, f.arbstop = arb, stop
+	f.up.Store(true)
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewFailoverArbiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewFailoverArbiter(ctx, 100*time.Millisecond, nil); err == nil {
+		t.Error("No dial strings should return an error")
+	}
+
+	_, _, dead := freePortCfg()
+	if f, err := NewFailoverArbiter(ctx, 100*time.Millisecond, nil, dead, "serial://dontexist:9600"); err == nil {
+		t.Error("Expected an error when every path is dead")
+	} else if rsp := f.Control(arbCmdOk); rsp.Error == nil {
+		t.Error("Expected Control to fail when every path is dead")
+	}
+}
+
+func TestFailoverArb_Control(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, live := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	dead := "tcp://localhost:1"
+	malformed := "tcp://localhost:1?bogus=1"
+
+	f, err := NewFailoverArbiter(ctx, 100*time.Millisecond, []Command{arbCmdOk}, malformed, dead, live)
+	if err != nil {
+		t.Error("Backup path should have been used", err)
+		t.FailNow()
+	}
+	defer f.Close()
+	_ = f.String()
+
+	if f.Active() != live {
+		t.Errorf("Expected %q to be active, got %q", live, f.Active())
+	}
+	if rsp := f.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the command to succeed on the backup path", rsp)
+	}
+	if rsp := f.Control(arbCmdError); rsp.Error != ErrErrorResponse {
+		t.Error("Error responses should be passed along untouched", rsp)
+	}
+	if f.Active() != live {
+		t.Error("An error response should not cause a failover")
+	}
+
+	//Reopening goes back to the primary, which is still dead
+	if err := f.Open(); err != nil || f.Active() != live {
+		t.Error("Expected reopen to fall through to the backup", err)
+	}
+
+	//A failing init sequence disqualifies a path
+	if _, err := NewFailoverArbiter(ctx, 100*time.Millisecond, []Command{arbCmdError}, live); err == nil {
+		t.Error("Expected a failed init sequence to be an error")
+	}
+}
@@ -0,0 +1,203 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &FailoverClient{}
+
+/*known is added to here, as NewFailoverClient consulting it would otherwise be an initialization cycle*/
+func init() {
+	known["failover"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewFailoverClient(ctx, dur, dial)
+	}
+}
+
+/*
+FailoverClient provides an implementer of the IDoIO interface spanning
+redundant paths to the same device, under the URI regime:
+
+	failover://
+
+Only one path is active at a time.  Open tries each path in turn until one
+opens, the first time starting with the first, and thereafter with the one
+after the path last active, so each reopen rotates through the list.  Unlike
+FailoverArb, it knows nothing of the device, so failing over is left to
+whoever calls Open.
+*/
+type FailoverClient struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	dial    string
+	dials   []string
+	current int //index into dials of the active (or last active) path, -1 before the first Open
+	idoio   IDoIO
+	log     Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewFailoverClient opens the first of several paths that will.  Dial should
+be in the form of
+
+	failover://(<dial>,<dial>[,<dial>...])
+
+eg "failover://(tcp://primary:5000,tcp://backup:5000,serial:///dev/ttyS0:9600)".
+Timeout is used when opening each path.
+*/
+func NewFailoverClient(ctx context.Context, timeout time.Duration, dial string) (*FailoverClient, error) {
+	d, err := dialFor(dial, "failover")
+	dials, query := d.Dials, d.Params.Encode()
+	if err == nil && query != "" {
+		err = fmt.Errorf("unknown parameters %q", query)
+	}
+	for _, d := range dials {
+		if err == nil && factoryFor(d) == nil {
+			err = fmt.Errorf("no known way to open %q", d)
+		}
+	}
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+	fctx, cancel := context.WithCancel(ctx)
+	f := &FailoverClient{ctx: fctx, cancel: cancel, timeout: timeout, dial: dial, dials: dials, current: -1}
+	return f, openUnlessLazy(ctx, f)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this FailoverClient was
+constructed with (see WithLogger), including by the paths opened after.  It
+is not safe to call concurrently with other methods.
+*/
+func (f *FailoverClient) SetLogger(l Logger) {
+	f.log = l
+}
+
+func (f *FailoverClient) dialString() string { return f.dial }
+
+func (f *FailoverClient) logger() Logger {
+	if f.log != nil {
+		return f.log
+	}
+	return LoggerFrom(f.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (f *FailoverClient) String() string {
+	if f.idoio == nil {
+		return fmt.Sprintf("failover over %d paths (none active)", len(f.dials))
+	}
+	return fmt.Sprintf("failover to %v (path %d of %d)", f.idoio, f.current+1, len(f.dials))
+}
+
+/*Active returns the dial string of the active path, or "" if there is none*/
+func (f *FailoverClient) Active() string {
+	if f.idoio == nil {
+		return ""
+	}
+	return f.dials[f.current]
+}
+
+/*Open closes the active path (ignoring errors), and opens the next that will*/
+func (f *FailoverClient) Open() error {
+	select {
+	case <-f.ctx.Done():
+		return newErr(false, false, f.ctx.Err())
+	default:
+	}
+	if f.idoio != nil {
+		f.idoio.Close()
+		f.idoio = nil
+	}
+	ctx := eager(f.ctx) //the path has to be open to know it is usable
+	if f.log != nil {
+		ctx = WithLogger(ctx, f.log)
+	}
+	var errs []string
+	for i := 1; i <= len(f.dials); i++ {
+		next := (f.current + i) % len(f.dials)
+		idoio, err := NewIDoIO(ctx, f.timeout, f.dials[next])
+		if err == nil {
+			f.idoio, f.current = idoio, next
+			f.logger().Debug("path opened", "event", EventConnect, "dial", f.dial, "path", f.dials[next])
+			return nil
+		}
+		if idoio != nil {
+			idoio.Close()
+		}
+		f.logger().Debug("unable to open path", "event", EventRetry, "dial", f.dial, "path", f.dials[next], "error", err)
+		errs = append(errs, err.Error())
+	}
+	f.current = (f.current + 1) % len(f.dials) //so the next Open starts further along
+	f.logger().Warn("unable to open any path", "event", EventError, "dial", f.dial)
+	return newErr(false, false, fmt.Errorf("unable to open any path: %v", strings.Join(errs, "; ")))
+}
+
+/*Read conforms to io.Reader, reading from the active path*/
+func (f *FailoverClient) Read(b []byte) (int, error) {
+	select {
+	case <-f.ctx.Done():
+		defer f.Close()
+		return 0, newErr(false, false, f.ctx.Err())
+	default:
+	}
+	if f.idoio == nil {
+		return 0, readErr
+	}
+	return f.idoio.Read(b)
+}
+
+/*Write conforms to io.Writer, writing to the active path*/
+func (f *FailoverClient) Write(b []byte) (int, error) {
+	select {
+	case <-f.ctx.Done():
+		defer f.Close()
+		return 0, newErr(false, false, f.ctx.Err())
+	default:
+	}
+	if f.idoio == nil {
+		return 0, writeErr
+	}
+	return f.idoio.Write(b)
+}
+
+/*Close conforms to io.Closer, closing the active path*/
+func (f *FailoverClient) Close() error {
+	f.cancel()
+	if f.idoio == nil {
+		return nil
+	}
+	f.logger().Debug("connection closed", "event", EventDisconnect, "dial", f.dial)
+	err := f.idoio.Close()
+	f.idoio = nil
+	return err
+}
@@ -0,0 +1,79 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFailoverClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"failover://tcp://localhost:1", "failover://(tcp://localhost:1,bogus://x)", "failover://(tcp://localhost:1)?x=1"} {
+		if _, err := NewFailoverClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+
+	_, _, down := freePortCfg()
+	_, upSvr, up := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", upSvr, arbHandler)
+	dial := fmt.Sprintf("failover://(%v, %v)", down, up)
+	idoio, err := NewIDoIO(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Expected the second path to open", err)
+	}
+	f := idoio.(*FailoverClient)
+	defer f.Close()
+	_ = f.String()
+	if f.Active() != up {
+		t.Error("Expected the second path to be active, not", f.Active())
+	}
+	a, stop := Arbitrate(ctx, f)
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected a response", rsp.Error)
+	}
+	stop()
+
+	//reopening rotates back around to the first, which is still down
+	if err := f.Open(); err != nil || f.Active() != up {
+		t.Error("Expected the second path again", f.Active(), err)
+	}
+
+	_, _, down2 := freePortCfg()
+	all, err := NewFailoverClient(ctx, time.Second, fmt.Sprintf("failover://(%v,%v)", down, down2))
+	if err == nil || IsTemporary(err) || all.Active() != "" {
+		t.Error("Expected a permanent error with every path down", err)
+	}
+	all.Close()
+
+	f.Close()
+	if _, err := f.Read(make([]byte, 4)); err == nil {
+		t.Error("Expected an error reading after close")
+	}
+}
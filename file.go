@@ -0,0 +1,209 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &FileClient{}
+
+/*
+FileClient provides an implementer of the IDoIO interface over files, under
+the URI regime:
+
+	file://
+
+Reads stream bytes from one file, and Writes append to another (or the same)
+file, so captured instrument streams can be replayed through anything that
+consumes an IDoIO, and anything that produces one can be logged.
+
+Once the end of the file being read is reached, Read returns io.EOF (as a
+permanent error), unless following, in which case it returns timeout errors
+until more data is appended, as 'tail -f' would.  Open starts reading from the
+beginning again.
+*/
+type FileClient struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	dial         string
+	rpath, wpath string
+	follow       bool
+	rfile, wfile *os.File
+	log          Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewFileClient opens a file for playback.  Dial should be in the form of
+"file://<path>[?write=<path>&follow=<bool>]".  Writes are appended to the write
+path, created if need be, which defaults to path itself.  Timeout is unused.
+*/
+func NewFileClient(ctx context.Context, timeout time.Duration, dial string) (*FileClient, error) {
+	d, err := dialFor(dial, "file")
+	if err == nil && d.Device == "" {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	fctx, cancel := context.WithCancel(ctx)
+	fc := &FileClient{ctx: fctx, cancel: cancel, dial: dial, rpath: d.Device, wpath: d.Device}
+	for k, v := range d.Params {
+		switch k {
+		case "write":
+			fc.wpath = v[0]
+		case "follow":
+			fc.follow, err = strconv.ParseBool(v[0])
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			cancel()
+			return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+		}
+	}
+	return fc, openUnlessLazy(ctx, fc)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this FileClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (fc *FileClient) SetLogger(l Logger) {
+	fc.log = l
+}
+
+func (fc *FileClient) dialString() string { return fc.dial }
+
+func (fc *FileClient) logger() Logger {
+	if fc.log != nil {
+		return fc.log
+	}
+	return LoggerFrom(fc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (fc *FileClient) String() string {
+	if fc.rpath == fc.wpath {
+		return fmt.Sprintf("file %v", fc.rpath)
+	}
+	return fmt.Sprintf("file %v (writing %v)", fc.rpath, fc.wpath)
+}
+
+/*Open forcibly closes (ignoring errors) both files, then reopens them, reading from the start*/
+func (fc *FileClient) Open() (err error) {
+	select {
+	case <-fc.ctx.Done():
+		return newErr(false, false, fc.ctx.Err())
+	default:
+	}
+	fc.closeFiles()
+	if fc.rfile, err = os.Open(fc.rpath); err == nil {
+		if fc.wfile, err = os.OpenFile(fc.wpath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			fc.rfile.Close()
+		}
+	}
+	if err != nil {
+		fc.rfile, fc.wfile = nil, nil
+		fc.logger().Warn("unable to open connection", "event", EventError, "dial", fc.dial, "error", err)
+		return newErr(false, false, err)
+	}
+	fc.logger().Debug("connection opened", "event", EventConnect, "dial", fc.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader, streaming from the file being played back*/
+func (fc *FileClient) Read(b []byte) (int, error) {
+	select {
+	case <-fc.ctx.Done():
+		defer fc.Close()
+		return 0, newErr(false, false, fc.ctx.Err())
+	default:
+	}
+	if fc.rfile == nil {
+		return 0, readErr
+	}
+	n, err := fc.rfile.Read(b)
+	switch {
+	case err == io.EOF && fc.follow:
+		return n, newErr(true, true, fmt.Errorf("read: waiting for %v to grow", fc.rpath))
+	case err != nil:
+		return n, newErr(false, false, err)
+	}
+	return n, nil
+}
+
+/*Write conforms to io.Writer, appending b to the file being written*/
+func (fc *FileClient) Write(b []byte) (int, error) {
+	select {
+	case <-fc.ctx.Done():
+		defer fc.Close()
+		return 0, newErr(false, false, fc.ctx.Err())
+	default:
+	}
+	if fc.wfile == nil {
+		return 0, writeErr
+	}
+	n, err := fc.wfile.Write(b)
+	if err != nil {
+		return n, newErr(false, false, err)
+	}
+	return n, nil
+}
+
+/*Close conforms to io.Closer*/
+func (fc *FileClient) Close() error {
+	fc.cancel()
+	if fc.rfile == nil {
+		return nil
+	}
+	fc.logger().Debug("connection closed", "event", EventDisconnect, "dial", fc.dial)
+	if err := fc.closeFiles(); err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
+
+/*closeFiles closes both files, returning the first error*/
+func (fc *FileClient) closeFiles() (err error) {
+	if fc.rfile != nil {
+		err = fc.rfile.Close()
+	}
+	if fc.wfile != nil {
+		if werr := fc.wfile.Close(); err == nil {
+			err = werr
+		}
+	}
+	fc.rfile, fc.wfile = nil, nil
+	return
+}
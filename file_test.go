@@ -0,0 +1,97 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(in, []byte("$GPZDA,1\r\n$GPZDA,2\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dial := range []string{"file://", "file://" + in + "?loop=1", "file://" + in + "?follow=maybe"} {
+		if _, err := NewFileClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	if _, err := NewIDoIO(ctx, time.Second, "file://"+filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	idoio, err := NewIDoIO(ctx, time.Second, "file://"+in+"?write="+out)
+	if err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	defer idoio.Close()
+	_ = idoio.String()
+	got, err := io.ReadAll(io.LimitReader(idoio, 1024))
+	if string(got) != "$GPZDA,1\r\n$GPZDA,2\r\n" {
+		t.Error("Expected the whole file", string(got), err)
+	}
+	buf := make([]byte, 16)
+	if _, err := idoio.Read(buf); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error at the end of the file", err)
+	}
+	if err := idoio.Open(); err != nil {
+		t.Fatal("Unable to reopen", err)
+	}
+	if n, _ := idoio.Read(buf[:8]); string(buf[:n]) != "$GPZDA,1" {
+		t.Error("Expected reopening to start from the beginning", string(buf[:n]))
+	}
+	idoio.Write([]byte("one\n"))
+	idoio.Write([]byte("two\n"))
+	if b, _ := os.ReadFile(out); string(b) != "one\ntwo\n" {
+		t.Error("Expected writes appended to the write file", string(b))
+	}
+	idoio.Close()
+	if _, err := idoio.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail once closed")
+	}
+
+	fc, err := NewFileClient(ctx, time.Second, "file://"+out+"?follow=true")
+	if err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	defer fc.Close()
+	io.ReadFull(fc, buf[:8])
+	if _, err := fc.Read(buf); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout when following", err)
+	}
+	fc.Write([]byte("three\n"))
+	if n, err := fc.Read(buf); err != nil || string(buf[:n]) != "three\n" {
+		t.Error("Expected appended data to be read when following", string(buf[:n]), err)
+	}
+}
@@ -1,15 +1,15 @@
 module github.com/NCAR/agnoio
 
-go 1.17
+go 1.21
 
 require (
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/pkg/errors v0.9.1
 	go.bug.st/serial v1.5.0
+	golang.org/x/sys v0.0.0-20220829200755-d48e67d00261
 )
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
-	golang.org/x/sys v0.0.0-20220829200755-d48e67d00261 // indirect
 )
@@ -0,0 +1,409 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &GRPCClient{}
+
+/*
+GRPCClient provides an implementer of the IDoIO interface for a bidirectional
+streaming gRPC method, so instrument traffic may be tunneled through gRPC
+infrastructure, under the URI regime:
+
+	grpc://
+
+The method must stream messages of the form
+
+	message Chunk { bytes data = 1; }
+
+both ways.  Each Write is sent as a Chunk, and Reads return the data of the
+Chunks received.  Connections always use TLS, as net/http only speaks
+HTTP/2 over TLS.
+*/
+type GRPCClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	timeout   time.Duration
+	rwtimeout time.Duration
+	dial      string
+	url       string
+	token     string //sent as a bearer token, if not empty
+	tlsConfig *tls.Config
+	client    *http.Client
+	stream    *grpcStream
+	pending   []byte //received but not yet Read
+	log       Logger //nil means LoggerFrom(ctx)
+}
+
+/*grpcStream is a single call of the method*/
+type grpcStream struct {
+	cancel context.CancelFunc
+	body   *io.PipeWriter
+	chunks chan []byte
+	opened chan struct{} //closed once the response headers arrive
+	done   chan struct{} //closed once the call has ended, and err is set
+	err    error
+}
+
+/*
+NewGRPCClient calls a bidirectional streaming method.  Dial should be in the
+form of
+
+	grpc://<host>:<port>/<package.Service>/<Method>[?token=<bearer>&insecure=<bool>]
+
+eg "grpc://gateway:443/agnoio.Tunnel/Stream".  token is sent as the
+authorization metadata, and insecure skips verification of the server
+certificate.  Timeout limits how long Open waits for the server to answer
+the call; servers that only answer when they first send are presumed
+connected after it.
+*/
+func NewGRPCClient(ctx context.Context, timeout time.Duration, dial string) (*GRPCClient, error) {
+	d, err := dialFor(dial, "grpc")
+	if err == nil && !isGRPCMethod(d.Path) {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	gctx, cancel := context.WithCancel(ctx)
+	g := &GRPCClient{ctx: gctx, cancel: cancel, timeout: timeout, rwtimeout: 1 * time.Millisecond, dial: dial, url: "https://" + d.Host + d.Path, tlsConfig: &tls.Config{}}
+	if err := g.parse(d.Params.Encode()); err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	return g, openUnlessLazy(ctx, g)
+}
+
+/*isGRPCMethod reports whether path is of the form /<service>/<method>*/
+func isGRPCMethod(path string) bool {
+	parts := strings.Split(path, "/")
+	return len(parts) == 3 && parts[0] == "" && parts[1] != "" && parts[2] != ""
+}
+
+func (g *GRPCClient) parse(query string) error {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for k, v := range q {
+		switch k {
+		case "token":
+			g.token = v[0]
+		case "insecure":
+			if g.tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(v[0]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	return nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this GRPCClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (g *GRPCClient) SetLogger(l Logger) {
+	g.log = l
+}
+
+func (g *GRPCClient) dialString() string { return g.dial }
+
+func (g *GRPCClient) logger() Logger {
+	if g.log != nil {
+		return g.log
+	}
+	return LoggerFrom(g.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (g *GRPCClient) String() string {
+	return fmt.Sprintf("gRPC stream %v", g.url)
+}
+
+/*Open forcibly ends the call (ignoring errors) and calls the method again*/
+func (g *GRPCClient) Open() error {
+	select {
+	case <-g.ctx.Done():
+		return newErr(false, false, g.ctx.Err())
+	default:
+	}
+	g.end()
+	g.pending = nil
+	if g.client == nil {
+		g.client = &http.Client{Transport: &http.Transport{TLSClientConfig: g.tlsConfig, ForceAttemptHTTP2: true}}
+	}
+	sctx, cancel := context.WithCancel(g.ctx)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(sctx, http.MethodPost, g.url, pr)
+	if err != nil {
+		cancel()
+		return newErr(false, false, err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+	s := &grpcStream{cancel: cancel, body: pw, chunks: make(chan []byte, 64), opened: make(chan struct{}), done: make(chan struct{})}
+	go s.run(g.client, req)
+	wait := g.timeout
+	if wait <= 0 {
+		wait = time.Second
+	}
+	select {
+	case <-s.opened:
+	case <-time.After(wait): //the server may only answer once it sends
+	case <-s.done:
+		cancel()
+		g.logger().Warn("unable to open connection", "event", EventError, "dial", g.dial, "error", s.err)
+		return newErr(false, false, errors.Wrapf(s.err, "unable to call %v", g.url))
+	}
+	g.stream = s
+	g.logger().Debug("connection opened", "event", EventConnect, "dial", g.dial)
+	return nil
+}
+
+/*run makes the call, passing on the Chunks received until it ends*/
+func (s *grpcStream) run(client *http.Client, req *http.Request) {
+	defer close(s.done)
+	rsp, err := client.Do(req)
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer rsp.Body.Close()
+	close(s.opened)
+	if rsp.StatusCode != http.StatusOK {
+		s.err = fmt.Errorf("HTTP status %v", rsp.Status)
+		return
+	}
+	r := bufio.NewReader(rsp.Body)
+	for {
+		msg, err := grpcReadMessage(r)
+		if err != nil {
+			s.err = grpcStatus(rsp, err)
+			return
+		}
+		data, err := grpcChunkData(msg)
+		if err != nil {
+			s.err = err
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		select {
+		case s.chunks <- data:
+		case <-req.Context().Done():
+			s.err = req.Context().Err()
+			return
+		}
+	}
+}
+
+/*grpcStatus explains the end of a call, with err the error ending the response body*/
+func grpcStatus(rsp *http.Response, err error) error {
+	if err != io.EOF {
+		return err
+	}
+	status, msg := rsp.Trailer.Get("Grpc-Status"), rsp.Trailer.Get("Grpc-Message")
+	if status == "" { //a Trailers-Only response
+		status, msg = rsp.Header.Get("Grpc-Status"), rsp.Header.Get("Grpc-Message")
+	}
+	if status == "" || status == "0" {
+		return io.EOF
+	}
+	if unescaped, uerr := url.PathUnescape(msg); uerr == nil {
+		msg = unescaped
+	}
+	return fmt.Errorf("gRPC status %v: %v", status, msg)
+}
+
+/*grpcReadMessage reads a single length prefixed message*/
+func grpcReadMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are unsupported")
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+/*grpcMessage frames data as a length prefixed Chunk*/
+func grpcMessage(data []byte) []byte {
+	msg := binary.AppendUvarint([]byte{0x0a}, uint64(len(data))) //field 1, length delimited
+	msg = append(msg, data...)
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+/*grpcChunkData returns the data of a Chunk, skipping any fields it does not know*/
+func grpcChunkData(msg []byte) (data []byte, err error) {
+	malformed := fmt.Errorf("malformed Chunk")
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, malformed
+		}
+		msg = msg[n:]
+		var l uint64
+		switch tag & 7 {
+		case 0: //varint
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return nil, malformed
+			}
+		case 1: //64 bit
+			n = 8
+		case 2: //length delimited
+			if l, n = binary.Uvarint(msg); n <= 0 || l > uint64(len(msg)-n) {
+				return nil, malformed
+			}
+			if tag>>3 == 1 {
+				data = msg[n : n+int(l)]
+			}
+			n += int(l)
+		case 5: //32 bit
+			n = 4
+		default:
+			return nil, malformed
+		}
+		if n > len(msg) {
+			return nil, malformed
+		}
+		msg = msg[n:]
+	}
+	return data, nil
+}
+
+/*Read conforms to io.Reader, returning the data of Chunks received*/
+func (g *GRPCClient) Read(b []byte) (int, error) {
+	select {
+	case <-g.ctx.Done():
+		defer g.Close()
+		return 0, newErr(false, false, g.ctx.Err())
+	default:
+	}
+	if g.stream == nil {
+		return 0, readErr
+	}
+	if len(g.pending) == 0 {
+		timer := time.NewTimer(g.rwtimeout)
+		defer timer.Stop()
+		select {
+		case g.pending = <-g.stream.chunks:
+		case <-g.stream.done:
+			select {
+			case g.pending = <-g.stream.chunks: //drain those received before the end
+			default:
+				return 0, g.ended("read")
+			}
+		case <-timer.C:
+			return 0, newErr(true, true, fmt.Errorf("read: nothing received"))
+		}
+	}
+	n := copy(b, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+/*Write conforms to io.Writer, sending b as a single Chunk*/
+func (g *GRPCClient) Write(b []byte) (int, error) {
+	select {
+	case <-g.ctx.Done():
+		defer g.Close()
+		return 0, newErr(false, false, g.ctx.Err())
+	default:
+	}
+	if g.stream == nil {
+		return 0, writeErr
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if _, err := g.stream.body.Write(grpcMessage(b)); err != nil {
+		return 0, g.ended("write")
+	}
+	return len(b), nil
+}
+
+/*ended explains the end of the call, which is never temporary*/
+func (g *GRPCClient) ended(op string) error {
+	err := io.ErrClosedPipe
+	select {
+	case <-g.stream.done:
+		err = g.stream.err
+	default:
+	}
+	g.logger().Debug(op+" failed", "event", EventError, "dial", g.dial, "error", err)
+	return newErr(false, false, err)
+}
+
+/*end ends the call, if any*/
+func (g *GRPCClient) end() {
+	if g.stream == nil {
+		return
+	}
+	g.stream.body.Close()
+	g.stream.cancel()
+	g.stream = nil
+}
+
+/*Close conforms to io.Closer, ending the call*/
+func (g *GRPCClient) Close() error {
+	g.cancel()
+	if g.stream != nil {
+		g.logger().Debug("connection closed", "event", EventDisconnect, "dial", g.dial)
+		g.end()
+	}
+	return nil
+}
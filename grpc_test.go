@@ -0,0 +1,154 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*grpcHandler serves /agnoio.Tunnel/Stream, answering each Chunk with "Rxd>%d", and fails any other method*/
+func grpcHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Error("Expected a gRPC call", r.Proto, r.Header)
+		}
+		if r.URL.Path != "/agnoio.Tunnel/Stream" {
+			w.Header().Set("Grpc-Status", "12")
+			w.Header().Set("Grpc-Message", "unknown%20method")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			t.Error("Expected the token", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		br := bufio.NewReader(r.Body)
+		for {
+			msg, err := grpcReadMessage(br)
+			if err != nil {
+				break
+			}
+			data, _ := grpcChunkData(msg)
+			w.Write(grpcMessage([]byte(fmt.Sprintf("Rxd>%d", len(data)))))
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}
+}
+
+func TestGRPCMessage(t *testing.T) {
+	data := bytes.Repeat([]byte{0xAB}, 300)
+	frame := grpcMessage(data)
+	msg, err := grpcReadMessage(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal("Unable to read message", err)
+	}
+	if got, err := grpcChunkData(msg); err != nil || !bytes.Equal(got, data) {
+		t.Error("Expected the data back", err)
+	}
+	//an unknown varint field 2 and fixed32 field 3 around the data
+	if got, err := grpcChunkData(append([]byte{0x10, 0x96, 0x01, 0x1d, 1, 2, 3, 4}, msg...)); err != nil || !bytes.Equal(got, data) {
+		t.Error("Expected unknown fields to be skipped", err)
+	}
+	if _, err := grpcChunkData([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("Expected an error for a short Chunk")
+	}
+	if _, err := grpcReadMessage(bytes.NewReader([]byte{1, 0, 0, 0, 0})); err == nil {
+		t.Error("Expected an error for a compressed message")
+	}
+}
+
+func TestGRPCClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewUnstartedServer(grpcHandler(t))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	for _, dial := range []string{"grpc://" + host, "grpc://" + host + "/agnoio.Tunnel/Stream?bogus=1", "grpc://" + host + "/agnoio.Tunnel/Stream?insecure=maybe"} {
+		if _, err := NewGRPCClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	if _, err := NewGRPCClient(ctx, time.Second, "grpc://"+host+"/agnoio.Tunnel/Stream"); err == nil {
+		t.Error("Expected an error with an untrusted certificate")
+	}
+
+	idoio, err := NewIDoIO(ctx, time.Second, "grpc://"+host+"/agnoio.Tunnel/Stream?insecure=1&token=s3cret")
+	if err != nil {
+		t.Fatal("Unable to call", err)
+	}
+	g := idoio.(*GRPCClient)
+	defer g.Close()
+	_ = g.String()
+
+	a, stop := Arbitrate(ctx, g)
+	for i := 0; i < 3; i++ {
+		if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+			t.Error("Expected a response", rsp.Error)
+		}
+	}
+	stop()
+
+	if err := g.Open(); err != nil {
+		t.Error("Unable to call again", err)
+	}
+	g.Write([]byte("0123456789"))
+	small := make([]byte, 3)
+	var got []byte
+	for i := 0; i < 1000 && len(got) < len("Rxd>10"); i++ {
+		n, _ := g.Read(small)
+		got = append(got, small[:n]...)
+	}
+	if string(got) != "Rxd>10" {
+		t.Errorf("Expected the response read in pieces, got %q", got)
+	}
+
+	failing, err := NewGRPCClient(ctx, time.Second, "grpc://"+host+"/agnoio.Tunnel/Nonesuch?insecure=1")
+	if err != nil {
+		t.Fatal("Unable to call", err)
+	}
+	defer failing.Close()
+	for i := 0; i < 1000; i++ {
+		if _, err = failing.Read(small); err != nil && !IsTemporary(err) {
+			break
+		}
+	}
+	if err == nil || IsTemporary(err) || !strings.Contains(err.Error(), "unknown method") {
+		t.Error("Expected the status of a failed call", err)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO = &HIDClient{}
+
+	hidSysfs = "/sys/class/hidraw" //where hidraw devices are described, swapped in tests
+)
+
+/*
+HIDClient provides an implementer of the IDoIO interface for USB HID devices,
+such as lab instruments that present as HID rather than serial, under the URI
+regime:
+
+	hid://
+
+Each Write is sent as a single output report, prefixed with the report ID,
+so must fit the report size of the device.  Each Read returns a single input
+report, which starts with its report ID if the device numbers its reports,
+so b should be large enough for one (64 bytes for most devices).  It uses
+the hidraw interface, so is only supported on linux.
+*/
+type HIDClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	rwtimeout time.Duration
+	dial      string
+	vid, pid  uint16
+	serial    string //empty matches any
+	report    byte
+	open      func(vid, pid uint16, serial string) (deadlineConn, error)
+	dev       deadlineConn
+	log       Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewHIDClient opens a HID device.  Dial should be in the form of
+
+	hid://<vid>:<pid>[?serial=<serial>&report=<id>]
+
+eg "hid://04d8:003f", where the vendor and product IDs are in hex.  serial
+picks among several identical devices, and report is the ID of output
+reports, which is 0 for devices that do not number them.  Timeout is unused.
+*/
+func NewHIDClient(ctx context.Context, timeout time.Duration, dial string) (*HIDClient, error) {
+	d, err := dialFor(dial, "hid")
+	if err != nil {
+		return nil, err
+	}
+	vendor, product, _ := strings.Cut(d.Device, ":")
+	if !isUSBID(vendor) || !isUSBID(product) {
+		return nil, newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	vid, _ := strconv.ParseUint(vendor, 16, 16)
+	pid, _ := strconv.ParseUint(product, 16, 16)
+	hctx, cancel := context.WithCancel(ctx)
+	h := &HIDClient{ctx: hctx, cancel: cancel, rwtimeout: 1 * time.Millisecond, dial: dial, vid: uint16(vid), pid: uint16(pid), open: openHID}
+	if err := h.parse(d.Params.Encode()); err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	return h, openUnlessLazy(ctx, h)
+}
+
+func (h *HIDClient) parse(query string) error {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for k, v := range q {
+		switch k {
+		case "serial":
+			h.serial = v[0]
+		case "report":
+			id, err := strconv.ParseUint(v[0], 0, 8)
+			if err != nil {
+				return fmt.Errorf("invalid report ID %q", v[0])
+			}
+			h.report = byte(id)
+		default:
+			return fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	return nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this HIDClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (h *HIDClient) SetLogger(l Logger) {
+	h.log = l
+}
+
+func (h *HIDClient) dialString() string { return h.dial }
+
+func (h *HIDClient) logger() Logger {
+	if h.log != nil {
+		return h.log
+	}
+	return LoggerFrom(h.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (h *HIDClient) String() string {
+	return fmt.Sprintf("HID device %04x:%04x", h.vid, h.pid)
+}
+
+/*Open forcibly closes (ignoring errors) the device, then finds and opens it again*/
+func (h *HIDClient) Open() (err error) {
+	select {
+	case <-h.ctx.Done():
+		return newErr(false, false, h.ctx.Err())
+	default:
+	}
+	if h.dev != nil {
+		h.dev.Close()
+		h.dev = nil
+	}
+	if h.dev, err = h.open(h.vid, h.pid, h.serial); err != nil {
+		h.dev = nil
+		h.logger().Warn("unable to open connection", "event", EventError, "dial", h.dial, "error", err)
+		return newErr(false, false, errors.Wrapf(err, "unable to open %v", h))
+	}
+	h.logger().Debug("connection opened", "event", EventConnect, "dial", h.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader, returning a single input report*/
+func (h *HIDClient) Read(b []byte) (int, error) {
+	select {
+	case <-h.ctx.Done():
+		defer h.Close()
+		return 0, newErr(false, false, h.ctx.Err())
+	default:
+	}
+	if h.dev == nil {
+		return 0, readErr
+	}
+	if h.rwtimeout > 0 {
+		h.dev.SetReadDeadline(time.Now().Add(h.rwtimeout))
+	}
+	n, err := h.dev.Read(b)
+	return n, h.devErr("read", err)
+}
+
+/*Write conforms to io.Writer, sending b as a single output report*/
+func (h *HIDClient) Write(b []byte) (int, error) {
+	select {
+	case <-h.ctx.Done():
+		defer h.Close()
+		return 0, newErr(false, false, h.ctx.Err())
+	default:
+	}
+	if h.dev == nil {
+		return 0, writeErr
+	}
+	if h.rwtimeout > 0 {
+		h.dev.SetWriteDeadline(time.Now().Add(h.rwtimeout))
+	}
+	n, err := h.dev.Write(append([]byte{h.report}, b...))
+	if n > 0 {
+		n-- //the report ID is not the caller's
+	}
+	return n, h.devErr("write", err)
+}
+
+/*Close conforms to io.Closer*/
+func (h *HIDClient) Close() error {
+	h.cancel()
+	if h.dev == nil {
+		return nil
+	}
+	h.logger().Debug("connection closed", "event", EventDisconnect, "dial", h.dial)
+	err := h.dev.Close()
+	h.dev = nil
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
+
+/*devErr makes err conform to net.Error, logging it unless it is a deadline passing*/
+func (h *HIDClient) devErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case os.IsTimeout(err):
+		return newErr(true, true, err)
+	}
+	h.logger().Debug(op+" failed", "event", EventError, "dial", h.dial, "error", err)
+	return newErr(false, false, err)
+}
+
+/*
+findHIDRaw returns the name (eg hidraw2) of the first hidraw device under
+root whose uevent matches vid, pid and, if not empty, serial.
+*/
+func findHIDRaw(root string, vid, pid uint16, serial string) (string, error) {
+	uevents, _ := filepath.Glob(filepath.Join(root, "*", "device", "uevent"))
+	sort.Strings(uevents)
+	id := fmt.Sprintf(":%08X:%08X", vid, pid)
+	for _, uevent := range uevents {
+		f, err := os.Open(uevent)
+		if err != nil {
+			continue
+		}
+		matched, uniq := false, ""
+		for s := bufio.NewScanner(f); s.Scan(); {
+			switch k, v, _ := strings.Cut(s.Text(), "="); k {
+			case "HID_ID":
+				matched = strings.HasSuffix(strings.ToUpper(v), id)
+			case "HID_UNIQ":
+				uniq = v
+			}
+		}
+		f.Close()
+		if matched && (serial == "" || serial == uniq) {
+			return filepath.Base(filepath.Dir(filepath.Dir(uevent))), nil
+		}
+	}
+	return "", fmt.Errorf("no hidraw device %04x:%04x found", vid, pid)
+}
@@ -0,0 +1,123 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindHIDRaw(t *testing.T) {
+	root := t.TempDir()
+	for name, uevent := range map[string]string{
+		"hidraw0": "DRIVER=hid-generic\nHID_ID=0003:0000046D:0000C52B\nHID_NAME=Logitech\n",
+		"hidraw1": "DRIVER=hid-generic\nHID_ID=0003:000004D8:0000003F\nHID_UNIQ=A1\n",
+		"hidraw2": "DRIVER=hid-generic\nHID_ID=0003:000004D8:0000003F\nHID_UNIQ=B2\n",
+	} {
+		dir := filepath.Join(root, name, "device")
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "uevent"), []byte(uevent), 0644)
+	}
+	for _, tc := range []struct {
+		vid, pid uint16
+		serial   string
+		want     string
+	}{
+		{0x04d8, 0x003f, "", "hidraw1"},
+		{0x04d8, 0x003f, "B2", "hidraw2"},
+		{0x046d, 0xc52b, "", "hidraw0"},
+	} {
+		if got, err := findHIDRaw(root, tc.vid, tc.pid, tc.serial); err != nil || got != tc.want {
+			t.Errorf("Expected %v for %04x:%04x %q, got %v %v", tc.want, tc.vid, tc.pid, tc.serial, got, err)
+		}
+	}
+	if _, err := findHIDRaw(root, 0x04d8, 0x003f, "C3"); err == nil {
+		t.Error("Expected an error for a missing serial")
+	}
+}
+
+func TestHIDClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"hid://04d8", "hid://04d8:003f?report=256", "hid://04d8:003f?bogus=1"} {
+		if _, err := NewHIDClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	hidSysfs = t.TempDir()
+	defer func() { hidSysfs = "/sys/class/hidraw" }()
+	if _, err := NewIDoIO(ctx, time.Second, "hid://04d8:003f"); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error for a missing device", err)
+	}
+
+	reports := make(chan []byte, 1)
+	h := &HIDClient{ctx: ctx, cancel: func() {}, rwtimeout: time.Millisecond, vid: 0x04d8, pid: 0x003f}
+	h.parse("report=2")
+	h.open = func(vid, pid uint16, serial string) (deadlineConn, error) {
+		near, far := net.Pipe()
+		go func() {
+			buf := make([]byte, 64)
+			for {
+				n, err := far.Read(buf)
+				if err != nil {
+					return
+				}
+				reports <- append([]byte{}, buf[:n]...)
+				far.Write([]byte{1, 0xAA, 0xBB})
+			}
+		}()
+		return near, nil
+	}
+	if err := h.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	defer h.Close()
+	_ = h.String()
+
+	buf := make([]byte, 64)
+	if _, err := h.Read(buf); err == nil || !IsTimeout(err) || !IsTemporary(err) {
+		t.Error("Expected a temporary timeout without a report", err)
+	}
+	go func() {
+		if n, err := h.Write([]byte{0x10, 0x20}); n != 2 || err != nil {
+			t.Error("Unable to write a report", n, err)
+		}
+	}()
+	if got := <-reports; !bytes.Equal(got, []byte{2, 0x10, 0x20}) {
+		t.Errorf("Expected the report ID to prefix the report, got % x", got)
+	}
+	n := 0
+	for i := 0; i < 1000 && n == 0; i++ {
+		n, _ = h.Read(buf)
+	}
+	if !bytes.Equal(buf[:n], []byte{1, 0xAA, 0xBB}) {
+		t.Errorf("Expected an input report, got % x", buf[:n])
+	}
+}
@@ -0,0 +1,117 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import "context"
+
+/*
+Hooks are called as a transport's connection comes and goes, with the dial
+string of the transport, so daemons can log and alert on link churn without
+wrapping every method.  Any of them may be nil.  They are called from
+whichever goroutine is using the transport, after it has noted the change
+(so Status and Stats are up to date), and must not block for long.
+*/
+type Hooks struct {
+	OnOpen      func(dial string)            //every successful Open
+	OnClose     func(dial string)            //the connection was closed, by Close or by the transport itself
+	OnError     func(dial string, err error) //any failure other than a timeout, temporary or not
+	OnReconnect func(dial string)            //a successful Open after the first, following OnOpen
+}
+
+type hooksKey struct{}
+
+/*
+WithHooks returns a copy of ctx carrying h.  NetClient, SerialClient,
+UnixgramClient and MulticastClient built from the returned context call h (see
+also SetHooks).
+*/
+func WithHooks(ctx context.Context, h Hooks) context.Context {
+	return context.WithValue(ctx, hooksKey{}, h)
+}
+
+/*hooksFrom returns the Hooks carried by ctx, if any*/
+func hooksFrom(ctx context.Context) Hooks {
+	h, _ := ctx.Value(hooksKey{}).(Hooks)
+	return h
+}
+
+/*
+SetHooks replaces the Hooks given at construction (see WithHooks).  It is safe
+to call while the transport is in use.
+*/
+func (c *connStats) SetHooks(h Hooks) {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+	c.hooks = h
+}
+
+/*currentHooks returns the Hooks in use, for Redial*/
+func (c *connStats) currentHooks() Hooks {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+	return c.hooks
+}
+
+/*
+instrument sets the dial string passed to Hooks and logged, the Hooks and Trace
+carried by ctx, and the Logger traced to
+*/
+func (c *connStats) instrument(ctx context.Context, dial string, logger func() Logger) {
+	c.ident, c.hooks, c.trace, c.logger = dial, hooksFrom(ctx), traceFrom(ctx), logger
+}
+
+/*
+onOpen, onClose and onError return a func calling the corresponding hook, to
+be called once statsMux has been released.  statsMux must be held.
+*/
+func (c *connStats) onOpen(reopen bool) func() {
+	h, dial := c.hooks, c.ident
+	return func() {
+		if h.OnOpen != nil {
+			h.OnOpen(dial)
+		}
+		if reopen && h.OnReconnect != nil {
+			h.OnReconnect(dial)
+		}
+	}
+}
+
+func (c *connStats) onClose() func() {
+	h, dial := c.hooks, c.ident
+	return func() {
+		if h.OnClose != nil {
+			h.OnClose(dial)
+		}
+	}
+}
+
+func (c *connStats) onError(err error) func() {
+	h, dial := c.hooks, c.ident
+	return func() {
+		if h.OnError != nil {
+			h.OnError(dial, err)
+		}
+	}
+}
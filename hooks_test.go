@@ -0,0 +1,85 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+
+	var mux sync.Mutex
+	var events []string
+	note := func(event, d string) {
+		mux.Lock()
+		defer mux.Unlock()
+		if d != dial {
+			t.Errorf("Expected hooks to be given %q, got %q", dial, d)
+		}
+		events = append(events, event)
+	}
+	hctx := WithHooks(ctx, Hooks{
+		OnOpen:      func(d string) { note("open", d) },
+		OnClose:     func(d string) { note("close", d) },
+		OnError:     func(d string, err error) { note("error", d) },
+		OnReconnect: func(d string) { note("reconnect", d) },
+	})
+
+	nc, err := NewNetClient(hctx, 100*time.Millisecond, dial)
+	if err == nil {
+		t.Fatal("Expected nothing to be listening yet")
+	}
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	if err := nc.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	if err := nc.Open(); err != nil {
+		t.Fatal("Unable to reopen", err)
+	}
+	nc.Close()
+
+	expected := []string{"error", "open", "open", "reconnect", "close"}
+	mux.Lock()
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, events)
+			break
+		}
+	}
+	mux.Unlock()
+
+	nc.SetHooks(Hooks{OnOpen: func(string) { t.Error("Expected replaced hooks not to be called") }})
+	nc.SetHooks(Hooks{})
+	nc.Open()
+}
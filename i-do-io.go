@@ -28,8 +28,10 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"regexp"
+	"reflect"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 /*
@@ -49,21 +51,316 @@ type IDoIO interface {
 	Open() error
 }
 
-var known = map[*regexp.Regexp]func(context.Context, time.Duration, string) (IDoIO, error){
-	netClientRe: func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
-		return NewNetClient(ctx, dur, dial)
+/*
+Flusher is implemented by IDoIOs that can discard data in flight: received
+but not yet read, or written but not yet sent.  Discover it with a type
+assertion.
+*/
+type Flusher interface {
+	FlushInput() error
+	FlushOutput() error
+}
+
+/*
+Drainer is implemented by IDoIOs that can wait for everything written to
+have been sent.  Discover it with a type assertion.
+*/
+type Drainer interface {
+	Drain() error
+}
+
+/*
+ContextReader is implemented by IDoIOs that can wait for data for as long as
+a ctx allows, rather than giving up after their usual short read timeout, so
+an Arbiter waiting on a slow device need not keep polling it.  ReadContext
+returns a timeout error, as Read does, if ctx is done before anything
+arrives.  Discover it with a type assertion.
+*/
+type ContextReader interface {
+	ReadContext(ctx context.Context, b []byte) (int, error)
+}
+
+/*
+readContext reads r as ReadContext does.  Readers that are not ContextReaders
+are read again and again, paced by readPause, until something other than a
+timeout comes back or ctx is done.
+*/
+func readContext(ctx context.Context, r io.Reader, b []byte) (int, error) {
+	if cr, ok := r.(ContextReader); ok {
+		return cr.ReadContext(ctx, b)
+	}
+	for {
+		start := time.Now()
+		n, err := r.Read(b)
+		if n > 0 || err != nil && !IsTimeout(err) {
+			return n, err
+		}
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = newErr(true, true, ctx.Err())
+			}
+			return n, err
+		case <-time.After(readPause - time.Since(start)):
+		}
+	}
+}
+
+/*
+HalfCloser is implemented by stream IDoIOs that can shut down one direction
+of the connection, eg to signal the end of a command with CloseWrite while
+still reading the response.  Open restores both directions.  Discover it with
+a type assertion.
+*/
+type HalfCloser interface {
+	CloseWrite() error
+	CloseRead() error
+}
+
+/*
+Messenger is implemented by IDoIOs carrying datagrams, for callers that care
+where one message ends and the next begins.  ReadMessage returns one whole
+datagram (waiting no longer than Read would), and WriteMessage sends b as
+one datagram or fails.  Discover it with a type assertion.
+*/
+type Messenger interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(b []byte) error
+}
+
+/*
+Redialer is implemented by IDoIOs that can make a fresh, unopened copy of
+themselves with the same configuration, for when the context they were built
+with has been cancelled and the dial string is no longer at hand.  The copy
+uses ctx in place of that context, but keeps the Logger, Hooks and Trace of
+the original.  Discover it with a type assertion.
+*/
+type Redialer interface {
+	Redial(ctx context.Context) (IDoIO, error)
+}
+
+/*maxDatagram is the largest payload a udp datagram can carry*/
+const maxDatagram = 65535
+
+/*readMessage reads a single datagram from r, which must return one per Read*/
+func readMessage(r io.Reader) ([]byte, error) {
+	buf := make([]byte, maxDatagram)
+	n, err := r.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf[:n]...), nil
+}
+
+/*writeMessage writes b to w, which must send one datagram per Write*/
+func writeMessage(w io.Writer, b []byte) error {
+	n, err := w.Write(b)
+	if err == nil && n < len(b) {
+		err = newErr(false, false, io.ErrShortWrite)
+	}
+	return err
+}
+
+/*
+WriteAll writes all of b to w, writing the remainder again after short writes
+and temporary errors (timeouts included), until everything is written, a
+permanent error occurs or ctx is done.  The number of bytes written is returned,
+along with an error whenever that is less than len(b); if ctx is done the error
+is temporary, and a timeout if its deadline passed.
+*/
+func WriteAll(ctx context.Context, w IDoIO, b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := w.Write(b[written:])
+		written += n
+		if err != nil && !IsTemporary(err) {
+			return written, err
+		}
+		if written == len(b) {
+			break
+		}
+		var retry <-chan time.Time
+		if n == 0 && err == nil {
+			retry = time.After(time.Millisecond) //nothing to wait on, so don't spin
+		}
+		select {
+		case <-ctx.Done():
+			timeout := ctx.Err() == context.DeadlineExceeded
+			return written, newErr(true, timeout, errors.Wrapf(ctx.Err(), "wrote %d of %d bytes", written, len(b)))
+		case <-retry:
+		default:
+		}
+	}
+	return written, nil
+}
+
+/*known holds the Factory of each built in scheme, by the scheme of ParseDial*/
+var known = map[string]Factory{
+	"tcp": netFactory, "tcp4": netFactory, "tcp6": netFactory,
+	"udp": netFactory, "udp4": netFactory, "udp6": netFactory,
+	"tcp-listen": listenFactory, "tcp4-listen": listenFactory, "tcp6-listen": listenFactory,
+	"udp-listen": udpListenFactory, "udp4-listen": udpListenFactory, "udp6-listen": udpListenFactory,
+	"serial": serialFactory, "rs232": serialFactory,
+	"mcast": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewMulticastClient(ctx, dur, dial)
 	},
-	serialRe: func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
-		return NewSerialClient(ctx, dur, dial)
+	"vsock": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewVsockClient(ctx, dur, dial)
+	},
+	"ble": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewBLEClient(ctx, dur, dial)
+	},
+	"hid": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewHIDClient(ctx, dur, dial)
+	},
+	"grpc": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewGRPCClient(ctx, dur, dial)
+	},
+	"modem": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewModemClient(ctx, dur, dial)
+	},
+	"sbd": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewSBDClient(ctx, dur, dial)
+	},
+	"dmx": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewDMXClient(ctx, dur, dial)
+	},
+	"unixgram": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewUnixgramClient(ctx, dur, dial)
+	},
+	"i2c": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewI2CClient(ctx, dur, dial)
+	},
+	"spi": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewSPIClient(ctx, dur, dial)
+	},
+	"file": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewFileClient(ctx, dur, dial)
+	},
+	"mem": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewMemClient(ctx, dur, dial)
+	},
+	"null": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewNullClient(ctx, dur, dial)
+	},
+	"zmq": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewZMQClient(ctx, dur, dial)
+	},
+	"mqtt": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewMQTTClient(ctx, dur, dial)
+	},
+	"rfc2217": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewRFC2217Client(ctx, dur, dial)
+	},
+	"ssh": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewSSHClient(ctx, dur, dial)
+	},
+	"replay": func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewReplayClient(ctx, dur, dial)
 	},
 }
 
-/*NewIDoIO returns a struct the conforms to the IOStreamer interface*/
+/*the Factories of the transports known by several schemes*/
+var (
+	netFactory Factory = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewNetClient(ctx, dur, dial)
+	}
+	listenFactory Factory = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewListenClient(ctx, dur, dial)
+	}
+	udpListenFactory Factory = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewUDPListenClient(ctx, dur, dial)
+	}
+	serialFactory Factory = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewSerialClient(ctx, dur, dial)
+	}
+)
+
+type lazyOpenKey struct{}
+
+/*
+WithLazyOpen returns a copy of ctx marking transports built from it (via
+NewIDoIO or their own constructors) to be left unopened, rather than
+connecting before they are returned.  The first Open is then up to the
+caller; until then Reads and Writes fail.  Transports wrapping others built
+from the same dial string leave those unopened too, to be opened along with
+them.
+*/
+func WithLazyOpen(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lazyOpenKey{}, true)
+}
+
+/*NewIDoIODeferred is NewIDoIO, leaving the IDoIO returned unopened (see WithLazyOpen)*/
+func NewIDoIODeferred(ctx context.Context, timeout time.Duration, dial string) (IDoIO, error) {
+	return NewIDoIO(WithLazyOpen(ctx), timeout, dial)
+}
+
+/*lazyOpen reports if ctx is marked by WithLazyOpen*/
+func lazyOpen(ctx context.Context) bool {
+	lazy, _ := ctx.Value(lazyOpenKey{}).(bool)
+	return lazy
+}
+
+/*openUnlessLazy opens o, as constructors do, unless ctx is marked by WithLazyOpen*/
+func openUnlessLazy(ctx context.Context, o interface{ Open() error }) error {
+	if lazyOpen(ctx) {
+		return nil
+	}
+	return o.Open()
+}
+
+/*
+eager returns ctx without the mark of WithLazyOpen, for transports built
+from within an Open that are expected to come back opened
+*/
+func eager(ctx context.Context) context.Context {
+	if !lazyOpen(ctx) {
+		return ctx
+	}
+	return context.WithValue(ctx, lazyOpenKey{}, false)
+}
+
+/*
+isNil reports whether idoio is nil, or an interface holding a nil pointer, as
+constructors returning a concrete type do on failure
+*/
+func isNil(idoio IDoIO) bool {
+	if idoio == nil {
+		return true
+	}
+	v := reflect.ValueOf(idoio)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+/*
+NewIDoIO returns a struct the conforms to the IOStreamer interface.  Besides the
+built in schemes, any scheme added with Register (or by a plugin, see
+LoadPlugin) is understood.  A Stack's description (see ParseStack) is built,
+so layers may be given in the dial string, e.g. "tcp://gps:4001 | log".
+Whenever nothing usable can be built, an InvalidIO is returned along with the
+error, so the IDoIO returned is always safe to use, and to Close.
+*/
 func NewIDoIO(ctx context.Context, timeout time.Duration, dial string) (IDoIO, error) {
-	for re, funcptr := range known {
-		if re.MatchString(dial) {
-			return funcptr(ctx, timeout, dial)
+	if isStackDesc(dial) {
+		s, err := ParseStack(dial)
+		if err != nil {
+			return InvalidIO(err.Error()), err
+		}
+		return s.Build(ctx, timeout)
+	}
+	if f := factoryFor(dial); f != nil {
+		idoio, err := f(ctx, timeout, dial)
+		if isNil(idoio) {
+			if err == nil {
+				err = newErr(false, false, fmt.Errorf("Nothing created from %q", dial))
+			}
+			return InvalidIO(err.Error()), err
 		}
+		return idoio, err
 	}
 	err := newErr(false, false, fmt.Errorf("No known way to create a IOStreamer from %q", dial))
 	return InvalidIO(err.Error()), err
@@ -26,7 +26,9 @@ SOFTWARE.
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestNewIDoIO(t *testing.T) {
@@ -45,3 +47,126 @@ func TestNewIDoIO(t *testing.T) {
 		}
 	}
 }
+
+func TestNewIDoIO_Invalid(t *testing.T) {
+	//constructors returning a concrete type hand back nil when they fail
+	dial := "modem:///dev/agnoio-no-such-port:9600/5551234"
+	idoio, err := NewIDoIO(context.Background(), 10*time.Millisecond, dial)
+	if err == nil {
+		t.Fatal("Expected", dial, "to fail")
+	}
+	if _, ok := idoio.(InvalidIO); !ok {
+		t.Fatalf("Expected an InvalidIO, got %T", idoio)
+	}
+	if idoio.Close() == nil || idoio.String() == "" {
+		t.Error("Expected the InvalidIO to fail, and describe why")
+	}
+}
+
+func TestNewIDoIO_BadParameters(t *testing.T) {
+	ctx := context.Background()
+	for _, dial := range []string{
+		"tcp://localhost:1?bogus=1", "udp://localhost:1?bogus=1", "serial:///dev/null:9600?bogus=1",
+		"mcast://239.0.0.1:5000?bogus=1", "zmq://localhost:1?bogus=1", "mqtt://localhost:1?bogus=1",
+		"tee://(null://)?bogus=1", "throttle://(null://)?bogus=1", "record://(null://)?bogus=1",
+		"reconnect://(null://)?bogus=1", "replay:///nonexistent?bogus=1", "file:///nonexistent/x?bogus=1",
+		"spi:///dev/null?bogus=1", "ssh://localhost?bogus=1",
+	} {
+		idoio, err := NewIDoIO(ctx, 10*time.Millisecond, dial)
+		if err == nil {
+			t.Error("Expected", dial, "to fail")
+		}
+		if _, ok := idoio.(InvalidIO); !ok {
+			t.Errorf("%s: expected an InvalidIO, got %T", dial, idoio)
+			continue
+		}
+		idoio.Close()
+	}
+
+	//closing an Arbiter over a bad dial is safe too
+	a, err := NewArbiter(context.Background(), 10*time.Millisecond, "tcp://localhost:1?bogus=1")
+	if err == nil {
+		t.Error("Expected the Arbiter to fail")
+	}
+	a.Close()
+}
+
+func TestNewIDoIODeferred(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	port, srvdial, dial := freePortCfg()
+	idoio, err := NewIDoIODeferred(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Expected nothing to be dialed yet", err)
+	}
+	defer idoio.Close()
+	if _, err := idoio.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail before Open")
+	}
+	if err := idoio.Open(); err == nil {
+		t.Error("Expected Open to fail with nothing listening")
+	}
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	if err := idoio.Open(); err != nil {
+		t.Error("Expected Open to connect", err)
+	}
+
+	//a failover path is only usable once open, whatever the context says
+	fo, err := NewIDoIODeferred(ctx, time.Second, fmt.Sprintf("failover://(tcp://localhost:%d)", port))
+	if err != nil {
+		t.Fatal("Unable to build", err)
+	}
+	defer fo.Close()
+	if err := fo.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	if _, err := fo.Write([]byte("x")); err != nil {
+		t.Error("Expected the path opened to be usable", err)
+	}
+}
+
+/*stingyIO writes at most two bytes at a time, failing temporarily every other write*/
+type stingyIO struct {
+	IDoIO
+	calls int
+}
+
+func (s *stingyIO) Write(b []byte) (int, error) {
+	if s.calls++; s.calls%2 == 0 {
+		return 0, newErr(true, true, fmt.Errorf("stingy"))
+	}
+	if len(b) > 2 {
+		b = b[:2]
+	}
+	return s.IDoIO.Write(b)
+}
+
+func TestWriteAll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	inner := &bufIO{}
+	if n, err := WriteAll(ctx, &stingyIO{IDoIO: inner}, []byte("Hello, World")); n != 12 || err != nil || inner.tx.String() != "Hello, World" {
+		t.Errorf("Expected everything to be written, wrote %d %q %v", n, inner.tx.String(), err)
+	}
+	if n, err := WriteAll(ctx, &stingyIO{IDoIO: inner}, nil); n != 0 || err != nil {
+		t.Error("Expected nothing to write to succeed", n, err)
+	}
+
+	a, b := NewMemPair(ctx)
+	b.Close()
+	if _, err := WriteAll(ctx, a, []byte("gone")); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error to end the write", err)
+	}
+
+	short, scancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer scancel()
+	null, _ := NewNullClient(ctx, time.Millisecond, "null://")
+	if n, err := WriteAll(short, &stingyIO{IDoIO: &zeroIO{null}}, []byte("never")); n != 0 || err == nil || !IsTimeout(err) {
+		t.Error("Expected the context's deadline to end the write with a timeout", n, err)
+	}
+}
+
+/*zeroIO never writes anything, without complaint*/
+type zeroIO struct{ IDoIO }
+
+func (zeroIO) Write([]byte) (int, error) { return 0, nil }
@@ -0,0 +1,222 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO = &I2CClient{}
+)
+
+/*I2CReadLen is the default number of bytes an I2CClient reads per Read*/
+const I2CReadLen = 32
+
+/*
+I2CClient provides an implementer of the IDoIO interface for a slave device on
+a Linux I2C bus, via the i2c-dev interface, under the URI regime:
+
+	i2c://
+
+Each Write is sent to the slave as a single transaction.  I2C slaves only
+speak when read, and the master chooses how many bytes to read, so each Read
+is a single read transaction of min(len(b), ReadLen) bytes (see SetReadLen),
+and always returns that many bytes (or an error).
+*/
+type I2CClient struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	dial    string
+	dev     string
+	addr    uint16
+	readLen int
+	open    func(dev string, addr uint16) (io.ReadWriteCloser, error)
+	bus     io.ReadWriteCloser
+	log     Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewI2CClient opens a slave on an I2C bus.  Dial should be in the form of
+"i2c://<device>:<address>", eg "i2c:///dev/i2c-1:0x48", where the 7 bit
+address may be given in hex or decimal.
+*/
+func NewI2CClient(ctx context.Context, timeout time.Duration, dial string) (*I2CClient, error) {
+	d, err := dialFor(dial, "i2c")
+	if err == nil && (!strings.HasPrefix(d.Device, "/") || d.Path == "") {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	addr, err := strconv.ParseUint(d.Path, 0, 16)
+	if err != nil || addr > 0x7f {
+		return nil, newErr(false, false, fmt.Errorf("invalid I2C address %q", d.Path))
+	}
+	i := newI2CClient(ctx, timeout, d.Device, uint16(addr), openI2C)
+	i.dial = dial
+	return i, openUnlessLazy(ctx, i)
+}
+
+func newI2CClient(ctx context.Context, timeout time.Duration, dev string, addr uint16, open func(string, uint16) (io.ReadWriteCloser, error)) *I2CClient {
+	nctx, cancel := context.WithCancel(ctx)
+	return &I2CClient{ctx: nctx, cancel: cancel, timeout: timeout, dev: dev, addr: addr, readLen: I2CReadLen, open: open}
+}
+
+/*
+SetLogger overrides the Logger carried by the context this I2CClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (i *I2CClient) SetLogger(l Logger) {
+	i.log = l
+}
+
+/*
+SetReadLen sets the most bytes read from the slave by each Read, which
+defaults to I2CReadLen.  It is not safe to call concurrently with other
+methods.
+*/
+func (i *I2CClient) SetReadLen(n int) {
+	if n > 0 {
+		i.readLen = n
+	}
+}
+
+func (i *I2CClient) dialString() string { return i.dial }
+
+func (i *I2CClient) logger() Logger {
+	if i.log != nil {
+		return i.log
+	}
+	return LoggerFrom(i.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (i *I2CClient) String() string {
+	return fmt.Sprintf("I2C slave 0x%02x on %v", i.addr, i.dev)
+}
+
+/*Open forcibly closes (ignoring errors) the bus, then reopens it and selects the slave*/
+func (i *I2CClient) Open() (err error) {
+	select {
+	case <-i.ctx.Done():
+		return newErr(false, false, i.ctx.Err())
+	default:
+	}
+	if i.bus != nil {
+		i.bus.Close()
+		i.bus = nil
+	}
+	if i.bus, err = i.open(i.dev, i.addr); err != nil {
+		i.bus = nil
+		i.logger().Warn("unable to open connection", "event", EventError, "dial", i.dial, "error", err)
+		return newErr(false, false, errors.Wrapf(err, "unable to open I2C slave 0x%02x on %v", i.addr, i.dev))
+	}
+	i.logger().Debug("connection opened", "event", EventConnect, "dial", i.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader, reading min(len(b), ReadLen) bytes from the slave*/
+func (i *I2CClient) Read(b []byte) (int, error) {
+	select {
+	case <-i.ctx.Done():
+		defer i.Close()
+		return 0, newErr(false, false, i.ctx.Err())
+	default:
+	}
+	if i.bus == nil {
+		return 0, readErr
+	}
+	if len(b) > i.readLen {
+		b = b[:i.readLen]
+	}
+	n, err := i.bus.Read(b)
+	return n, i.busErr("read", err)
+}
+
+/*Write conforms to io.Writer, sending b to the slave in one transaction*/
+func (i *I2CClient) Write(b []byte) (int, error) {
+	select {
+	case <-i.ctx.Done():
+		defer i.Close()
+		return 0, newErr(false, false, i.ctx.Err())
+	default:
+	}
+	if i.bus == nil {
+		return 0, writeErr
+	}
+	n, err := i.bus.Write(b)
+	return n, i.busErr("write", err)
+}
+
+/*Close conforms to io.Closer*/
+func (i *I2CClient) Close() error {
+	i.cancel()
+	if i.bus == nil {
+		return nil
+	}
+	i.logger().Debug("connection closed", "event", EventDisconnect, "dial", i.dial)
+	err := i.bus.Close()
+	i.bus = nil
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
+
+/*busErr logs err, and makes it conform to net.Error.  A slave not acknowledging is temporary.*/
+func (i *I2CClient) busErr(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	i.logger().Debug(op+" failed", "event", EventError, "dial", i.dial, "error", err)
+	return newErr(isNACK(err), false, err)
+}
+
+/*openI2CFile opens dev and selects the slave at addr with setSlave*/
+func openI2CFile(dev string, addr uint16, setSlave func(*os.File, uint16) error) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSlave(f, addr); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const i2cSlave = 0x0703 //the I2C_SLAVE ioctl from linux/i2c-dev.h
+
+func openI2C(dev string, addr uint16) (io.ReadWriteCloser, error) {
+	return openI2CFile(dev, addr, func(f *os.File, addr uint16) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(addr)); errno != 0 {
+			return os.NewSyscallError("ioctl I2C_SLAVE", errno)
+		}
+		return nil
+	})
+}
+
+/*isNACK reports if err is the bus driver reporting the slave did not acknowledge*/
+func isNACK(err error) bool {
+	return errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.EREMOTEIO)
+}
@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*fakeI2C records writes, and reads from a fixed register file*/
+type fakeI2C struct {
+	wrote  bytes.Buffer
+	regs   []byte
+	nack   bool
+	closed bool
+}
+
+func (f *fakeI2C) Write(b []byte) (int, error) { return f.wrote.Write(b) }
+func (f *fakeI2C) Close() error                { f.closed = true; return nil }
+func (f *fakeI2C) Read(b []byte) (int, error) {
+	if f.nack {
+		return 0, syscall.ENXIO
+	}
+	return copy(b, f.regs), nil
+}
+
+func TestI2CClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, dial := range []string{"i2c://dev/i2c-1:0x48", "i2c:///dev/i2c-1", "i2c:///dev/i2c-1:0x80", "i2c:///dev/i2c-1:200"} {
+		if _, err := NewI2CClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	if _, err := NewIDoIO(ctx, time.Second, "i2c:///dev/does-not-exist:0x48"); err == nil {
+		t.Error("Expected an error opening a missing bus")
+	}
+
+	bus := &fakeI2C{regs: bytes.Repeat([]byte{0xAB}, 64)}
+	var gotDev string
+	var gotAddr uint16
+	i := newI2CClient(ctx, time.Second, "/dev/i2c-1", 0x48, func(dev string, addr uint16) (io.ReadWriteCloser, error) {
+		gotDev, gotAddr = dev, addr
+		return bus, nil
+	})
+	if err := i.Open(); err != nil || gotDev != "/dev/i2c-1" || gotAddr != 0x48 {
+		t.Fatal("Expected the slave to be selected", gotDev, gotAddr, err)
+	}
+	if i.String() != "I2C slave 0x48 on /dev/i2c-1" {
+		t.Error("Unexpected String", i.String())
+	}
+	if n, err := i.Write([]byte{0x01, 0x60}); err != nil || n != 2 || !bytes.Equal(bus.wrote.Bytes(), []byte{0x01, 0x60}) {
+		t.Error("Expected the write to reach the slave", n, err)
+	}
+	buf := make([]byte, 1024)
+	if n, err := i.Read(buf); err != nil || n != I2CReadLen {
+		t.Error("Expected reads limited to the default length", n, err)
+	}
+	i.SetReadLen(2)
+	if n, err := i.Read(buf); err != nil || n != 2 {
+		t.Error("Expected reads limited to the set length", n, err)
+	}
+	bus.nack = true
+	if _, err := i.Read(buf); err == nil || IsTemporary(err) != (runtime.GOOS == "linux") {
+		t.Error("Expected a NACK to be temporary on linux", err)
+	}
+	if err := i.Close(); err != nil || !bus.closed {
+		t.Error("Expected the bus to be closed", err)
+	}
+	if _, err := i.Read(buf); err == nil {
+		t.Error("Expected reads to fail once closed")
+	}
+
+	failed := newI2CClient(ctx, time.Second, "/dev/i2c-1", 0x48, func(string, uint16) (io.ReadWriteCloser, error) {
+		return nil, fmt.Errorf("no such slave")
+	})
+	if err := failed.Open(); err == nil || IsTemporary(err) {
+		t.Error("Expected a failed open to be permanent", err)
+	}
+}
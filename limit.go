@@ -0,0 +1,103 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*OverflowPolicy says what to do with a response that outgrows its limit*/
+type OverflowPolicy int
+
+const (
+	//OverflowFail ends the exchange with ErrResponseTooLarge
+	OverflowFail OverflowPolicy = iota
+	//OverflowTrim discards the oldest bytes, keeping only the trailing limit's worth
+	OverflowTrim
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowFail:
+		return "fail"
+	case OverflowTrim:
+		return "trim"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+/*MarshalText implements encoding.TextMarshaler*/
+func (p OverflowPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+/*UnmarshalText implements encoding.TextUnmarshaler, accepting "fail" or "trim"*/
+func (p *OverflowPolicy) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "fail", "":
+		*p = OverflowFail
+	case "trim":
+		*p = OverflowTrim
+	default:
+		return fmt.Errorf("invalid overflow policy %q", b)
+	}
+	return nil
+}
+
+var (
+	limitMux     sync.RWMutex
+	defaultLimit responseLimit
+)
+
+/*
+SetDefaultResponseLimit sets the package-wide limit on the bytes an Arbiter
+holds while waiting on a response, used by Simple and by Commands that do not
+set MaxResponseBytes, and what to do once it is reached.  A limit of zero or
+less, the default, means no limit.
+*/
+func SetDefaultResponseLimit(max int, policy OverflowPolicy) {
+	limitMux.Lock()
+	defer limitMux.Unlock()
+	defaultLimit = responseLimit{max: max, policy: policy}
+}
+
+/*responseLimit bounds the response readUntil collects*/
+type responseLimit struct {
+	max     int //no limit unless positive
+	policy  OverflowPolicy
+	trimmed func(n int) //told of the bytes discarded under OverflowTrim
+}
+
+/*limitFor returns the limit for cmd's responses, falling back to the package default*/
+func limitFor(cmd Command) responseLimit {
+	if cmd.MaxResponseBytes != 0 {
+		return responseLimit{max: cmd.MaxResponseBytes, policy: cmd.Overflow}
+	}
+	limitMux.RLock()
+	defer limitMux.RUnlock()
+	return defaultLimit
+}
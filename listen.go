@@ -0,0 +1,493 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO = &ListenClient{}
+	_ IDoIO = &UDPListenClient{}
+)
+
+/*
+ListenClient provides an implementer of the IDoIO interface for the passive
+side of a tcp connection, under the URI regimes:
+
+	tcp-listen://
+	tcp4-listen://
+	tcp6-listen://
+
+It listens, and exposes the connection of a single client, for data loggers
+and the like that dial out rather than being dialed.  Should another client
+connect, it replaces the current one, as that is usually the same device
+reconnecting after the old connection silently died.  Until a client connects,
+Reads and Writes fail with temporary timeout errors.  Once the client
+disconnects, they fail with permanent errors until Open, which drops the old
+connection (if any) and waits for the next client, listening again if need
+be.
+
+Behind a load balancer speaking the HAProxy PROXY protocol, add
+?proxyproto=true to have the header each client is prefixed with stripped
+(version 1 or 2), and Peer report the address it gives.
+*/
+type ListenClient struct {
+	ctx              context.Context
+	cancel           context.CancelFunc
+	dial             string
+	network, address string
+	proxyHdr         bool //strip a PROXY protocol header from each client
+	timeout          time.Duration
+	rwtimeout        time.Duration
+	log              Logger //nil means LoggerFrom(ctx)
+
+	mux  sync.Mutex //guards everything below
+	ln   net.Listener
+	conn net.Conn
+}
+
+/*
+NewListenClient listens for a client to connect.  Dial should be in the form
+of "tcp[46]-listen://<host>:<port>[?proxyproto=<bool>]", eg
+"tcp-listen://0.0.0.0:5000".  Timeout bounds the wait for a PROXY header, if
+one is expected, defaulting to 5s.
+*/
+func NewListenClient(ctx context.Context, timeout time.Duration, dial string) (*ListenClient, error) {
+	d, err := dialFor(dial, "tcp-listen", "tcp4-listen", "tcp6-listen")
+	if err != nil {
+		return nil, err
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	lc := &ListenClient{ctx: lctx, cancel: cancel, dial: dial, network: strings.TrimSuffix(d.Scheme, "-listen"), address: d.Host, timeout: timeout, rwtimeout: 1 * time.Millisecond}
+	for k, v := range d.Params {
+		if err != nil {
+			break
+		}
+		switch k {
+		case "proxyproto":
+			lc.proxyHdr, err = strconv.ParseBool(v[0])
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	return lc, openUnlessLazy(ctx, lc)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this ListenClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (lc *ListenClient) SetLogger(l Logger) {
+	lc.log = l
+}
+
+func (lc *ListenClient) dialString() string { return lc.dial }
+
+func (lc *ListenClient) logger() Logger {
+	if lc.log != nil {
+		return lc.log
+	}
+	return LoggerFrom(lc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (lc *ListenClient) String() string {
+	return fmt.Sprintf("%v listener on %v", lc.network, lc.Addr())
+}
+
+/*Addr returns the address listened on, which tells the port chosen if it was given as 0*/
+func (lc *ListenClient) Addr() string {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	if lc.ln == nil {
+		return lc.address
+	}
+	return lc.ln.Addr().String()
+}
+
+/*Connected reports if a client is connected*/
+func (lc *ListenClient) Connected() bool {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	return lc.conn != nil
+}
+
+/*
+Peer returns the address of the connected client, as given by its PROXY
+header if there was one, or nil if there is no client
+*/
+func (lc *ListenClient) Peer() net.Addr {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	if lc.conn == nil {
+		return nil
+	}
+	return lc.conn.RemoteAddr()
+}
+
+/*Open drops the current client, if any, listening for the next if not already*/
+func (lc *ListenClient) Open() error {
+	select {
+	case <-lc.ctx.Done():
+		return newErr(false, false, lc.ctx.Err())
+	default:
+	}
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	if lc.conn != nil {
+		lc.conn.Close()
+		lc.conn = nil
+	}
+	if lc.ln != nil {
+		return nil
+	}
+	var lcfg net.ListenConfig
+	ln, err := lcfg.Listen(lc.ctx, lc.network, lc.address)
+	if err != nil { //implements net.Error
+		lc.logger().Warn("unable to listen", "event", EventError, "dial", lc.dial, "error", err)
+		return err
+	}
+	lc.ln = ln
+	go lc.accept(ln)
+	return nil
+}
+
+/*accept hands each client connecting to ln over to Read and Write, until ln is closed*/
+func (lc *ListenClient) accept(ln net.Listener) {
+	go func() {
+		<-lc.ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			lc.mux.Lock()
+			if lc.ln == ln {
+				lc.ln = nil //so the next Open listens again
+			}
+			lc.mux.Unlock()
+			return
+		}
+		if lc.proxyHdr {
+			if conn, err = lc.stripProxy(conn); err != nil {
+				lc.logger().Warn("client dropped", "event", EventError, "dial", lc.dial, "error", err)
+				continue
+			}
+		}
+		lc.mux.Lock()
+		if lc.conn != nil {
+			lc.logger().Info("client replaced", "event", EventDisconnect, "dial", lc.dial, "remote", lc.conn.RemoteAddr().String())
+			lc.conn.Close()
+		}
+		lc.conn = conn
+		lc.mux.Unlock()
+		lc.logger().Debug("client connected", "event", EventConnect, "dial", lc.dial, "remote", conn.RemoteAddr().String())
+	}
+}
+
+/*stripProxy reads the PROXY header conn starts with, closing conn if it cannot*/
+func (lc *ListenClient) stripProxy(conn net.Conn) (net.Conn, error) {
+	timeout := lc.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	pc := &proxiedConn{Conn: conn, r: bufio.NewReader(conn)}
+	var err error
+	if pc.src, pc.dst, err = readProxyHeader(pc.r); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "no PROXY header from %v", conn.RemoteAddr())
+	}
+	conn.SetReadDeadline(time.Time{})
+	return pc, nil
+}
+
+/*current returns the connected client, or an error if there is none*/
+func (lc *ListenClient) current() (net.Conn, error) {
+	select {
+	case <-lc.ctx.Done():
+		defer lc.Close()
+		return nil, newErr(false, false, lc.ctx.Err())
+	default:
+	}
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	if lc.conn == nil {
+		return nil, newErr(true, true, fmt.Errorf("no client connected to %v", lc.dial))
+	}
+	return lc.conn, nil
+}
+
+/*Read conforms to io.Reader, reading from the connected client*/
+func (lc *ListenClient) Read(b []byte) (int, error) {
+	conn, err := lc.current()
+	if err != nil {
+		if lc.rwtimeout > 0 && IsTimeout(err) {
+			time.Sleep(lc.rwtimeout) //as long as a read from a quiet client would take
+		}
+		return 0, err
+	}
+	if lc.rwtimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(lc.rwtimeout))
+	}
+	return conn.Read(b)
+}
+
+/*Write conforms to io.Writer, writing to the connected client*/
+func (lc *ListenClient) Write(b []byte) (int, error) {
+	conn, err := lc.current()
+	if err != nil {
+		return 0, err
+	}
+	if lc.rwtimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(lc.rwtimeout))
+	}
+	return conn.Write(b)
+}
+
+/*Close conforms to io.Closer, disconnecting the client and no longer listening*/
+func (lc *ListenClient) Close() error {
+	lc.cancel()
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	var err error
+	if lc.conn != nil {
+		lc.logger().Debug("connection closed", "event", EventDisconnect, "dial", lc.dial)
+		err = lc.conn.Close()
+		lc.conn = nil
+	}
+	if lc.ln != nil {
+		lc.ln.Close()
+		lc.ln = nil
+	}
+	return err
+}
+
+/*
+UDPListenClient provides an implementer of the IDoIO interface for
+instruments that stream udp to a configured destination, under the URI
+regimes:
+
+	udp-listen://
+	udp4-listen://
+	udp6-listen://
+
+It binds a local port and learns its peer from the first datagram received,
+after which Reads return only datagrams from that peer, and Writes are sent
+to it.  Until a peer is learned, Writes fail with temporary errors.  Open
+forgets the peer, so the next to send becomes the peer.
+*/
+type UDPListenClient struct {
+	ctx              context.Context
+	cancel           context.CancelFunc
+	dial             string
+	network, address string
+	rwtimeout        time.Duration
+	log              Logger //nil means LoggerFrom(ctx)
+
+	mux  sync.Mutex //guards everything below
+	conn net.PacketConn
+	peer net.Addr
+}
+
+/*
+NewUDPListenClient binds a local port.  Dial should be in the form of
+"udp[46]-listen://<host>:<port>", eg "udp-listen://0.0.0.0:5000".  Timeout
+is unused.
+*/
+func NewUDPListenClient(ctx context.Context, timeout time.Duration, dial string) (*UDPListenClient, error) {
+	d, err := dialFor(dial, "udp-listen", "udp4-listen", "udp6-listen")
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	uctx, cancel := context.WithCancel(ctx)
+	uc := &UDPListenClient{ctx: uctx, cancel: cancel, dial: dial, network: strings.TrimSuffix(d.Scheme, "-listen"), address: d.Host, rwtimeout: 1 * time.Millisecond}
+	return uc, openUnlessLazy(ctx, uc)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this UDPListenClient
+was constructed with (see WithLogger).  It is not safe to call concurrently
+with other methods.
+*/
+func (uc *UDPListenClient) SetLogger(l Logger) {
+	uc.log = l
+}
+
+func (uc *UDPListenClient) dialString() string { return uc.dial }
+
+func (uc *UDPListenClient) logger() Logger {
+	if uc.log != nil {
+		return uc.log
+	}
+	return LoggerFrom(uc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (uc *UDPListenClient) String() string {
+	return fmt.Sprintf("%v listener on %v", uc.network, uc.Addr())
+}
+
+/*Addr returns the address bound, which tells the port chosen if it was given as 0*/
+func (uc *UDPListenClient) Addr() string {
+	uc.mux.Lock()
+	defer uc.mux.Unlock()
+	if uc.conn == nil {
+		return uc.address
+	}
+	return uc.conn.LocalAddr().String()
+}
+
+/*Peer returns the address of the peer learned, or nil if there is none yet*/
+func (uc *UDPListenClient) Peer() net.Addr {
+	uc.mux.Lock()
+	defer uc.mux.Unlock()
+	return uc.peer
+}
+
+/*Open forgets the peer, binding the local port if not already*/
+func (uc *UDPListenClient) Open() error {
+	select {
+	case <-uc.ctx.Done():
+		return newErr(false, false, uc.ctx.Err())
+	default:
+	}
+	uc.mux.Lock()
+	defer uc.mux.Unlock()
+	uc.peer = nil
+	if uc.conn != nil {
+		return nil
+	}
+	var lcfg net.ListenConfig
+	conn, err := lcfg.ListenPacket(uc.ctx, uc.network, uc.address)
+	if err != nil { //implements net.Error
+		uc.logger().Warn("unable to listen", "event", EventError, "dial", uc.dial, "error", err)
+		return err
+	}
+	uc.conn = conn
+	return nil
+}
+
+/*Read conforms to io.Reader, returning a datagram from the peer, learning it if need be*/
+func (uc *UDPListenClient) Read(b []byte) (int, error) {
+	select {
+	case <-uc.ctx.Done():
+		defer uc.Close()
+		return 0, newErr(false, false, uc.ctx.Err())
+	default:
+	}
+	uc.mux.Lock()
+	conn := uc.conn
+	uc.mux.Unlock()
+	if conn == nil {
+		return 0, readErr
+	}
+	if uc.rwtimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(uc.rwtimeout))
+	}
+	n, from, err := conn.ReadFrom(b)
+	if err != nil {
+		return 0, err
+	}
+	uc.mux.Lock()
+	defer uc.mux.Unlock()
+	switch {
+	case uc.peer == nil:
+		uc.peer = from
+		uc.logger().Debug("peer learned", "event", EventConnect, "dial", uc.dial, "remote", from.String())
+	case uc.peer.String() != from.String():
+		return 0, newErr(true, true, fmt.Errorf("read: ignored a datagram from %v", from))
+	}
+	return n, nil
+}
+
+/*Write conforms to io.Writer, sending b to the peer as a single datagram*/
+func (uc *UDPListenClient) Write(b []byte) (int, error) {
+	select {
+	case <-uc.ctx.Done():
+		defer uc.Close()
+		return 0, newErr(false, false, uc.ctx.Err())
+	default:
+	}
+	uc.mux.Lock()
+	conn, peer := uc.conn, uc.peer
+	uc.mux.Unlock()
+	switch {
+	case conn == nil:
+		return 0, writeErr
+	case peer == nil:
+		return 0, newErr(true, false, fmt.Errorf("write: no peer learned on %v", uc.dial))
+	}
+	if uc.rwtimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(uc.rwtimeout))
+	}
+	return conn.WriteTo(b, peer)
+}
+
+/*ReadMessage conforms to Messenger, returning a single datagram from the peer*/
+func (uc *UDPListenClient) ReadMessage() ([]byte, error) {
+	return readMessage(uc)
+}
+
+/*WriteMessage conforms to Messenger, sending b to the peer as a single datagram*/
+func (uc *UDPListenClient) WriteMessage(b []byte) error {
+	return writeMessage(uc, b)
+}
+
+/*Close conforms to io.Closer, no longer listening*/
+func (uc *UDPListenClient) Close() error {
+	uc.cancel()
+	uc.mux.Lock()
+	defer uc.mux.Unlock()
+	uc.peer = nil
+	if uc.conn == nil {
+		return nil
+	}
+	uc.logger().Debug("connection closed", "event", EventDisconnect, "dial", uc.dial)
+	err := uc.conn.Close()
+	uc.conn = nil
+	return err
+}
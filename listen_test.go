@@ -0,0 +1,186 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*dialIn connects to lc as a data logger would, answering "Rxd>%d" to whatever it is sent*/
+func dialIn(t *testing.T, lc *ListenClient) net.Conn {
+	t.Helper()
+	con, err := net.Dial("tcp", lc.Addr())
+	if err != nil {
+		t.Fatal("Unable to connect", err)
+	}
+	go arbHandler(t, con)
+	for i := 0; i < 100 && !lc.Connected(); i++ {
+		<-time.After(time.Millisecond)
+	}
+	return con
+}
+
+func TestListenClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewListenClient(ctx, time.Second, "tcp-listen://localhost"); err == nil {
+		t.Error("Expected an error without a port")
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, "tcp-listen://localhost:0")
+	if err != nil {
+		t.Fatal("Unable to listen", err)
+	}
+	lc := idoio.(*ListenClient)
+	defer lc.Close()
+	_ = lc.String()
+	if _, err := NewIDoIO(ctx, time.Second, "tcp-listen://"+lc.Addr()); err == nil {
+		t.Error("Expected an error listening on a port in use")
+	}
+
+	buf := make([]byte, 16)
+	if _, err := lc.Read(buf); err == nil || !IsTimeout(err) || !IsTemporary(err) {
+		t.Error("Expected a temporary timeout without a client", err)
+	}
+	if _, err := lc.Write([]byte("x")); err == nil || !IsTemporary(err) {
+		t.Error("Expected a temporary error writing without a client", err)
+	}
+
+	a, stop := Arbitrate(ctx, lc)
+	defer stop()
+	first := dialIn(t, lc)
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the client to answer", rsp.Error)
+	}
+
+	//the client reconnects without the old connection being noticed
+	second := dialIn(t, lc)
+	<-time.After(10 * time.Millisecond)
+	if _, err := first.Write([]byte("stale")); err == nil {
+		if _, err := first.Read(buf); err == nil {
+			t.Error("Expected the replaced client to be disconnected")
+		}
+	}
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the new client to answer", rsp.Error)
+	}
+
+	second.Close()
+	<-time.After(10 * time.Millisecond)
+	if _, err := lc.Read(buf); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error once the client left", err)
+	}
+	if err := lc.Open(); err != nil || lc.Connected() {
+		t.Error("Expected Open to drop the old client", err)
+	}
+	third := dialIn(t, lc)
+	defer third.Close()
+	fmt.Fprint(third, "hello")
+	<-time.After(10 * time.Millisecond)
+	if n, err := lc.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Error("Expected to read from the next client", string(buf[:n]), err)
+	}
+}
+
+func TestUDPListenClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewUDPListenClient(ctx, time.Second, "udp-listen://localhost"); err == nil {
+		t.Error("Expected an error without a port")
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, "udp-listen://127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen", err)
+	}
+	uc := idoio.(*UDPListenClient)
+	defer uc.Close()
+	_ = uc.String()
+
+	buf := make([]byte, 16)
+	if _, err := uc.Write([]byte("x")); err == nil || !IsTemporary(err) {
+		t.Error("Expected a temporary error writing without a peer", err)
+	}
+	if _, err := uc.Read(buf); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout without a peer", err)
+	}
+
+	peer, err := net.Dial("udp", uc.Addr())
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer peer.Close()
+	other, err := net.Dial("udp", uc.Addr())
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer other.Close()
+
+	peer.Write([]byte("hello"))
+	n := 0
+	for i := 0; i < 1000 && n == 0; i++ {
+		n, _ = uc.Read(buf)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Expected hello, got %q", buf[:n])
+	}
+	if uc.Peer() == nil || uc.Peer().String() != peer.LocalAddr().String() {
+		t.Error("Expected the peer to be learned", uc.Peer())
+	}
+
+	other.Write([]byte("intruder"))
+	ignored := false
+	for i := 0; i < 1000 && !ignored; i++ {
+		if n, err = uc.Read(buf); n != 0 {
+			t.Errorf("Expected datagrams from others ignored, got %q", buf[:n])
+			break
+		}
+		ignored = strings.Contains(err.Error(), "ignored")
+	}
+	if !ignored {
+		t.Error("Expected the intruding datagram to be ignored")
+	}
+
+	if _, err := uc.Write([]byte(fmt.Sprintf("Rxd>%d", 5))); err != nil {
+		t.Error("Unable to write to the peer", err)
+	}
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := peer.Read(buf); err != nil || string(buf[:n]) != "Rxd>5" {
+		t.Errorf("Expected the peer to receive Rxd>5, got %q %v", buf[:n], err)
+	}
+
+	if err := uc.Open(); err != nil || uc.Peer() != nil {
+		t.Error("Expected Open to forget the peer", err)
+	}
+	if err := uc.Close(); err != nil {
+		t.Error("Unable to close", err)
+	}
+	if _, err := uc.Read(buf); err == nil {
+		t.Error("Expected an error reading after close")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+/*
+Logger is the structured logger used by everything in this package.  The
+methods mirror those of *slog.Logger, which is the default implementation, and
+args are alternating key-value pairs (or slog.Attr values).
+
+Every message carries an "event" attribute naming one of the Event* constants,
+and where applicable a "dial" attribute identifying the transport and a
+"command" attribute naming the Command involved.
+*/
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Values of the "event" attribute attached to every log message
+const (
+	EventConnect    = "connect"    //a transport was (re)opened
+	EventDisconnect = "disconnect" //a transport was closed
+	EventRetry      = "retry"      //an operation is being retried, possibly on another path
+	EventCommand    = "command"    //an Arbiter exchange completed
+	EventError      = "error"      //a non-temporary error was encountered
+	EventStall      = "stall"      //data stopped arriving at the expected rate
+	EventRecover    = "recover"    //data resumed arriving at the expected rate
+	EventTraffic    = "traffic"    //bytes were read or written (see Trace)
+	EventTimeout    = "timeout"    //a read or write timed out (see Trace)
+)
+
+/*
+Trace selects which Debug level messages a transport logs about its own
+operation, for seeing what it is doing in the field.  Only NetClient,
+SerialClient, UnixgramClient and MulticastClient trace reads, writes and
+timeouts, logging how many bytes moved; to see the bytes themselves use
+WithLogging.
+*/
+type Trace uint
+
+// Traces, to be or'ed together
+const (
+	TraceOpen    Trace = 1 << iota //the connection opened
+	TraceClose                     //the connection closed
+	TraceRead                      //every read returning data
+	TraceWrite                     //every write
+	TraceTimeout                   //every read or write that timed out
+
+	TraceNone    Trace = 0
+	DefaultTrace       = TraceOpen | TraceClose
+	TraceAll           = TraceOpen | TraceClose | TraceRead | TraceWrite | TraceTimeout
+)
+
+type traceKey struct{}
+
+/*
+WithTrace returns a copy of ctx carrying t.  Transports built from the
+returned context trace t instead of DefaultTrace, to the Logger they would
+otherwise use.
+*/
+func WithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+/*traceFrom returns the Trace carried by ctx, or DefaultTrace*/
+func traceFrom(ctx context.Context) Trace {
+	if t, ok := ctx.Value(traceKey{}).(Trace); ok {
+		return t
+	}
+	return DefaultTrace
+}
+
+var (
+	loggerMux     sync.RWMutex
+	defaultLogger Logger //nil means slog.Default()
+)
+
+type loggerKey struct{}
+
+/*dialer is implemented by IDoIOs that remember the dial string they were built from*/
+type dialer interface {
+	dialString() string
+}
+
+/*dialOf returns the dial string idoio was built from, or failing that, its String()*/
+func dialOf(idoio IDoIO) string {
+	if d, ok := idoio.(dialer); ok {
+		return d.dialString()
+	}
+	return idoio.String()
+}
+
+/*
+SetDefaultLogger sets the package-wide Logger used by anything that has not
+been given one explicitly, either via SetLogger or the context passed to its
+constructor.  Passing nil reverts to slog.Default().
+*/
+func SetDefaultLogger(l Logger) {
+	loggerMux.Lock()
+	defer loggerMux.Unlock()
+	defaultLogger = l
+}
+
+/*DefaultLogger returns the package-wide Logger*/
+func DefaultLogger() Logger {
+	loggerMux.RLock()
+	defer loggerMux.RUnlock()
+	if defaultLogger == nil {
+		return slog.Default()
+	}
+	return defaultLogger
+}
+
+/*
+WithLogger returns a copy of ctx carrying l.  Anything in this package built
+from the returned context (or one derived from it) logs to l instead of the
+package-wide default.
+*/
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+/*
+LoggerFrom returns the Logger carried by ctx, or the package-wide default if
+ctx does not carry one.
+*/
+func LoggerFrom(ctx context.Context) Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(Logger); ok && l != nil {
+			return l
+		}
+	}
+	return DefaultLogger()
+}
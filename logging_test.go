@@ -0,0 +1,126 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerFrom(t *testing.T) {
+	if LoggerFrom(context.Background()) != slog.Default() {
+		t.Error("Expected slog.Default() when nothing else is configured")
+	}
+
+	pkg := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	SetDefaultLogger(pkg)
+	defer SetDefaultLogger(nil)
+	if LoggerFrom(context.Background()) != pkg {
+		t.Error("Expected the package default logger")
+	}
+
+	ctxlog := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if LoggerFrom(WithLogger(context.Background(), ctxlog)) != ctxlog {
+		t.Error("Expected the context's logger")
+	}
+}
+
+func TestLogging_Events(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), l))
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	a, err := NewArbiter(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Error("Unable to dial", err)
+		t.FailNow()
+	}
+	a.Control(arbCmdOk)
+	a.Close()
+
+	for _, want := range []string{
+		"event=" + EventConnect,
+		"event=" + EventCommand,
+		"event=" + EventDisconnect,
+		"command=\"" + arbCmdOk.Name + "\"",
+		"dial=" + dial,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the log output", want)
+		}
+	}
+
+	//per-instance loggers win over the context
+	other := &bytes.Buffer{}
+	nc, _ := NewNetClient(ctx, 0, "tcp://localhost:1")
+	nc.SetLogger(slog.New(slog.NewTextHandler(other, nil)))
+	nc.Open()
+	if !strings.Contains(other.String(), "event="+EventError) {
+		t.Error("Expected the failed open to be logged to the instance logger")
+	}
+}
+
+func TestWithTrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), l))
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	nc, err := NewNetClient(WithTrace(ctx, TraceWrite|TraceTimeout), 100*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	nc.Write([]byte("ABC"))
+	nc.Read(make([]byte, 16))
+	nc.Read(make([]byte, 16))
+	nc.Close()
+	for _, want := range []string{"msg=write event=" + EventTraffic, "bytes=3", "event=" + EventTimeout} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the log output", want)
+		}
+	}
+	for _, unwanted := range []string{"msg=read ", "event=" + EventConnect, "event=" + EventDisconnect} {
+		if strings.Contains(buf.String(), unwanted) {
+			t.Errorf("Expected no %q in the log output", unwanted)
+		}
+	}
+
+	buf.Reset()
+	nc, _ = NewNetClient(WithTrace(ctx, TraceNone), 100*time.Millisecond, dial)
+	nc.Write([]byte("ABC"))
+	nc.Close()
+	if buf.Len() != 0 {
+		t.Error("Expected nothing to be traced", buf.String())
+	}
+}
@@ -0,0 +1,1133 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Duration is a time.Duration that is read from, and written to, JSON as a
+string understood by time.ParseDuration (e.g. "1.5s").  Plain numbers are read
+as nanoseconds.
+*/
+type Duration time.Duration
+
+/*MarshalJSON conforms to json.Marshaler*/
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+/*UnmarshalJSON conforms to json.Unmarshaler*/
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(b, &ns); err != nil {
+			return fmt.Errorf("invalid duration %s", b)
+		}
+		*d = Duration(ns)
+		return nil
+	}
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(pd)
+	return nil
+}
+
+/*
+CommandConfig is the serializable form of a Command.  The regular expressions
+are given as strings, and empty strings mean no regular expression.
+*/
+type CommandConfig struct {
+	Name          string   `json:"name"`
+	Timeout       Duration `json:"timeout"`
+	Prototype     string   `json:"prototype"`
+	CommandRegexp string   `json:"command_regexp,omitempty"`
+	Response      string   `json:"response,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	Description   string   `json:"description,omitempty"`
+	Urgent        bool     `json:"urgent,omitempty"`
+	Lookback      int      `json:"lookback,omitempty"`
+	Priority      Priority `json:"priority,omitempty"`
+	Retries       int      `json:"retries,omitempty"`
+	RetryBackoff  Duration `json:"retry_backoff,omitempty"`
+
+	RetryOn          RetryCondition `json:"retry_on,omitempty"`
+	MaxResponseBytes int            `json:"max_response_bytes,omitempty"`
+	Overflow         OverflowPolicy `json:"overflow,omitempty"`
+}
+
+/*Command compiles cc into a Command*/
+func (cc CommandConfig) Command() (Command, error) {
+	cmd := Command{Name: cc.Name, Timeout: time.Duration(cc.Timeout), Prototype: cc.Prototype, Description: cc.Description, Urgent: cc.Urgent, Lookback: cc.Lookback, Priority: cc.Priority,
+		MaxResponseBytes: cc.MaxResponseBytes, Overflow: cc.Overflow,
+		Retries: cc.Retries, RetryBackoff: time.Duration(cc.RetryBackoff), RetryOn: cc.RetryOn}
+	for _, re := range []struct {
+		src string
+		dst **regexp.Regexp
+	}{{cc.CommandRegexp, &cmd.CommandRegexp}, {cc.Response, &cmd.Response}, {cc.Error, &cmd.Error}} {
+		if re.src == "" {
+			continue
+		}
+		var err error
+		if *re.dst, err = regexp.Compile(re.src); err != nil {
+			return cmd, newErr(false, false, errors.Wrapf(err, "command %q", cc.Name))
+		}
+	}
+	return cmd, nil
+}
+
+/*DeviceConfig describes a single device managed by a Manager*/
+type DeviceConfig struct {
+	Name string `json:"name"`
+
+	//Dial is the dial string of the device (see NewIDoIO)
+	Dial string `json:"dial"`
+
+	//Failover lists backup dial strings, tried in order after Dial (see NewFailoverArbiter)
+	Failover []string `json:"failover,omitempty"`
+
+	//Timeout is used when opening the device. Defaults to 5s.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	//Commands names the device's command set in ManagerConfig.CommandSets
+	Commands string `json:"commands,omitempty"`
+
+	//Init lists commands from the command set sent, in order, each time the device is opened
+	Init []string `json:"init,omitempty"`
+
+	//Disabled devices are validated but never opened
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+/*ManagerConfig describes every device a Manager looks after*/
+type ManagerConfig struct {
+	Devices     []DeviceConfig             `json:"devices"`
+	CommandSets map[string][]CommandConfig `json:"command_sets,omitempty"`
+
+	/*ReconnectInterval is how long after going down a device is reopened, and
+	  how often devices are checked. Defaults to 10s.*/
+	ReconnectInterval Duration `json:"reconnect_interval,omitempty"`
+
+	/*MaxReconnectInterval caps the delay between reopens, which doubles with
+	  each consecutive failure. Defaults to ReconnectInterval, i.e. no backoff.*/
+	MaxReconnectInterval Duration `json:"max_reconnect_interval,omitempty"`
+
+	/*FailureBudget is the number of failed reopens tolerated within
+	  FailureWindow.  A device exceeding it is escalated (see Manager.OnEscalate)
+	  and no longer reopened until restarted.  Zero means unlimited.*/
+	FailureBudget int `json:"failure_budget,omitempty"`
+
+	//FailureWindow is the period FailureBudget applies to. Defaults to 1h.
+	FailureWindow Duration `json:"failure_window,omitempty"`
+
+	//HealthAddr, if not empty, is the address an HTTP health endpoint (see Manager.ServeHTTP) listens on
+	HealthAddr string `json:"health_addr,omitempty"`
+}
+
+/*LoadManagerConfig reads a JSON encoded ManagerConfig from path*/
+func LoadManagerConfig(path string) (ManagerConfig, error) {
+	cfg := ManagerConfig{}
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, newErr(false, false, err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, newErr(false, false, errors.Wrapf(err, "unable to parse %q", path))
+	}
+	return cfg, nil
+}
+
+/*commandSets compiles every command set in the config*/
+func (mc ManagerConfig) commandSets() (map[string]Commands, error) {
+	sets := map[string]Commands{}
+	for name, ccs := range mc.CommandSets {
+		cmds := Commands{}
+		for _, cc := range ccs {
+			cmd, err := cc.Command()
+			if err != nil {
+				return nil, errors.Wrapf(err, "command set %q", name)
+			}
+			cmds[cc.Name] = cmd
+		}
+		sets[name] = cmds
+	}
+	return sets, nil
+}
+
+/*
+Validate checks the config for errors that would prevent a Manager from being
+built: missing or duplicate names, bad dial strings, bad regular expressions,
+and references to undefined command sets or commands.
+*/
+func (mc ManagerConfig) Validate() error {
+	sets, err := mc.commandSets()
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	seen := map[string]bool{}
+	for i, dc := range mc.Devices {
+		switch {
+		case dc.Name == "":
+			return newErr(false, false, fmt.Errorf("device %d has no name", i))
+		case seen[dc.Name]:
+			return newErr(false, false, fmt.Errorf("device %q is defined more than once", dc.Name))
+		}
+		seen[dc.Name] = true
+		for _, dial := range append([]string{dc.Dial}, dc.Failover...) {
+			if factoryFor(dial) == nil {
+				return newErr(false, false, fmt.Errorf("device %q: unknown dial string %q", dc.Name, dial))
+			}
+		}
+		if _, err := dc.init(sets); err != nil {
+			return newErr(false, false, err)
+		}
+	}
+	return nil
+}
+
+/*init resolves the device's init sequence from the compiled command sets*/
+func (dc DeviceConfig) init(sets map[string]Commands) ([]Command, error) {
+	cmds, ok := sets[dc.Commands]
+	if dc.Commands != "" && !ok {
+		return nil, fmt.Errorf("device %q: undefined command set %q", dc.Name, dc.Commands)
+	}
+	init := make([]Command, 0, len(dc.Init))
+	for _, name := range dc.Init {
+		cmd, ok := cmds[name]
+		if !ok {
+			return nil, fmt.Errorf("device %q: init command %q is not in its command set", dc.Name, name)
+		}
+		init = append(init, cmd)
+	}
+	return init, nil
+}
+
+/*DeviceStatus is a snapshot of the state of a managed device*/
+type DeviceStatus struct {
+	Name      string
+	Dial      string
+	Disabled  bool
+	Connected bool
+	Escalated bool      //true if the device exceeded its failure budget and is no longer reopened
+	Closed    bool      //true if the device was closed through its Arbiter, and is no longer reopened
+	Error     error     //the most recent error opening the device, nil once it is connected
+	Since     time.Time //when Connected last changed
+	LastData  time.Time //when data was last received from the device, zero if never
+	NextRetry time.Time //when the device is next reopened, zero if it is not waiting to be
+	Failures  int       //failed reopens within the failure window
+}
+
+/*
+MarshalJSON conforms to json.Marshaler.  The Error is rendered as a string, and
+zero times are omitted.
+*/
+func (ds DeviceStatus) MarshalJSON() ([]byte, error) {
+	type status struct {
+		Name      string     `json:"name"`
+		Dial      string     `json:"dial"`
+		State     string     `json:"state"`
+		Connected bool       `json:"connected"`
+		Error     string     `json:"error,omitempty"`
+		Since     time.Time  `json:"since"`
+		LastData  *time.Time `json:"last_data,omitempty"`
+		NextRetry *time.Time `json:"next_retry,omitempty"`
+		Failures  int        `json:"failures"`
+	}
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	js := status{
+		Name:      ds.Name,
+		Dial:      ds.Dial,
+		State:     ds.State(),
+		Connected: ds.Connected,
+		Since:     ds.Since,
+		LastData:  optional(ds.LastData),
+		NextRetry: optional(ds.NextRetry),
+		Failures:  ds.Failures,
+	}
+	if ds.Error != nil {
+		js.Error = ds.Error.Error()
+	}
+	return json.Marshal(js)
+}
+
+/*State returns one of "up", "down", "escalated", "closed" or "disabled"*/
+func (ds DeviceStatus) State() string {
+	switch {
+	case ds.Disabled:
+		return "disabled"
+	case ds.Connected:
+		return "up"
+	case ds.Escalated:
+		return "escalated"
+	case ds.Closed:
+		return "closed"
+	default:
+		return "down"
+	}
+}
+
+/*String conforms to fmt.Stringer*/
+func (ds DeviceStatus) String() string {
+	state := ds.State()
+	if !ds.Connected && ds.Error != nil {
+		state = fmt.Sprintf("%s (%v)", state, ds.Error)
+	}
+	return fmt.Sprintf("%s %s: %s since %s", ds.Name, ds.Dial, state, ds.Since.UTC().Format(time.RFC3339))
+}
+
+var (
+	_ ContextArbiter = &device{}
+	_ QueueReporter  = &device{}
+	_ Expecter       = &device{}
+	_ DialogRunner   = &device{}
+	_ Batcher        = &device{}
+)
+
+/*
+device is a single device under management.  It is also the Arbiter handed out
+by Manager.Arbiter, so that callers holding it are carried across a reload
+that swaps the underlying FailoverArb.
+*/
+type device struct {
+	name string
+
+	swap     sync.RWMutex //held for reading during exchanges, and for writing while swapping
+	cfg      DeviceConfig
+	initCfg  []CommandConfig
+	commands Commands
+	arb      *FailoverArb //nil if disabled or retired
+	retired  bool
+
+	mux       sync.Mutex //guards the state below
+	err       error
+	up        bool
+	since     time.Time
+	lastData  time.Time
+	backoff   time.Duration //delay before the next reopen, zero when up
+	retryAt   time.Time     //zero when up, or not yet noticed to be down
+	failures  []time.Time   //failed reopens within the failure window
+	escalated bool
+	closed    bool //by a caller, until Restart, or a reload changing its connection, builds a new FailoverArb
+}
+
+/*record notes the outcome of an attempt to open the device*/
+func (d *device) record(arb *FailoverArb, err error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.err = err
+	d.refresh(arb)
+}
+
+/*
+refresh brings the device's state up to date with arb, which may have
+reconnected (or been dropped) by an exchange. The caller must hold d.mux.
+*/
+func (d *device) refresh(arb *FailoverArb) {
+	up := arb != nil && arb.Connected()
+	if up != d.up {
+		d.up, d.since = up, time.Now()
+	}
+	if d.up {
+		d.err, d.backoff, d.retryAt = nil, 0, time.Time{}
+	}
+}
+
+/*received notes that data arrived from the device*/
+func (d *device) received(n int) {
+	if n == 0 {
+		return
+	}
+	d.mux.Lock()
+	d.lastData = time.Now()
+	d.mux.Unlock()
+}
+
+/*status returns a snapshot of the device's state*/
+func (d *device) status() DeviceStatus {
+	d.swap.RLock()
+	arb, cfg := d.arb, d.cfg
+	d.swap.RUnlock()
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.refresh(arb)
+	return DeviceStatus{
+		Name:      d.name,
+		Dial:      cfg.Dial,
+		Disabled:  cfg.Disabled,
+		Connected: d.up,
+		Escalated: d.escalated,
+		Closed:    d.closed,
+		Error:     d.err,
+		Since:     d.since,
+		LastData:  d.lastData,
+		NextRetry: d.retryAt,
+		Failures:  len(d.failures),
+	}
+}
+
+/*resetSupervision forgets past failures. The caller must hold d.mux.*/
+func (d *device) resetSupervision() {
+	d.backoff, d.retryAt, d.failures, d.escalated = 0, time.Time{}, nil, false
+}
+
+/*current returns the active FailoverArb, or an error if there is none. The caller must hold d.swap.*/
+func (d *device) current() (*FailoverArb, error) {
+	switch {
+	case d.retired:
+		return nil, newErr(false, false, fmt.Errorf("device %q has been removed", d.name))
+	case d.arb == nil:
+		return nil, newErr(false, false, fmt.Errorf("device %q is disabled", d.name))
+	}
+	return d.arb, nil
+}
+
+func (d *device) String() string {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	if d.arb == nil {
+		return fmt.Sprintf("%s (not connected)", d.name)
+	}
+	return fmt.Sprintf("%s: %v", d.name, d.arb)
+}
+
+func (d *device) dialString() string {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	return d.cfg.Dial
+}
+
+func (d *device) Open() error {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err == nil {
+		err = arb.Open()
+	}
+	d.record(arb, err)
+	return err
+}
+
+/*
+Close closes the device's FailoverArb, which cannot be reopened, so the
+supervisor leaves the device alone until it is restarted or reconfigured
+*/
+func (d *device) Close() error {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return err
+	}
+	d.mux.Lock()
+	d.closed, d.retryAt = true, time.Time{}
+	d.mux.Unlock()
+	return arb.Close()
+}
+
+func (d *device) Read(b []byte) (int, error) {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return 0, err
+	}
+	n, err := arb.Read(b)
+	d.received(n)
+	return n, err
+}
+
+func (d *device) Write(b []byte) (int, error) {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return 0, err
+	}
+	return arb.Write(b)
+}
+
+func (d *device) Queue() QueueStatus {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return QueueStatus{}
+	}
+	return arb.Queue()
+}
+
+func (d *device) Simple(cmd, ok, failure []byte, duration time.Duration) Response {
+	return d.SimpleContext(context.Background(), cmd, ok, failure, duration)
+}
+
+func (d *device) SimpleContext(ctx context.Context, cmd, ok, failure []byte, duration time.Duration) Response {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return Response{Error: err}
+	}
+	rsp := arb.SimpleContext(ctx, cmd, ok, failure, duration)
+	d.received(len(rsp.Bytes))
+	return rsp
+}
+
+func (d *device) Expect(out []byte, timeout time.Duration, check CheckFunc) Response {
+	return d.ExpectContext(context.Background(), out, timeout, check)
+}
+
+func (d *device) ExpectContext(ctx context.Context, out []byte, timeout time.Duration, check CheckFunc) Response {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return Response{Error: err}
+	}
+	rsp := arb.ExpectContext(ctx, out, timeout, check)
+	d.received(len(rsp.Bytes))
+	return rsp
+}
+
+func (d *device) RunDialog(ctx context.Context, dl Dialog) DialogResult {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return DialogResult{Error: err}
+	}
+	res := arb.RunDialog(ctx, dl)
+	for _, rsp := range res.Responses {
+		d.received(len(rsp.Bytes))
+	}
+	return res
+}
+
+func (d *device) ControlAll(cmds []Command, stopOnError bool) BatchResult {
+	return d.ControlAllContext(context.Background(), cmds, stopOnError)
+}
+
+func (d *device) ControlAllContext(ctx context.Context, cmds []Command, stopOnError bool) BatchResult {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return BatchResult{Error: err}
+	}
+	res := arb.ControlAllContext(ctx, cmds, stopOnError)
+	for _, rsp := range res.Responses {
+		d.received(len(rsp.Bytes))
+	}
+	return res
+}
+
+func (d *device) Control(cmd Command, args ...interface{}) Response {
+	return d.ControlContext(context.Background(), cmd, args...)
+}
+
+func (d *device) ControlContext(ctx context.Context, cmd Command, args ...interface{}) Response {
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	arb, err := d.current()
+	if err != nil {
+		return Response{Error: err}
+	}
+	rsp := arb.ControlContext(ctx, cmd, args...)
+	d.received(len(rsp.Bytes))
+	return rsp
+}
+
+/*
+Manager builds, and looks after, Arbiters for a collection of devices described
+by a ManagerConfig, so acquisition daemons need not each re-implement the
+orchestration layer.  Each device is an Arbiter (backed by a FailoverArb, so
+backup paths and init sequences come for free) that can be looked up by name.
+
+Devices that cannot be opened do not prevent the Manager from being built.
+Devices that are down are supervised: they are reopened after
+ReconnectInterval, backing off exponentially to MaxReconnectInterval while
+reopening keeps failing.  A device that fails more than FailureBudget times
+within FailureWindow is escalated to the callback set by OnEscalate, and left
+alone until Restart is called, as is a device closed through its Arbiter.  The
+state of every device is reported by Status, and over HTTP by ServeHTTP.
+
+The configuration may be changed at runtime with Reload, or by watching a file
+with WatchFile, without disturbing devices whose configuration is unchanged.
+*/
+type Manager struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mux         sync.Mutex //guards the fields below, and serializes reloads
+	interval    time.Duration
+	maxInterval time.Duration
+	budget      int
+	window      time.Duration
+	escalate    func(DeviceStatus)
+	devices     map[string]*device
+	order       []string
+	sets        map[string]Commands //the command sets of the configuration, by name
+	healthAddr  string
+	health      *http.Server
+	wake        chan struct{} //nudges the supervisor after a reload or restart
+}
+
+/*NewManagerFromFile loads a ManagerConfig from path and returns a Manager built from it*/
+func NewManagerFromFile(ctx context.Context, path string) (*Manager, error) {
+	cfg, err := LoadManagerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewManager(ctx, cfg)
+}
+
+/*
+NewManagerFromDials returns a Manager of devices named by the keys of dials,
+opened from the dial strings they map to, with the defaults of ManagerConfig
+throughout.  Devices are ordered by name.
+*/
+func NewManagerFromDials(ctx context.Context, dials map[string]string) (*Manager, error) {
+	var cfg ManagerConfig
+	for name, dial := range dials {
+		cfg.Devices = append(cfg.Devices, DeviceConfig{Name: name, Dial: dial})
+	}
+	sort.Slice(cfg.Devices, func(i, j int) bool { return cfg.Devices[i].Name < cfg.Devices[j].Name })
+	return NewManager(ctx, cfg)
+}
+
+/*
+NewManager validates cfg and opens every enabled device in it.  An error is
+returned only if cfg is invalid.
+*/
+func NewManager(ctx context.Context, cfg ManagerConfig) (*Manager, error) {
+	mctx, cancel := context.WithCancel(ctx)
+	m := &Manager{ctx: mctx, cancel: cancel, devices: map[string]*device{}, wake: make(chan struct{}, 1)}
+	if err := m.Reload(cfg); err != nil {
+		cancel()
+		return nil, err
+	}
+	go m.supervise()
+	return m, nil
+}
+
+/*
+Reload applies cfg to a running Manager.  Devices new to cfg are opened, and
+devices absent from cfg are closed and retired; callers still holding their
+Arbiters receive errors.  Devices whose dial strings, timeout or init sequence
+changed are swapped gracefully: the replacement is opened first, and exchanges
+already in progress on the old connection finish before it is closed.  Devices
+whose command sets alone changed are updated without reconnecting, and all
+other devices are left untouched.
+
+If cfg is invalid, an error is returned and nothing is changed.
+*/
+func (m *Manager) Reload(cfg ManagerConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	sets, _ := cfg.commandSets()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if cfg.HealthAddr != m.healthAddr {
+		if err := m.serve(cfg.HealthAddr); err != nil {
+			return err
+		}
+	}
+	if m.interval = time.Duration(cfg.ReconnectInterval); m.interval <= 0 {
+		m.interval = 10 * time.Second
+	}
+	if m.maxInterval = time.Duration(cfg.MaxReconnectInterval); m.maxInterval < m.interval {
+		m.maxInterval = m.interval
+	}
+	if m.window = time.Duration(cfg.FailureWindow); m.window <= 0 {
+		m.window = time.Hour
+	}
+	m.budget, m.sets = cfg.FailureBudget, sets
+	defer m.nudge()
+
+	var wg sync.WaitGroup
+	keep := map[string]bool{}
+	order := make([]string, 0, len(cfg.Devices))
+	for _, dc := range cfg.Devices {
+		keep[dc.Name] = true
+		order = append(order, dc.Name)
+		commands := sets[dc.Commands]
+		if commands == nil {
+			commands = Commands{}
+		}
+		initCfg := cfg.initConfigs(dc)
+		d, ok := m.devices[dc.Name]
+		if !ok {
+			d = &device{name: dc.Name, since: time.Now()}
+			m.devices[dc.Name] = d
+		}
+		d.swap.RLock()
+		same := ok && sameConnection(d.cfg, dc) && reflect.DeepEqual(d.initCfg, initCfg)
+		d.swap.RUnlock()
+		if same {
+			d.swap.Lock()
+			d.cfg, d.commands = dc, commands
+			d.swap.Unlock()
+			continue
+		}
+		if ok {
+			LoggerFrom(m.ctx).Info("reconfiguring device", "event", EventDisconnect, "device", dc.Name, "dial", dc.Dial)
+		}
+		wg.Add(1)
+		go func(d *device, dc DeviceConfig, initCfg []CommandConfig, commands Commands) {
+			defer wg.Done()
+			m.replace(d, dc, initCfg, commands, sets)
+		}(d, dc, initCfg, commands)
+	}
+	for name, d := range m.devices {
+		if keep[name] {
+			continue
+		}
+		LoggerFrom(m.ctx).Info("removing device", "event", EventDisconnect, "device", name)
+		delete(m.devices, name)
+		wg.Add(1)
+		go func(d *device) {
+			defer wg.Done()
+			d.swap.Lock()
+			defer d.swap.Unlock()
+			if d.arb != nil {
+				d.arb.Close()
+			}
+			d.arb, d.retired = nil, true
+		}(d)
+	}
+	wg.Wait()
+	m.order = order
+	return nil
+}
+
+/*sameConnection returns true if a and b would open identical connections*/
+func sameConnection(a, b DeviceConfig) bool {
+	return a.Dial == b.Dial && a.Timeout == b.Timeout && a.Disabled == b.Disabled &&
+		reflect.DeepEqual(a.Failover, b.Failover)
+}
+
+/*initConfigs returns the configuration of each of dc's init commands, in order*/
+func (mc ManagerConfig) initConfigs(dc DeviceConfig) []CommandConfig {
+	var init []CommandConfig
+	for _, name := range dc.Init {
+		for _, cc := range mc.CommandSets[dc.Commands] {
+			if cc.Name == name {
+				init = append(init, cc)
+				break
+			}
+		}
+	}
+	return init
+}
+
+/*
+replace opens a new FailoverArb for d as described by dc, then swaps it in
+once exchanges on the old one have finished, and closes the old one
+*/
+func (m *Manager) replace(d *device, dc DeviceConfig, initCfg []CommandConfig, commands Commands, sets map[string]Commands) {
+	var arb *FailoverArb
+	var err error
+	if !dc.Disabled {
+		timeout := time.Duration(dc.Timeout)
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		init, _ := dc.init(sets)
+		arb, err = NewFailoverArbiter(m.ctx, timeout, init, append([]string{dc.Dial}, dc.Failover...)...)
+	}
+	d.swap.Lock()
+	old := d.arb
+	d.cfg, d.initCfg, d.commands, d.arb = dc, initCfg, commands, arb
+	d.swap.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	d.mux.Lock()
+	d.resetSupervision()
+	d.closed = false
+	d.mux.Unlock()
+	d.record(arb, err)
+}
+
+/*
+OnEscalate sets fn to be called, from the supervisor, each time a device
+exceeds its failure budget.  The device is not reopened again until Restart is
+called for it (or it is reconfigured by a reload); fn may do so itself.
+*/
+func (m *Manager) OnEscalate(fn func(DeviceStatus)) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.escalate = fn
+}
+
+/*
+Restart clears the named device's failure history, including any escalation,
+and reopens it now.  A device closed through its Arbiter gets a new
+FailoverArb.
+*/
+func (m *Manager) Restart(name string) error {
+	m.mux.Lock()
+	d, ok := m.devices[name]
+	sets := m.sets
+	m.mux.Unlock()
+	if !ok {
+		return newErr(false, false, fmt.Errorf("no device named %q", name))
+	}
+	d.mux.Lock()
+	d.resetSupervision()
+	closed := d.closed
+	d.mux.Unlock()
+	defer m.nudge()
+	if closed {
+		d.swap.RLock()
+		dc, initCfg, commands := d.cfg, d.initCfg, d.commands
+		d.swap.RUnlock()
+		m.replace(d, dc, initCfg, commands, sets)
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		return d.err
+	}
+	return d.Open()
+}
+
+/*nudge wakes the supervisor early*/
+func (m *Manager) nudge() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*supervise reopens devices that are down, as described by Manager*/
+func (m *Manager) supervise() {
+	for {
+		m.mux.Lock()
+		interval := m.interval
+		m.mux.Unlock()
+		next := time.Now().Add(interval)
+		for _, d := range m.snapshot() {
+			if at := m.tend(d); !at.IsZero() && at.Before(next) {
+				next = at
+			}
+		}
+		wait := time.NewTimer(time.Until(next))
+		select {
+		case <-m.ctx.Done():
+			wait.Stop()
+			return
+		case <-m.wake:
+			wait.Stop()
+		case <-wait.C:
+		}
+	}
+}
+
+/*
+tend reopens d if it is down and due, and returns when it is next due, or the
+zero time if it is not waiting to be reopened
+*/
+func (m *Manager) tend(d *device) time.Time {
+	d.swap.RLock()
+	arb, name, dial := d.arb, d.name, d.cfg.Dial
+	d.swap.RUnlock()
+	m.mux.Lock()
+	interval, maxInterval, budget, window, escalate := m.interval, m.maxInterval, m.budget, m.window, m.escalate
+	m.mux.Unlock()
+
+	now := time.Now()
+	d.mux.Lock()
+	d.refresh(arb)
+	switch {
+	case arb == nil || d.up || d.escalated || d.closed:
+		d.mux.Unlock()
+		return time.Time{}
+	case d.retryAt.IsZero(): //newly noticed to be down
+		d.backoff = interval
+		d.retryAt = now.Add(d.backoff)
+		fallthrough
+	case now.Before(d.retryAt):
+		defer d.mux.Unlock()
+		return d.retryAt
+	}
+	d.mux.Unlock()
+
+	LoggerFrom(m.ctx).Info("reopening device", "event", EventRetry, "device", name, "dial", dial)
+	err := arb.Open()
+	d.record(arb, err)
+	if err == nil {
+		d.mux.Lock()
+		d.resetSupervision()
+		d.mux.Unlock()
+		return time.Time{}
+	}
+
+	d.mux.Lock()
+	now = time.Now()
+	kept := d.failures[:0]
+	for _, f := range d.failures {
+		if now.Sub(f) < window {
+			kept = append(kept, f)
+		}
+	}
+	d.failures = append(kept, now)
+	if budget > 0 && len(d.failures) > budget {
+		d.escalated, d.retryAt = true, time.Time{}
+		d.mux.Unlock()
+		LoggerFrom(m.ctx).Error("device exceeded its failure budget", "event", EventError, "device", name, "dial", dial, "error", err)
+		if escalate != nil {
+			escalate(d.status())
+		}
+		return time.Time{}
+	}
+	if d.backoff *= 2; d.backoff > maxInterval {
+		d.backoff = maxInterval
+	}
+	d.retryAt = now.Add(d.backoff)
+	defer d.mux.Unlock()
+	return d.retryAt
+}
+
+/*
+serve replaces the health endpoint with one listening on addr, or just stops
+it if addr is empty. The caller must hold m.mux.
+*/
+func (m *Manager) serve(addr string) error {
+	var svr *http.Server
+	if addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return newErr(false, false, errors.Wrap(err, "unable to start health endpoint"))
+		}
+		svr = &http.Server{Handler: m, ReadHeaderTimeout: 5 * time.Second}
+		go svr.Serve(l)
+		LoggerFrom(m.ctx).Info("health endpoint listening", "event", EventConnect, "addr", l.Addr().String())
+	}
+	if m.health != nil {
+		m.health.Close()
+	}
+	m.health, m.healthAddr = svr, addr
+	return nil
+}
+
+/*
+ServeHTTP conforms to http.Handler, reporting the state of every device as
+JSON, e.g.
+
+	{"healthy":false,"devices":[{"name":"gps","dial":"tcp://gps:4001",
+	  "state":"down","connected":false,"error":"...","since":"...",
+	  "last_data":"...","next_retry":"...","failures":2}]}
+
+The status code is 200 when every enabled device is connected, and 503
+otherwise, so the endpoint can be used directly by load balancers.
+*/
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats := m.Status()
+	healthy := true
+	for _, ds := range stats {
+		healthy = healthy && (ds.Disabled || ds.Connected)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Healthy bool           `json:"healthy"`
+		Devices []DeviceStatus `json:"devices"`
+	}{healthy, stats})
+}
+
+/*
+WatchFile polls path every interval, and Reloads the Manager whenever the
+file's contents change, until the Manager is closed.  Changes that cannot be
+loaded or applied are logged, and the running configuration is kept.
+*/
+func (m *Manager) WatchFile(path string, interval time.Duration) {
+	last, _ := os.ReadFile(path)
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-tick.C:
+			}
+			b, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(b, last) {
+				continue
+			}
+			last = b
+			cfg, err := LoadManagerConfig(path)
+			if err == nil {
+				err = m.Reload(cfg)
+			}
+			if err != nil {
+				LoggerFrom(m.ctx).Warn("unable to reload configuration", "event", EventError, "path", path, "error", err)
+				continue
+			}
+			LoggerFrom(m.ctx).Info("configuration reloaded", "event", EventConnect, "path", path)
+		}
+	}()
+}
+
+func (m *Manager) snapshot() []*device {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	devs := make([]*device, 0, len(m.order))
+	for _, name := range m.order {
+		devs = append(devs, m.devices[name])
+	}
+	return devs
+}
+
+/*String conforms to fmt.Stringer*/
+func (m *Manager) String() string {
+	return fmt.Sprintf("Manager of %d devices", len(m.Names()))
+}
+
+/*Names returns the names of the managed devices, in configuration order*/
+func (m *Manager) Names() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]string{}, m.order...)
+}
+
+/*
+Arbiter returns the Arbiter of the named device, and false if there is no such
+device.  The Arbiter remains valid across reloads that reconfigure the device.
+*/
+func (m *Manager) Arbiter(name string) (Arbiter, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	d, ok := m.devices[name]
+	if !ok {
+		return nil, false
+	}
+	return d, true
+}
+
+/*Commands returns the command set of the named device, and false if there is no such device*/
+func (m *Manager) Commands(name string) (Commands, bool) {
+	m.mux.Lock()
+	d, ok := m.devices[name]
+	m.mux.Unlock()
+	if !ok {
+		return nil, false
+	}
+	d.swap.RLock()
+	defer d.swap.RUnlock()
+	return d.commands.Clone(), true
+}
+
+/*
+Control looks up the named device and command, and runs the command on the
+device's Arbiter
+*/
+func (m *Manager) Control(device, command string, args ...interface{}) Response {
+	arb, ok := m.Arbiter(device)
+	if !ok {
+		return Response{Error: newErr(false, false, fmt.Errorf("no device named %q", device))}
+	}
+	cmds, _ := m.Commands(device)
+	cmd, ok := cmds[command]
+	if !ok {
+		return Response{Error: newErr(false, false, fmt.Errorf("device %q has no command %q", device, command))}
+	}
+	return arb.Control(cmd, args...)
+}
+
+/*Status returns the state of every device, in configuration order*/
+func (m *Manager) Status() []DeviceStatus {
+	devs := m.snapshot()
+	stats := make([]DeviceStatus, len(devs))
+	for i, d := range devs {
+		stats[i] = d.status()
+	}
+	return stats
+}
+
+/*Healthy returns true if every enabled device is connected*/
+func (m *Manager) Healthy() bool {
+	for _, ds := range m.Status() {
+		if !ds.Disabled && !ds.Connected {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Close closes every device, stops supervision and the health endpoint. The
+Manager may not be reused.
+*/
+func (m *Manager) Close() error {
+	m.cancel()
+	m.mux.Lock()
+	m.serve("")
+	m.mux.Unlock()
+	var errs []string
+	for _, d := range m.snapshot() {
+		d.swap.RLock()
+		arb := d.arb
+		d.swap.RUnlock()
+		if arb == nil {
+			continue
+		}
+		if err := arb.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", d.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return newErr(false, false, fmt.Errorf("unable to close %s", strings.Join(errs, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const managerJSON = `{
+	"reconnect_interval": "50ms",
+	"command_sets": {
+		"echo": [
+			{"name": "hello", "timeout": "500ms", "prototype": "ABC", "response": "Rxd>3"},
+			{"name": "bad", "timeout": "500ms", "prototype": "ABC", "response": "^a", "error": "Rxd>3"}
+		]
+	},
+	"devices": [
+		{"name": "up", "dial": %q, "timeout": "100ms", "commands": "echo", "init": ["hello"]},
+		{"name": "late", "dial": %q, "timeout": "100ms", "commands": "echo"},
+		{"name": "off", "dial": "tcp://localhost:1", "commands": "echo", "disabled": true}
+	]
+}`
+
+func TestNewManagerFromFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	_, lateSrvdial, lateDial := freePortCfg()
+
+	path := filepath.Join(t.TempDir(), "devices.json")
+	os.WriteFile(path, []byte(fmt.Sprintf(managerJSON, dial, lateDial)), 0644)
+	m, err := NewManagerFromFile(ctx, path)
+	if err != nil {
+		t.Error("Unable to build manager", err)
+		t.FailNow()
+	}
+	defer m.Close()
+	t.Log(m)
+
+	if names := m.Names(); len(names) != 3 || names[0] != "up" || names[2] != "off" {
+		t.Error("Unexpected device names", names)
+	}
+	if rsp := m.Control("up", "hello"); rsp.Error != nil {
+		t.Error("Expected the command to succeed", rsp)
+	}
+	if rsp := m.Control("up", "bad"); rsp.Error != ErrErrorResponse {
+		t.Error("Expected an error response", rsp)
+	}
+	if rsp := m.Control("up", "nope"); rsp.Error == nil {
+		t.Error("Expected an unknown command to fail")
+	}
+	if rsp := m.Control("off", "hello"); rsp.Error == nil {
+		t.Error("Expected a disabled device to fail")
+	}
+	if cmds, ok := m.Commands("late"); !ok || !cmds.Contains("hello", "bad") {
+		t.Error("Expected the command set to be resolved", cmds)
+	}
+
+	stats := m.Status()
+	for _, s := range stats {
+		t.Log(s)
+	}
+	if !stats[0].Connected || stats[1].Connected || stats[1].Error == nil || !stats[2].Disabled {
+		t.Error("Unexpected status", stats)
+	}
+	if m.Healthy() {
+		t.Error("Expected the manager to be unhealthy with a device down")
+	}
+
+	//the late device comes up and is reconnected by the supervisor
+	newTCPSvr(ctx, t, "tcp", lateSrvdial, arbHandler)
+	deadline := time.Now().Add(2 * time.Second)
+	for !m.Healthy() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats = m.Status(); !stats[1].Connected || stats[1].Error != nil {
+		t.Error("Expected the late device to be reconnected", stats[1])
+	}
+}
+
+func TestNewManagerFromDials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	if _, err := NewManagerFromDials(ctx, map[string]string{"bad": "bogus://x"}); err == nil {
+		t.Error("Expected an unknown scheme to be rejected")
+	}
+	m, err := NewManagerFromDials(ctx, map[string]string{"radar": dial, "anemometer": "null://"})
+	if err != nil {
+		t.Fatal("Unable to build manager", err)
+	}
+	defer m.Close()
+	if names := m.Names(); len(names) != 2 || names[0] != "anemometer" || names[1] != "radar" {
+		t.Error("Expected the devices in name order", names)
+	}
+	arb, ok := m.Arbiter("radar")
+	if !ok {
+		t.Fatal("Expected to find the device by name")
+	}
+	if rsp := arb.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the device to answer", rsp.Error)
+	}
+	if !m.Healthy() {
+		t.Error("Expected every device to be up", m.Status())
+	}
+}
+
+func TestManagerConfig_Validate(t *testing.T) {
+	good := DeviceConfig{Name: "a", Dial: "tcp://localhost:1"}
+	for name, cfg := range map[string]ManagerConfig{
+		"no name":     {Devices: []DeviceConfig{{Dial: "tcp://localhost:1"}}},
+		"duplicate":   {Devices: []DeviceConfig{good, good}},
+		"bad dial":    {Devices: []DeviceConfig{{Name: "a", Dial: "carrier-pigeon://coop"}}},
+		"bad backup":  {Devices: []DeviceConfig{{Name: "a", Dial: "tcp://localhost:1", Failover: []string{"nope"}}}},
+		"no set":      {Devices: []DeviceConfig{{Name: "a", Dial: "tcp://localhost:1", Commands: "x"}}},
+		"no init cmd": {Devices: []DeviceConfig{{Name: "a", Dial: "tcp://localhost:1", Init: []string{"x"}}}},
+		"bad regexp": {
+			Devices:     []DeviceConfig{good},
+			CommandSets: map[string][]CommandConfig{"x": {{Name: "x", Response: "("}}},
+		},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Error("Expected an invalid config:", name)
+		}
+	}
+	if err := (ManagerConfig{Devices: []DeviceConfig{good}}).Validate(); err != nil {
+		t.Error("Expected a valid config", err)
+	}
+
+	var d Duration
+	if err := d.UnmarshalJSON([]byte(`"1.5s"`)); err != nil || time.Duration(d) != 1500*time.Millisecond {
+		t.Error("Unable to parse duration string", d, err)
+	}
+	if err := d.UnmarshalJSON([]byte(`1000`)); err != nil || time.Duration(d) != time.Microsecond {
+		t.Error("Unable to parse duration nanoseconds", d, err)
+	}
+	if b, _ := d.MarshalJSON(); string(b) != `"1µs"` {
+		t.Error("Unexpected duration encoding", string(b))
+	}
+}
+
+func TestManager_Reload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvA, dialA := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvA, arbHandler)
+	_, srvB, dialB := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvB, arbHandler)
+
+	path := filepath.Join(t.TempDir(), "devices.json")
+	os.WriteFile(path, []byte(fmt.Sprintf(managerJSON, dialA, dialA)), 0644)
+	m, err := NewManagerFromFile(ctx, path)
+	if err != nil {
+		t.Error("Unable to build manager", err)
+		t.FailNow()
+	}
+	defer m.Close()
+	m.WatchFile(path, 10*time.Millisecond)
+	up, _ := m.Arbiter("up")
+	late, _ := m.Arbiter("late")
+	unchanged := late.(*device).arb
+
+	//"up" moves to another port, "late" loses a command, "off" goes away, "new" appears
+	cfg, _ := LoadManagerConfig(path)
+	cfg.Devices[0].Dial = dialB
+	cfg.Devices[1].Commands = ""
+	cfg.Devices = append(cfg.Devices[:2], DeviceConfig{Name: "new", Dial: dialB})
+	if err := m.Reload(ManagerConfig{Devices: []DeviceConfig{{Name: "broken"}}}); err == nil {
+		t.Error("Expected an invalid config to be rejected")
+	}
+	b, _ := json.Marshal(cfg)
+	os.WriteFile(path, b, 0644)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(m.Names()) != 3 || m.Names()[2] != "new" {
+		if time.Now().After(deadline) {
+			t.Error("Expected the config file change to be applied", m.Names())
+			t.FailNow()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := m.Status(); stats[0].Dial != dialB || !stats[0].Connected || !stats[2].Connected {
+		t.Error("Unexpected status after reload", stats)
+	}
+	if rsp := up.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the old handle to follow the swap", rsp)
+	}
+	if late.(*device).arb != unchanged {
+		t.Error("Expected a command set change not to reconnect")
+	}
+	if cmds, _ := m.Commands("late"); len(cmds) != 0 {
+		t.Error("Expected the command set to be updated", cmds)
+	}
+	if _, ok := m.Arbiter("off"); ok {
+		t.Error("Expected the removed device to be gone")
+	}
+}
+
+func TestManager_Supervision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	port, _, _ := freePortCfg()
+	healthAddr := fmt.Sprintf("localhost:%d", port+1)
+
+	m, err := NewManager(ctx, ManagerConfig{
+		Devices:              []DeviceConfig{{Name: "flaky", Dial: dial, Timeout: Duration(50 * time.Millisecond)}},
+		ReconnectInterval:    Duration(10 * time.Millisecond),
+		MaxReconnectInterval: Duration(20 * time.Millisecond),
+		FailureBudget:        2,
+		FailureWindow:        Duration(time.Minute),
+		HealthAddr:           healthAddr,
+	})
+	if err != nil {
+		t.Error("Unable to build manager", err)
+		t.FailNow()
+	}
+	defer m.Close()
+	escalated := make(chan DeviceStatus, 1)
+	m.OnEscalate(func(ds DeviceStatus) { escalated <- ds })
+
+	select {
+	case ds := <-escalated:
+		t.Log(ds)
+		if !ds.Escalated || ds.Failures != 3 || ds.State() != "escalated" {
+			t.Error("Unexpected escalated status", ds)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the device to be escalated")
+		t.FailNow()
+	}
+
+	rsp, err := http.Get("http://" + healthAddr + "/")
+	if err != nil {
+		t.Error("Unable to query health endpoint", err)
+		t.FailNow()
+	}
+	health := struct {
+		Healthy bool
+		Devices []struct {
+			Name, State, Error string
+			Failures           int
+		}
+	}{}
+	json.NewDecoder(rsp.Body).Decode(&health)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusServiceUnavailable || health.Healthy || len(health.Devices) != 1 ||
+		health.Devices[0].State != "escalated" || health.Devices[0].Error == "" {
+		t.Error("Unexpected health report", rsp.StatusCode, health)
+	}
+
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	if err := m.Restart("flaky"); err != nil {
+		t.Error("Expected the restart to succeed", err)
+	}
+	if arb, _ := m.Arbiter("flaky"); arb.Control(arbCmdOk).Error != nil {
+		t.Error("Expected the restarted device to work")
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	t.Log(rec.Body.String())
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"state":"up"`) || !strings.Contains(rec.Body.String(), `"last_data"`) {
+		t.Error("Unexpected health report", rec.Code, rec.Body.String())
+	}
+	if err := m.Restart("nope"); err == nil {
+		t.Error("Expected an unknown device to fail")
+	}
+}
+
+func TestManager_CloseDevice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	m, err := NewManager(ctx, ManagerConfig{
+		Devices:           []DeviceConfig{{Name: "dev", Dial: dial, Timeout: Duration(50 * time.Millisecond)}},
+		ReconnectInterval: Duration(10 * time.Millisecond),
+		FailureBudget:     1,
+	})
+	if err != nil {
+		t.Error("Unable to build manager", err)
+		t.FailNow()
+	}
+	defer m.Close()
+	escalated := make(chan DeviceStatus, 1)
+	m.OnEscalate(func(ds DeviceStatus) { escalated <- ds })
+
+	arb, _ := m.Arbiter("dev")
+	if err := arb.Close(); err != nil {
+		t.Error("Unable to close the device", err)
+	}
+	m.nudge()
+	select {
+	case ds := <-escalated:
+		t.Error("Expected the supervisor to leave a closed device alone", ds)
+	case <-time.After(200 * time.Millisecond): //many supervisor ticks
+	}
+	ds := m.Status()[0]
+	if !ds.Closed || ds.State() != "closed" || ds.Failures != 0 || ds.Error != nil || !ds.NextRetry.IsZero() {
+		t.Error("Unexpected status of a closed device", ds)
+	}
+
+	if err := m.Restart("dev"); err != nil {
+		t.Error("Expected the restart to succeed", err)
+	}
+	if arb.Control(arbCmdOk).Error != nil {
+		t.Error("Expected the restarted device to work")
+	}
+	if ds = m.Status()[0]; ds.Closed || ds.State() != "up" {
+		t.Error("Unexpected status of a restarted device", ds)
+	}
+}
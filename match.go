@@ -0,0 +1,167 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+/*maxTailWindow is the longest match tailMatcher will work out a window for*/
+const maxTailWindow = 1 << 16
+
+/*
+tailMatcher matches a regexp against a buffer that only ever grows, as an
+Arbiter's response does, rescanning only what may hold a new match.  Anything
+that matched entirely within what was scanned before has been reported already,
+so a new match must end in the new data, and so can start no further back than
+the longest match the regexp can make.  Regexps without a longest match, or
+that look at what precedes a match (^, \b and the like), are rescanned in full
+unless a lookback is given.
+*/
+type tailMatcher struct {
+	re       *regexp.Regexp
+	lookback int //bytes before the new data to rescan, negative for all of them
+	scanned  int
+}
+
+/*
+newTailMatcher returns a tailMatcher for re, which may be nil to match
+nothing.  A positive lookback is used when re does not bound its own.
+*/
+func newTailMatcher(re *regexp.Regexp, lookback int) *tailMatcher {
+	m := &tailMatcher{re: re, lookback: -1}
+	if re == nil {
+		return m
+	}
+	if n, ok := maxMatchLen(re); ok {
+		m.lookback = max(n-1, 0)
+	} else if lookback > 0 {
+		m.lookback = lookback
+	}
+	return m
+}
+
+/*Match reports whether raw, which must begin with whatever was given before, holds a match*/
+func (m *tailMatcher) Match(raw []byte) bool {
+	if m.re == nil {
+		return false
+	}
+	start := 0
+	if m.lookback >= 0 {
+		start = max(min(m.scanned, len(raw))-m.lookback, 0)
+	}
+	m.scanned = len(raw)
+	return m.re.Match(raw[start:])
+}
+
+/*discard tells m the first n bytes of what it was given have been dropped*/
+func (m *tailMatcher) discard(n int) { m.scanned = max(m.scanned-n, 0) }
+
+/*tailContains is tailMatcher for a fixed sequence, as Simple looks for*/
+type tailContains struct {
+	seq     []byte
+	scanned int
+}
+
+/*Contains reports whether raw, which must begin with whatever was given before, contains the sequence*/
+func (t *tailContains) Contains(raw []byte) bool {
+	if t.seq == nil {
+		return false
+	}
+	start := max(min(t.scanned, len(raw))-len(t.seq)+1, 0)
+	t.scanned = len(raw)
+	return bytes.Contains(raw[start:], t.seq)
+}
+
+/*discard tells t the first n bytes of what it was given have been dropped*/
+func (t *tailContains) discard(n int) { t.scanned = max(t.scanned-n, 0) }
+
+/*
+maxMatchLen returns the most bytes a match of re can span, or false if that
+is unbounded, or if re asserts anything about what precedes a match
+*/
+func maxMatchLen(re *regexp.Regexp) (int, bool) {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := maxLen(parsed.Simplify())
+	return n, ok && n <= maxTailWindow
+}
+
+func maxLen(re *syntax.Regexp) (int, bool) {
+	switch re.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch, syntax.OpEndLine, syntax.OpEndText:
+		return 0, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return len(re.Rune) * utf8.UTFMax, true
+		}
+		n := 0
+		for _, r := range re.Rune {
+			n += utf8.RuneLen(r)
+		}
+		return n, true
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return 0, true
+		}
+		if hi := re.Rune[len(re.Rune)-1]; hi < utf8.RuneSelf {
+			return 1, true
+		}
+		return utf8.UTFMax, true
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return utf8.UTFMax, true
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxLen(re.Sub[0])
+	case syntax.OpRepeat:
+		n, ok := maxLen(re.Sub[0])
+		if !ok || re.Max < 0 || n*re.Max > maxTailWindow {
+			return 0, false
+		}
+		return n * re.Max, true
+	case syntax.OpConcat, syntax.OpAlternate:
+		total := 0
+		for _, sub := range re.Sub {
+			n, ok := maxLen(sub)
+			if !ok {
+				return 0, false
+			}
+			if re.Op == syntax.OpConcat {
+				total += n
+			} else {
+				total = max(total, n)
+			}
+			if total > maxTailWindow {
+				return 0, false
+			}
+		}
+		return total, true
+	}
+	return 0, false //star, plus, and assertions about what came before
+}
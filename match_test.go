@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMaxMatchLen(t *testing.T) {
+	for re, want := range map[string]int{
+		`OK\r\n`:            4,
+		`(OK|ERROR \d)`:     7,
+		`Rxd>\d{1,3}`:       7,
+		`(?i)ok`:            8,
+		`[^a]`:              4,
+		`done$`:             4,
+		`x?y`:               2,
+		`[\x{100}-\x{200}]`: 4,
+	} {
+		if n, ok := maxMatchLen(regexp.MustCompile(re)); !ok || n != want {
+			t.Errorf("Expected %q to match at most %d bytes, got %d %v", re, want, n, ok)
+		}
+	}
+	for _, re := range []string{`Rxd>\d+`, `^OK`, `(?m)^OK`, `\bOK`, `a*`, `x{1,}`} {
+		if _, ok := maxMatchLen(regexp.MustCompile(re)); ok {
+			t.Errorf("Expected %q to need a full rescan", re)
+		}
+	}
+}
+
+func TestTailMatcher(t *testing.T) {
+	//feeds raw a few bytes at a time, as readUntil would, returning how many it took to match
+	feed := func(match func([]byte) bool, raw string) int {
+		for i := 1; i <= len(raw); i++ {
+			if match([]byte(raw[:i])) {
+				return i
+			}
+		}
+		return -1
+	}
+	noise := strings.Repeat("babble ", 100)
+	for _, tc := range []struct {
+		re       string
+		lookback int
+		raw      string
+		want     int
+	}{
+		{`OK\r\n`, 0, noise + "OK\r\n", len(noise) + 4},
+		{`Rxd>\d+`, 0, noise + "Rxd>12", len(noise) + 5},
+		{`^babble`, 0, noise, 6},
+		{`\bOK`, 0, noise + "xOK OK", len(noise) + 6},
+		{`(?s)BEGIN.*END`, 0, "BEGIN" + noise + "END", len(noise) + 8},
+		{`(?s)BEGIN.*END`, 16, "BEGIN" + noise + "END", -1}, //the lookback is too short
+		{`nope`, 0, noise, -1},
+	} {
+		m := newTailMatcher(regexp.MustCompile(tc.re), tc.lookback)
+		if got := feed(m.Match, tc.raw); got != tc.want {
+			t.Errorf("%q (lookback %d): expected a match after %d bytes, got %d", tc.re, tc.lookback, tc.want, got)
+		}
+	}
+	if newTailMatcher(nil, 0).Match([]byte("anything")) {
+		t.Error("Expected a nil regexp to match nothing")
+	}
+
+	c := &tailContains{seq: []byte("OK\r\n")}
+	if got := feed(c.Contains, noise+"OK\r\n"); got != len(noise)+4 {
+		t.Error("Expected the sequence to be found across reads", got)
+	}
+	if (&tailContains{}).Contains([]byte("anything")) {
+		t.Error("Expected a nil sequence to be found nowhere")
+	}
+}
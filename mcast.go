@@ -0,0 +1,283 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &MulticastClient{}
+
+/*
+MulticastClient provides an implementer of the IDoIO interface for multicast
+udp streams, such as shipboard navigation feeds, under the URI regime:
+
+	mcast://
+
+It joins a group and binds its port, so Reads return datagrams sent to the
+group by anyone.  Writes are sent to the group.
+*/
+type MulticastClient struct {
+	connStats
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	dial      string
+	group     *net.UDPAddr
+	iface     *net.Interface //nil means the system default
+	ttl       int            //0 means the system default
+	loop      *bool          //nil means the system default
+	rwtimeout time.Duration
+	conn      *net.UDPConn
+	log       Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewMulticastClient joins a multicast group.  Dial should be in the form of
+
+	mcast://<group>:<port>[?iface=<name>&ttl=<hops>&loop=<bool>]
+
+eg "mcast://239.192.0.1:10110?iface=eth1&ttl=4".  iface names the interface
+to join on and send from; ttl is the hop limit of datagrams written; loop
+is whether they are also delivered to this host.  Timeout is unused.
+*/
+func NewMulticastClient(ctx context.Context, timeout time.Duration, dial string) (*MulticastClient, error) {
+	d, err := dialFor(dial, "mcast")
+	if err != nil {
+		return nil, err
+	}
+	host, port, _ := net.SplitHostPort(d.Host)
+	mctx, cancel := context.WithCancel(ctx)
+	mc := &MulticastClient{ctx: mctx, cancel: cancel, dial: dial, rwtimeout: 1 * time.Millisecond}
+	if err := mc.parse(host, port, d.Params.Encode()); err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	mc.instrument(ctx, dial, mc.logger)
+	return mc, openUnlessLazy(ctx, mc)
+}
+
+func (mc *MulticastClient) parse(host, port, query string) error {
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsMulticast() {
+		return fmt.Errorf("%q is not a multicast group", host)
+	}
+	p, _ := strconv.Atoi(port)
+	mc.group = &net.UDPAddr{IP: ip, Port: p}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for k, v := range q {
+		switch k {
+		case "iface":
+			if mc.iface, err = net.InterfaceByName(v[0]); err != nil {
+				return err
+			}
+		case "ttl":
+			if mc.ttl, err = strconv.Atoi(v[0]); err != nil || mc.ttl < 1 || mc.ttl > 255 {
+				return fmt.Errorf("ttl must be in [1, 255], not %q", v[0])
+			}
+		case "loop":
+			loop, err := strconv.ParseBool(v[0])
+			if err != nil {
+				return err
+			}
+			mc.loop = &loop
+		default:
+			return fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	return nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this MulticastClient
+was constructed with (see WithLogger).  It is not safe to call concurrently
+with other methods.
+*/
+func (mc *MulticastClient) SetLogger(l Logger) {
+	mc.log = l
+}
+
+func (mc *MulticastClient) dialString() string { return mc.dial }
+
+func (mc *MulticastClient) logger() Logger {
+	if mc.log != nil {
+		return mc.log
+	}
+	return LoggerFrom(mc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (mc *MulticastClient) String() string {
+	return fmt.Sprintf("multicast group %v", mc.group)
+}
+
+func (mc *MulticastClient) network() string {
+	if mc.group.IP.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+/*sockopts applies the ttl and loop options, if any, to conn*/
+func (mc *MulticastClient) sockopts(conn *net.UDPConn) error {
+	level, ttlOpt, loopOpt := syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, syscall.IP_MULTICAST_LOOP
+	if mc.network() == "udp6" {
+		level, ttlOpt, loopOpt = syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, syscall.IPV6_MULTICAST_LOOP
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if mc.ttl > 0 {
+			serr = setsockoptInt(fd, level, ttlOpt, mc.ttl)
+		}
+		if serr == nil && mc.loop != nil {
+			v := 0
+			if *mc.loop {
+				v = 1
+			}
+			serr = setsockoptInt(fd, level, loopOpt, v)
+		}
+	})
+	if err == nil {
+		err = serr
+	}
+	return err
+}
+
+/*Open forcibly leaves the group (ignoring errors) and joins it again*/
+func (mc *MulticastClient) Open() (err error) {
+	mc.opening()
+	defer func() { mc.opened(err) }()
+	select {
+	case <-mc.ctx.Done():
+		return newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.conn != nil {
+		mc.conn.Close()
+		mc.conn = nil
+	}
+	conn, err := net.ListenMulticastUDP(mc.network(), mc.iface, mc.group)
+	if err == nil {
+		if err = mc.sockopts(conn); err != nil {
+			conn.Close()
+			err = newErr(false, false, errors.Wrap(err, "unable to set socket options"))
+		}
+	}
+	if err != nil { //implements net.Error
+		mc.logger().Warn("unable to join group", "event", EventError, "dial", mc.dial, "error", err)
+		return err
+	}
+	mc.conn = conn
+	if mc.tracing(TraceOpen) {
+		mc.logger().Debug("group joined", "event", EventConnect, "dial", mc.dial)
+	}
+	return nil
+}
+
+/*Read conforms to io.Reader, returning a datagram sent to the group*/
+func (mc *MulticastClient) Read(b []byte) (n int, err error) {
+	defer func() { mc.read(n, err) }()
+	select {
+	case <-mc.ctx.Done():
+		defer mc.Close()
+		return 0, newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.conn == nil {
+		return 0, readErr
+	}
+	if mc.rwtimeout > 0 {
+		mc.conn.SetReadDeadline(time.Now().Add(mc.rwtimeout))
+	}
+	n, _, err = mc.conn.ReadFromUDP(b)
+	return n, err
+}
+
+/*Write conforms to io.Writer, sending b to the group as a single datagram*/
+func (mc *MulticastClient) Write(b []byte) (n int, err error) {
+	defer func() { mc.wrote(n, err) }()
+	select {
+	case <-mc.ctx.Done():
+		defer mc.Close()
+		return 0, newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.conn == nil {
+		return 0, writeErr
+	}
+	if mc.rwtimeout > 0 {
+		mc.conn.SetWriteDeadline(time.Now().Add(mc.rwtimeout))
+	}
+	return mc.conn.WriteToUDP(b, mc.group)
+}
+
+/*ReadMessage conforms to Messenger, returning a single datagram sent to the group*/
+func (mc *MulticastClient) ReadMessage() ([]byte, error) {
+	return readMessage(mc)
+}
+
+/*WriteMessage conforms to Messenger, sending b to the group as a single datagram*/
+func (mc *MulticastClient) WriteMessage(b []byte) error {
+	return writeMessage(mc, b)
+}
+
+/*Redial conforms to Redialer*/
+func (mc *MulticastClient) Redial(ctx context.Context) (IDoIO, error) {
+	m, err := NewMulticastClient(WithLazyOpen(ctx), 0, mc.dial)
+	if err != nil {
+		return nil, err
+	}
+	m.log, m.hooks, m.trace = mc.log, mc.currentHooks(), mc.trace
+	return m, nil
+}
+
+/*Close conforms to io.Closer, leaving the group*/
+func (mc *MulticastClient) Close() error {
+	mc.cancel()
+	mc.closed()
+	defer func() { mc.conn = nil }()
+	if mc.conn != nil {
+		if mc.tracing(TraceClose) {
+			mc.logger().Debug("group left", "event", EventDisconnect, "dial", mc.dial)
+		}
+		return mc.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+/*loopbackIface returns the name of a loopback interface, skipping t if there is none*/
+func loopbackIface(t *testing.T) string {
+	ifis, _ := net.Interfaces()
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestMulticastClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{
+		"mcast://239.192.0.1",
+		"mcast://10.0.0.1:5000",
+		"mcast://239.192.0.1:5000?ttl=0",
+		"mcast://239.192.0.1:5000?loop=maybe",
+		"mcast://239.192.0.1:5000?iface=nonesuch0",
+		"mcast://239.192.0.1:5000?bogus=1",
+	} {
+		if _, err := NewMulticastClient(ctx, time.Second, dial); err == nil || IsTemporary(err) {
+			t.Error("Expected a permanent error for", dial)
+		}
+	}
+
+	port, _, _ := freePortCfg()
+	dial := fmt.Sprintf("mcast://239.192.0.1:%d?iface=%s&ttl=1&loop=true", port, loopbackIface(t))
+	idoio, err := NewIDoIO(ctx, time.Second, dial)
+	if err != nil {
+		t.Skip("Unable to join a group on loopback", err)
+	}
+	mc := idoio.(*MulticastClient)
+	defer mc.Close()
+	_ = mc.String()
+
+	if _, err := mc.Write([]byte("$GPGGA")); err != nil {
+		t.Fatal("Unable to write to the group", err)
+	}
+	buf := make([]byte, 16)
+	n := 0
+	for i := 0; i < 1000 && n == 0; i++ {
+		n, _ = mc.Read(buf)
+	}
+	if string(buf[:n]) != "$GPGGA" {
+		t.Errorf("Expected our own datagram looped back, got %q", buf[:n])
+	}
+
+	if err := mc.Open(); err != nil {
+		t.Error("Unable to rejoin", err)
+	}
+	if err := mc.Close(); err != nil {
+		t.Error("Unable to close", err)
+	}
+	if _, err := mc.Read(buf); err == nil {
+		t.Error("Expected an error reading after close")
+	}
+}
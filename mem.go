@@ -0,0 +1,257 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	_ IDoIO         = &MemClient{}
+	_ ContextReader = &MemClient{}
+
+	memMux     sync.Mutex
+	memWaiting = map[string]*MemClient{} //the unclaimed ends of named pairs
+)
+
+/*memPipe is one direction of a MemClient pair: an unbounded buffer*/
+type memPipe struct {
+	mux    sync.Mutex
+	buf    bytes.Buffer
+	ready  chan struct{} //closed when there is data, or the pipe is closed
+	closed bool
+}
+
+func newMemPipe() *memPipe { return &memPipe{ready: make(chan struct{})} }
+
+/*signal wakes readers. The caller must hold p.mux.*/
+func (p *memPipe) signal() {
+	select {
+	case <-p.ready:
+	default:
+		close(p.ready)
+	}
+}
+
+func (p *memPipe) write(b []byte) (int, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.closed {
+		return 0, writeErr
+	}
+	p.buf.Write(b)
+	p.signal()
+	return len(b), nil
+}
+
+/*
+read returns buffered data, waiting up to wait (if positive) for some to
+arrive, or until stop is closed
+*/
+func (p *memPipe) read(ctx context.Context, b []byte, wait time.Duration, stop <-chan struct{}) (int, error) {
+	var expired <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		p.mux.Lock()
+		if p.buf.Len() > 0 {
+			n, _ := p.buf.Read(b)
+			if p.buf.Len() == 0 && !p.closed {
+				p.ready = make(chan struct{})
+			}
+			p.mux.Unlock()
+			return n, nil
+		}
+		if p.closed {
+			p.mux.Unlock()
+			return 0, newErr(false, false, io.EOF)
+		}
+		ready := p.ready
+		p.mux.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, newErr(false, false, ctx.Err())
+		case <-expired:
+			return 0, newErr(true, true, fmt.Errorf("read: nothing to read"))
+		case <-stop:
+			return 0, newErr(true, true, fmt.Errorf("read: nothing to read"))
+		case <-ready:
+		}
+	}
+}
+
+func (p *memPipe) close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.closed = true
+	p.signal()
+}
+
+/*
+MemClient provides an implementer of the IDoIO interface that is one end of
+an in-memory connection, under the URI regime:
+
+	mem://
+
+Whatever is written to one end is read from the other, so an Arbiter (or
+anything else taking an IDoIO) can be tested against a simulated device
+without any sockets.  Writes never block, being buffered without limit until
+read, and Reads wait briefly for data as a NetClient does, returning a timeout
+error if there is none.  Once either end is closed, the other end reads
+anything still buffered followed by io.EOF (as a permanent error), and writes
+fail.  A closed MemClient cannot be reopened.
+*/
+type MemClient struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	name   string
+	wait   time.Duration
+	rx, tx *memPipe
+	log    Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewMemPair returns the two ends of an in-memory connection, each valid until
+ctx is done or either end is closed.
+*/
+func NewMemPair(ctx context.Context) (*MemClient, *MemClient) {
+	return newMemPair(ctx, "pair")
+}
+
+func newMemPair(ctx context.Context, name string) (*MemClient, *MemClient) {
+	ab, ba := newMemPipe(), newMemPipe()
+	actx, acancel := context.WithCancel(ctx)
+	bctx, bcancel := context.WithCancel(ctx)
+	return &MemClient{ctx: actx, cancel: acancel, name: name, wait: 1 * time.Millisecond, rx: ba, tx: ab},
+		&MemClient{ctx: bctx, cancel: bcancel, name: name, wait: 1 * time.Millisecond, rx: ab, tx: ba}
+}
+
+/*
+NewMemClient returns one end of the named in-memory connection given by a dial
+string of the form "mem://<name>".  The first call with a name creates the
+connection, and the second call with the same name returns its other end;
+after that the name is free to be used again.  Timeout is unused.
+*/
+func NewMemClient(ctx context.Context, timeout time.Duration, dial string) (*MemClient, error) {
+	d, err := dialFor(dial, "mem")
+	if err == nil && d.Device == "" {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, fmt.Errorf("invalid parameters in %q: %v", dial, err))
+	}
+	memMux.Lock()
+	defer memMux.Unlock()
+	if other, ok := memWaiting[d.Device]; ok {
+		delete(memWaiting, d.Device)
+		return other, nil
+	}
+	a, b := newMemPair(ctx, d.Device)
+	memWaiting[d.Device] = b
+	return a, nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this MemClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (mc *MemClient) SetLogger(l Logger) {
+	mc.log = l
+}
+
+func (mc *MemClient) dialString() string { return "mem://" + mc.name }
+
+func (mc *MemClient) logger() Logger {
+	if mc.log != nil {
+		return mc.log
+	}
+	return LoggerFrom(mc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (mc *MemClient) String() string {
+	return fmt.Sprintf("in-memory connection %v", mc.name)
+}
+
+/*Open conforms to IDoIO, but only succeeds if the connection has not been closed*/
+func (mc *MemClient) Open() error {
+	select {
+	case <-mc.ctx.Done():
+		return newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	mc.tx.mux.Lock()
+	defer mc.tx.mux.Unlock()
+	if mc.tx.closed {
+		return newErr(false, false, fmt.Errorf("in-memory connection %v is closed", mc.name))
+	}
+	return nil
+}
+
+/*Read conforms to io.Reader, returning what the other end has written*/
+func (mc *MemClient) Read(b []byte) (int, error) {
+	return mc.rx.read(mc.ctx, b, mc.wait, nil)
+}
+
+/*ReadContext conforms to ContextReader, waiting for data until ctx is done*/
+func (mc *MemClient) ReadContext(ctx context.Context, b []byte) (int, error) {
+	return mc.rx.read(mc.ctx, b, 0, ctx.Done())
+}
+
+/*Write conforms to io.Writer, buffering b for the other end to read*/
+func (mc *MemClient) Write(b []byte) (int, error) {
+	select {
+	case <-mc.ctx.Done():
+		return 0, newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	return mc.tx.write(b)
+}
+
+/*Close conforms to io.Closer, closing both directions*/
+func (mc *MemClient) Close() error {
+	mc.cancel()
+	mc.tx.close()
+	mc.rx.close()
+	memMux.Lock()
+	if memWaiting[mc.name] != nil && memWaiting[mc.name].rx == mc.tx {
+		delete(memWaiting, mc.name) //nobody claimed the other end
+	}
+	memMux.Unlock()
+	mc.logger().Debug("connection closed", "event", EventDisconnect, "dial", mc.dialString())
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNewMemPair(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := NewMemPair(ctx)
+	_ = a.String()
+	buf := make([]byte, 16)
+	if _, err := b.Read(buf); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout with nothing written", err)
+	}
+	a.Write([]byte("hello "))
+	a.Write([]byte("world"))
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "hello world" {
+		t.Error("Expected both writes", string(buf[:n]), err)
+	}
+	go b.Write([]byte("back"))
+	n, err := a.Read(buf)
+	for i := 0; i < 100 && err != nil && IsTimeout(err); i++ {
+		n, err = a.Read(buf)
+	}
+	if err != nil || string(buf[:n]) != "back" {
+		t.Error("Expected data written concurrently", string(buf[:n]), err)
+	}
+
+	b.Write([]byte("last"))
+	b.Close()
+	if n, err := a.Read(buf); err != nil || string(buf[:n]) != "last" {
+		t.Error("Expected buffered data to survive a close", string(buf[:n]), err)
+	}
+	if _, err := a.Read(buf); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error once the other end closed", err)
+	}
+	if _, err := a.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail once the other end closed")
+	}
+	if err := b.Open(); err == nil {
+		t.Error("Expected reopening a closed end to fail")
+	}
+}
+
+func TestMemClient_Arbiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewMemClient(ctx, time.Second, "mem://"); err == nil {
+		t.Error("Expected an error for a bad dial string")
+	}
+
+	a, err := NewArbiter(ctx, 100*time.Millisecond, "mem://device")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer a.Close()
+	dev, err := NewIDoIO(ctx, time.Second, "mem://device")
+	if err != nil {
+		t.Fatal("Unable to claim the other end", err)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := dev.Read(buf)
+			if err != nil && !IsTemporary(err) {
+				return
+			}
+			if n > 0 {
+				fmt.Fprintf(dev, "Rxd>%d", n)
+			}
+		}
+	}()
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the simulated device to answer", rsp.Error)
+	}
+	if rsp := a.Control(arbCmdError); rsp.Error == nil {
+		t.Error("Expected the error response to be matched")
+	}
+
+	if other, _ := NewMemClient(ctx, time.Second, "mem://device"); other.rx == dev.(*MemClient).tx {
+		t.Error("Expected the name to be free once both ends are claimed")
+	} else {
+		other.Close()
+		if _, ok := memWaiting["device"]; ok {
+			t.Error("Expected an unclaimed end to be forgotten once closed")
+		}
+	}
+}
@@ -0,0 +1,353 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO = &ModemClient{}
+
+	//ErrModemBusy is returned by Open when the number dialed is busy. It is temporary.
+	ErrModemBusy = newErr(true, false, errors.New("modem: busy"))
+
+	//ErrNoCarrier is returned by Open when no connection could be made, and by Read when the carrier is lost
+	ErrNoCarrier = newErr(false, false, errors.New("modem: no carrier"))
+
+	//ErrNoDialtone is returned by Open when the line is dead
+	ErrNoDialtone = newErr(false, false, errors.New("modem: no dialtone"))
+
+	//ErrNoAnswer is returned by Open when the remote modem does not answer. It is temporary.
+	ErrNoAnswer = newErr(true, false, errors.New("modem: no answer"))
+)
+
+/*modemNoCarrier is what a modem emits when the carrier drops in data mode*/
+var modemNoCarrier = []byte("\r\nNO CARRIER\r\n")
+
+/*ModemConfig controls how a ModemClient dials and hangs up*/
+type ModemConfig struct {
+	//Init is sent, without the trailing carriage return, before dialing. Defaults to "ATE0V1" (no echo, verbose result codes).
+	Init string
+
+	//DialTimeout is how long to wait for the result of dialing. Defaults to 60s.
+	DialTimeout time.Duration
+
+	//GuardTime is the silence required either side of the +++ escape. Defaults to 1s.
+	GuardTime time.Duration
+}
+
+/*
+ModemClient is a Hayes compatible modem, on a serial port (or any other
+IDoIO), which dials a number on Open and hangs up on Close.  Once connected,
+reads and writes pass straight through in data mode.
+*/
+type ModemClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	line      IDoIO
+	number    string
+	cfg       ModemConfig
+	dial      string
+	connected bool
+	at        *atPort
+	pending   []byte //data that arrived along with CONNECT
+	log       Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewModemClient dials out through the modem attached to a serial port (8N1).
+Dial should be in the form of "modem://<device>:<baud>/<number>", where the
+number may contain the usual dial modifiers (e.g. "9,5551234").
+*/
+func NewModemClient(ctx context.Context, timeout time.Duration, dial string) (*ModemClient, error) {
+	d, err := dialFor(dial, "modem")
+	if err == nil && (d.Path == "" || strings.Trim(d.Path, "0123456789*#,+ WwPpTt-") != "") {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	line, err := NewSerialClient(ctx, timeout, fmt.Sprintf("serial://%s:%d", d.Device, d.Baud))
+	if err != nil {
+		return nil, err
+	}
+	mc := newModemClient(ctx, line, d.Path, ModemConfig{})
+	mc.dial = dial
+	return mc, openUnlessLazy(ctx, mc)
+}
+
+/*
+NewModemClientOver dials number through the modem reachable over line, e.g.
+one behind a terminal server.  The ModemClient takes ownership of line.
+*/
+func NewModemClientOver(ctx context.Context, line IDoIO, number string, cfg ModemConfig) (*ModemClient, error) {
+	mc := newModemClient(ctx, line, number, cfg)
+	return mc, openUnlessLazy(ctx, mc)
+}
+
+func newModemClient(ctx context.Context, line IDoIO, number string, cfg ModemConfig) *ModemClient {
+	if cfg.Init == "" {
+		cfg.Init = "ATE0V1"
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 60 * time.Second
+	}
+	if cfg.GuardTime <= 0 {
+		cfg.GuardTime = 1 * time.Second
+	}
+	nctx, cancel := context.WithCancel(ctx)
+	return &ModemClient{ctx: nctx, cancel: cancel, line: line, at: &atPort{ctx: nctx, line: line}, number: number, cfg: cfg, dial: dialOf(line) + "/" + number}
+}
+
+/*
+SetLogger overrides the Logger carried by the context this ModemClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (mc *ModemClient) SetLogger(l Logger) {
+	mc.log = l
+}
+
+func (mc *ModemClient) dialString() string { return mc.dial }
+
+func (mc *ModemClient) logger() Logger {
+	if mc.log != nil {
+		return mc.log
+	}
+	return LoggerFrom(mc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (mc *ModemClient) String() string {
+	return fmt.Sprintf("modem connection to %s via %v", mc.number, mc.line)
+}
+
+/*
+Open hangs up any existing call, reopens the line to the modem, and dials.  It
+returns ErrModemBusy, ErrNoCarrier, ErrNoDialtone or ErrNoAnswer if the modem
+reports so.
+*/
+func (mc *ModemClient) Open() error {
+	select {
+	case <-mc.ctx.Done():
+		return newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.connected {
+		mc.hangup()
+	}
+	if err := mc.line.Open(); err != nil {
+		return err
+	}
+	mc.at.take()
+	if _, err := mc.at.command("AT", 2*time.Second, modemResult); err != nil {
+		return newErr(false, false, errors.Wrap(err, "modem not responding"))
+	}
+	if _, err := mc.at.command(mc.cfg.Init, 5*time.Second, modemResult); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to initialize modem"))
+	}
+	mc.logger().Debug("dialing", "event", EventConnect, "dial", mc.dial)
+	if _, err := mc.at.command("ATD"+mc.number, mc.cfg.DialTimeout, modemResult); err != nil {
+		mc.logger().Warn("unable to dial", "event", EventError, "dial", mc.dial, "error", err)
+		return err
+	}
+	mc.connected, mc.pending = true, mc.at.take()
+	mc.logger().Debug("modem connected", "event", EventConnect, "dial", mc.dial)
+	return nil
+}
+
+/*
+atPort exchanges AT commands with a Hayes style device over an IDoIO, keeping
+anything read beyond a response for the next exchange (or data mode)
+*/
+type atPort struct {
+	ctx  context.Context
+	line IDoIO
+	buf  []byte
+}
+
+/*command sends cmd and awaits its final result, returning the intermediate lines*/
+func (p *atPort) command(cmd string, wait time.Duration, final func(string) (bool, error)) ([]string, error) {
+	if _, err := p.line.Write([]byte(cmd + "\r")); err != nil {
+		return nil, err
+	}
+	return p.await(cmd, wait, final)
+}
+
+/*
+await reads lines until final reports one as final, or wait elapses,
+returning the (non-empty) lines before it.  what names the exchange in errors.
+*/
+func (p *atPort) await(what string, wait time.Duration, final func(string) (bool, error)) ([]string, error) {
+	deadline := time.Now().Add(wait)
+	var lines []string
+	for {
+		for {
+			end := bytes.IndexAny(p.buf, "\r\n")
+			if end < 0 {
+				break
+			}
+			line := strings.TrimSpace(string(p.buf[:end]))
+			p.buf = p.buf[end+1:]
+			if line == "" {
+				continue
+			}
+			if done, err := final(line); done {
+				if len(p.buf) > 0 && p.buf[0] == '\n' {
+					p.buf = p.buf[1:]
+				}
+				return lines, err
+			}
+			lines = append(lines, line)
+		}
+		if err := p.fill(what, deadline); err != nil {
+			return lines, err
+		}
+	}
+}
+
+/*readN returns the next n bytes, e.g. of a binary response*/
+func (p *atPort) readN(what string, n int, wait time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(wait)
+	for len(p.buf) < n {
+		if err := p.fill(what, deadline); err != nil {
+			return nil, err
+		}
+	}
+	b := cloneBytes(p.buf[:n])
+	p.buf = p.buf[n:]
+	return b, nil
+}
+
+/*fill reads once into the buffer, failing with a timeout after deadline*/
+func (p *atPort) fill(what string, deadline time.Time) error {
+	select {
+	case <-p.ctx.Done():
+		return newErr(false, false, p.ctx.Err())
+	default:
+	}
+	if !time.Now().Before(deadline) {
+		return newErr(true, true, fmt.Errorf("no response to %q", what))
+	}
+	b := make([]byte, 256)
+	n, err := p.line.Read(b)
+	p.buf = append(p.buf, b[:n]...)
+	if err != nil && !IsTemporary(err) {
+		return err
+	}
+	return nil
+}
+
+/*take returns and forgets anything buffered*/
+func (p *atPort) take() []byte {
+	b := p.buf
+	p.buf = nil
+	return b
+}
+
+/*modemResult returns true if line is a final result code, and the error it represents*/
+func modemResult(line string) (bool, error) {
+	switch {
+	case line == "OK", strings.HasPrefix(line, "CONNECT"):
+		return true, nil
+	case line == "BUSY":
+		return true, ErrModemBusy
+	case line == "NO CARRIER":
+		return true, ErrNoCarrier
+	case line == "NO DIALTONE", line == "NO DIAL TONE":
+		return true, ErrNoDialtone
+	case line == "NO ANSWER":
+		return true, ErrNoAnswer
+	case line == "ERROR":
+		return true, newErr(false, false, errors.New("modem: error"))
+	}
+	return false, nil
+}
+
+/*hangup escapes to command mode and hangs up, ignoring errors as the call may already be gone*/
+func (mc *ModemClient) hangup() {
+	mc.connected, mc.pending = false, nil
+	time.Sleep(mc.cfg.GuardTime)
+	mc.line.Write([]byte("+++"))
+	time.Sleep(mc.cfg.GuardTime)
+	mc.at.take()
+	mc.at.await("+++", 2*time.Second, modemResult)
+	mc.at.command("ATH0", 5*time.Second, modemResult)
+	mc.logger().Debug("modem hung up", "event", EventDisconnect, "dial", mc.dial)
+}
+
+/*
+Read conforms to io.Reader.  If the modem reports the carrier has been lost,
+data before the report is returned along with ErrNoCarrier.
+*/
+func (mc *ModemClient) Read(b []byte) (int, error) {
+	if !mc.connected {
+		return 0, readErr
+	}
+	if len(mc.pending) > 0 {
+		n := copy(b, mc.pending)
+		mc.pending = mc.pending[n:]
+		return n, nil
+	}
+	n, err := mc.line.Read(b)
+	if i := bytes.Index(b[:n], modemNoCarrier); i >= 0 {
+		mc.connected = false
+		mc.logger().Warn("carrier lost", "event", EventDisconnect, "dial", mc.dial)
+		return i, ErrNoCarrier
+	}
+	return n, err
+}
+
+/*Write conforms to io.Writer*/
+func (mc *ModemClient) Write(b []byte) (int, error) {
+	if !mc.connected {
+		return 0, writeErr
+	}
+	return mc.line.Write(b)
+}
+
+/*Close conforms to io.Closer, hanging up before closing the line to the modem*/
+func (mc *ModemClient) Close() error {
+	select {
+	case <-mc.ctx.Done():
+		mc.line.Close()
+		return newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.connected {
+		mc.hangup()
+	}
+	return mc.line.Close()
+}
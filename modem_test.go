@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+hayesHandler emulates a Hayes modem: numbers ending in 0 are busy, others
+connect and echo in data mode until "+++", and "DROP" drops the carrier
+*/
+func hayesHandler(t *testing.T, con net.Conn) {
+	defer con.Close()
+	data := false
+	buf := make([]byte, 256)
+	var line []byte
+	for {
+		n, err := con.Read(buf)
+		if err != nil {
+			return
+		}
+		if data {
+			switch {
+			case bytes.Equal(buf[:n], []byte("+++")):
+				data = false
+				con.Write([]byte("\r\nOK\r\n"))
+			case bytes.Contains(buf[:n], []byte("DROP")):
+				con.Write([]byte("bye\r\nNO CARRIER\r\n"))
+				data = false
+			default:
+				con.Write(buf[:n])
+			}
+			continue
+		}
+		line = append(line, buf[:n]...)
+		for {
+			i := bytes.IndexByte(line, '\r')
+			if i < 0 {
+				break
+			}
+			cmd := string(line[:i])
+			line = line[i+1:]
+			switch {
+			case strings.HasPrefix(cmd, "ATD") && strings.HasSuffix(cmd, "0"):
+				con.Write([]byte("\r\nBUSY\r\n"))
+			case strings.HasPrefix(cmd, "ATD"):
+				con.Write([]byte("\r\nCONNECT 9600\r\nwelcome"))
+				data = true
+			case strings.HasPrefix(cmd, "AT"):
+				con.Write([]byte("\r\nOK\r\n"))
+			}
+		}
+	}
+}
+
+func TestModemClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, hayesHandler)
+	cfg := ModemConfig{DialTimeout: time.Second, GuardTime: 10 * time.Millisecond}
+
+	line, _ := NewNetClient(ctx, 50*time.Millisecond, dial)
+	if _, err := NewModemClientOver(ctx, line, "5551230", cfg); err != ErrModemBusy {
+		t.Error("Expected a busy signal", err)
+	}
+
+	line, _ = NewNetClient(ctx, 50*time.Millisecond, dial)
+	mc, err := NewModemClientOver(ctx, line, "9,5551234", cfg)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	if dialOf(mc) != dial+"/9,5551234" {
+		t.Error("Unexpected dial string", dialOf(mc))
+	}
+	buf := make([]byte, 64)
+	if n, err := mc.Read(buf); err != nil || string(buf[:n]) != "welcome" {
+		t.Errorf("Expected the data following CONNECT, got %q %v", buf[:n], err)
+	}
+	mc.Write([]byte("ping"))
+	time.Sleep(20 * time.Millisecond)
+	if n, err := mc.Read(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("Expected an echo in data mode, got %q %v", buf[:n], err)
+	}
+
+	mc.Write([]byte("DROP"))
+	time.Sleep(20 * time.Millisecond)
+	if n, err := mc.Read(buf); err != ErrNoCarrier || string(buf[:n]) != "bye" {
+		t.Errorf("Expected the carrier to drop, got %q %v", buf[:n], err)
+	}
+	if _, err := mc.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail without a carrier")
+	}
+
+	if err := mc.Open(); err != nil {
+		t.Fatal("Unable to redial", err)
+	}
+	if err := mc.Close(); err != nil {
+		t.Error("Unable to hang up", err)
+	}
+
+	if _, err := NewIDoIO(ctx, time.Millisecond, "modem:///dev/nonexistent:9600/5551234"); err == nil {
+		t.Error("Expected a missing serial device to fail")
+	}
+	if _, err := NewModemClient(ctx, time.Millisecond, "modem:///dev/ttyS0:9600"); err == nil {
+		t.Error("Expected a dial string without a number to fail")
+	}
+}
@@ -0,0 +1,440 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_     IDoIO  = &MQTTClient{}
+	mqttN uint64 //distinguishes generated client identifiers
+)
+
+// MQTT 3.1.1 control packet types, shifted into the high nibble of the fixed header
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPubAck     = 0x40
+	mqttSubscribe  = 0x82 //includes the required flags
+	mqttSubAck     = 0x90
+	mqttPingReq    = 0xc0
+	mqttPingResp   = 0xd0
+	mqttDisconnect = 0xe0
+)
+
+/*
+MQTTClient provides an implementer of the IDoIO interface that maps a pair of
+MQTT topics onto a byte stream, under the URI regime:
+
+	mqtt://
+
+Reads return the payloads of messages published on the sub topic (one message
+per Read, unless b is too small to hold it), and each Write publishes b on the
+pub topic, so serial gateways bridged onto MQTT can be driven by an Arbiter.
+It speaks MQTT 3.1.1 over plain tcp at QoS 0, with a clean session, pinging
+the broker in the background to keep the connection alive.
+*/
+type MQTTClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	dial      string
+	address   string
+	sub, pub  string
+	clientID  string
+	user      string
+	pass      *string
+	keepalive time.Duration
+	timeout   time.Duration
+	rwtimeout time.Duration
+	log       Logger //nil means LoggerFrom(ctx)
+
+	conn net.Conn
+	wmux sync.Mutex    //serializes writes to conn, from Write and the pinger
+	stop chan struct{} //stops the pinger for conn
+	raw  []byte        //received but not yet parsed
+	msgs [][]byte      //payloads not yet (completely) read
+}
+
+/*
+NewMQTTClient connects to an MQTT broker. Dial should be in the form of
+"mqtt://<host>:<port>?sub=<topic>&pub=<topic>", optionally with any of
+client=<id> (defaulting to one generated), user=<name>, pass=<password> and
+keepalive=<seconds> (defaulting to 60).  Timeout bounds connecting, and
+waiting for the broker to acknowledge the connection and subscription.
+*/
+func NewMQTTClient(ctx context.Context, timeout time.Duration, dial string) (*MQTTClient, error) {
+	d, err := dialFor(dial, "mqtt")
+	if err != nil {
+		return nil, err
+	}
+	mc := &MQTTClient{
+		dial:      dial,
+		address:   d.Host,
+		clientID:  fmt.Sprintf("agnoio-%d-%d", os.Getpid(), atomic.AddUint64(&mqttN, 1)),
+		keepalive: 60 * time.Second,
+		timeout:   timeout,
+		rwtimeout: 1 * time.Millisecond,
+	}
+	for k, v := range d.Params {
+		switch k {
+		case "sub":
+			mc.sub = v[0]
+		case "pub":
+			mc.pub = v[0]
+		case "client":
+			mc.clientID = v[0]
+		case "user":
+			mc.user = v[0]
+		case "pass":
+			mc.pass = &v[0]
+		case "keepalive":
+			var secs uint64
+			secs, err = strconv.ParseUint(v[0], 10, 16)
+			mc.keepalive = time.Duration(secs) * time.Second
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+		}
+	}
+	if mc.sub == "" || mc.pub == "" {
+		return nil, newErr(false, false, fmt.Errorf("both sub and pub topics are required in %q", dial))
+	}
+	mc.ctx, mc.cancel = context.WithCancel(ctx)
+	return mc, openUnlessLazy(ctx, mc)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this MQTTClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (mc *MQTTClient) SetLogger(l Logger) {
+	mc.log = l
+}
+
+func (mc *MQTTClient) dialString() string { return mc.dial }
+
+func (mc *MQTTClient) logger() Logger {
+	if mc.log != nil {
+		return mc.log
+	}
+	return LoggerFrom(mc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (mc *MQTTClient) String() string {
+	return fmt.Sprintf("mqtt connection to %v (sub %v, pub %v)", mc.address, mc.sub, mc.pub)
+}
+
+/*Open forcibly closes (ignoring errors) any existing connection, then reconnects and resubscribes*/
+func (mc *MQTTClient) Open() (err error) {
+	select {
+	case <-mc.ctx.Done():
+		return newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	mc.disconnect()
+	mc.raw, mc.msgs = nil, nil
+	dialer := net.Dialer{Timeout: mc.timeout}
+	conn, err := dialer.DialContext(mc.ctx, "tcp", mc.address)
+	if err != nil {
+		mc.logger().Warn("unable to open connection", "event", EventError, "dial", mc.dial, "error", err)
+		return err
+	}
+	if err = mc.handshake(conn); err != nil {
+		conn.Close()
+		mc.logger().Warn("MQTT handshake failed", "event", EventError, "dial", mc.dial, "error", err)
+		if _, ok := err.(net.Error); !ok {
+			err = newErr(false, false, err)
+		}
+		return err
+	}
+	mc.conn, mc.stop = conn, make(chan struct{})
+	if mc.keepalive > 0 {
+		go mc.ping(conn, mc.stop)
+	}
+	mc.logger().Debug("connection opened", "event", EventConnect, "dial", mc.dial)
+	return nil
+}
+
+/*handshake connects and subscribes, buffering anything published in the meantime*/
+func (mc *MQTTClient) handshake(conn net.Conn) error {
+	if mc.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(mc.timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	flags := byte(0x02) //clean session
+	payload := mqttString(mc.clientID)
+	if mc.user != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(mc.user)...)
+	}
+	if mc.pass != nil {
+		flags |= 0x40
+		payload = append(payload, mqttString(*mc.pass)...)
+	}
+	connect := append(mqttString("MQTT"), 4, flags)
+	connect = binary.BigEndian.AppendUint16(connect, uint16(mc.keepalive/time.Second))
+	subscribe := append([]byte{0, 1}, mqttString(mc.sub)...)
+	out := append(mqttPacket(mqttConnect, append(connect, payload...)), mqttPacket(mqttSubscribe, append(subscribe, 0))...)
+	if _, err := conn.Write(out); err != nil {
+		return err
+	}
+	hdr, body, err := mqttReadPacket(conn)
+	switch {
+	case err != nil:
+		return err
+	case hdr&0xf0 != mqttConnAck || len(body) != 2:
+		return fmt.Errorf("expected CONNACK")
+	case body[1] != 0:
+		return fmt.Errorf("broker refused the connection with code %d", body[1])
+	}
+	for {
+		hdr, body, err := mqttReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		switch hdr & 0xf0 {
+		case mqttSubAck:
+			if len(body) != 3 || body[2] == 0x80 {
+				return fmt.Errorf("broker refused the subscription to %q", mc.sub)
+			}
+			return nil
+		case mqttPublish:
+			mc.received(conn, hdr, body)
+		}
+	}
+}
+
+/*ping sends PINGREQ until stop is closed*/
+func (mc *MQTTClient) ping(conn net.Conn, stop chan struct{}) {
+	tick := time.NewTicker(mc.keepalive / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-mc.ctx.Done():
+			return
+		case <-tick.C:
+		}
+		mc.wmux.Lock()
+		conn.SetWriteDeadline(time.Now().Add(mc.keepalive / 2))
+		_, err := conn.Write(mqttPacket(mqttPingReq, nil))
+		mc.wmux.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+/*received queues the payload of a PUBLISH, acknowledging it if need be*/
+func (mc *MQTTClient) received(conn net.Conn, hdr byte, body []byte) {
+	if len(body) < 2 {
+		return
+	}
+	n := 2 + int(binary.BigEndian.Uint16(body))
+	if qos := (hdr >> 1) & 0x03; qos > 0 {
+		if len(body) < n+2 {
+			return
+		}
+		mc.wmux.Lock()
+		conn.Write(mqttPacket(mqttPubAck, body[n:n+2]))
+		mc.wmux.Unlock()
+		n += 2
+	}
+	if len(body) > n {
+		mc.msgs = append(mc.msgs, body[n:])
+	}
+}
+
+/*Read conforms to io.Reader, returning (part of) the next message published on the sub topic*/
+func (mc *MQTTClient) Read(b []byte) (int, error) {
+	select {
+	case <-mc.ctx.Done():
+		defer mc.Close()
+		return 0, newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.conn == nil {
+		return 0, readErr
+	}
+	if len(mc.msgs) == 0 {
+		if mc.rwtimeout > 0 {
+			mc.conn.SetReadDeadline(time.Now().Add(mc.rwtimeout))
+		}
+		buf := make([]byte, 4096)
+		n, err := mc.conn.Read(buf)
+		mc.raw = append(mc.raw, buf[:n]...)
+		for {
+			hdr, body, n := mqttParsePacket(mc.raw)
+			if n == 0 {
+				break
+			}
+			mc.raw = mc.raw[n:]
+			if hdr&0xf0 == mqttPublish {
+				mc.received(mc.conn, hdr, body)
+			}
+		}
+		if len(mc.msgs) == 0 {
+			if err == nil {
+				err = newErr(true, true, fmt.Errorf("read: message incomplete"))
+			}
+			mc.logErr("read", err)
+			return 0, err
+		}
+	}
+	n := copy(b, mc.msgs[0])
+	if mc.msgs[0] = mc.msgs[0][n:]; len(mc.msgs[0]) == 0 {
+		mc.msgs = mc.msgs[1:]
+	}
+	return n, nil
+}
+
+/*Write conforms to io.Writer, publishing b on the pub topic*/
+func (mc *MQTTClient) Write(b []byte) (int, error) {
+	select {
+	case <-mc.ctx.Done():
+		defer mc.Close()
+		return 0, newErr(false, false, mc.ctx.Err())
+	default:
+	}
+	if mc.conn == nil {
+		return 0, writeErr
+	}
+	mc.wmux.Lock()
+	defer mc.wmux.Unlock()
+	if mc.timeout > 0 { //a partly written packet would break the stream, so allow more than rwtimeout
+		mc.conn.SetWriteDeadline(time.Now().Add(mc.timeout))
+	}
+	if _, err := mc.conn.Write(mqttPacket(mqttPublish, append(mqttString(mc.pub), b...))); err != nil {
+		mc.logErr("write", err)
+		return 0, err
+	}
+	return len(b), nil
+}
+
+/*Close conforms to io.Closer, disconnecting from the broker*/
+func (mc *MQTTClient) Close() error {
+	mc.cancel()
+	return mc.disconnect()
+}
+
+func (mc *MQTTClient) disconnect() error {
+	if mc.conn == nil {
+		return nil
+	}
+	close(mc.stop)
+	mc.wmux.Lock()
+	mc.conn.SetWriteDeadline(time.Now().Add(mc.rwtimeout))
+	mc.conn.Write(mqttPacket(mqttDisconnect, nil))
+	mc.wmux.Unlock()
+	mc.logger().Debug("connection closed", "event", EventDisconnect, "dial", mc.dial)
+	err := mc.conn.Close()
+	mc.conn = nil
+	return err
+}
+
+/*logErr logs non-temporary errors from op*/
+func (mc *MQTTClient) logErr(op string, err error) {
+	if err != nil && !IsTemporary(err) {
+		mc.logger().Debug(op+" failed", "event", EventError, "dial", mc.dial, "error", err)
+	}
+}
+
+/*mqttString encodes s as a length prefixed UTF-8 string*/
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+/*mqttPacket encodes a control packet: the fixed header, the remaining length, and body*/
+func mqttPacket(hdr byte, body []byte) []byte {
+	p := []byte{hdr}
+	for n := len(body); ; {
+		b := byte(n & 0x7f)
+		if n >>= 7; n > 0 {
+			b |= 0x80
+		}
+		if p = append(p, b); n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+/*mqttParsePacket decodes the packet at the start of b, returning n of 0 if it is incomplete*/
+func mqttParsePacket(b []byte) (hdr byte, body []byte, n int) {
+	size := 0
+	for i := 1; i < 5 && i < len(b); i++ {
+		size |= int(b[i]&0x7f) << (7 * (i - 1))
+		if b[i]&0x80 == 0 {
+			if len(b) < i+1+size {
+				return 0, nil, 0
+			}
+			return b[0], b[i+1 : i+1+size], i + 1 + size
+		}
+	}
+	return 0, nil, 0
+}
+
+/*mqttReadPacket reads a single packet from r*/
+func mqttReadPacket(r io.Reader) (byte, []byte, error) {
+	hdr := make([]byte, 1)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, nil, err
+	}
+	size := 0
+	for i := 0; ; i++ {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		size |= int(b[0]&0x7f) << (7 * i)
+		if b[0]&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+	}
+	body := make([]byte, size)
+	_, err := io.ReadFull(r, body)
+	return hdr[0], body, err
+}
@@ -0,0 +1,142 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+mqttBroker returns a broker for a single client, which answers every message
+published on "dev/in" by publishing "Rxd>%d" on "dev/out", and refuses
+connections from user "nobody"
+*/
+func mqttBroker(t *testing.T, con net.Conn) {
+	defer con.Close()
+	hdr, body, err := mqttReadPacket(con)
+	if err != nil || hdr != mqttConnect {
+		return
+	}
+	if bytes.Contains(body, []byte("nobody")) {
+		con.Write(mqttPacket(mqttConnAck, []byte{0, 5}))
+		return
+	}
+	con.Write(mqttPacket(mqttConnAck, []byte{0, 0}))
+	for {
+		hdr, body, err := mqttReadPacket(con)
+		if err != nil {
+			return
+		}
+		switch hdr & 0xf0 {
+		case mqttSubscribe & 0xf0:
+			topic := string(body[4 : len(body)-1])
+			code := byte(0)
+			if topic != "dev/out" {
+				code = 0x80
+			}
+			con.Write(mqttPacket(mqttSubAck, append(body[:2:2], code)))
+			//a retained message, delivered at QoS 1
+			con.Write(mqttPacket(mqttPublish|0x02, append(append(mqttString("dev/out"), 0, 7), "hello"...)))
+		case mqttPublish:
+			n := 2 + int(binary.BigEndian.Uint16(body))
+			if string(body[2:n]) == "dev/in" {
+				con.Write(mqttPacket(mqttPublish, append(mqttString("dev/out"), fmt.Sprintf("Rxd>%d", len(body)-n)...)))
+			}
+		case mqttPingReq:
+			con.Write(mqttPacket(mqttPingResp, nil))
+		case mqttPubAck:
+			if !bytes.Equal(body, []byte{0, 7}) {
+				t.Error("Unexpected PUBACK", body)
+			}
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func TestMQTTPacket(t *testing.T) {
+	for _, size := range []int{0, 5, 127, 128, 16384} {
+		p := mqttPacket(mqttPublish, make([]byte, size))
+		hdr, body, n := mqttParsePacket(p)
+		if hdr != mqttPublish || len(body) != size || n != len(p) {
+			t.Error("Packet did not survive a round trip", size, n)
+		}
+		if _, _, n := mqttParsePacket(p[:len(p)-1]); n != 0 {
+			t.Error("Expected an incomplete packet to be left alone", size)
+		}
+		if hdr, body, err := mqttReadPacket(bytes.NewReader(p)); err != nil || hdr != mqttPublish || len(body) != size {
+			t.Error("Unable to read packet", size, err)
+		}
+	}
+}
+
+func TestMQTTClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"mqtt://localhost:1883", "mqtt://localhost:1883?sub=a", "mqtt://localhost:1883?sub=a&pub=b&qos=2"} {
+		if _, err := NewMQTTClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+
+	_, srvdial, _ := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, mqttBroker)
+	a, err := NewArbiter(ctx, time.Second, "mqtt://"+srvdial+"?sub=dev/out&pub=dev/in&keepalive=1")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer a.Close()
+	<-time.After(700 * time.Millisecond) //long enough to ping
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil || string(rsp.Bytes) != "Rxd>3" {
+		t.Error("Expected a reply via the broker", string(rsp.Bytes), rsp.Error)
+	}
+
+	mc, err := NewMQTTClient(ctx, time.Second, "mqtt://"+srvdial+"?sub=dev/out&pub=dev/in&client=me&user=u&pass=p")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	_ = mc.String()
+	buf := make([]byte, 3)
+	if n, err := mc.Read(buf); err != nil || string(buf[:n]) != "hel" {
+		t.Error("Expected the retained message", string(buf[:n]), err)
+	}
+	if n, err := mc.Read(buf); err != nil || string(buf[:n]) != "lo" {
+		t.Error("Expected the rest of the message, and no more", string(buf[:n]), err)
+	}
+	mc.Close()
+
+	if _, err := NewIDoIO(ctx, time.Second, "mqtt://"+srvdial+"?sub=dev/other&pub=dev/in"); err == nil {
+		t.Error("Expected a refused subscription to fail")
+	}
+	if _, err := NewIDoIO(ctx, time.Second, "mqtt://"+srvdial+"?sub=dev/out&pub=dev/in&user=nobody"); err == nil || IsTemporary(err) {
+		t.Error("Expected a refused connection to fail", err)
+	}
+}
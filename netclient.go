@@ -27,21 +27,57 @@ package agnoio
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
-	"regexp"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 var (
-	_           IDoIO = &NetClient{}
-	netClientRe       = regexp.MustCompile("^(tcp|tcp4|tcp6|udp|udp4|udp6):\\/\\/(.*:[a-zA-Z0-9]*)$")
-	writeErr          = newErr(false, false, fmt.Errorf("write: broken connection"))
-	readErr           = newErr(false, false, fmt.Errorf("read: broken connection"))
+	_        IDoIO         = &NetClient{}
+	_        Flusher       = &NetClient{}
+	_        Drainer       = &NetClient{}
+	_        ContextReader = &NetClient{}
+	_        io.ReaderFrom = &NetClient{}
+	_        io.WriterTo   = &NetClient{}
+	writeErr               = newErr(false, false, fmt.Errorf("write: broken connection"))
+	readErr                = newErr(false, false, fmt.Errorf("read: broken connection"))
 )
 
 /*
 NewNetClient opens a connection to remote tcpv4 host.
-dial should be in the form of: 'tcp|udp[46]{0,1}://<host>:<port>'
+dial should be in the form of: 'tcp|udp[46]{0,1}://<host>:<port>[?<key>=<value>&...]'
+where the keys, each optional, are
+
+	proxy=<url>           tcp only: connect through a proxy (default none)
+	proxyproto=<1|2>      tcp only: send a PROXY protocol header, version 1 or 2 (default none)
+	broadcast=<bool>      udp only: allow sending to broadcast addresses (default false)
+	keepalive=<duration>  tcp only: idle time before the first keepalive, and between
+	                      the rest, in whole seconds; 0 turns them off (default 1s)
+	keepcount=<n>         tcp only: keepalives left unanswered before the connection
+	                      is dropped (default the system's, 9 on linux)
+	nodelay=<bool>        tcp only: false lets small writes be coalesced (default true)
+	laddr=<ip>:<port>     the local address to bind, port 0 for any (default any)
+	rcvbuf=<bytes>        the socket's receive buffer, SO_RCVBUF (default the system's)
+	sndbuf=<bytes>        the socket's send buffer, SO_SNDBUF (default the system's)
+
+The proxy is given as eg 'socks5://[user:pass@]bastion:1080' or
+'http://[user:pass@]proxy:3128', for SOCKS5 or HTTP CONNECT.  Failures of the
+proxy handshake are neither temporary nor timeouts.  The PROXY header gives
+the addresses of the connection itself, for servers behind a load balancer
+that expect one.
+
+Broadcasting is what device discovery protocols need, eg
+'udp://255.255.255.255:30718?broadcast=1'.  Slow links, such as satellite
+modems, want keepalives far longer than a second.  Hosts with several
+interfaces can pin the connection to one with laddr.  Buffers are set before
+connecting, so tcp can negotiate a window to match; see SocketInfo for what
+the kernel made of them.
 
 Timeout is used a read/write timeout at the socket level. If timeout is zero,
 timeouts are not used nor applied, and any errors are due to normal socket behaviour.
@@ -70,20 +106,28 @@ The caller is responsible for handling errors. This pkg just propagates any erro
 encountered.
 */
 func NewNetClient(ctx context.Context, timeout time.Duration, dial string) (*NetClient, error) {
-	if !netClientRe.MatchString(dial) {
-		return nil, newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	d, err := dialFor(dial, "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6")
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseNetOptions(d.Scheme, d.Params.Encode())
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
 	}
-	matches := netClientRe.FindAllStringSubmatch(dial, -1) //capture groups used
 	nctx, cancel := context.WithCancel(ctx)
 	nc := &NetClient{
-		network:   matches[0][1],
-		address:   matches[0][2],
-		timeout:   timeout,
-		rwtimeout: 1 * time.Millisecond,
-		ctx:       nctx,
-		cancel:    cancel,
+		dial:       dial,
+		network:    d.Scheme,
+		address:    d.Host,
+		netOptions: opts,
+		hook:       dialControlFrom(ctx),
+		timeout:    timeout,
+		rwtimeout:  1 * time.Millisecond,
+		ctx:        nctx,
+		cancel:     cancel,
 	}
-	return nc, nc.Open()
+	nc.instrument(ctx, dial, nc.logger)
+	return nc, openUnlessLazy(ctx, nc)
 }
 
 /*
@@ -98,12 +142,36 @@ access under the following URI Regimes:
 	udp6://
 */
 type NetClient struct {
+	netOptions //from the query portion of the dial string
+	connStats
+
+	dial             string
 	network, address string
 	cancel           context.CancelFunc
 	ctx              context.Context
 	rwtimeout        time.Duration
 	timeout          time.Duration
 	conn             net.Conn
+	hook             DialControl //from the context given at construction, nil if none
+	log              Logger      //nil means LoggerFrom(ctx)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this NetClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (nc *NetClient) SetLogger(l Logger) {
+	nc.log = l
+}
+
+func (nc *NetClient) dialString() string { return nc.dial }
+
+func (nc *NetClient) logger() Logger {
+	if nc.log != nil {
+		return nc.log
+	}
+	return LoggerFrom(nc.ctx)
 }
 
 /*
@@ -122,6 +190,8 @@ Open forcible disconnects (ignore errors) the network connection and
 attempts the connect process again.  It returns an error if it was unable to start
 */
 func (nc *NetClient) Open() (err error) {
+	nc.opening()
+	defer func() { nc.opened(err) }()
 	select {
 	case <-nc.ctx.Done():
 		return newErr(false, false, nc.ctx.Err())
@@ -134,14 +204,40 @@ func (nc *NetClient) Open() (err error) {
 	dialer := net.Dialer{
 		Timeout: nc.timeout,
 		// Deadline:
-		// LocalAddr:
+		LocalAddr: nc.laddr,
 		// FallbackDelay:
 		DualStack: false,
-		KeepAlive: 1 * time.Second,
+		KeepAlive: nc.keepalive,
 		Resolver:  nil,
+		Control:   nc.control,
+	}
+	//Errors from DialContext implement net.Error, as do those from dialProxy
+	if nc.proxy != nil {
+		nc.conn, err = dialProxy(nc.ctx, &dialer, nc.proxy, nc.network, nc.address)
+	} else {
+		nc.conn, err = dialer.DialContext(nc.ctx, nc.network, nc.address)
+	}
+	if err != nil {
+		nc.logger().Warn("unable to open connection", "event", EventError, "dial", nc.dial, "error", err)
+		return
+	}
+	if tc, ok := nc.conn.(*net.TCPConn); ok {
+		nc.tune(tc)
+	}
+	if nc.proxyHdr > 0 {
+		if nc.timeout > 0 {
+			nc.conn.SetWriteDeadline(time.Now().Add(nc.timeout))
+		}
+		if err = writeProxyHeader(nc.conn, nc.proxyHdr, nc.conn.LocalAddr(), nc.conn.RemoteAddr()); err != nil {
+			nc.conn.Close()
+			nc.conn = nil
+			nc.logger().Warn("unable to send PROXY header", "event", EventError, "dial", nc.dial, "error", err)
+			return newErr(false, false, errors.Wrap(err, "unable to send PROXY header"))
+		}
+	}
+	if nc.tracing(TraceOpen) {
+		nc.logger().Debug("connection opened", "event", EventConnect, "dial", nc.dial)
 	}
-	//Errors from DialContext implement net.Error
-	nc.conn, err = dialer.DialContext(nc.ctx, nc.network, nc.address)
 	return
 }
 
@@ -149,7 +245,8 @@ func (nc *NetClient) Open() (err error) {
 Read conforms to io.Writer, but immediately returns upon ctx
 destruction after closing the underlying transport
 */
-func (nc *NetClient) Read(b []byte) (int, error) {
+func (nc *NetClient) Read(b []byte) (n int, err error) {
+	defer func() { nc.read(n, err) }()
 	select {
 	case <-nc.ctx.Done():
 		defer nc.Close()
@@ -161,15 +258,53 @@ func (nc *NetClient) Read(b []byte) (int, error) {
 		if nc.rwtimeout > 0 {
 			nc.conn.SetReadDeadline(time.Now().Add(nc.rwtimeout))
 		}
-		return nc.conn.Read(b) //nc.conn  return errors that conform to net.Error
+		n, err := nc.conn.Read(b) //nc.conn  return errors that conform to net.Error
+		nc.logErr("read", err)
+		return n, err
+	}
+}
+
+/*
+ReadContext conforms to ContextReader, waiting for data until either ctx or
+the NetClient's own ctx is done, rather than for the read timeout
+*/
+func (nc *NetClient) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	defer func() { nc.read(n, err) }()
+	select {
+	case <-nc.ctx.Done():
+		defer nc.Close()
+		return 0, newErr(false, false, nc.ctx.Err())
+	default:
+	}
+	conn := nc.conn
+	if conn == nil {
+		return 0, readErr
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(nc.ctx, cancel)()
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now()) //unblocks the read with a timeout
+		close(interrupted)
+	})
+	n, err = conn.Read(b)
+	if !stop() {
+		<-interrupted
 	}
+	conn.SetReadDeadline(time.Time{}) //Read sets its own, if it wants one
+	nc.logErr("read", err)
+	return n, err
 }
 
 /*
 Write conforms to io.Writer, but immediately returns upon ctx
 destruction after closing the underlying transport
 */
-func (nc *NetClient) Write(b []byte) (int, error) {
+func (nc *NetClient) Write(b []byte) (n int, err error) {
+	defer func() { nc.wrote(n, err) }()
 	select {
 	case <-nc.ctx.Done():
 		defer nc.Close()
@@ -181,19 +316,415 @@ func (nc *NetClient) Write(b []byte) (int, error) {
 		if nc.rwtimeout > 0 {
 			nc.conn.SetWriteDeadline(time.Now().Add(nc.rwtimeout))
 		}
-		return nc.conn.Write(b) //nc.conn  return errors that conform to net.Error
+		n, err := nc.conn.Write(b) //nc.conn  return errors that conform to net.Error
+		nc.logErr("write", err)
+		return n, err
 	}
 }
 
+/*
+ReadFrom conforms to io.ReaderFrom, writing everything read from r until
+io.EOF, without the write deadline Write uses.  io.Copy into a NetClient so
+hands r to the net.Conn, letting the kernel move the data (with splice or
+sendfile) where it can; a NetClient as r is read from directly.
+*/
+func (nc *NetClient) ReadFrom(r io.Reader) (n int64, err error) {
+	defer func() { nc.wrote(int(n), err) }()
+	if nc.ctx.Err() != nil {
+		return 0, newErr(false, false, nc.ctx.Err())
+	}
+	if nc.conn == nil {
+		return 0, writeErr
+	}
+	if src, ok := r.(*NetClient); ok {
+		if src.conn == nil {
+			return 0, readErr
+		}
+		defer func() { src.read(int(n), nil) }()
+		src.conn.SetReadDeadline(time.Time{})
+		r = src.conn
+	}
+	nc.conn.SetWriteDeadline(time.Time{})
+	n, err = io.Copy(nc.conn, r)
+	nc.logErr("write", err)
+	return n, asNetError(err)
+}
+
+/*
+WriteTo conforms to io.WriterTo, writing everything read until the peer closes
+the connection to w, without the read deadline Read uses.  As with ReadFrom,
+io.Copy from a NetClient lets the kernel move the data where it can.
+*/
+func (nc *NetClient) WriteTo(w io.Writer) (n int64, err error) {
+	if dst, ok := w.(*NetClient); ok {
+		return dst.ReadFrom(nc)
+	}
+	defer func() { nc.read(int(n), err) }()
+	if nc.ctx.Err() != nil {
+		return 0, newErr(false, false, nc.ctx.Err())
+	}
+	if nc.conn == nil {
+		return 0, readErr
+	}
+	nc.conn.SetReadDeadline(time.Time{})
+	n, err = io.Copy(w, nc.conn)
+	nc.logErr("read", err)
+	return n, asNetError(err)
+}
+
+/*asNetError makes err (from outside this package) conform to net.Error, as a permanent error*/
+func asNetError(err error) error {
+	if _, ok := err.(net.Error); ok || err == nil {
+		return err
+	}
+	return newErr(false, false, err)
+}
+
+/*Redial conforms to Redialer*/
+func (nc *NetClient) Redial(ctx context.Context) (IDoIO, error) {
+	n, err := NewNetClient(WithLazyOpen(ctx), nc.timeout, nc.dial)
+	if err != nil {
+		return nil, err
+	}
+	n.log, n.hooks, n.trace = nc.log, nc.currentHooks(), nc.trace
+	if n.hook == nil {
+		n.hook = nc.hook
+	}
+	return n, nil
+}
+
 /*
 Close conforms to io.Closer, but immediately returns upon ctx
 destruction after closing the underlying transport
 */
 func (nc *NetClient) Close() error {
 	nc.cancel()
+	nc.closed()
 	defer func() { nc.conn = nil }()
 	if nc.conn != nil {
+		if nc.tracing(TraceClose) {
+			nc.logger().Debug("connection closed", "event", EventDisconnect, "dial", nc.dial)
+		}
 		return nc.conn.Close()
 	}
 	return nil
 }
+
+/*FlushInput conforms to Flusher, reading and discarding whatever has already arrived*/
+func (nc *NetClient) FlushInput() error {
+	b := make([]byte, 4096)
+	for {
+		if _, err := nc.Read(b); err != nil {
+			if IsTimeout(err) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+/*
+FlushOutput conforms to Flusher.  Datagrams are sent whole, so there is never
+anything to discard; a stream socket cannot take back what has been queued.
+*/
+func (nc *NetClient) FlushOutput() error {
+	if nc.conn == nil {
+		return writeErr
+	}
+	if strings.HasPrefix(nc.network, "udp") {
+		return nil
+	}
+	return newErr(false, false, fmt.Errorf("%s cannot discard queued output", nc.network))
+}
+
+/*
+Drain conforms to Drainer.  For tcp it waits, for up to the timeout given at
+construction (if any), until the peer has acknowledged everything written;
+datagrams are sent by the time Write returns.
+*/
+func (nc *NetClient) Drain() error {
+	if nc.conn == nil {
+		return writeErr
+	}
+	if strings.HasPrefix(nc.network, "udp") {
+		return nil
+	}
+	sc, ok := nc.conn.(syscall.Conn)
+	if !ok {
+		return newErr(false, false, fmt.Errorf("%T cannot be drained", nc.conn))
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	var deadline <-chan time.Time
+	if nc.timeout > 0 {
+		deadline = time.After(nc.timeout)
+	}
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	for {
+		var unsent int
+		var serr error
+		if err := raw.Control(func(fd uintptr) { unsent, serr = socketUnsent(fd) }); err != nil {
+			return newErr(false, false, err)
+		}
+		if serr != nil {
+			return newErr(false, false, serr)
+		}
+		if unsent == 0 {
+			return nil
+		}
+		select {
+		case <-nc.ctx.Done():
+			return newErr(false, false, nc.ctx.Err())
+		case <-deadline:
+			return newErr(true, true, fmt.Errorf("%d bytes still unacknowledged", unsent))
+		case <-tick.C:
+		}
+	}
+}
+
+/*
+CloseWrite conforms to HalfCloser, shutting down the sending side of a tcp
+connection so the peer reads EOF, while replies can still be read
+*/
+func (nc *NetClient) CloseWrite() error {
+	return nc.halfClose("write", func(c HalfCloser) error { return c.CloseWrite() })
+}
+
+/*CloseRead conforms to HalfCloser, shutting down the receiving side of a tcp connection*/
+func (nc *NetClient) CloseRead() error {
+	return nc.halfClose("read", func(c HalfCloser) error { return c.CloseRead() })
+}
+
+func (nc *NetClient) halfClose(side string, f func(HalfCloser) error) error {
+	if nc.conn == nil {
+		return writeErr
+	}
+	c, ok := nc.conn.(HalfCloser) //as *net.TCPConn is, including via a proxy
+	if !ok || nc.network[:3] != "tcp" {
+		return newErr(false, false, fmt.Errorf("%s cannot close just its %s side", nc.network, side))
+	}
+	nc.logger().Debug(side+" side closed", "event", EventDisconnect, "dial", nc.dial)
+	return f(c)
+}
+
+/*
+ReadMessage conforms to Messenger, returning a single datagram.  It fails for
+tcp, which has no message boundaries to keep.
+*/
+func (nc *NetClient) ReadMessage() ([]byte, error) {
+	if nc.network[:3] != "udp" {
+		return nil, newErr(false, false, fmt.Errorf("%s has no message boundaries", nc.network))
+	}
+	return readMessage(nc)
+}
+
+/*
+WriteMessage conforms to Messenger, sending b as a single datagram.  It fails
+for tcp, which has no message boundaries to keep.
+*/
+func (nc *NetClient) WriteMessage(b []byte) error {
+	if nc.network[:3] != "udp" {
+		return newErr(false, false, fmt.Errorf("%s has no message boundaries", nc.network))
+	}
+	return writeMessage(nc, b)
+}
+
+/*netOptions are those given in the query portion of a NetClient dial string*/
+type netOptions struct {
+	proxy     *url.URL      //nil if connecting directly
+	broadcast bool          //set SO_BROADCAST
+	keepalive time.Duration //tcp keepalive idle time and probe interval, negative for none
+	keepcount int           //unanswered keepalive probes before giving up, 0 for the OS default
+	coalesce  bool          //clear the TCP_NODELAY Go sets, letting small writes be coalesced
+	laddr     net.Addr      //local address to bind, nil to let the OS choose
+	rcvbuf    int           //SO_RCVBUF in bytes, 0 for the OS default
+	sndbuf    int           //SO_SNDBUF in bytes, 0 for the OS default
+	proxyHdr  int           //PROXY protocol header version to send on connecting, 0 for none
+}
+
+/*parseNetOptions parses the query portion of a NetClient dial string*/
+func parseNetOptions(network, query string) (opts netOptions, err error) {
+	opts = netOptions{keepalive: time.Second}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return opts, err
+	}
+	for k, v := range q {
+		switch k {
+		case "proxy":
+			opts.proxy, err = parseProxy(network, v[0])
+		case "proxyproto":
+			opts.proxyHdr, err = parseProxyProto(network, v[0])
+		case "broadcast":
+			if opts.broadcast, err = strconv.ParseBool(v[0]); err == nil && network[:3] != "udp" {
+				err = fmt.Errorf("%s cannot broadcast", network)
+			}
+		case "keepalive":
+			if opts.keepalive, err = time.ParseDuration(v[0]); err == nil && opts.keepalive <= 0 {
+				opts.keepalive = -1
+			}
+		case "keepcount":
+			if opts.keepcount, err = strconv.Atoi(v[0]); err == nil && opts.keepcount <= 0 {
+				err = fmt.Errorf("invalid %s %q", k, v[0])
+			}
+		case "laddr":
+			if network[:3] == "tcp" {
+				opts.laddr, err = net.ResolveTCPAddr(network, v[0])
+			} else {
+				opts.laddr, err = net.ResolveUDPAddr(network, v[0])
+			}
+		case "rcvbuf", "sndbuf":
+			var n int
+			if n, err = strconv.Atoi(v[0]); err == nil && n <= 0 {
+				err = fmt.Errorf("invalid %s %q", k, v[0])
+			}
+			if k == "rcvbuf" {
+				opts.rcvbuf = n
+			} else {
+				opts.sndbuf = n
+			}
+		case "nodelay":
+			var nodelay bool
+			nodelay, err = strconv.ParseBool(v[0])
+			opts.coalesce = !nodelay
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err == nil && network[:3] != "tcp" && (k == "keepalive" || k == "keepcount" || k == "nodelay") {
+			err = fmt.Errorf("%s has no %s", network, k)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+/*
+tune applies the tcp options that have to wait for a connection: Go resets
+the keepalive probe interval and count once connected
+*/
+func (nc *NetClient) tune(tc *net.TCPConn) {
+	if nc.coalesce {
+		tc.SetNoDelay(false)
+	}
+	if nc.keepalive <= 0 {
+		return
+	}
+	raw, err := tc.SyscallConn()
+	if err == nil {
+		secs := int((nc.keepalive + time.Second - 1) / time.Second)
+		cerr := raw.Control(func(fd uintptr) { err = setKeepalive(fd, secs, nc.keepcount) })
+		if err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		nc.logger().Warn("unable to tune tcp keepalives", "event", EventError, "dial", nc.dial, "error", err)
+	}
+}
+
+/*control is a net.Dialer Control func setting the socket options that need setsockopt*/
+func (o netOptions) control(network, address string, c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		if o.broadcast {
+			serr = setsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+		}
+		//before connect so tcp can negotiate a window scale to match
+		if o.rcvbuf > 0 && serr == nil {
+			serr = setsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.rcvbuf)
+		}
+		if o.sndbuf > 0 && serr == nil {
+			serr = setsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.sndbuf)
+		}
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+/*
+SocketInfo describes the socket underneath a NetClient as the kernel has it,
+rather than as it was asked for in the dial string
+*/
+type SocketInfo struct {
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	RecvBuffer int //SO_RCVBUF in bytes; linux reports double what was asked for, to cover its bookkeeping
+	SendBuffer int //SO_SNDBUF in bytes; likewise
+}
+
+/*
+Info reports the addresses and kernel buffer sizes of the open connection, so
+that rcvbuf and sndbuf requests capped by the OS (net.core.rmem_max on linux)
+can be noticed.  It returns an error if the connection is not open.
+*/
+func (nc *NetClient) Info() (info SocketInfo, err error) {
+	if nc.conn == nil {
+		return info, readErr
+	}
+	info.LocalAddr, info.RemoteAddr = nc.conn.LocalAddr(), nc.conn.RemoteAddr()
+	sc, ok := nc.conn.(syscall.Conn)
+	if !ok {
+		return info, nil //proxied udp, nothing further to ask
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return info, err
+	}
+	var serr error
+	if err = raw.Control(func(fd uintptr) {
+		if info.RecvBuffer, serr = getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF); serr == nil {
+			info.SendBuffer, serr = getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		}
+	}); err != nil {
+		return info, err
+	}
+	return info, serr
+}
+
+/*
+DialControl is called with the raw socket of each connection a NetClient
+makes, after the options from the dial string have been set and before it is
+connected, to set any others, eg SO_REUSEADDR, IP_TOS or SO_BINDTODEVICE.  If
+it returns an error the connection is abandoned.  When dialing via a proxy it
+is the connection to the proxy that is handed over.
+*/
+type DialControl func(network, address string, c syscall.RawConn) error
+
+type dialControlKey struct{}
+
+/*
+WithDialControl returns a copy of ctx carrying f.  NetClients built from the
+returned context (or one derived from it) call f on every connection they make.
+*/
+func WithDialControl(ctx context.Context, f DialControl) context.Context {
+	return context.WithValue(ctx, dialControlKey{}, f)
+}
+
+func dialControlFrom(ctx context.Context) DialControl {
+	f, _ := ctx.Value(dialControlKey{}).(DialControl)
+	return f
+}
+
+/*control is the net.Dialer Control func, applying the netOptions and then any DialControl*/
+func (nc *NetClient) control(network, address string, c syscall.RawConn) error {
+	if err := nc.netOptions.control(network, address, c); err != nil {
+		return err
+	}
+	if nc.hook != nil {
+		return nc.hook(network, address, c)
+	}
+	return nil
+}
+
+/*logErr logs non-temporary errors from op*/
+func (nc *NetClient) logErr(op string, err error) {
+	if err != nil && !IsTemporary(err) {
+		nc.logger().Debug(op+" failed", "event", EventError, "dial", nc.dial, "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNetClient_KeepaliveSockopts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	sockopt := func(nc *NetClient, level, opt int) int {
+		t.Helper()
+		raw, err := nc.conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v int
+		var gerr error
+		raw.Control(func(fd uintptr) { v, gerr = unix.GetsockoptInt(int(fd), level, opt) })
+		if gerr != nil {
+			t.Fatal(gerr)
+		}
+		return v
+	}
+
+	nc, err := NewNetClient(ctx, time.Second, dial+"?keepalive=45s&keepcount=4&nodelay=false")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	if v := sockopt(nc, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL); v != 45 {
+		t.Error("Expected a 45s keepalive interval, got", v)
+	}
+	if v := sockopt(nc, unix.IPPROTO_TCP, unix.TCP_KEEPCNT); v != 4 {
+		t.Error("Expected 4 keepalive probes, got", v)
+	}
+	if v := sockopt(nc, unix.IPPROTO_TCP, unix.TCP_NODELAY); v != 0 {
+		t.Error("Expected TCP_NODELAY to be cleared")
+	}
+
+	nc, err = NewNetClient(ctx, time.Second, dial+"?keepalive=0")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	if v := sockopt(nc, unix.SOL_SOCKET, unix.SO_KEEPALIVE); v != 0 {
+		t.Error("Expected keepalives to be off")
+	}
+	if v := sockopt(nc, unix.IPPROTO_TCP, unix.TCP_NODELAY); v == 0 {
+		t.Error("Expected TCP_NODELAY to be left set")
+	}
+}
@@ -25,10 +25,14 @@ SOFTWARE.
 package agnoio
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -57,6 +61,22 @@ func randPortCfg() (port int, svr string, dial string) {
 	return
 }
 
+/*
+freePortCfg is randPortCfg, but with a port the OS reports free, for tests that
+need nothing to be listening on it, or that run alongside other servers
+*/
+func freePortCfg() (port int, svr string, dial string) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic(err)
+	}
+	port = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	svr = fmt.Sprintf("localhost:%d", port)
+	dial = fmt.Sprintf("tcp://localhost:%d", port)
+	return
+}
+
 func newTCPSvr(ctx context.Context, t *testing.T, proto string, addr string, handler respHandler) {
 	t.Helper()
 	running := make(chan struct{})
@@ -155,3 +175,425 @@ func TestNewNetClient(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestNetClient_Broadcast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"tcp://localhost:1?broadcast=1", "udp://localhost:1?broadcast=maybe"} {
+		if _, err := NewNetClient(ctx, time.Second, dial); err == nil || IsTemporary(err) {
+			t.Error("Expected a permanent error for", dial)
+		}
+	}
+	opts, err := parseNetOptions("udp4", "broadcast=true")
+	if err != nil || !opts.broadcast || opts.proxy != nil {
+		t.Error("Expected broadcast to be parsed", opts, err)
+	}
+
+	port, _, _ := freePortCfg()
+	nc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("udp4://255.255.255.255:%d?broadcast=1", port))
+	if err != nil {
+		t.Fatal("Unable to open a broadcast client", err)
+	}
+	defer nc.Close()
+	if !nc.broadcast {
+		t.Error("Expected the client to broadcast")
+	}
+	if _, err := nc.Write([]byte("discover")); err != nil {
+		t.Error("Unable to broadcast", err)
+	}
+}
+
+func TestNetClient_FlushDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	nc, err := NewNetClient(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+
+	if _, err := nc.Write([]byte("dead cat")); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Drain(); err != nil {
+		t.Error("Unable to drain", err)
+	}
+	<-time.After(20 * time.Millisecond)
+	if err := nc.FlushInput(); err != nil {
+		t.Error("Unable to flush input", err)
+	}
+	b := make([]byte, 16)
+	if n, err := nc.Read(b); err == nil || !IsTimeout(err) {
+		t.Errorf("Expected nothing left to read, got %q %v", b[:n], err)
+	}
+	if err := nc.FlushOutput(); err == nil || IsTemporary(err) {
+		t.Error("Expected tcp to be unable to discard output")
+	}
+
+	port, _, _ := freePortCfg()
+	uc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("udp://localhost:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	if uc.FlushOutput() != nil || uc.Drain() != nil || uc.FlushInput() != nil {
+		t.Error("Expected udp to flush and drain trivially")
+	}
+}
+
+func TestNetClient_ReadContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	nc, err := NewNetClient(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+
+	b := make([]byte, 16)
+	rctx, rcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer rcancel()
+	start := time.Now()
+	if _, err := nc.ReadContext(rctx, b); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout once ctx was done", err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("Expected to wait for ctx, only waited %v", waited)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		nc.Write([]byte("cat"))
+	}()
+	rctx, rcancel = context.WithCancel(ctx)
+	time.AfterFunc(time.Second, rcancel)
+	if n, err := nc.ReadContext(rctx, b); err != nil || string(b[:n]) != "Rxd>3" {
+		t.Errorf("Expected the reply, got %q %v", b[:n], err)
+	}
+
+	//reads go back to their usual timeout afterwards
+	start = time.Now()
+	if _, err := nc.Read(b); err == nil || !IsTimeout(err) || time.Since(start) > 500*time.Millisecond {
+		t.Error("Expected a quick timeout", err)
+	}
+}
+
+func TestNetClient_Keepalive(t *testing.T) {
+	for _, query := range []string{"keepalive=1m", "keepcount=3", "nodelay=false"} {
+		if _, err := parseNetOptions("udp", query); err == nil {
+			t.Error("Expected udp to refuse", query)
+		}
+	}
+	for _, query := range []string{"keepalive=soon", "keepcount=0", "nodelay=maybe"} {
+		if _, err := parseNetOptions("tcp", query); err == nil {
+			t.Error("Expected an error for", query)
+		}
+	}
+	opts, err := parseNetOptions("tcp", "")
+	if err != nil || opts.keepalive != time.Second || opts.keepcount != 0 || opts.coalesce {
+		t.Error("Unexpected defaults", opts, err)
+	}
+	opts, err = parseNetOptions("tcp4", "keepalive=45s&keepcount=4&nodelay=false")
+	if err != nil || opts.keepalive != 45*time.Second || opts.keepcount != 4 || !opts.coalesce {
+		t.Error("Unexpected options", opts, err)
+	}
+	if opts, err = parseNetOptions("tcp", "keepalive=0"); err != nil || opts.keepalive >= 0 {
+		t.Error("Expected keepalive=0 to turn keepalives off", opts, err)
+	}
+}
+
+func TestNetClient_LocalAddr(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := parseNetOptions("tcp", "laddr=nowhere"); err == nil {
+		t.Error("Expected an unresolvable laddr to fail")
+	}
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	lport, _, _ := freePortCfg()
+	nc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("%s?laddr=127.0.0.1:%d", dial, lport))
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	if got := nc.conn.LocalAddr().String(); got != fmt.Sprintf("127.0.0.1:%d", lport) {
+		t.Error("Expected to be bound to the given port, got", got)
+	}
+
+	port, _, _ := freePortCfg()
+	uc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("udp4://localhost:%d?laddr=127.0.0.1:0", port))
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer uc.Close()
+	if la := uc.conn.LocalAddr().(*net.UDPAddr); !la.IP.Equal(net.IPv4(127, 0, 0, 1)) || la.Port == 0 {
+		t.Error("Expected to be bound to loopback, got", la)
+	}
+}
+
+func TestNetClient_Buffers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, q := range []string{"rcvbuf=0", "sndbuf=-1", "rcvbuf=big"} {
+		if _, err := parseNetOptions("udp", q); err == nil {
+			t.Errorf("Expected %q to fail", q)
+		}
+	}
+	nc := &NetClient{}
+	if _, err := nc.Info(); err == nil {
+		t.Error("Expected Info to fail when not open")
+	}
+
+	port, _, _ := freePortCfg()
+	uc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("udp4://localhost:%d?rcvbuf=65536&sndbuf=32768", port))
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer uc.Close()
+	info, err := uc.Info()
+	if err != nil {
+		t.Fatal("Unable to get socket info", err)
+	}
+	//linux doubles the request, others give it back as is
+	if info.RecvBuffer < 65536 || info.SendBuffer < 32768 {
+		t.Errorf("Expected buffers of at least 65536/32768, got %d/%d", info.RecvBuffer, info.SendBuffer)
+	}
+	if info.RemoteAddr.String() != fmt.Sprintf("127.0.0.1:%d", port) {
+		t.Error("Unexpected remote address", info.RemoteAddr)
+	}
+}
+
+func TestNetClient_Messenger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	nc, err := NewNetClient(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	if _, err := nc.ReadMessage(); err == nil {
+		t.Error("Expected tcp to have no messages to read")
+	}
+	if err := nc.WriteMessage([]byte("x")); err == nil {
+		t.Error("Expected tcp to have no messages to write")
+	}
+
+	port, _, _ := freePortCfg()
+	newUDPEchoSvr(ctx, t, fmt.Sprintf("127.0.0.1:%d", port))
+	uc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("udp4://127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer uc.Close()
+	var m Messenger = uc
+	big := bytes.Repeat([]byte("x"), 1000)
+	for _, msg := range [][]byte{[]byte("one"), big} {
+		if err := m.WriteMessage(msg); err != nil {
+			t.Fatal("Unable to write", err)
+		}
+	}
+	<-time.After(20 * time.Millisecond)
+	for _, want := range [][]byte{[]byte("one"), big} {
+		if got, err := m.ReadMessage(); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Expected one whole datagram of %d bytes per read, got %d %v", len(want), len(got), err)
+		}
+	}
+	if _, err := m.ReadMessage(); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout with nothing to read", err)
+	}
+}
+
+func TestNetClient_HalfClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	//replies only once the command is known to be complete
+	newTCPSvr(ctx, t, "tcp", srvdial, func(t *testing.T, con net.Conn) {
+		cmd, _ := io.ReadAll(con)
+		con.Write([]byte("Rxd:" + string(cmd)))
+		con.Close()
+	})
+	nc, err := NewNetClient(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	var hc HalfCloser = nc
+	nc.Write([]byte("dead cat"))
+	if err := hc.CloseWrite(); err != nil {
+		t.Fatal("Unable to close the write side", err)
+	}
+	if _, err := nc.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail after CloseWrite")
+	}
+	<-time.After(20 * time.Millisecond)
+	buf := make([]byte, 64)
+	if n, err := nc.Read(buf); err != nil || string(buf[:n]) != "Rxd:dead cat" {
+		t.Errorf("Expected the reply to still be readable, got %q %v", buf[:n], err)
+	}
+	if err := nc.Open(); err != nil {
+		t.Fatal("Unable to reopen", err)
+	}
+	if err := hc.CloseRead(); err != nil {
+		t.Error("Unable to close the read side", err)
+	}
+	if _, err := nc.Read(buf); err != io.EOF {
+		t.Error("Expected EOF after CloseRead, got", err)
+	}
+
+	port, _, _ := freePortCfg()
+	uc, err := NewNetClient(ctx, time.Second, fmt.Sprintf("udp4://127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer uc.Close()
+	if err := uc.CloseWrite(); err == nil {
+		t.Error("Expected udp to have no write side to close")
+	}
+}
+
+func TestNetClient_DialControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	var called []string
+	hctx := WithDialControl(ctx, func(network, address string, c syscall.RawConn) error {
+		called = append(called, network)
+		return nil
+	})
+	nc, err := NewNetClient(hctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	if err := nc.Open(); err != nil {
+		t.Fatal("Unable to reopen", err)
+	}
+	if len(called) < 2 || !strings.HasPrefix(called[0], "tcp") {
+		t.Error("Expected the hook to be called on every connection, got", called)
+	}
+
+	refuse := WithDialControl(ctx, func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("not on this network")
+	})
+	if _, err := NewNetClient(refuse, time.Second, dial); err == nil {
+		t.Error("Expected an error from the hook to abandon the connection")
+	}
+}
+
+func TestNetClient_Redial(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	nctx, ncancel := context.WithCancel(ctx)
+	nc, err := NewNetClient(nctx, time.Second, dial+"?nodelay=false")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	opens := 0
+	nc.SetHooks(Hooks{OnOpen: func(string) { opens++ }})
+	a, _ := Arbitrate(nctx, nc)
+	ncancel()
+	if err := nc.Open(); err == nil {
+		t.Fatal("Expected a cancelled NetClient not to open")
+	}
+
+	idoio, err := nc.Redial(ctx)
+	if err != nil {
+		t.Fatal("Unable to redial", err)
+	}
+	fresh := idoio.(*NetClient)
+	if fresh.dial != nc.dial || fresh.timeout != nc.timeout || !fresh.coalesce || fresh.IsOpen() {
+		t.Error("Expected an unopened copy", fresh.dial, fresh.timeout, fresh.Status())
+	}
+	if err := fresh.Open(); err != nil || opens != 1 {
+		t.Error("Expected the copy to open, with the hooks kept", err, opens)
+	}
+	fresh.Close()
+
+	idoio, err = a.(*Arb).Redial(ctx)
+	if err != nil {
+		t.Fatal("Unable to redial the Arbiter", err)
+	}
+	defer idoio.Close()
+	if err := idoio.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	if resp := idoio.(Arbiter).Control(arbCmdOk); resp.Error != nil {
+		t.Error("Expected the redialed Arbiter to work", resp.Error)
+	}
+
+	b, stop := Arbitrate(ctx, &bufIO{})
+	defer stop()
+	if _, err := b.(*Arb).Redial(ctx); err == nil {
+		t.Error("Expected an Arbiter over a bufIO not to redial")
+	}
+}
+
+func TestNetClient_Copy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	payload := bytes.Repeat([]byte("0123456789"), 100000)
+
+	source, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	go func() {
+		if c, err := source.Accept(); err == nil {
+			c.Write(payload)
+			c.Close()
+		}
+	}()
+	sink, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		if c, err := sink.Accept(); err == nil {
+			b, _ := io.ReadAll(c)
+			received <- b
+		}
+	}()
+
+	src, err := NewNetClient(ctx, time.Second, "tcp://"+source.Addr().String())
+	if err != nil {
+		t.Fatal("Unable to dial the source", err)
+	}
+	dst, err := NewNetClient(ctx, time.Second, "tcp://"+sink.Addr().String())
+	if err != nil {
+		t.Fatal("Unable to dial the sink", err)
+	}
+	n, err := io.Copy(dst, src)
+	if n != int64(len(payload)) || err != nil {
+		t.Fatal("Expected everything to be copied", n, err)
+	}
+	dst.Close()
+	if b := <-received; !bytes.Equal(b, payload) {
+		t.Error("Expected the payload to arrive intact", len(b))
+	}
+	if src.Stats().BytesRead != uint64(n) || dst.Stats().BytesWritten != uint64(n) {
+		t.Error("Expected the copy to be counted", src.Stats(), dst.Stats())
+	}
+
+	//nothing can be copied once closed
+	buf := &bytes.Buffer{}
+	src.Close()
+	if _, err := src.WriteTo(buf); err == nil {
+		t.Error("Expected a closed NetClient to fail")
+	}
+}
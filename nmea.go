@@ -0,0 +1,491 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	knotsToMetersPerSec = 1852.0 / 3600.0
+	nmeaMaxSentence     = 1024 //longer than any sane sentence, proprietary ones included
+)
+
+/*
+ScanNMEA is a bufio.SplitFunc returning NMEA 0183 sentences, from the leading
+'$' or '!' up to (but not including) the line ending.  Anything between
+sentences is discarded.  It is registered as "nmea" for framing layers, e.g.
+"frame(split=nmea)".
+*/
+func ScanNMEA(data []byte, atEOF bool) (int, []byte, error) {
+	start := bytes.IndexAny(data, "$!")
+	if start < 0 {
+		return len(data), nil, nil
+	}
+	if end := bytes.IndexByte(data[start:], '\n'); end >= 0 {
+		return start + end + 1, bytes.TrimRight(data[start:start+end], "\r"), nil
+	}
+	if len(data)-start > nmeaMaxSentence {
+		return start + 1, nil, nil //no line ending in sight, resync on the next start
+	}
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+/*
+NMEASentence is an NMEA 0183 sentence split into its fields, without any
+interpretation.  Proprietary sentences (those whose address starts with 'P')
+have a Talker of "P" and a Type of the rest of the address, e.g. "$PUBX" has
+Type "UBX".
+*/
+type NMEASentence struct {
+	Raw    string
+	Talker string   //e.g. "GP", "GN"
+	Type   string   //e.g. "GGA"
+	Fields []string //the fields following the address, without the checksum
+}
+
+/*Sentence conforms to NMEAMessage*/
+func (s NMEASentence) Sentence() NMEASentence { return s }
+
+/*String conforms to fmt.Stringer*/
+func (s NMEASentence) String() string { return s.Raw }
+
+/*field returns field i, or "" if the sentence is too short to have it*/
+func (s NMEASentence) field(i int) string {
+	if i < len(s.Fields) {
+		return s.Fields[i]
+	}
+	return ""
+}
+
+/*
+NMEAMessage is a decoded NMEA sentence, e.g. a GGA, or the NMEASentence itself
+for types without a registered parser.  Decoded types embed the NMEASentence
+they were parsed from.
+*/
+type NMEAMessage interface {
+	Sentence() NMEASentence
+}
+
+/*NMEAParser decodes an NMEASentence (with a valid checksum) into a typed NMEAMessage*/
+type NMEAParser func(NMEASentence) (NMEAMessage, error)
+
+var nmeaParsers = struct {
+	sync.RWMutex
+	parsers map[string]NMEAParser
+}{parsers: map[string]NMEAParser{
+	"GGA": parseGGA,
+	"RMC": parseRMC,
+	"GSV": parseGSV,
+	"ZDA": parseZDA,
+}}
+
+/*
+RegisterNMEA adds a parser for sentences of type typ (e.g. "GRME" for Garmin's
+"$PGRME"), which ParseNMEA will then use.  An error is returned if typ is
+taken.
+*/
+func RegisterNMEA(typ string, parser NMEAParser) error {
+	nmeaParsers.Lock()
+	defer nmeaParsers.Unlock()
+	if _, taken := nmeaParsers.parsers[typ]; taken || typ == "" {
+		return newErr(false, false, fmt.Errorf("NMEA parser for %q is already registered", typ))
+	}
+	nmeaParsers.parsers[typ] = parser
+	return nil
+}
+
+/*
+SplitNMEA splits a sentence into its fields, verifying the checksum if there
+is one.  Leading and trailing whitespace is ignored.
+*/
+func SplitNMEA(line string) (NMEASentence, error) {
+	line = strings.TrimSpace(line)
+	s := NMEASentence{Raw: line}
+	if len(line) < 2 || (line[0] != '$' && line[0] != '!') {
+		return s, newErr(false, false, fmt.Errorf("not an NMEA sentence: %q", line))
+	}
+	body := line[1:]
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		want, err := strconv.ParseUint(body[star+1:], 16, 8)
+		if err != nil || len(body)-star != 3 {
+			return s, newErr(false, false, fmt.Errorf("malformed checksum in %q", line))
+		}
+		var sum byte
+		for i := 0; i < star; i++ {
+			sum ^= body[i]
+		}
+		if sum != byte(want) {
+			return s, newErr(false, false, fmt.Errorf("checksum %02X does not match %02X in %q", sum, want, line))
+		}
+		body = body[:star]
+	}
+	fields := strings.Split(body, ",")
+	addr := fields[0]
+	switch {
+	case strings.HasPrefix(addr, "P"):
+		s.Talker, s.Type = "P", addr[1:]
+	case len(addr) >= 5:
+		s.Talker, s.Type = addr[:len(addr)-3], addr[len(addr)-3:]
+	default:
+		return s, newErr(false, false, fmt.Errorf("malformed address in %q", line))
+	}
+	s.Fields = fields[1:]
+	return s, nil
+}
+
+/*
+ParseNMEA splits line and decodes it with the parser registered for its type,
+returning the bare NMEASentence if there is none.
+*/
+func ParseNMEA(line string) (NMEAMessage, error) {
+	s, err := SplitNMEA(line)
+	if err != nil {
+		return nil, err
+	}
+	nmeaParsers.RLock()
+	parse, ok := nmeaParsers.parsers[s.Type]
+	nmeaParsers.RUnlock()
+	if !ok {
+		return s, nil
+	}
+	msg, err := parse(s)
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "unable to parse %s", s.Type))
+	}
+	return msg, nil
+}
+
+/*GGA is a GPS fix: time, position and fix quality*/
+type GGA struct {
+	NMEASentence
+	TimeOfDay       time.Duration //since UTC midnight
+	Latitude        float64       //decimal degrees, negative south
+	Longitude       float64       //decimal degrees, negative west
+	Quality         int           //0 no fix, 1 GPS, 2 DGPS, 4 RTK fixed, 5 RTK float, ...
+	Satellites      int           //number in use
+	HDOP            float64
+	Altitude        float64       //meters above mean sea level
+	GeoidSeparation float64       //meters, geoid above the WGS84 ellipsoid
+	DGPSAge         time.Duration //age of differential corrections, zero if none
+	DGPSStation     string
+}
+
+/*RMC is the recommended minimum: date, time, position and velocity*/
+type RMC struct {
+	NMEASentence
+	Time              time.Time //UTC
+	Valid             bool      //status 'A'
+	Latitude          float64   //decimal degrees, negative south
+	Longitude         float64   //decimal degrees, negative west
+	Speed             float64   //over ground, meters per second
+	Course            float64   //over ground, degrees true
+	MagneticVariation float64   //degrees, negative west
+}
+
+/*GSVSatellite is one satellite reported by a GSV sentence*/
+type GSVSatellite struct {
+	PRN       int
+	Elevation int //degrees
+	Azimuth   int //degrees true
+	SNR       int //dB-Hz, or -1 if not tracked
+}
+
+/*GSV is one sentence of a sequence listing the satellites in view*/
+type GSV struct {
+	NMEASentence
+	Total            int //number of sentences in the sequence
+	Number           int //of this sentence, starting at 1
+	SatellitesInView int
+	Satellites       []GSVSatellite
+}
+
+/*ZDA is the UTC date and time, and the local time zone*/
+type ZDA struct {
+	NMEASentence
+	Time       time.Time //UTC
+	ZoneOffset time.Duration
+}
+
+func parseGGA(s NMEASentence) (NMEAMessage, error) {
+	var err error
+	g := GGA{NMEASentence: s}
+	g.TimeOfDay, err = nmeaTimeOfDay(s.field(0), err)
+	g.Latitude, err = nmeaDegrees(s.field(1), s.field(2), err)
+	g.Longitude, err = nmeaDegrees(s.field(3), s.field(4), err)
+	g.Quality, err = nmeaInt(s.field(5), err)
+	g.Satellites, err = nmeaInt(s.field(6), err)
+	g.HDOP, err = nmeaFloat(s.field(7), err)
+	g.Altitude, err = nmeaFloat(s.field(8), err)
+	g.GeoidSeparation, err = nmeaFloat(s.field(10), err)
+	age, err := nmeaFloat(s.field(12), err)
+	g.DGPSAge = time.Duration(age * float64(time.Second))
+	g.DGPSStation = s.field(13)
+	return g, err
+}
+
+func parseRMC(s NMEASentence) (NMEAMessage, error) {
+	var err error
+	r := RMC{NMEASentence: s, Valid: s.field(1) == "A"}
+	tod, err := nmeaTimeOfDay(s.field(0), err)
+	r.Latitude, err = nmeaDegrees(s.field(2), s.field(3), err)
+	r.Longitude, err = nmeaDegrees(s.field(4), s.field(5), err)
+	knots, err := nmeaFloat(s.field(6), err)
+	r.Speed = knots * knotsToMetersPerSec
+	r.Course, err = nmeaFloat(s.field(7), err)
+	if d := s.field(8); err == nil && d != "" {
+		var date time.Time
+		if date, err = time.Parse("020106", d); err == nil {
+			r.Time = date.Add(tod)
+		}
+	}
+	r.MagneticVariation, err = nmeaFloat(s.field(9), err)
+	if s.field(10) == "W" {
+		r.MagneticVariation = -r.MagneticVariation
+	}
+	return r, err
+}
+
+func parseGSV(s NMEASentence) (NMEAMessage, error) {
+	var err error
+	g := GSV{NMEASentence: s}
+	g.Total, err = nmeaInt(s.field(0), err)
+	g.Number, err = nmeaInt(s.field(1), err)
+	g.SatellitesInView, err = nmeaInt(s.field(2), err)
+	for i := 3; i+2 < len(s.Fields) && s.field(i) != ""; i += 4 {
+		sat := GSVSatellite{SNR: -1}
+		sat.PRN, err = nmeaInt(s.field(i), err)
+		sat.Elevation, err = nmeaInt(s.field(i+1), err)
+		sat.Azimuth, err = nmeaInt(s.field(i+2), err)
+		if snr := s.field(i + 3); snr != "" {
+			sat.SNR, err = nmeaInt(snr, err)
+		}
+		g.Satellites = append(g.Satellites, sat)
+	}
+	return g, err
+}
+
+func parseZDA(s NMEASentence) (NMEAMessage, error) {
+	var err error
+	z := ZDA{NMEASentence: s}
+	tod, err := nmeaTimeOfDay(s.field(0), err)
+	day, err := nmeaInt(s.field(1), err)
+	month, err := nmeaInt(s.field(2), err)
+	year, err := nmeaInt(s.field(3), err)
+	zh, err := nmeaInt(s.field(4), err)
+	zm, err := nmeaInt(s.field(5), err)
+	if zh < 0 {
+		zm = -zm
+	}
+	z.Time = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Add(tod)
+	z.ZoneOffset = time.Duration(zh)*time.Hour + time.Duration(zm)*time.Minute
+	return z, err
+}
+
+/*
+The nmea* field helpers pass through any earlier error, so that a parser can
+decode every field and check for an error once.  Empty fields decode as zero.
+*/
+
+func nmeaFloat(f string, err error) (float64, error) {
+	if err != nil || f == "" {
+		return 0, err
+	}
+	return strconv.ParseFloat(f, 64)
+}
+
+func nmeaInt(f string, err error) (int, error) {
+	if err != nil || f == "" {
+		return 0, err
+	}
+	return strconv.Atoi(f)
+}
+
+/*nmeaTimeOfDay decodes hhmmss.ss*/
+func nmeaTimeOfDay(f string, err error) (time.Duration, error) {
+	if err != nil || f == "" {
+		return 0, err
+	}
+	if len(f) < 6 {
+		return 0, fmt.Errorf("malformed time %q", f)
+	}
+	h, err := strconv.Atoi(f[0:2])
+	m, err := nmeaInt(f[2:4], err)
+	sec, err := nmeaFloat(f[4:], err)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)), err
+}
+
+/*nmeaDegrees decodes (d)ddmm.mmmm and a hemisphere into signed decimal degrees*/
+func nmeaDegrees(f, hemi string, err error) (float64, error) {
+	if err != nil || f == "" {
+		return 0, err
+	}
+	dot := strings.IndexByte(f, '.')
+	if dot < 0 {
+		dot = len(f)
+	}
+	if dot < 3 {
+		return 0, fmt.Errorf("malformed position %q", f)
+	}
+	deg, err := strconv.Atoi(f[:dot-2])
+	min, err := nmeaFloat(f[dot-2:], err)
+	v := float64(deg) + min/60
+	if hemi == "S" || hemi == "W" {
+		v = -v
+	}
+	return v, err
+}
+
+/*
+NMEAReader continuously reads NMEA sentences from an IDoIO, decodes them with
+ParseNMEA, and hands each to a callback.  Sentences that fail to decode are
+counted and logged at debug level.  As with a DataLogger, non-temporary read
+errors cause the IDoIO to be reopened (every second) for as long as the
+NMEAReader runs, and the IDoIO remains owned by the caller.
+*/
+type NMEAReader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	idotoo  IDoIO
+	handler func(NMEAMessage)
+	done    chan struct{}
+
+	mux    sync.Mutex
+	good   uint64
+	errors uint64
+}
+
+/*
+NewNMEAReader starts reading idoio, calling handler (from a single goroutine)
+with each decoded sentence until ctx is cancelled or Close is called.
+*/
+func NewNMEAReader(ctx context.Context, idoio IDoIO, handler func(NMEAMessage)) *NMEAReader {
+	rctx, cancel := context.WithCancel(ctx)
+	r := &NMEAReader{ctx: rctx, cancel: cancel, idotoo: idoio, handler: handler, done: make(chan struct{})}
+	go r.run()
+	return r
+}
+
+/*
+NMEAChan is NewNMEAReader delivering on a channel with the given buffer size.
+The channel is closed once ctx is done.
+*/
+func NMEAChan(ctx context.Context, idoio IDoIO, size int) <-chan NMEAMessage {
+	ch := make(chan NMEAMessage, size)
+	r := NewNMEAReader(ctx, idoio, func(msg NMEAMessage) {
+		select {
+		case ch <- msg:
+		case <-ctx.Done():
+		}
+	})
+	go func() {
+		<-r.done
+		close(ch)
+	}()
+	return ch
+}
+
+/*String conforms to fmt.Stringer*/
+func (r *NMEAReader) String() string { return "NMEAReader over " + r.idotoo.String() }
+
+/*Counts returns the number of sentences decoded, and the number that failed to*/
+func (r *NMEAReader) Counts() (good, bad uint64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.good, r.errors
+}
+
+/*Close stops the NMEAReader. The underlying IDoIO is left as is.*/
+func (r *NMEAReader) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+/*run is the read loop, which exits when r.ctx is done*/
+func (r *NMEAReader) run() {
+	defer close(r.done)
+	framed := NewFramedIO(r.idotoo, ScanNMEA)
+	buf := make([]byte, nmeaMaxSentence)
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		default:
+		}
+		n, err := framed.Read(buf)
+		if n > 0 {
+			r.decode(string(buf[:n]))
+		}
+		if err == nil || IsTemporary(err) {
+			continue
+		}
+		r.reopen(err)
+	}
+}
+
+func (r *NMEAReader) decode(line string) {
+	msg, err := ParseNMEA(line)
+	r.mux.Lock()
+	if err != nil {
+		r.errors++
+	} else {
+		r.good++
+	}
+	r.mux.Unlock()
+	if err != nil {
+		LoggerFrom(r.ctx).Debug("bad NMEA sentence", "event", EventError, "dial", dialOf(r.idotoo), "error", err)
+		return
+	}
+	r.handler(msg)
+}
+
+/*reopen repeatedly reopens the IDoIO until it succeeds or r.ctx is done*/
+func (r *NMEAReader) reopen(cause error) {
+	l := LoggerFrom(r.ctx)
+	l.Warn("NMEA reader lost its connection", "event", EventError, "dial", dialOf(r.idotoo), "error", cause)
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		l.Info("NMEA reader reopening connection", "event", EventRetry, "dial", dialOf(r.idotoo))
+		if err := r.idotoo.Open(); err == nil {
+			return
+		}
+	}
+}
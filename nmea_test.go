@@ -0,0 +1,157 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	nmeaGGA = "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47"
+	nmeaRMC = "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"
+	nmeaGSV = "$GPGSV,2,1,08,01,40,083,46,02,17,308,,12,07,344,39,14,22,228,45*70"
+	nmeaZDA = "$GPZDA,201530.00,04,07,2002,00,00*60"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+func TestScanNMEA(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("junk" + nmeaGGA + "\r\n\r\n!AIVDM,1,1,,A,x,0*00\n$GPZDA,trailing"))
+	sc.Split(ScanNMEA)
+	var got []string
+	for sc.Scan() {
+		got = append(got, sc.Text())
+	}
+	if len(got) != 3 || got[0] != nmeaGGA || got[1] != "!AIVDM,1,1,,A,x,0*00" || got[2] != "$GPZDA,trailing" {
+		t.Errorf("Unexpected sentences %q", got)
+	}
+}
+
+func TestParseNMEA(t *testing.T) {
+	msg, err := ParseNMEA(nmeaGGA)
+	gga, ok := msg.(GGA)
+	if err != nil || !ok {
+		t.Fatal("Unable to parse GGA", msg, err)
+	}
+	if gga.Talker != "GP" || gga.TimeOfDay != 12*time.Hour+35*time.Minute+19*time.Second ||
+		!near(gga.Latitude, 48+7.038/60) || !near(gga.Longitude, 11+31.0/60) ||
+		gga.Quality != 1 || gga.Satellites != 8 || gga.Altitude != 545.4 || gga.GeoidSeparation != 46.9 {
+		t.Errorf("Unexpected GGA %+v", gga)
+	}
+
+	msg, err = ParseNMEA(nmeaRMC)
+	rmc, ok := msg.(RMC)
+	if err != nil || !ok {
+		t.Fatal("Unable to parse RMC", msg, err)
+	}
+	if !rmc.Valid || !rmc.Time.Equal(time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)) ||
+		!near(rmc.Speed, 22.4*1852/3600) || rmc.Course != 84.4 || rmc.MagneticVariation != -3.1 {
+		t.Errorf("Unexpected RMC %+v", rmc)
+	}
+
+	msg, err = ParseNMEA(nmeaGSV)
+	gsv, ok := msg.(GSV)
+	if err != nil || !ok || gsv.Total != 2 || gsv.Number != 1 || gsv.SatellitesInView != 8 || len(gsv.Satellites) != 4 {
+		t.Fatal("Unexpected GSV", msg, err)
+	}
+	if s := gsv.Satellites[1]; s.PRN != 2 || s.Elevation != 17 || s.Azimuth != 308 || s.SNR != -1 {
+		t.Errorf("Unexpected untracked satellite %+v", s)
+	}
+
+	msg, err = ParseNMEA(nmeaZDA)
+	if zda, ok := msg.(ZDA); err != nil || !ok || !zda.Time.Equal(time.Date(2002, 7, 4, 20, 15, 30, 0, time.UTC)) {
+		t.Error("Unexpected ZDA", msg, err)
+	}
+
+	if _, err := ParseNMEA(strings.Replace(nmeaGGA, "*47", "*48", 1)); err == nil {
+		t.Error("Expected a bad checksum to be refused")
+	}
+	if _, err := ParseNMEA("$GPGGA,12x519*" + "00"); err == nil {
+		t.Error("Expected a malformed sentence to be refused")
+	}
+	if msg, err := ParseNMEA("$GPXYZ,1,2"); err != nil || msg.Sentence().Type != "XYZ" || len(msg.Sentence().Fields) != 2 {
+		t.Error("Expected an unknown sentence to be returned raw", msg, err)
+	}
+}
+
+type pgrme struct {
+	NMEASentence
+	HPE float64
+}
+
+func TestRegisterNMEA(t *testing.T) {
+	err := RegisterNMEA("GRME", func(s NMEASentence) (NMEAMessage, error) {
+		hpe, err := nmeaFloat(s.field(0), nil)
+		return pgrme{s, hpe}, err
+	})
+	if err != nil {
+		t.Fatal("Unable to register", err)
+	}
+	if RegisterNMEA("GGA", parseGGA) == nil {
+		t.Error("Expected GGA to already be taken")
+	}
+	if msg, err := ParseNMEA("$PGRME,15.0,M,45.0,M,25.0,M"); err != nil || msg.(pgrme).HPE != 15 {
+		t.Error("Expected the proprietary parser to be used", msg, err)
+	}
+}
+
+func TestNMEAChan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, func(t *testing.T, con net.Conn) {
+		defer con.Close()
+		con.Write([]byte(nmeaGGA + "\r\n$GPGGA,bad*00\r\n" + nmeaRMC[:20]))
+		time.Sleep(50 * time.Millisecond)
+		con.Write([]byte(nmeaRMC[20:] + "\r\n"))
+		<-ctx.Done()
+	})
+	idoio, err := NewIDoIO(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer idoio.Close()
+
+	ch := NMEAChan(ctx, idoio, 4)
+	for _, want := range []string{"GGA", "RMC"} {
+		select {
+		case msg := <-ch:
+			if msg.Sentence().Type != want {
+				t.Error("Expected", want, "got", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for", want)
+		}
+	}
+	cancel()
+	for range ch {
+	}
+}
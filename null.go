@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var _ IDoIO = &NullClient{}
+
+/*
+NullClient provides an implementer of the IDoIO interface that goes nowhere,
+under the URI regime:
+
+	null://
+
+Writes are discarded (though counted, see Written), and Reads wait for the
+timeout and return a timeout error, as a device that never answers would.  It
+stands in for real hardware during bring-up, and lets Commands be executed as
+a dry run.
+*/
+type NullClient struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	dial    string
+	written uint64
+}
+
+/*
+NewNullClient returns a NullClient.  Dial should be in the form of
+"null://[<name>]", the name serving only to tell several apart.  Timeout is
+how long Read waits.
+*/
+func NewNullClient(ctx context.Context, timeout time.Duration, dial string) (*NullClient, error) {
+	if _, err := dialFor(dial, "null"); err != nil {
+		return nil, err
+	}
+	nctx, cancel := context.WithCancel(ctx)
+	return &NullClient{ctx: nctx, cancel: cancel, timeout: timeout, dial: dial}, nil
+}
+
+func (nc *NullClient) dialString() string { return nc.dial }
+
+/*String conforms to the fmt.Stringer interface*/
+func (nc *NullClient) String() string { return fmt.Sprintf("null device %v", nc.dial) }
+
+/*Written returns the number of bytes discarded so far*/
+func (nc *NullClient) Written() uint64 { return atomic.LoadUint64(&nc.written) }
+
+/*Open conforms to IDoIO, and only fails once the context is done*/
+func (nc *NullClient) Open() error {
+	select {
+	case <-nc.ctx.Done():
+		return newErr(false, false, nc.ctx.Err())
+	default:
+		return nil
+	}
+}
+
+/*Read conforms to io.Reader, waiting for the timeout and returning a timeout error*/
+func (nc *NullClient) Read(b []byte) (int, error) {
+	select {
+	case <-nc.ctx.Done():
+		return 0, newErr(false, false, nc.ctx.Err())
+	case <-time.After(nc.timeout):
+		return 0, newErr(true, true, fmt.Errorf("read: nothing from %v", nc.dial))
+	}
+}
+
+/*Write conforms to io.Writer, discarding b*/
+func (nc *NullClient) Write(b []byte) (int, error) {
+	if err := nc.Open(); err != nil {
+		return 0, err
+	}
+	atomic.AddUint64(&nc.written, uint64(len(b)))
+	return len(b), nil
+}
+
+/*Close conforms to io.Closer*/
+func (nc *NullClient) Close() error {
+	nc.cancel()
+	return nil
+}
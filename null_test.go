@@ -0,0 +1,66 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNullClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewNullClient(ctx, time.Millisecond, "nul://"); err == nil {
+		t.Error("Expected an error for a bad dial string")
+	}
+	a, err := NewArbiter(ctx, 10*time.Millisecond, "null://bench")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer a.Close()
+	cmd := arbCmdOk
+	cmd.Timeout = 20 * time.Millisecond
+	if rsp := a.Control(cmd); rsp.Error == nil || !IsTimeout(rsp.Error) {
+		t.Error("Expected commands to time out", rsp.Error)
+	}
+
+	nc, _ := NewNullClient(ctx, time.Millisecond, "null://")
+	_ = nc.String()
+	if n, err := nc.Write([]byte("ABC")); n != 3 || err != nil || nc.Written() != 3 {
+		t.Error("Expected writes to be discarded and counted", n, err, nc.Written())
+	}
+	start := time.Now()
+	if _, err := nc.Read(make([]byte, 8)); err == nil || !IsTimeout(err) || time.Since(start) < time.Millisecond {
+		t.Error("Expected reads to wait and time out", err)
+	}
+	nc.Close()
+	if _, err := nc.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail once closed")
+	}
+	if err := nc.Open(); err == nil {
+		t.Error("Expected opening to fail once closed")
+	}
+}
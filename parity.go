@@ -0,0 +1,193 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+/*Parity is the kind of parity bit carried in bit 7 of each byte by a ParityIO*/
+type Parity int
+
+const (
+	//ParityEven sets bit 7 so each byte has an even number of set bits (e.g. 7E1)
+	ParityEven Parity = 1 + iota
+
+	//ParityOdd sets bit 7 so each byte has an odd number of set bits (e.g. 7O1)
+	ParityOdd
+
+	//ParityMark always sets bit 7
+	ParityMark
+
+	//ParitySpace always clears bit 7
+	ParitySpace
+)
+
+var parityNames = map[Parity]string{ParityEven: "even", ParityOdd: "odd", ParityMark: "mark", ParitySpace: "space"}
+
+/*String conforms to fmt.Stringer*/
+func (p Parity) String() string {
+	if name, ok := parityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Parity(%d)", int(p))
+}
+
+/*ParseParity returns the Parity named by s (even, odd, mark or space), ignoring case*/
+func ParseParity(s string) (Parity, error) {
+	for p, name := range parityNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return 0, newErr(false, false, fmt.Errorf("unknown parity %q", s))
+}
+
+/*bit returns bit 7 of the 7 bit value c under p*/
+func (p Parity) bit(c byte) byte {
+	switch p {
+	case ParityEven:
+		return byte(bits.OnesCount8(c)&1) << 7
+	case ParityOdd:
+		return byte(^bits.OnesCount8(c)&1) << 7
+	case ParityMark:
+		return 0x80
+	}
+	return 0
+}
+
+/*
+ErrParity is returned, along with the data, by a ParityIO Read that received
+bytes with bad parity.  It is Temporary but not a Timeout, so Arbiters keep
+reading through it.
+*/
+var ErrParity = newErr(true, false, errors.New("parity error"))
+
+/*ParityStats counts the bytes checked by a ParityIO*/
+type ParityStats struct {
+	Bytes  uint64 //bytes read
+	Errors uint64 //bytes read with bad parity
+}
+
+var _ IDoIO = &ParityIO{}
+
+/*
+ParityIO emulates 7 bit serial framing with parity (7E1, 7O1, ...) over a
+transport that only carries 8N1, such as a TCP serial server in raw mode or a
+USB adapter with broken parity support.  The parity bit is computed and placed
+in bit 7 of each byte written, and checked and stripped from each byte read.
+
+Bytes read with bad parity are still delivered (stripped), but are counted
+(see Stats) and cause the Read to return ErrParity.
+*/
+type ParityIO struct {
+	IDoIO
+	parity Parity
+	mux    sync.Mutex
+	stats  ParityStats
+}
+
+/*NewParityIO returns a ParityIO applying p to idoio*/
+func NewParityIO(idoio IDoIO, p Parity) *ParityIO {
+	return &ParityIO{IDoIO: idoio, parity: p}
+}
+
+/*WithParity emulates parity p, described as e.g. "parity(mode=even)"*/
+func WithParity(p Parity) Layer {
+	return NewLayer("parity", RankTransform, map[string]string{"mode": p.String()}, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewParityIO(inner, p), nil
+	})
+}
+
+func parseParityLayer(params map[string]string) (Layer, error) {
+	p, err := ParseParity(params["mode"])
+	if err != nil {
+		return Layer{}, err
+	}
+	return WithParity(p), nil
+}
+
+func (pio *ParityIO) dialString() string { return dialOf(pio.IDoIO) }
+
+/*String conforms to fmt.Stringer*/
+func (pio *ParityIO) String() string {
+	return fmt.Sprintf("%v parity over %v", pio.parity, pio.IDoIO)
+}
+
+/*Stats returns the counts of bytes read, and parity errors, so far*/
+func (pio *ParityIO) Stats() ParityStats {
+	pio.mux.Lock()
+	defer pio.mux.Unlock()
+	return pio.stats
+}
+
+/*
+Write conforms to io.Writer.  b must be 7 bit data; if any byte has bit 7 set,
+nothing is written and an error is returned.
+*/
+func (pio *ParityIO) Write(b []byte) (int, error) {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c&0x80 != 0 {
+			return 0, newErr(false, false, fmt.Errorf("byte %d (%#02x) does not fit in 7 bits", i, c))
+		}
+		out[i] = c | pio.parity.bit(c)
+	}
+	for len(out) > 0 {
+		n, err := pio.IDoIO.Write(out)
+		if err != nil {
+			return len(b) - len(out) + n, err
+		}
+		out = out[n:]
+	}
+	return len(b), nil
+}
+
+/*Read conforms to io.Reader, checking and stripping the parity bit of each byte*/
+func (pio *ParityIO) Read(b []byte) (int, error) {
+	n, err := pio.IDoIO.Read(b)
+	bad := 0
+	for i, c := range b[:n] {
+		data := c & 0x7f
+		if c&0x80 != pio.parity.bit(data) {
+			bad++
+		}
+		b[i] = data
+	}
+	pio.mux.Lock()
+	pio.stats.Bytes += uint64(n)
+	pio.stats.Errors += uint64(bad)
+	pio.mux.Unlock()
+	if bad > 0 && err == nil {
+		err = ErrParity
+	}
+	return n, err
+}
@@ -0,0 +1,77 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParityIO(t *testing.T) {
+	for _, tc := range []struct {
+		parity Parity
+		wire   []byte //"A0" on the wire
+	}{
+		{ParityEven, []byte{0x41, 0x30}},
+		{ParityOdd, []byte{0xc1, 0xb0}},
+		{ParityMark, []byte{0xc1, 0xb0}},
+		{ParitySpace, []byte{0x41, 0x30}},
+	} {
+		inner := &bufIO{}
+		pio := NewParityIO(inner, tc.parity)
+		if n, err := pio.Write([]byte("A0")); n != 2 || err != nil || !bytes.Equal(inner.tx.Bytes(), tc.wire) {
+			t.Errorf("%v: expected % x, got % x %v", tc.parity, tc.wire, inner.tx.Bytes(), err)
+		}
+		inner.rx.Write(tc.wire)
+		b := make([]byte, 8)
+		if n, err := pio.Read(b); err != nil || string(b[:n]) != "A0" {
+			t.Errorf("%v: expected clean data, got %q %v", tc.parity, b[:n], err)
+		}
+		if p, err := ParseParity(tc.parity.String()); err != nil || p != tc.parity {
+			t.Error("Expected the parity name to round trip", tc.parity, err)
+		}
+	}
+
+	inner := &bufIO{}
+	pio := NewParityIO(inner, ParityEven)
+	if n, err := pio.Write([]byte{0x41, 0x80}); n != 0 || err == nil || inner.tx.Len() != 0 {
+		t.Error("Expected 8 bit data to be refused", n, err)
+	}
+	inner.rx.Write([]byte{0xc1, 0x30, 0xb1})
+	b := make([]byte, 8)
+	n, err := pio.Read(b)
+	if string(b[:n]) != "A01" || err != ErrParity || !IsTemporary(err) || IsTimeout(err) {
+		t.Errorf("Expected stripped data and a parity error, got %q %v", b[:n], err)
+	}
+	if s := pio.Stats(); s.Bytes != 3 || s.Errors != 1 {
+		t.Error("Unexpected stats", s)
+	}
+	if _, err := ParseParity("none"); err == nil {
+		t.Error("Expected an unknown parity to be rejected")
+	}
+	if s, err := ParseStack("tcp://localhost:1 | parity(mode=odd)"); err != nil || s.String() != "tcp://localhost:1 | parity(mode=odd)" {
+		t.Error("Expected the parity layer to round trip", s, err)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*parseProxy parses the proxy parameter of a network dial string*/
+func parseProxy(network, raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme != "socks5" && proxy.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported proxy %q", raw)
+	}
+	if network[:3] != "tcp" {
+		return nil, fmt.Errorf("%s cannot be proxied", network)
+	}
+	return proxy, nil
+}
+
+/*
+dialProxy connects to address via proxy.  Errors connecting to the proxy are
+returned as is; failures of the handshake are neither temporary nor timeouts.
+*/
+func dialProxy(ctx context.Context, dialer *net.Dialer, proxy *url.URL, network, address string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+	if proxy.Scheme == "socks5" {
+		err = socks5Connect(conn, proxy.User, address)
+	} else {
+		conn, err = httpConnect(conn, proxy.User, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, newErr(false, false, errors.Wrapf(err, "proxy %v", proxy.Host))
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+/*socks5Connect asks a SOCKS5 (RFC 1928) proxy on conn to connect to address*/
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	greeting := []byte{5, 1, 0} //no authentication
+	if user != nil {
+		greeting = []byte{5, 2, 0, 2} //or username/password (RFC 1929)
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 5:
+		return fmt.Errorf("not a SOCKS5 proxy")
+	case reply[1] == 2 && user != nil:
+		pass, _ := user.Password()
+		auth := append([]byte{1, byte(len(user.Username()))}, user.Username()...)
+		auth = append(append(auth, byte(len(pass))), pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("authentication failed")
+		}
+	case reply[1] != 0:
+		return fmt.Errorf("no acceptable authentication method")
+	}
+
+	req := []byte{5, 1, 0} //CONNECT
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		req = append(append(req, 1), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else {
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(req, uint16(port))); err != nil {
+		return err
+	}
+	hdr := make([]byte, 5) //up to the first byte of the bound address
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return err
+	}
+	if hdr[1] != 0 {
+		return fmt.Errorf("connect failed with code %d", hdr[1])
+	}
+	rest := map[byte]int{1: 4 - 1 + 2, 3: int(hdr[4]) + 2, 4: 16 - 1 + 2}[hdr[3]]
+	if rest == 0 {
+		return fmt.Errorf("malformed reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, rest))
+	return err
+}
+
+/*bufferedConn is a net.Conn whose reads start with data already buffered*/
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (bc *bufferedConn) Read(b []byte) (int, error) { return bc.r.Read(b) }
+
+/*httpConnect asks an HTTP proxy on conn to tunnel to address with the CONNECT method*/
+func httpConnect(conn net.Conn, user *url.Userinfo, address string) (net.Conn, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if user != nil {
+		pass, _ := user.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return conn, err
+	}
+	br := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return conn, err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("connect failed: %s", rsp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: io.MultiReader(io.LimitReader(br, int64(br.Buffered())), conn)}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,137 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*splice copies between a and b until either closes*/
+func splice(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	go io.Copy(a, b)
+	io.Copy(b, a)
+}
+
+/*socks5Proxy is a SOCKS5 proxy accepting user "u" with password "p", or no authentication*/
+func socks5Proxy(t *testing.T, con net.Conn) {
+	defer con.Close()
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(con, hdr); err != nil {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	io.ReadFull(con, methods)
+	if methods[len(methods)-1] == 2 {
+		con.Write([]byte{5, 2})
+		io.ReadFull(con, hdr)
+		user := make([]byte, hdr[1]+1)
+		io.ReadFull(con, user)
+		pass := make([]byte, user[len(user)-1])
+		io.ReadFull(con, pass)
+		if string(user[:len(user)-1]) != "u" || string(pass) != "p" {
+			con.Write([]byte{1, 1})
+			return
+		}
+		con.Write([]byte{1, 0})
+	} else {
+		con.Write([]byte{5, 0})
+	}
+	req := make([]byte, 5)
+	io.ReadFull(con, req)
+	host := make([]byte, req[4])
+	io.ReadFull(con, host)
+	port := make([]byte, 2)
+	io.ReadFull(con, port)
+	target, err := net.Dial("tcp", net.JoinHostPort(string(host), strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		con.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	con.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	splice(con, target)
+}
+
+/*httpProxy is an HTTP CONNECT proxy refusing tunnels to port 1*/
+func httpProxy(t *testing.T, con net.Conn) {
+	defer con.Close()
+	req, err := http.ReadRequest(bufio.NewReader(con))
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		fmt.Fprint(con, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	fmt.Fprint(con, "HTTP/1.1 200 Connection established\r\n\r\n")
+	splice(con, target)
+}
+
+func TestNetClient_Proxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"tcp://localhost:1?proxy=ftp://proxy:21", "udp://localhost:1?proxy=socks5://proxy:1080", "tcp://localhost:1?via=x"} {
+		if _, err := NewNetClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+
+	_, srvdial, _ := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	_, socksdial, _ := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", socksdial, socks5Proxy)
+	_, httpdial, _ := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", httpdial, httpProxy)
+
+	for _, proxy := range []string{"socks5://" + socksdial, "socks5://u:p@" + socksdial, "http://" + httpdial, "http://u:p@" + httpdial} {
+		a, err := NewArbiter(ctx, time.Second, "tcp://"+srvdial+"?proxy="+proxy)
+		if err != nil {
+			t.Error("Unable to dial via", proxy, err)
+			continue
+		}
+		if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+			t.Error("Expected a response via", proxy, rsp.Error)
+		}
+		a.Close()
+	}
+
+	for _, proxy := range []string{"socks5://u:wrong@" + socksdial, "socks5://" + socksdial, "http://" + httpdial} {
+		_, err := NewNetClient(ctx, time.Second, "tcp://localhost:1?proxy="+proxy)
+		if err == nil || IsTemporary(err) || IsTimeout(err) {
+			t.Error("Expected a permanent error from", proxy, err)
+		}
+	}
+}
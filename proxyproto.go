@@ -0,0 +1,193 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+This file speaks the HAProxy PROXY protocol, versions 1 (text) and 2 (binary),
+which prefixes a tcp stream with the addresses of the connection it is being
+relayed for.  See https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+*/
+
+/*proxyV2Sig starts every version 2 header*/
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+/*parseProxyProto parses the version of header a NetClient is to send*/
+func parseProxyProto(network, v string) (int, error) {
+	if network[:3] != "tcp" {
+		return 0, fmt.Errorf("%s cannot send PROXY headers", network)
+	}
+	switch v {
+	case "1", "v1":
+		return 1, nil
+	case "2", "v2":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("unknown PROXY protocol version %q", v)
+}
+
+/*
+writeProxyHeader writes a PROXY protocol header of the given version to w,
+relaying a tcp connection from src to dst
+*/
+func writeProxyHeader(w io.Writer, version int, src, dst net.Addr) error {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	four := sok && dok && s.IP.To4() != nil && d.IP.To4() != nil
+	var hdr []byte
+	switch {
+	case version == 1 && (!sok || !dok):
+		hdr = []byte("PROXY UNKNOWN\r\n")
+	case version == 1:
+		fam := "TCP6"
+		if four {
+			fam = "TCP4"
+		}
+		hdr = []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", fam, s.IP, d.IP, s.Port, d.Port))
+	case !sok || !dok:
+		hdr = append(append(hdr, proxyV2Sig...), 0x20, 0x00, 0, 0) //LOCAL
+	default:
+		fam, sip, dip := byte(0x21), s.IP.To16(), d.IP.To16()
+		if four {
+			fam, sip, dip = 0x11, s.IP.To4(), d.IP.To4()
+		}
+		hdr = append(append(hdr, proxyV2Sig...), 0x21, fam)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(2*len(sip)+4))
+		hdr = append(append(hdr, sip...), dip...)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(s.Port))
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(d.Port))
+	}
+	_, err := w.Write(hdr)
+	return err
+}
+
+/*
+readProxyHeader reads a PROXY protocol header of either version from r,
+returning the addresses relayed.  They are nil for a connection the proxy
+made on its own behalf (LOCAL or UNKNOWN), or of a family other than tcp.
+*/
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first[0] == 'P' {
+		return readProxyV1(r)
+	}
+	return readProxyV2(r)
+}
+
+func readProxyV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < 107 && !bytes.HasSuffix(line, []byte("\r\n")) { //107 bytes at most, by the spec
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+	f := strings.Fields(string(line))
+	switch {
+	case len(f) >= 2 && f[0] == "PROXY" && f[1] == "UNKNOWN":
+		return nil, nil, nil
+	case len(f) != 6 || f[0] != "PROXY" || (f[1] != "TCP4" && f[1] != "TCP6"):
+		return nil, nil, fmt.Errorf("malformed PROXY header %q", line)
+	}
+	sport, serr := strconv.ParseUint(f[4], 10, 16)
+	dport, derr := strconv.ParseUint(f[5], 10, 16)
+	sip, dip := net.ParseIP(f[2]), net.ParseIP(f[3])
+	if serr != nil || derr != nil || sip == nil || dip == nil {
+		return nil, nil, fmt.Errorf("malformed PROXY header %q", line)
+	}
+	return &net.TCPAddr{IP: sip, Port: int(sport)}, &net.TCPAddr{IP: dip, Port: int(dport)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	hdr := make([]byte, 16)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Sig) || hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("no PROXY header")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]&0x0f == 0 { //LOCAL
+		return nil, nil, nil
+	}
+	var n int
+	switch hdr[13] {
+	case 0x11: //TCP over IPv4
+		n = net.IPv4len
+	case 0x21: //TCP over IPv6
+		n = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*n+4 {
+		return nil, nil, fmt.Errorf("short PROXY header")
+	}
+	ports := body[2*n:]
+	src = &net.TCPAddr{IP: net.IP(body[:n]), Port: int(binary.BigEndian.Uint16(ports))}
+	dst = &net.TCPAddr{IP: net.IP(body[n : 2*n]), Port: int(binary.BigEndian.Uint16(ports[2:]))}
+	return src, dst, nil
+}
+
+/*proxiedConn is a connection whose PROXY header has been read, reporting the addresses it gave*/
+type proxiedConn struct {
+	net.Conn
+	r        *bufio.Reader //holds anything read past the header
+	src, dst net.Addr      //nil if the header gave none
+}
+
+func (pc *proxiedConn) Read(b []byte) (int, error) { return pc.r.Read(b) }
+
+/*RemoteAddr returns the source the PROXY header gave, if any*/
+func (pc *proxiedConn) RemoteAddr() net.Addr {
+	if pc.src != nil {
+		return pc.src
+	}
+	return pc.Conn.RemoteAddr()
+}
+
+/*LocalAddr returns the destination the PROXY header gave, if any*/
+func (pc *proxiedConn) LocalAddr() net.Addr {
+	if pc.dst != nil {
+		return pc.dst
+	}
+	return pc.Conn.LocalAddr()
+}
@@ -0,0 +1,128 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyHeader(t *testing.T) {
+	v4s, v4d := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}, &net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 80}
+	v6s, v6d := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4000}, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}
+	for _, tc := range []struct {
+		version  int
+		src, dst net.Addr
+	}{
+		{1, v4s, v4d}, {1, v6s, v6d}, {2, v4s, v4d}, {2, v6s, v6d},
+		{1, &net.UDPAddr{}, v4d}, {2, &net.UDPAddr{}, v4d},
+	} {
+		var buf bytes.Buffer
+		if err := writeProxyHeader(&buf, tc.version, tc.src, tc.dst); err != nil {
+			t.Fatal("Unable to write header", err)
+		}
+		if tc.version == 1 && !strings.HasPrefix(buf.String(), "PROXY ") {
+			t.Errorf("Expected a text header, got %q", buf.String())
+		}
+		buf.WriteString("payload")
+		r := bufio.NewReader(&buf)
+		src, dst, err := readProxyHeader(r)
+		if err != nil {
+			t.Errorf("Unable to read v%d header %v->%v: %v", tc.version, tc.src, tc.dst, err)
+			continue
+		}
+		if _, ok := tc.src.(*net.TCPAddr); !ok {
+			if src != nil || dst != nil {
+				t.Error("Expected no addresses for a non-tcp connection, got", src, dst)
+			}
+		} else if src.String() != tc.src.String() || dst.String() != tc.dst.String() {
+			t.Errorf("Expected %v->%v, got %v->%v", tc.src, tc.dst, src, dst)
+		}
+		if rest, _ := r.ReadString(0); rest != "payload" {
+			t.Errorf("Expected the payload to follow the header, got %q", rest)
+		}
+	}
+	for _, bad := range []string{"PROXY TCP4 1.2.3.4\r\n", "PROXY TCP4 a b 1 2\r\n", "GET / HTTP/1.1\r\n\r\n\r\n", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x02ab"} {
+		if _, _, err := readProxyHeader(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if _, err := parseProxyProto("udp", "1"); err == nil {
+		t.Error("Expected udp not to send PROXY headers")
+	}
+	if _, err := parseProxyProto("tcp", "3"); err == nil {
+		t.Error("Expected an unknown version to be rejected")
+	}
+}
+
+func TestListenClient_ProxyProto(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewListenClient(ctx, time.Second, "tcp-listen://localhost:0?bogus=1"); err == nil {
+		t.Error("Expected an unknown parameter to be rejected")
+	}
+	lc, err := NewListenClient(ctx, time.Second, "tcp-listen://127.0.0.1:0?proxyproto=true")
+	if err != nil {
+		t.Fatal("Unable to listen", err)
+	}
+	defer lc.Close()
+
+	for _, v := range []string{"1", "2"} {
+		nc, err := NewNetClient(ctx, time.Second, "tcp://"+lc.Addr()+"?proxyproto="+v)
+		if err != nil {
+			t.Fatal("Unable to dial", err)
+		}
+		for i := 0; i < 100 && (!lc.Connected() || lc.Peer().String() != nc.conn.LocalAddr().String()); i++ {
+			<-time.After(time.Millisecond)
+		}
+		if p := lc.Peer(); p == nil || p.String() != nc.conn.LocalAddr().String() {
+			t.Errorf("Expected the peer %v from the v%s header, got %v", nc.conn.LocalAddr(), v, p)
+		}
+		nc.Write([]byte("hello"))
+		<-time.After(10 * time.Millisecond)
+		buf := make([]byte, 16)
+		if n, err := lc.Read(buf); err != nil || string(buf[:n]) != "hello" {
+			t.Errorf("Expected only the payload to be read, got %q %v", buf[:n], err)
+		}
+		nc.Close()
+	}
+
+	//a client without a header is dropped
+	con, err := net.Dial("tcp", lc.Addr())
+	if err != nil {
+		t.Fatal("Unable to connect", err)
+	}
+	defer con.Close()
+	con.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	con.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := con.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected a client without a PROXY header to be dropped")
+	}
+}
@@ -0,0 +1,243 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+/*
+Priority orders the commands waiting for an Arbiter: the highest goes first.
+Any value may be used; the named ones are for convenience.
+*/
+type Priority int
+
+const (
+	//PriorityLow commands wait for everything else
+	PriorityLow Priority = -1
+	//PriorityRoutine is the priority of commands that do not give one
+	PriorityRoutine Priority = 0
+	//PriorityHigh commands go ahead of routine ones
+	PriorityHigh Priority = 1
+	//PriorityUrgent is the priority of Urgent commands (see Command.Urgent)
+	PriorityUrgent Priority = 2
+)
+
+type callerKey struct{}
+
+/*
+WithCaller returns a copy of ctx naming the caller of the commands it is given
+to (see ContextArbiter).  Commands of the same priority from different named
+callers take turns, so that one caller with a backlog cannot hold up the
+others; commands from the same caller, or from unnamed callers, are served in
+the order they were given.
+*/
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+/*callerFrom returns the caller named by ctx, or "" for unnamed callers*/
+func callerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+/*QueueStatus is a snapshot of the commands waiting for an Arbiter*/
+type QueueStatus struct {
+	Busy       bool             //an exchange is in progress
+	Depth      int              //commands waiting, not counting the one in progress
+	ByPriority map[Priority]int //commands waiting at each priority
+	ByCaller   map[string]int   //commands waiting from each caller, "" for unnamed ones
+}
+
+/*
+QueueReporter is implemented by Arbiters that can report the commands
+waiting for them.  Discover it with a type assertion.
+*/
+type QueueReporter interface {
+	Queue() QueueStatus
+}
+
+/*
+laneMutex is a mutual exclusion lock with a lane of waiters for each
+priority, used to let urgent commands (an abort or emergency stop, say) jump a
+backlog of routine ones.  Its ordering guarantees are:
+
+  - whoever holds the lock is never interrupted: an urgent caller waits for the
+    exchange in progress to finish
+  - on Unlock, the lock is handed to a waiter in the highest priority lane
+  - within each lane, callers (see WithCaller) take turns, and each caller's
+    waiters are served in the order they called Lock
+
+Lower priority callers can therefore starve while higher priority ones keep
+arriving.
+*/
+type laneMutex struct {
+	mu    sync.Mutex
+	held  bool
+	lanes []*lane //by descending priority, none empty
+}
+
+/*lane holds the waiters of one priority*/
+type lane struct {
+	priority Priority
+	callers  []string //callers with waiters, in the order they take turns
+	waiting  map[string][]chan struct{}
+}
+
+/*Lock waits in the routine lane*/
+func (l *laneMutex) Lock() { l.lockContext(context.Background(), PriorityRoutine) }
+
+/*LockUrgent waits in the urgent lane*/
+func (l *laneMutex) LockUrgent() { l.lockContext(context.Background(), PriorityUrgent) }
+
+/*
+lockContext waits in the lane for p, as the caller named by ctx, until it
+holds the lock, or until ctx is done, in which case it gives up its place and
+returns ctx.Err()
+*/
+func (l *laneMutex) lockContext(ctx context.Context, p Priority) error {
+	l.mu.Lock()
+	if !l.held {
+		l.held = true
+		l.mu.Unlock()
+		return nil
+	}
+	turn, caller := make(chan struct{}), callerFrom(ctx)
+	ln := l.lane(p)
+	if len(ln.waiting[caller]) == 0 {
+		ln.callers = append(ln.callers, caller)
+	}
+	ln.waiting[caller] = append(ln.waiting[caller], turn)
+	l.mu.Unlock()
+	select {
+	case <-turn: //the lock is handed over still held
+		return nil
+	case <-ctx.Done():
+	}
+	l.mu.Lock()
+	waiting := l.drop(p, caller, turn)
+	l.mu.Unlock()
+	if !waiting { //handed over as ctx was done: pass it on
+		l.Unlock()
+	}
+	return ctx.Err()
+}
+
+/*lane returns the lane for p, adding it if need be. The caller must hold l.mu.*/
+func (l *laneMutex) lane(p Priority) *lane {
+	i := sort.Search(len(l.lanes), func(i int) bool { return l.lanes[i].priority <= p })
+	if i < len(l.lanes) && l.lanes[i].priority == p {
+		return l.lanes[i]
+	}
+	ln := &lane{priority: p, waiting: map[string][]chan struct{}{}}
+	l.lanes = append(l.lanes[:i], append([]*lane{ln}, l.lanes[i:]...)...)
+	return ln
+}
+
+/*
+drop removes turn from the lane for p, returning false if it was not waiting
+there (because it has been handed the lock). The caller must hold l.mu.
+*/
+func (l *laneMutex) drop(p Priority, caller string, turn chan struct{}) bool {
+	for i, ln := range l.lanes {
+		if ln.priority != p {
+			continue
+		}
+		for j, t := range ln.waiting[caller] {
+			if t == turn {
+				ln.waiting[caller] = append(ln.waiting[caller][:j:j], ln.waiting[caller][j+1:]...)
+				l.tidy(i, caller, false)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+tidy takes caller out of its turn in lane i once it has nothing waiting, or
+otherwise, if it has just been served, moves it to the back, and removes the
+lane once empty.  The caller must hold l.mu.
+*/
+func (l *laneMutex) tidy(i int, caller string, served bool) {
+	ln := l.lanes[i]
+	more := len(ln.waiting[caller]) > 0
+	if more && !served { //keeps its place, having not been served
+		return
+	}
+	for j, c := range ln.callers {
+		if c != caller {
+			continue
+		}
+		ln.callers = append(ln.callers[:j:j], ln.callers[j+1:]...)
+		if more {
+			ln.callers = append(ln.callers, caller)
+		}
+		break
+	}
+	if !more {
+		delete(ln.waiting, caller)
+	}
+	if len(ln.callers) == 0 {
+		l.lanes = append(l.lanes[:i:i], l.lanes[i+1:]...)
+	}
+}
+
+/*Unlock hands the lock to the next waiter, if there is one*/
+func (l *laneMutex) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		panic("agnoio: unlock of unlocked laneMutex")
+	}
+	if len(l.lanes) == 0 {
+		l.held = false
+		return
+	}
+	ln := l.lanes[0]
+	caller := ln.callers[0]
+	next := ln.waiting[caller][0]
+	ln.waiting[caller] = ln.waiting[caller][1:]
+	l.tidy(0, caller, true)
+	close(next)
+}
+
+/*status reports the waiters for the lock*/
+func (l *laneMutex) status() QueueStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	qs := QueueStatus{Busy: l.held, ByPriority: map[Priority]int{}, ByCaller: map[string]int{}}
+	for _, ln := range l.lanes {
+		for caller, w := range ln.waiting {
+			qs.Depth += len(w)
+			qs.ByPriority[ln.priority] += len(w)
+			qs.ByCaller[caller] += len(w)
+		}
+	}
+	return qs
+}
@@ -0,0 +1,150 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*queueUp has each of names wait on l, in order, at the priority and as the caller given by its first two letters*/
+func queueUp(l *laneMutex, names []string, order chan<- string) {
+	prios := map[byte]Priority{'l': PriorityLow, 'r': PriorityRoutine, 'h': PriorityHigh, 'u': PriorityUrgent}
+	for i, name := range names {
+		go func(name string) {
+			l.lockContext(WithCaller(context.Background(), name[1:2]), prios[name[0]])
+			order <- name
+			l.Unlock()
+		}(name)
+		for l.status().Depth != i+1 {
+			<-time.After(time.Millisecond)
+		}
+	}
+}
+
+func TestLaneMutex_Priority(t *testing.T) {
+	var l laneMutex
+	l.Lock()
+	names := []string{"la1", "ra1", "ha1", "ua1", "ra2", "ha2", "la2"}
+	order := make(chan string, len(names))
+	queueUp(&l, names, order)
+
+	qs := l.status()
+	if !qs.Busy || qs.Depth != 7 || qs.ByPriority[PriorityLow] != 2 || qs.ByPriority[PriorityUrgent] != 1 || qs.ByCaller["a"] != 7 {
+		t.Errorf("Unexpected queue status %+v", qs)
+	}
+	l.Unlock()
+	got := make([]string, 0, len(names))
+	for range names {
+		got = append(got, <-order)
+	}
+	if s := strings.Join(got, " "); s != "ua1 ha1 ha2 ra1 ra2 la1 la2" {
+		t.Error("Expected waiters by priority, each in order, got", s)
+	}
+	if qs := l.status(); qs.Busy || qs.Depth != 0 || len(l.lanes) != 0 {
+		t.Errorf("Expected an idle, empty queue, got %+v", qs)
+	}
+}
+
+func TestLaneMutex_Fairness(t *testing.T) {
+	var l laneMutex
+	l.Lock()
+	//a has a backlog before b and c arrive
+	names := []string{"ra1", "ra2", "ra3", "rb1", "rb2", "rc1", "ha1"}
+	order := make(chan string, len(names))
+	queueUp(&l, names, order)
+	l.Unlock()
+	got := make([]string, 0, len(names))
+	for range names {
+		got = append(got, <-order)
+	}
+	if s := strings.Join(got, " "); s != "ha1 ra1 rb1 rc1 ra2 rb2 ra3" {
+		t.Error("Expected callers to take turns, got", s)
+	}
+}
+
+func TestLaneMutex_FairnessCancelled(t *testing.T) {
+	var l laneMutex
+	l.Lock()
+	//a's first waiter gives up while a is at the head of the lane
+	ctx, cancel := context.WithCancel(WithCaller(context.Background(), "a"))
+	gaveUp := make(chan error)
+	go func() { gaveUp <- l.lockContext(ctx, PriorityRoutine) }()
+	for l.status().Depth != 1 {
+		<-time.After(time.Millisecond)
+	}
+	names := []string{"ra1", "rb1", "ra2"}
+	order := make(chan string, len(names))
+	for i, name := range names {
+		go func(name string) {
+			l.lockContext(WithCaller(context.Background(), name[1:2]), PriorityRoutine)
+			order <- name
+			l.Unlock()
+		}(name)
+		for l.status().Depth != i+2 {
+			<-time.After(time.Millisecond)
+		}
+	}
+	cancel()
+	if err := <-gaveUp; err != context.Canceled {
+		t.Error("Expected the cancelled waiter to give up", err)
+	}
+	l.Unlock()
+	got := make([]string, 0, len(names))
+	for range names {
+		got = append(got, <-order)
+	}
+	if s := strings.Join(got, " "); s != "ra1 rb1 ra2" {
+		t.Error("Expected a to keep its turn, having not been served, got", s)
+	}
+}
+
+func TestArb_Queue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, stop := Arbitrate(ctx, &bufIO{})
+	defer stop()
+	slow := Command{Name: "slow", Timeout: 50 * time.Millisecond, Response: arbCmdOk.Response}
+	go a.Control(slow)
+	time.Sleep(10 * time.Millisecond)
+
+	qr := a.(QueueReporter)
+	done := make(chan Priority, 2)
+	for _, p := range []Priority{PriorityLow, PriorityHigh} {
+		cmd := slow
+		cmd.Timeout, cmd.Priority = time.Millisecond, p
+		go func() { a.(ContextArbiter).ControlContext(WithCaller(ctx, "x"), cmd); done <- cmd.Priority }()
+		time.Sleep(5 * time.Millisecond)
+	}
+	if qs := qr.Queue(); !qs.Busy || qs.Depth != 2 || qs.ByCaller["x"] != 2 || qs.ByPriority[PriorityHigh] != 1 {
+		t.Errorf("Unexpected queue status %+v", qs)
+	}
+	if first := <-done; first != PriorityHigh {
+		t.Error("Expected the high priority command first, got", first)
+	}
+	<-done
+}
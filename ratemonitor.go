@@ -0,0 +1,223 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+/*RateMonitorConfig controls how a RateMonitor measures and judges data rates*/
+type RateMonitorConfig struct {
+	//Interval is how often rates are sampled. Defaults to 1s.
+	Interval time.Duration
+
+	/*Smoothing is the time constant of the exponentially weighted moving
+	  average applied to the sampled rates. Defaults to 10s.*/
+	Smoothing time.Duration
+
+	/*Delimiter marks the end of a message when counting messages.  If nil,
+	  every Read returning data counts as one message.*/
+	Delimiter []byte
+
+	/*Floor is the data rate, in bytes per second, below which the connection is
+	  considered to be stalling. A zero Floor means only intervals without any
+	  data at all count as being below the floor.*/
+	Floor float64
+
+	/*StallAfter is how long the rate must stay below Floor before OnStall is
+	  called. Zero disables stall detection.*/
+	StallAfter time.Duration
+
+	//OnStall, if not nil, is called once each time the connection stalls
+	OnStall func(RateStats)
+
+	//OnRecover, if not nil, is called once each time a stalled connection rises back above the floor
+	OnRecover func(RateStats)
+}
+
+/*RateStats is a snapshot of a RateMonitor's measurements*/
+type RateStats struct {
+	BytesPerSec    float64   //smoothed received bytes per second
+	MessagesPerSec float64   //smoothed received messages per second
+	TotalBytes     uint64    //bytes received since the RateMonitor was created
+	TotalMessages  uint64    //messages received since the RateMonitor was created
+	LastData       time.Time //when data was last received, zero if never
+	Stalled        bool      //true if the rate has been below the floor for at least StallAfter
+	BelowSince     time.Time //when the rate fell below the floor, zero if it is above
+}
+
+/*String conforms to fmt.Stringer*/
+func (rs RateStats) String() string {
+	return fmt.Sprintf("%.1f B/s %.2f msg/s (%d bytes, %d msgs) stalled:%v", rs.BytesPerSec, rs.MessagesPerSec, rs.TotalBytes, rs.TotalMessages, rs.Stalled)
+}
+
+var _ IDoIO = &RateMonitor{}
+var _ ContextReader = &RateMonitor{}
+
+/*
+RateMonitor wraps an IDoIO and measures the rate at which data is read through
+it, raising an event when the rate falls, and stays, below a configured floor.
+This catches the "still connected but silently stopped sending" failure that
+error returns alone never reveal.
+
+Sampling runs in the background until the context passed to NewRateMonitor is
+done.  Data read by other means can be accounted for via Observe.
+*/
+type RateMonitor struct {
+	idotoo IDoIO
+	cfg    RateMonitorConfig
+	ctx    context.Context
+
+	mux    sync.Mutex
+	stats  RateStats
+	bytes  uint64 //counts since the last sample
+	msgs   uint64
+	primed bool //false until the first sample has been taken
+}
+
+/*NewRateMonitor returns a RateMonitor over idoio that samples until ctx is done*/
+func NewRateMonitor(ctx context.Context, idoio IDoIO, cfg RateMonitorConfig) *RateMonitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 1 * time.Second
+	}
+	if cfg.Smoothing <= 0 {
+		cfg.Smoothing = 10 * time.Second
+	}
+	rm := &RateMonitor{idotoo: idoio, cfg: cfg, ctx: ctx}
+	go rm.run()
+	return rm
+}
+
+/*String conforms to fmt.Stringer*/
+func (rm *RateMonitor) String() string {
+	return fmt.Sprintf("RateMonitor over %v", rm.idotoo)
+}
+
+func (rm *RateMonitor) dialString() string { return dialOf(rm.idotoo) }
+
+/*Open conforms to IDoIO*/
+func (rm *RateMonitor) Open() error { return rm.idotoo.Open() }
+
+/*Close conforms to io.Closer.  Sampling continues until the RateMonitor's context is done.*/
+func (rm *RateMonitor) Close() error { return rm.idotoo.Close() }
+
+/*Write conforms to io.Writer.  Written data is not measured.*/
+func (rm *RateMonitor) Write(b []byte) (int, error) { return rm.idotoo.Write(b) }
+
+/*Read conforms to io.Reader, accounting for everything read*/
+func (rm *RateMonitor) Read(b []byte) (int, error) {
+	n, err := rm.idotoo.Read(b)
+	rm.Observe(b[:n])
+	return n, err
+}
+
+/*ReadContext conforms to ContextReader, accounting for everything read*/
+func (rm *RateMonitor) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, rm.idotoo, b)
+	rm.Observe(b[:n])
+	return n, err
+}
+
+/*Observe accounts for b as having been received*/
+func (rm *RateMonitor) Observe(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	rm.mux.Lock()
+	defer rm.mux.Unlock()
+	rm.bytes += uint64(len(b))
+	rm.stats.TotalBytes += uint64(len(b))
+	rm.stats.LastData = time.Now()
+	msgs := uint64(1)
+	if rm.cfg.Delimiter != nil {
+		msgs = uint64(bytes.Count(b, rm.cfg.Delimiter))
+	}
+	rm.msgs += msgs
+	rm.stats.TotalMessages += msgs
+}
+
+/*Stats returns a snapshot of the current measurements*/
+func (rm *RateMonitor) Stats() RateStats {
+	rm.mux.Lock()
+	defer rm.mux.Unlock()
+	return rm.stats
+}
+
+func (rm *RateMonitor) run() {
+	tick := time.NewTicker(rm.cfg.Interval)
+	defer tick.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-tick.C:
+			rm.sample(now, now.Sub(last))
+			last = now
+		}
+	}
+}
+
+/*sample folds the counts since the last sample into the averages, and checks for stalls*/
+func (rm *RateMonitor) sample(now time.Time, elapsed time.Duration) {
+	rm.mux.Lock()
+	secs := elapsed.Seconds()
+	brate, mrate := float64(rm.bytes)/secs, float64(rm.msgs)/secs
+	if rm.primed {
+		alpha := 1 - math.Exp(-secs/rm.cfg.Smoothing.Seconds())
+		rm.stats.BytesPerSec += alpha * (brate - rm.stats.BytesPerSec)
+		rm.stats.MessagesPerSec += alpha * (mrate - rm.stats.MessagesPerSec)
+	} else {
+		rm.stats.BytesPerSec, rm.stats.MessagesPerSec, rm.primed = brate, mrate, true
+	}
+
+	below := rm.stats.BytesPerSec < rm.cfg.Floor || (rm.cfg.Floor == 0 && rm.bytes == 0)
+	rm.bytes, rm.msgs = 0, 0
+	var fire func(RateStats)
+	switch {
+	case below && rm.stats.BelowSince.IsZero():
+		rm.stats.BelowSince = now
+	case !below:
+		rm.stats.BelowSince = time.Time{}
+		if rm.stats.Stalled {
+			rm.stats.Stalled, fire = false, rm.cfg.OnRecover
+			LoggerFrom(rm.ctx).Info("data rate recovered", "event", EventRecover, "dial", dialOf(rm.idotoo), "rate", rm.stats.BytesPerSec)
+		}
+	}
+	if below && !rm.stats.Stalled && rm.cfg.StallAfter > 0 && now.Sub(rm.stats.BelowSince) >= rm.cfg.StallAfter {
+		rm.stats.Stalled, fire = true, rm.cfg.OnStall
+		LoggerFrom(rm.ctx).Warn("data rate below floor", "event", EventStall, "dial", dialOf(rm.idotoo), "rate", rm.stats.BytesPerSec, "floor", rm.cfg.Floor)
+	}
+	stats := rm.stats
+	rm.mux.Unlock()
+	if fire != nil {
+		fire(stats)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateMonitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &bufIO{}
+	stalled, recovered := make(chan RateStats, 1), make(chan RateStats, 1)
+	rm := NewRateMonitor(ctx, inner, RateMonitorConfig{
+		Interval:   5 * time.Millisecond,
+		Smoothing:  5 * time.Millisecond,
+		Delimiter:  []byte("\n"),
+		Floor:      100,
+		StallAfter: 20 * time.Millisecond,
+		OnStall:    func(rs RateStats) { stalled <- rs },
+		OnRecover:  func(rs RateStats) { recovered <- rs },
+	})
+	_ = rm.String()
+
+	inner.rx.WriteString("$GPGGA\n$GPRMC\n")
+	rm.Read(make([]byte, 64))
+	if st := rm.Stats(); st.TotalBytes != 14 || st.TotalMessages != 2 || st.LastData.IsZero() {
+		t.Error("Unexpected totals", st)
+	}
+
+	select {
+	case rs := <-stalled:
+		if !rs.Stalled || rs.BelowSince.IsZero() {
+			t.Error("Expected stalled stats", rs)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Expected the monitor to detect a stall")
+		t.FailNow()
+	}
+
+	//keep feeding well above the floor until it recovers
+	stop := time.After(500 * time.Millisecond)
+	for {
+		rm.Observe(make([]byte, 100))
+		select {
+		case rs := <-recovered:
+			if rs.Stalled || rs.BytesPerSec < 100 {
+				t.Error("Expected recovered stats", rs)
+			}
+			_ = rs.String()
+			return
+		case <-stop:
+			t.Error("Expected the monitor to recover")
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+The helpers below loop over Read until their condition is met, relying (as the
+Arbiter does) on the IDoIO's reads returning timeout errors periodically rather
+than blocking indefinitely.  Temporary errors are retried; anything else ends
+the loop and is returned.
+*/
+
+/*
+ReadN reads until exactly n bytes have arrived, timeout elapses, or ctx is
+done.  If it stops early, the partial data is returned along with an error,
+where IsTimeout() is true if timeout elapsed.
+*/
+func ReadN(ctx context.Context, idoio IDoIO, n int, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	b := make([]byte, n)
+	got := 0
+	for got < n {
+		if err := waitErr(ctx, deadline, fmt.Sprintf("read %d of %d bytes", got, n)); err != nil {
+			return b[:got], err
+		}
+		m, err := idoio.Read(b[got:])
+		got += m
+		if err != nil && !IsTemporary(err) {
+			return b[:got], err
+		}
+	}
+	return b, nil
+}
+
+/*
+Discard reads and throws away n bytes, returning how many were discarded before
+timeout elapsed (when the error's IsTimeout() is true) or ctx was done
+*/
+func Discard(ctx context.Context, idoio IDoIO, n int, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	scratch := make([]byte, 4096)
+	got := 0
+	for got < n {
+		if err := waitErr(ctx, deadline, fmt.Sprintf("discarded %d of %d bytes", got, n)); err != nil {
+			return got, err
+		}
+		want := n - got
+		if want > len(scratch) {
+			want = len(scratch)
+		}
+		m, err := idoio.Read(scratch[:want])
+		got += m
+		if err != nil && !IsTemporary(err) {
+			return got, err
+		}
+	}
+	return got, nil
+}
+
+/*
+DiscardUntilQuiet reads and throws away everything until nothing has arrived
+for quiet, returning how many bytes were discarded.  If the input is still
+not quiet after max, an error where IsTimeout() is true is returned.  This
+is useful for draining a chatty device before a command.
+*/
+func DiscardUntilQuiet(ctx context.Context, idoio IDoIO, quiet, max time.Duration) (int, error) {
+	deadline := time.Now().Add(max)
+	scratch := make([]byte, 4096)
+	got, last := 0, time.Now()
+	for time.Since(last) < quiet {
+		if err := waitErr(ctx, deadline, fmt.Sprintf("still receiving after discarding %d bytes", got)); err != nil {
+			return got, err
+		}
+		m, err := idoio.Read(scratch)
+		if m > 0 {
+			got, last = got+m, time.Now()
+		}
+		if err != nil && !IsTemporary(err) {
+			return got, err
+		}
+	}
+	return got, nil
+}
+
+/*
+waitErr returns an error describing progress if ctx is done (which is neither
+temporary nor a timeout, as for an Arbiter) or deadline has passed (a timeout)
+*/
+func waitErr(ctx context.Context, deadline time.Time, progress string) error {
+	if err := ctx.Err(); err != nil {
+		return newErr(false, false, errors.Wrap(err, progress))
+	}
+	if !time.Now().Before(deadline) {
+		return newErr(true, true, errors.New("timed out: "+progress))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadN(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, func(t *testing.T, con net.Conn) {
+		defer con.Close()
+		con.Write([]byte("01234"))
+		time.Sleep(30 * time.Millisecond)
+		con.Write([]byte("56789abc"))
+		<-ctx.Done()
+	})
+	idoio, err := NewIDoIO(ctx, 5*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer idoio.Close()
+
+	if b, err := ReadN(ctx, idoio, 8, time.Second); err != nil || string(b) != "01234567" {
+		t.Errorf("Expected 8 bytes across two writes, got %q %v", b, err)
+	}
+	if b, err := ReadN(ctx, idoio, 8, 50*time.Millisecond); !IsTimeout(err) || string(b) != "89abc" {
+		t.Errorf("Expected a timeout with partial data, got %q %v", b, err)
+	}
+	cctx, ccancel := context.WithCancel(ctx)
+	ccancel()
+	if _, err := ReadN(cctx, idoio, 1, time.Second); err == nil || IsTimeout(err) {
+		t.Error("Expected a cancelled context to fail without a timeout", err)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, func(t *testing.T, con net.Conn) {
+		defer con.Close()
+		for i := 0; i < 10; i++ {
+			con.Write([]byte("chatter"))
+			time.Sleep(5 * time.Millisecond)
+		}
+		con.Write([]byte("x"))
+		<-ctx.Done()
+	})
+	idoio, err := NewIDoIO(ctx, 5*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer idoio.Close()
+
+	if n, err := Discard(ctx, idoio, 3, time.Second); err != nil || n != 3 {
+		t.Error("Expected to discard 3 bytes", n, err)
+	}
+	if _, err := DiscardUntilQuiet(ctx, idoio, 30*time.Millisecond, 10*time.Millisecond); !IsTimeout(err) {
+		t.Error("Expected chatter to outlast a short max", err)
+	}
+	if n, err := DiscardUntilQuiet(ctx, idoio, 30*time.Millisecond, time.Second); err != nil || n == 0 {
+		t.Error("Expected to discard until quiet", n, err)
+	}
+	if n, err := Discard(ctx, idoio, 1, 20*time.Millisecond); !IsTimeout(err) || n != 0 {
+		t.Error("Expected nothing left to discard", n, err)
+	}
+}
@@ -0,0 +1,348 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO         = &Reconnector{}
+	_ ContextReader = &Reconnector{}
+)
+
+func init() {
+	known["reconnect"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewReconnectClient(ctx, dur, dial)
+	}
+}
+
+/*ReconnectState is the state of the IDoIO a Reconnector looks after*/
+type ReconnectState int
+
+const (
+	ReconnectUp           ReconnectState = iota //open, as far as is known
+	ReconnectReconnecting                       //failed, and being reopened
+	ReconnectFailed                             //could not be reopened within MaxAttempts, until Open is called
+)
+
+/*String conforms to fmt.Stringer*/
+func (rs ReconnectState) String() string {
+	switch rs {
+	case ReconnectUp:
+		return "up"
+	case ReconnectReconnecting:
+		return "reconnecting"
+	case ReconnectFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("ReconnectState(%d)", int(rs))
+}
+
+/*ReconnectConfig controls how a Reconnector reopens the IDoIO it wraps*/
+type ReconnectConfig struct {
+	//MinBackoff is the wait before the first reopen. Defaults to 100ms.
+	MinBackoff time.Duration
+
+	//MaxBackoff caps the wait between reopens, which doubles with each failure. Defaults to 30s.
+	MaxBackoff time.Duration
+
+	//Jitter is the most added at random to each wait, so links that dropped together are not reopened in lockstep.
+	Jitter time.Duration
+
+	//MaxAttempts is how many reopens in a row may fail before giving up. Zero means never give up.
+	MaxAttempts int
+
+	/*OnState, if not nil, is called on every change of state, with the error
+	  that caused it (nil for ReconnectUp).  It must not call Open.*/
+	OnState func(state ReconnectState, err error)
+}
+
+/*
+Reconnector wraps an IDoIO, reopening it with exponential backoff whenever a
+Read or Write fails permanently (i.e. not IsTemporary), and then retrying the
+Read or Write if nothing had been transferred.  The caller blocks while it
+reconnects, until the context passed to NewReconnector is done.  Should
+MaxAttempts reopens in a row fail, the last error is returned, and Reads and
+Writes keep failing until Open is called.
+*/
+type Reconnector struct {
+	idotoo IDoIO
+	cfg    ReconnectConfig
+	ctx    context.Context
+	dial   string //empty unless built from one
+	log    Logger //nil means LoggerFrom(ctx)
+
+	reconnecting sync.Mutex //serializes reconnecting, and guards rnd
+	rnd          *rand.Rand
+
+	mux   sync.Mutex //guards everything below
+	state ReconnectState
+	gen   uint64 //incremented on every reopen, so failures from before it are not acted on twice
+	last  error  //why the Reconnector gave up
+}
+
+/*NewReconnector returns a Reconnector over idoio, which gives up waiting to reconnect once ctx is done*/
+func NewReconnector(ctx context.Context, idoio IDoIO, cfg ReconnectConfig) *Reconnector {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	return &Reconnector{idotoo: idoio, cfg: cfg, ctx: ctx, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+/*
+NewReconnectClient opens a Reconnector over another dial string.  Dial should
+be in the form of
+
+	reconnect://(<dial>)?[min=<duration>][&max=<duration>][&jitter=<duration>][&attempts=<n>]
+
+eg "reconnect://(tcp://localhost:4000)?min=1s&max=1m".
+*/
+func NewReconnectClient(ctx context.Context, timeout time.Duration, dial string) (*Reconnector, error) {
+	d, err := dialFor(dial, "reconnect")
+	dials, query := d.Dials, d.Params.Encode()
+	if err == nil && len(dials) != 1 {
+		err = fmt.Errorf("exactly one dial string may be wrapped")
+	}
+	if err == nil && factoryFor(dials[0]) == nil {
+		err = fmt.Errorf("no known way to open %q", dials[0])
+	}
+	var cfg ReconnectConfig
+	if err == nil {
+		cfg, err = parseReconnect(query)
+	}
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+	idoio, err := NewIDoIO(ctx, timeout, dials[0])
+	r := NewReconnector(ctx, idoio, cfg)
+	r.dial = dial
+	return r, err
+}
+
+func parseReconnect(query string) (cfg ReconnectConfig, err error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return cfg, err
+	}
+	for k, v := range q {
+		switch k {
+		case "min":
+			cfg.MinBackoff, err = time.ParseDuration(v[0])
+		case "max":
+			cfg.MaxBackoff, err = time.ParseDuration(v[0])
+		case "jitter":
+			cfg.Jitter, err = time.ParseDuration(v[0])
+		case "attempts":
+			if cfg.MaxAttempts, err = strconv.Atoi(v[0]); err == nil && cfg.MaxAttempts < 0 {
+				err = fmt.Errorf("invalid %s %q", k, v[0])
+			}
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this Reconnector was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (r *Reconnector) SetLogger(l Logger) {
+	r.log = l
+}
+
+func (r *Reconnector) logger() Logger {
+	if r.log != nil {
+		return r.log
+	}
+	return LoggerFrom(r.ctx)
+}
+
+func (r *Reconnector) dialString() string {
+	if r.dial != "" {
+		return r.dial
+	}
+	return dialOf(r.idotoo)
+}
+
+/*String conforms to fmt.Stringer*/
+func (r *Reconnector) String() string {
+	return fmt.Sprintf("Reconnector over %v", r.idotoo)
+}
+
+/*State returns the state of the IDoIO wrapped*/
+func (r *Reconnector) State() ReconnectState {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.state
+}
+
+/*Open conforms to IDoIO, reopening the IDoIO wrapped, and ending any failed state*/
+func (r *Reconnector) Open() error {
+	r.reconnecting.Lock()
+	defer r.reconnecting.Unlock()
+	err := r.idotoo.Open()
+	r.mux.Lock()
+	r.gen++
+	r.mux.Unlock()
+	if err == nil {
+		r.setState(ReconnectUp, nil)
+	}
+	return err
+}
+
+/*Close conforms to io.Closer, closing the IDoIO wrapped*/
+func (r *Reconnector) Close() error { return r.idotoo.Close() }
+
+/*Read conforms to io.Reader, reconnecting on permanent errors*/
+func (r *Reconnector) Read(b []byte) (int, error) {
+	return r.do(r.idotoo.Read, b)
+}
+
+/*ReadContext conforms to ContextReader, reconnecting on permanent errors*/
+func (r *Reconnector) ReadContext(ctx context.Context, b []byte) (int, error) {
+	return r.do(func(b []byte) (int, error) { return readContext(ctx, r.idotoo, b) }, b)
+}
+
+/*Write conforms to io.Writer, reconnecting on permanent errors*/
+func (r *Reconnector) Write(b []byte) (int, error) {
+	return r.do(r.idotoo.Write, b)
+}
+
+/*do performs op, reconnecting and trying again while it fails permanently having transferred nothing*/
+func (r *Reconnector) do(op func([]byte) (int, error), b []byte) (int, error) {
+	for {
+		gen, err := r.usable()
+		if err != nil {
+			return 0, err
+		}
+		n, err := op(b)
+		if err == nil || IsTemporary(err) || r.ctx.Err() != nil {
+			return n, err
+		}
+		if rerr := r.reconnect(gen, err); rerr != nil {
+			return n, rerr
+		}
+		if n > 0 {
+			return n, newErr(true, false, errors.Wrap(err, "reconnected"))
+		}
+	}
+}
+
+/*usable returns the current generation, or an error if the Reconnector has given up*/
+func (r *Reconnector) usable() (uint64, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.state == ReconnectFailed {
+		return r.gen, newErr(false, false, errors.Wrap(r.last, "gave up reconnecting"))
+	}
+	return r.gen, nil
+}
+
+/*
+reconnect reopens the IDoIO wrapped after cause, unless that has been done
+since gen, waiting ever longer between attempts
+*/
+func (r *Reconnector) reconnect(gen uint64, cause error) error {
+	r.reconnecting.Lock()
+	defer r.reconnecting.Unlock()
+	if now, err := r.usable(); now != gen || err != nil { //another Read or Write has already reconnected, or given up
+		return err
+	}
+	r.setState(ReconnectReconnecting, cause)
+	wait := r.cfg.MinBackoff
+	for attempt := 1; ; attempt++ {
+		delay := wait
+		if r.cfg.Jitter > 0 {
+			delay += time.Duration(r.rnd.Int63n(int64(r.cfg.Jitter) + 1))
+		}
+		select {
+		case <-r.ctx.Done():
+			return newErr(false, false, r.ctx.Err())
+		case <-time.After(delay):
+		}
+		err := r.idotoo.Open()
+		if err == nil {
+			r.mux.Lock()
+			r.gen++
+			r.mux.Unlock()
+			r.setState(ReconnectUp, nil)
+			return nil
+		}
+		r.logger().Debug("unable to reconnect", "event", EventRetry, "dial", r.dialString(), "attempt", attempt, "error", err)
+		if r.cfg.MaxAttempts > 0 && attempt >= r.cfg.MaxAttempts {
+			r.mux.Lock()
+			r.gen++
+			r.last = err
+			r.mux.Unlock()
+			r.setState(ReconnectFailed, err)
+			return newErr(false, false, errors.Wrapf(err, "unable to reconnect after %d attempts", attempt))
+		}
+		if wait *= 2; wait > r.cfg.MaxBackoff {
+			wait = r.cfg.MaxBackoff
+		}
+	}
+}
+
+/*setState changes the state, logging and reporting it*/
+func (r *Reconnector) setState(state ReconnectState, err error) {
+	r.mux.Lock()
+	was := r.state
+	r.state = state
+	r.mux.Unlock()
+	if was == state {
+		return
+	}
+	switch state {
+	case ReconnectUp:
+		r.logger().Info("reconnected", "event", EventConnect, "dial", r.dialString())
+	case ReconnectReconnecting:
+		r.logger().Warn("connection lost, reconnecting", "event", EventDisconnect, "dial", r.dialString(), "error", err)
+	case ReconnectFailed:
+		r.logger().Error("gave up reconnecting", "event", EventError, "dial", r.dialString(), "error", err)
+	}
+	if r.cfg.OnState != nil {
+		r.cfg.OnState(state, err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*droppingIO is a bufIO that can be dropped, failing permanently until reopened, and refuse some reopens*/
+type droppingIO struct {
+	bufIO
+	mux     sync.Mutex
+	down    bool
+	refuse  int //Opens still to fail
+	opens   int
+	written []byte
+}
+
+func (d *droppingIO) Open() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.opens++
+	if d.refuse > 0 {
+		d.refuse--
+		return newErr(false, false, errors.New("refused"))
+	}
+	d.down = false
+	return nil
+}
+
+func (d *droppingIO) Write(p []byte) (int, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.down {
+		return 0, writeErr
+	}
+	d.written = append(d.written, p...)
+	return len(p), nil
+}
+
+func (d *droppingIO) drop(refuse int) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.down, d.refuse = true, refuse
+}
+
+func TestReconnector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inner := &droppingIO{}
+	var states []ReconnectState
+	r := NewReconnector(ctx, inner, ReconnectConfig{
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  4 * time.Millisecond,
+		MaxAttempts: 3,
+		OnState:     func(s ReconnectState, err error) { states = append(states, s) },
+	})
+	_ = r.String()
+
+	inner.drop(1)
+	if n, err := r.Write([]byte("hello")); n != 5 || err != nil {
+		t.Error("Expected the write to go through once reconnected", n, err)
+	}
+	if string(inner.written) != "hello" || inner.opens != 2 || r.State() != ReconnectUp {
+		t.Error("Expected one refused and one successful reopen", string(inner.written), inner.opens, r.State())
+	}
+	if len(states) != 2 || states[0] != ReconnectReconnecting || states[1] != ReconnectUp {
+		t.Error("Expected to hear of reconnecting and being up again", states)
+	}
+
+	inner.drop(10)
+	if _, err := r.Write([]byte("x")); err == nil || IsTemporary(err) {
+		t.Error("Expected to give up after 3 attempts", err)
+	}
+	if r.State() != ReconnectFailed || inner.opens != 5 {
+		t.Error("Expected to have failed after 3 reopens", r.State(), inner.opens)
+	}
+	if _, err := r.Write([]byte("x")); err == nil || inner.opens != 5 {
+		t.Error("Expected to stay failed without reopening", err)
+	}
+	inner.drop(0)
+	if err := r.Open(); err != nil || r.State() != ReconnectUp {
+		t.Error("Expected Open to end the failure", err)
+	}
+	if n, err := r.Read(make([]byte, 4)); n != 0 || err == nil || !IsTemporary(err) {
+		t.Error("Expected temporary errors to be passed through", err)
+	}
+
+	//waiting gives up with the context
+	inner.drop(10)
+	r = NewReconnector(ctx, inner, ReconnectConfig{MinBackoff: time.Hour})
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := r.Write([]byte("x")); err == nil || !errors.Is(err.(*neterror).err, context.Canceled) {
+		t.Error("Expected the context to end the wait", err)
+	}
+}
+
+func TestNewReconnectClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, bad := range []string{"reconnect://(null://)?min=x", "reconnect://(null://)?attempts=-1", "reconnect://(null://)?bogus=1", "reconnect://(null://,null://)", "reconnect://(bogus://)"} {
+		if _, err := NewIDoIO(ctx, time.Second, bad); err == nil {
+			t.Errorf("Expected %q to fail", bad)
+		}
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, "reconnect://(null://)?min=1s&max=1m&jitter=10ms&attempts=5")
+	if err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	r := idoio.(*Reconnector)
+	if r.cfg.MinBackoff != time.Second || r.cfg.MaxBackoff != time.Minute || r.cfg.Jitter != 10*time.Millisecond || r.cfg.MaxAttempts != 5 {
+		t.Error("Unexpected config", r.cfg)
+	}
+	if dialOf(r) != "reconnect://(null://)?min=1s&max=1m&jitter=10ms&attempts=5" {
+		t.Error("Expected the dial string to be remembered", dialOf(r))
+	}
+}
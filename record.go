@@ -0,0 +1,180 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO         = &Recorder{}
+	_ ContextReader = &Recorder{}
+)
+
+func init() {
+	known["record"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewRecordClient(ctx, dur, dial)
+	}
+}
+
+/*
+Recorder wraps an IDoIO, writing everything read from and written to it, with
+timestamps, to a capture (see CaptureWriter), from which it may be replayed
+(see ReplayClient).  Each record is flushed as it is written, so a capture
+cut short by a crash is readable up to that point.
+*/
+type Recorder struct {
+	idotoo IDoIO
+	dial   string     //empty unless built from one
+	mux    sync.Mutex //guards cw
+	cw     *CaptureWriter
+	err    error //the first error writing the capture
+}
+
+/*NewRecorder returns a Recorder over idoio writing to cw, which it closes when closed*/
+func NewRecorder(idoio IDoIO, cw *CaptureWriter) *Recorder {
+	return &Recorder{idotoo: idoio, cw: cw}
+}
+
+/*
+NewRecordClient opens a Recorder over another dial string.  Dial should be in
+the form of
+
+	record://(<dial>)?file=<path>
+
+eg "record://(tcp://host:5000)?file=/data/session.cap".  The capture is
+created (or truncated), labelled with the dial string recorded.
+*/
+func NewRecordClient(ctx context.Context, timeout time.Duration, dial string) (*Recorder, error) {
+	d, err := dialFor(dial, "record")
+	dials, query := d.Dials, d.Params.Encode()
+	if err == nil && len(dials) != 1 {
+		err = fmt.Errorf("exactly one dial string may be recorded")
+	}
+	if err == nil && factoryFor(dials[0]) == nil {
+		err = fmt.Errorf("no known way to open %q", dials[0])
+	}
+	var q url.Values
+	if err == nil {
+		q, err = url.ParseQuery(query)
+	}
+	for k := range q {
+		if err == nil && k != "file" {
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	if err == nil && q.Get("file") == "" {
+		err = fmt.Errorf("a file is required")
+	}
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+	cw, err := CreateCapture(q.Get("file"), dials[0])
+	if err != nil {
+		return nil, err
+	}
+	idoio, err := NewIDoIO(ctx, timeout, dials[0])
+	r := NewRecorder(idoio, cw)
+	r.dial = dial
+	return r, err
+}
+
+func (r *Recorder) dialString() string {
+	if r.dial != "" {
+		return r.dial
+	}
+	return dialOf(r.idotoo)
+}
+
+/*String conforms to fmt.Stringer*/
+func (r *Recorder) String() string {
+	return fmt.Sprintf("Recorder over %v", r.idotoo)
+}
+
+/*Err returns the first error writing the capture, after which nothing more is recorded*/
+func (r *Recorder) Err() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.err
+}
+
+/*Open conforms to IDoIO, reopening the IDoIO recorded.  Recording continues in the same capture.*/
+func (r *Recorder) Open() error { return r.idotoo.Open() }
+
+/*Close conforms to io.Closer, closing both the IDoIO recorded and the capture*/
+func (r *Recorder) Close() error {
+	err := r.idotoo.Close()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.cw != nil {
+		if cerr := r.cw.Close(); err == nil {
+			err = cerr
+		}
+		r.cw = nil
+	}
+	return err
+}
+
+/*Read conforms to io.Reader, recording whatever was read*/
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.idotoo.Read(b)
+	r.record(Rx, b[:n])
+	return n, err
+}
+
+/*ReadContext conforms to ContextReader, recording whatever was read*/
+func (r *Recorder) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, r.idotoo, b)
+	r.record(Rx, b[:n])
+	return n, err
+}
+
+/*Write conforms to io.Writer, recording whatever was written*/
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.idotoo.Write(b)
+	r.record(Tx, b[:n])
+	return n, err
+}
+
+func (r *Recorder) record(dir Direction, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.cw == nil || r.err != nil {
+		return
+	}
+	r.err = r.cw.WriteRecord(Record{Time: time.Now(), Direction: dir, Bytes: append([]byte{}, b...)})
+	if r.err == nil {
+		r.err = r.cw.Flush()
+	}
+}
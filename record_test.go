@@ -0,0 +1,80 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "session.cap")
+	for _, dial := range []string{"record://(null://)", "record://(null://)?x=1", "record://(bogus://x)?file=" + path, "record://(null://,null://)?file=" + path} {
+		if _, err := NewRecordClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+
+	_, svr, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", svr, arbHandler)
+	idoio, err := NewIDoIO(ctx, time.Second, fmt.Sprintf("record://(%v)?file=%v", dial, path))
+	if err != nil {
+		t.Fatal("Unable to record", err)
+	}
+	r := idoio.(*Recorder)
+	_ = r.String()
+	a, stop := Arbitrate(ctx, r)
+	for i := 0; i < 2; i++ {
+		if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+			t.Error("Expected a response", rsp.Error)
+		}
+	}
+	stop()
+	if err := r.Close(); err != nil || r.Err() != nil {
+		t.Error("Unable to close the recording", err, r.Err())
+	}
+
+	cr, err := OpenCapture(path)
+	if err != nil {
+		t.Fatal("Unable to open the capture", err)
+	}
+	defer cr.Close()
+	if cr.Label() != dial {
+		t.Error("Expected the capture labelled with the dial recorded, not", cr.Label())
+	}
+	recs, _ := cr.ReadAll()
+	var got string
+	for _, rec := range recs {
+		got += fmt.Sprintf("%v %s;", rec.Direction, rec.Bytes)
+	}
+	if want := "tx ABC;rx Rxd>3;tx ABC;rx Rxd>3;"; got != want {
+		t.Errorf("Expected %q recorded, got %q", want, got)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*Factory creates an IDoIO from a dial string, as NewIDoIO does*/
+type Factory func(ctx context.Context, timeout time.Duration, dial string) (IDoIO, error)
+
+/*
+PluginPathEnv names the environment variable holding a list of directories
+(separated as for PATH) searched for plugins the first time NewIDoIO is handed
+a dial string it does not recognize.  See LoadPlugin.
+*/
+const PluginPathEnv = "AGNOIO_PLUGIN_PATH"
+
+var schemeRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*)://`)
+
+var registry = struct {
+	sync.RWMutex
+	schemes  map[string]Factory
+	patterns []pattern //in the order registered
+}{schemes: map[string]Factory{}}
+
+/*pattern is a Factory registered with RegisterScheme*/
+type pattern struct {
+	re *regexp.Regexp
+	f  Factory
+}
+
+var envPlugins sync.Once
+
+/*
+Register makes f the Factory for dial strings of the form <scheme>://...,
+allowing transports outside this package to be created by NewIDoIO (and so by
+NewArbiter, Manager, etc).  It is typically called from the init function of
+the package, or plugin, providing the transport.  Schemes are case
+insensitive, and an error is returned if scheme is invalid or already taken.
+*/
+func Register(scheme string, f Factory) error {
+	scheme = strings.ToLower(scheme)
+	if !schemeRe.MatchString(scheme+"://") || f == nil {
+		return newErr(false, false, fmt.Errorf("invalid scheme %q", scheme))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, taken := registry.schemes[scheme]; taken || known[scheme] != nil {
+		return newErr(false, false, fmt.Errorf("scheme %q is already registered", scheme))
+	}
+	registry.schemes[scheme] = f
+	return nil
+}
+
+/*
+RegisterScheme makes f the Factory for dial strings matching re, for when the
+scheme alone does not settle which transport to use (eg a new form of
+serial:// dial string).  The built in transports are consulted first, for
+dial strings in the form ParseDial expects of their scheme, then those added
+with RegisterScheme in the order they were added, and then those added with
+Register.  An error is returned if either argument is nil.
+*/
+func RegisterScheme(re *regexp.Regexp, f Factory) error {
+	if re == nil || f == nil {
+		return newErr(false, false, fmt.Errorf("RegisterScheme needs both a regexp and a Factory"))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	registry.patterns = append(registry.patterns, pattern{re: re, f: f})
+	return nil
+}
+
+/*Schemes returns every scheme NewIDoIO understands, built in or registered, sorted*/
+func Schemes() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	var schemes []string
+	for s := range known {
+		schemes = append(schemes, s)
+	}
+	for s := range registry.schemes {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+/*
+Supports reports whether NewIDoIO recognizes dial, without opening anything.
+Layers given in a Stack's description (see ParseStack) must be known too.  A
+dial string that is supported may still be rejected for its parameters, see
+ValidateDial.
+*/
+func Supports(dial string) bool {
+	if isStackDesc(dial) {
+		s, err := ParseStack(dial)
+		return err == nil && factoryFor(s.Dial) != nil
+	}
+	return factoryFor(dial) != nil
+}
+
+/*
+LoadPlugin opens the Go plugin (see package plugin) at path, and returns the
+schemes it registered.  Plugins register their schemes by calling Register
+from an init function, e.g.
+
+	package main
+
+	import "github.com/NCAR/agnoio"
+
+	func init() { agnoio.Register("acme", NewAcmeClient) }
+
+and are built with
+
+	go build -buildmode=plugin -o acme.so
+
+against the same version of this package as the application.  Loading a
+plugin a second time is harmless, and registers nothing.  Plugins are only
+supported on linux, darwin and freebsd, in builds with cgo enabled; elsewhere
+LoadPlugin always returns an error.
+*/
+func LoadPlugin(path string) ([]string, error) {
+	before := map[string]bool{}
+	for _, s := range Schemes() {
+		before[s] = true
+	}
+	if err := openPlugin(path); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "unable to load plugin %q", path))
+	}
+	var added []string
+	for _, s := range Schemes() {
+		if !before[s] {
+			added = append(added, s)
+		}
+	}
+	DefaultLogger().Debug("plugin loaded", "path", path, "schemes", added)
+	return added, nil
+}
+
+/*
+LoadPlugins loads every *.so file in dir (see LoadPlugin), returning the
+schemes registered and the first error encountered.  Plugins that fail to load
+do not prevent the others from being loaded.
+*/
+func LoadPlugins(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return nil, newErr(false, false, err)
+	}
+	var added []string
+	var first error
+	for _, path := range paths {
+		schemes, err := LoadPlugin(path)
+		if err != nil && first == nil {
+			first = err
+		}
+		added = append(added, schemes...)
+	}
+	return added, first
+}
+
+/*
+factoryFor returns the Factory able to handle dial, or nil.  The built in
+schemes, for dial strings ParseDial can take apart, are consulted before
+registered patterns and then schemes, and if none know dial, the plugins in
+PluginPathEnv are loaded (once) and the registered schemes checked again.
+*/
+func factoryFor(dial string) Factory {
+	if d, err := ParseDial(dial); err == nil && known[d.Scheme] != nil {
+		return known[d.Scheme]
+	}
+	registry.RLock()
+	for _, p := range registry.patterns {
+		if p.re.MatchString(dial) {
+			registry.RUnlock()
+			return p.f
+		}
+	}
+	registry.RUnlock()
+	m := schemeRe.FindStringSubmatch(dial)
+	if m == nil {
+		return nil
+	}
+	scheme := strings.ToLower(m[1])
+	registry.RLock()
+	f := registry.schemes[scheme]
+	registry.RUnlock()
+	if f != nil {
+		return f
+	}
+	envPlugins.Do(func() {
+		for _, dir := range filepath.SplitList(os.Getenv(PluginPathEnv)) {
+			if _, err := LoadPlugins(dir); err != nil {
+				DefaultLogger().Warn("unable to load plugins", "event", EventError, "dir", dir, "error", err)
+			}
+		}
+	})
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.schemes[scheme]
+}
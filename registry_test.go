@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRegister(t *testing.T) {
+	factory := func(ctx context.Context, timeout time.Duration, dial string) (IDoIO, error) {
+		return &bufIO{}, nil
+	}
+	if err := Register("Test-Scheme", factory); err != nil {
+		t.Error("Unable to register", err)
+	}
+	for _, bad := range []string{"test-scheme", "tcp", "serial", "", "1abc", "a b"} {
+		if err := Register(bad, factory); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if err := Register("nilfactory", nil); err == nil {
+		t.Error("Expected a nil factory to be rejected")
+	}
+
+	found := map[string]bool{}
+	for _, s := range Schemes() {
+		found[s] = true
+	}
+	if !found["test-scheme"] || !found["tcp"] || !found["rs232"] {
+		t.Error("Expected registered and built in schemes", Schemes())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if idoio, err := NewIDoIO(ctx, time.Second, "TEST-SCHEME://anything"); err != nil || idoio.String() != "bufIO" {
+		t.Error("Expected the registered factory to be used", idoio, err)
+	}
+	if _, err := NewArbiter(ctx, time.Second, "test-scheme://x"); err != nil {
+		t.Error("Expected an Arbiter over the registered scheme", err)
+	}
+	if _, err := NewIDoIO(ctx, time.Second, "unregistered://x"); err == nil {
+		t.Error("Expected an unregistered scheme to fail")
+	}
+	if err := (ManagerConfig{Devices: []DeviceConfig{{Name: "a", Dial: "test-scheme://a"}}}).Validate(); err != nil {
+		t.Error("Expected the Manager to accept the registered scheme", err)
+	}
+}
+
+func TestRegisterScheme(t *testing.T) {
+	factory := func(ctx context.Context, timeout time.Duration, dial string) (IDoIO, error) {
+		return &bufIO{}, nil
+	}
+	if err := RegisterScheme(nil, factory); err == nil {
+		t.Error("Expected a nil regexp to be rejected")
+	}
+	if err := RegisterScheme(regexp.MustCompile(`^x`), nil); err == nil {
+		t.Error("Expected a nil factory to be rejected")
+	}
+	if err := RegisterScheme(regexp.MustCompile(`^(tcp://.*|acme:[0-9]+)$`), factory); err != nil {
+		t.Fatal("Unable to register", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if idoio, err := NewIDoIO(ctx, time.Second, "acme:42"); err != nil || idoio.String() != "bufIO" {
+		t.Error("Expected the registered factory to be used", idoio, err)
+	}
+	if idoio, _ := NewIDoIO(ctx, time.Millisecond, "tcp://localhost:1"); idoio != nil && idoio.String() == "bufIO" {
+		t.Error("Expected the built in transports to come first")
+	}
+	if _, err := NewIDoIO(ctx, time.Second, "acme:x"); err == nil {
+		t.Error("Expected a dial string not matching to fail")
+	}
+}
+
+func TestLoadPlugin(t *testing.T) {
+	if _, err := LoadPlugin(filepath.Join(t.TempDir(), "missing.so")); err == nil {
+		t.Error("Expected a missing plugin to fail")
+	}
+	if added, err := LoadPlugins(t.TempDir()); err != nil || len(added) != 0 {
+		t.Error("Expected an empty directory to load nothing", added, err)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	for _, good := range []string{"tcp://localhost:1", "serial:///dev/ttyS0:9600", "null://", "tcp://localhost:1 | log | tap(size=8)"} {
+		if !Supports(good) {
+			t.Errorf("Expected %q to be supported", good)
+		}
+	}
+	for _, bad := range []string{"", "unregistered://x", "tcp://localhost:1 | nope", "nope:// | log"} {
+		if Supports(bad) {
+			t.Errorf("Expected %q not to be supported", bad)
+		}
+	}
+}
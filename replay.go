@@ -0,0 +1,250 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &ReplayClient{}
+
+/*
+ReplayClient provides an implementer of the IDoIO interface playing back a
+capture (see Recorder), so parsers may be regression tested against real
+instrument sessions, under the URI regime:
+
+	replay://
+
+Reads return what was read in the capture, in order.  Writes are discarded,
+but in lockstep, each write recorded is waited for, so what was read after it
+is only returned once something has been written, as a device would answer a
+command.  When timing, what was read is returned no sooner than it was in the
+capture, relative to the first record (or the last write waited for).
+
+Once the capture is exhausted, Read returns io.EOF (as a permanent error).
+Open rewinds to the start.
+*/
+type ReplayClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	rwtimeout time.Duration
+	dial      string
+	path      string
+	timing    bool
+	speed     float64
+	lockstep  bool
+	cr        *CaptureReader
+	next      *Record   //read from cr but not yet played
+	pending   []byte    //of a record being played
+	written   int       //writes not yet matched with records
+	origin    time.Time //when the record at epoch is due
+	epoch     time.Time //time in the capture corresponding to origin
+	log       Logger    //nil means LoggerFrom(ctx)
+}
+
+/*
+NewReplayClient opens a capture.  Dial should be in the form of
+
+	replay://<path>[?timing=<bool>&speed=<factor>&lockstep=<bool>]
+
+eg "replay:///data/session.cap?timing=1&speed=10".  speed scales time when
+timing, so 10 is ten times faster than recorded.  Timeout is unused.
+*/
+func NewReplayClient(ctx context.Context, timeout time.Duration, dial string) (*ReplayClient, error) {
+	d, err := dialFor(dial, "replay")
+	if err == nil && d.Device == "" {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	rc := &ReplayClient{ctx: rctx, cancel: cancel, rwtimeout: 1 * time.Millisecond, dial: dial, path: d.Device, speed: 1}
+	if err := rc.parse(d.Params.Encode()); err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	return rc, openUnlessLazy(ctx, rc)
+}
+
+func (rc *ReplayClient) parse(query string) error {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for k, v := range q {
+		switch k {
+		case "timing":
+			rc.timing, err = strconv.ParseBool(v[0])
+		case "lockstep":
+			rc.lockstep, err = strconv.ParseBool(v[0])
+		case "speed":
+			if rc.speed, err = strconv.ParseFloat(v[0], 64); err == nil && rc.speed <= 0 {
+				err = fmt.Errorf("speed must be positive, not %q", v[0])
+			}
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this ReplayClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (rc *ReplayClient) SetLogger(l Logger) {
+	rc.log = l
+}
+
+func (rc *ReplayClient) dialString() string { return rc.dial }
+
+func (rc *ReplayClient) logger() Logger {
+	if rc.log != nil {
+		return rc.log
+	}
+	return LoggerFrom(rc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (rc *ReplayClient) String() string {
+	if rc.cr != nil && rc.cr.Label() != "" {
+		return fmt.Sprintf("replay of %v from %v", rc.cr.Label(), rc.path)
+	}
+	return fmt.Sprintf("replay of %v", rc.path)
+}
+
+/*Open rewinds to the start of the capture*/
+func (rc *ReplayClient) Open() error {
+	select {
+	case <-rc.ctx.Done():
+		return newErr(false, false, rc.ctx.Err())
+	default:
+	}
+	if rc.cr != nil {
+		rc.cr.Close()
+		rc.cr = nil
+	}
+	cr, err := OpenCapture(rc.path)
+	if err != nil {
+		rc.logger().Warn("unable to open connection", "event", EventError, "dial", rc.dial, "error", err)
+		return err
+	}
+	rc.cr, rc.next, rc.pending, rc.written = cr, nil, nil, 0
+	rc.origin, rc.epoch = time.Time{}, time.Time{}
+	rc.logger().Debug("connection opened", "event", EventConnect, "dial", rc.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader, returning what was read in the capture*/
+func (rc *ReplayClient) Read(b []byte) (int, error) {
+	select {
+	case <-rc.ctx.Done():
+		defer rc.Close()
+		return 0, newErr(false, false, rc.ctx.Err())
+	default:
+	}
+	if rc.cr == nil {
+		return 0, readErr
+	}
+	for len(rc.pending) == 0 {
+		if rc.next == nil {
+			rec, err := rc.cr.Next()
+			if err == io.EOF {
+				return 0, newErr(false, false, io.EOF)
+			} else if err != nil {
+				return 0, err
+			}
+			rc.next = &rec
+		}
+		if rc.epoch.IsZero() {
+			rc.origin, rc.epoch = time.Now(), rc.next.Time
+		}
+		if rc.next.Direction == Tx {
+			if rc.lockstep {
+				if rc.written == 0 {
+					return 0, newErr(true, true, fmt.Errorf("read: awaiting a write"))
+				}
+				rc.written--
+				rc.origin, rc.epoch = time.Now(), rc.next.Time //replies are timed from the command
+			}
+			rc.next = nil
+			continue
+		}
+		if rc.timing {
+			due := rc.origin.Add(time.Duration(float64(rc.next.Time.Sub(rc.epoch)) / rc.speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(min(wait, rc.rwtimeout))
+				if time.Now().Before(due) {
+					return 0, newErr(true, true, fmt.Errorf("read: nothing due"))
+				}
+			}
+		}
+		rc.pending, rc.next = rc.next.Bytes, nil
+	}
+	n := copy(b, rc.pending)
+	rc.pending = rc.pending[n:]
+	return n, nil
+}
+
+/*Write conforms to io.Writer, discarding b, but in lockstep releasing what was read after the next write recorded*/
+func (rc *ReplayClient) Write(b []byte) (int, error) {
+	select {
+	case <-rc.ctx.Done():
+		defer rc.Close()
+		return 0, newErr(false, false, rc.ctx.Err())
+	default:
+	}
+	if rc.cr == nil {
+		return 0, writeErr
+	}
+	if len(b) > 0 {
+		rc.written++
+	}
+	return len(b), nil
+}
+
+/*Close conforms to io.Closer*/
+func (rc *ReplayClient) Close() error {
+	rc.cancel()
+	if rc.cr == nil {
+		return nil
+	}
+	rc.logger().Debug("connection closed", "event", EventDisconnect, "dial", rc.dial)
+	err := rc.cr.Close()
+	rc.cr = nil
+	return err
+}
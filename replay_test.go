@@ -0,0 +1,119 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*writeCapture writes recs to a capture at path*/
+func writeCapture(t *testing.T, path string, recs ...Record) {
+	t.Helper()
+	cw, err := CreateCapture(path, "tcp://device:4000")
+	if err != nil {
+		t.Fatal("Unable to create capture", err)
+	}
+	for _, rec := range recs {
+		cw.WriteRecord(rec)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal("Unable to close capture", err)
+	}
+}
+
+/*readFor reads from idoio until want bytes have arrived or d passes*/
+func readFor(idoio IDoIO, want int, d time.Duration) (string, error) {
+	var got []byte
+	buf := make([]byte, 4)
+	for end := time.Now().Add(d); len(got) < want && time.Now().Before(end); {
+		n, err := idoio.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil && !IsTemporary(err) {
+			return string(got), err
+		}
+	}
+	return string(got), nil
+}
+
+func TestReplayClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "session.cap")
+	t0 := time.Now()
+	writeCapture(t, path,
+		Record{Time: t0, Direction: Rx, Bytes: []byte("hello")},
+		Record{Time: t0.Add(time.Millisecond), Direction: Tx, Bytes: []byte("ABC")},
+		Record{Time: t0.Add(300 * time.Millisecond), Direction: Rx, Bytes: []byte("Rxd>3")},
+	)
+	for _, dial := range []string{"replay://", "replay://" + path + "?speed=0", "replay://" + path + "?timing=maybe", "replay://" + path + "?x=1"} {
+		if _, err := NewReplayClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	if _, err := NewReplayClient(ctx, time.Second, "replay://"+path+".missing"); err == nil {
+		t.Error("Expected an error for a missing capture")
+	}
+
+	idoio, err := NewIDoIO(ctx, time.Second, "replay://"+path)
+	if err != nil {
+		t.Fatal("Unable to replay", err)
+	}
+	rc := idoio.(*ReplayClient)
+	defer rc.Close()
+	_ = rc.String()
+	if got, err := readFor(rc, 10, time.Second); got != "helloRxd>3" || err != nil {
+		t.Errorf("Expected everything read, ignoring writes, got %q %v", got, err)
+	}
+	if _, err := rc.Read(make([]byte, 4)); err == nil || IsTemporary(err) || err.(*neterror).err != io.EOF {
+		t.Error("Expected a permanent EOF at the end", err)
+	}
+	if err := rc.Open(); err != nil {
+		t.Fatal("Unable to rewind", err)
+	}
+	if got, _ := readFor(rc, 10, time.Second); got != "helloRxd>3" {
+		t.Errorf("Expected to read it all again, got %q", got)
+	}
+
+	lock, err := NewReplayClient(ctx, time.Second, "replay://"+path+"?lockstep=1&timing=1&speed=3")
+	if err != nil {
+		t.Fatal("Unable to replay", err)
+	}
+	defer lock.Close()
+	if got, _ := readFor(lock, 10, 50*time.Millisecond); got != "hello" {
+		t.Errorf("Expected only what preceded the write, got %q", got)
+	}
+	start := time.Now()
+	lock.Write([]byte("ABC"))
+	if got, _ := readFor(lock, 5, time.Second); got != "Rxd>3" {
+		t.Errorf("Expected the reply after the write, got %q", got)
+	}
+	if took := time.Since(start); took < 90*time.Millisecond || took > 500*time.Millisecond {
+		t.Error("Expected the reply timed from the write, at three times the speed, not after", took)
+	}
+}
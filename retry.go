@@ -0,0 +1,104 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*RetryCondition says which failed exchanges a Command is re-issued after (see Command.Retries)*/
+type RetryCondition int
+
+const (
+	//RetryTimeouts re-issues commands that timed out
+	RetryTimeouts RetryCondition = iota
+	//RetryErrorResponses re-issues commands that timed out or got an error response
+	RetryErrorResponses
+)
+
+func (r RetryCondition) String() string {
+	switch r {
+	case RetryTimeouts:
+		return "timeout"
+	case RetryErrorResponses:
+		return "error"
+	default:
+		return fmt.Sprintf("RetryCondition(%d)", int(r))
+	}
+}
+
+/*MarshalText implements encoding.TextMarshaler*/
+func (r RetryCondition) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+/*UnmarshalText implements encoding.TextUnmarshaler, accepting "timeout" or "error"*/
+func (r *RetryCondition) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "timeout", "":
+		*r = RetryTimeouts
+	case "error":
+		*r = RetryErrorResponses
+	default:
+		return fmt.Errorf("invalid retry condition %q", b)
+	}
+	return nil
+}
+
+/*retries reports whether an exchange of c that failed with err should be re-issued*/
+func (c Command) retries(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case err == ErrErrorResponse:
+		return c.RetryOn == RetryErrorResponses
+	default:
+		return IsTimeout(err)
+	}
+}
+
+/*backoff returns how long to wait before re-issuing c a retry'th time, counting from 1*/
+func (c Command) backoff(retry int) time.Duration {
+	d := c.RetryBackoff
+	for i := 1; i < retry && d > 0 && d < time.Hour; i++ {
+		d *= 2
+	}
+	return d
+}
+
+/*pause waits for d, returning false if either ctx or the Arb's own context is done first*/
+func (a *Arb) pause(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+	case <-a.ctx.Done():
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package agnoio
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+)
+
+/*answeringIO is a bufIO answering each write with the next of replies, or OK once they run out*/
+type answeringIO struct {
+	bufIO
+	replies []string
+}
+
+func (f *answeringIO) Write(p []byte) (int, error) {
+	reply := "OK\n"
+	if len(f.replies) > 0 {
+		reply, f.replies = f.replies[0], f.replies[1:]
+	}
+	f.rx.WriteString(reply)
+	return f.bufIO.Write(p)
+}
+
+func TestArb_Retries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := Command{Name: "ping", Prototype: "ping\n", Timeout: 20 * time.Millisecond,
+		Response: regexp.MustCompile(`OK`), Error: regexp.MustCompile(`ERR`), Retries: 2, RetryBackoff: 5 * time.Millisecond}
+
+	for _, tc := range []struct {
+		replies  []string
+		on       RetryCondition
+		attempts int
+		err      error
+		timeout  bool
+	}{
+		{[]string{"", ""}, RetryTimeouts, 3, nil, false},
+		{[]string{"", "", ""}, RetryTimeouts, 3, nil, true},
+		{[]string{"ERR\n"}, RetryTimeouts, 1, ErrErrorResponse, false},
+		{[]string{"ERR\n", ""}, RetryErrorResponses, 3, nil, false},
+		{nil, RetryErrorResponses, 1, nil, false},
+	} {
+		dev := &answeringIO{replies: tc.replies}
+		a, stop := Arbitrate(ctx, dev)
+		cmd.RetryOn = tc.on
+		rsp := a.Control(cmd)
+		stop()
+		if rsp.Attempts != tc.attempts || tc.timeout != (rsp.Error != nil && IsTimeout(rsp.Error)) || !tc.timeout && rsp.Error != tc.err {
+			t.Errorf("%q retrying on %v: expected %d attempts, got %d: %v", tc.replies, tc.on, tc.attempts, rsp.Attempts, rsp.Error)
+		}
+		if sent := dev.tx.String(); len(sent) != len("ping\n")*rsp.Attempts {
+			t.Errorf("%q: expected the command sent once per attempt, got %q", tc.replies, sent)
+		}
+	}
+
+	//the caller giving up ends the retries
+	a, stop := Arbitrate(ctx, &answeringIO{replies: []string{"", "", ""}})
+	defer stop()
+	cmd.RetryBackoff = time.Hour
+	cctx, ccancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer ccancel()
+	if rsp := a.(ContextArbiter).ControlContext(cctx, cmd); rsp.Attempts != 1 || !IsTimeout(rsp.Error) {
+		t.Error("Expected the backoff to be cut short", rsp.Attempts, rsp.Error)
+	}
+
+	var cc CommandConfig
+	if err := json.Unmarshal([]byte(`{"name":"x","retries":2,"retry_backoff":"1s","retry_on":"error"}`), &cc); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := cc.Command(); c.Retries != 2 || c.RetryBackoff != time.Second || c.RetryOn != RetryErrorResponses {
+		t.Error("Expected retries to be configurable", c.Retries, c.RetryBackoff, c.RetryOn)
+	}
+}
+
+func TestCommand_Backoff(t *testing.T) {
+	c := Command{RetryBackoff: time.Second}
+	for retry, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second} {
+		if retry > 0 && c.backoff(retry) != want {
+			t.Errorf("retry %d: expected %v, got %v", retry, want, c.backoff(retry))
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+var (
+	_ IDoIO = &RFC2217Client{}
+)
+
+// COM-PORT-OPTION (RFC 2217) option and client to server commands. Servers answer with the command + 100.
+const (
+	telnetOptComPort = 44
+
+	comPortSetBaud     = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortSetControl  = 5
+	comPortServer      = 100
+)
+
+// Flow control settings for RFC2217Config.Flow
+const (
+	FlowNone    = "none"
+	FlowXonXoff = "xonxoff"
+	FlowRTSCTS  = "rtscts"
+)
+
+var (
+	rfc2217Parity = map[serial.Parity]byte{serial.NoParity: 1, serial.OddParity: 2, serial.EvenParity: 3, serial.MarkParity: 4, serial.SpaceParity: 5}
+	rfc2217Stop   = map[serial.StopBits]byte{serial.OneStopBit: 1, serial.TwoStopBits: 2, serial.OnePointFiveStopBits: 3}
+	rfc2217Flow   = map[string]byte{FlowNone: 1, FlowXonXoff: 2, FlowRTSCTS: 3}
+)
+
+/*RFC2217Config is the serial port configuration an RFC2217Client asks for*/
+type RFC2217Config struct {
+	serial.Mode
+	Flow string //one of the Flow* constants, defaulting to FlowNone
+}
+
+/*
+RFC2217Client provides an implementer of the IDoIO interface for a serial port
+shared over the network by a terminal server (ser2net, a console server, etc)
+speaking the telnet COM-PORT-OPTION of RFC 2217, under the URI regime:
+
+	rfc2217://
+
+Unlike a raw tcp:// connection to such a server, the baud rate, framing and
+flow control of the remote port are set (on every Open) rather than left to the
+server's configuration.  The settings the server reports having applied are
+available from Reported.
+*/
+type RFC2217Client struct {
+	*TelnetIO
+	dial string
+	cfg  RFC2217Config
+
+	mux      sync.Mutex //guards reported
+	reported RFC2217Config
+}
+
+/*
+NewRFC2217Client connects to a remote serial port. Dial should be in the form
+of "rfc2217://<host>:<port>:<baud>", optionally followed by any of
+?databits=<5-8>&parity=<none|odd|even|mark|space>&stopbits=<1|1.5|2>&flow=<none|xonxoff|rtscts>,
+which default to 8N1 without flow control.  Timeout is used as for a
+NetClient.
+*/
+func NewRFC2217Client(ctx context.Context, timeout time.Duration, dial string) (*RFC2217Client, error) {
+	d, err := dialFor(dial, "rfc2217")
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseRFC2217Config(strconv.Itoa(d.Baud), d.Params.Encode())
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	nc, err := NewNetClient(ctx, timeout, "tcp://"+d.Host)
+	if err != nil {
+		return nil, err
+	}
+	rc := &RFC2217Client{TelnetIO: NewTelnetIO(nc), dial: dial, cfg: cfg}
+	rc.agree[telnetOptComPort] = true
+	rc.onSub = rc.subnegotiation
+	if lazyOpen(ctx) {
+		return rc, nil
+	}
+	return rc, rc.configure()
+}
+
+/*parseRFC2217Config parses the baud and query portions of an rfc2217:// dial string*/
+func parseRFC2217Config(baud, query string) (RFC2217Config, error) {
+	cfg := RFC2217Config{Mode: serial.Mode{DataBits: 8, Parity: serial.NoParity, StopBits: serial.OneStopBit}, Flow: FlowNone}
+	var err error
+	if cfg.BaudRate, err = strconv.Atoi(baud); err != nil {
+		return cfg, err
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return cfg, err
+	}
+	for k, v := range q {
+		if known, err := parseSerialMode(&cfg.Mode, k, v[0]); known {
+			if err != nil {
+				return cfg, err
+			}
+			continue
+		}
+		ok := true
+		switch k {
+		case "flow":
+			_, ok = rfc2217Flow[v[0]]
+			cfg.Flow = v[0]
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err == nil && !ok {
+			err = fmt.Errorf("invalid %s %q", k, v[0])
+		}
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+func (rc *RFC2217Client) dialString() string { return rc.dial }
+
+/*String conforms to fmt.Stringer*/
+func (rc *RFC2217Client) String() string {
+	return fmt.Sprintf("RFC 2217 serial port at %v, %d baud", rc.TelnetIO.IDoIO, rc.cfg.BaudRate)
+}
+
+/*Open conforms to IDoIO, reconnecting and configuring the remote port again*/
+func (rc *RFC2217Client) Open() error {
+	if err := rc.TelnetIO.Open(); err != nil {
+		return err
+	}
+	return rc.configure()
+}
+
+/*Reported returns the configuration the server last reported having applied*/
+func (rc *RFC2217Client) Reported() RFC2217Config {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	return rc.reported
+}
+
+/*configure offers COM-PORT-OPTION and asks for the configured port settings*/
+func (rc *RFC2217Client) configure() error {
+	baud := binary.BigEndian.AppendUint32(nil, uint32(rc.cfg.BaudRate))
+	flow := rfc2217Flow[rc.cfg.Flow]
+	if flow == 0 {
+		flow = rfc2217Flow[FlowNone]
+	}
+	out := []byte{telnetIAC, telnetWILL, telnetOptComPort}
+	for _, cmd := range [][]byte{
+		append([]byte{comPortSetBaud}, baud...),
+		{comPortSetDataSize, byte(rc.cfg.DataBits)},
+		{comPortSetParity, rfc2217Parity[rc.cfg.Parity]},
+		{comPortSetStopSize, rfc2217Stop[rc.cfg.StopBits]},
+		{comPortSetControl, flow},
+	} {
+		out = append(out, telnetIAC, telnetSB, telnetOptComPort)
+		for _, c := range cmd {
+			if out = append(out, c); c == telnetIAC {
+				out = append(out, c)
+			}
+		}
+		out = append(out, telnetIAC, telnetSE)
+	}
+	rc.wmux.Lock()
+	defer rc.wmux.Unlock()
+	rc.replied[[2]byte{telnetWILL, telnetOptComPort}] = true
+	if _, err := rc.TelnetIO.IDoIO.Write(out); err != nil {
+		return err
+	}
+	return nil
+}
+
+/*subnegotiation records the settings the server reports*/
+func (rc *RFC2217Client) subnegotiation(sub []byte) {
+	if len(sub) < 3 || sub[0] != telnetOptComPort {
+		return
+	}
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	switch cmd, val := sub[1]-comPortServer, sub[2:]; cmd {
+	case comPortSetBaud:
+		if len(val) == 4 {
+			rc.reported.BaudRate = int(binary.BigEndian.Uint32(val))
+		}
+	case comPortSetDataSize:
+		rc.reported.DataBits = int(val[0])
+	case comPortSetParity:
+		for p, v := range rfc2217Parity {
+			if v == val[0] {
+				rc.reported.Parity = p
+			}
+		}
+	case comPortSetStopSize:
+		for s, v := range rfc2217Stop {
+			if v == val[0] {
+				rc.reported.StopBits = s
+			}
+		}
+	case comPortSetControl:
+		for f, v := range rfc2217Flow {
+			if v == val[0] {
+				rc.reported.Flow = f
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+/*
+rfc2217Handler is a terminal server that agrees to COM-PORT-OPTION,
+acknowledges every setting, and answers data with "Rxd>%d"
+*/
+func rfc2217Handler(t *testing.T, con net.Conn) {
+	defer con.Close()
+	buf := make([]byte, 1024)
+	var sub []byte
+	state := tnData
+	for {
+		n, err := con.Read(buf)
+		if err != nil {
+			return
+		}
+		data, out := 0, []byte{}
+		for _, c := range buf[:n] {
+			switch state {
+			case tnData:
+				if c == telnetIAC {
+					state = tnIAC
+				} else {
+					data++
+				}
+			case tnIAC:
+				switch c {
+				case telnetWILL:
+					state = tnOption
+				case telnetSB:
+					state, sub = tnSub, nil
+				default:
+					state = tnData
+				}
+			case tnOption:
+				out = append(out, telnetIAC, telnetDO, c)
+				state = tnData
+			case tnSub:
+				if c == telnetIAC {
+					state = tnSubIAC
+				} else {
+					sub = append(sub, c)
+				}
+			case tnSubIAC:
+				state = tnSub
+				switch c {
+				case telnetIAC:
+					sub = append(sub, c)
+				case telnetSE:
+					state = tnData
+					sub[1] += comPortServer
+					out = append(out, telnetIAC, telnetSB)
+					for _, s := range sub {
+						if out = append(out, s); s == telnetIAC {
+							out = append(out, s)
+						}
+					}
+					out = append(out, telnetIAC, telnetSE)
+				}
+			}
+		}
+		if data > 0 {
+			out = append(out, fmt.Sprintf("Rxd>%d", data)...)
+		}
+		con.Write(out)
+	}
+}
+
+func TestParseRFC2217Config(t *testing.T) {
+	cfg, err := parseRFC2217Config("9600", "")
+	if err != nil || cfg.BaudRate != 9600 || cfg.DataBits != 8 || cfg.Parity != serial.NoParity || cfg.StopBits != serial.OneStopBit || cfg.Flow != FlowNone {
+		t.Error("Unexpected defaults", cfg, err)
+	}
+	cfg, err = parseRFC2217Config("115200", "databits=7&parity=even&stopbits=1.5&flow=rtscts")
+	if err != nil || cfg.DataBits != 7 || cfg.Parity != serial.EvenParity || cfg.StopBits != serial.OnePointFiveStopBits || cfg.Flow != FlowRTSCTS {
+		t.Error("Unexpected config", cfg, err)
+	}
+	for _, q := range []string{"databits=9", "parity=weird", "stopbits=3", "flow=magic", "dtr=1"} {
+		if _, err := parseRFC2217Config("9600", q); err == nil {
+			t.Error("Expected an error for", q)
+		}
+	}
+}
+
+func TestRFC2217Client(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewRFC2217Client(ctx, time.Second, "rfc2217://localhost:2000"); err == nil {
+		t.Error("Expected an error without a baud rate")
+	}
+	_, srvdial, _ := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, rfc2217Handler)
+
+	//a baud rate whose encoding contains 0xFF, which must be escaped
+	a, err := NewArbiter(ctx, time.Second, "rfc2217://"+srvdial+":65535?parity=odd&stopbits=2&flow=xonxoff")
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer a.Close()
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected the data to get through", rsp.Error)
+	}
+	rc := a.(*Arb).idotoo.(*RFC2217Client)
+	_ = rc.String()
+	want := RFC2217Config{Mode: serial.Mode{BaudRate: 65535, DataBits: 8, Parity: serial.OddParity, StopBits: serial.TwoStopBits}, Flow: FlowXonXoff}
+	if got := rc.Reported(); got != want {
+		t.Error("Expected the server to report the settings", got)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+/*Direction identifies which way a chunk of traffic was travelling*/
+type Direction int
+
+const (
+	//Rx is traffic read from a transport
+	Rx Direction = 1 + iota
+
+	//Tx is traffic written to a transport
+	Tx
+)
+
+/*String conforms to fmt.Stringer*/
+func (d Direction) String() string {
+	switch d {
+	case Rx:
+		return "rx"
+	case Tx:
+		return "tx"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+/*Record is a timestamped chunk of traffic*/
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Bytes     []byte
+}
+
+/*String conforms to fmt.Stringer*/
+func (r Record) String() string {
+	return fmt.Sprintf("%s %v %q", r.Time.UTC().Format(time.RFC3339Nano), r.Direction, r.Bytes)
+}
+
+var _ IDoIO = &RingTap{}
+var _ ContextReader = &RingTap{}
+
+/*
+RingTap wraps an IDoIO, keeping the most recent traffic in both directions in
+memory, so the context preceding a failure is available on demand (see Dump)
+without always-on logging.  At most Limit() bytes of traffic are retained; the
+oldest traffic is discarded first.
+*/
+type RingTap struct {
+	idotoo  IDoIO
+	limit   int
+	mux     sync.Mutex
+	records []Record //oldest first
+	size    int      //sum of len(records[*].Bytes)
+}
+
+/*NewRingTap returns a RingTap over idoio retaining the last n bytes of traffic*/
+func NewRingTap(idoio IDoIO, n int) *RingTap {
+	return &RingTap{idotoo: idoio, limit: n}
+}
+
+/*String conforms to fmt.Stringer*/
+func (r *RingTap) String() string {
+	return fmt.Sprintf("RingTap(%d) over %v", r.limit, r.idotoo)
+}
+
+func (r *RingTap) dialString() string { return dialOf(r.idotoo) }
+
+/*Limit returns the maximum number of bytes retained*/
+func (r *RingTap) Limit() int { return r.limit }
+
+/*Open conforms to IDoIO. The retained traffic survives reopening.*/
+func (r *RingTap) Open() error { return r.idotoo.Open() }
+
+/*Close conforms to io.Closer. The retained traffic remains available.*/
+func (r *RingTap) Close() error { return r.idotoo.Close() }
+
+/*Read conforms to io.Reader, retaining whatever was read*/
+func (r *RingTap) Read(b []byte) (int, error) {
+	n, err := r.idotoo.Read(b)
+	r.add(Rx, b[:n])
+	return n, err
+}
+
+/*ReadContext conforms to ContextReader, retaining whatever was read*/
+func (r *RingTap) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, r.idotoo, b)
+	r.add(Rx, b[:n])
+	return n, err
+}
+
+/*Write conforms to io.Writer, retaining whatever was written*/
+func (r *RingTap) Write(b []byte) (int, error) {
+	n, err := r.idotoo.Write(b)
+	r.add(Tx, b[:n])
+	return n, err
+}
+
+/*Records returns a copy of the retained traffic, oldest first*/
+func (r *RingTap) Records() []Record {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	recs := make([]Record, len(r.records))
+	for i, rec := range r.records {
+		rec.Bytes = append([]byte{}, rec.Bytes...)
+		recs[i] = rec
+	}
+	return recs
+}
+
+/*
+Dump writes the retained traffic to w, oldest first, one record per line, in
+the form
+
+	2018-03-04T05:06:07.123456789Z tx "MEAS?\r\n"
+*/
+func (r *RingTap) Dump(w io.Writer) error {
+	for _, rec := range r.Records() {
+		if _, err := fmt.Fprintln(w, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*Reset discards all retained traffic*/
+func (r *RingTap) Reset() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.records, r.size = nil, 0
+}
+
+/*add retains b, discarding the oldest traffic to stay within the limit*/
+func (r *RingTap) add(dir Direction, b []byte) {
+	if len(b) == 0 || r.limit <= 0 {
+		return
+	}
+	if len(b) > r.limit {
+		b = b[len(b)-r.limit:]
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.records = append(r.records, Record{Time: time.Now(), Direction: dir, Bytes: append([]byte{}, b...)})
+	r.size += len(b)
+	for r.size > r.limit {
+		excess := r.size - r.limit
+		if oldest := r.records[0].Bytes; len(oldest) > excess {
+			r.records[0].Bytes = oldest[excess:]
+			r.size -= excess
+			break
+		}
+		r.size -= len(r.records[0].Bytes)
+		r.records = r.records[1:]
+	}
+}
@@ -0,0 +1,99 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+/*
+bufIO is an IDoIO test double: reads drain rx, writes append to tx, and reads
+of an empty rx return a timeout
+*/
+type bufIO struct {
+	rx, tx bytes.Buffer
+}
+
+func (b *bufIO) String() string { return "bufIO" }
+func (b *bufIO) Open() error    { return nil }
+func (b *bufIO) Close() error   { return nil }
+func (b *bufIO) Write(p []byte) (int, error) {
+	return b.tx.Write(p)
+}
+func (b *bufIO) Read(p []byte) (int, error) {
+	if b.rx.Len() == 0 {
+		return 0, newErr(true, true, errors.New("bufIO: nothing to read"))
+	}
+	return b.rx.Read(p)
+}
+
+func TestRingTap(t *testing.T) {
+	inner := &bufIO{}
+	r := NewRingTap(inner, 10)
+	_ = r.String()
+	if r.Limit() != 10 {
+		t.Error("Wrong limit")
+	}
+
+	r.Write([]byte("hello"))
+	inner.rx.WriteString("world!")
+	b := make([]byte, 32)
+	if n, err := r.Read(b); n != 6 || err != nil {
+		t.Error("Expected the read to pass through", n, err)
+	}
+	r.Read(b) //timeout, nothing retained
+
+	recs := r.Records()
+	if len(recs) != 2 || recs[0].Direction != Tx || recs[1].Direction != Rx {
+		t.Error("Expected a tx record followed by an rx record", recs)
+		t.FailNow()
+	}
+	//11 bytes of traffic, 10 retained: the oldest byte goes
+	if string(recs[0].Bytes) != "ello" || string(recs[1].Bytes) != "world!" {
+		t.Errorf("Unexpected retained traffic %q %q", recs[0].Bytes, recs[1].Bytes)
+	}
+
+	//a single huge write keeps only its tail
+	r.Write([]byte("0123456789abcdef"))
+	if recs = r.Records(); len(recs) != 1 || string(recs[0].Bytes) != "6789abcdef" {
+		t.Error("Expected only the tail of the last write", recs)
+	}
+
+	dump := &bytes.Buffer{}
+	if err := r.Dump(dump); err != nil || !strings.Contains(dump.String(), `tx "6789abcdef"`) {
+		t.Errorf("Unexpected dump %q", dump.String())
+	}
+
+	r.Reset()
+	if len(r.Records()) != 0 {
+		t.Error("Reset should discard everything")
+	}
+	if Direction(42).String() == "" || Rx.String() != "rx" {
+		t.Error("Unexpected direction strings")
+	}
+}
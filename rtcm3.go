@@ -0,0 +1,220 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+)
+
+const (
+	rtcm3Preamble   = 0xd3
+	rtcm3Overhead   = 6 //preamble, reserved bits and length, and CRC
+	rtcm3MaxPayload = 1023
+)
+
+/*crc24qTable is the lookup table for CRC-24Q (polynomial 0x1864cfb)*/
+var crc24qTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 16
+		for j := 0; j < 8; j++ {
+			c <<= 1
+			if c&0x1000000 != 0 {
+				c ^= 0x1864cfb
+			}
+		}
+		t[i] = c & 0xffffff
+	}
+	return
+}()
+
+/*crc24q is the CRC-24Q used by RTCM 3 (and SBAS)*/
+func crc24q(b []byte) uint32 {
+	var crc uint32
+	for _, v := range b {
+		crc = ((crc << 8) & 0xffffff) ^ crc24qTable[byte(crc>>16)^v]
+	}
+	return crc
+}
+
+/*
+ScanRTCM3 is a bufio.SplitFunc returning whole RTCM 3 frames (preamble through
+CRC).  Frames failing the CRC, and anything between frames, are discarded.  It
+is registered as "rtcm3" for framing layers, e.g. "frame(split=rtcm3)".
+*/
+func ScanRTCM3(data []byte, atEOF bool) (int, []byte, error) {
+	skip := 0
+	for {
+		start := bytes.IndexByte(data[skip:], rtcm3Preamble)
+		if start < 0 {
+			return len(data), nil, nil
+		}
+		start += skip
+		if len(data)-start < 3 {
+			if atEOF { //not a frame after all, try the next candidate
+				skip = start + 1
+				continue
+			}
+			return start, nil, nil
+		}
+		if data[start+1]&0xfc != 0 { //reserved bits must be zero
+			skip = start + 1
+			continue
+		}
+		end := start + rtcm3Overhead + (int(data[start+1]&0x03)<<8 | int(data[start+2]))
+		if len(data) < end {
+			if atEOF { //not a frame after all, try the next candidate
+				skip = start + 1
+				continue
+			}
+			return start, nil, nil
+		}
+		frame := data[start:end]
+		n := len(frame)
+		if crc24q(frame[:n-3]) != uint32(frame[n-3])<<16|uint32(frame[n-2])<<8|uint32(frame[n-1]) {
+			skip = start + 1
+			continue
+		}
+		return end, frame, nil
+	}
+}
+
+/*
+ScanRTCM3Types is ScanRTCM3, discarding frames whose message type is not
+among types, e.g. to forward only the observations and station position from
+a base station that also emits ephemerides
+*/
+func ScanRTCM3Types(types ...uint16) bufio.SplitFunc {
+	want := map[uint16]bool{}
+	for _, t := range types {
+		want[t] = true
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		consumed := 0
+		for {
+			adv, tok, err := ScanRTCM3(data[consumed:], atEOF)
+			consumed += adv
+			if tok == nil || err != nil || want[rtcm3Type(tok)] {
+				return consumed, tok, err
+			}
+			if consumed == len(data) {
+				return consumed, nil, nil
+			}
+		}
+	}
+}
+
+/*rtcm3Type returns the message type of a frame, 0 if it has no payload*/
+func rtcm3Type(frame []byte) uint16 {
+	if len(frame) < rtcm3Overhead+2 {
+		return 0
+	}
+	return uint16(frame[3])<<4 | uint16(frame[4])>>4
+}
+
+/*RTCM3Message is a single RTCM 3 message*/
+type RTCM3Message struct {
+	Type    uint16 //the first 12 bits of the payload, e.g. 1005
+	Payload []byte //including the type
+}
+
+/*String conforms to fmt.Stringer*/
+func (m RTCM3Message) String() string {
+	if name := RTCM3TypeName(m.Type); name != "" {
+		return fmt.Sprintf("RTCM3 %d %s (%d bytes)", m.Type, name, len(m.Payload))
+	}
+	return fmt.Sprintf("RTCM3 %d (%d bytes)", m.Type, len(m.Payload))
+}
+
+/*
+StationID returns the reference station ID carried in the 12 bits following
+the type, and true, for the messages known to carry one (observations, station
+descriptions and MSM)
+*/
+func (m RTCM3Message) StationID() (uint16, bool) {
+	t := m.Type
+	has := (t >= 1001 && t <= 1012) || t == 1033 || (t >= 1071 && t <= 1137)
+	if !has || len(m.Payload) < 3 {
+		return 0, false
+	}
+	return uint16(m.Payload[1]&0x0f)<<8 | uint16(m.Payload[2]), true
+}
+
+/*Bytes returns m framed for sending*/
+func (m RTCM3Message) Bytes() []byte {
+	n := len(m.Payload)
+	b := make([]byte, 3, n+rtcm3Overhead)
+	b[0], b[1], b[2] = rtcm3Preamble, byte(n>>8)&0x03, byte(n)
+	b = append(b, m.Payload...)
+	crc := crc24q(b)
+	return append(b, byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+/*DecodeRTCM3 returns the message in frame, which must be exactly one valid RTCM 3 frame*/
+func DecodeRTCM3(frame []byte) (RTCM3Message, error) {
+	adv, tok, _ := ScanRTCM3(frame, true)
+	if tok == nil || adv != len(frame) || len(tok) != len(frame) || len(frame) < rtcm3Overhead+2 {
+		return RTCM3Message{}, newErr(false, false, fmt.Errorf("not a valid RTCM3 frame: % x", frame))
+	}
+	return RTCM3Message{Type: rtcm3Type(frame), Payload: cloneBytes(frame[3 : len(frame)-3])}, nil
+}
+
+var rtcm3Names = map[uint16]string{
+	1001: "L1 GPS RTK observables",
+	1002: "extended L1 GPS RTK observables",
+	1003: "L1&L2 GPS RTK observables",
+	1004: "extended L1&L2 GPS RTK observables",
+	1005: "stationary RTK reference station ARP",
+	1006: "stationary RTK reference station ARP with antenna height",
+	1007: "antenna descriptor",
+	1008: "antenna descriptor and serial number",
+	1009: "L1 GLONASS RTK observables",
+	1010: "extended L1 GLONASS RTK observables",
+	1011: "L1&L2 GLONASS RTK observables",
+	1012: "extended L1&L2 GLONASS RTK observables",
+	1019: "GPS ephemerides",
+	1020: "GLONASS ephemerides",
+	1033: "receiver and antenna descriptors",
+	1042: "BeiDou ephemerides",
+	1045: "Galileo F/NAV ephemerides",
+	1046: "Galileo I/NAV ephemerides",
+	1230: "GLONASS code-phase biases",
+}
+
+/*
+RTCM3TypeName returns a short description of message type t, or "" if it is
+not known.  MSM types (1071-1137) are described by constellation and level.
+*/
+func RTCM3TypeName(t uint16) string {
+	if name, ok := rtcm3Names[t]; ok {
+		return name
+	}
+	if t >= 1071 && t <= 1137 && t%10 >= 1 && t%10 <= 7 {
+		gnss := map[uint16]string{107: "GPS", 108: "GLONASS", 109: "Galileo", 110: "SBAS", 111: "QZSS", 112: "BeiDou", 113: "NavIC"}[t/10]
+		return fmt.Sprintf("%s MSM%d", gnss, t%10)
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+/*a 1005 message from station 2003, as commonly used to check RTCM 3 decoders*/
+var rtcm1005 = []byte{0xd3, 0x00, 0x13, 0x3e, 0xd7, 0xd3, 0x02, 0x02, 0x98, 0x0e, 0xde, 0xef, 0x34, 0xb4, 0xbd, 0x62, 0xac, 0x09, 0x41, 0x98, 0x6f, 0x33, 0x36, 0x0b, 0x98}
+
+func TestScanRTCM3(t *testing.T) {
+	m, err := DecodeRTCM3(rtcm1005)
+	if err != nil {
+		t.Fatal("Unable to decode 1005", err)
+	}
+	if id, ok := m.StationID(); m.Type != 1005 || !ok || id != 2003 {
+		t.Error("Unexpected message", m, id, ok)
+	}
+	if !bytes.Equal(m.Bytes(), rtcm1005) {
+		t.Errorf("Re-encoding differs: % x", m.Bytes())
+	}
+	t.Log(m)
+
+	msm := RTCM3Message{Type: 1077, Payload: []byte{0x43, 0x50, 0x07, 0xd3}}
+	bad := cloneBytes(rtcm1005)
+	bad[10]++
+	stream := bytes.Join([][]byte{[]byte("noise\xd3"), bad, rtcm1005, {0xd3, 0xff}, msm.Bytes(), RTCM3Message{Type: 1019, Payload: []byte{0x3f, 0xb0}}.Bytes()}, nil)
+
+	var got []uint16
+	sc := bufio.NewScanner(bytes.NewReader(stream))
+	sc.Split(ScanRTCM3)
+	for sc.Scan() {
+		m, err := DecodeRTCM3(sc.Bytes())
+		if err != nil {
+			t.Error("Unable to decode a scanned frame", err)
+		}
+		got = append(got, m.Type)
+	}
+	if len(got) != 3 || got[0] != 1005 || got[1] != 1077 || got[2] != 1019 {
+		t.Error("Expected 1005, 1077, 1019, got", got)
+	}
+	if RTCM3TypeName(1077) != "GPS MSM7" {
+		t.Error("Unexpected MSM name", RTCM3TypeName(1077))
+	}
+
+	got = nil
+	sc = bufio.NewScanner(bytes.NewReader(stream))
+	sc.Split(ScanRTCM3Types(1005, 1019))
+	for sc.Scan() {
+		got = append(got, rtcm3Type(sc.Bytes()))
+	}
+	if len(got) != 2 || got[0] != 1005 || got[1] != 1019 {
+		t.Error("Expected only 1005 and 1019, got", got)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"sync"
+)
+
+var _ IDoIO = &SafeIO{}
+
+/*
+SafeIO wraps an IDoIO so that it may be shared by several goroutines.  Reads
+are serialized with one lock and writes with another, so unlike an Arbiter a
+Read waiting for data does not hold up a Write; Open and Close take both.  No
+attempt is made to pair writes with what is read in response, for which use an
+Arbiter.
+*/
+type SafeIO struct {
+	idotoo     IDoIO
+	rmux, wmux sync.Mutex
+}
+
+/*NewSafeIO returns a SafeIO over idoio*/
+func NewSafeIO(idoio IDoIO) *SafeIO {
+	return &SafeIO{idotoo: idoio}
+}
+
+/*SafeWrapper is a Wrapper (see Chain) making a SafeIO*/
+func SafeWrapper(idoio IDoIO) IDoIO { return NewSafeIO(idoio) }
+
+func (s *SafeIO) dialString() string { return dialOf(s.idotoo) }
+
+/*String conforms to fmt.Stringer*/
+func (s *SafeIO) String() string {
+	return fmt.Sprintf("SafeIO over %v", s.idotoo)
+}
+
+/*Open conforms to IDoIO, waiting for any Read and Write in progress*/
+func (s *SafeIO) Open() error {
+	s.lockBoth()
+	defer s.unlockBoth()
+	return s.idotoo.Open()
+}
+
+/*Close conforms to io.Closer, waiting for any Read and Write in progress*/
+func (s *SafeIO) Close() error {
+	s.lockBoth()
+	defer s.unlockBoth()
+	return s.idotoo.Close()
+}
+
+/*Read conforms to io.Reader, one Read at a time*/
+func (s *SafeIO) Read(b []byte) (int, error) {
+	s.rmux.Lock()
+	defer s.rmux.Unlock()
+	return s.idotoo.Read(b)
+}
+
+/*Write conforms to io.Writer, one Write at a time*/
+func (s *SafeIO) Write(b []byte) (int, error) {
+	s.wmux.Lock()
+	defer s.wmux.Unlock()
+	return s.idotoo.Write(b)
+}
+
+/*lockBoth takes the write lock and then the read lock, always in that order*/
+func (s *SafeIO) lockBoth() {
+	s.wmux.Lock()
+	s.rmux.Lock()
+}
+
+func (s *SafeIO) unlockBoth() {
+	s.rmux.Unlock()
+	s.wmux.Unlock()
+}
@@ -0,0 +1,92 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*blockingIO is a bufIO whose Reads wait for release to be closed*/
+type blockingIO struct {
+	bufIO
+	release chan struct{}
+}
+
+func (b *blockingIO) Read(p []byte) (int, error) {
+	<-b.release
+	return b.bufIO.Read(p)
+}
+
+func TestSafeIO(t *testing.T) {
+	inner := &bufIO{}
+	s := NewSafeIO(inner)
+	if !strings.HasPrefix(s.String(), "SafeIO over") || dialOf(s) != "bufIO" {
+		t.Error("Unexpected names", s, dialOf(s))
+	}
+	inner.rx.WriteString(strings.Repeat("x", 100))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s.Write([]byte("abc"))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 1)
+			for j := 0; j < 10; j++ {
+				s.Read(b)
+			}
+		}()
+	}
+	wg.Wait()
+	s.Open()
+	s.Close()
+	if inner.tx.Len() != 300 || inner.rx.Len() != 0 {
+		t.Error("Expected every read and write to complete", inner.tx.Len(), inner.rx.Len())
+	}
+
+	blocked := &blockingIO{release: make(chan struct{})}
+	s = Chain(SafeWrapper)(blocked).(*SafeIO)
+	go s.Read(make([]byte, 1))
+	time.Sleep(5 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		s.Write([]byte("while reading"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected a Write not to wait for a Read")
+	}
+	close(blocked.release)
+}
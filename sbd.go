@@ -0,0 +1,487 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &SBDClient{}
+
+/*Message size limits of the Iridium 9602/9603*/
+const (
+	SBDMaxMO = 340 //mobile originated, i.e. Write
+	SBDMaxMT = 270 //mobile terminated, i.e. Read
+)
+
+/*SBDConfig controls when an SBDClient initiates sessions*/
+type SBDConfig struct {
+	//Init is sent, without the trailing carriage return, on Open. Defaults to "ATE0&K0" (no echo or flow control).
+	Init string
+
+	/*MailboxCheck is how often a session is initiated, when nothing is queued,
+	  to collect mobile terminated messages. Defaults to 5m, negative disables.*/
+	MailboxCheck time.Duration
+
+	//RetryInterval is how long to wait after a failed session. Defaults to 30s.
+	RetryInterval time.Duration
+
+	//SessionTimeout is how long to wait for AT+SBDIX to complete. Defaults to 90s.
+	SessionTimeout time.Duration
+
+	//MaxQueue caps the number of queued writes, beyond which Write returns ErrQueueFull. Defaults to 100.
+	MaxQueue int
+}
+
+/*SBDSession is the result of an AT+SBDIX session*/
+type SBDSession struct {
+	MOStatus int //0-4 means any queued message was sent
+	MOMSN    int
+	MTStatus int //0 none waiting, 1 received, 2 mailbox check failed
+	MTMSN    int
+	MTLength int
+	MTQueued int //messages still waiting at the gateway
+}
+
+/*
+SBDClient is an Iridium 9602/9603 short burst data modem.  Each Write is a
+single mobile originated message, queued and sent (with AT+SBDWB and
+AT+SBDIX) by a background session loop; messages are sent in order, and
+retried every RetryInterval until they are.  The loop also initiates a session
+every MailboxCheck to collect mobile terminated messages, each of which is
+returned by Read as a unit (over several Reads if b is too small).
+
+The session loop runs until the context passed to NewSBDClient is done.
+*/
+type SBDClient struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	line    IDoIO
+	at      *atPort
+	cfg     SBDConfig
+	dial    string
+	log     Logger //nil means LoggerFrom(ctx)
+	wake    chan struct{}
+	ready   chan struct{}
+
+	lineMux sync.Mutex //serializes use of line
+	open    bool
+
+	mux     sync.Mutex //guards the queues
+	mo      [][]byte
+	mt      [][]byte
+	pending []byte //remainder of a message too large for the last Read
+}
+
+/*
+NewSBDClient opens the SBD modem on a serial port (8N1).  Dial should be in
+the form of "sbd://<device>:<baud>", the 9602/9603 default being 19200.
+Timeout is how long Read waits for a message.
+*/
+func NewSBDClient(ctx context.Context, timeout time.Duration, dial string) (*SBDClient, error) {
+	d, err := dialFor(dial, "sbd")
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	line, err := NewSerialClient(ctx, timeout, fmt.Sprintf("serial://%s:%d", d.Device, d.Baud))
+	if err != nil {
+		return nil, err
+	}
+	s := newSBDClient(ctx, timeout, line, SBDConfig{})
+	s.dial = dial
+	return s, s.start()
+}
+
+/*
+NewSBDClientOver uses the SBD modem reachable over line, configured by cfg.
+The SBDClient takes ownership of line.
+*/
+func NewSBDClientOver(ctx context.Context, timeout time.Duration, line IDoIO, cfg SBDConfig) (*SBDClient, error) {
+	s := newSBDClient(ctx, timeout, line, cfg)
+	return s, s.start()
+}
+
+func newSBDClient(ctx context.Context, timeout time.Duration, line IDoIO, cfg SBDConfig) *SBDClient {
+	if cfg.Init == "" {
+		cfg.Init = "ATE0&K0"
+	}
+	if cfg.MailboxCheck == 0 {
+		cfg.MailboxCheck = 5 * time.Minute
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 30 * time.Second
+	}
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = 90 * time.Second
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = 100
+	}
+	nctx, cancel := context.WithCancel(ctx)
+	return &SBDClient{
+		ctx:     nctx,
+		cancel:  cancel,
+		timeout: timeout,
+		line:    line,
+		at:      &atPort{ctx: nctx, line: line},
+		cfg:     cfg,
+		dial:    dialOf(line),
+		wake:    make(chan struct{}, 1),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+/*start opens the modem and, whether or not that succeeds, starts the session loop*/
+func (s *SBDClient) start() error {
+	err := openUnlessLazy(s.ctx, s)
+	go s.run()
+	return err
+}
+
+/*
+SetLogger overrides the Logger carried by the context this SBDClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (s *SBDClient) SetLogger(l Logger) {
+	s.log = l
+}
+
+func (s *SBDClient) dialString() string { return s.dial }
+
+func (s *SBDClient) logger() Logger {
+	if s.log != nil {
+		return s.log
+	}
+	return LoggerFrom(s.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (s *SBDClient) String() string {
+	return fmt.Sprintf("SBD modem via %v (%d queued)", s.line, s.Pending())
+}
+
+/*Pending returns the number of queued writes*/
+func (s *SBDClient) Pending() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.mo)
+}
+
+/*Open (re)opens the line to the modem and initializes it*/
+func (s *SBDClient) Open() error {
+	select {
+	case <-s.ctx.Done():
+		return newErr(false, false, s.ctx.Err())
+	default:
+	}
+	s.lineMux.Lock()
+	defer s.lineMux.Unlock()
+	s.open = false
+	if err := s.line.Open(); err != nil {
+		return err
+	}
+	s.at.take()
+	for _, cmd := range []string{"AT", s.cfg.Init} {
+		if _, err := s.at.command(cmd, 5*time.Second, atOK); err != nil {
+			return newErr(false, false, errors.Wrap(err, "unable to initialize SBD modem"))
+		}
+	}
+	s.open = true
+	s.logger().Debug("SBD modem opened", "event", EventConnect, "dial", s.dial)
+	s.nudge()
+	return nil
+}
+
+/*Close conforms to io.Closer. Queued writes are kept, and sent once reopened.*/
+func (s *SBDClient) Close() error {
+	s.lineMux.Lock()
+	defer s.lineMux.Unlock()
+	s.open = false
+	s.logger().Debug("SBD modem closed", "event", EventDisconnect, "dial", s.dial)
+	return s.line.Close()
+}
+
+/*
+Write conforms to io.Writer, queueing b as a single message.  Messages larger
+than SBDMaxMO are refused, as are any beyond the configured MaxQueue
+(with ErrQueueFull).
+*/
+func (s *SBDClient) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if len(b) > SBDMaxMO {
+		return 0, newErr(false, false, fmt.Errorf("%d bytes exceeds the SBD limit of %d", len(b), SBDMaxMO))
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.mo) >= s.cfg.MaxQueue {
+		return 0, ErrQueueFull
+	}
+	s.mo = append(s.mo, cloneBytes(b))
+	s.nudge()
+	return len(b), nil
+}
+
+/*
+Read conforms to io.Reader, returning the next mobile terminated message, or
+a timeout error if none arrives within the timeout (if it is positive)
+*/
+func (s *SBDClient) Read(b []byte) (int, error) {
+	var expiry <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		expiry = timer.C
+	}
+	for {
+		s.mux.Lock()
+		if len(s.pending) == 0 && len(s.mt) > 0 {
+			s.pending, s.mt = s.mt[0], s.mt[1:]
+		}
+		if len(s.pending) > 0 {
+			n := copy(b, s.pending)
+			s.pending = s.pending[n:]
+			s.mux.Unlock()
+			return n, nil
+		}
+		s.mux.Unlock()
+		select {
+		case <-s.ctx.Done():
+			return 0, newErr(false, false, s.ctx.Err())
+		case <-expiry:
+			return 0, newErr(true, true, errors.New("no SBD message received"))
+		case <-s.ready:
+		}
+	}
+}
+
+/*nudge wakes the session loop*/
+func (s *SBDClient) nudge() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*run initiates sessions whenever there is something to send, and to check the mailbox*/
+func (s *SBDClient) run() {
+	failed, more := false, false
+	for {
+		wait := s.cfg.MailboxCheck
+		switch {
+		case failed:
+			wait = s.cfg.RetryInterval
+		case more || s.Pending() > 0:
+			wait = 0
+		}
+		wake := s.wake
+		if failed {
+			wake = nil //only the retry interval ends a backoff
+		}
+		var timer *time.Timer
+		var expiry <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			expiry = timer.C
+		}
+		select {
+		case <-s.ctx.Done():
+		case <-wake:
+		case <-expiry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		var err error
+		more, err = s.session()
+		failed = err != nil
+		if failed {
+			s.logger().Warn("SBD session failed", "event", EventError, "dial", s.dial, "error", err, "queued", s.Pending())
+		}
+	}
+}
+
+/*session sends the oldest queued message, if any, and collects any message waiting*/
+func (s *SBDClient) session() (bool, error) {
+	s.lineMux.Lock()
+	defer s.lineMux.Unlock()
+	if !s.open {
+		return false, newErr(false, false, errors.New("SBD modem is not open"))
+	}
+	s.mux.Lock()
+	var msg []byte
+	if len(s.mo) > 0 {
+		msg = s.mo[0]
+	}
+	s.mux.Unlock()
+	if msg != nil {
+		if err := s.writeMO(msg); err != nil {
+			return false, err
+		}
+	}
+	lines, err := s.at.command("AT+SBDIX", s.cfg.SessionTimeout, atOK)
+	if err != nil {
+		return false, err
+	}
+	sess, err := parseSBDIX(lines)
+	if err != nil {
+		return false, err
+	}
+	s.logger().Debug("SBD session", "event", EventCommand, "dial", s.dial, "mo", sess.MOStatus, "mt", sess.MTStatus, "waiting", sess.MTQueued)
+	var moErr error
+	if msg != nil && sess.MOStatus > 4 {
+		moErr = newErr(true, false, fmt.Errorf("SBD session failed with MO status %d", sess.MOStatus))
+	} else if msg != nil {
+		s.mux.Lock()
+		s.mo = s.mo[1:]
+		s.mux.Unlock()
+		if _, err := s.at.command("AT+SBDD0", 5*time.Second, atOK); err != nil { //so a mailbox check does not resend it
+			return false, err
+		}
+	}
+	switch sess.MTStatus {
+	case 1: //collected even if sending failed
+		if err := s.readMT(); err != nil {
+			return false, err
+		}
+	case 2:
+		return false, newErr(true, false, errors.New("SBD mailbox check failed"))
+	}
+	if moErr != nil {
+		return false, moErr
+	}
+	return sess.MTQueued > 0, nil
+}
+
+/*writeMO loads msg into the modem's mobile originated buffer*/
+func (s *SBDClient) writeMO(msg []byte) error {
+	if _, err := s.at.command(fmt.Sprintf("AT+SBDWB=%d", len(msg)), 5*time.Second, func(line string) (bool, error) {
+		return atOK(strings.Replace(line, "READY", "OK", 1))
+	}); err != nil {
+		return err
+	}
+	var sum [2]byte
+	binary.BigEndian.PutUint16(sum[:], sbdChecksum(msg))
+	if _, err := s.line.Write(append(cloneBytes(msg), sum[:]...)); err != nil {
+		return err
+	}
+	lines, err := s.at.await("AT+SBDWB", 5*time.Second, atOK)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 || lines[len(lines)-1] != "0" {
+		return newErr(false, false, fmt.Errorf("SBD modem refused the message: %q", lines))
+	}
+	return nil
+}
+
+/*readMT reads the modem's mobile terminated buffer onto the read queue*/
+func (s *SBDClient) readMT() error {
+	if _, err := s.line.Write([]byte("AT+SBDRB\r")); err != nil {
+		return err
+	}
+	hdr, err := s.at.readN("AT+SBDRB", 2, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	body, err := s.at.readN("AT+SBDRB", int(binary.BigEndian.Uint16(hdr))+2, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	msg, sum := body[:len(body)-2], binary.BigEndian.Uint16(body[len(body)-2:])
+	if _, err := s.at.await("AT+SBDRB", 5*time.Second, atOK); err != nil {
+		return err
+	}
+	if sbdChecksum(msg) != sum {
+		return newErr(true, false, errors.New("SBD message failed its checksum"))
+	}
+	s.mux.Lock()
+	s.mt = append(s.mt, msg)
+	s.mux.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+/*sbdChecksum is the least significant 16 bits of the sum of b*/
+func sbdChecksum(b []byte) uint16 {
+	var sum uint16
+	for _, v := range b {
+		sum += uint16(v)
+	}
+	return sum
+}
+
+/*parseSBDIX finds and decodes the +SBDIX response among lines*/
+func parseSBDIX(lines []string) (SBDSession, error) {
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "+SBDIX:") {
+			continue
+		}
+		f := strings.Split(strings.TrimPrefix(line, "+SBDIX:"), ",")
+		if len(f) != 6 {
+			break
+		}
+		v := make([]int, 6)
+		for i := range f {
+			var err error
+			if v[i], err = strconv.Atoi(strings.TrimSpace(f[i])); err != nil {
+				return SBDSession{}, newErr(false, false, fmt.Errorf("malformed %q", line))
+			}
+		}
+		return SBDSession{MOStatus: v[0], MOMSN: v[1], MTStatus: v[2], MTMSN: v[3], MTLength: v[4], MTQueued: v[5]}, nil
+	}
+	return SBDSession{}, newErr(false, false, fmt.Errorf("no +SBDIX response in %q", lines))
+}
+
+/*atOK is the final result check for plain AT commands*/
+func atOK(line string) (bool, error) {
+	switch line {
+	case "OK":
+		return true, nil
+	case "ERROR":
+		return true, newErr(false, false, errors.New("modem: error"))
+	}
+	return false, nil
+}
@@ -0,0 +1,164 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+sbdHandler emulates a 9602 with one MT message waiting.  The first session
+fails to send, and every MO message received is passed to mo.
+*/
+func sbdHandler(mo chan<- string) respHandler {
+	return func(t *testing.T, con net.Conn) {
+		defer con.Close()
+		r := bufio.NewReader(con)
+		var buffer []byte
+		sessions := 0
+		mt := []byte("hello from the gateway")
+		for {
+			cmd, err := r.ReadString('\r')
+			if err != nil {
+				return
+			}
+			cmd = strings.TrimSpace(cmd)
+			switch {
+			case strings.HasPrefix(cmd, "AT+SBDWB="):
+				n, _ := strconv.Atoi(strings.TrimPrefix(cmd, "AT+SBDWB="))
+				fmt.Fprint(con, "READY\r\n")
+				b := make([]byte, n+2)
+				io.ReadFull(r, b)
+				if sbdChecksum(b[:n]) != binary.BigEndian.Uint16(b[n:]) {
+					fmt.Fprint(con, "2\r\n\r\nOK\r\n")
+					continue
+				}
+				buffer = b[:n]
+				fmt.Fprint(con, "0\r\n\r\nOK\r\n")
+			case cmd == "AT+SBDIX":
+				sessions++
+				moStatus := 0
+				if sessions == 1 && buffer != nil {
+					moStatus = 32
+				} else if buffer != nil {
+					mo <- string(buffer)
+				}
+				mtStatus, mtLen := 0, 0
+				if mt != nil {
+					mtStatus, mtLen = 1, len(mt)
+				}
+				fmt.Fprintf(con, "+SBDIX: %d, %d, %d, 0, %d, 0\r\n\r\nOK\r\n", moStatus, sessions, mtStatus, mtLen)
+			case cmd == "AT+SBDD0":
+				buffer = nil
+				fmt.Fprint(con, "0\r\n\r\nOK\r\n")
+			case cmd == "AT+SBDRB":
+				var hdr, sum [2]byte
+				binary.BigEndian.PutUint16(hdr[:], uint16(len(mt)))
+				binary.BigEndian.PutUint16(sum[:], sbdChecksum(mt))
+				con.Write(append(append(hdr[:], mt...), sum[:]...))
+				fmt.Fprint(con, "\r\nOK\r\n")
+				mt = nil
+			default:
+				fmt.Fprint(con, "\r\nOK\r\n")
+			}
+		}
+	}
+}
+
+func TestSBDClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	mo := make(chan string, 4)
+	newTCPSvr(ctx, t, "tcp", srvdial, sbdHandler(mo))
+
+	line, _ := NewNetClient(ctx, 50*time.Millisecond, dial)
+	s, err := NewSBDClientOver(ctx, time.Second, line, SBDConfig{RetryInterval: 20 * time.Millisecond, MailboxCheck: -1, MaxQueue: 2})
+	if err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	defer s.Close()
+
+	if _, err := s.Write(make([]byte, SBDMaxMO+1)); err == nil {
+		t.Error("Expected an oversized message to be refused")
+	}
+	for _, m := range []string{"first", "second"} {
+		if n, err := s.Write([]byte(m)); err != nil || n != len(m) {
+			t.Error("Unable to queue", m, err)
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-mo:
+			if got != want {
+				t.Error("Expected", want, "got", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for", want)
+		}
+	}
+
+	buf := make([]byte, 8)
+	var got []byte
+	for len(got) < len("hello from the gateway") {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatal("Expected the MT message", err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "hello from the gateway" {
+		t.Errorf("Unexpected MT message %q", got)
+	}
+	for deadline := time.Now().Add(time.Second); s.Pending() != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Error("Expected nothing queued", s.Pending())
+			break
+		}
+	}
+	s.timeout = 10 * time.Millisecond
+	if _, err := s.Read(buf); !IsTimeout(err) {
+		t.Error("Expected a timeout without messages", err)
+	}
+}
+
+func TestParseSBDIX(t *testing.T) {
+	sess, err := parseSBDIX([]string{"junk", "+SBDIX: 0, 12, 1, 5, 22, 3"})
+	if err != nil || sess != (SBDSession{0, 12, 1, 5, 22, 3}) {
+		t.Error("Unexpected session", sess, err)
+	}
+	if _, err := parseSBDIX([]string{"+SBDIX: 0, x, 1, 5, 22, 3"}); err == nil {
+		t.Error("Expected a malformed response to be refused")
+	}
+}
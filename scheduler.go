@@ -0,0 +1,429 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*Schedule determines when a Job runs*/
+type Schedule interface {
+	//Next returns the first activation time strictly after t
+	Next(t time.Time) time.Time
+}
+
+/*Every returns a Schedule that activates every d, aligned to multiples of d since the zero time*/
+func Every(d time.Duration) Schedule { return every(d) }
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	d := time.Duration(e)
+	if d <= 0 {
+		d = time.Second
+	}
+	return t.Truncate(d).Add(d)
+}
+
+/*
+cron is a parsed 5 field cron specification; each field is a bitmask of the
+permitted values
+*/
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+/*
+ParseSchedule parses a cron-like specification. It accepts the standard 5 field
+form
+
+	minute hour day-of-month month day-of-week
+
+where each field is *, a value, a range (1-5), a list (1,3,5), or any of these
+with a step (0-59/15, 1-31/2).  Days of the week run 0-6 from Sunday, and 7 is
+also accepted as Sunday.  When both day fields are restricted, either matching
+suffices, as with cron(8).  The aliases @yearly, @monthly, @weekly, @daily,
+@hourly and "@every <duration>" are also accepted.
+
+Schedules are evaluated in the location of the time they are handed, which for
+a Scheduler is UTC.
+*/
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d <= 0 {
+			return nil, newErr(false, false, fmt.Errorf("invalid schedule %q", spec))
+		}
+		return Every(d), nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, newErr(false, false, fmt.Errorf("invalid schedule %q: expected 5 fields", spec))
+	}
+	c := &cron{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	masks := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		m, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, newErr(false, false, errors.Wrapf(err, "invalid schedule %q", spec))
+		}
+		*masks[i] = m
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+/*parseCronField parses a single cron field into a bitmask*/
+func parseCronField(f string, min, max int) (mask uint64, err error) {
+	for _, part := range strings.Split(f, ",") {
+		lo, hi, step := min, max, 1
+		rng := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng = part[:i]
+		}
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			ends := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(ends[0]); err != nil {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+			if hi, err = strconv.Atoi(ends[1]); err != nil {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+		default:
+			if lo, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			if step == 1 {
+				hi = lo
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+/*Next conforms to Schedule, returning the zero time if no activation occurs within 5 years*/
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+/*Step is a Command, and its arguments, run as part of a Job*/
+type Step struct {
+	Command Command
+	Args    []interface{}
+}
+
+/*Job is a named sequence of Steps run against a registered Arbiter on a Schedule*/
+type Job struct {
+	Name     string
+	Arbiter  string        //name the Arbiter was registered with via Scheduler.AddArbiter
+	Steps    []Step        //run in order, stopping at the first failure
+	Schedule Schedule      //see Every and ParseSchedule
+	Jitter   time.Duration //each run is delayed by a random amount up to Jitter
+
+	//Handler, if not nil, receives the result of every run (or skipped run)
+	Handler func(JobResult)
+}
+
+/*JobResult is the outcome of a single run of a Job*/
+type JobResult struct {
+	Job       string
+	Scheduled time.Time  //when the run was due, before jitter
+	Started   time.Time  //zero if Skipped
+	Finished  time.Time  //zero if Skipped
+	Responses []Response //one per Step run
+	Error     error      //the first error encountered, if any
+	Skipped   bool       //true if the run was skipped because the previous run had not finished
+}
+
+/*String conforms to fmt.Stringer*/
+func (jr JobResult) String() string {
+	switch {
+	case jr.Skipped:
+		return fmt.Sprintf("%s due %s: skipped, still running", jr.Job, jr.Scheduled.Format(time.RFC3339))
+	case jr.Error != nil:
+		return fmt.Sprintf("%s due %s: failed after %d steps in %v: %v", jr.Job, jr.Scheduled.Format(time.RFC3339), len(jr.Responses), jr.Finished.Sub(jr.Started), jr.Error)
+	default:
+		return fmt.Sprintf("%s due %s: ok, %d steps in %v", jr.Job, jr.Scheduled.Format(time.RFC3339), len(jr.Responses), jr.Finished.Sub(jr.Started))
+	}
+}
+
+type schedJob struct {
+	Job
+	cancel  context.CancelFunc
+	mux     sync.Mutex
+	running bool
+}
+
+/*
+Scheduler runs Jobs against named Arbiters on cron-like or fixed interval
+Schedules, so routine tasks (e.g. zeroing a sensor at 00:00 UTC) can live next
+to the code that talks to the device rather than in external cron scripts.
+
+A Job never overlaps itself: if a run is still in progress when the next is
+due, the new run is skipped and reported to the Job's Handler as Skipped.
+Jobs run until they are removed or the Scheduler's context is done.
+*/
+type Scheduler struct {
+	ctx  context.Context
+	mux  sync.Mutex
+	arbs map[string]Arbiter
+	jobs map[string]*schedJob
+}
+
+/*NewScheduler returns an empty Scheduler that runs until ctx is done*/
+func NewScheduler(ctx context.Context) *Scheduler {
+	return &Scheduler{ctx: ctx, arbs: map[string]Arbiter{}, jobs: map[string]*schedJob{}}
+}
+
+/*AddArbiter registers arb under name for use by Jobs, replacing any existing registration*/
+func (s *Scheduler) AddArbiter(name string, arb Arbiter) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.arbs[name] = arb
+}
+
+/*RemoveArbiter removes the named Arbiter; Jobs referencing it will fail until it is re-added*/
+func (s *Scheduler) RemoveArbiter(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.arbs, name)
+}
+
+/*Add starts running job, replacing any existing Job of the same name*/
+func (s *Scheduler) Add(job Job) error {
+	switch {
+	case job.Name == "":
+		return newErr(false, false, fmt.Errorf("a job name is required"))
+	case job.Schedule == nil:
+		return newErr(false, false, fmt.Errorf("job %q has no schedule", job.Name))
+	case len(job.Steps) == 0:
+		return newErr(false, false, fmt.Errorf("job %q has no steps", job.Name))
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sj := &schedJob{Job: job, cancel: cancel}
+	s.mux.Lock()
+	if old, ok := s.jobs[job.Name]; ok {
+		old.cancel()
+	}
+	s.jobs[job.Name] = sj
+	s.mux.Unlock()
+	go s.loop(ctx, sj)
+	return nil
+}
+
+/*Remove stops the named Job. A run in progress is allowed to finish.*/
+func (s *Scheduler) Remove(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if sj, ok := s.jobs[name]; ok {
+		sj.cancel()
+		delete(s.jobs, name)
+	}
+}
+
+/*Jobs returns the names of the scheduled Jobs, sorted*/
+func (s *Scheduler) Jobs() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*Next returns when the named Job is next due, and false if there is no such Job*/
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	sj, ok := s.jobs[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return sj.Schedule.Next(time.Now().UTC()), true
+}
+
+/*RunNow runs the named Job immediately, outside of its Schedule, and waits for the result*/
+func (s *Scheduler) RunNow(name string) (JobResult, error) {
+	s.mux.Lock()
+	sj, ok := s.jobs[name]
+	s.mux.Unlock()
+	if !ok {
+		return JobResult{}, newErr(false, false, fmt.Errorf("no job named %q", name))
+	}
+	return s.fire(sj, time.Now().UTC(), 0), nil
+}
+
+/*loop waits for each activation of sj's Schedule and fires it*/
+func (s *Scheduler) loop(ctx context.Context, sj *schedJob) {
+	for {
+		due := sj.Schedule.Next(time.Now().UTC())
+		if due.IsZero() {
+			return
+		}
+		wait := time.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return
+		case <-wait.C:
+		}
+		var jitter time.Duration
+		if sj.Jitter > 0 {
+			jitter = time.Duration(rand.Int63n(int64(sj.Jitter)))
+		}
+		go s.fire(sj, due, jitter)
+	}
+}
+
+/*fire runs sj after the jitter, unless it is already running, and delivers the result*/
+func (s *Scheduler) fire(sj *schedJob, due time.Time, jitter time.Duration) JobResult {
+	res := JobResult{Job: sj.Name, Scheduled: due}
+	sj.mux.Lock()
+	if sj.running {
+		sj.mux.Unlock()
+		res.Skipped = true
+		LoggerFrom(s.ctx).Warn("job still running, skipping", "event", EventCommand, "job", sj.Name)
+		s.deliver(sj, res)
+		return res
+	}
+	sj.running = true
+	sj.mux.Unlock()
+	defer func() {
+		sj.mux.Lock()
+		sj.running = false
+		sj.mux.Unlock()
+	}()
+
+	if jitter > 0 {
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(jitter):
+		}
+	}
+	res.Started = time.Now().UTC()
+	s.mux.Lock()
+	arb, ok := s.arbs[sj.Arbiter]
+	s.mux.Unlock()
+	switch {
+	case s.ctx.Err() != nil:
+		res.Error = newErr(false, false, s.ctx.Err())
+	case !ok:
+		res.Error = newErr(false, false, fmt.Errorf("no arbiter named %q", sj.Arbiter))
+	default:
+		for _, step := range sj.Steps {
+			rsp := arb.Control(step.Command, step.Args...)
+			res.Responses = append(res.Responses, rsp)
+			if rsp.Error != nil {
+				res.Error = rsp.Error
+				break
+			}
+		}
+	}
+	res.Finished = time.Now().UTC()
+	if res.Error != nil {
+		LoggerFrom(s.ctx).Warn("job failed", "event", EventError, "job", sj.Name, "error", res.Error)
+	} else {
+		LoggerFrom(s.ctx).Debug("job ran", "event", EventCommand, "job", sj.Name, "duration", res.Finished.Sub(res.Started))
+	}
+	s.deliver(sj, res)
+	return res
+}
+
+func (s *Scheduler) deliver(sj *schedJob, res JobResult) {
+	if sj.Handler != nil {
+		sj.Handler(res)
+	}
+}
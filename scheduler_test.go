@@ -0,0 +1,159 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC) //a Sunday
+	for _, tc := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2018, 3, 4, 5, 7, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2018, 3, 4, 6, 0, 0, 0, time.UTC)},
+		{"0-59/15 * * * *", time.Date(2018, 3, 4, 5, 15, 0, 0, time.UTC)},
+		{"30 12 1,15 * *", time.Date(2018, 3, 15, 12, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2018, 3, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2018, 3, 9, 0, 0, 0, 0, time.UTC)}, //13th or Friday
+		{"@every 10m", time.Date(2018, 3, 4, 5, 10, 0, 0, time.UTC)},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Error("Unable to parse", tc.spec, err)
+			continue
+		}
+		if next := s.Next(base); !next.Equal(tc.next) {
+			t.Errorf("%q: expected %v, got %v", tc.spec, tc.next, next)
+		}
+	}
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "@every -1s", "a b c d e"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if s, _ := ParseSchedule("0 0 31 2 *"); !s.Next(base).IsZero() {
+		t.Error("Expected an impossible schedule to never activate")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	a, err := NewArbiter(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Error("Unable to dial", err)
+		t.FailNow()
+	}
+	defer a.Close()
+
+	s := NewScheduler(ctx)
+	s.AddArbiter("dev", a)
+	if err := s.Add(Job{Name: "empty", Schedule: Every(time.Second)}); err == nil {
+		t.Error("Expected a job without steps to be rejected")
+	}
+
+	results := make(chan JobResult, 16)
+	err = s.Add(Job{
+		Name:     "ok",
+		Arbiter:  "dev",
+		Steps:    []Step{{Command: arbCmdOk}, {Command: arbCmdOk}},
+		Schedule: Every(50 * time.Millisecond),
+		Jitter:   5 * time.Millisecond,
+		Handler:  func(jr JobResult) { results <- jr },
+	})
+	if err != nil {
+		t.Error("Unable to add job", err)
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 || jobs[0] != "ok" {
+		t.Error("Unexpected jobs", jobs)
+	}
+	if _, ok := s.Next("ok"); !ok {
+		t.Error("Expected the job to be scheduled")
+	}
+	select {
+	case jr := <-results:
+		t.Log(jr)
+		if jr.Error != nil || len(jr.Responses) != 2 || jr.Skipped {
+			t.Error("Expected both steps to succeed", jr)
+		}
+	case <-time.After(time.Second):
+		t.Error("Job never ran")
+	}
+	s.Remove("ok")
+
+	jr, err := s.RunNow("ok")
+	if err == nil {
+		t.Error("Expected a removed job to be gone", jr)
+	}
+	s.Add(Job{Name: "bad", Arbiter: "dev", Steps: []Step{{Command: arbCmdError}, {Command: arbCmdOk}}, Schedule: Every(time.Hour)})
+	if jr, _ = s.RunNow("bad"); jr.Error == nil || len(jr.Responses) != 1 {
+		t.Error("Expected the job to stop at the failed step", jr)
+	}
+	s.Add(Job{Name: "lost", Arbiter: "nope", Steps: []Step{{Command: arbCmdOk}}, Schedule: Every(time.Hour)})
+	if jr, _ = s.RunNow("lost"); jr.Error == nil {
+		t.Error("Expected an unknown arbiter to fail", jr)
+	}
+}
+
+func TestScheduler_Overlap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	a, _ := NewArbiter(ctx, 100*time.Millisecond, dial)
+	defer a.Close()
+
+	s := NewScheduler(ctx)
+	s.AddArbiter("dev", a)
+	results := make(chan JobResult, 64)
+	//each run takes ~arbCmdTimeout.Timeout, far longer than the interval
+	s.Add(Job{
+		Name:     "slow",
+		Arbiter:  "dev",
+		Steps:    []Step{{Command: arbCmdTimeout}},
+		Schedule: Every(20 * time.Millisecond),
+		Handler:  func(jr JobResult) { results <- jr },
+	})
+	skipped := false
+	deadline := time.After(2 * time.Second)
+	for !skipped {
+		select {
+		case jr := <-results:
+			skipped = jr.Skipped
+		case <-deadline:
+			t.Error("Expected overlapping runs to be skipped")
+			return
+		}
+	}
+}
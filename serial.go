@@ -28,8 +28,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"regexp"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -37,51 +40,220 @@ import (
 )
 
 var _ IDoIO = &SerialClient{}
-var serialRe = regexp.MustCompile("^rs232|serial:\\/\\/([^:]*):([0-9]*)$")
+var _ ModemController = &SerialClient{}
+var _ Flusher = &SerialClient{}
+var _ Drainer = &SerialClient{}
+var _ ContextReader = &SerialClient{}
+
+var (
+	serialParities = map[string]serial.Parity{"none": serial.NoParity, "odd": serial.OddParity, "even": serial.EvenParity, "mark": serial.MarkParity, "space": serial.SpaceParity}
+	serialStopBits = map[string]serial.StopBits{"1": serial.OneStopBit, "1.5": serial.OnePointFiveStopBits, "2": serial.TwoStopBits}
+)
+
+/*serialOpen opens a serial device, swapped in tests*/
+var serialOpen = serial.Open
+
+/*
+ModemController is implemented by IDoIOs whose modem control lines can be
+driven and read, such as a SerialClient.  Discover it with a type assertion.
+*/
+type ModemController interface {
+	SetDTR(dtr bool) error
+	SetRTS(rts bool) error
+	ModemStatus() (*serial.ModemStatusBits, error)
+}
 
 /*SerialClient wraps around a serial port*/
 type SerialClient struct {
-	ctx     context.Context
-	cancel  context.CancelFunc
-	timeout time.Duration
+	connStats
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	timeout   time.Duration
 	rwtimeout time.Duration
-	mode    *serial.Mode
-	dev     string
-	conn    serial.Port
+	mode      *serial.Mode
+	dev       string
+	vid, pid  string //non-empty when dev is found by USB vid:pid at Open
+	serialNo  string //optional USB serial number narrowing vid:pid
+	flow      string //one of the Flow* constants
+	rs485     string //one of the RS485* constants, or empty for RS-232
+	rtsBefore time.Duration
+	rtsAfter  time.Duration
+	hotplug   time.Duration //how often to look for the device, 0 to not watch
+	gone      atomic.Bool   //the device was last seen unplugged
+	unwatch   func()        //stops the watcher, nil if there is none
+	dial      string
+	conn      serial.Port
+	log       Logger //nil means LoggerFrom(ctx)
 }
 
 /*
-NewSerialClient opens a connection to a serial device in 8N1 mode.
-Dial should be in the form of "serial://<device>:<baud>
+NewSerialClient opens a connection to a serial device.
+Dial should be in the form of "serial://<device>:<baud> (eg serial:///dev/ttyUSB0:9600
+or serial://COM12:9600), or
+"serial://vid:pid=<vid>:<pid>[:<serial number>]:<baud>" to use whichever
+port belongs to that USB device.  The device is looked up on every Open, so
+an adapter that is replugged and renamed is found again.  Either may be
+followed by ?databits=<5-8>&parity=<none|odd|even|mark|space>&stopbits=<1|1.5|2>&flow=<none|xonxoff|rtscts>,
+which default to 8N1 without flow control.
+
+For half-duplex RS-485 add rs485=<rts|kernel>, optionally with
+rtsbefore=<duration>&rtsafter=<duration> to hold the transmitter on around
+each Write.  With rts the transmitter is keyed by raising RTS from here, and
+Write returns once the bytes have left the port; kernel leaves it to a driver
+with RS-485 support (linux only).
+
+USB adapters that get unplugged can be watched for with hotplug=<duration>,
+the interval at which the device is looked for.  While it is missing Read and
+Write fail permanently without trying to reopen it, and once it is back they
+reopen it as usual.
 */
 func NewSerialClient(ctx context.Context, timeout time.Duration, dial string) (*SerialClient, error) {
-	if !serialRe.MatchString(dial) {
+	d, err := dialFor(dial, "serial", "rs232")
+	if err != nil {
+		return nil, err
+	}
+	var dev, vid, pid, serialNo string
+	if usb, ok := strings.CutPrefix(d.Device, "vid:pid="); ok {
+		ids := strings.Split(usb, ":")
+		if len(ids) < 2 || len(ids) > 3 || !isUSBID(ids[0]) || !isUSBID(ids[1]) || len(ids) == 3 && ids[2] == "" {
+			return nil, newErr(false, false, fmt.Errorf("dial string not in correct form"))
+		}
+		vid, pid = ids[0], ids[1]
+		if len(ids) == 3 {
+			serialNo = ids[2]
+		}
+	} else if strings.Contains(d.Device, ":") {
 		return nil, newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	} else {
+		dev = serialDevice(d.Device)
 	}
-	matches := serialRe.FindAllStringSubmatch(dial, -1) //capture groups used
-	i, _ := strconv.ParseInt(matches[0][2], 10, 64)
 	nctx, cancel := context.WithCancel(ctx)
 
 	sc := &SerialClient{
-		ctx:     nctx,
-		cancel:  cancel,
-		timeout: timeout,
+		ctx:       nctx,
+		cancel:    cancel,
+		timeout:   timeout,
 		rwtimeout: 1 * time.Millisecond,
 		mode: &serial.Mode{
-			BaudRate: int(i),
+			BaudRate: d.Baud,
 			DataBits: 8,
 			Parity:   serial.NoParity,
 			StopBits: serial.OneStopBit,
 		},
-		dev:  matches[0][1],
-		conn: nil,
+		dev:      dev,
+		vid:      vid,
+		pid:      pid,
+		serialNo: serialNo,
+		dial:     dial,
+		conn:     nil,
+	}
+	if err := sc.parse(d.Params.Encode()); err != nil {
+		cancel()
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	sc.instrument(ctx, dial, sc.logger)
+	if sc.hotplug > 0 {
+		sc.gone.Store(!sc.present())
+		sc.startWatching()
+	}
+	return sc, openUnlessLazy(ctx, sc)
+}
+
+/*parse applies the query portion of a serial:// dial string*/
+func (sc *SerialClient) parse(query string) error {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for k, v := range q {
+		known, err := parseSerialMode(sc.mode, k, v[0])
+		switch {
+		case known:
+		case k == "flow":
+			if _, ok := rfc2217Flow[v[0]]; !ok {
+				err = fmt.Errorf("invalid %s %q", k, v[0])
+			}
+			sc.flow = v[0]
+		case k == "rs485":
+			if sc.rs485 = v[0]; sc.rs485 != RS485RTS && sc.rs485 != RS485Kernel {
+				err = fmt.Errorf("invalid %s %q", k, v[0])
+			}
+		case k == "rtsbefore":
+			sc.rtsBefore, err = time.ParseDuration(v[0])
+		case k == "rtsafter":
+			sc.rtsAfter, err = time.ParseDuration(v[0])
+		case k == "hotplug":
+			if sc.hotplug, err = time.ParseDuration(v[0]); err == nil && sc.hotplug <= 0 {
+				err = fmt.Errorf("invalid %s %q", k, v[0])
+			}
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+parseSerialMode applies a databits, parity or stopbits dial string parameter
+to mode, reporting whether k was one of them.
+*/
+func parseSerialMode(mode *serial.Mode, k, v string) (known bool, err error) {
+	ok := true
+	switch k {
+	case "databits":
+		mode.DataBits, err = strconv.Atoi(v)
+		ok = mode.DataBits >= 5 && mode.DataBits <= 8
+	case "parity":
+		mode.Parity, ok = serialParities[v]
+	case "stopbits":
+		mode.StopBits, ok = serialStopBits[v]
+	default:
+		return false, nil
+	}
+	if err == nil && !ok {
+		err = fmt.Errorf("invalid %s %q", k, v)
+	}
+	return true, err
+}
+
+/*serialFraming describes mode in the usual shorthand, eg 8N1 or 7E2*/
+func serialFraming(mode *serial.Mode) string {
+	stop := map[serial.StopBits]string{serial.OneStopBit: "1", serial.OnePointFiveStopBits: "1.5", serial.TwoStopBits: "2"}
+	parity := byte('?')
+	if mode.Parity >= serial.NoParity && mode.Parity <= serial.SpaceParity {
+		parity = "NOEMS"[mode.Parity]
+	}
+	return fmt.Sprintf("%d%c%s", mode.DataBits, parity, stop[mode.StopBits])
+}
+
+/*
+SetLogger overrides the Logger carried by the context this SerialClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (sc *SerialClient) SetLogger(l Logger) {
+	sc.log = l
+}
+
+func (sc *SerialClient) dialString() string { return sc.dial }
+
+func (sc *SerialClient) logger() Logger {
+	if sc.log != nil {
+		return sc.log
 	}
-	return sc, sc.Open()
+	return LoggerFrom(sc.ctx)
 }
 
 /*String conforms to the fmt.Stringer interface*/
 func (sc *SerialClient) String() string {
-	return fmt.Sprintf("serial connection to %v:%d 8N1", sc.dev, sc.mode.BaudRate)
+	if sc.vid != "" {
+		return fmt.Sprintf("serial connection to %v (usb %s:%s):%d %s", sc.dev, sc.vid, sc.pid, sc.mode.BaudRate, serialFraming(sc.mode))
+	}
+	return fmt.Sprintf("serial connection to %v:%d %s", sc.dev, sc.mode.BaudRate, serialFraming(sc.mode))
 }
 
 /*
@@ -89,19 +261,51 @@ Open forcible closes any previously open ports (ignore errors) the network conne
 attempts the connect process again.  It returns an error if it was unable to start
 */
 func (sc *SerialClient) Open() (err error) {
+	sc.opening()
+	defer func() { sc.opened(err) }()
 	select {
 	case <-sc.ctx.Done():
 		return newErr(false, false, sc.ctx.Err())
 	default:
 	}
+	sc.startWatching()
 	if sc.conn != nil {
 		sc.conn.Close()
 		sc.conn = nil
 	}
-	if sc.conn, err = serial.Open(sc.dev, sc.mode); err != nil {
+	if sc.vid != "" {
+		var dev string
+		if dev, err = findUSBSerial(sc.vid, sc.pid, sc.serialNo); err != nil {
+			sc.logger().Warn("unable to find serial device", "event", EventError, "dial", sc.dial, "error", err)
+			return newErr(true, false, err)
+		}
+		sc.dev = dev
+	}
+	if sc.conn, err = serialOpen(sc.dev, sc.mode); err != nil {
+		sc.conn = nil //serial.Open hands back a typed nil on failure
+		sc.logger().Warn("unable to open serial device", "event", EventError, "dial", sc.dial, "error", err)
 		return newErr(false, false, errors.Wrapf(err, "unable to open serial device %q", sc.dev))
 	}
+	if sc.flow != "" && sc.flow != FlowNone {
+		if err = serialFlow(sc.conn, sc.flow); err != nil {
+			sc.conn.Close()
+			sc.conn = nil
+			sc.logger().Warn("unable to set serial flow control", "event", EventError, "dial", sc.dial, "error", err)
+			return newErr(false, false, errors.Wrapf(err, "unable to set %s flow control on %q", sc.flow, sc.dev))
+		}
+	}
+	if sc.rs485 == RS485Kernel {
+		if err = serialRS485(sc.conn, sc.rtsBefore, sc.rtsAfter); err != nil {
+			sc.conn.Close()
+			sc.conn = nil
+			sc.logger().Warn("unable to set RS-485 mode", "event", EventError, "dial", sc.dial, "error", err)
+			return newErr(false, false, errors.Wrapf(err, "unable to set RS-485 mode on %q", sc.dev))
+		}
+	}
 	sc.conn.SetReadTimeout(sc.rwtimeout)
+	if sc.tracing(TraceOpen) {
+		sc.logger().Debug("serial device opened", "event", EventConnect, "dial", sc.dial)
+	}
 	return nil
 }
 
@@ -109,18 +313,27 @@ func (sc *SerialClient) Open() (err error) {
 Read conforms to io.Writer, but immediately returns upon ctx
 destruction after closing the underlying transport
 */
-func (sc *SerialClient) Read(b []byte) (int, error) {
+func (sc *SerialClient) Read(b []byte) (n int, err error) {
+	defer func() { sc.read(n, err) }()
 	select {
 	case <-sc.ctx.Done():
 		defer sc.Close()
 		return 0, newErr(false, false, sc.ctx.Err())
 	default:
+		if err := sc.unplugged(); err != nil {
+			return 0, err
+		}
 		if sc.conn == nil {
 			if sc.Open() != nil {
 				return 0, newErr(false, false, errors.New("broken connection, unable to reopen serial device"))
 			}
 		}
 		n, e := sc.conn.Read(b)
+		if n == 0 && e != nil && e != io.EOF && sc.hotplug > 0 {
+			//an unplugged device reads as closed, rather than timing out
+			sc.logger().Debug("read failed", "event", EventError, "dial", sc.dial, "error", e)
+			return n, sc.ioErr(e)
+		}
 		switch n {
 		case 0:
 			return n, newErr(true, true, io.EOF)
@@ -132,51 +345,329 @@ func (sc *SerialClient) Read(b []byte) (int, error) {
 		case io.EOF: //most likely as a timeout
 			return n, newErr(true, true, e)
 		default:
-			return n, newErr(false, false, e)
+			sc.logger().Debug("read failed", "event", EventError, "dial", sc.dial, "error", e)
+			return n, sc.ioErr(e)
 		}
 	}
 }
 
+/*
+serialWait bounds each read made by ReadContext, as a pending read of a serial
+port cannot be interrupted
+*/
+const serialWait = 50 * time.Millisecond
+
+/*
+ReadContext conforms to ContextReader, waiting for data until ctx is done
+rather than for the read timeout.  The port is read serialWait at a time, so
+it takes up to that long to notice ctx is done.
+*/
+func (sc *SerialClient) ReadContext(ctx context.Context, b []byte) (int, error) {
+	for {
+		wait := serialWait
+		if deadline, ok := ctx.Deadline(); ok {
+			wait = min(wait, time.Until(deadline))
+		}
+		select {
+		case <-ctx.Done():
+			return 0, newErr(true, true, ctx.Err())
+		default:
+		}
+		if wait <= 0 {
+			return 0, newErr(true, true, context.DeadlineExceeded)
+		}
+		n, err := sc.readWaiting(b, wait)
+		if n > 0 || err == nil || !IsTimeout(err) {
+			return n, err
+		}
+	}
+}
+
+/*readWaiting reads as Read does, but waits up to wait for data*/
+func (sc *SerialClient) readWaiting(b []byte, wait time.Duration) (int, error) {
+	if conn := sc.conn; conn != nil {
+		conn.SetReadTimeout(wait)
+		defer conn.SetReadTimeout(sc.rwtimeout)
+	}
+	return sc.Read(b)
+}
+
 /*
 Write conforms to io.Writer, but immediately returns upon ctx
 destruction after closing the underlying transport
 */
-func (sc *SerialClient) Write(b []byte) (int, error) {
+func (sc *SerialClient) Write(b []byte) (n int, err error) {
+	defer func() { sc.wrote(n, err) }()
 	select {
 	case <-sc.ctx.Done():
 		defer sc.Close()
 		return 0, newErr(false, false, sc.ctx.Err())
 	default:
+		if err := sc.unplugged(); err != nil {
+			return 0, err
+		}
 		if sc.conn == nil {
 			if sc.Open() != nil {
 				return 0, newErr(false, false, errors.New("broken connection, unable to reopen serial device"))
 			}
 		}
-		n, e := sc.conn.Write(b)
+		var n int
+		var e error
+		if sc.rs485 == RS485RTS {
+			n, e = writeRS485(sc.conn, sc.mode, sc.rtsBefore, sc.rtsAfter, b)
+		} else {
+			n, e = sc.conn.Write(b)
+		}
 		switch e {
 		case nil:
 			return n, nil
 		case io.EOF: //most likely as a timeout??
 			return n, newErr(true, true, e)
 		default:
-			return n, newErr(false, false, e)
+			sc.logger().Debug("write failed", "event", EventError, "dial", sc.dial, "error", e)
+			return n, sc.ioErr(e)
 		}
 	}
 }
 
+/*Redial conforms to Redialer, keeping any mode set with SetMode*/
+func (sc *SerialClient) Redial(ctx context.Context) (IDoIO, error) {
+	s, err := NewSerialClient(WithLazyOpen(ctx), sc.timeout, sc.dial)
+	if err != nil {
+		return nil, err
+	}
+	mode := *sc.mode
+	s.mode, s.log, s.hooks, s.trace = &mode, sc.log, sc.currentHooks(), sc.trace
+	return s, nil
+}
+
 /*
 Close conforms to io.Closer, but immediately returns upon ctx
 destruction after closing the underlying transport
 */
 func (sc *SerialClient) Close() error {
 	defer func() { sc.conn = nil }()
+	sc.closed()
+	if sc.unwatch != nil {
+		sc.unwatch()
+		sc.unwatch = nil
+	}
 	select {
 	case <-sc.ctx.Done():
 		return newErr(false, false, sc.ctx.Err()) //Context closed: return that error
 	default:
 		if sc.conn != nil {
+			if sc.tracing(TraceClose) {
+				sc.logger().Debug("serial device closed", "event", EventDisconnect, "dial", sc.dial)
+			}
 			return newErr(false, false, sc.conn.Close())
 		}
 		return nil
 	}
 }
+
+/*present reports whether the device can be found, by name or by USB vid:pid*/
+func (sc *SerialClient) present() bool {
+	if sc.vid != "" {
+		_, err := findUSBSerial(sc.vid, sc.pid, sc.serialNo)
+		return err == nil
+	}
+	if _, err := os.Stat(sc.dev); err == nil {
+		return true
+	}
+	ports, _ := serialPorts() //COM ports have no node to stat
+	for _, p := range ports {
+		if sameSerialDevice(p.Name, sc.dev) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+startWatching starts the hotplug watcher, if one is wanted and not already
+running.  It runs until Close, or until sc.ctx is done.
+*/
+func (sc *SerialClient) startWatching() {
+	if sc.hotplug <= 0 || sc.unwatch != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(sc.ctx)
+	done := make(chan struct{})
+	sc.unwatch = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		sc.watch(ctx)
+	}()
+}
+
+/*watch looks for the device coming and going every sc.hotplug, until ctx is done*/
+func (sc *SerialClient) watch(ctx context.Context) {
+	t := time.NewTicker(sc.hotplug)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		gone := !sc.present()
+		if sc.gone.Swap(gone) == gone {
+			continue
+		}
+		if gone {
+			sc.logger().Warn("serial device unplugged", "event", EventDisconnect, "dial", sc.dial)
+		} else {
+			sc.logger().Info("serial device plugged in", "dial", sc.dial)
+		}
+	}
+}
+
+/*unplugged closes the port and returns a permanent error if the device was last seen unplugged*/
+func (sc *SerialClient) unplugged() error {
+	if !sc.gone.Load() {
+		return nil
+	}
+	if sc.conn != nil {
+		sc.conn.Close()
+		sc.conn = nil
+		sc.closed()
+	}
+	return newErr(false, false, fmt.Errorf("serial device %q is unplugged", sc.dial))
+}
+
+/*
+ioErr classifies a read or write failure.  When watching for hot-plugging the
+port is closed, to be reopened on the next call, and the failure is temporary
+as long as the device is still there.
+*/
+func (sc *SerialClient) ioErr(e error) error {
+	if sc.hotplug <= 0 {
+		return newErr(false, false, e)
+	}
+	sc.conn.Close()
+	sc.conn = nil
+	sc.closed()
+	present := sc.present()
+	sc.gone.Store(!present)
+	return newErr(present, false, e)
+}
+
+/*port returns the open serial port, reopening it if needs be*/
+func (sc *SerialClient) port() (serial.Port, error) {
+	select {
+	case <-sc.ctx.Done():
+		return nil, newErr(false, false, sc.ctx.Err())
+	default:
+	}
+	if err := sc.unplugged(); err != nil {
+		return nil, err
+	}
+	if sc.conn == nil {
+		if sc.Open() != nil {
+			return nil, newErr(false, false, errors.New("broken connection, unable to reopen serial device"))
+		}
+	}
+	return sc.conn, nil
+}
+
+/*SetDTR raises (true) or lowers the DTR line, eg to reset a device that wires it to reset*/
+func (sc *SerialClient) SetDTR(dtr bool) error {
+	p, err := sc.port()
+	if err != nil {
+		return err
+	}
+	if err = p.SetDTR(dtr); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to set DTR"))
+	}
+	return nil
+}
+
+/*SetRTS raises (true) or lowers the RTS line.  It fights with flow=rtscts and rs485*/
+func (sc *SerialClient) SetRTS(rts bool) error {
+	p, err := sc.port()
+	if err != nil {
+		return err
+	}
+	if err = p.SetRTS(rts); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to set RTS"))
+	}
+	return nil
+}
+
+/*ModemStatus reads the CTS, DSR, RI and DCD lines*/
+func (sc *SerialClient) ModemStatus() (*serial.ModemStatusBits, error) {
+	p, err := sc.port()
+	if err != nil {
+		return nil, err
+	}
+	bits, err := p.GetModemStatusBits()
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrap(err, "unable to read modem status"))
+	}
+	return bits, nil
+}
+
+/*
+SetMode reconfigures the open port's baud rate and framing in place, without
+the Close/Open that would drop the control lines, as autobaud sequences and
+bootloader hand-offs need.  The new mode is kept for any later reopen.
+*/
+func (sc *SerialClient) SetMode(mode serial.Mode) error {
+	p, err := sc.port()
+	if err != nil {
+		return err
+	}
+	if err = p.SetMode(&mode); err != nil {
+		return newErr(false, false, errors.Wrapf(err, "unable to set %d %s on %q", mode.BaudRate, serialFraming(&mode), sc.dev))
+	}
+	sc.mode = &mode
+	sc.logger().Debug("serial mode changed", "dial", sc.dial, "baud", mode.BaudRate, "framing", serialFraming(&mode))
+	return nil
+}
+
+/*SetBaud changes the baud rate of the open port, keeping its framing*/
+func (sc *SerialClient) SetBaud(baud int) error {
+	mode := *sc.mode
+	mode.BaudRate = baud
+	return sc.SetMode(mode)
+}
+
+/*FlushInput conforms to Flusher, discarding whatever the port has received but not yet been read*/
+func (sc *SerialClient) FlushInput() error {
+	p, err := sc.port()
+	if err != nil {
+		return err
+	}
+	if err = p.ResetInputBuffer(); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to flush input"))
+	}
+	return nil
+}
+
+/*FlushOutput conforms to Flusher, discarding whatever has been written but not yet sent*/
+func (sc *SerialClient) FlushOutput() error {
+	p, err := sc.port()
+	if err != nil {
+		return err
+	}
+	if err = p.ResetOutputBuffer(); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to flush output"))
+	}
+	return nil
+}
+
+/*Drain conforms to Drainer, blocking until everything written has left the port*/
+func (sc *SerialClient) Drain() error {
+	p, err := sc.port()
+	if err != nil {
+		return err
+	}
+	if err = serialDrain(p); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to drain"))
+	}
+	return nil
+}
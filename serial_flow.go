@@ -0,0 +1,58 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.bug.st/serial"
+)
+
+var (
+	serialFlow  = setSerialFlow //applies one of the Flow* constants to an open port, swapped in tests
+	serialDrain = drainSerial   //waits for written bytes to leave an open port, swapped in tests
+)
+
+/*
+serialHandle digs the OS descriptor out of a port opened by go.bug.st/serial,
+which keeps it to itself.  It is only needed for settings that package has no
+API for, such as flow control.
+*/
+func serialHandle(p serial.Port) (uintptr, error) {
+	v := reflect.ValueOf(p)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		switch f := v.FieldByName("handle"); f.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			return uintptr(f.Int()), nil
+		case reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return uintptr(f.Uint()), nil
+		}
+	}
+	return 0, fmt.Errorf("%T does not expose its handle", p)
+}
@@ -0,0 +1,100 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+/*openPty returns the master of a new pseudo terminal, and the path of its slave*/
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudo terminals:", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSetSerialFlow(t *testing.T) {
+	_, slave := openPty(t)
+	p, err := serial.Open(slave, &serial.Mode{BaudRate: 9600})
+	if err != nil {
+		t.Skip("unable to open pty as a serial port:", err)
+	}
+	defer p.Close()
+	fd, err := serialHandle(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flow         string
+		rtscts, xonx bool
+	}{
+		{FlowRTSCTS, true, false},
+		{FlowXonXoff, false, true},
+		{FlowNone, false, false},
+	}
+	for _, tc := range tests {
+		if err := setSerialFlow(p, tc.flow); err != nil {
+			t.Fatal(tc.flow, err)
+		}
+		tio, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtscts := tio.Cflag&unix.CRTSCTS != 0; rtscts != tc.rtscts {
+			t.Errorf("%s: CRTSCTS is %v", tc.flow, rtscts)
+		}
+		if xonx := tio.Iflag&(unix.IXON|unix.IXOFF) == unix.IXON|unix.IXOFF; xonx != tc.xonx {
+			t.Errorf("%s: IXON|IXOFF is %v", tc.flow, xonx)
+		}
+	}
+	if err := setSerialFlow(p, "semaphore"); err == nil {
+		t.Error("expected an unknown flow control to fail")
+	}
+	if err := setSerialFlow(&tstport{}, FlowRTSCTS); err == nil {
+		t.Error("expected a port without a handle to fail")
+	}
+	if err := drainSerial(p); err != nil {
+		t.Error("unable to drain", err)
+	}
+	if err := setSerialRS485(p, 0, 0); err == nil {
+		t.Error("a pty has no RS-485 mode, expected an error")
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+/*setSerialFlow sets (or clears) RTS/CTS and XON/XOFF flow control in the termios of p*/
+func setSerialFlow(p serial.Port, flow string) error {
+	fd, err := serialHandle(p)
+	if err != nil {
+		return err
+	}
+	t, err := unix.IoctlGetTermios(int(fd), termiosGet)
+	if err != nil {
+		return err
+	}
+	t.Cflag &^= unix.CRTSCTS
+	t.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+	switch flow {
+	case FlowNone, "":
+	case FlowRTSCTS:
+		t.Cflag |= unix.CRTSCTS
+	case FlowXonXoff:
+		t.Iflag |= unix.IXON | unix.IXOFF
+		t.Cc[unix.VSTART], t.Cc[unix.VSTOP] = 0x11, 0x13
+	default:
+		return fmt.Errorf("unknown flow control %q", flow)
+	}
+	return unix.IoctlSetTermios(int(fd), termiosSet, t)
+}
+
+/*drainSerial waits until everything written to p has been transmitted*/
+func drainSerial(p serial.Port) error {
+	fd, err := serialHandle(p)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetInt(int(fd), drainReq, drainArg)
+}
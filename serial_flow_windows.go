@@ -0,0 +1,106 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"unsafe"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procGetCommState = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetCommState")
+	procSetCommState = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetCommState")
+)
+
+/*commDCB is the win32 DCB structure*/
+type commDCB struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32 //bitfield, see the dcb* constants
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+const (
+	dcbOutxCtsFlow         = 0x00000004
+	dcbOutX                = 0x00000100
+	dcbInX                 = 0x00000200
+	dcbRtsControlMask      = 0x00003000
+	dcbRtsControlOn        = 0x00001000
+	dcbRtsControlHandshake = 0x00002000
+)
+
+/*setSerialFlow sets (or clears) RTS/CTS and XON/XOFF flow control in the DCB of p*/
+func setSerialFlow(p serial.Port, flow string) error {
+	h, err := serialHandle(p)
+	if err != nil {
+		return err
+	}
+	d := commDCB{}
+	d.DCBlength = uint32(unsafe.Sizeof(d))
+	if r, _, e := procGetCommState.Call(h, uintptr(unsafe.Pointer(&d))); r == 0 {
+		return e
+	}
+	if d.Flags&dcbRtsControlMask == dcbRtsControlHandshake {
+		d.Flags = d.Flags&^dcbRtsControlMask | dcbRtsControlOn //leave RTS asserted when handshaking stops
+	}
+	d.Flags &^= dcbOutxCtsFlow | dcbOutX | dcbInX
+	switch flow {
+	case FlowNone, "":
+	case FlowRTSCTS:
+		d.Flags = d.Flags&^dcbRtsControlMask | dcbOutxCtsFlow | dcbRtsControlHandshake
+	case FlowXonXoff:
+		d.Flags |= dcbOutX | dcbInX
+		d.XonChar, d.XoffChar = 0x11, 0x13
+	default:
+		return fmt.Errorf("unknown flow control %q", flow)
+	}
+	if r, _, e := procSetCommState.Call(h, uintptr(unsafe.Pointer(&d))); r == 0 {
+		return e
+	}
+	return nil
+}
+
+/*drainSerial waits until everything written to p has been transmitted*/
+func drainSerial(p serial.Port) error {
+	h, err := serialHandle(p)
+	if err != nil {
+		return err
+	}
+	return windows.FlushFileBuffers(windows.Handle(h))
+}
@@ -0,0 +1,133 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"go.bug.st/serial"
+)
+
+/*SerialPortInfo describes a serial port found by ListSerialPorts*/
+type SerialPortInfo struct {
+	Name         string //device to open, eg /dev/ttyUSB0 or COM3
+	IsUSB        bool   //the fields below are only set for USB devices
+	VID, PID     string //hex, as reported by the OS
+	SerialNumber string //may be empty
+	Description  string //OS dependent product description, may be empty
+}
+
+/*
+DialString returns a serial dial string for this port at baud.  USB ports
+are dialed by vid:pid (and serial number when known) so the dial string
+survives the device being renamed.
+*/
+func (spi SerialPortInfo) DialString(baud int) string {
+	switch {
+	case !spi.IsUSB || spi.VID == "" || spi.PID == "":
+		return fmt.Sprintf("serial://%s:%d", spi.Name, baud)
+	case spi.SerialNumber == "" || strings.Contains(spi.SerialNumber, ":"):
+		return fmt.Sprintf("serial://vid:pid=%s:%s:%d", spi.VID, spi.PID, baud)
+	default:
+		return fmt.Sprintf("serial://vid:pid=%s:%s:%s:%d", spi.VID, spi.PID, spi.SerialNumber, baud)
+	}
+}
+
+/*serialPorts enumerates the serial ports on this system, swapped in tests*/
+var serialPorts = nativeSerialPorts
+
+/*
+ListSerialPorts enumerates the serial ports on this system, with whatever
+USB metadata the OS offers.  On darwin without cgo only the names are known.
+*/
+func ListSerialPorts() ([]SerialPortInfo, error) {
+	return serialPorts()
+}
+
+/*serialPortNames lists the serial ports on this system, without any metadata*/
+func serialPortNames() ([]SerialPortInfo, error) {
+	names, err := serial.GetPortsList()
+	if err != nil {
+		return nil, err
+	}
+	ports := make([]SerialPortInfo, 0, len(names))
+	for _, n := range names {
+		ports = append(ports, SerialPortInfo{Name: n})
+	}
+	return ports, nil
+}
+
+/*
+serialDevice tidies the device named in a dial string.  Windows ports from
+COM10 up must be opened as \\.\COMxx, which go.bug.st/serial does itself for
+every port, so the prefix is dropped if given.
+*/
+func serialDevice(dev string) string {
+	return strings.TrimPrefix(dev, `\\.\`)
+}
+
+/*sameSerialDevice compares device names, ignoring case where the OS does*/
+func sameSerialDevice(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+/*
+findUSBSerial returns the device name of the serial port on the USB device
+vid:pid.  If serialNo is not empty the device must also carry that serial
+number; otherwise more than one match is an error, since there is no telling
+which was wanted.
+*/
+func findUSBSerial(vid, pid, serialNo string) (string, error) {
+	ports, err := serialPorts()
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, p := range ports {
+		if !p.IsUSB || !strings.EqualFold(p.VID, vid) || !strings.EqualFold(p.PID, pid) {
+			continue
+		}
+		if serialNo != "" && p.SerialNumber != serialNo {
+			continue
+		}
+		found = append(found, p.Name)
+	}
+	switch len(found) {
+	case 0:
+		if serialNo != "" {
+			return "", fmt.Errorf("no serial port found on USB device %s:%s with serial number %q", vid, pid, serialNo)
+		}
+		return "", fmt.Errorf("no serial port found on USB device %s:%s", vid, pid)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("USB device %s:%s matches %d serial ports (%s), add a serial number", vid, pid, len(found), strings.Join(found, ", "))
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !darwin || cgo
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import "go.bug.st/serial/enumerator"
+
+func nativeSerialPorts() ([]SerialPortInfo, error) {
+	details, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return serialPortNames() //eg SetupAPI refused on a locked down windows machine
+	}
+	ports := make([]SerialPortInfo, 0, len(details))
+	for _, d := range details {
+		ports = append(ports, SerialPortInfo{
+			Name:         d.Name,
+			IsUSB:        d.IsUSB,
+			VID:          d.VID,
+			PID:          d.PID,
+			SerialNumber: d.SerialNumber,
+			Description:  d.Product,
+		})
+	}
+	return ports, nil
+}
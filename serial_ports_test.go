@@ -0,0 +1,179 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.bug.st/serial"
+)
+
+func TestFindUSBSerial(t *testing.T) {
+	defer func(f func() ([]SerialPortInfo, error)) { serialPorts = f }(serialPorts)
+	serialPorts = func() ([]SerialPortInfo, error) {
+		return []SerialPortInfo{
+			{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A600XYZ"},
+			{Name: "/dev/ttyUSB1", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A600ABC"},
+			{Name: "/dev/ttyACM0", IsUSB: true, VID: "10c4", PID: "ea60", SerialNumber: ""},
+			{Name: "/dev/ttyS0"},
+		}, nil
+	}
+	tests := map[string]struct {
+		vid, pid, sn string
+		want         string
+		err          bool
+	}{
+		"single match":          {vid: "10c4", pid: "ea60", want: "/dev/ttyACM0"},
+		"case insensitive":      {vid: "10C4", pid: "EA60", want: "/dev/ttyACM0"},
+		"by serial number":      {vid: "0403", pid: "6001", sn: "A600ABC", want: "/dev/ttyUSB1"},
+		"ambiguous":             {vid: "0403", pid: "6001", err: true},
+		"no such device":        {vid: "dead", pid: "beef", err: true},
+		"no such serial number": {vid: "0403", pid: "6001", sn: "nope", err: true},
+	}
+	for name, tc := range tests {
+		got, err := findUSBSerial(tc.vid, tc.pid, tc.sn)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+	if got, err := findUSBSerial("0403", "6001", "A600XYZ"); err != nil || got != "/dev/ttyUSB0" {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	serialPorts = func() ([]SerialPortInfo, error) { return nil, errors.New("enumeration failed") }
+	if _, err := findUSBSerial("0403", "6001", ""); err == nil {
+		t.Error("enumeration errors should surface")
+	}
+}
+
+func TestSerialPortInfo_DialString(t *testing.T) {
+	tests := map[string]struct {
+		info SerialPortInfo
+		want string
+	}{
+		"plain":            {SerialPortInfo{Name: "/dev/ttyS0"}, "serial:///dev/ttyS0:9600"},
+		"usb":              {SerialPortInfo{Name: "/dev/ttyACM0", IsUSB: true, VID: "10c4", PID: "ea60"}, "serial://vid:pid=10c4:ea60:9600"},
+		"usb with serial":  {SerialPortInfo{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A600XYZ"}, "serial://vid:pid=0403:6001:A600XYZ:9600"},
+		"usb without vids": {SerialPortInfo{Name: "COM3", IsUSB: true}, "serial://COM3:9600"},
+	}
+	for name, tc := range tests {
+		if got := tc.info.DialString(9600); got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestListSerialPorts(t *testing.T) {
+	ports, err := ListSerialPorts()
+	if err != nil {
+		t.Skip("unable to enumerate serial ports:", err)
+	}
+	for _, p := range ports {
+		if p.Name == "" {
+			t.Errorf("unnamed port %+v", p)
+		}
+		t.Log(p.DialString(9600))
+	}
+}
+
+func TestSerialClient_USB(t *testing.T) {
+	defer func(f func() ([]SerialPortInfo, error)) { serialPorts = f }(serialPorts)
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+
+	ports := []SerialPortInfo{{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A600XYZ"}}
+	serialPorts = func() ([]SerialPortInfo, error) { return ports, nil }
+	var opened []string
+	var baud int
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) {
+		opened, baud = append(opened, dev), mode.BaudRate
+		return &tstport{}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewSerialClient(ctx, 0, "serial://vid:pid=0403:6001"); err == nil {
+		t.Error("a USB dial without a baud rate should fail")
+	}
+	idoio, err := NewIDoIO(ctx, 0, "serial://vid:pid=0403:6001:A600XYZ:115200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := idoio.(*SerialClient)
+	if sc.dev != "/dev/ttyUSB0" || baud != 115200 {
+		t.Errorf("opened %q at %d baud", sc.dev, baud)
+	}
+	_ = sc.String()
+
+	//replugged and renamed, the next Open follows it
+	ports[0].Name = "/dev/ttyUSB3"
+	if err := sc.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if sc.dev != "/dev/ttyUSB3" {
+		t.Errorf("reopened %q", sc.dev)
+	}
+
+	//unplugged, the failure is temporary
+	ports = nil
+	if err := sc.Open(); err == nil || !IsTemporary(err) {
+		t.Errorf("expected a temporary error, got %v", err)
+	}
+	if len(opened) != 2 {
+		t.Errorf("opened %v", opened)
+	}
+
+	if _, err := NewIDoIO(ctx, 0, "rs232://vid:pid=0403:6001:9600"); err == nil {
+		t.Error("no device, expected an error")
+	}
+}
+
+func TestSerialClient_COMPorts(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	var opened string
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) {
+		opened = dev
+		return &tstport{}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for dial, want := range map[string]string{
+		"serial://COM3:9600":       "COM3",
+		"serial://COM12:9600":      "COM12",
+		`serial://\\.\COM12:9600`:  "COM12",
+		`rs232://\\.\COM7:4800`:    "COM7",
+		"serial:///dev/ttyS0:9600": "/dev/ttyS0",
+	} {
+		if _, err := NewSerialClient(ctx, 0, dial); err != nil || opened != want {
+			t.Errorf("%s: opened %q, wanted %q (%v)", dial, opened, want, err)
+		}
+	}
+	if !sameSerialDevice("COM3", "COM3") || sameSerialDevice("COM3", "COM4") {
+		t.Error("device names compare wrongly")
+	}
+}
@@ -0,0 +1,60 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"time"
+
+	"go.bug.st/serial"
+)
+
+/*RS-485 transmitter control, as chosen with ?rs485= in a serial dial string*/
+const (
+	RS485RTS    = "rts"    //RTS is raised around each Write, by this package
+	RS485Kernel = "kernel" //the driver keys the transmitter (linux TIOCSRS485)
+)
+
+/*serialRS485 puts an open port in the driver's RS-485 mode, swapped in tests*/
+var serialRS485 = setSerialRS485
+
+/*
+writeRS485 writes b to p with RTS raised, holding it rtsBefore ahead of the
+first byte and rtsAfter beyond the last.  Where the port cannot be drained
+the time to transmit b at baud is waited out instead.
+*/
+func writeRS485(p serial.Port, mode *serial.Mode, rtsBefore, rtsAfter time.Duration, b []byte) (int, error) {
+	if err := p.SetRTS(true); err != nil {
+		return 0, err
+	}
+	defer p.SetRTS(false)
+	time.Sleep(rtsBefore)
+	n, err := p.Write(b)
+	if serialDrain(p) != nil && mode.BaudRate > 0 {
+		bits := 1 + mode.DataBits + 2 //start, data, and parity and stop at worst
+		time.Sleep(time.Duration(n*bits) * time.Second / time.Duration(mode.BaudRate))
+	}
+	time.Sleep(rtsAfter)
+	return n, err
+}
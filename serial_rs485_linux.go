@@ -0,0 +1,63 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"time"
+	"unsafe"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+/*serialRS485Config is the kernel's struct serial_rs485*/
+type serialRS485Config struct {
+	flags       uint32
+	delayBefore uint32 //milliseconds
+	delayAfter  uint32 //milliseconds
+	padding     [5]uint32
+}
+
+const (
+	serRS485Enabled   = 1 << 0
+	serRS485RTSOnSend = 1 << 1
+)
+
+/*setSerialRS485 has the driver raise RTS while transmitting*/
+func setSerialRS485(p serial.Port, rtsBefore, rtsAfter time.Duration) error {
+	fd, err := serialHandle(p)
+	if err != nil {
+		return err
+	}
+	cfg := serialRS485Config{
+		flags:       serRS485Enabled | serRS485RTSOnSend,
+		delayBefore: uint32(rtsBefore / time.Millisecond),
+		delayAfter:  uint32(rtsAfter / time.Millisecond),
+	}
+	if _, _, e := unix.Syscall(unix.SYS_IOCTL, fd, unix.TIOCSRS485, uintptr(unsafe.Pointer(&cfg))); e != 0 {
+		return e
+	}
+	return nil
+}
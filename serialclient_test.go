@@ -29,6 +29,9 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -78,6 +81,7 @@ var (
 
 func TestMain(m *testing.M) {
 	flag.Parse()
+	os.Exit(m.Run())
 }
 
 func TestNewSerialClient(t *testing.T) {
@@ -292,6 +296,55 @@ func TestSerial_Read(t *testing.T) {
 	}
 }
 
+/*waitPort is a tstport whose reads wait out the read timeout, counting them*/
+type waitPort struct {
+	tstport
+	wait  time.Duration
+	reads int
+	data  []byte
+}
+
+func (wp *waitPort) SetReadTimeout(d time.Duration) error {
+	wp.wait = d
+	return nil
+}
+
+func (wp *waitPort) Read(p []byte) (int, error) {
+	wp.reads++
+	if len(wp.data) > 0 {
+		n := copy(p, wp.data)
+		wp.data = wp.data[n:]
+		return n, nil
+	}
+	time.Sleep(wp.wait)
+	return 0, nil
+}
+
+func TestSerial_ReadContext(t *testing.T) {
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+	wp := &waitPort{wait: time.Millisecond}
+	ser := &SerialClient{ctx: ctx, cancel: cncl, conn: wp, mode: &serial.Mode{}, dev: "nope", rwtimeout: time.Millisecond}
+
+	rctx, rcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer rcancel()
+	if _, err := ser.ReadContext(rctx, make([]byte, 8)); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout once ctx was done", err)
+	}
+	if wp.reads > 5 {
+		t.Errorf("Expected a read every %v at most, got %d", serialWait, wp.reads)
+	}
+	if wp.wait != time.Millisecond {
+		t.Error("Expected the read timeout to be restored, got", wp.wait)
+	}
+
+	wp.data = []byte("cat")
+	b := make([]byte, 8)
+	if n, err := ser.ReadContext(ctx, b); err != nil || string(b[:n]) != "cat" {
+		t.Errorf("Expected the data, got %q %v", b[:n], err)
+	}
+}
+
 func TestSerial_Write(t *testing.T) {
 	type x struct {
 		n    int
@@ -388,3 +441,420 @@ func TestSerial_Close(t *testing.T) {
 		cncl()
 	}
 }
+
+func TestSerialClient_Mode(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	var opened serial.Mode
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) {
+		opened = *mode
+		return &tstport{}, nil
+	}
+	defer func(f func() ([]SerialPortInfo, error)) { serialPorts = f }(serialPorts)
+	serialPorts = func() ([]SerialPortInfo, error) {
+		return []SerialPortInfo{{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001"}}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := map[string]struct {
+		dial    string
+		framing string
+		err     bool
+	}{
+		"default 8N1":  {dial: "serial:///dev/ttyS0:9600", framing: "8N1"},
+		"7E1":          {dial: "serial:///dev/ttyS0:9600?parity=even&databits=7", framing: "7E1"},
+		"8O2 rs232":    {dial: "rs232://COM3:19200?parity=odd&stopbits=2", framing: "8O2"},
+		"usb 7S1.5":    {dial: "serial://vid:pid=0403:6001:4800?databits=7&parity=space&stopbits=1.5", framing: "7S1.5"},
+		"bad databits": {dial: "serial:///dev/ttyS0:9600?databits=9", err: true},
+		"bad parity":   {dial: "serial:///dev/ttyS0:9600?parity=sometimes", err: true},
+		"bad stopbits": {dial: "serial:///dev/ttyS0:9600?stopbits=3", err: true},
+		"unknown":      {dial: "serial:///dev/ttyS0:9600?colour=blue", err: true},
+		"missing baud": {dial: "serial:///dev/ttyS0:", err: true},
+		"not serial":   {dial: "rs232x://COM3:9600", err: true},
+	}
+	for name, tc := range tests {
+		sc, err := NewSerialClient(ctx, 0, tc.dial)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected error state %v", name, err)
+		}
+		if err != nil {
+			continue
+		}
+		if got := serialFraming(&opened); got != tc.framing {
+			t.Errorf("%s: opened %s, wanted %s", name, got, tc.framing)
+		}
+		if got := serialFraming(sc.mode); got != tc.framing {
+			t.Errorf("%s: configured %s, wanted %s", name, got, tc.framing)
+		}
+		t.Log(sc)
+	}
+}
+
+func TestSerialClient_Flow(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	defer func(f func(serial.Port, string) error) { serialFlow = f }(serialFlow)
+	closed := 0
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) {
+		return &tstport{close: func() error { closed++; return nil }}, nil
+	}
+	var flows []string
+	serialFlow = func(p serial.Port, flow string) error {
+		if flows = append(flows, flow); flow == FlowXonXoff {
+			return fmt.Errorf("not on this port")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?flow=sideways"); err == nil {
+		t.Error("expected an unknown flow control to fail")
+	}
+	if _, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?flow=none"); err != nil {
+		t.Error(err)
+	}
+	sc, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:115200?flow=rtscts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.Open(); err != nil { //flow control is set again on every open
+		t.Fatal(err)
+	}
+	if _, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?flow=xonxoff"); err == nil {
+		t.Error("expected a failure to set flow control to fail the open")
+	}
+	if want := []string{FlowRTSCTS, FlowRTSCTS, FlowXonXoff}; fmt.Sprint(flows) != fmt.Sprint(want) {
+		t.Errorf("set %v, wanted %v", flows, want)
+	}
+	if closed != 2 {
+		t.Errorf("expected the replaced and the misconfigured ports to be closed, closed %d", closed)
+	}
+}
+
+/*rtsPort records the order of DTR and RTS changes and writes*/
+type rtsPort struct {
+	tstport
+	events []string
+	status *serial.ModemStatusBits
+}
+
+func (rp *rtsPort) SetDTR(dtr bool) error {
+	rp.events = append(rp.events, fmt.Sprintf("dtr=%v", dtr))
+	return nil
+}
+func (rp *rtsPort) SetMode(m *serial.Mode) error {
+	if m.BaudRate <= 0 {
+		return fmt.Errorf("invalid baud rate %d", m.BaudRate)
+	}
+	rp.events = append(rp.events, fmt.Sprintf("%d %s", m.BaudRate, serialFraming(m)))
+	return nil
+}
+func (rp *rtsPort) ResetInputBuffer() error {
+	rp.events = append(rp.events, "flush input")
+	return nil
+}
+func (rp *rtsPort) ResetOutputBuffer() error {
+	rp.events = append(rp.events, "flush output")
+	return nil
+}
+func (rp *rtsPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	if rp.status == nil {
+		return nil, fmt.Errorf("no modem status")
+	}
+	return rp.status, nil
+}
+
+func (rp *rtsPort) SetRTS(rts bool) error {
+	rp.events = append(rp.events, fmt.Sprintf("rts=%v", rts))
+	return nil
+}
+func (rp *rtsPort) Write(p []byte) (int, error) {
+	rp.events = append(rp.events, fmt.Sprintf("write %q", p))
+	return len(p), nil
+}
+
+func TestSerialClient_RS485(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	defer func(f func(serial.Port) error) { serialDrain = f }(serialDrain)
+	defer func(f func(serial.Port, time.Duration, time.Duration) error) { serialRS485 = f }(serialRS485)
+	port := &rtsPort{}
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) { return port, nil }
+	serialDrain = func(p serial.Port) error {
+		port.events = append(port.events, "drain")
+		return nil
+	}
+	var kernel []time.Duration
+	serialRS485 = func(p serial.Port, before, after time.Duration) error {
+		kernel = append(kernel, before, after)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, dial := range []string{
+		"serial:///dev/ttyS0:9600?rs485=sometimes",
+		"serial:///dev/ttyS0:9600?rs485=rts&rtsbefore=soon",
+		"serial:///dev/ttyS0:9600?rs485=rts&rtsafter=later",
+	} {
+		if _, err := NewSerialClient(ctx, 0, dial); err == nil {
+			t.Errorf("%s: expected an error", dial)
+		}
+	}
+
+	sc, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?rs485=rts&rtsbefore=2ms&rtsafter=3ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if n, err := sc.Write([]byte("ABC")); n != 3 || err != nil {
+		t.Fatal(n, err)
+	}
+	if time.Since(start) < 5*time.Millisecond {
+		t.Error("expected the rts delays to be honoured")
+	}
+	if want := `[rts=true write "ABC" drain rts=false]`; fmt.Sprint(port.events) != want {
+		t.Errorf("got %v, wanted %s", port.events, want)
+	}
+
+	//without a drain, the transmission time is waited out: 10 bytes at 9600 baud is ~11ms
+	port.events = nil
+	serialDrain = func(p serial.Port) error { return fmt.Errorf("no drain") }
+	start = time.Now()
+	sc.Write([]byte("0123456789"))
+	if time.Since(start) < 10*time.Millisecond {
+		t.Error("expected the transmission time to be waited out")
+	}
+
+	if _, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?rs485=kernel&rtsbefore=1ms&rtsafter=4ms"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(kernel) != "[1ms 4ms]" {
+		t.Errorf("kernel RS-485 set with %v", kernel)
+	}
+	serialRS485 = func(serial.Port, time.Duration, time.Duration) error { return fmt.Errorf("not a UART") }
+	if _, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?rs485=kernel"); err == nil {
+		t.Error("expected a driver without RS-485 support to fail the open")
+	}
+}
+
+func TestSerialClient_ModemController(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	port := &rtsPort{}
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) { return port, nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	idoio, err := NewIDoIO(ctx, 0, "serial:///dev/ttyS0:9600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc, ok := idoio.(ModemController)
+	if !ok {
+		t.Fatal("expected a SerialClient to be a ModemController")
+	}
+	mc.SetDTR(false)
+	mc.SetDTR(true)
+	idoio.Close()
+	mc.SetRTS(true) //reopens
+	if want := "[dtr=false dtr=true rts=true]"; fmt.Sprint(port.events) != want {
+		t.Errorf("got %v, wanted %s", port.events, want)
+	}
+	if _, err := mc.ModemStatus(); err == nil {
+		t.Error("expected the port's error")
+	}
+	port.status = &serial.ModemStatusBits{CTS: true, DCD: true}
+	if bits, err := mc.ModemStatus(); err != nil || !bits.CTS || !bits.DCD || bits.DSR {
+		t.Errorf("got %+v, %v", bits, err)
+	}
+
+	cancel()
+	if mc.SetDTR(true) == nil || mc.SetRTS(true) == nil {
+		t.Error("expected errors on a dead context")
+	}
+	if _, err := mc.ModemStatus(); err == nil {
+		t.Error("expected an error on a dead context")
+	}
+}
+
+func TestSerialClient_SetMode(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	port := &rtsPort{}
+	var opened []int
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) {
+		opened = append(opened, mode.BaudRate)
+		return port, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sc, err := NewSerialClient(ctx, 0, "serial:///dev/ttyS0:9600?parity=even&databits=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.SetBaud(115200); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.SetBaud(-1); err == nil {
+		t.Error("expected the port to refuse a bad baud rate")
+	}
+	if err := sc.SetMode(serial.Mode{BaudRate: 57600, DataBits: 8, StopBits: serial.TwoStopBits}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[115200 7E1 57600 8N2]"; fmt.Sprint(port.events) != want {
+		t.Errorf("got %v, wanted %s", port.events, want)
+	}
+	//the last good mode survives a reopen
+	if err := sc.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(opened) != "[9600 57600]" {
+		t.Errorf("opened at %v", opened)
+	}
+	t.Log(sc)
+
+	cancel()
+	if err := sc.SetBaud(9600); err == nil {
+		t.Error("expected an error on a dead context")
+	}
+}
+
+func TestSerialClient_Hotplug(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	opens := 0
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) {
+		if _, err := os.Stat(dev); err != nil {
+			return nil, err
+		}
+		opens++
+		return &tstport{read: func(b []byte) (int, error) {
+			if _, err := os.Stat(dev); err != nil {
+				return 0, fmt.Errorf("input/output error")
+			}
+			return copy(b, "x"), nil
+		}}, nil
+	}
+	dev := filepath.Join(t.TempDir(), "ttyUSB0")
+	plug := func() { os.WriteFile(dev, nil, 0o600) }
+	plug()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewSerialClient(ctx, 0, "serial://"+dev+":9600?hotplug=0s"); err == nil {
+		t.Error("expected a zero interval to fail")
+	}
+	sc, err := NewSerialClient(ctx, 0, "serial://"+dev+":9600?hotplug=5ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 8)
+	if n, err := sc.Read(b); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+
+	//yanked: the failing read is permanent, and so is everything after until it is back
+	os.Remove(dev)
+	if _, err := sc.Read(b); err == nil || IsTemporary(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if _, err := sc.Write(b); err == nil || IsTemporary(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if err := sc.SetDTR(true); err == nil {
+		t.Error("expected an error while unplugged")
+	}
+
+	plug()
+	deadline := time.Now().Add(time.Second)
+	for sc.gone.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n, err := sc.Read(b); n != 1 || err != nil {
+		t.Fatal("expected a reopen once plugged back in", n, err)
+	}
+	if opens != 2 {
+		t.Errorf("opened %d times", opens)
+	}
+
+	//yanked and noticed by the watcher alone
+	os.Remove(dev)
+	deadline = time.Now().Add(time.Second)
+	for !sc.gone.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := sc.Read(b); err == nil || IsTemporary(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if opens != 2 {
+		t.Errorf("opened %d times", opens)
+	}
+}
+
+func TestSerialClient_HotplugClose(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) { return &tstport{}, nil }
+	dev := filepath.Join(t.TempDir(), "ttyUSB0")
+	os.WriteFile(dev, nil, 0o600)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		sc, err := NewSerialClient(ctx, 0, "serial://"+dev+":9600?hotplug=5ms")
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := sc.Redial(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc.Close()
+		if err := again.Open(); err != nil {
+			t.Fatal(err)
+		}
+		again.Close()
+	}
+	//every watcher is gone once its client is closed
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no goroutines left behind, went from %d to %d", before, after)
+	}
+}
+
+func TestSerialClient_FlushDrain(t *testing.T) {
+	defer func(f func(string, *serial.Mode) (serial.Port, error)) { serialOpen = f }(serialOpen)
+	defer func(f func(serial.Port) error) { serialDrain = f }(serialDrain)
+	port := &rtsPort{}
+	serialOpen = func(dev string, mode *serial.Mode) (serial.Port, error) { return port, nil }
+	serialDrain = func(p serial.Port) error {
+		port.events = append(port.events, "drain")
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	idoio, err := NewIDoIO(ctx, 0, "serial:///dev/ttyS0:9600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := idoio.(Flusher)
+	if !ok {
+		t.Fatal("expected a SerialClient to be a Flusher")
+	}
+	d, ok := idoio.(Drainer)
+	if !ok {
+		t.Fatal("expected a SerialClient to be a Drainer")
+	}
+	if f.FlushInput() != nil || f.FlushOutput() != nil || d.Drain() != nil {
+		t.Error("expected flushing and draining to succeed")
+	}
+	if want := "[flush input flush output drain]"; fmt.Sprint(port.events) != want {
+		t.Errorf("got %v, wanted %s", port.events, want)
+	}
+	serialDrain = func(serial.Port) error { return fmt.Errorf("no drain") }
+	if d.Drain() == nil {
+		t.Error("expected the drain error")
+	}
+	cancel()
+	if f.FlushInput() == nil || f.FlushOutput() == nil || d.Drain() == nil {
+		t.Error("expected errors on a dead context")
+	}
+}
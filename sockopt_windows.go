@@ -0,0 +1,59 @@
+//go:build windows
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func setsockoptInt(fd uintptr, level, opt, value int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), level, opt, value)
+}
+
+func getsockoptInt(fd uintptr, level, opt int) (int, error) {
+	var v int32
+	l := int32(unsafe.Sizeof(v))
+	err := syscall.Getsockopt(syscall.Handle(fd), int32(level), int32(opt), (*byte)(unsafe.Pointer(&v)), &l)
+	return int(v), err
+}
+
+/*
+setKeepalive sets the interval (in seconds) between tcp keepalive probes, and
+if count is not zero how many go unanswered before the connection is dropped
+(windows 10 1709 on)
+*/
+func setKeepalive(fd uintptr, interval, count int) error {
+	const tcpKeepCnt, tcpKeepIntvl = 16, 17
+	if err := setsockoptInt(fd, syscall.IPPROTO_TCP, tcpKeepIntvl, interval); err != nil {
+		return err
+	}
+	if count > 0 {
+		return setsockoptInt(fd, syscall.IPPROTO_TCP, tcpKeepCnt, count)
+	}
+	return nil
+}
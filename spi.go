@@ -0,0 +1,246 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &SPIClient{}
+
+/*SPIConfig describes how an SPIClient clocks its transfers*/
+type SPIConfig struct {
+	Speed uint32 //clock rate in Hz, defaults to 500000
+	Mode  uint8  //SPI mode 0 to 3 (clock polarity and phase)
+	Bits  uint8  //bits per word, defaults to 8
+}
+
+/*spiBus is what an SPIClient needs of a device: full duplex transfers*/
+type spiBus interface {
+	transfer(tx, rx []byte) error
+	Close() error
+}
+
+/*
+SPIClient provides an implementer of the IDoIO interface for a device on a
+Linux SPI bus, via the spidev interface, under the URI regime:
+
+	spi://
+
+SPI is full duplex: every Write clocks b out while clocking in as many bytes,
+which are buffered for subsequent Reads.  Read returns buffered bytes, or a
+timeout error immediately if there are none, as nothing arrives unless
+something is written.  To read from a device, write the command followed by
+enough padding (typically zeros) for the reply.
+*/
+type SPIClient struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	dial    string
+	dev     string
+	cfg     SPIConfig
+	open    func(dev string, cfg SPIConfig) (spiBus, error)
+	log     Logger //nil means LoggerFrom(ctx)
+
+	mux sync.Mutex //guards everything below
+	bus spiBus
+	rx  bytes.Buffer
+}
+
+/*
+NewSPIClient opens a device on an SPI bus.  Dial should be in the form of
+"spi://<device>[?speed=<hz>&mode=<0-3>&bits=<n>]", eg
+"spi:///dev/spidev0.0?speed=500000&mode=0".
+*/
+func NewSPIClient(ctx context.Context, timeout time.Duration, dial string) (*SPIClient, error) {
+	d, err := dialFor(dial, "spi")
+	if err == nil && !strings.HasPrefix(d.Device, "/") {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseSPIConfig(d.Params.Encode())
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	s := newSPIClient(ctx, timeout, d.Device, cfg, openSPI)
+	s.dial = dial
+	return s, openUnlessLazy(ctx, s)
+}
+
+func newSPIClient(ctx context.Context, timeout time.Duration, dev string, cfg SPIConfig, open func(string, SPIConfig) (spiBus, error)) *SPIClient {
+	nctx, cancel := context.WithCancel(ctx)
+	return &SPIClient{ctx: nctx, cancel: cancel, timeout: timeout, dev: dev, cfg: cfg, open: open}
+}
+
+/*parseSPIConfig parses the query portion of an spi:// dial string*/
+func parseSPIConfig(query string) (SPIConfig, error) {
+	cfg := SPIConfig{Speed: 500000, Bits: 8}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return cfg, err
+	}
+	for k, v := range q {
+		var n uint64
+		switch k {
+		case "speed":
+			n, err = strconv.ParseUint(v[0], 10, 32)
+			cfg.Speed = uint32(n)
+		case "mode":
+			n, err = strconv.ParseUint(v[0], 10, 8)
+			if err == nil && n > 3 {
+				err = fmt.Errorf("mode must be 0 to 3")
+			}
+			cfg.Mode = uint8(n)
+		case "bits":
+			n, err = strconv.ParseUint(v[0], 10, 8)
+			cfg.Bits = uint8(n)
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return cfg, errors.Wrap(err, k)
+		}
+	}
+	return cfg, nil
+}
+
+/*
+SetLogger overrides the Logger carried by the context this SPIClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (s *SPIClient) SetLogger(l Logger) {
+	s.log = l
+}
+
+func (s *SPIClient) dialString() string { return s.dial }
+
+func (s *SPIClient) logger() Logger {
+	if s.log != nil {
+		return s.log
+	}
+	return LoggerFrom(s.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (s *SPIClient) String() string {
+	return fmt.Sprintf("SPI device %v (%dHz mode %d)", s.dev, s.cfg.Speed, s.cfg.Mode)
+}
+
+/*Open forcibly closes (ignoring errors) the device, discarding anything buffered, then reopens it*/
+func (s *SPIClient) Open() (err error) {
+	select {
+	case <-s.ctx.Done():
+		return newErr(false, false, s.ctx.Err())
+	default:
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.bus != nil {
+		s.bus.Close()
+		s.bus = nil
+	}
+	s.rx.Reset()
+	if s.bus, err = s.open(s.dev, s.cfg); err != nil {
+		s.bus = nil
+		s.logger().Warn("unable to open connection", "event", EventError, "dial", s.dial, "error", err)
+		return newErr(false, false, errors.Wrapf(err, "unable to open SPI device %v", s.dev))
+	}
+	s.logger().Debug("connection opened", "event", EventConnect, "dial", s.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader, returning bytes clocked in by earlier Writes*/
+func (s *SPIClient) Read(b []byte) (int, error) {
+	select {
+	case <-s.ctx.Done():
+		defer s.Close()
+		return 0, newErr(false, false, s.ctx.Err())
+	default:
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.bus == nil {
+		return 0, readErr
+	}
+	if s.rx.Len() == 0 {
+		return 0, newErr(true, true, fmt.Errorf("read: nothing clocked in"))
+	}
+	return s.rx.Read(b)
+}
+
+/*Write conforms to io.Writer, performing a full duplex transfer of len(b) bytes*/
+func (s *SPIClient) Write(b []byte) (int, error) {
+	select {
+	case <-s.ctx.Done():
+		defer s.Close()
+		return 0, newErr(false, false, s.ctx.Err())
+	default:
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.bus == nil {
+		return 0, writeErr
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	rx := make([]byte, len(b))
+	if err := s.bus.transfer(b, rx); err != nil {
+		s.logger().Debug("write failed", "event", EventError, "dial", s.dial, "error", err)
+		return 0, newErr(false, false, err)
+	}
+	s.rx.Write(rx)
+	return len(b), nil
+}
+
+/*Close conforms to io.Closer*/
+func (s *SPIClient) Close() error {
+	s.cancel()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.bus == nil {
+		return nil
+	}
+	s.logger().Debug("connection closed", "event", EventDisconnect, "dial", s.dial)
+	err := s.bus.Close()
+	s.bus = nil
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
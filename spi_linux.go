@@ -0,0 +1,107 @@
+//go:build linux
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// ioctls from linux/spi/spidev.h
+const (
+	spiIOCWrMode       = 0x40016b01
+	spiIOCWrBitsPerWrd = 0x40016b03
+	spiIOCWrMaxSpeedHz = 0x40046b04
+	spiIOCMessage1     = 0x40206b00 //SPI_IOC_MESSAGE(1)
+)
+
+/*spiIOCTransfer mirrors struct spi_ioc_transfer*/
+type spiIOCTransfer struct {
+	txBuf, rxBuf   uint64
+	len, speedHz   uint32
+	delayUsecs     uint16
+	bitsPerWord    uint8
+	csChange       uint8
+	txNbits        uint8
+	rxNbits        uint8
+	wordDelayUsecs uint8
+	pad            uint8
+}
+
+type spidev struct {
+	f   *os.File
+	cfg SPIConfig
+}
+
+func openSPI(dev string, cfg SPIConfig) (spiBus, error) {
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &spidev{f: f, cfg: cfg}
+	mode, bits, speed := cfg.Mode, cfg.Bits, cfg.Speed
+	for _, set := range []struct {
+		name string
+		req  uintptr
+		arg  unsafe.Pointer
+	}{
+		{"SPI_IOC_WR_MODE", spiIOCWrMode, unsafe.Pointer(&mode)},
+		{"SPI_IOC_WR_BITS_PER_WORD", spiIOCWrBitsPerWrd, unsafe.Pointer(&bits)},
+		{"SPI_IOC_WR_MAX_SPEED_HZ", spiIOCWrMaxSpeedHz, unsafe.Pointer(&speed)},
+	} {
+		if err := s.ioctl(set.name, set.req, set.arg); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *spidev) ioctl(name string, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, s.f.Fd(), req, uintptr(arg)); errno != 0 {
+		return os.NewSyscallError("ioctl "+name, errno)
+	}
+	return nil
+}
+
+func (s *spidev) transfer(tx, rx []byte) error {
+	xfer := spiIOCTransfer{
+		txBuf:       uint64(uintptr(unsafe.Pointer(&tx[0]))),
+		rxBuf:       uint64(uintptr(unsafe.Pointer(&rx[0]))),
+		len:         uint32(len(tx)),
+		speedHz:     s.cfg.Speed,
+		bitsPerWord: s.cfg.Bits,
+	}
+	err := s.ioctl("SPI_IOC_MESSAGE", spiIOCMessage1, unsafe.Pointer(&xfer))
+	runtime.KeepAlive(tx)
+	runtime.KeepAlive(rx)
+	return err
+}
+
+func (s *spidev) Close() error { return s.f.Close() }
@@ -0,0 +1,122 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+/*fakeSPI answers every transfer with the bitwise inverse of what was sent*/
+type fakeSPI struct {
+	sent   bytes.Buffer
+	fail   bool
+	closed bool
+}
+
+func (f *fakeSPI) Close() error { f.closed = true; return nil }
+func (f *fakeSPI) transfer(tx, rx []byte) error {
+	if f.fail {
+		return fmt.Errorf("transfer failed")
+	}
+	f.sent.Write(tx)
+	for i, b := range tx {
+		rx[i] = ^b
+	}
+	return nil
+}
+
+func TestParseSPIConfig(t *testing.T) {
+	if cfg, err := parseSPIConfig(""); err != nil || cfg != (SPIConfig{Speed: 500000, Bits: 8}) {
+		t.Error("Unexpected defaults", cfg, err)
+	}
+	if cfg, err := parseSPIConfig("speed=1000000&mode=3&bits=16"); err != nil || cfg != (SPIConfig{Speed: 1000000, Mode: 3, Bits: 16}) {
+		t.Error("Unexpected config", cfg, err)
+	}
+	for _, q := range []string{"mode=4", "speed=fast", "parity=none", "bits=256"} {
+		if _, err := parseSPIConfig(q); err == nil {
+			t.Error("Expected an error for", q)
+		}
+	}
+}
+
+func TestSPIClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"spi://dev/spidev0.0", "spi:///dev/spidev0.0?mode=7"} {
+		if _, err := NewSPIClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	if _, err := NewIDoIO(ctx, time.Second, "spi:///dev/does-not-exist?speed=1000"); err == nil {
+		t.Error("Expected an error opening a missing device")
+	}
+
+	bus := &fakeSPI{}
+	var got SPIConfig
+	s := newSPIClient(ctx, time.Second, "/dev/spidev0.0", SPIConfig{Speed: 1000, Mode: 1, Bits: 8}, func(dev string, cfg SPIConfig) (spiBus, error) {
+		got = cfg
+		return bus, nil
+	})
+	if err := s.Open(); err != nil || got.Mode != 1 {
+		t.Fatal("Expected the device to be configured", got, err)
+	}
+	_ = s.String()
+	buf := make([]byte, 8)
+	if _, err := s.Read(buf); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout with nothing clocked in", err)
+	}
+	if n, err := s.Write([]byte{0x00, 0x0f}); err != nil || n != 2 {
+		t.Error("Unable to write", n, err)
+	}
+	s.Write([]byte{0xff})
+	if n, err := s.Read(buf[:2]); err != nil || !bytes.Equal(buf[:n], []byte{0xff, 0xf0}) {
+		t.Error("Expected the bytes clocked in by the first write", buf[:n], err)
+	}
+	if n, err := s.Read(buf); err != nil || !bytes.Equal(buf[:n], []byte{0x00}) {
+		t.Error("Expected the rest buffered for the next read", buf[:n], err)
+	}
+	bus.fail = true
+	if _, err := s.Write([]byte{1}); err == nil || IsTemporary(err) {
+		t.Error("Expected a failed transfer to be reported", err)
+	}
+	bus.fail = false
+	s.Write([]byte{1})
+	if err := s.Open(); err != nil {
+		t.Error("Unable to reopen", err)
+	}
+	if _, err := s.Read(buf); err == nil {
+		t.Error("Expected reopening to discard buffered bytes")
+	}
+	if err := s.Close(); err != nil || !bus.closed {
+		t.Error("Expected the device to be closed", err)
+	}
+	if _, err := s.Write([]byte{1}); err == nil {
+		t.Error("Expected writes to fail once closed")
+	}
+}
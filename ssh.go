@@ -0,0 +1,292 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO = &SSHClient{}
+
+	//sshCommand is the OpenSSH client run by SSHClient
+	sshCommand = "ssh"
+)
+
+/*
+SSHClient provides an implementer of the IDoIO interface over the stdin and
+stdout of a command (or shell) run on a remote host via ssh, under the URI
+regime:
+
+	ssh://
+
+The session is run by the system's OpenSSH client in batch mode, so
+authentication must not need a password: keys come from the key parameter,
+an agent, or ssh_config, which is also honored for host aliases, known hosts,
+etc.  A jump host may be given to reach instruments behind one.
+
+Reads wait briefly for output, as a NetClient does.  Once the session ends,
+Read returns a permanent error including whatever the ssh client reported on
+stderr, and Open starts a new session.
+*/
+type SSHClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	dial      string
+	args      []string //for the ssh client
+	timeout   time.Duration
+	rwtimeout time.Duration
+	log       Logger //nil means LoggerFrom(ctx)
+
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	stderr *sshStderr
+	done   chan struct{} //closed once cmd has been waited for
+	err    error         //why cmd ended, valid once done is closed
+}
+
+/*sshStderr keeps the start of what the ssh client writes to stderr, for error messages*/
+type sshStderr struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *sshStderr) Write(b []byte) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if room := 1024 - s.buf.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		s.buf.Write(b[:room])
+	}
+	return len(b), nil
+}
+
+func (s *sshStderr) String() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return strings.TrimSpace(s.buf.String())
+}
+
+/*
+NewSSHClient starts a remote command via ssh.  Dial should be in the form of
+"ssh://[<user>@]<host>[:<port>][/<command>][?key=<path>&jump=<[user@]host[:port]>]",
+eg "ssh://obs@gateway:22/usr/local/bin/console?key=/home/obs/.ssh/id_ed25519".
+Without a command, the remote login shell is run.  Timeout bounds connecting.
+*/
+func NewSSHClient(ctx context.Context, timeout time.Duration, dial string) (*SSHClient, error) {
+	d, err := dialFor(dial, "ssh")
+	if err != nil {
+		return nil, err
+	}
+	host, port, hasPort := strings.Cut(d.Host, ":")
+	if _, perr := strconv.ParseUint(port, 10, 16); host == "" || hasPort && perr != nil {
+		return nil, newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	args := []string{"-T", "-o", "BatchMode=yes"}
+	if timeout > 0 {
+		secs := int((timeout + time.Second - 1) / time.Second)
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", secs))
+	}
+	if d.User != "" {
+		args = append(args, "-l", d.User)
+	}
+	if hasPort {
+		args = append(args, "-p", port)
+	}
+	for k, v := range d.Params {
+		switch k {
+		case "key":
+			args = append(args, "-i", v[0])
+		case "jump":
+			args = append(args, "-J", v[0])
+		default:
+			return nil, newErr(false, false, fmt.Errorf("unknown parameter %q in %q", k, dial))
+		}
+	}
+	args = append(args, "--", host)
+	if d.Path != "" && d.Path != "/" {
+		args = append(args, d.Path)
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	sc := &SSHClient{ctx: sctx, cancel: cancel, dial: dial, args: args, timeout: timeout, rwtimeout: 1 * time.Millisecond}
+	return sc, openUnlessLazy(ctx, sc)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this SSHClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (sc *SSHClient) SetLogger(l Logger) {
+	sc.log = l
+}
+
+func (sc *SSHClient) dialString() string { return sc.dial }
+
+func (sc *SSHClient) logger() Logger {
+	if sc.log != nil {
+		return sc.log
+	}
+	return LoggerFrom(sc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (sc *SSHClient) String() string {
+	return fmt.Sprintf("ssh session %v", sc.dial)
+}
+
+/*Open forcibly ends any existing session, then starts a new one*/
+func (sc *SSHClient) Open() error {
+	select {
+	case <-sc.ctx.Done():
+		return newErr(false, false, sc.ctx.Err())
+	default:
+	}
+	sc.end()
+	//os.Pipe, unlike exec.Cmd's pipes, allows deadlines to be set
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return newErr(false, false, err)
+	}
+	cmd := exec.Command(sshCommand, sc.args...)
+	cmd.Stdin, cmd.Stdout, sc.stderr = inR, outW, &sshStderr{}
+	cmd.Stderr = sc.stderr
+	err = cmd.Start()
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		sc.logger().Warn("unable to start ssh", "event", EventError, "dial", sc.dial, "error", err)
+		return newErr(false, false, errors.Wrap(err, "unable to start ssh"))
+	}
+	sc.cmd, sc.stdin, sc.stdout, sc.done = cmd, inW, outR, make(chan struct{})
+	go func(done chan struct{}) {
+		sc.err = cmd.Wait()
+		close(done)
+	}(sc.done)
+	sc.logger().Debug("connection opened", "event", EventConnect, "dial", sc.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader, returning output of the remote command*/
+func (sc *SSHClient) Read(b []byte) (int, error) {
+	select {
+	case <-sc.ctx.Done():
+		defer sc.Close()
+		return 0, newErr(false, false, sc.ctx.Err())
+	default:
+	}
+	if sc.stdout == nil {
+		return 0, readErr
+	}
+	if sc.rwtimeout > 0 {
+		sc.stdout.SetReadDeadline(time.Now().Add(sc.rwtimeout))
+	}
+	n, err := sc.stdout.Read(b)
+	if err != nil && !os.IsTimeout(err) {
+		err = sc.ended(err)
+	} else if err != nil {
+		err = newErr(true, true, err)
+	}
+	return n, err
+}
+
+/*Write conforms to io.Writer, sending b to the remote command*/
+func (sc *SSHClient) Write(b []byte) (int, error) {
+	select {
+	case <-sc.ctx.Done():
+		defer sc.Close()
+		return 0, newErr(false, false, sc.ctx.Err())
+	default:
+	}
+	if sc.stdin == nil {
+		return 0, writeErr
+	}
+	n, err := sc.stdin.Write(b)
+	if err != nil {
+		err = sc.ended(err)
+	}
+	return n, err
+}
+
+/*ended explains err, from a session that has (or is about to have) ended*/
+func (sc *SSHClient) ended(err error) error {
+	select {
+	case <-sc.done:
+		if sc.err != nil {
+			err = sc.err
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+	if msg := sc.stderr.String(); msg != "" {
+		err = errors.Wrap(err, msg)
+	}
+	sc.logger().Debug("ssh session ended", "event", EventError, "dial", sc.dial, "error", err)
+	return newErr(false, false, err)
+}
+
+/*Close conforms to io.Closer, ending the session*/
+func (sc *SSHClient) Close() error {
+	sc.cancel()
+	sc.end()
+	return nil
+}
+
+/*end closes stdin, giving the session a moment to finish, then kills it*/
+func (sc *SSHClient) end() {
+	if sc.cmd == nil {
+		return
+	}
+	sc.stdin.Close()
+	select {
+	case <-sc.done:
+	case <-time.After(100 * time.Millisecond):
+		sc.cmd.Process.Kill()
+		<-sc.done
+	}
+	sc.stdout.Close()
+	sc.cmd, sc.stdin, sc.stdout = nil, nil, nil
+	sc.logger().Debug("connection closed", "event", EventDisconnect, "dial", sc.dial)
+}
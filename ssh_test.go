@@ -0,0 +1,101 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*fakeSSH replaces the ssh client with a script, returning where it records its arguments*/
+func fakeSSH(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir := t.TempDir()
+	path, args := filepath.Join(dir, "ssh"), filepath.Join(dir, "args")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho \"$@\" > "+args+"\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := sshCommand
+	sshCommand = path
+	t.Cleanup(func() { sshCommand = old })
+	return args
+}
+
+func TestSSHClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"ssh://", "ssh://host?password=x"} {
+		if _, err := NewSSHClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+
+	args := fakeSSH(t, "exec cat")
+	a, err := NewArbiter(ctx, time.Second, "ssh://obs@gateway:2222/usr/bin/console -v?key=/keys/id&jump=bastion")
+	if err != nil {
+		t.Fatal("Unable to start", err)
+	}
+	defer a.Close()
+	echo := Command{Name: "echo", Prototype: "ping\n", Timeout: time.Second, Response: regexp.MustCompile("ping\n")}
+	if rsp := a.Control(echo); rsp.Error != nil {
+		t.Error("Expected the remote command to echo", rsp.Error)
+	}
+	got, _ := os.ReadFile(args)
+	for _, want := range []string{"-T", "BatchMode=yes", "ConnectTimeout=1", "-l obs", "-p 2222", "-i /keys/id", "-J bastion", "-- gateway /usr/bin/console -v"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("Expected %q in the ssh arguments %q", want, got)
+		}
+	}
+
+	fakeSSH(t, "echo 'Permission denied (publickey).' >&2; exit 255")
+	sc, err := NewSSHClient(ctx, time.Second, "ssh://host")
+	if err != nil {
+		t.Fatal("Unable to start", err)
+	}
+	defer sc.Close()
+	_ = sc.String()
+	buf := make([]byte, 64)
+	for {
+		if _, err = sc.Read(buf); err == nil || !IsTemporary(err) {
+			break
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Error("Expected the ssh failure to be reported", err)
+	}
+	sc.Close()
+	if _, err := sc.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail once closed")
+	}
+}
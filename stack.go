@@ -0,0 +1,565 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Layer ranks determine where a Layer sits in a Stack.  Lower ranks sit closer
+to the transport, so e.g. a tap always records the raw bytes, whatever order
+the Layers were given in.
+*/
+const (
+	RankReconnect    = 10
+	RankLogging      = 20
+	RankTap          = 30
+	RankMetrics      = 40
+	RankTransform    = 45
+	RankFraming      = 50
+	RankTriggers     = 60
+	RankStoreForward = 70
+)
+
+/*
+Layer is a single wrapper in a Stack.  Layers are made by the With* functions,
+by NewLayer for wrappers outside this package, or by parsing a Stack's
+description (see ParseStack).
+*/
+type Layer struct {
+	Name   string
+	Rank   int
+	Params map[string]string //rendered in the description, and read back by ParseStack
+	wrap   func(ctx context.Context, inner IDoIO) (IDoIO, error)
+}
+
+/*NewLayer returns a Layer that wraps IDoIOs with wrap*/
+func NewLayer(name string, rank int, params map[string]string, wrap func(ctx context.Context, inner IDoIO) (IDoIO, error)) Layer {
+	return Layer{Name: name, Rank: rank, Params: params, wrap: wrap}
+}
+
+/*String conforms to fmt.Stringer, returning the layer as name(key=value,...)*/
+func (l Layer) String() string {
+	if len(l.Params) == 0 {
+		return l.Name
+	}
+	keys := make([]string, 0, len(l.Params))
+	for k := range l.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + l.Params[k]
+	}
+	return fmt.Sprintf("%s(%s)", l.Name, strings.Join(keys, ","))
+}
+
+/*
+Stack describes a transport and the Layers wrapped around it.  Its String is a
+textual description, e.g.
+
+	tcp://gps:4001 | log | tap(size=4096) | frame(split=lines)
+
+that ParseStack turns back into an equivalent Stack, so stacks can live in
+configuration files.
+*/
+type Stack struct {
+	Dial   string
+	Layers []Layer //in rank order, transport outwards
+}
+
+/*NewStack returns a Stack over dial with layers sorted (stably) by rank*/
+func NewStack(dial string, layers ...Layer) *Stack {
+	s := &Stack{Dial: dial, Layers: append([]Layer{}, layers...)}
+	sort.SliceStable(s.Layers, func(i, j int) bool { return s.Layers[i].Rank < s.Layers[j].Rank })
+	return s
+}
+
+/*String conforms to fmt.Stringer, returning the Stack's textual description*/
+func (s *Stack) String() string {
+	parts := []string{s.Dial}
+	for _, l := range s.Layers {
+		parts = append(parts, l.String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+/*
+Build opens the Stack's transport with NewIDoIO and wraps it in each Layer in
+turn.  As with NewArbiter, if the transport fails to open the (wrapped) IDoIO
+is still returned alongside the error, so it may be reopened later.
+*/
+func (s *Stack) Build(ctx context.Context, timeout time.Duration) (IDoIO, error) {
+	idoio, openErr := NewIDoIO(ctx, timeout, s.Dial)
+	if _, invalid := idoio.(InvalidIO); invalid {
+		return idoio, openErr
+	}
+	for _, l := range s.Layers {
+		wrapped, err := l.wrap(ctx, idoio)
+		if err != nil {
+			idoio.Close()
+			return InvalidIO(s.String()), newErr(false, false, errors.Wrapf(err, "unable to apply layer %v", l))
+		}
+		idoio = wrapped
+	}
+	return idoio, openErr
+}
+
+/*Build is shorthand for NewStack(dial, layers...).Build(ctx, timeout)*/
+func Build(ctx context.Context, timeout time.Duration, dial string, layers ...Layer) (IDoIO, error) {
+	return NewStack(dial, layers...).Build(ctx, timeout)
+}
+
+/*
+Wrapper wraps an IDoIO in another, adding some cross-cutting concern (logging,
+throttling, framing, ...) without regard to the transport underneath.
+*/
+type Wrapper func(IDoIO) IDoIO
+
+/*
+Chain returns a Wrapper applying each of ws in turn, so the first sits closest
+to the transport and the last is outermost.  Nil Wrappers are skipped.
+*/
+func Chain(ws ...Wrapper) Wrapper {
+	ws = append([]Wrapper{}, ws...)
+	return func(idoio IDoIO) IDoIO {
+		for _, w := range ws {
+			if w != nil {
+				idoio = w(idoio)
+			}
+		}
+		return idoio
+	}
+}
+
+/*Layer returns a Layer named name, of the given rank, that applies w*/
+func (w Wrapper) Layer(name string, rank int) Layer {
+	return NewLayer(name, rank, nil, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return w(inner), nil
+	})
+}
+
+/*
+RegisterWrapper allows w to be referenced by name from stack descriptions, and
+so from dial strings given to NewIDoIO, e.g. "tcp://gps:4001 | name".  The
+layer takes no parameters.
+*/
+func RegisterWrapper(name string, rank int, w Wrapper) error {
+	return RegisterLayer(name, func(params map[string]string) (Layer, error) {
+		if len(params) > 0 {
+			return Layer{}, fmt.Errorf("takes no parameters")
+		}
+		return w.Layer(name, rank), nil
+	})
+}
+
+/*isStackDesc reports if dial is a Stack's description rather than a bare dial string*/
+func isStackDesc(dial string) bool {
+	return strings.Contains(dial, "|")
+}
+
+/*
+LayerParser makes a Layer from the parameters in its textual description. See
+RegisterLayer.
+*/
+type LayerParser func(params map[string]string) (Layer, error)
+
+var layerParsers = struct {
+	sync.RWMutex
+	parsers map[string]LayerParser
+}{parsers: map[string]LayerParser{
+	"log":          func(map[string]string) (Layer, error) { return WithLogging(nil), nil },
+	"tap":          parseTapLayer,
+	"rate":         parseRateLayer,
+	"frame":        parseFrameLayer,
+	"storeforward": parseStoreForwardLayer,
+	"armor":        parseArmorLayer,
+	"escape":       parseEscapeLayer,
+	"parity":       parseParityLayer,
+	"telnet":       func(map[string]string) (Layer, error) { return WithTelnet(), nil },
+	"ansi":         parseANSILayer,
+}}
+
+/*
+RegisterLayer allows ParseStack to understand layers named name, typically
+those made with NewLayer.  An error is returned if name is taken.
+*/
+func RegisterLayer(name string, parse LayerParser) error {
+	layerParsers.Lock()
+	defer layerParsers.Unlock()
+	if _, taken := layerParsers.parsers[name]; taken || name == "" {
+		return newErr(false, false, fmt.Errorf("layer %q is already registered", name))
+	}
+	layerParsers.parsers[name] = parse
+	return nil
+}
+
+/*
+ParseStack parses the textual description of a Stack (see Stack.String).
+Layers that hold functions or other live values (e.g. WithTriggers) can not be
+described, and so can not be parsed.
+*/
+func ParseStack(desc string) (*Stack, error) {
+	parts := strings.Split(desc, "|")
+	dial := strings.TrimSpace(parts[0])
+	if dial == "" {
+		return nil, newErr(false, false, fmt.Errorf("stack %q has no dial string", desc))
+	}
+	var layers []Layer
+	for _, part := range parts[1:] {
+		name, params, err := parseLayerDesc(strings.TrimSpace(part))
+		if err != nil {
+			return nil, newErr(false, false, errors.Wrapf(err, "stack %q", desc))
+		}
+		layerParsers.RLock()
+		parse, ok := layerParsers.parsers[name]
+		layerParsers.RUnlock()
+		if !ok {
+			return nil, newErr(false, false, fmt.Errorf("stack %q: unknown layer %q", desc, name))
+		}
+		l, err := parse(params)
+		if err != nil {
+			return nil, newErr(false, false, errors.Wrapf(err, "stack %q: layer %q", desc, name))
+		}
+		layers = append(layers, l)
+	}
+	return NewStack(dial, layers...), nil
+}
+
+/*parseLayerDesc splits name(key=value,...) into its name and parameters*/
+func parseLayerDesc(desc string) (name string, params map[string]string, err error) {
+	params = map[string]string{}
+	name = desc
+	if i := strings.Index(desc, "("); i >= 0 {
+		if !strings.HasSuffix(desc, ")") {
+			return "", nil, fmt.Errorf("unbalanced parentheses in %q", desc)
+		}
+		name = desc[:i]
+		if args := strings.TrimSpace(desc[i+1 : len(desc)-1]); args != "" {
+			for _, kv := range strings.Split(args, ",") {
+				k, v, ok := strings.Cut(kv, "=")
+				if !ok {
+					return "", nil, fmt.Errorf("expected key=value, got %q in %q", kv, desc)
+				}
+				params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		return "", nil, fmt.Errorf("empty layer")
+	}
+	return name, params, nil
+}
+
+/*paramDuration reads an optional duration parameter*/
+func paramDuration(params map[string]string, key string) (time.Duration, error) {
+	v, ok := params[key]
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	return d, errors.Wrapf(err, "%s", key)
+}
+
+/*paramInt reads an optional integer parameter*/
+func paramInt(params map[string]string, key string) (int64, error) {
+	v, ok := params[key]
+	if !ok {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	return i, errors.Wrapf(err, "%s", key)
+}
+
+/*
+WithLogging logs every read and write at Debug level, and non-temporary errors
+at Warn level, to l.  A nil l logs to the Logger of the context the Stack is
+built with.
+*/
+func WithLogging(l Logger) Layer {
+	return NewLayer("log", RankLogging, nil, func(ctx context.Context, inner IDoIO) (IDoIO, error) {
+		if l == nil {
+			l = LoggerFrom(ctx)
+		}
+		return &loggingIO{IDoIO: inner, log: l}, nil
+	})
+}
+
+type loggingIO struct {
+	IDoIO
+	log Logger
+}
+
+func (lio *loggingIO) dialString() string { return dialOf(lio.IDoIO) }
+
+func (lio *loggingIO) Read(b []byte) (int, error) {
+	n, err := lio.IDoIO.Read(b)
+	lio.logIO(Rx, b[:n], err)
+	return n, err
+}
+
+func (lio *loggingIO) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, lio.IDoIO, b)
+	lio.logIO(Rx, b[:n], err)
+	return n, err
+}
+
+func (lio *loggingIO) Write(b []byte) (int, error) {
+	n, err := lio.IDoIO.Write(b)
+	lio.logIO(Tx, b[:n], err)
+	return n, err
+}
+
+func (lio *loggingIO) logIO(dir Direction, b []byte, err error) {
+	if len(b) > 0 {
+		lio.log.Debug("traffic", "dial", dialOf(lio.IDoIO), "direction", dir.String(), "bytes", fmt.Sprintf("%q", b))
+	}
+	if err != nil && !IsTemporary(err) {
+		lio.log.Warn(dir.String()+" failed", "event", EventError, "dial", dialOf(lio.IDoIO), "error", err)
+	}
+}
+
+/*WithTap retains the last n bytes of traffic in a RingTap*/
+func WithTap(n int) Layer {
+	return NewLayer("tap", RankTap, map[string]string{"size": strconv.Itoa(n)}, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewRingTap(inner, n), nil
+	})
+}
+
+func parseTapLayer(params map[string]string) (Layer, error) {
+	n, err := paramInt(params, "size")
+	if err != nil || n <= 0 {
+		return Layer{}, fmt.Errorf("a positive size is required")
+	}
+	return WithTap(int(n)), nil
+}
+
+/*
+WithMetrics measures data rates with a RateMonitor.  Only the Interval,
+Smoothing, Floor and StallAfter of cfg are described.
+*/
+func WithMetrics(cfg RateMonitorConfig) Layer {
+	params := map[string]string{}
+	if cfg.Interval > 0 {
+		params["interval"] = cfg.Interval.String()
+	}
+	if cfg.Smoothing > 0 {
+		params["smoothing"] = cfg.Smoothing.String()
+	}
+	if cfg.Floor > 0 {
+		params["floor"] = strconv.FormatFloat(cfg.Floor, 'g', -1, 64)
+	}
+	if cfg.StallAfter > 0 {
+		params["stall"] = cfg.StallAfter.String()
+	}
+	return NewLayer("rate", RankMetrics, params, func(ctx context.Context, inner IDoIO) (IDoIO, error) {
+		return NewRateMonitor(ctx, inner, cfg), nil
+	})
+}
+
+func parseRateLayer(params map[string]string) (l Layer, err error) {
+	cfg := RateMonitorConfig{}
+	if cfg.Interval, err = paramDuration(params, "interval"); err != nil {
+		return
+	}
+	if cfg.Smoothing, err = paramDuration(params, "smoothing"); err != nil {
+		return
+	}
+	if cfg.StallAfter, err = paramDuration(params, "stall"); err != nil {
+		return
+	}
+	if v, ok := params["floor"]; ok {
+		if cfg.Floor, err = strconv.ParseFloat(v, 64); err != nil {
+			return l, errors.Wrap(err, "floor")
+		}
+	}
+	return WithMetrics(cfg), nil
+}
+
+/*WithTriggers feeds everything read to t (see Triggers.Wrap). It can not be parsed.*/
+func WithTriggers(t *Triggers) Layer {
+	return NewLayer("triggers", RankTriggers, nil, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return t.Wrap(inner), nil
+	})
+}
+
+/*
+WithStoreForward queues writes made while the link is down in a StoreForward.
+Only the Path, TTL, MaxEntries and MaxBytes of cfg are described.
+*/
+func WithStoreForward(cfg StoreForwardConfig) Layer {
+	params := map[string]string{"path": cfg.Path}
+	if cfg.TTL > 0 {
+		params["ttl"] = cfg.TTL.String()
+	}
+	if cfg.MaxEntries > 0 {
+		params["entries"] = strconv.Itoa(cfg.MaxEntries)
+	}
+	if cfg.MaxBytes > 0 {
+		params["bytes"] = strconv.FormatInt(cfg.MaxBytes, 10)
+	}
+	return NewLayer("storeforward", RankStoreForward, params, func(ctx context.Context, inner IDoIO) (IDoIO, error) {
+		return NewStoreForward(ctx, inner, cfg)
+	})
+}
+
+func parseStoreForwardLayer(params map[string]string) (l Layer, err error) {
+	cfg := StoreForwardConfig{Path: params["path"]}
+	if cfg.TTL, err = paramDuration(params, "ttl"); err != nil {
+		return
+	}
+	var n int64
+	if n, err = paramInt(params, "entries"); err != nil {
+		return
+	}
+	cfg.MaxEntries = int(n)
+	if cfg.MaxBytes, err = paramInt(params, "bytes"); err != nil {
+		return
+	}
+	return WithStoreForward(cfg), nil
+}
+
+var splitFuncs = struct {
+	sync.RWMutex
+	funcs map[string]bufio.SplitFunc
+}{funcs: map[string]bufio.SplitFunc{
+	"lines": bufio.ScanLines,
+	"words": bufio.ScanWords,
+	"bytes": bufio.ScanBytes,
+	"nmea":  ScanNMEA,
+	"ubx":   ScanUBX,
+	"rtcm3": ScanRTCM3,
+}}
+
+/*
+RegisterSplitFunc names split so that it can be used in a parsed frame layer,
+e.g. "frame(split=name)".  An error is returned if name is taken.
+*/
+func RegisterSplitFunc(name string, split bufio.SplitFunc) error {
+	splitFuncs.Lock()
+	defer splitFuncs.Unlock()
+	if _, taken := splitFuncs.funcs[name]; taken || name == "" {
+		return newErr(false, false, fmt.Errorf("split func %q is already registered", name))
+	}
+	splitFuncs.funcs[name] = split
+	return nil
+}
+
+/*
+WithFraming makes each Read return a single token found by split (see
+bufio.Scanner), or as much of it as fits.  name is used in the description,
+and must have been registered with RegisterSplitFunc (the bufio Scan* funcs
+are registered as "lines", "words" and "bytes") for the layer to be parsed.
+*/
+func WithFraming(name string, split bufio.SplitFunc) Layer {
+	return NewLayer("frame", RankFraming, map[string]string{"split": name}, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewFramedIO(inner, split), nil
+	})
+}
+
+func parseFrameLayer(params map[string]string) (Layer, error) {
+	name := params["split"]
+	splitFuncs.RLock()
+	split, ok := splitFuncs.funcs[name]
+	splitFuncs.RUnlock()
+	if !ok {
+		return Layer{}, fmt.Errorf("unknown split func %q", name)
+	}
+	return WithFraming(name, split), nil
+}
+
+/*
+FramedIO wraps an IDoIO so that each Read returns a single token, as found by
+a bufio.SplitFunc, rather than whatever bytes happened to arrive.  A token
+larger than the Read buffer is returned over several Reads.  Writes pass
+straight through.
+*/
+type FramedIO struct {
+	IDoIO
+	split   bufio.SplitFunc
+	buf     []byte //data read but not yet tokenized
+	pending []byte //remainder of a token too large for the last Read
+	scratch []byte
+}
+
+/*NewFramedIO returns a FramedIO tokenizing idoio with split*/
+func NewFramedIO(idoio IDoIO, split bufio.SplitFunc) *FramedIO {
+	return &FramedIO{IDoIO: idoio, split: split, scratch: make([]byte, 4096)}
+}
+
+func (f *FramedIO) dialString() string { return dialOf(f.IDoIO) }
+
+/*String conforms to fmt.Stringer*/
+func (f *FramedIO) String() string { return fmt.Sprintf("Framed %v", f.IDoIO) }
+
+/*
+Read conforms to io.Reader.  Underlying errors are only returned when no
+complete token is available; data already buffered is kept for the next Read.
+Empty tokens are skipped.
+*/
+func (f *FramedIO) Read(b []byte) (int, error) {
+	if len(f.pending) > 0 {
+		n := copy(b, f.pending)
+		f.pending = f.pending[n:]
+		return n, nil
+	}
+	for {
+		var adv int
+		var tok []byte
+		var err error
+		if len(f.buf) > 0 { //as with bufio.Scanner, split never sees empty data
+			adv, tok, err = f.split(f.buf, false)
+		}
+		if err != nil {
+			f.buf = nil
+			return 0, newErr(false, false, errors.Wrap(err, "framing"))
+		}
+		if adv > 0 || len(tok) > 0 {
+			tok = cloneBytes(tok)
+			f.buf = f.buf[adv:]
+			if len(tok) == 0 {
+				continue //skipped data, or an empty token
+			}
+			n := copy(b, tok)
+			f.pending = tok[n:]
+			return n, nil
+		}
+		n, err := f.IDoIO.Read(f.scratch)
+		f.buf = append(f.buf, f.scratch[:n]...)
+		if err != nil && n == 0 {
+			return 0, err
+		}
+	}
+}
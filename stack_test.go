@@ -0,0 +1,191 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseStack(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "q")
+	s := NewStack("tcp://localhost:1",
+		WithStoreForward(StoreForwardConfig{Path: journal, TTL: time.Minute}),
+		WithFraming("lines", bufio.ScanLines),
+		WithTap(64),
+		WithMetrics(RateMonitorConfig{Interval: time.Second, Floor: 2.5}),
+		WithLogging(nil),
+	)
+	desc := s.String()
+	t.Log(desc)
+	expected := "tcp://localhost:1 | log | tap(size=64) | rate(floor=2.5,interval=1s) | frame(split=lines) | storeforward(path=" + journal + ",ttl=1m0s)"
+	if desc != expected {
+		t.Errorf("Expected layers in rank order\n%s\ngot\n%s", expected, desc)
+	}
+	parsed, err := ParseStack(desc)
+	if err != nil || parsed.String() != desc {
+		t.Error("Expected the description to round trip", parsed, err)
+	}
+	if parsed, err = ParseStack("tcp://localhost:1|tap(size=8)|log"); err != nil || parsed.String() != "tcp://localhost:1 | log | tap(size=8)" {
+		t.Error("Expected layers to be reordered by rank", parsed, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"| log",
+		"tcp://localhost:1 | nope",
+		"tcp://localhost:1 | tap",
+		"tcp://localhost:1 | tap(size=x)",
+		"tcp://localhost:1 | tap(size=1",
+		"tcp://localhost:1 | tap(size)",
+		"tcp://localhost:1 | rate(interval=soon)",
+		"tcp://localhost:1 | frame(split=nmea2)",
+		"tcp://localhost:1 | ",
+	} {
+		if _, err := ParseStack(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+
+	if err := RegisterLayer("tap", parseTapLayer); err == nil {
+		t.Error("Expected a duplicate layer to be rejected")
+	}
+	if err := RegisterSplitFunc("lines", bufio.ScanLines); err == nil {
+		t.Error("Expected a duplicate split func to be rejected")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	trig := NewTriggers(0)
+	var matched []string
+	trig.Register("rxd", regexp.MustCompile(`Rxd>\d`), 0, func(m Match) { matched = append(matched, string(m.Bytes)) })
+	idoio, err := Build(ctx, time.Second, dial, WithTriggers(trig), WithFraming("bytes", bufio.ScanBytes), WithTap(16))
+	if err != nil {
+		t.Error("Unable to build", err)
+		t.FailNow()
+	}
+	defer idoio.Close()
+	t.Log(idoio)
+
+	idoio.Write([]byte("ABC"))
+	got := []byte{}
+	b := make([]byte, 16)
+	deadline := time.Now().Add(time.Second)
+	for len(got) < 5 && time.Now().Before(deadline) {
+		n, _ := idoio.Read(b)
+		if n > 1 {
+			t.Error("Expected a single byte per read", n)
+		}
+		got = append(got, b[:n]...)
+	}
+	if string(got) != "Rxd>3" || len(matched) != 1 {
+		t.Errorf("Unexpected traffic %q, matches %v", got, matched)
+	}
+
+	if _, err := Build(ctx, time.Second, "nope://x", WithTap(1)); err == nil {
+		t.Error("Expected an unknown dial string to fail")
+	}
+	failing := NewLayer("fail", 0, nil, func(context.Context, IDoIO) (IDoIO, error) { return nil, ErrQueueFull })
+	if _, err := Build(ctx, time.Second, dial, failing); err == nil {
+		t.Error("Expected a failing layer to fail the build")
+	}
+}
+
+func TestFramedIO(t *testing.T) {
+	inner := &bufIO{}
+	inner.rx.WriteString("first\r\n\nsecond line\nthi")
+	f := NewFramedIO(inner, bufio.ScanLines)
+	b := make([]byte, 6)
+	for _, expected := range []string{"first", "second", " line"} {
+		if n, err := f.Read(b); err != nil || string(b[:n]) != expected {
+			t.Errorf("Expected %q, got %q %v", expected, b[:n], err)
+		}
+	}
+	if n, err := f.Read(b); err == nil || !IsTimeout(err) || n != 0 {
+		t.Error("Expected a timeout while the token is incomplete", n, err)
+	}
+	inner.rx.WriteString("rd\n")
+	if n, err := f.Read(b); err != nil || string(b[:n]) != "third" {
+		t.Errorf("Expected the buffered token to complete, got %q %v", b[:n], err)
+	}
+}
+
+type countingIO struct {
+	IDoIO
+	writes *int
+}
+
+func (c countingIO) Write(b []byte) (int, error) {
+	*c.writes++
+	return c.IDoIO.Write(b)
+}
+
+func TestChain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+
+	var order []string
+	named := func(name string) Wrapper {
+		return func(inner IDoIO) IDoIO { order = append(order, name); return inner }
+	}
+	Chain(named("inner"), nil, named("outer"))(&bufIO{})
+	if len(order) != 2 || order[0] != "inner" || order[1] != "outer" {
+		t.Error("Expected wrappers to be applied transport outwards", order)
+	}
+
+	writes := 0
+	counting := Wrapper(func(inner IDoIO) IDoIO { return countingIO{IDoIO: inner, writes: &writes} })
+	if err := RegisterWrapper("counting", RankMetrics, counting); err != nil {
+		t.Fatal("Unable to register wrapper", err)
+	}
+	if err := RegisterWrapper("counting", RankMetrics, counting); err == nil {
+		t.Error("Expected a duplicate wrapper to be rejected")
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, dial+" | counting | log")
+	if err != nil {
+		t.Fatal("Unable to build from a dial string", err)
+	}
+	defer idoio.Close()
+	idoio.Write([]byte("A"))
+	if writes != 1 {
+		t.Error("Expected the registered wrapper to see the write", writes)
+	}
+	for _, bad := range []string{dial + " | counting(x=1)", dial + " | nope", " | log"} {
+		if _, err := NewIDoIO(ctx, time.Second, bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
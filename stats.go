@@ -0,0 +1,238 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*ConnStats is a snapshot of the traffic through an IDoIO, and of its connection history*/
+type ConnStats struct {
+	BytesRead    uint64
+	BytesWritten uint64
+	Reopens      uint64        //successful Opens after the first, including reconnections made by Read and Write
+	LastError    error         //the last error other than a timeout, nil if there has been none
+	LastErrorAt  time.Time     //when LastError happened
+	OpenedAt     time.Time     //when the connection was last opened, zero if it is not open
+	Uptime       time.Duration //how long the connection has been open, zero if it is not
+}
+
+/*String conforms to fmt.Stringer*/
+func (cs ConnStats) String() string {
+	return fmt.Sprintf("rx %d tx %d bytes, %d reopens, up %v, last error: %v", cs.BytesRead, cs.BytesWritten, cs.Reopens, cs.Uptime, cs.LastError)
+}
+
+/*
+StatsReporter is implemented by IDoIOs that keep ConnStats, for health
+reporting.  Discover it with a type assertion.
+*/
+type StatsReporter interface {
+	Stats() ConnStats
+}
+
+/*ConnState is where a connection is in its life*/
+type ConnState int
+
+/*The ConnStates*/
+const (
+	StateClosed  ConnState = iota //never opened, or closed
+	StateOpening                  //an Open is in progress
+	StateOpen                     //opened, with no permanent error since
+	StateErrored                  //the last Open, Read or Write failed permanently
+	StateUnknown                  //the IDoIO does not keep track
+)
+
+/*String conforms to fmt.Stringer*/
+func (s ConnState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpening:
+		return "opening"
+	case StateOpen:
+		return "open"
+	case StateErrored:
+		return "errored"
+	default:
+		return "unknown"
+	}
+}
+
+/*ConnStatus is a snapshot of the state of a connection*/
+type ConnStatus struct {
+	State       ConnState
+	LastError   error     //the last error other than a timeout, nil if there has been none
+	LastErrorAt time.Time //when LastError happened
+	OpenedAt    time.Time //when the connection was last opened, zero if it is not open
+	ClosedAt    time.Time //when the connection was last closed or failed, zero if it never has
+}
+
+/*String conforms to fmt.Stringer*/
+func (cs ConnStatus) String() string {
+	if cs.LastError == nil {
+		return cs.State.String()
+	}
+	return fmt.Sprintf("%v, last error: %v", cs.State, cs.LastError)
+}
+
+/*
+StatusReporter is implemented by IDoIOs that keep track of their ConnState,
+for monitoring.  Discover it with a type assertion.
+*/
+type StatusReporter interface {
+	Status() ConnStatus
+	IsOpen() bool
+}
+
+/*
+connStats is embedded by the transports keeping ConnStats, giving them a
+Stats method that is safe to call while they are in use
+*/
+type connStats struct {
+	statsMux sync.Mutex
+	stats    ConnStats
+	opens    uint64
+	state    ConnState
+	closedAt time.Time
+	hooks    Hooks
+	ident    string        //the dial string passed to hooks and logged
+	trace    Trace         //what to log, fixed at construction
+	logger   func() Logger //nil until instrumented
+}
+
+/*Stats conforms to StatsReporter*/
+func (c *connStats) Stats() ConnStats {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+	cs := c.stats
+	if !cs.OpenedAt.IsZero() {
+		cs.Uptime = time.Since(cs.OpenedAt)
+	}
+	return cs
+}
+
+/*Status conforms to StatusReporter*/
+func (c *connStats) Status() ConnStatus {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+	return ConnStatus{State: c.state, LastError: c.stats.LastError, LastErrorAt: c.stats.LastErrorAt,
+		OpenedAt: c.stats.OpenedAt, ClosedAt: c.closedAt}
+}
+
+/*IsOpen conforms to StatusReporter*/
+func (c *connStats) IsOpen() bool {
+	return c.Status().State == StateOpen
+}
+
+/*opening notes that an Open has started*/
+func (c *connStats) opening() {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+	c.state = StateOpening
+}
+
+/*opened counts the result of an Open*/
+func (c *connStats) opened(err error) {
+	c.statsMux.Lock()
+	var notify func()
+	if err != nil {
+		c.stats.OpenedAt = time.Time{}
+		notify = c.failed(err)
+	} else {
+		if c.opens++; c.opens > 1 {
+			c.stats.Reopens++
+		}
+		c.stats.OpenedAt, c.state = time.Now(), StateOpen
+		notify = c.onOpen(c.opens > 1)
+	}
+	c.statsMux.Unlock()
+	notify()
+}
+
+/*closed notes that the connection is no longer open*/
+func (c *connStats) closed() {
+	c.statsMux.Lock()
+	c.stats.OpenedAt, c.state, c.closedAt = time.Time{}, StateClosed, time.Now()
+	notify := c.onClose()
+	c.statsMux.Unlock()
+	notify()
+}
+
+/*read counts the result of a Read*/
+func (c *connStats) read(n int, err error) {
+	c.statsMux.Lock()
+	c.stats.BytesRead += uint64(n)
+	notify := c.failed(err)
+	c.statsMux.Unlock()
+	c.traceIO(TraceRead, n, err)
+	notify()
+}
+
+/*wrote counts the result of a Write*/
+func (c *connStats) wrote(n int, err error) {
+	c.statsMux.Lock()
+	c.stats.BytesWritten += uint64(n)
+	notify := c.failed(err)
+	c.statsMux.Unlock()
+	c.traceIO(TraceWrite, n, err)
+	notify()
+}
+
+/*
+failed records err as the last error, unless it is nil or a timeout, and if it
+is permanent notes that the connection has failed.  It returns a func calling
+the OnError hook as required (see onError).  statsMux must be held.
+*/
+func (c *connStats) failed(err error) func() {
+	if err == nil || IsTimeout(err) {
+		return func() {}
+	}
+	c.stats.LastError, c.stats.LastErrorAt = err, time.Now()
+	if !IsTemporary(err) && c.state != StateClosed {
+		c.state, c.closedAt = StateErrored, c.stats.LastErrorAt
+	}
+	return c.onError(err)
+}
+
+/*tracing reports whether t is to be logged*/
+func (c *connStats) tracing(t Trace) bool {
+	return c.logger != nil && c.trace&t != 0
+}
+
+/*traceIO logs the outcome of a read or write, if that is being traced*/
+func (c *connStats) traceIO(op Trace, n int, err error) {
+	name := "read"
+	if op == TraceWrite {
+		name = "write"
+	}
+	if n > 0 && c.tracing(op) {
+		c.logger().Debug(name, "event", EventTraffic, "dial", c.ident, "bytes", n)
+	}
+	if err != nil && IsTimeout(err) && c.tracing(TraceTimeout) {
+		c.logger().Debug(name+" timed out", "event", EventTimeout, "dial", c.ident)
+	}
+}
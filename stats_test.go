@@ -0,0 +1,152 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnStats(t *testing.T) {
+	var c connStats
+	if cs := c.Stats(); cs.Uptime != 0 || cs.LastError != nil {
+		t.Error("Expected nothing to report before opening", cs)
+	}
+	c.opened(nil)
+	c.read(10, nil)
+	c.wrote(4, newErr(true, true, errors.New("timeout")))
+	c.read(0, newErr(true, true, errors.New("timeout")))
+	time.Sleep(5 * time.Millisecond)
+	cs := c.Stats()
+	if cs.BytesRead != 10 || cs.BytesWritten != 4 || cs.Reopens != 0 {
+		t.Error("Unexpected counts", cs)
+	}
+	if cs.LastError != nil {
+		t.Error("Expected timeouts not to count as errors", cs.LastError)
+	}
+	if cs.Uptime < 5*time.Millisecond {
+		t.Error("Expected to have been up a while", cs.Uptime)
+	}
+
+	broken := errors.New("broken")
+	c.wrote(0, broken)
+	c.closed()
+	c.opened(errors.New("refused"))
+	c.opened(nil)
+	cs = c.Stats()
+	if cs.Reopens != 1 || cs.LastError == nil || cs.LastError.Error() != "refused" || cs.LastErrorAt.IsZero() {
+		t.Error("Expected one reopen after a failed attempt", cs)
+	}
+	c.closed()
+	if cs = c.Stats(); cs.Uptime != 0 || !cs.OpenedAt.IsZero() {
+		t.Error("Expected no uptime once closed", cs)
+	}
+	_ = cs.String()
+}
+
+func TestNetClient_Stats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	nc, err := NewNetClient(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer nc.Close()
+	var sr StatsReporter = nc
+	nc.Write([]byte("dead cat"))
+	<-time.After(20 * time.Millisecond)
+	buf := make([]byte, 64)
+	n, _ := nc.Read(buf)
+	if err := nc.Open(); err != nil {
+		t.Fatal("Unable to reopen", err)
+	}
+	cs := sr.Stats()
+	if cs.BytesWritten != 8 || cs.BytesRead != uint64(n) || n == 0 || cs.Reopens != 1 || cs.Uptime == 0 {
+		t.Error("Unexpected stats", cs)
+	}
+}
+
+func TestConnStats_Status(t *testing.T) {
+	var c connStats
+	if cs := c.Status(); cs.State != StateClosed || c.IsOpen() {
+		t.Error("Expected to start closed", cs)
+	}
+	c.opening()
+	if cs := c.Status(); cs.State != StateOpening {
+		t.Error("Expected to be opening", cs)
+	}
+	c.opened(nil)
+	c.read(0, newErr(true, true, errors.New("timeout")))
+	c.wrote(0, newErr(true, false, errors.New("busy")))
+	if cs := c.Status(); cs.State != StateOpen || !c.IsOpen() || cs.OpenedAt.IsZero() {
+		t.Error("Expected temporary errors to leave the connection open", cs)
+	}
+	c.read(0, errors.New("reset"))
+	if cs := c.Status(); cs.State != StateErrored || cs.LastError == nil || cs.ClosedAt.IsZero() {
+		t.Error("Expected a permanent error to be noted", cs)
+	}
+	c.opening()
+	c.opened(errors.New("refused"))
+	if cs := c.Status(); cs.State != StateErrored || cs.LastError.Error() != "refused" {
+		t.Error("Expected a failed open to be noted", cs)
+	}
+	c.closed()
+	c.read(0, errors.New("closed"))
+	if cs := c.Status(); cs.State != StateClosed {
+		t.Error("Expected to stay closed", cs)
+	}
+	_ = c.Status().String()
+	for s := StateClosed; s <= StateUnknown; s++ {
+		_ = s.String()
+	}
+}
+
+func TestArb_Status(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, arbHandler)
+	a, err := NewArbiter(ctx, time.Second, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	if !a.(*Arb).IsOpen() {
+		t.Error("Expected an open Arbiter", a.(*Arb).Status())
+	}
+	a.Close()
+	if cs := a.(*Arb).Status(); cs.State != StateClosed {
+		t.Error("Expected a closed Arbiter", cs)
+	}
+
+	b, stop := Arbitrate(ctx, &bufIO{})
+	defer stop()
+	if cs := b.(*Arb).Status(); cs.State != StateUnknown {
+		t.Error("Expected an Arbiter over a bufIO not to know its state", cs)
+	}
+}
@@ -0,0 +1,339 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ErrQueueFull is returned by StoreForward.Write when accepting the data would
+exceed the configured capacity.  It is neither temporary nor a timeout.
+*/
+var ErrQueueFull = newErr(false, false, errors.New("store and forward queue is full"))
+
+/*StoreForwardConfig controls a StoreForward's journal and limits*/
+type StoreForwardConfig struct {
+	//Path is the journal file queued writes are persisted to. It is required.
+	Path string
+
+	//TTL is how long queued writes remain eligible to be sent. Zero means forever.
+	TTL time.Duration
+
+	//MaxEntries caps the number of queued writes. Zero means unlimited.
+	MaxEntries int
+
+	//MaxBytes caps the total size of queued writes. Zero means unlimited.
+	MaxBytes int64
+
+	//RetryInterval is how often a down link is reopened and the queue flushed. Defaults to 5s.
+	RetryInterval time.Duration
+}
+
+type sfEntry struct {
+	queued time.Time
+	data   []byte
+}
+
+var _ IDoIO = &StoreForward{}
+var _ ContextReader = &StoreForward{}
+
+/*
+StoreForward wraps an IDoIO so that writes made while the link is down are
+persisted to disk and sent, in order, once the link returns.  This allows
+commands issued during an outage of an intermittent link (e.g. satellite) to
+be delivered late rather than failing outright.
+
+A Write is accepted (and reports success) once it has either been written to
+the underlying IDoIO or been queued.  Once anything is queued, all later
+writes are queued behind it so ordering is preserved.  The queue is flushed
+whenever Open succeeds, when Flush is called, and periodically in the
+background until the context passed to NewStoreForward is done.  Queued writes
+older than TTL are discarded rather than sent.
+
+Reads pass straight through to the underlying IDoIO.
+*/
+type StoreForward struct {
+	idotoo IDoIO
+	cfg    StoreForwardConfig
+	ctx    context.Context
+
+	mux   sync.Mutex
+	queue []sfEntry
+	size  int64
+	down  bool
+}
+
+/*
+NewStoreForward returns a StoreForward over idoio, reloading any writes left
+queued in cfg.Path by a previous run.
+*/
+func NewStoreForward(ctx context.Context, idoio IDoIO, cfg StoreForwardConfig) (*StoreForward, error) {
+	if cfg.Path == "" {
+		return nil, newErr(false, false, fmt.Errorf("a journal path is required"))
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 5 * time.Second
+	}
+	sf := &StoreForward{idotoo: idoio, cfg: cfg, ctx: ctx}
+	if err := sf.load(); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "unable to load journal %q", cfg.Path))
+	}
+	go sf.run()
+	return sf, nil
+}
+
+/*String conforms to fmt.Stringer*/
+func (sf *StoreForward) String() string {
+	return fmt.Sprintf("StoreForward(%d queued) over %v", sf.Pending(), sf.idotoo)
+}
+
+func (sf *StoreForward) dialString() string { return dialOf(sf.idotoo) }
+
+/*Pending returns the number of queued writes*/
+func (sf *StoreForward) Pending() int {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return len(sf.queue)
+}
+
+/*Read conforms to io.Reader*/
+func (sf *StoreForward) Read(b []byte) (int, error) { return sf.idotoo.Read(b) }
+
+/*ReadContext conforms to ContextReader*/
+func (sf *StoreForward) ReadContext(ctx context.Context, b []byte) (int, error) {
+	return readContext(ctx, sf.idotoo, b)
+}
+
+/*Close conforms to io.Closer. The queue remains in the journal.*/
+func (sf *StoreForward) Close() error { return sf.idotoo.Close() }
+
+/*Open conforms to IDoIO, flushing the queue if the underlying IDoIO opens*/
+func (sf *StoreForward) Open() error {
+	if err := sf.idotoo.Open(); err != nil {
+		return err
+	}
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	sf.down = false
+	return sf.flush()
+}
+
+/*
+Write conforms to io.Writer, queueing b if it cannot be written now.  The only
+error returned is ErrQueueFull, or an error persisting the queue.
+*/
+func (sf *StoreForward) Write(b []byte) (int, error) {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	if len(sf.queue) == 0 && !sf.down {
+		n, err := sf.idotoo.Write(b)
+		if err == nil {
+			return n, nil
+		}
+		if !IsTemporary(err) {
+			sf.down = true
+			LoggerFrom(sf.ctx).Warn("link down, queueing writes", "event", EventError, "dial", dialOf(sf.idotoo), "error", err)
+		}
+		if err := sf.enqueue(b[n:]); err != nil {
+			return n, err
+		}
+		return len(b), nil
+	}
+	if err := sf.enqueue(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+/*Flush attempts to send everything queued, returning the first error encountered*/
+func (sf *StoreForward) Flush() error {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return sf.flush()
+}
+
+/*run periodically reopens a down link and flushes the queue*/
+func (sf *StoreForward) run() {
+	tick := time.NewTicker(sf.cfg.RetryInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-sf.ctx.Done():
+			return
+		case <-tick.C:
+		}
+		sf.mux.Lock()
+		pending, down := len(sf.queue), sf.down
+		sf.mux.Unlock()
+		switch {
+		case pending == 0:
+		case down:
+			LoggerFrom(sf.ctx).Info("reopening to flush queued writes", "event", EventRetry, "dial", dialOf(sf.idotoo), "queued", pending)
+			sf.Open()
+		default:
+			sf.Flush()
+		}
+	}
+}
+
+/*enqueue adds b to the queue and journal. The caller must hold sf.mux.*/
+func (sf *StoreForward) enqueue(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	sf.expire()
+	if (sf.cfg.MaxEntries > 0 && len(sf.queue)+1 > sf.cfg.MaxEntries) ||
+		(sf.cfg.MaxBytes > 0 && sf.size+int64(len(b)) > sf.cfg.MaxBytes) {
+		return ErrQueueFull
+	}
+	e := sfEntry{queued: time.Now(), data: cloneBytes(b)}
+	f, err := os.OpenFile(sf.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to journal write"))
+	}
+	defer f.Close()
+	if err := writeEntry(f, e); err != nil {
+		return newErr(false, false, errors.Wrap(err, "unable to journal write"))
+	}
+	sf.queue = append(sf.queue, e)
+	sf.size += int64(len(b))
+	return nil
+}
+
+/*
+flush sends queued writes in order until the queue is empty or a write fails,
+then rewrites the journal. The caller must hold sf.mux.
+*/
+func (sf *StoreForward) flush() (err error) {
+	if len(sf.queue) == 0 {
+		return nil
+	}
+	sf.expire()
+	for len(sf.queue) > 0 {
+		e := &sf.queue[0]
+		var n int
+		if n, err = sf.idotoo.Write(e.data); err != nil {
+			e.data = e.data[n:]
+			sf.size -= int64(n)
+			if !IsTemporary(err) {
+				sf.down = true
+			}
+			break
+		}
+		sf.size -= int64(len(e.data))
+		sf.queue = sf.queue[1:]
+	}
+	if jerr := sf.save(); err == nil {
+		err = jerr
+	}
+	return
+}
+
+/*expire drops queued writes older than the TTL. The caller must hold sf.mux.*/
+func (sf *StoreForward) expire() {
+	if sf.cfg.TTL <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-sf.cfg.TTL)
+	for len(sf.queue) > 0 && sf.queue[0].queued.Before(cutoff) {
+		sf.size -= int64(len(sf.queue[0].data))
+		sf.queue = sf.queue[1:]
+	}
+}
+
+/*save atomically rewrites the journal from the queue*/
+func (sf *StoreForward) save() error {
+	tmp := sf.cfg.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range sf.queue {
+		if err = writeEntry(w, e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, sf.cfg.Path)
+}
+
+/*load reads the journal into the queue, tolerating a truncated final entry*/
+func (sf *StoreForward) load() error {
+	f, err := os.Open(sf.cfg.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		var hdr [12]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		sf.queue = append(sf.queue, sfEntry{queued: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[:8]))), data: data})
+		sf.size += int64(len(data))
+	}
+	sf.expire()
+	sf.down = len(sf.queue) > 0
+	return nil
+}
+
+/*writeEntry writes a journal entry: 8 bytes of unix nanoseconds, 4 bytes of length, and the data*/
+func writeEntry(w io.Writer, e sfEntry) error {
+	var hdr [12]byte
+	binary.BigEndian.PutUint64(hdr[:8], uint64(e.queued.UnixNano()))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(e.data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(e.data)
+	return err
+}
@@ -0,0 +1,152 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*flakyIO is a bufIO whose writes and opens fail while down is set*/
+type flakyIO struct {
+	bufIO
+	mux  sync.Mutex
+	down bool
+}
+
+func (f *flakyIO) setDown(down bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.down = down
+}
+
+func (f *flakyIO) sent() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.tx.String()
+}
+
+func (f *flakyIO) Open() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.down {
+		return newErr(false, false, errors.New("flakyIO: link down"))
+	}
+	return nil
+}
+
+func (f *flakyIO) Write(p []byte) (int, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.down {
+		return 0, newErr(false, false, errors.New("flakyIO: link down"))
+	}
+	return f.tx.Write(p)
+}
+
+func TestNewStoreForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewStoreForward(ctx, &flakyIO{}, StoreForwardConfig{}); err == nil {
+		t.Error("Expected an error without a journal path")
+	}
+
+	journal := filepath.Join(t.TempDir(), "queue")
+	inner := &flakyIO{}
+	sf, err := NewStoreForward(ctx, inner, StoreForwardConfig{Path: journal, RetryInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sf.String()
+	sf.Write([]byte("a"))
+	inner.setDown(true)
+	for _, s := range []string{"b", "c"} {
+		if n, err := sf.Write([]byte(s)); n != 1 || err != nil {
+			t.Error("Expected the write to be queued", n, err)
+		}
+	}
+	if sf.Pending() != 2 || inner.sent() != "a" {
+		t.Error("Expected 2 queued writes", sf.Pending(), inner.sent())
+	}
+
+	//a new StoreForward over the same journal picks up where the last left off
+	inner2 := &flakyIO{}
+	sf2, err := NewStoreForward(ctx, inner2, StoreForwardConfig{Path: journal, RetryInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf2.Pending() != 2 {
+		t.Error("Expected the journal to be reloaded", sf2.Pending())
+	}
+	sf2.Write([]byte("d")) //queued behind the reloaded writes
+	if err := sf2.Open(); err != nil {
+		t.Error("Unable to open", err)
+	}
+	if sf2.Pending() != 0 || inner2.sent() != "bcd" {
+		t.Errorf("Expected the queue to be flushed in order, got %q (%d left)", inner2.sent(), sf2.Pending())
+	}
+	sf3, _ := NewStoreForward(ctx, &flakyIO{}, StoreForwardConfig{Path: journal, RetryInterval: time.Hour})
+	if sf3.Pending() != 0 {
+		t.Error("Expected the flushed journal to be empty", sf3.Pending())
+	}
+}
+
+func TestStoreForward_Limits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inner := &flakyIO{down: true}
+	sf, _ := NewStoreForward(ctx, inner, StoreForwardConfig{
+		Path:          filepath.Join(t.TempDir(), "queue"),
+		MaxEntries:    2,
+		MaxBytes:      5,
+		TTL:           50 * time.Millisecond,
+		RetryInterval: 10 * time.Millisecond,
+	})
+	sf.Write([]byte("12"))
+	sf.Write([]byte("34"))
+	if _, err := sf.Write([]byte("5")); err != ErrQueueFull {
+		t.Error("Expected the entry limit to be hit", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	sf.Write([]byte("567"))
+	if _, err := sf.Write([]byte("890")); err != ErrQueueFull {
+		t.Error("Expected the byte limit to be hit", err)
+	}
+
+	//the expired writes are dropped, the retry loop sends the rest
+	inner.setDown(false)
+	deadline := time.Now().Add(time.Second)
+	for sf.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := inner.sent(); got != "567" {
+		t.Errorf("Expected only the unexpired write to be sent, got %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import "context"
+
+/*streamChunk is the size of the buffer Stream reads into*/
+const streamChunk = 4096
+
+/*
+Stream continuously reads idoio, delivering whatever is read on the first
+channel returned, each chunk being a fresh slice the receiver may keep.
+Timeouts and other temporary errors are handled internally by reading again.
+The first permanent error is delivered on the second channel, after which (or
+once ctx is done) both channels are closed.  The IDoIO is neither opened nor
+closed, so wrap it in a Reconnector to stream across dropped connections.
+*/
+func Stream(ctx context.Context, idoio IDoIO) (<-chan []byte, <-chan error) {
+	chunks, errs := make(chan []byte), make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(chunks)
+		buf := make([]byte, streamChunk)
+		for ctx.Err() == nil {
+			n, err := idoio.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- append([]byte(nil), buf[:n]...):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil && !IsTemporary(err) {
+				errs <- err
+				return
+			}
+		}
+	}()
+	return chunks, errs
+}
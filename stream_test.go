@@ -0,0 +1,70 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, b := NewMemPair(ctx)
+	chunks, errs := Stream(ctx, b)
+
+	go func() {
+		a.Write([]byte("Hello"))
+		time.Sleep(10 * time.Millisecond) //a few timeouts in between
+		a.Write([]byte(", World"))
+		time.Sleep(10 * time.Millisecond)
+		a.Close()
+	}()
+	got := ""
+	for chunk := range chunks {
+		got += string(chunk)
+	}
+	if got != "Hello, World" {
+		t.Errorf("Expected everything written to be streamed, got %q", got)
+	}
+	if err := <-errs; err == nil || err.Error() != io.EOF.Error() {
+		t.Error("Expected the closed connection to end the stream with io.EOF", err)
+	}
+	if _, open := <-errs; open {
+		t.Error("Expected the error channel to be closed")
+	}
+
+	sctx, scancel := context.WithCancel(ctx)
+	c, _ := NewMemPair(ctx)
+	chunks, errs = Stream(sctx, c)
+	scancel()
+	for range chunks {
+	}
+	if err, open := <-errs; open || err != nil {
+		t.Error("Expected a cancelled stream to end without error", err)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO         = &Tee{}
+	_ ContextReader = &Tee{}
+)
+
+func init() {
+	known["tee"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewTeeClient(ctx, dur, dial)
+	}
+}
+
+/*
+Tee wraps an IDoIO, copying everything read from and written to it to one or
+more sinks, such as a file capturing the raw traffic alongside its live
+processing.  Sinks receive the traffic of both directions, as it happens.  A
+sink that fails other than temporarily is logged and dropped, without
+disturbing the IDoIO.
+*/
+type Tee struct {
+	idotoo IDoIO
+	ctx    context.Context //whose Logger is used
+	dial   string          //empty unless built from one
+	mux    sync.Mutex      //guards sinks
+	sinks  []io.Writer
+	owned  []io.Closer //sinks opened from the dial string, closed by Close
+	log    Logger      //nil means LoggerFrom(ctx)
+}
+
+/*NewTee returns a Tee over idoio copying its traffic to sinks*/
+func NewTee(idoio IDoIO, sinks ...io.Writer) *Tee {
+	return &Tee{idotoo: idoio, ctx: context.Background(), sinks: sinks}
+}
+
+/*
+NewTeeClient opens a Tee over another dial string.  Dial should be in the
+form of
+
+	tee://(<dial>)?log=<path>[&log=<path>...][&sink=<dial>...]
+
+eg "tee://(tcp://host:5000)?log=/data/raw.bin".  Files named by log are
+appended to, and the IDoIOs given by sink (eg a udp:// collector) are written
+to.  Timeout is used when opening each.
+*/
+func NewTeeClient(ctx context.Context, timeout time.Duration, dial string) (*Tee, error) {
+	d, err := dialFor(dial, "tee")
+	dials, query := d.Dials, d.Params.Encode()
+	if err == nil && len(dials) != 1 {
+		err = fmt.Errorf("exactly one dial string may be teed")
+	}
+	if err == nil && factoryFor(dials[0]) == nil {
+		err = fmt.Errorf("no known way to open %q", dials[0])
+	}
+	var q url.Values
+	if err == nil {
+		q, err = url.ParseQuery(query)
+	}
+	for k := range q {
+		if err == nil && k != "log" && k != "sink" {
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+	}
+	if err == nil && len(q) == 0 {
+		err = fmt.Errorf("at least one log or sink is required")
+	}
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+
+	t := &Tee{ctx: ctx, dial: dial}
+	for _, path := range q["log"] {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			t.closeOwned()
+			return nil, newErr(false, false, errors.Wrap(err, "unable to open log"))
+		}
+		t.sinks, t.owned = append(t.sinks, f), append(t.owned, f)
+	}
+	for _, d := range q["sink"] {
+		sink, err := NewIDoIO(eager(ctx), timeout, d) //sinks are not reopened with the Tee
+		if err != nil {
+			if sink != nil {
+				sink.Close()
+			}
+			t.closeOwned()
+			return nil, newErr(false, false, errors.Wrapf(err, "unable to open sink %q", d))
+		}
+		t.sinks, t.owned = append(t.sinks, sink), append(t.owned, sink)
+	}
+	t.idotoo, err = NewIDoIO(ctx, timeout, dials[0])
+	return t, err
+}
+
+/*
+SetLogger overrides the Logger carried by the context this Tee was
+constructed with (see WithLogger), which reports sinks dropped.
+*/
+func (t *Tee) SetLogger(l Logger) {
+	t.log = l
+}
+
+func (t *Tee) logger() Logger {
+	if t.log != nil {
+		return t.log
+	}
+	return LoggerFrom(t.ctx)
+}
+
+func (t *Tee) dialString() string {
+	if t.dial != "" {
+		return t.dial
+	}
+	return dialOf(t.idotoo)
+}
+
+/*String conforms to fmt.Stringer*/
+func (t *Tee) String() string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return fmt.Sprintf("Tee over %v to %d sinks", t.idotoo, len(t.sinks))
+}
+
+/*Open conforms to IDoIO, reopening the IDoIO teed.  Sinks are left as they are.*/
+func (t *Tee) Open() error { return t.idotoo.Open() }
+
+/*Close conforms to io.Closer, closing the IDoIO teed, and any sinks opened from a dial string*/
+func (t *Tee) Close() error {
+	err := t.idotoo.Close()
+	t.closeOwned()
+	return err
+}
+
+/*Read conforms to io.Reader, copying whatever was read to the sinks*/
+func (t *Tee) Read(b []byte) (int, error) {
+	n, err := t.idotoo.Read(b)
+	t.copy(b[:n])
+	return n, err
+}
+
+/*ReadContext conforms to ContextReader, copying whatever was read to the sinks*/
+func (t *Tee) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, t.idotoo, b)
+	t.copy(b[:n])
+	return n, err
+}
+
+/*Write conforms to io.Writer, copying whatever was written to the sinks*/
+func (t *Tee) Write(b []byte) (int, error) {
+	n, err := t.idotoo.Write(b)
+	t.copy(b[:n])
+	return n, err
+}
+
+/*copy writes b to every sink, dropping those that fail permanently*/
+func (t *Tee) copy(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	kept := t.sinks[:0]
+	for _, s := range t.sinks {
+		if _, err := s.Write(b); err != nil && !IsTemporary(err) {
+			t.logger().Warn("tee sink dropped", "event", EventError, "dial", t.dialString(), "error", err)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	t.sinks = kept
+}
+
+func (t *Tee) closeOwned() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, c := range t.owned {
+		c.Close()
+	}
+	t.owned, t.sinks = nil, nil
+}
@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*brokenWriter always fails*/
+type brokenWriter struct{}
+
+func (brokenWriter) Write(b []byte) (int, error) { return 0, errors.New("broken") }
+
+func TestTee(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	near, far := NewMemPair(ctx)
+	var sink bytes.Buffer
+	tee := NewTee(near, &sink, brokenWriter{})
+	_ = tee.String()
+	if dialOf(tee) != dialOf(near) {
+		t.Error("Expected the dial string of the IDoIO teed")
+	}
+	tee.Write([]byte("ping"))
+	far.Write([]byte("pong"))
+	buf := make([]byte, 16)
+	for i := 0; i < 1000; i++ {
+		if n, _ := tee.Read(buf); n > 0 {
+			break
+		}
+	}
+	if sink.String() != "pingpong" {
+		t.Errorf("Expected both directions teed, got %q", sink.String())
+	}
+	if len(tee.sinks) != 1 {
+		t.Error("Expected the broken sink to be dropped")
+	}
+}
+
+func TestTeeClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := filepath.Join(t.TempDir(), "raw.bin")
+	os.WriteFile(log, []byte("old"), 0644)
+	for _, dial := range []string{"tee://(null://)", "tee://(null://,null://)?log=" + log, "tee://(bogus://x)?log=" + log, "tee://(null://)?x=1", "tee://(null://)?sink=bogus://x", "tee://(null://)?log=" + t.TempDir()} {
+		if _, err := NewTeeClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+
+	_, svr, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", svr, arbHandler)
+	collector, err := NewMemClient(ctx, time.Second, "mem://teecollector")
+	if err != nil {
+		t.Fatal("Unable to open the collector", err)
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, fmt.Sprintf("tee://(%v)?log=%v&sink=mem://teecollector", dial, log))
+	if err != nil {
+		t.Fatal("Unable to tee", err)
+	}
+	tee := idoio.(*Tee)
+	a, stop := Arbitrate(ctx, tee)
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected a response", rsp.Error)
+	}
+	stop()
+	tee.Close()
+
+	want := "ABCRxd>3"
+	if got, _ := os.ReadFile(log); string(got) != "old"+want {
+		t.Errorf("Expected the traffic appended to the log, got %q", got)
+	}
+	var got []byte
+	buf := make([]byte, 16)
+	for i := 0; i < 1000 && len(got) < len(want); i++ {
+		n, _ := collector.Read(buf)
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != want {
+		t.Errorf("Expected the traffic written to the sink, got %q", got)
+	}
+}
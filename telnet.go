@@ -0,0 +1,267 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Telnet command bytes (RFC 854)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptBinary = 0
+	telnetOptSGA    = 3
+)
+
+/*telnet parser states*/
+const (
+	tnData = iota
+	tnIAC
+	tnOption //awaiting the option following WILL/WONT/DO/DONT
+	tnSub    //inside a subnegotiation
+	tnSubIAC //IAC seen inside a subnegotiation
+	tnCR     //CR seen in data
+)
+
+var _ IDoIO = &TelnetIO{}
+
+/*
+TelnetIO strips Telnet (RFC 854) command sequences from a stream, such as that
+of a console server in telnet mode reached via plain tcp://, so that the 0xFF
+bytes introducing them stop corrupting payloads.  It is not a telnet client:
+option negotiation is answered just enough to keep the server happy, by
+agreeing to BINARY and SUPPRESS-GO-AHEAD in both directions, and refusing
+everything else.  Subnegotiations and other commands are discarded, escaped
+0xFF (IAC IAC) bytes are unescaped, and 0xFF bytes written are escaped.  The
+CR NUL sequence of the network virtual terminal is read as a bare CR.
+*/
+type TelnetIO struct {
+	IDoIO
+	wmux    sync.Mutex //serializes writes, including negotiation replies
+	state   int
+	verb    byte
+	replied map[[2]byte]bool //replies already sent, to avoid negotiation loops
+	agree   map[byte]bool    //options agreed to besides BINARY and SUPPRESS-GO-AHEAD
+	sub     []byte           //the subnegotiation being received
+	onSub   func([]byte)     //if not nil, handed each subnegotiation received
+}
+
+/*NewTelnetIO returns a TelnetIO over idoio*/
+func NewTelnetIO(idoio IDoIO) *TelnetIO {
+	return &TelnetIO{IDoIO: idoio, replied: map[[2]byte]bool{}, agree: map[byte]bool{}}
+}
+
+/*WithTelnet strips telnet command sequences, described as "telnet"*/
+func WithTelnet() Layer {
+	return NewLayer("telnet", RankTransform, nil, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewTelnetIO(inner), nil
+	})
+}
+
+func (tio *TelnetIO) dialString() string { return dialOf(tio.IDoIO) }
+
+/*String conforms to fmt.Stringer*/
+func (tio *TelnetIO) String() string { return fmt.Sprintf("Telnet stripped %v", tio.IDoIO) }
+
+/*Open conforms to IDoIO, forgetting any negotiation*/
+func (tio *TelnetIO) Open() error {
+	tio.state, tio.replied, tio.sub = tnData, map[[2]byte]bool{}, nil
+	return tio.IDoIO.Open()
+}
+
+/*
+Write conforms to io.Writer, escaping 0xFF bytes.  On error, the count returned
+is of the bytes of b that went out, not of the escaped bytes.
+*/
+func (tio *TelnetIO) Write(b []byte) (int, error) {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+		out = append(out, c)
+	}
+	tio.wmux.Lock()
+	defer tio.wmux.Unlock()
+	if written, err := tio.write(out); err != nil {
+		return unescaped(b, written), err
+	}
+	return len(b), nil
+}
+
+/*write writes all of out to the underlying IDoIO, which must be locked by wmux*/
+func (tio *TelnetIO) write(out []byte) (written int, err error) {
+	for written < len(out) {
+		var n int
+		n, err = tio.IDoIO.Write(out[written:])
+		written += n
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+/*
+unescaped returns how many bytes of b were written when n bytes of its escaped
+form were; a 0xFF of which only the first half of its escape went out is not
+counted.
+*/
+func unescaped(b []byte, n int) (m int) {
+	for _, c := range b {
+		if n--; c == telnetIAC {
+			n--
+		}
+		if n < 0 {
+			break
+		}
+		m++
+	}
+	return
+}
+
+/*
+Read conforms to io.Reader, returning the data with telnet commands removed.
+Negotiation replies are written as a side effect, and an error writing them is
+returned along with whatever data was read.  Reads consisting entirely of
+telnet commands are not reported; the underlying IDoIO is read again.
+*/
+func (tio *TelnetIO) Read(b []byte) (int, error) {
+	for {
+		n, out, err := tio.read(b)
+		if out > 0 || n == 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+/*read reads into b, returning the bytes read and the bytes left after stripping*/
+func (tio *TelnetIO) read(b []byte) (n, out int, err error) {
+	n, err = tio.IDoIO.Read(b)
+	var replies []byte
+	for _, c := range b[:n] {
+		switch tio.state {
+		case tnData, tnCR:
+			switch {
+			case c == telnetIAC:
+				tio.state = tnIAC
+			case tio.state == tnCR && c == 0:
+				tio.state = tnData
+			default:
+				b[out] = c
+				out++
+				tio.state = tnData
+				if c == '\r' {
+					tio.state = tnCR
+				}
+			}
+		case tnIAC:
+			switch c {
+			case telnetIAC:
+				b[out] = c
+				out++
+				tio.state = tnData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				tio.verb, tio.state = c, tnOption
+			case telnetSB:
+				tio.state, tio.sub = tnSub, tio.sub[:0]
+			default: //NOP, GA, etc
+				tio.state = tnData
+			}
+		case tnOption:
+			replies = append(replies, tio.answer(tio.verb, c)...)
+			tio.state = tnData
+		case tnSub:
+			if c == telnetIAC {
+				tio.state = tnSubIAC
+			} else {
+				tio.sub = append(tio.sub, c)
+			}
+		case tnSubIAC:
+			tio.state = tnSub
+			switch c {
+			case telnetIAC:
+				tio.sub = append(tio.sub, c)
+			case telnetSE:
+				tio.state = tnData
+				if tio.onSub != nil {
+					tio.onSub(append([]byte(nil), tio.sub...))
+				}
+			}
+		}
+	}
+	if len(replies) > 0 {
+		tio.wmux.Lock()
+		_, werr := tio.write(replies)
+		tio.wmux.Unlock()
+		if err == nil && werr != nil {
+			err = errors.Wrap(werr, "unable to answer telnet negotiation")
+		}
+	}
+	return
+}
+
+/*answer returns the reply, if any, to a negotiation of opt*/
+func (tio *TelnetIO) answer(verb, opt byte) []byte {
+	wanted := opt == telnetOptBinary || opt == telnetOptSGA || tio.agree[opt]
+	var reply byte
+	switch {
+	case verb == telnetDO && wanted:
+		reply = telnetWILL
+	case verb == telnetDO, verb == telnetDONT:
+		reply = telnetWONT
+	case verb == telnetWILL && wanted:
+		reply = telnetDO
+	default:
+		reply = telnetDONT
+	}
+	key := [2]byte{reply, opt}
+	if tio.replied[key] {
+		return nil
+	}
+	//a fresh reply to this option supersedes any other sent for it
+	for _, v := range []byte{telnetWILL, telnetWONT, telnetDO, telnetDONT} {
+		if (v == telnetWILL || v == telnetWONT) == (reply == telnetWILL || reply == telnetWONT) {
+			delete(tio.replied, [2]byte{v, opt})
+		}
+	}
+	tio.replied[key] = true
+	return []byte{telnetIAC, reply, opt}
+}
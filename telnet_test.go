@@ -0,0 +1,120 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+/*cappedIO is a bufIO that accepts only room more bytes, then fails writes with err*/
+type cappedIO struct {
+	bufIO
+	room int
+	err  error
+}
+
+func (c *cappedIO) Write(p []byte) (int, error) {
+	if len(p) <= c.room {
+		c.room -= len(p)
+		return c.bufIO.Write(p)
+	}
+	n, _ := c.bufIO.Write(p[:c.room])
+	c.room = 0
+	return n, c.err
+}
+
+func TestTelnetIO(t *testing.T) {
+	inner := &bufIO{}
+	tio := NewTelnetIO(inner)
+	_ = tio.String()
+
+	if n, err := tio.Write([]byte{0x01, 0xff, 0x02}); n != 3 || err != nil || !bytes.Equal(inner.tx.Bytes(), []byte{0x01, 0xff, 0xff, 0x02}) {
+		t.Errorf("Expected IAC to be escaped, got % x %v", inner.tx.Bytes(), err)
+	}
+	inner.tx.Reset()
+
+	inner.rx.Write([]byte{
+		'a', telnetIAC, telnetDO, telnetOptBinary, //accepted
+		'b', telnetIAC, telnetWILL, 1, //ECHO refused
+		telnetIAC, telnetIAC, //escaped data
+		telnetIAC, telnetSB, 24, 1, telnetIAC, telnetIAC, telnetIAC, telnetSE, //terminal type subnegotiation
+		'\r', 0, 'c', telnetIAC, 241, //NOP
+		telnetIAC, //split across reads
+	})
+	b := make([]byte, 64)
+	n, err := tio.Read(b)
+	if err != nil || !bytes.Equal(b[:n], []byte{'a', 'b', 0xff, '\r', 'c'}) {
+		t.Errorf("Unexpected data % x %v", b[:n], err)
+	}
+	expected := []byte{telnetIAC, telnetWILL, telnetOptBinary, telnetIAC, telnetDONT, 1}
+	if !bytes.Equal(inner.tx.Bytes(), expected) {
+		t.Errorf("Expected replies % x, got % x", expected, inner.tx.Bytes())
+	}
+
+	//a repeated request is not answered again, and a command-only read is skipped
+	inner.tx.Reset()
+	inner.rx.Write([]byte{telnetDO, telnetOptBinary})
+	inner.rx.Write([]byte{'d'})
+	if n, err = tio.Read(b[:2]); err != nil || string(b[:n]) != "d" || inner.tx.Len() != 0 {
+		t.Errorf("Unexpected data %q %v, replies % x", b[:n], err, inner.tx.Bytes())
+	}
+
+	if s, err := ParseStack("tcp://localhost:1 | telnet"); err != nil || s.String() != "tcp://localhost:1 | telnet" {
+		t.Error("Expected the telnet layer to round trip", s, err)
+	}
+}
+
+func TestTelnetIO_WriteErrors(t *testing.T) {
+	broken := errors.New("broken")
+	//the escaped form is 01 ff ff 02 ff ff 03: four bytes make it through two whole characters and an escape
+	for _, tc := range []struct {
+		room, n int
+		err     error
+	}{
+		{0, 0, broken},
+		{3, 2, broken},
+		{4, 3, broken},
+		{5, 3, broken},
+		{6, 4, io.ErrShortWrite}, //(0, nil) writes give up rather than spinning
+	} {
+		tio := NewTelnetIO(&cappedIO{room: tc.room, err: tc.err})
+		if n, err := tio.Write([]byte{0x01, 0xff, 0x02, 0xff, 0x03}); n != tc.n || err != tc.err {
+			t.Errorf("With room for %d bytes expected %d, %v, got %d, %v", tc.room, tc.n, tc.err, n, err)
+		}
+	}
+
+	//a failure to answer a negotiation is reported along with the data read
+	inner := &cappedIO{err: broken}
+	tio := NewTelnetIO(inner)
+	inner.rx.Write([]byte{'a', telnetIAC, telnetDO, telnetOptBinary})
+	b := make([]byte, 8)
+	if n, err := tio.Read(b); string(b[:n]) != "a" || errors.Cause(err) != broken {
+		t.Errorf("Expected the reply error with the data, got %q %v", b[:n], err)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &Throttle{}
+
+func init() {
+	known["throttle"] = func(ctx context.Context, dur time.Duration, dial string) (IDoIO, error) {
+		return NewThrottleClient(ctx, dur, dial)
+	}
+}
+
+/*
+Rate limits traffic to BytesPerSec on average, allowing bursts of up to Burst
+bytes.  The zero Rate is unlimited, and a Burst of zero is a tenth of a
+second's worth (but at least a byte).
+*/
+type Rate struct {
+	BytesPerSec float64
+	Burst       int
+}
+
+/*tokenBucket enforces a Rate, holding a token per byte that may be sent*/
+type tokenBucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func newTokenBucket(r Rate) *tokenBucket {
+	if r.BytesPerSec <= 0 {
+		return nil
+	}
+	burst := float64(r.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(r.BytesPerSec/10))
+	}
+	return &tokenBucket{rate: r.BytesPerSec, burst: burst, tokens: burst, last: time.Now()}
+}
+
+/*available returns the tokens held, after refilling them for the time passed*/
+func (tb *tokenBucket) available() float64 {
+	now := time.Now()
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	return tb.tokens
+}
+
+/*until returns how long until n (no more than the burst) tokens are held*/
+func (tb *tokenBucket) until(n int) time.Duration {
+	short := float64(n) - tb.available()
+	if short <= 0 {
+		return 0
+	}
+	return time.Duration(short / tb.rate * float64(time.Second))
+}
+
+func (tb *tokenBucket) take(n int) { tb.tokens -= float64(n) }
+
+/*
+Throttle wraps an IDoIO, pacing what is read from and written to it, for
+radios and legacy devices that lock up when flooded.  Writes block until they
+are within the Rate, so may be split into several writes of at most a burst.
+Reads return no more than the Rate allows, and temporary timeouts when it
+allows nothing, leaving the rest with the device.
+*/
+type Throttle struct {
+	idotoo IDoIO
+	ctx    context.Context
+	cancel context.CancelFunc
+	dial   string //empty unless built from one
+	rx, tx *tokenBucket
+}
+
+/*NewThrottle returns a Throttle over idoio limiting reads to rx and writes to tx*/
+func NewThrottle(idoio IDoIO, rx, tx Rate) *Throttle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Throttle{idotoo: idoio, ctx: ctx, cancel: cancel, rx: newTokenBucket(rx), tx: newTokenBucket(tx)}
+}
+
+/*
+NewThrottleClient opens a Throttle over another dial string.  Dial should be
+in the form of
+
+	throttle://(<dial>)?[rx=<bytes/s>][&tx=<bytes/s>][&burst=<bytes>]
+
+eg "throttle://(serial:///dev/ttyUSB0:115200)?tx=2000".  burst applies to
+both directions.
+*/
+func NewThrottleClient(ctx context.Context, timeout time.Duration, dial string) (*Throttle, error) {
+	d, err := dialFor(dial, "throttle")
+	dials, query := d.Dials, d.Params.Encode()
+	if err == nil && len(dials) != 1 {
+		err = fmt.Errorf("exactly one dial string may be throttled")
+	}
+	if err == nil && factoryFor(dials[0]) == nil {
+		err = fmt.Errorf("no known way to open %q", dials[0])
+	}
+	var rx, tx Rate
+	if err == nil {
+		rx, tx, err = parseThrottle(query)
+	}
+	if err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid dial string %q", dial))
+	}
+	idoio, err := NewIDoIO(ctx, timeout, dials[0])
+	t := NewThrottle(idoio, rx, tx)
+	t.dial = dial
+	return t, err
+}
+
+func parseThrottle(query string) (rx, tx Rate, err error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return rx, tx, err
+	}
+	for k, v := range q {
+		switch k {
+		case "rx":
+			rx.BytesPerSec, err = strconv.ParseFloat(v[0], 64)
+		case "tx":
+			tx.BytesPerSec, err = strconv.ParseFloat(v[0], 64)
+		case "burst":
+			rx.Burst, err = strconv.Atoi(v[0])
+			tx.Burst = rx.Burst
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return rx, tx, err
+		}
+	}
+	if rx.BytesPerSec <= 0 && tx.BytesPerSec <= 0 {
+		err = fmt.Errorf("a positive rx or tx rate is required")
+	}
+	return rx, tx, err
+}
+
+func (t *Throttle) dialString() string {
+	if t.dial != "" {
+		return t.dial
+	}
+	return dialOf(t.idotoo)
+}
+
+/*String conforms to fmt.Stringer*/
+func (t *Throttle) String() string {
+	return fmt.Sprintf("Throttle over %v", t.idotoo)
+}
+
+/*Open conforms to IDoIO, reopening the IDoIO throttled*/
+func (t *Throttle) Open() error { return t.idotoo.Open() }
+
+/*Close conforms to io.Closer, closing the IDoIO throttled, and abandoning any Write waiting*/
+func (t *Throttle) Close() error {
+	t.cancel()
+	return t.idotoo.Close()
+}
+
+/*Read conforms to io.Reader, reading no more than the rx Rate allows*/
+func (t *Throttle) Read(b []byte) (int, error) {
+	if t.rx == nil {
+		return t.idotoo.Read(b)
+	}
+	allowed := int(t.rx.available())
+	if allowed < 1 {
+		time.Sleep(min(t.rx.until(1), time.Millisecond))
+		if allowed = int(t.rx.available()); allowed < 1 {
+			return 0, newErr(true, true, fmt.Errorf("read: throttled"))
+		}
+	}
+	if len(b) > allowed {
+		b = b[:allowed]
+	}
+	n, err := t.idotoo.Read(b)
+	t.rx.take(n)
+	return n, err
+}
+
+/*Write conforms to io.Writer, blocking until b may be written within the tx Rate*/
+func (t *Throttle) Write(b []byte) (int, error) {
+	if t.tx == nil {
+		return t.idotoo.Write(b)
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), int(t.tx.burst))]
+		if wait := t.tx.until(len(chunk)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				timer.Stop()
+				return written, newErr(false, false, t.ctx.Err())
+			}
+		}
+		n, err := t.idotoo.Write(chunk)
+		t.tx.take(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,97 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	near, far := NewMemPair(ctx)
+	th := NewThrottle(near, Rate{BytesPerSec: 1000, Burst: 100}, Rate{BytesPerSec: 1000, Burst: 100})
+	defer th.Close()
+	_ = th.String()
+
+	start := time.Now()
+	if n, err := th.Write(bytes.Repeat([]byte{'x'}, 300)); n != 300 || err != nil {
+		t.Error("Unable to write", n, err)
+	}
+	if took := time.Since(start); took < 180*time.Millisecond || took > time.Second {
+		t.Error("Expected 300 bytes to take about 200ms after a 100 byte burst, not", took)
+	}
+
+	far.Write(bytes.Repeat([]byte{'y'}, 300))
+	start = time.Now()
+	got, buf := 0, make([]byte, 1024)
+	for time.Since(start) < time.Second && got < 300 {
+		n, err := th.Read(buf)
+		if n > 100 {
+			t.Error("Expected no more than a burst per read, got", n)
+		}
+		if n == 0 && (err == nil || !IsTemporary(err)) {
+			t.Error("Expected a temporary error while throttled", err)
+		}
+		got += n
+	}
+	if took := time.Since(start); got != 300 || took < 180*time.Millisecond {
+		t.Error("Expected 300 bytes to take about 200ms to read, not", got, took)
+	}
+
+	slow := NewThrottle(near, Rate{}, Rate{BytesPerSec: 1})
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		slow.Close()
+	}()
+	if n, err := slow.Write([]byte("ab")); n != 1 || err == nil {
+		t.Error("Expected a write waiting to be abandoned on close", n, err)
+	}
+}
+
+func TestThrottleClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"throttle://(null://)", "throttle://(null://)?tx=fast", "throttle://(null://)?x=1", "throttle://(bogus://x)?tx=10"} {
+		if _, err := NewThrottleClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, "throttle://(null://)?tx=2000&burst=8")
+	if err != nil {
+		t.Fatal("Unable to throttle", err)
+	}
+	th := idoio.(*Throttle)
+	defer th.Close()
+	if th.rx != nil || th.tx == nil || th.tx.rate != 2000 || th.tx.burst != 8 {
+		t.Error("Expected only writes throttled", th.rx, th.tx)
+	}
+	if dialOf(th) != "throttle://(null://)?tx=2000&burst=8" {
+		t.Error("Expected the dial string, not", dialOf(th))
+	}
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Transform converts bytes on their way to and from a transport.  Decode is the
+inverse of Encode, but both may be stateful: Decode in particular is handed
+data as it arrives, and may hold on to an incomplete unit until more arrives.
+If a Transform also has a Reset() method, it is called whenever the
+Transformer is (re)opened.
+*/
+type Transform interface {
+	Encode(b []byte) ([]byte, error) //outgoing; the result is written in place of b
+	Decode(b []byte) ([]byte, error) //incoming; the result is read in place of b
+}
+
+/*TransformFuncs adapts a pair of functions to a Transform. A nil func passes bytes through.*/
+type TransformFuncs struct {
+	EncodeFunc func([]byte) ([]byte, error)
+	DecodeFunc func([]byte) ([]byte, error)
+}
+
+/*Encode conforms to Transform*/
+func (tf TransformFuncs) Encode(b []byte) ([]byte, error) {
+	if tf.EncodeFunc == nil {
+		return b, nil
+	}
+	return tf.EncodeFunc(b)
+}
+
+/*Decode conforms to Transform*/
+func (tf TransformFuncs) Decode(b []byte) ([]byte, error) {
+	if tf.DecodeFunc == nil {
+		return b, nil
+	}
+	return tf.DecodeFunc(b)
+}
+
+var _ IDoIO = &Transformer{}
+
+/*
+Transformer wraps an IDoIO, encoding everything written and decoding
+everything read with a Transform.  It is the extension point for site
+specific scrambling, byte reordering, or vendor quirks that do not merit a
+full transport.
+
+As the encoded and decoded lengths need not match, a Write reports either all
+of b as written, or none of it along with the error; in the latter case some
+of the encoded bytes may still have been sent.
+*/
+type Transformer struct {
+	IDoIO
+	t       Transform
+	pending []byte //decoded but not yet read
+	scratch []byte
+}
+
+/*NewTransformer returns a Transformer applying t to idoio*/
+func NewTransformer(idoio IDoIO, t Transform) *Transformer {
+	return &Transformer{IDoIO: idoio, t: t, scratch: make([]byte, 4096)}
+}
+
+/*WithTransform applies t in a Transformer. It can not be parsed.*/
+func WithTransform(name string, t Transform) Layer {
+	return NewLayer(name, RankTransform, nil, func(_ context.Context, inner IDoIO) (IDoIO, error) {
+		return NewTransformer(inner, t), nil
+	})
+}
+
+func (tr *Transformer) dialString() string { return dialOf(tr.IDoIO) }
+
+/*String conforms to fmt.Stringer*/
+func (tr *Transformer) String() string { return fmt.Sprintf("Transformed %v", tr.IDoIO) }
+
+/*Open conforms to IDoIO, discarding anything pending and resetting the Transform*/
+func (tr *Transformer) Open() error {
+	tr.pending = nil
+	if r, ok := tr.t.(interface{ Reset() }); ok {
+		r.Reset()
+	}
+	return tr.IDoIO.Open()
+}
+
+/*Write conforms to io.Writer, writing the encoded form of b*/
+func (tr *Transformer) Write(b []byte) (int, error) {
+	enc, err := tr.t.Encode(b)
+	if err != nil {
+		return 0, newErr(false, false, errors.Wrap(err, "encode"))
+	}
+	for len(enc) > 0 {
+		n, err := tr.IDoIO.Write(enc)
+		if err != nil {
+			return 0, err
+		}
+		enc = enc[n:]
+	}
+	return len(b), nil
+}
+
+/*
+Read conforms to io.Reader, returning decoded bytes.  Reads of the underlying
+IDoIO continue until something decodes, or an error is returned.
+*/
+func (tr *Transformer) Read(b []byte) (int, error) {
+	for len(tr.pending) == 0 {
+		n, rerr := tr.IDoIO.Read(tr.scratch)
+		if n > 0 {
+			dec, err := tr.t.Decode(tr.scratch[:n])
+			if err != nil {
+				return 0, newErr(false, false, errors.Wrap(err, "decode"))
+			}
+			tr.pending = append(tr.pending, dec...)
+		}
+		if rerr != nil && len(tr.pending) == 0 {
+			return 0, rerr
+		}
+	}
+	n := copy(b, tr.pending)
+	tr.pending = tr.pending[n:]
+	return n, nil
+}
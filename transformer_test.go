@@ -0,0 +1,100 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"errors"
+	"testing"
+)
+
+/*swapper swaps each pair of bytes, holding an odd trailing byte until the next Decode*/
+type swapper struct {
+	held   []byte
+	resets int
+}
+
+func (s *swapper) swap(b []byte) []byte {
+	out := cloneBytes(b)
+	for i := 0; i+1 < len(out); i += 2 {
+		out[i], out[i+1] = out[i+1], out[i]
+	}
+	return out
+}
+
+func (s *swapper) Encode(b []byte) ([]byte, error) { return s.swap(b), nil }
+
+func (s *swapper) Decode(b []byte) ([]byte, error) {
+	b = append(s.held, b...)
+	even := len(b) &^ 1
+	s.held = cloneBytes(b[even:])
+	return s.swap(b[:even]), nil
+}
+
+func (s *swapper) Reset() { s.held, s.resets = nil, s.resets+1 }
+
+func TestTransformer(t *testing.T) {
+	inner := &bufIO{}
+	sw := &swapper{}
+	tr := NewTransformer(inner, sw)
+	_ = tr.String()
+
+	if n, err := tr.Write([]byte("abcd")); n != 4 || err != nil || inner.tx.String() != "badc" {
+		t.Errorf("Expected the write to be encoded, got %q %d %v", inner.tx.String(), n, err)
+	}
+
+	inner.rx.WriteString("bad")
+	b := make([]byte, 8)
+	if n, err := tr.Read(b); err != nil || string(b[:n]) != "ab" {
+		t.Errorf("Expected the held byte to be kept back, got %q %v", b[:n], err)
+	}
+	if n, err := tr.Read(b); n != 0 || err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout with nothing decodable", n, err)
+	}
+	inner.rx.WriteString("c")
+	if n, err := tr.Read(b[:1]); err != nil || string(b[:n]) != "c" {
+		t.Errorf("Expected the pair to complete, got %q %v", b[:n], err)
+	}
+	if n, err := tr.Read(b); err != nil || string(b[:n]) != "d" {
+		t.Errorf("Expected the rest of the decoded data, got %q %v", b[:n], err)
+	}
+
+	inner.rx.WriteString("x")
+	tr.Read(b)
+	tr.Open()
+	if sw.resets != 1 || sw.held != nil {
+		t.Error("Expected Open to reset the transform")
+	}
+
+	failing := NewTransformer(inner, TransformFuncs{
+		EncodeFunc: func([]byte) ([]byte, error) { return nil, errors.New("nope") },
+	})
+	if n, err := failing.Write([]byte("a")); n != 0 || err == nil {
+		t.Error("Expected the encode error", n, err)
+	}
+	inner.rx.WriteString("zz")
+	if n, err := failing.Read(b); err != nil || string(b[:n]) != "zz" {
+		t.Errorf("Expected a nil DecodeFunc to pass through, got %q %v", b[:n], err)
+	}
+}
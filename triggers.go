@@ -0,0 +1,267 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+/*DefaultTriggerWindow is the window used by NewTriggers when passed a window <= 0*/
+const DefaultTriggerWindow = 4096
+
+/*Match describes a trigger firing*/
+type Match struct {
+	Trigger    string    //Name the trigger was registered under
+	Time       time.Time //When the match was detected
+	Bytes      []byte    //The bytes that matched
+	Submatches [][]byte  //Regexp submatches (including Bytes as the 0th), nil for CheckFunc triggers
+	Context    []byte    //The unconsumed stream preceding, and including, the match
+	Suppressed int       //Matches dropped by rate limiting since this trigger last fired
+}
+
+/*String conforms to fmt.Stringer*/
+func (m Match) String() string {
+	return fmt.Sprintf("%s matched %q at %s", m.Trigger, m.Bytes, m.Time.UTC().Format(time.RFC3339Nano))
+}
+
+type trigger struct {
+	name       string
+	re         *regexp.Regexp
+	check      CheckFunc
+	fn         func(Match)
+	every      time.Duration //minimum interval between firings
+	last       time.Time
+	suppressed int
+	buf        []byte
+}
+
+/*
+Triggers watches a stream of bytes for registered patterns, and calls back
+when they appear. This allows things like an "OVERTEMP" alarm embedded in a
+telemetry stream to be acted upon without writing a bespoke reader loop.
+
+Data is given to Triggers in one of three ways: by wrapping an IDoIO (see
+Wrap) so everything read through it is scanned, by letting Triggers run its
+own read loop (see Watch), or by calling Feed directly.
+
+Each trigger scans its own window of the stream, so triggers do not consume
+data from each other.  At most window bytes of unmatched data are retained per
+trigger, so patterns longer than the window will not be matched.
+*/
+type Triggers struct {
+	mux      sync.Mutex
+	window   int
+	triggers []*trigger
+}
+
+/*NewTriggers returns an empty Triggers retaining at most window bytes of unmatched data per trigger*/
+func NewTriggers(window int) *Triggers {
+	if window <= 0 {
+		window = DefaultTriggerWindow
+	}
+	return &Triggers{window: window}
+}
+
+/*
+Register calls fn each time re matches the stream, but no more than once per
+every (zero means every match).  Matches that are rate limited are counted,
+and the count is reported in the next Match delivered.  Data up to the end of
+each match is consumed, so a match is reported exactly once.  Registering an
+existing name replaces that trigger.
+*/
+func (t *Triggers) Register(name string, re *regexp.Regexp, every time.Duration, fn func(Match)) {
+	t.add(&trigger{name: name, re: re, every: every, fn: fn})
+}
+
+/*
+RegisterCheck is similar to Register, but uses check to evaluate the stream.
+When check returns Success, fn is called and the window is consumed. When check
+returns Failure, the window is discarded without calling fn.
+*/
+func (t *Triggers) RegisterCheck(name string, check CheckFunc, every time.Duration, fn func(Match)) {
+	t.add(&trigger{name: name, check: check, every: every, fn: fn})
+}
+
+/*Unregister removes the named trigger*/
+func (t *Triggers) Unregister(name string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for i, tr := range t.triggers {
+		if tr.name == name {
+			t.triggers = append(t.triggers[:i], t.triggers[i+1:]...)
+			return
+		}
+	}
+}
+
+/*Names returns the names of the registered triggers, in registration order*/
+func (t *Triggers) Names() []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	names := make([]string, len(t.triggers))
+	for i, tr := range t.triggers {
+		names[i] = tr.name
+	}
+	return names
+}
+
+func (t *Triggers) add(tr *trigger) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for i, old := range t.triggers {
+		if old.name == tr.name {
+			t.triggers[i] = tr
+			return
+		}
+	}
+	t.triggers = append(t.triggers, tr)
+}
+
+/*
+Feed scans b with every registered trigger, calling back for each match.
+Callbacks are made synchronously, in the order matches occur, so they should
+be quick.
+*/
+func (t *Triggers) Feed(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	now := time.Now()
+	t.mux.Lock()
+	var fire []func()
+	for _, tr := range t.triggers {
+		for _, m := range tr.scan(b, t.window, now) {
+			fn, m := tr.fn, m
+			fire = append(fire, func() { fn(m) })
+		}
+	}
+	t.mux.Unlock()
+	for _, f := range fire {
+		f()
+	}
+}
+
+/*scan appends b to the trigger's window and returns any matches that should fire*/
+func (tr *trigger) scan(b []byte, window int, now time.Time) (matches []Match) {
+	tr.buf = append(tr.buf, b...)
+loop:
+	for {
+		var m Match
+		if tr.re != nil {
+			loc := tr.re.FindSubmatchIndex(tr.buf)
+			if loc == nil {
+				break
+			}
+			m = Match{Bytes: cloneBytes(tr.buf[loc[0]:loc[1]]), Context: cloneBytes(tr.buf[:loc[1]])}
+			for i := 0; i < len(loc); i += 2 {
+				if loc[i] < 0 {
+					m.Submatches = append(m.Submatches, nil)
+					continue
+				}
+				m.Submatches = append(m.Submatches, cloneBytes(tr.buf[loc[i]:loc[i+1]]))
+			}
+			tr.buf = tr.buf[loc[1]:]
+			if loc[0] == loc[1] && len(tr.buf) > 0 { //never loop on empty matches
+				tr.buf = tr.buf[1:]
+			}
+		} else {
+			switch tr.check(tr.buf) {
+			case Success:
+				m = Match{Bytes: cloneBytes(tr.buf), Context: cloneBytes(tr.buf)}
+				tr.buf = nil
+			case Failure:
+				tr.buf = nil
+				break loop
+			default:
+				break loop
+			}
+		}
+		if tr.every > 0 && !tr.last.IsZero() && now.Sub(tr.last) < tr.every {
+			tr.suppressed++
+		} else {
+			m.Trigger, m.Time, m.Suppressed = tr.name, now, tr.suppressed
+			tr.last, tr.suppressed = now, 0
+			matches = append(matches, m)
+		}
+		if len(tr.buf) == 0 {
+			break
+		}
+	}
+	if len(tr.buf) > window {
+		tr.buf = append([]byte{}, tr.buf[len(tr.buf)-window:]...)
+	}
+	return
+}
+
+func cloneBytes(b []byte) []byte { return append([]byte{}, b...) }
+
+/*
+Wrap returns an IDoIO that passes everything through to idoio, feeding all
+bytes read to t.
+*/
+func (t *Triggers) Wrap(idoio IDoIO) IDoIO {
+	return &triggerIO{IDoIO: idoio, t: t}
+}
+
+/*
+Watch reads idoio until ctx is done or a non-temporary error is encountered,
+feeding everything read to t.  The returned error is the reason Watch stopped.
+*/
+func (t *Triggers) Watch(ctx context.Context, idoio IDoIO) error {
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-ctx.Done():
+			return newErr(false, false, ctx.Err())
+		default:
+		}
+		n, err := idoio.Read(buf)
+		t.Feed(buf[:n])
+		if err != nil && !IsTemporary(err) {
+			return err
+		}
+	}
+}
+
+type triggerIO struct {
+	IDoIO
+	t *Triggers
+}
+
+func (tio *triggerIO) String() string {
+	return fmt.Sprintf("Triggers over %v", tio.IDoIO)
+}
+
+func (tio *triggerIO) dialString() string { return dialOf(tio.IDoIO) }
+
+func (tio *triggerIO) Read(b []byte) (int, error) {
+	n, err := tio.IDoIO.Read(b)
+	tio.t.Feed(b[:n])
+	return n, err
+}
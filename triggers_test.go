@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTriggers_Register(t *testing.T) {
+	tr := NewTriggers(0)
+	var got []Match
+	tr.Register("temp", regexp.MustCompile(`OVERTEMP (\d+)`), 0, func(m Match) { got = append(got, m) })
+	tr.Register("volts", regexp.MustCompile(`LOWV`), time.Hour, func(m Match) { got = append(got, m) })
+
+	tr.Feed([]byte("T=21.2\r\nOVERT"))
+	if len(got) != 0 {
+		t.Error("Nothing should have matched yet", got)
+	}
+	tr.Feed([]byte("EMP 42\r\nLOWV\r\nLOWV\r\n"))
+	if len(got) != 2 {
+		t.Error("Expected two matches (the second LOWV is rate limited)", got)
+		t.FailNow()
+	}
+	if got[0].Trigger != "temp" || string(got[0].Submatches[1]) != "42" || !bytes.HasPrefix(got[0].Context, []byte("T=21.2")) {
+		t.Error("Unexpected match", got[0], got[0].Context)
+	}
+	_ = got[0].String()
+
+	//data is consumed, so refeeding nothing new should not fire
+	tr.Feed([]byte("\r\n"))
+	if len(got) != 2 {
+		t.Error("A match should only be reported once")
+	}
+
+	tr.Register("volts", regexp.MustCompile(`LOWV`), 0, func(m Match) { got = append(got, m) })
+	tr.Unregister("temp")
+	if names := tr.Names(); len(names) != 1 || names[0] != "volts" {
+		t.Error("Unexpected triggers", names)
+	}
+}
+
+func TestTriggers_RegisterCheck(t *testing.T) {
+	tr := NewTriggers(8)
+	fired := 0
+	tr.RegisterCheck("len", func(b []byte) ExitCriteria {
+		switch {
+		case bytes.HasPrefix(b, []byte("!")):
+			return Failure
+		case len(b) >= 4:
+			return Success
+		}
+		return Insufficient
+	}, 0, func(m Match) { fired++ })
+
+	tr.Feed([]byte("ab"))
+	tr.Feed([]byte("cd"))
+	tr.Feed([]byte("!xyz"))
+	if fired != 1 {
+		t.Errorf("Expected exactly one firing, got %d", fired)
+	}
+}
+
+func TestTriggers_WrapWatch(t *testing.T) {
+	inner := &bufIO{}
+	inner.rx.WriteString("ALARM")
+	tr := NewTriggers(0)
+	fired := make(chan Match, 2)
+	tr.Register("alarm", regexp.MustCompile("ALARM"), 0, func(m Match) { fired <- m })
+
+	w := tr.Wrap(inner)
+	_ = w.String()
+	w.Read(make([]byte, 16))
+	if len(fired) != 1 {
+		t.Error("Expected reads through the wrapper to fire")
+	}
+
+	inner.rx.WriteString("ALARM")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tr.Watch(ctx, inner); err == nil || len(fired) != 2 {
+		t.Error("Expected Watch to fire then end with the context", err)
+	}
+}
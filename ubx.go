@@ -0,0 +1,366 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*UBX message classes*/
+const (
+	UBXClassNAV = 0x01
+	UBXClassACK = 0x05
+	UBXClassCFG = 0x06
+	UBXClassMON = 0x0a
+)
+
+/*UBX message IDs, within their class*/
+const (
+	UBXNavPVT    = 0x07 //NAV-PVT
+	UBXAckNak    = 0x00 //ACK-NAK
+	UBXAckAck    = 0x01 //ACK-ACK
+	UBXCfgPrt    = 0x00 //CFG-PRT
+	UBXCfgMsg    = 0x01 //CFG-MSG
+	UBXCfgRate   = 0x08 //CFG-RATE
+	UBXCfgCfg    = 0x09 //CFG-CFG
+	UBXCfgValSet = 0x8a //CFG-VALSET
+	UBXMonVer    = 0x04 //MON-VER
+)
+
+const (
+	ubxSync1      = 0xb5
+	ubxSync2      = 0x62
+	ubxOverhead   = 8 //sync, class, id, length and checksum
+	ubxMaxPayload = 8192
+)
+
+/*
+ScanUBX is a bufio.SplitFunc returning whole u-blox UBX frames (sync chars
+through checksum).  Frames with a bad checksum, and anything between frames,
+are discarded, so it can be used on a port also carrying NMEA.  It is
+registered as "ubx" for framing layers, e.g. "frame(split=ubx)".
+*/
+func ScanUBX(data []byte, atEOF bool) (int, []byte, error) {
+	skip := 0
+	for {
+		start := bytes.Index(data[skip:], []byte{ubxSync1, ubxSync2})
+		if start < 0 {
+			if len(data) > 0 && data[len(data)-1] == ubxSync1 && !atEOF {
+				return len(data) - 1, nil, nil //keep what may be the first sync char
+			}
+			return len(data), nil, nil
+		}
+		start += skip
+		if len(data)-start < 6 {
+			if atEOF { //not a frame after all, try the next candidate
+				skip = start + 1
+				continue
+			}
+			return start, nil, nil
+		}
+		size := int(binary.LittleEndian.Uint16(data[start+4:]))
+		if size > ubxMaxPayload {
+			skip = start + 1
+			continue
+		}
+		end := start + ubxOverhead + size
+		if len(data) < end {
+			if atEOF { //not a frame after all, try the next candidate
+				skip = start + 1
+				continue
+			}
+			return start, nil, nil
+		}
+		frame := data[start:end]
+		if a, b := ubxChecksum(frame[2 : end-start-2]); a != frame[len(frame)-2] || b != frame[len(frame)-1] {
+			skip = start + 1
+			continue
+		}
+		return end, frame, nil
+	}
+}
+
+/*ubxChecksum is the 8-bit Fletcher checksum over class, id, length and payload*/
+func ubxChecksum(b []byte) (byte, byte) {
+	var a, c byte
+	for _, v := range b {
+		a += v
+		c += a
+	}
+	return a, c
+}
+
+/*UBXMessage is a single UBX message*/
+type UBXMessage struct {
+	Class   byte
+	ID      byte
+	Payload []byte
+}
+
+/*String conforms to fmt.Stringer*/
+func (m UBXMessage) String() string {
+	return fmt.Sprintf("UBX %02x-%02x (%d bytes)", m.Class, m.ID, len(m.Payload))
+}
+
+/*Bytes returns m framed for sending*/
+func (m UBXMessage) Bytes() []byte {
+	b := make([]byte, ubxOverhead+len(m.Payload))
+	b[0], b[1], b[2], b[3] = ubxSync1, ubxSync2, m.Class, m.ID
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(m.Payload)))
+	copy(b[6:], m.Payload)
+	b[len(b)-2], b[len(b)-1] = ubxChecksum(b[2 : len(b)-2])
+	return b
+}
+
+/*DecodeUBX returns the message in frame, which must be exactly one valid UBX frame*/
+func DecodeUBX(frame []byte) (UBXMessage, error) {
+	adv, tok, _ := ScanUBX(frame, true)
+	if tok == nil || adv != len(frame) || len(tok) != len(frame) {
+		return UBXMessage{}, newErr(false, false, fmt.Errorf("not a valid UBX frame: % x", frame))
+	}
+	return UBXMessage{Class: frame[2], ID: frame[3], Payload: cloneBytes(frame[6 : len(frame)-2])}, nil
+}
+
+/*UBXPoll returns the (empty) message polling for class/id*/
+func UBXPoll(class, id byte) UBXMessage { return UBXMessage{Class: class, ID: id} }
+
+/*
+UBXCommand returns a Command sending msg that succeeds when the receiver
+acknowledges it with ACK-ACK and fails on ACK-NAK, for configuring u-blox
+receivers through an Arbiter.
+*/
+func UBXCommand(name string, msg UBXMessage, timeout time.Duration) Command {
+	ack := func(id byte) *regexp.Regexp {
+		return regexp.MustCompile("(?s)" + ubxPattern([]byte{ubxSync1, ubxSync2, UBXClassACK, id, 2, 0, msg.Class, msg.ID}))
+	}
+	return Command{
+		Name:        name,
+		Timeout:     timeout,
+		Prototype:   strings.Replace(string(msg.Bytes()), "%", "%%", -1),
+		Response:    ack(UBXAckAck),
+		Error:       ack(UBXAckNak),
+		Description: msg.String(),
+	}
+}
+
+/*
+ubxPattern returns a regexp matching b.  Regexps match runes rather than bytes,
+and the bytes 0x80-0xbf can never start a UTF-8 sequence, so each decodes on
+its own as utf8.RuneError.
+*/
+func ubxPattern(b []byte) string {
+	p := ""
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			p += fmt.Sprintf(`\x{%02x}`, c)
+		case c < 0xc0:
+			p += `\x{fffd}`
+		default:
+			p += "."
+		}
+	}
+	return p
+}
+
+/*UBXAck is a decoded ACK-ACK or ACK-NAK*/
+type UBXAck struct {
+	Class byte //of the message acknowledged
+	ID    byte
+	Ack   bool //false for ACK-NAK
+}
+
+/*ParseUBXAck decodes an ACK-ACK or ACK-NAK message*/
+func ParseUBXAck(m UBXMessage) (UBXAck, error) {
+	if m.Class != UBXClassACK || (m.ID != UBXAckAck && m.ID != UBXAckNak) || len(m.Payload) != 2 {
+		return UBXAck{}, newErr(false, false, fmt.Errorf("%v is not an ACK", m))
+	}
+	return UBXAck{Class: m.Payload[0], ID: m.Payload[1], Ack: m.ID == UBXAckAck}, nil
+}
+
+/*NAVPVT is a decoded NAV-PVT navigation solution*/
+type NAVPVT struct {
+	ITOW               time.Duration //GPS time of week of the navigation epoch
+	Time               time.Time     //UTC
+	ValidDate          bool
+	ValidTime          bool
+	FullyResolved      bool
+	TimeAccuracy       time.Duration
+	FixType            int //0 none, 1 dead reckoning, 2 2D, 3 3D, 4 GNSS + dead reckoning, 5 time only
+	FixOK              bool
+	Satellites         int
+	Latitude           float64 //degrees
+	Longitude          float64 //degrees
+	Height             float64 //meters above the ellipsoid
+	HeightMSL          float64 //meters above mean sea level
+	HorizontalAccuracy float64 //meters
+	VerticalAccuracy   float64 //meters
+	VelNorth           float64 //meters per second
+	VelEast            float64 //meters per second
+	VelDown            float64 //meters per second
+	GroundSpeed        float64 //meters per second
+	Heading            float64 //of motion, degrees
+	SpeedAccuracy      float64 //meters per second
+	HeadingAccuracy    float64 //degrees
+	PDOP               float64
+}
+
+/*ParseNAVPVT decodes a NAV-PVT message*/
+func ParseNAVPVT(m UBXMessage) (NAVPVT, error) {
+	p := m.Payload
+	if m.Class != UBXClassNAV || m.ID != UBXNavPVT || len(p) < 92 {
+		return NAVPVT{}, newErr(false, false, fmt.Errorf("%v is not a NAV-PVT", m))
+	}
+	u4 := func(o int) uint32 { return binary.LittleEndian.Uint32(p[o:]) }
+	i4 := func(o int) float64 { return float64(int32(u4(o))) }
+	return NAVPVT{
+		ITOW:               time.Duration(u4(0)) * time.Millisecond,
+		Time:               time.Date(int(binary.LittleEndian.Uint16(p[4:])), time.Month(p[6]), int(p[7]), int(p[8]), int(p[9]), int(p[10]), int(int32(u4(16))), time.UTC),
+		ValidDate:          p[11]&0x01 != 0,
+		ValidTime:          p[11]&0x02 != 0,
+		FullyResolved:      p[11]&0x04 != 0,
+		TimeAccuracy:       time.Duration(u4(12)),
+		FixType:            int(p[20]),
+		FixOK:              p[21]&0x01 != 0,
+		Satellites:         int(p[23]),
+		Longitude:          i4(24) * 1e-7,
+		Latitude:           i4(28) * 1e-7,
+		Height:             i4(32) / 1e3,
+		HeightMSL:          i4(36) / 1e3,
+		HorizontalAccuracy: float64(u4(40)) / 1e3,
+		VerticalAccuracy:   float64(u4(44)) / 1e3,
+		VelNorth:           i4(48) / 1e3,
+		VelEast:            i4(52) / 1e3,
+		VelDown:            i4(56) / 1e3,
+		GroundSpeed:        i4(60) / 1e3,
+		Heading:            i4(64) * 1e-5,
+		SpeedAccuracy:      float64(u4(68)) / 1e3,
+		HeadingAccuracy:    float64(u4(72)) * 1e-5,
+		PDOP:               float64(binary.LittleEndian.Uint16(p[76:])) / 100,
+	}, nil
+}
+
+/*CFGMsg returns a CFG-MSG setting the rate (per navigation solution) at which class/id is output on the current port*/
+func CFGMsg(class, id, rate byte) UBXMessage {
+	return UBXMessage{Class: UBXClassCFG, ID: UBXCfgMsg, Payload: []byte{class, id, rate}}
+}
+
+/*
+CFGRate returns a CFG-RATE setting the measurement interval, the number of
+measurements per navigation solution, and the time reference (0 UTC, 1 GPS)
+*/
+func CFGRate(meas time.Duration, nav, timeRef uint16) UBXMessage {
+	p := make([]byte, 6)
+	binary.LittleEndian.PutUint16(p[0:], uint16(meas/time.Millisecond))
+	binary.LittleEndian.PutUint16(p[2:], nav)
+	binary.LittleEndian.PutUint16(p[4:], timeRef)
+	return UBXMessage{Class: UBXClassCFG, ID: UBXCfgRate, Payload: p}
+}
+
+/*UBX protocol masks for CFGPrtUART*/
+const (
+	UBXProtoUBX   = 0x01
+	UBXProtoNMEA  = 0x02
+	UBXProtoRTCM  = 0x04
+	UBXProtoRTCM3 = 0x20
+)
+
+/*
+CFGPrtUART returns a CFG-PRT configuring UART port (1 or 2) for 8N1 at baud,
+accepting and emitting the protocols in the in and out masks (UBXProto*)
+*/
+func CFGPrtUART(port byte, baud uint32, in, out uint16) UBXMessage {
+	p := make([]byte, 20)
+	p[0] = port
+	binary.LittleEndian.PutUint32(p[4:], 0x08d0) //8 bits, no parity, 1 stop bit
+	binary.LittleEndian.PutUint32(p[8:], baud)
+	binary.LittleEndian.PutUint16(p[12:], in)
+	binary.LittleEndian.PutUint16(p[14:], out)
+	return UBXMessage{Class: UBXClassCFG, ID: UBXCfgPrt, Payload: p}
+}
+
+/*
+CFGCfg returns a CFG-CFG clearing, saving to non-volatile storage, and loading
+the configuration sections in the given masks, e.g. CFGCfg(0, 0xffff, 0) saves
+the current configuration
+*/
+func CFGCfg(clear, save, load uint32) UBXMessage {
+	p := make([]byte, 12)
+	binary.LittleEndian.PutUint32(p[0:], clear)
+	binary.LittleEndian.PutUint32(p[4:], save)
+	binary.LittleEndian.PutUint32(p[8:], load)
+	return UBXMessage{Class: UBXClassCFG, ID: UBXCfgCfg, Payload: p}
+}
+
+/*CFG-VALSET layers*/
+const (
+	UBXLayerRAM   = 0x01
+	UBXLayerBBR   = 0x02
+	UBXLayerFlash = 0x04
+)
+
+/*UBXConfigValue is a configuration key and value, for receivers using CFG-VALSET (generation 9 onwards)*/
+type UBXConfigValue struct {
+	Key   uint32
+	Value uint64
+}
+
+/*
+CFGValSet returns a CFG-VALSET applying vals to the layers (UBXLayer*).  The
+size of each value is taken from its key; an error is returned for keys with
+an invalid size.
+*/
+func CFGValSet(layers byte, vals ...UBXConfigValue) (UBXMessage, error) {
+	p := []byte{0, layers, 0, 0}
+	for _, v := range vals {
+		var size int
+		switch (v.Key >> 28) & 0x07 {
+		case 1, 2:
+			size = 1
+		case 3:
+			size = 2
+		case 4:
+			size = 4
+		case 5:
+			size = 8
+		default:
+			return UBXMessage{}, newErr(false, false, fmt.Errorf("configuration key %08x has no valid size", v.Key))
+		}
+		if size < 8 && v.Value > math.MaxUint64>>(64-8*size) {
+			return UBXMessage{}, newErr(false, false, fmt.Errorf("value %d does not fit key %08x", v.Value, v.Key))
+		}
+		var b [12]byte
+		binary.LittleEndian.PutUint32(b[0:], v.Key)
+		binary.LittleEndian.PutUint64(b[4:], v.Value)
+		p = append(p, b[:4+size]...)
+	}
+	return UBXMessage{Class: UBXClassCFG, ID: UBXCfgValSet, Payload: p}, nil
+}
@@ -0,0 +1,147 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func navPVTFixture() UBXMessage {
+	p := make([]byte, 92)
+	binary.LittleEndian.PutUint32(p[0:], 123456000)
+	binary.LittleEndian.PutUint16(p[4:], 2020)
+	p[6], p[7], p[8], p[9], p[10], p[11] = 2, 29, 13, 14, 15, 0x07
+	binary.LittleEndian.PutUint32(p[16:], 500)
+	p[20], p[21], p[23] = 3, 0x01, 12
+	lon := int32(-1050000000)
+	binary.LittleEndian.PutUint32(p[24:], uint32(lon))
+	binary.LittleEndian.PutUint32(p[28:], 400150000)
+	binary.LittleEndian.PutUint32(p[36:], 1655000)
+	binary.LittleEndian.PutUint32(p[60:], 1500)
+	binary.LittleEndian.PutUint16(p[76:], 150)
+	return UBXMessage{Class: UBXClassNAV, ID: UBXNavPVT, Payload: p}
+}
+
+func TestScanUBX(t *testing.T) {
+	good := navPVTFixture().Bytes()
+	bad := CFGMsg(1, 7, 1).Bytes()
+	bad[len(bad)-1]++
+	stream := bytes.Join([][]byte{[]byte("$GPGGA,junk\r\n"), bad, good, {0xb5}, CFGMsg(1, 7, 1).Bytes()}, nil)
+
+	sc := bufio.NewScanner(bytes.NewReader(stream))
+	sc.Split(ScanUBX)
+	var got []UBXMessage
+	for sc.Scan() {
+		m, err := DecodeUBX(sc.Bytes())
+		if err != nil {
+			t.Error("Unable to decode a scanned frame", err)
+		}
+		got = append(got, m)
+	}
+	if len(got) != 2 || got[0].ID != UBXNavPVT || got[1].Class != UBXClassCFG {
+		t.Fatal("Expected NAV-PVT and CFG-MSG, got", got)
+	}
+
+	pvt, err := ParseNAVPVT(got[0])
+	if err != nil {
+		t.Fatal("Unable to parse NAV-PVT", err)
+	}
+	if !pvt.Time.Equal(time.Date(2020, 2, 29, 13, 14, 15, 500, time.UTC)) || !pvt.ValidDate || !pvt.FixOK ||
+		pvt.FixType != 3 || pvt.Satellites != 12 || !near(pvt.Longitude, -105) || !near(pvt.Latitude, 40.015) ||
+		pvt.HeightMSL != 1655 || pvt.GroundSpeed != 1.5 || pvt.PDOP != 1.5 || pvt.ITOW != 123456*time.Second {
+		t.Errorf("Unexpected NAV-PVT %+v", pvt)
+	}
+	if _, err := ParseNAVPVT(got[1]); err == nil {
+		t.Error("Expected CFG-MSG not to parse as NAV-PVT")
+	}
+	if _, err := DecodeUBX(bad); err == nil {
+		t.Error("Expected a bad checksum to be refused")
+	}
+}
+
+func TestCFGValSet(t *testing.T) {
+	m, err := CFGValSet(UBXLayerRAM|UBXLayerFlash, UBXConfigValue{Key: 0x40520001, Value: 115200}, UBXConfigValue{Key: 0x10740001, Value: 1})
+	if err != nil || !bytes.Equal(m.Payload, []byte{0, 5, 0, 0, 1, 0, 0x52, 0x40, 0x00, 0xc2, 0x01, 0x00, 1, 0, 0x74, 0x10, 1}) {
+		t.Errorf("Unexpected CFG-VALSET % x %v", m.Payload, err)
+	}
+	if _, err := CFGValSet(UBXLayerRAM, UBXConfigValue{Key: 0x20000000, Value: 256}); err == nil {
+		t.Error("Expected an oversized value to be refused")
+	}
+}
+
+/*ubxAckHandler acknowledges every UBX frame received, refusing CFG-CFG*/
+func ubxAckHandler(t *testing.T, con net.Conn) {
+	defer con.Close()
+	sc := bufio.NewScanner(con)
+	sc.Split(ScanUBX)
+	for sc.Scan() {
+		m, _ := DecodeUBX(sc.Bytes())
+		id := byte(UBXAckAck)
+		if m.Class == UBXClassCFG && m.ID == UBXCfgCfg {
+			id = UBXAckNak
+		}
+		con.Write(UBXMessage{Class: UBXClassACK, ID: id, Payload: []byte{m.Class, m.ID}}.Bytes())
+	}
+}
+
+func TestUBXCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, srvdial, dial := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, ubxAckHandler)
+	a, err := NewArbiter(ctx, 100*time.Millisecond, dial)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer a.Close()
+
+	valset, _ := CFGValSet(UBXLayerRAM, UBXConfigValue{Key: 0x40520001, Value: 0x25})
+	for _, m := range []UBXMessage{CFGMsg(UBXClassNAV, UBXNavPVT, 1), CFGRate(200*time.Millisecond, 1, 0), CFGPrtUART(1, 115200, UBXProtoUBX, UBXProtoUBX|UBXProtoNMEA), valset} {
+		if rsp := a.Control(UBXCommand("cfg", m, 500*time.Millisecond)); rsp.Error != nil {
+			t.Error("Expected an ACK for", m, rsp.Error)
+		}
+	}
+	if rsp := a.Control(UBXCommand("save", CFGCfg(0, 0xffff, 0), 500*time.Millisecond)); rsp.Error == nil {
+		t.Error("Expected a NAK for CFG-CFG")
+	} else if ack, err := ParseUBXAck(mustDecodeUBX(t, rsp.Bytes)); err != nil || ack.Ack || ack.ID != UBXCfgCfg {
+		t.Error("Unexpected NAK", ack, err)
+	}
+}
+
+func mustDecodeUBX(t *testing.T, b []byte) UBXMessage {
+	t.Helper()
+	_, tok, _ := ScanUBX(b, true)
+	m, err := DecodeUBX(tok)
+	if err != nil {
+		t.Error("Unable to decode", err)
+	}
+	return m
+}
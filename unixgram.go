@@ -0,0 +1,268 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_         IDoIO  = &UnixgramClient{}
+	unixgramN uint64 //distinguishes generated local socket names
+)
+
+/*
+NewUnixgramClient opens a unix domain datagram socket to a local peer. dial
+should be in the form of: 'unixgram://<path>[?local=<path>]'
+
+Unlike a stream, a peer can only reply to a datagram socket that is bound to
+an address of its own.  If local is not given, a socket is bound in
+os.TempDir() and removed again by Close.  A path starting with '@' names a
+socket in the (linux only) abstract namespace.
+
+timeout is used when reading and writing as for a NetClient.
+*/
+func NewUnixgramClient(ctx context.Context, timeout time.Duration, dial string) (*UnixgramClient, error) {
+	d, err := dialFor(dial, "unixgram")
+	if err == nil && d.Device == "" {
+		err = newErr(false, false, fmt.Errorf("dial string not in correct form"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k := range d.Params {
+		if k != "local" {
+			return nil, newErr(false, false, fmt.Errorf("unknown parameter %q in %q", k, dial))
+		}
+	}
+	uctx, cancel := context.WithCancel(ctx)
+	uc := &UnixgramClient{
+		dial:      dial,
+		remote:    d.Device,
+		local:     d.Params.Get("local"),
+		rwtimeout: 1 * time.Millisecond,
+		ctx:       uctx,
+		cancel:    cancel,
+	}
+	if uc.local == "" {
+		uc.local = filepath.Join(os.TempDir(), fmt.Sprintf("agnoio-%d-%d.sock", os.Getpid(), atomic.AddUint64(&unixgramN, 1)))
+		uc.owned = true
+	}
+	uc.instrument(ctx, dial, uc.logger)
+	return uc, openUnlessLazy(ctx, uc)
+}
+
+/*
+UnixgramClient provides an implementer of the IDoIO interface for message
+based local IPC, under the URI regime:
+
+	unixgram://
+
+Each Write is sent as a single datagram, and each Read returns (at most) a
+single datagram, so message boundaries are preserved in both directions. A
+datagram larger than the buffer handed to Read is truncated, and the Read
+returns io.ErrShortBuffer (as a temporary error) along with what did fit.
+*/
+type UnixgramClient struct {
+	connStats
+
+	dial          string
+	remote, local string
+	owned         bool //local was generated, and so is removed on close
+	cancel        context.CancelFunc
+	ctx           context.Context
+	rwtimeout     time.Duration
+	conn          *net.UnixConn
+	buf           []byte //datagrams are read here first
+	log           Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this UnixgramClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (uc *UnixgramClient) SetLogger(l Logger) {
+	uc.log = l
+}
+
+func (uc *UnixgramClient) dialString() string { return uc.dial }
+
+func (uc *UnixgramClient) logger() Logger {
+	if uc.log != nil {
+		return uc.log
+	}
+	return LoggerFrom(uc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (uc *UnixgramClient) String() string {
+	return fmt.Sprintf("unixgram connection to %v from %v", uc.remote, uc.local)
+}
+
+/*
+Open forcibly closes (ignoring errors) any existing socket, and binds and
+connects a new one.
+*/
+func (uc *UnixgramClient) Open() (err error) {
+	uc.opening()
+	defer func() { uc.opened(err) }()
+	select {
+	case <-uc.ctx.Done():
+		return newErr(false, false, uc.ctx.Err())
+	default:
+	}
+	uc.disconnect()
+	if uc.owned {
+		os.Remove(uc.local)
+	}
+	laddr := &net.UnixAddr{Name: uc.local, Net: "unixgram"}
+	raddr := &net.UnixAddr{Name: uc.remote, Net: "unixgram"}
+	//Errors from DialUnix implement net.Error
+	if uc.conn, err = net.DialUnix("unixgram", laddr, raddr); err != nil {
+		uc.conn = nil
+		uc.logger().Warn("unable to open connection", "event", EventError, "dial", uc.dial, "error", err)
+		return
+	}
+	if uc.tracing(TraceOpen) {
+		uc.logger().Debug("connection opened", "event", EventConnect, "dial", uc.dial)
+	}
+	return
+}
+
+/*
+Read conforms to io.Reader, returning a single datagram, but immediately
+returns upon ctx destruction after closing the underlying transport
+*/
+func (uc *UnixgramClient) Read(b []byte) (n int, err error) {
+	defer func() { uc.read(n, err) }()
+	select {
+	case <-uc.ctx.Done():
+		defer uc.Close()
+		return 0, newErr(false, false, uc.ctx.Err())
+	default:
+	}
+	if uc.conn == nil {
+		return 0, readErr
+	}
+	if uc.rwtimeout > 0 {
+		uc.conn.SetReadDeadline(time.Now().Add(uc.rwtimeout))
+	}
+	//read into one byte more than b can hold, to notice truncated datagrams
+	if cap(uc.buf) < len(b)+1 {
+		uc.buf = make([]byte, len(b)+1)
+	}
+	n, err = uc.conn.Read(uc.buf[:len(b)+1])
+	uc.logErr("read", err)
+	if n > len(b) {
+		return copy(b, uc.buf[:n]), newErr(true, false, io.ErrShortBuffer)
+	}
+	return copy(b, uc.buf[:n]), err
+}
+
+/*
+Write conforms to io.Writer, sending b as a single datagram, but immediately
+returns upon ctx destruction after closing the underlying transport
+*/
+func (uc *UnixgramClient) Write(b []byte) (n int, err error) {
+	defer func() { uc.wrote(n, err) }()
+	select {
+	case <-uc.ctx.Done():
+		defer uc.Close()
+		return 0, newErr(false, false, uc.ctx.Err())
+	default:
+	}
+	if uc.conn == nil {
+		return 0, writeErr
+	}
+	if uc.rwtimeout > 0 {
+		uc.conn.SetWriteDeadline(time.Now().Add(uc.rwtimeout))
+	}
+	n, err = uc.conn.Write(b)
+	uc.logErr("write", err)
+	return n, err
+}
+
+/*
+ReadMessage conforms to Messenger, returning a single datagram.  A datagram
+larger than 64KiB is truncated as for Read.
+*/
+func (uc *UnixgramClient) ReadMessage() ([]byte, error) {
+	return readMessage(uc)
+}
+
+/*WriteMessage conforms to Messenger, sending b as a single datagram*/
+func (uc *UnixgramClient) WriteMessage(b []byte) error {
+	return writeMessage(uc, b)
+}
+
+/*Redial conforms to Redialer.  A generated local socket file is generated afresh.*/
+func (uc *UnixgramClient) Redial(ctx context.Context) (IDoIO, error) {
+	u, err := NewUnixgramClient(WithLazyOpen(ctx), 0, uc.dial)
+	if err != nil {
+		return nil, err
+	}
+	u.log, u.hooks, u.trace = uc.log, uc.currentHooks(), uc.trace
+	return u, nil
+}
+
+/*
+Close conforms to io.Closer, closing the socket and removing the local socket
+file if it was generated
+*/
+func (uc *UnixgramClient) Close() error {
+	uc.cancel()
+	return uc.disconnect()
+}
+
+func (uc *UnixgramClient) disconnect() error {
+	if uc.conn == nil {
+		return nil
+	}
+	uc.closed()
+	if uc.tracing(TraceClose) {
+		uc.logger().Debug("connection closed", "event", EventDisconnect, "dial", uc.dial)
+	}
+	err := uc.conn.Close()
+	uc.conn = nil
+	if uc.owned {
+		os.Remove(uc.local)
+	}
+	return err
+}
+
+/*logErr logs non-temporary errors from op*/
+func (uc *UnixgramClient) logErr(op string, err error) {
+	if err != nil && !IsTemporary(err) {
+		uc.logger().Debug(op+" failed", "event", EventError, "dial", uc.dial, "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*newUnixgramSvr echoes every datagram received on path back to its sender, prefixed with "Rxd:"*/
+func newUnixgramSvr(ctx context.Context, t *testing.T, path string) {
+	t.Helper()
+	svr, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal("Unable to start server", err)
+	}
+	go func() {
+		<-ctx.Done()
+		svr.Close()
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := svr.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+			svr.WriteToUnix(append([]byte("Rxd:"), buf[:n]...), from)
+		}
+	}()
+}
+
+func TestUnixgramClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "svr.sock")
+	newUnixgramSvr(ctx, t, path)
+
+	if _, err := NewUnixgramClient(ctx, time.Second, "unixgram://"); err == nil {
+		t.Error("Expected an error for a bad dial string")
+	}
+	idoio, err := NewIDoIO(ctx, time.Second, "unixgram://"+path)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	uc := idoio.(*UnixgramClient)
+	if _, err := os.Stat(uc.local); err != nil {
+		t.Error("Expected a generated local socket", err)
+	}
+	_ = uc.String()
+
+	for _, msg := range []string{"one", "two"} {
+		if n, err := uc.Write([]byte(msg)); err != nil || n != len(msg) {
+			t.Fatal("Unable to write", n, err)
+		}
+	}
+	<-time.After(20 * time.Millisecond)
+	buf := make([]byte, 64)
+	for _, want := range []string{"Rxd:one", "Rxd:two"} {
+		n, err := uc.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Errorf("Expected one datagram %q per read, got %q %v", want, buf[:n], err)
+		}
+	}
+	if _, err := uc.Read(buf); err == nil || !IsTimeout(err) {
+		t.Error("Expected a timeout with nothing to read", err)
+	}
+
+	uc.Write([]byte("a longer message"))
+	<-time.After(20 * time.Millisecond)
+	n, err := uc.Read(buf[:4])
+	if ne, ok := err.(*neterror); string(buf[:n]) != "Rxd:" || !ok || !ne.Temporary() || ne.err != io.ErrShortBuffer {
+		t.Error("Expected a truncated datagram to be reported", n, err)
+	}
+
+	local := uc.local
+	if err := uc.Close(); err != nil {
+		t.Error("Unable to close", err)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Error("Expected the generated local socket to be removed", err)
+	}
+	if _, err := uc.Write([]byte("x")); err == nil {
+		t.Error("Expected writes to fail once closed")
+	}
+
+	mine := filepath.Join(dir, "mine.sock")
+	uc, err = NewUnixgramClient(ctx, time.Second, fmt.Sprintf("unixgram://%s?local=%s", path, mine))
+	if err != nil || uc.local != mine {
+		t.Fatal("Unable to dial with a local address", err)
+	}
+	defer uc.Close()
+	uc.Write([]byte("hi"))
+	<-time.After(20 * time.Millisecond)
+	if n, err := uc.Read(buf); err != nil || string(buf[:n]) != "Rxd:hi" {
+		t.Error("Expected a reply to the given local address", string(buf[:n]), err)
+	}
+}
+
+func TestUnixgramClient_Messenger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "svr.sock")
+	newUnixgramSvr(ctx, t, path)
+	uc, err := NewUnixgramClient(ctx, time.Second, "unixgram://"+path)
+	if err != nil {
+		t.Fatal("Unable to dial", err)
+	}
+	defer uc.Close()
+	for _, msg := range []string{"one", "two"} {
+		if err := uc.WriteMessage([]byte(msg)); err != nil {
+			t.Fatal("Unable to write", err)
+		}
+	}
+	<-time.After(20 * time.Millisecond)
+	for _, want := range []string{"Rxd:one", "Rxd:two"} {
+		if got, err := uc.ReadMessage(); err != nil || string(got) != want {
+			t.Errorf("Expected one datagram %q per read, got %q %v", want, got, err)
+		}
+	}
+}
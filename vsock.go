@@ -0,0 +1,208 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ IDoIO = &VsockClient{}
+
+/*VsockHostCID is the context identifier of the host, as seen from a guest VM*/
+const VsockHostCID = 2
+
+/*deadlineConn is a stream with deadlines, as both *os.File and net.Conn are*/
+type deadlineConn interface {
+	io.ReadWriteCloser
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+/*
+VsockClient provides an implementer of the IDoIO interface for virtio
+sockets between a VM and its hypervisor, as used by instrument bridges
+running in guests, under the URI regime:
+
+	vsock://
+
+It is only supported on linux.
+*/
+type VsockClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	timeout   time.Duration
+	rwtimeout time.Duration
+	dial      string
+	cid, port uint32
+	open      func(cid, port uint32, timeout time.Duration) (deadlineConn, error)
+	conn      deadlineConn
+	log       Logger //nil means LoggerFrom(ctx)
+}
+
+/*
+NewVsockClient connects a vsock stream.  Dial should be in the form of
+"vsock://<cid>:<port>", eg "vsock://3:5000", where cid may be "host" for
+VsockHostCID.  Timeout limits connecting.
+*/
+func NewVsockClient(ctx context.Context, timeout time.Duration, dial string) (*VsockClient, error) {
+	d, err := dialFor(dial, "vsock")
+	if err != nil {
+		return nil, err
+	}
+	if err := noParams(d); err != nil {
+		return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+	}
+	host, p, _ := net.SplitHostPort(d.Host)
+	if host == "host" {
+		host = strconv.Itoa(VsockHostCID)
+	}
+	cid, err := strconv.ParseUint(host, 10, 32)
+	port, perr := strconv.ParseUint(p, 10, 32)
+	if err != nil || perr != nil {
+		return nil, newErr(false, false, fmt.Errorf("invalid vsock address in %q", dial))
+	}
+	vc := newVsockClient(ctx, timeout, uint32(cid), uint32(port), dialVsock)
+	vc.dial = dial
+	return vc, openUnlessLazy(ctx, vc)
+}
+
+func newVsockClient(ctx context.Context, timeout time.Duration, cid, port uint32, open func(uint32, uint32, time.Duration) (deadlineConn, error)) *VsockClient {
+	vctx, cancel := context.WithCancel(ctx)
+	return &VsockClient{ctx: vctx, cancel: cancel, timeout: timeout, rwtimeout: 1 * time.Millisecond, cid: cid, port: port, open: open}
+}
+
+/*
+SetLogger overrides the Logger carried by the context this VsockClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (vc *VsockClient) SetLogger(l Logger) {
+	vc.log = l
+}
+
+func (vc *VsockClient) dialString() string { return vc.dial }
+
+func (vc *VsockClient) logger() Logger {
+	if vc.log != nil {
+		return vc.log
+	}
+	return LoggerFrom(vc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (vc *VsockClient) String() string {
+	return fmt.Sprintf("vsock connection to %d:%d", vc.cid, vc.port)
+}
+
+/*Open forcibly closes (ignoring errors) the stream and connects again*/
+func (vc *VsockClient) Open() (err error) {
+	select {
+	case <-vc.ctx.Done():
+		return newErr(false, false, vc.ctx.Err())
+	default:
+	}
+	if vc.conn != nil {
+		vc.conn.Close()
+		vc.conn = nil
+	}
+	if vc.conn, err = vc.open(vc.cid, vc.port, vc.timeout); err != nil {
+		vc.conn = nil
+		vc.logger().Warn("unable to open connection", "event", EventError, "dial", vc.dial, "error", err)
+		return newErr(false, os.IsTimeout(err), errors.Wrapf(err, "unable to connect to vsock %d:%d", vc.cid, vc.port))
+	}
+	vc.logger().Debug("connection opened", "event", EventConnect, "dial", vc.dial)
+	return nil
+}
+
+/*Read conforms to io.Reader*/
+func (vc *VsockClient) Read(b []byte) (int, error) {
+	select {
+	case <-vc.ctx.Done():
+		defer vc.Close()
+		return 0, newErr(false, false, vc.ctx.Err())
+	default:
+	}
+	if vc.conn == nil {
+		return 0, readErr
+	}
+	if vc.rwtimeout > 0 {
+		vc.conn.SetReadDeadline(time.Now().Add(vc.rwtimeout))
+	}
+	n, err := vc.conn.Read(b)
+	return n, vc.connErr("read", err)
+}
+
+/*Write conforms to io.Writer*/
+func (vc *VsockClient) Write(b []byte) (int, error) {
+	select {
+	case <-vc.ctx.Done():
+		defer vc.Close()
+		return 0, newErr(false, false, vc.ctx.Err())
+	default:
+	}
+	if vc.conn == nil {
+		return 0, writeErr
+	}
+	if vc.rwtimeout > 0 {
+		vc.conn.SetWriteDeadline(time.Now().Add(vc.rwtimeout))
+	}
+	n, err := vc.conn.Write(b)
+	return n, vc.connErr("write", err)
+}
+
+/*Close conforms to io.Closer*/
+func (vc *VsockClient) Close() error {
+	vc.cancel()
+	if vc.conn == nil {
+		return nil
+	}
+	vc.logger().Debug("connection closed", "event", EventDisconnect, "dial", vc.dial)
+	err := vc.conn.Close()
+	vc.conn = nil
+	if err != nil {
+		return newErr(false, false, err)
+	}
+	return nil
+}
+
+/*connErr makes err conform to net.Error, logging it unless it is a deadline passing*/
+func (vc *VsockClient) connErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case os.IsTimeout(err):
+		return newErr(true, true, err)
+	}
+	vc.logger().Debug(op+" failed", "event", EventError, "dial", vc.dial, "error", err)
+	return newErr(false, false, err)
+}
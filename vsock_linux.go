@@ -0,0 +1,100 @@
+//go:build linux && !386
+
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const afVsock = 40 //AF_VSOCK from linux/socket.h
+
+/*sockaddrVM is struct sockaddr_vm from linux/vm_sockets.h*/
+type sockaddrVM struct {
+	family   uint16
+	reserved uint16
+	port     uint32
+	cid      uint32
+	flags    uint8
+	zero     [3]uint8
+}
+
+/*dialVsock connects a vsock stream, returned as a pollable file so deadlines work*/
+func dialVsock(cid, port uint32, timeout time.Duration) (deadlineConn, error) {
+	fd, err := syscall.Socket(afVsock, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := sockaddrVM{family: afVsock, port: port, cid: cid}
+	_, _, errno := syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+	if errno != 0 && errno != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("connect", errno)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("vsock:%d:%d", cid, port))
+	if errno == syscall.EINPROGRESS {
+		if err = awaitConnect(f, timeout); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+/*awaitConnect waits for the non-blocking connect of f to complete, returning its outcome*/
+func awaitConnect(f *os.File, timeout time.Duration) error {
+	if timeout > 0 {
+		f.SetWriteDeadline(time.Now().Add(timeout))
+		defer f.SetWriteDeadline(time.Time{})
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	waited := false
+	var cerr error
+	if err = rc.Write(func(fd uintptr) bool {
+		if !waited { //writable means connected (or failed)
+			waited = true
+			return false
+		}
+		soerr, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err == nil && soerr != 0 {
+			err = syscall.Errno(soerr)
+		}
+		if err != nil {
+			cerr = os.NewSyscallError("connect", err)
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	return cerr
+}
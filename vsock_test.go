@@ -0,0 +1,78 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestVsockClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dial := range []string{"vsock://3", "vsock://guest:5000", "vsock://3:99999999999", "vsock://99999999999:1"} {
+		if _, err := NewVsockClient(ctx, time.Second, dial); err == nil {
+			t.Error("Expected an error for", dial)
+		}
+	}
+	//Either vsock is unsupported, or there is nothing listening on the host
+	if _, err := NewIDoIO(ctx, 100*time.Millisecond, "vsock://host:1"); err == nil || IsTemporary(err) {
+		t.Error("Expected a permanent error connecting to nothing", err)
+	}
+
+	var gotCID, gotPort uint32
+	vc := newVsockClient(ctx, time.Second, VsockHostCID, 5000, func(cid, port uint32, timeout time.Duration) (deadlineConn, error) {
+		gotCID, gotPort = cid, port
+		near, far := net.Pipe()
+		go arbHandler(t, far)
+		return near, nil
+	})
+	if err := vc.Open(); err != nil {
+		t.Fatal("Unable to open", err)
+	}
+	defer vc.Close()
+	_ = vc.String()
+	if gotCID != VsockHostCID || gotPort != 5000 {
+		t.Error("Expected the host and port to be dialed", gotCID, gotPort)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := vc.Read(buf); err == nil || !IsTimeout(err) || !IsTemporary(err) {
+		t.Error("Expected a temporary timeout with nothing to read", err)
+	}
+	a, stop := Arbitrate(ctx, vc)
+	defer stop()
+	if rsp := a.Control(arbCmdOk); rsp.Error != nil {
+		t.Error("Expected a response", rsp.Error)
+	}
+	stop()
+
+	vc.Close()
+	if _, err := vc.Write([]byte("x")); err == nil {
+		t.Error("Expected an error writing after close")
+	}
+}
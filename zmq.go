@@ -0,0 +1,427 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ IDoIO = &ZMQClient{}
+
+	//zmqPeers lists the socket types each supported type may talk to
+	zmqPeers = map[string][]string{
+		"PAIR":   {"PAIR"},
+		"REQ":    {"REP", "ROUTER"},
+		"DEALER": {"REP", "DEALER", "ROUTER"},
+	}
+)
+
+// ZMTP 3.0 framing (see rfc.zeromq.org/spec/23)
+const (
+	zmtpMore    = 0x01
+	zmtpLong    = 0x02
+	zmtpCommand = 0x04
+)
+
+/*
+ZMQClient provides an implementer of the IDoIO interface that speaks ZMTP 3.0,
+the ZeroMQ wire protocol, over tcp to a peer ZeroMQ socket, under the URI
+regime:
+
+	zmq://
+
+The socket type (PAIR, REQ, or DEALER) is chosen with the type parameter, and
+only the NULL security mechanism is supported.  Each Write is sent as a single
+message; a REQ socket adds the empty delimiter frame a REP or ROUTER peer
+expects, and strips it from replies.  Reads return the frames of each message
+received concatenated, never mixing two messages in one Read.  Unlike
+libzmq, a REQ ZMQClient does not enforce strict send/receive alternation, so a
+Command that timed out does not wedge the socket.
+*/
+type ZMQClient struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	dial      string
+	address   string
+	sockType  string
+	identity  string
+	timeout   time.Duration
+	rwtimeout time.Duration
+	conn      net.Conn
+	raw       []byte   //received but not yet parsed
+	frames    [][]byte //frames of a partially received message
+	msgs      [][]byte //received messages not yet (completely) read
+	log       Logger   //nil means LoggerFrom(ctx)
+}
+
+/*
+NewZMQClient connects to a ZeroMQ socket.  Dial should be in the form of
+"zmq://<host>:<port>[?type=<pair|req|dealer>&identity=<id>]", the type
+defaulting to pair.  Timeout bounds connecting and the ZMTP handshake.
+*/
+func NewZMQClient(ctx context.Context, timeout time.Duration, dial string) (*ZMQClient, error) {
+	d, err := dialFor(dial, "zmq")
+	if err != nil {
+		return nil, err
+	}
+	zc := &ZMQClient{dial: dial, address: d.Host, sockType: "PAIR", timeout: timeout, rwtimeout: 1 * time.Millisecond}
+	for k, v := range d.Params {
+		switch k {
+		case "type":
+			zc.sockType = strings.ToUpper(v[0])
+			if _, ok := zmqPeers[zc.sockType]; !ok {
+				err = fmt.Errorf("unsupported socket type %q", v[0])
+			}
+		case "identity":
+			zc.identity = v[0]
+		default:
+			err = fmt.Errorf("unknown parameter %q", k)
+		}
+		if err != nil {
+			return nil, newErr(false, false, errors.Wrapf(err, "invalid parameters in %q", dial))
+		}
+	}
+	zc.ctx, zc.cancel = context.WithCancel(ctx)
+	return zc, openUnlessLazy(ctx, zc)
+}
+
+/*
+SetLogger overrides the Logger carried by the context this ZMQClient was
+constructed with (see WithLogger).  It is not safe to call concurrently with
+other methods.
+*/
+func (zc *ZMQClient) SetLogger(l Logger) {
+	zc.log = l
+}
+
+func (zc *ZMQClient) dialString() string { return zc.dial }
+
+func (zc *ZMQClient) logger() Logger {
+	if zc.log != nil {
+		return zc.log
+	}
+	return LoggerFrom(zc.ctx)
+}
+
+/*String conforms to the fmt.Stringer interface*/
+func (zc *ZMQClient) String() string {
+	return fmt.Sprintf("zmq %v connection to %v", zc.sockType, zc.address)
+}
+
+/*Open forcibly closes (ignoring errors) any existing connection, then reconnects and handshakes*/
+func (zc *ZMQClient) Open() (err error) {
+	select {
+	case <-zc.ctx.Done():
+		return newErr(false, false, zc.ctx.Err())
+	default:
+	}
+	if zc.conn != nil {
+		zc.conn.Close()
+		zc.conn = nil
+	}
+	zc.raw, zc.frames, zc.msgs = nil, nil, nil
+	dialer := net.Dialer{Timeout: zc.timeout}
+	conn, err := dialer.DialContext(zc.ctx, "tcp", zc.address)
+	if err != nil {
+		zc.logger().Warn("unable to open connection", "event", EventError, "dial", zc.dial, "error", err)
+		return err
+	}
+	if err = zc.handshake(conn); err != nil {
+		conn.Close()
+		zc.logger().Warn("ZMTP handshake failed", "event", EventError, "dial", zc.dial, "error", err)
+		if _, ok := err.(net.Error); !ok {
+			err = newErr(false, false, err)
+		}
+		return err
+	}
+	zc.conn = conn
+	zc.logger().Debug("connection opened", "event", EventConnect, "dial", zc.dial)
+	return nil
+}
+
+/*handshake exchanges greetings and READY commands with the peer*/
+func (zc *ZMQClient) handshake(conn net.Conn) error {
+	if zc.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(zc.timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	props := map[string]string{"Socket-Type": zc.sockType}
+	if zc.identity != "" {
+		props["Identity"] = zc.identity
+	}
+	if _, err := conn.Write(append(zmtpGreeting(false), zmtpFrame(zmtpCommand, zmtpReady(props))...)); err != nil {
+		return err
+	}
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(conn, peer); err != nil {
+		return err
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f || peer[10] < 3 {
+		return fmt.Errorf("peer does not speak ZMTP 3")
+	}
+	if mech := string(bytes.TrimRight(peer[12:32], "\x00")); mech != "NULL" {
+		return fmt.Errorf("unsupported security mechanism %q", mech)
+	}
+	flags, body, err := zmtpReadFrame(conn)
+	if err != nil {
+		return err
+	}
+	name, props, err := zmtpParseCommand(body)
+	if flags&zmtpCommand == 0 || err != nil {
+		return fmt.Errorf("expected a READY command")
+	}
+	switch name {
+	case "READY":
+	case "ERROR":
+		return fmt.Errorf("peer refused the connection")
+	default:
+		return fmt.Errorf("expected a READY command, got %q", name)
+	}
+	for _, ok := range zmqPeers[zc.sockType] {
+		if props["Socket-Type"] == ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("a %s socket cannot talk to a %s socket", zc.sockType, props["Socket-Type"])
+}
+
+/*Read conforms to io.Reader, returning (part of) the next message received*/
+func (zc *ZMQClient) Read(b []byte) (int, error) {
+	select {
+	case <-zc.ctx.Done():
+		defer zc.Close()
+		return 0, newErr(false, false, zc.ctx.Err())
+	default:
+	}
+	if zc.conn == nil {
+		return 0, readErr
+	}
+	if len(zc.msgs) == 0 {
+		if zc.rwtimeout > 0 {
+			zc.conn.SetReadDeadline(time.Now().Add(zc.rwtimeout))
+		}
+		buf := make([]byte, 4096)
+		n, err := zc.conn.Read(buf)
+		zc.raw = append(zc.raw, buf[:n]...)
+		if perr := zc.parse(); perr != nil {
+			err = perr
+		}
+		if len(zc.msgs) == 0 {
+			if err == nil {
+				err = newErr(true, true, fmt.Errorf("read: message incomplete"))
+			}
+			zc.logErr("read", err)
+			return 0, err
+		}
+	}
+	n := copy(b, zc.msgs[0])
+	if zc.msgs[0] = zc.msgs[0][n:]; len(zc.msgs[0]) == 0 {
+		zc.msgs = zc.msgs[1:]
+	}
+	return n, nil
+}
+
+/*parse moves complete frames from raw into msgs*/
+func (zc *ZMQClient) parse() error {
+	for {
+		flags, body, n := zmtpParseFrame(zc.raw)
+		if n == 0 {
+			return nil
+		}
+		zc.raw = zc.raw[n:]
+		if flags&zmtpCommand != 0 {
+			if name, _, _ := zmtpParseCommand(body); name == "ERROR" {
+				return newErr(false, false, fmt.Errorf("peer reported an error"))
+			}
+			continue //eg PING, which need not be answered
+		}
+		zc.frames = append(zc.frames, body)
+		if flags&zmtpMore != 0 {
+			continue
+		}
+		frames := zc.frames
+		zc.frames = nil
+		if zc.sockType == "REQ" && len(frames) > 0 && len(frames[0]) == 0 {
+			frames = frames[1:]
+		}
+		if msg := bytes.Join(frames, nil); len(msg) > 0 {
+			zc.msgs = append(zc.msgs, msg)
+		}
+	}
+}
+
+/*Write conforms to io.Writer, sending b as a single message*/
+func (zc *ZMQClient) Write(b []byte) (int, error) {
+	select {
+	case <-zc.ctx.Done():
+		defer zc.Close()
+		return 0, newErr(false, false, zc.ctx.Err())
+	default:
+	}
+	if zc.conn == nil {
+		return 0, writeErr
+	}
+	var msg []byte
+	if zc.sockType == "REQ" {
+		msg = zmtpFrame(zmtpMore, nil)
+	}
+	msg = append(msg, zmtpFrame(0, b)...)
+	if zc.timeout > 0 { //a partly written frame would break the framing, so allow more than rwtimeout
+		zc.conn.SetWriteDeadline(time.Now().Add(zc.timeout))
+	}
+	if _, err := zc.conn.Write(msg); err != nil {
+		zc.logErr("write", err)
+		return 0, err
+	}
+	return len(b), nil
+}
+
+/*Close conforms to io.Closer*/
+func (zc *ZMQClient) Close() error {
+	zc.cancel()
+	defer func() { zc.conn = nil }()
+	if zc.conn != nil {
+		zc.logger().Debug("connection closed", "event", EventDisconnect, "dial", zc.dial)
+		return zc.conn.Close()
+	}
+	return nil
+}
+
+/*logErr logs non-temporary errors from op*/
+func (zc *ZMQClient) logErr(op string, err error) {
+	if err != nil && !IsTemporary(err) {
+		zc.logger().Debug(op+" failed", "event", EventError, "dial", zc.dial, "error", err)
+	}
+}
+
+/*zmtpGreeting returns a ZMTP 3.0 greeting for the NULL mechanism*/
+func zmtpGreeting(server bool) []byte {
+	g := make([]byte, 64)
+	g[0], g[9], g[10], g[11] = 0xff, 0x7f, 3, 0
+	copy(g[12:32], "NULL")
+	if server {
+		g[32] = 1
+	}
+	return g
+}
+
+/*zmtpFrame encodes a frame, using the long form only if need be*/
+func zmtpFrame(flags byte, body []byte) []byte {
+	if len(body) > 255 {
+		f := make([]byte, 9, 9+len(body))
+		f[0] = flags | zmtpLong
+		binary.BigEndian.PutUint64(f[1:], uint64(len(body)))
+		return append(f, body...)
+	}
+	return append([]byte{flags, byte(len(body))}, body...)
+}
+
+/*zmtpParseFrame decodes the frame at the start of b, returning n of 0 if it is incomplete*/
+func zmtpParseFrame(b []byte) (flags byte, body []byte, n int) {
+	if len(b) < 2 {
+		return 0, nil, 0
+	}
+	flags, size, hdr := b[0], uint64(b[1]), 2
+	if flags&zmtpLong != 0 {
+		if len(b) < 9 {
+			return 0, nil, 0
+		}
+		size, hdr = binary.BigEndian.Uint64(b[1:9]), 9
+	}
+	if uint64(len(b)-hdr) < size {
+		return 0, nil, 0
+	}
+	return flags, b[hdr : hdr+int(size)], hdr + int(size)
+}
+
+/*zmtpReadFrame reads a single frame from r*/
+func zmtpReadFrame(r io.Reader) (byte, []byte, error) {
+	hdr := make([]byte, 9)
+	if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+		return 0, nil, err
+	}
+	size := uint64(hdr[1])
+	if hdr[0]&zmtpLong != 0 {
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(hdr[1:])
+	}
+	if size > 1<<24 {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too long", size)
+	}
+	body := make([]byte, size)
+	_, err := io.ReadFull(r, body)
+	return hdr[0], body, err
+}
+
+/*zmtpReady encodes the body of a READY command carrying props*/
+func zmtpReady(props map[string]string) []byte {
+	b := append([]byte{5}, "READY"...)
+	for _, k := range []string{"Socket-Type", "Identity"} {
+		if v, ok := props[k]; ok {
+			b = append(b, byte(len(k)))
+			b = append(b, k...)
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+/*zmtpParseCommand decodes the body of a command frame*/
+func zmtpParseCommand(b []byte) (string, map[string]string, error) {
+	bad := fmt.Errorf("malformed command")
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, bad
+	}
+	name, b := string(b[1:1+b[0]]), b[1+b[0]:]
+	props := map[string]string{}
+	if name != "READY" {
+		return name, props, nil
+	}
+	for len(b) > 0 {
+		if len(b) < 1+int(b[0])+4 {
+			return name, props, bad
+		}
+		k, rest := string(b[1:1+b[0]]), b[1+b[0]:]
+		size := binary.BigEndian.Uint32(rest)
+		if uint64(len(rest)-4) < uint64(size) {
+			return name, props, bad
+		}
+		props[k], b = string(rest[4:4+size]), rest[4+size:]
+	}
+	return name, props, nil
+}
@@ -0,0 +1,119 @@
+/*
+MIT License
+
+Copyright (c) 2015-2017 University Corporation for Atmospheric Research
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package agnoio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+/*zmtpHandler returns a peer of socket type typ answering each message with "Rxd>%d"*/
+func zmtpHandler(typ string) respHandler {
+	return func(t *testing.T, con net.Conn) {
+		defer con.Close()
+		con.Write(append(zmtpGreeting(true), zmtpFrame(zmtpCommand, zmtpReady(map[string]string{"Socket-Type": typ}))...))
+		if _, err := io.ReadFull(con, make([]byte, 64)); err != nil {
+			return
+		}
+		if _, _, err := zmtpReadFrame(con); err != nil {
+			return
+		}
+		var frames [][]byte
+		for {
+			flags, body, err := zmtpReadFrame(con)
+			if err != nil {
+				return
+			}
+			if frames = append(frames, body); flags&zmtpMore != 0 {
+				continue
+			}
+			var rsp []byte
+			if typ == "REP" {
+				if len(frames[0]) != 0 {
+					t.Error("Expected an empty delimiter from a REQ socket")
+				}
+				rsp = zmtpFrame(zmtpMore, nil)
+			}
+			rsp = append(rsp, zmtpFrame(zmtpCommand, append([]byte{4}, "PING"...))...)
+			rsp = append(rsp, zmtpFrame(zmtpMore, []byte("Rxd>"))...)
+			rsp = append(rsp, zmtpFrame(0, []byte(fmt.Sprint(len(frames[len(frames)-1]))))...)
+			con.Write(rsp)
+			frames = nil
+		}
+	}
+}
+
+func TestZMTPFrame(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	for _, body := range [][]byte{nil, []byte("short"), long} {
+		f := zmtpFrame(zmtpMore, body)
+		flags, got, n := zmtpParseFrame(f)
+		if n != len(f) || flags&zmtpMore == 0 || !bytes.Equal(got, body) {
+			t.Error("Frame did not survive a round trip", len(body), n, flags)
+		}
+		if _, _, n := zmtpParseFrame(f[:len(f)-1]); len(body) > 0 && n != 0 {
+			t.Error("Expected an incomplete frame to be left alone")
+		}
+	}
+	name, props, err := zmtpParseCommand(zmtpReady(map[string]string{"Socket-Type": "REQ", "Identity": "me"}))
+	if err != nil || name != "READY" || props["Socket-Type"] != "REQ" || props["Identity"] != "me" {
+		t.Error("READY did not survive a round trip", name, props, err)
+	}
+	if _, _, err := zmtpParseCommand([]byte{5, 'R', 'E', 'A', 'D', 'Y', 3, 'a'}); err == nil {
+		t.Error("Expected a malformed command to be rejected")
+	}
+}
+
+func TestZMQClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewZMQClient(ctx, time.Second, "zmq://localhost:1?type=pub"); err == nil {
+		t.Error("Expected an error for an unsupported socket type")
+	}
+
+	for _, tc := range []struct{ mine, peer string }{{"pair", "PAIR"}, {"req", "REP"}, {"dealer", "ROUTER"}} {
+		_, srvdial, _ := freePortCfg()
+		newTCPSvr(ctx, t, "tcp", srvdial, zmtpHandler(tc.peer))
+		a, err := NewArbiter(ctx, time.Second, fmt.Sprintf("zmq://%s?type=%s", srvdial, tc.mine))
+		if err != nil {
+			t.Fatal("Unable to dial", tc.mine, err)
+		}
+		if rsp := a.Control(arbCmdOk); rsp.Error != nil || string(rsp.Bytes) != "Rxd>3" {
+			t.Error("Expected a reply from the", tc.peer, "peer", string(rsp.Bytes), rsp.Error)
+		}
+		a.Close()
+	}
+
+	_, srvdial, _ := freePortCfg()
+	newTCPSvr(ctx, t, "tcp", srvdial, zmtpHandler("PUB"))
+	if _, err := NewIDoIO(ctx, time.Second, "zmq://"+srvdial+"?type=req"); err == nil || IsTemporary(err) {
+		t.Error("Expected an incompatible peer to be refused", err)
+	}
+}